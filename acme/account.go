@@ -4,6 +4,7 @@ import (
 	"crypto"
 	"encoding/base64"
 	"encoding/json"
+	"time"
 
 	"go.step.sm/crypto/jose"
 )
@@ -11,11 +12,14 @@ import (
 // Account is a subset of the internal account type containing only those
 // attributes required for responses in the ACME protocol.
 type Account struct {
-	ID        string           `json:"-"`
-	Key       *jose.JSONWebKey `json:"-"`
-	Contact   []string         `json:"contact,omitempty"`
-	Status    Status           `json:"status"`
-	OrdersURL string           `json:"orders"`
+	ID                     string           `json:"-"`
+	ProvisionerID          string           `json:"-"`
+	CreatedAt              time.Time        `json:"-"`
+	Key                    *jose.JSONWebKey `json:"-"`
+	Contact                []string         `json:"contact,omitempty"`
+	Status                 Status           `json:"status"`
+	OrdersURL              string           `json:"orders"`
+	ExternalAccountBinding interface{}      `json:"externalAccountBinding,omitempty"`
 }
 
 // ToLog enables response logging.
@@ -40,3 +44,36 @@ func KeyToID(jwk *jose.JSONWebKey) (string, error) {
 	}
 	return base64.RawURLEncoding.EncodeToString(kid), nil
 }
+
+// ExternalAccountKey is an ACME External Account Binding key. The key is
+// created by an administrator for a provisioner and it can be used only once
+// to bind a new ACME account.
+type ExternalAccountKey struct {
+	ID            string
+	ProvisionerID string
+	Reference     string
+	AccountID     string
+	KeyBytes      []byte
+	CreatedAt     time.Time
+	BoundAt       time.Time
+}
+
+// AlreadyBound returns true if the External Account Binding key has already
+// been bound to an ACME account.
+func (eak *ExternalAccountKey) AlreadyBound() bool {
+	return !eak.BoundAt.IsZero()
+}
+
+// BindTo binds the External Account Binding key to the given ACME account. A
+// key can only be bound once, and once bound the HMAC key is removed so it
+// cannot be used again.
+func (eak *ExternalAccountKey) BindTo(acc *Account) error {
+	if eak.AlreadyBound() {
+		return NewError(ErrorUnauthorizedType, "external account binding key with id '%s' was already bound to account '%s' on %s",
+			eak.ID, eak.AccountID, eak.BoundAt)
+	}
+	eak.AccountID = acc.ID
+	eak.BoundAt = time.Now().UTC().Truncate(time.Second)
+	eak.KeyBytes = []byte{}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"crypto"
 	"encoding/base64"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
@@ -79,3 +80,43 @@ func TestAccount_IsValid(t *testing.T) {
 		})
 	}
 }
+
+func TestExternalAccountKey_BindTo(t *testing.T) {
+	boundAt := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	type test struct {
+		eak *ExternalAccountKey
+		acc *Account
+		err *Error
+	}
+	tests := map[string]test{
+		"ok": {
+			eak: &ExternalAccountKey{ID: "eakID", KeyBytes: []byte{1, 2, 3}},
+			acc: &Account{ID: "accountID"},
+		},
+		"fail/already-bound": {
+			eak: &ExternalAccountKey{ID: "eakID", AccountID: "otherID", BoundAt: boundAt},
+			acc: &Account{ID: "accountID"},
+			err: NewError(ErrorUnauthorizedType, "external account binding key with id 'eakID' was already bound to account 'otherID' on %s", boundAt),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.eak.BindTo(tc.acc)
+			if tc.err != nil {
+				if assert.NotNil(t, err) {
+					ae, ok := err.(*Error)
+					assert.True(t, ok)
+					assert.Equals(t, ae.Type, tc.err.Type)
+					assert.Equals(t, ae.Err.Error(), tc.err.Err.Error())
+					assert.Equals(t, tc.eak.AccountID, "otherID")
+					assert.Equals(t, tc.eak.BoundAt, boundAt)
+				}
+				return
+			}
+			assert.FatalError(t, err)
+			assert.True(t, tc.eak.AlreadyBound())
+			assert.Equals(t, tc.eak.AccountID, tc.acc.ID)
+			assert.Equals(t, tc.eak.KeyBytes, []byte{})
+		})
+	}
+}
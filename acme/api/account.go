@@ -1,20 +1,32 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/go-chi/chi"
 	"github.com/smallstep/certificates/acme"
 	"github.com/smallstep/certificates/api"
 	"github.com/smallstep/certificates/logging"
+	"go.step.sm/crypto/jose"
 )
 
 // NewAccountRequest represents the payload for a new account request.
 type NewAccountRequest struct {
-	Contact              []string `json:"contact"`
-	OnlyReturnExisting   bool     `json:"onlyReturnExisting"`
-	TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed"`
+	Contact                []string                `json:"contact"`
+	OnlyReturnExisting     bool                    `json:"onlyReturnExisting"`
+	TermsOfServiceAgreed   bool                    `json:"termsOfServiceAgreed"`
+	ExternalAccountBinding *ExternalAccountBinding `json:"externalAccountBinding,omitempty"`
+}
+
+// ExternalAccountBinding represents the flattened JWS sent in the
+// externalAccountBinding field of a new account request.
+type ExternalAccountBinding struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Sig       string `json:"signature"`
 }
 
 func validateContacts(cs []string) error {
@@ -104,16 +116,38 @@ func (h *Handler) NewAccount(w http.ResponseWriter, r *http.Request) {
 			api.WriteError(w, err)
 			return
 		}
+		eak, err := h.validateExternalAccountBinding(ctx, &nar, jwk)
+		if err != nil {
+			api.WriteError(w, err)
+			return
+		}
+		prov, err := provisionerFromContext(ctx)
+		if err != nil {
+			api.WriteError(w, err)
+			return
+		}
+		if err := rateLimits(prov).AccountsPerIP.Take(ctx, h.db, remoteIP(r, prov)); err != nil {
+			api.WriteError(w, err)
+			return
+		}
 
 		acc = &acme.Account{
-			Key:     jwk,
-			Contact: nar.Contact,
-			Status:  acme.StatusValid,
+			ProvisionerID: prov.GetID(),
+			Key:           jwk,
+			Contact:       nar.Contact,
+			Status:        acme.StatusValid,
 		}
 		if err := h.db.CreateAccount(ctx, acc); err != nil {
 			api.WriteError(w, acme.WrapErrorISE(err, "error creating account"))
 			return
 		}
+		if eak != nil {
+			if err := h.bindExternalAccountKey(ctx, eak, acc); err != nil {
+				api.WriteError(w, err)
+				return
+			}
+			acc.ExternalAccountBinding = nar.ExternalAccountBinding
+		}
 	} else {
 		// Account exists //
 		httpStatus = http.StatusOK
@@ -125,6 +159,101 @@ func (h *Handler) NewAccount(w http.ResponseWriter, r *http.Request) {
 	api.JSONStatus(w, acc, httpStatus)
 }
 
+// validateExternalAccountBinding validates the externalAccountBinding of a
+// new account request as described in RFC 8555, section 7.3.4. It returns the
+// External Account Binding key used, or nil if the provisioner does not
+// require external account binding.
+func (h *Handler) validateExternalAccountBinding(ctx context.Context, nar *NewAccountRequest, jwk *jose.JSONWebKey) (*acme.ExternalAccountKey, error) {
+	acmeProv, err := acmeProvisionerFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !acmeProv.RequireEAB {
+		return nil, nil
+	}
+	if nar.ExternalAccountBinding == nil {
+		return nil, acme.NewError(acme.ErrorExternalAccountRequiredType, "no external account binding provided")
+	}
+
+	eabJSON, err := json.Marshal(nar.ExternalAccountBinding)
+	if err != nil {
+		return nil, acme.WrapErrorISE(err, "error marshaling externalAccountBinding")
+	}
+	eabJWS, err := jose.ParseJWS(string(eabJSON))
+	if err != nil {
+		return nil, acme.WrapError(acme.ErrorMalformedType, err, "error parsing externalAccountBinding")
+	}
+	if len(eabJWS.Signatures) != 1 {
+		return nil, acme.NewError(acme.ErrorMalformedType, "externalAccountBinding must contain exactly one signature")
+	}
+
+	hdr := eabJWS.Signatures[0].Protected
+	switch hdr.Algorithm {
+	case jose.HS256, jose.HS384, jose.HS512:
+	default:
+		return nil, acme.NewError(acme.ErrorMalformedType, "unsupported externalAccountBinding algorithm '%s'", hdr.Algorithm)
+	}
+	if hdr.Nonce != "" {
+		return nil, acme.NewError(acme.ErrorMalformedType, "externalAccountBinding must not contain a nonce")
+	}
+	if hdr.KeyID == "" {
+		return nil, acme.NewError(acme.ErrorMalformedType, "externalAccountBinding kid cannot be empty")
+	}
+	if u, ok := hdr.ExtraHeaders["url"].(string); !ok || u != h.linker.GetLink(ctx, NewAccountLinkType) {
+		return nil, acme.NewError(acme.ErrorMalformedType, "externalAccountBinding url header does not match the new account url")
+	}
+
+	eak, err := h.db.GetExternalAccountKey(ctx, acmeProv.GetID(), hdr.KeyID)
+	switch {
+	case errors.Is(err, acme.ErrNotFound):
+		return nil, acme.NewError(acme.ErrorUnauthorizedType, "externalAccountBinding kid '%s' references an unknown key", hdr.KeyID)
+	case err != nil:
+		return nil, acme.WrapErrorISE(err, "error retrieving external account key")
+	}
+	if eak.AlreadyBound() {
+		return nil, acme.NewError(acme.ErrorUnauthorizedType, "external account binding key with id '%s' was already bound to account '%s' on %s",
+			eak.ID, eak.AccountID, eak.BoundAt)
+	}
+
+	payload, err := eabJWS.Verify(eak.KeyBytes)
+	if err != nil {
+		return nil, acme.WrapError(acme.ErrorUnauthorizedType, err, "error verifying externalAccountBinding signature")
+	}
+	var payloadJWK jose.JSONWebKey
+	if err := json.Unmarshal(payload, &payloadJWK); err != nil {
+		return nil, acme.WrapError(acme.ErrorMalformedType, err, "error unmarshaling externalAccountBinding payload")
+	}
+	payloadKid, err := acme.KeyToID(&payloadJWK)
+	if err != nil {
+		return nil, acme.WrapError(acme.ErrorMalformedType, err, "invalid jwk in externalAccountBinding payload")
+	}
+	accountKid, err := acme.KeyToID(jwk)
+	if err != nil {
+		return nil, err
+	}
+	if payloadKid != accountKid {
+		return nil, acme.NewError(acme.ErrorUnauthorizedType, "externalAccountBinding payload does not match the account key")
+	}
+	return eak, nil
+}
+
+// bindExternalAccountKey binds the External Account Binding key to the new
+// account. If the key cannot be bound, because it has been used concurrently
+// by another request, the new account is deactivated.
+func (h *Handler) bindExternalAccountKey(ctx context.Context, eak *acme.ExternalAccountKey, acc *acme.Account) error {
+	if err := eak.BindTo(acc); err != nil {
+		return err
+	}
+	if err := h.db.UpdateExternalAccountKey(ctx, eak.ProvisionerID, eak); err != nil {
+		acc.Status = acme.StatusDeactivated
+		if uerr := h.db.UpdateAccount(ctx, acc); uerr != nil {
+			return acme.WrapErrorISE(uerr, "error deactivating account %s", acc.ID)
+		}
+		return acme.WrapErrorISE(err, "error binding external account key %s to account %s", eak.ID, acc.ID)
+	}
+	return nil
+}
+
 // GetOrUpdateAccount is the api for updating an ACME account.
 func (h *Handler) GetOrUpdateAccount(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
@@ -172,6 +301,145 @@ func (h *Handler) GetOrUpdateAccount(w http.ResponseWriter, r *http.Request) {
 	api.JSON(w, acc)
 }
 
+// KeyChangeRequest represents the payload of the inner JWS of a key-change
+// request.
+type KeyChangeRequest struct {
+	Account string           `json:"account"`
+	OldKey  *jose.JSONWebKey `json:"oldKey"`
+}
+
+// Validate validates a key-change request body.
+func (k *KeyChangeRequest) Validate() error {
+	switch {
+	case k.Account == "":
+		return acme.NewError(acme.ErrorMalformedType, "account cannot be empty")
+	case k.OldKey == nil:
+		return acme.NewError(acme.ErrorMalformedType, "oldKey cannot be empty")
+	case !k.OldKey.Valid():
+		return acme.NewError(acme.ErrorMalformedType, "invalid oldKey")
+	default:
+		return nil
+	}
+}
+
+// KeyChange is the api for rolling over the key of an ACME account as
+// described in RFC 8555, section 7.3.5. The payload of the request, signed by
+// the current account key, is a JWS signed by the new key.
+func (h *Handler) KeyChange(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	acc, err := accountFromContext(ctx)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	jws, err := jwsFromContext(ctx)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	payload, err := payloadFromContext(ctx)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+
+	innerJWS, err := jose.ParseJWS(string(payload.value))
+	if err != nil {
+		api.WriteError(w, acme.WrapError(acme.ErrorMalformedType, err, "failed to parse inner JWS"))
+		return
+	}
+	if len(innerJWS.Signatures) != 1 {
+		api.WriteError(w, acme.NewError(acme.ErrorMalformedType, "inner JWS must contain exactly one signature"))
+		return
+	}
+	hdr := innerJWS.Signatures[0].Protected
+	newKey := hdr.JSONWebKey
+	switch {
+	case newKey == nil:
+		api.WriteError(w, acme.NewError(acme.ErrorMalformedType, "inner JWS must contain a jwk"))
+		return
+	case !newKey.Valid():
+		api.WriteError(w, acme.NewError(acme.ErrorMalformedType, "invalid jwk in inner JWS"))
+		return
+	case len(hdr.Nonce) > 0:
+		api.WriteError(w, acme.NewError(acme.ErrorMalformedType, "inner JWS must not contain a nonce"))
+		return
+	case hdr.ExtraHeaders["url"] != jws.Signatures[0].Protected.ExtraHeaders["url"]:
+		api.WriteError(w, acme.NewError(acme.ErrorMalformedType, "url header in inner JWS does not match outer JWS"))
+		return
+	}
+	if acmeErr := validateJWSAlgorithm(hdr); acmeErr != nil {
+		api.WriteError(w, acmeErr)
+		return
+	}
+	innerPayload, err := innerJWS.Verify(newKey)
+	if err != nil {
+		api.WriteError(w, acme.WrapError(acme.ErrorMalformedType, err, "error verifying inner JWS"))
+		return
+	}
+
+	var kcr KeyChangeRequest
+	if err := json.Unmarshal(innerPayload, &kcr); err != nil {
+		api.WriteError(w, acme.WrapError(acme.ErrorMalformedType, err,
+			"failed to unmarshal key-change request payload"))
+		return
+	}
+	if err := kcr.Validate(); err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	if accURL := h.linker.GetLink(ctx, AccountLinkType, acc.ID); kcr.Account != accURL {
+		api.WriteError(w, acme.NewError(acme.ErrorMalformedType,
+			"account '%s' in key-change request does not match '%s'", kcr.Account, accURL))
+		return
+	}
+	oldKid, err := acme.KeyToID(kcr.OldKey)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	accKid, err := acme.KeyToID(acc.Key)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	if oldKid != accKid {
+		api.WriteError(w, acme.NewError(acme.ErrorMalformedType, "oldKey does not match the account key"))
+		return
+	}
+
+	// The new key must not be used by any other account.
+	newKid, err := acme.KeyToID(newKey)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	existing, err := h.db.GetAccountByKeyID(ctx, newKid)
+	switch {
+	case err == nil:
+		w.Header().Set("Location", h.linker.GetLink(ctx, AccountLinkType, existing.ID))
+		acmeErr := acme.NewError(acme.ErrorMalformedType, "new key is already in use by account '%s'", existing.ID)
+		acmeErr.Status = http.StatusConflict
+		api.WriteError(w, acmeErr)
+		return
+	case !errors.Is(err, acme.ErrNotFound):
+		api.WriteError(w, acme.WrapErrorISE(err, "error retrieving account by key"))
+		return
+	}
+
+	newKey.KeyID = newKid
+	if err := h.db.UpdateAccountKey(ctx, acc, newKey); err != nil {
+		api.WriteError(w, acme.WrapErrorISE(err, "error updating account key"))
+		return
+	}
+	acc.Key = newKey
+
+	h.linker.LinkAccount(ctx, acc)
+
+	w.Header().Set("Location", h.linker.GetLink(ctx, AccountLinkType, acc.ID))
+	api.JSON(w, acc)
+}
+
 func logOrdersByAccount(w http.ResponseWriter, oids []string) {
 	if rl, ok := w.(logging.ResponseLogger); ok {
 		m := map[string]interface{}{
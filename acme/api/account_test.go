@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
 	"github.com/smallstep/certificates/acme"
 	"github.com/smallstep/certificates/authority/provisioner"
@@ -286,10 +287,42 @@ func TestHandler_GetOrdersByAccountID(t *testing.T) {
 	}
 }
 
+func newExternalAccountBinding(t *testing.T, keyID, u string, hmacKey []byte, jwk *jose.JSONWebKey) *ExternalAccountBinding {
+	t.Helper()
+	pub := jwk.Public()
+	payload, err := json.Marshal(&pub)
+	assert.FatalError(t, err)
+	so := new(jose.SignerOptions)
+	so.WithHeader("kid", keyID)
+	so.WithHeader("url", u)
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.HS256, Key: hmacKey}, so)
+	assert.FatalError(t, err)
+	jws, err := signer.Sign(payload)
+	assert.FatalError(t, err)
+	eab := new(ExternalAccountBinding)
+	assert.FatalError(t, json.Unmarshal([]byte(jws.FullSerialize()), eab))
+	return eab
+}
+
 func TestHandler_NewAccount(t *testing.T) {
 	prov := newProv()
 	escProvName := url.PathEscape(prov.GetName())
 	baseURL := &url.URL{Scheme: "https", Host: "test.ca.smallstep.com"}
+	newAccountURL := fmt.Sprintf("%s/acme/%s/new-account", baseURL.String(), escProvName)
+	eabProv := newProv().(*provisioner.ACME)
+	eabProv.RequireEAB = true
+	hmacKey := []byte("a-32-byte-long-external-acct-key")
+	newEABContext := func(t *testing.T, nar *NewAccountRequest, jwk *jose.JSONWebKey) context.Context {
+		b, err := json.Marshal(nar)
+		assert.FatalError(t, err)
+		ctx := context.WithValue(context.Background(), payloadContextKey, &payloadInfo{value: b})
+		ctx = context.WithValue(ctx, jwkContextKey, jwk)
+		ctx = context.WithValue(ctx, baseURLContextKey, baseURL)
+		return context.WithValue(ctx, provisionerContextKey, eabProv)
+	}
+	getEAK := func(ctx context.Context, provisionerID, keyID string) (*acme.ExternalAccountKey, error) {
+		return &acme.ExternalAccountKey{ID: keyID, ProvisionerID: provisionerID, KeyBytes: hmacKey}, nil
+	}
 
 	type test struct {
 		db         acme.DB
@@ -386,6 +419,7 @@ func TestHandler_NewAccount(t *testing.T) {
 			assert.FatalError(t, err)
 			ctx := context.WithValue(context.Background(), payloadContextKey, &payloadInfo{value: b})
 			ctx = context.WithValue(ctx, jwkContextKey, jwk)
+			ctx = context.WithValue(ctx, provisionerContextKey, prov)
 			return test{
 				db: &acme.MockDB{
 					MockCreateAccount: func(ctx context.Context, acc *acme.Account) error {
@@ -415,6 +449,7 @@ func TestHandler_NewAccount(t *testing.T) {
 				db: &acme.MockDB{
 					MockCreateAccount: func(ctx context.Context, acc *acme.Account) error {
 						acc.ID = "accountID"
+						assert.Equals(t, acc.ProvisionerID, prov.GetID())
 						assert.Equals(t, acc.Contact, nar.Contact)
 						assert.Equals(t, acc.Key, jwk)
 						return nil
@@ -431,6 +466,154 @@ func TestHandler_NewAccount(t *testing.T) {
 				statusCode: 201,
 			}
 		},
+		"fail/eab-required": func(t *testing.T) test {
+			jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+			return test{
+				ctx:        newEABContext(t, &NewAccountRequest{Contact: []string{"foo"}}, jwk),
+				statusCode: 400,
+				err:        acme.NewError(acme.ErrorExternalAccountRequiredType, "no external account binding provided"),
+			}
+		},
+		"fail/eab-url-mismatch": func(t *testing.T) test {
+			jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+			nar := &NewAccountRequest{
+				ExternalAccountBinding: newExternalAccountBinding(t, "eakID", "https://foo.com", hmacKey, jwk),
+			}
+			return test{
+				ctx:        newEABContext(t, nar, jwk),
+				statusCode: 400,
+				err:        acme.NewError(acme.ErrorMalformedType, "externalAccountBinding url header does not match the new account url"),
+			}
+		},
+		"fail/eab-unknown-key": func(t *testing.T) test {
+			jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+			nar := &NewAccountRequest{
+				ExternalAccountBinding: newExternalAccountBinding(t, "eakID", newAccountURL, hmacKey, jwk),
+			}
+			return test{
+				db: &acme.MockDB{
+					MockGetExternalAccountKey: func(ctx context.Context, provisionerID, keyID string) (*acme.ExternalAccountKey, error) {
+						assert.Equals(t, provisionerID, eabProv.GetID())
+						assert.Equals(t, keyID, "eakID")
+						return nil, acme.ErrNotFound
+					},
+				},
+				ctx:        newEABContext(t, nar, jwk),
+				statusCode: 401,
+				err:        acme.NewError(acme.ErrorUnauthorizedType, "externalAccountBinding kid 'eakID' references an unknown key"),
+			}
+		},
+		"fail/eab-already-bound": func(t *testing.T) test {
+			jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+			nar := &NewAccountRequest{
+				ExternalAccountBinding: newExternalAccountBinding(t, "eakID", newAccountURL, hmacKey, jwk),
+			}
+			return test{
+				db: &acme.MockDB{
+					MockGetExternalAccountKey: func(ctx context.Context, provisionerID, keyID string) (*acme.ExternalAccountKey, error) {
+						return &acme.ExternalAccountKey{ID: keyID, AccountID: "otherID", BoundAt: time.Now()}, nil
+					},
+				},
+				ctx:        newEABContext(t, nar, jwk),
+				statusCode: 401,
+				err:        acme.NewError(acme.ErrorUnauthorizedType, "external account binding key was already bound"),
+			}
+		},
+		"fail/eab-bad-signature": func(t *testing.T) test {
+			jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+			nar := &NewAccountRequest{
+				ExternalAccountBinding: newExternalAccountBinding(t, "eakID", newAccountURL, []byte("another-32-byte-long-hmac-key!!!"), jwk),
+			}
+			return test{
+				db:         &acme.MockDB{MockGetExternalAccountKey: getEAK},
+				ctx:        newEABContext(t, nar, jwk),
+				statusCode: 401,
+				err:        acme.NewError(acme.ErrorUnauthorizedType, "error verifying externalAccountBinding signature"),
+			}
+		},
+		"fail/eab-payload-mismatch": func(t *testing.T) test {
+			jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+			other, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+			nar := &NewAccountRequest{
+				ExternalAccountBinding: newExternalAccountBinding(t, "eakID", newAccountURL, hmacKey, other),
+			}
+			return test{
+				db:         &acme.MockDB{MockGetExternalAccountKey: getEAK},
+				ctx:        newEABContext(t, nar, jwk),
+				statusCode: 401,
+				err:        acme.NewError(acme.ErrorUnauthorizedType, "externalAccountBinding payload does not match the account key"),
+			}
+		},
+		"fail/eab-bind-error": func(t *testing.T) test {
+			jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+			nar := &NewAccountRequest{
+				ExternalAccountBinding: newExternalAccountBinding(t, "eakID", newAccountURL, hmacKey, jwk),
+			}
+			return test{
+				db: &acme.MockDB{
+					MockGetExternalAccountKey: getEAK,
+					MockCreateAccount: func(ctx context.Context, acc *acme.Account) error {
+						acc.ID = "accountID"
+						return nil
+					},
+					MockUpdateExternalAccountKey: func(ctx context.Context, provisionerID string, eak *acme.ExternalAccountKey) error {
+						return errors.New("force")
+					},
+					MockUpdateAccount: func(ctx context.Context, acc *acme.Account) error {
+						assert.Equals(t, acc.ID, "accountID")
+						assert.Equals(t, acc.Status, acme.StatusDeactivated)
+						return nil
+					},
+				},
+				ctx:        newEABContext(t, nar, jwk),
+				statusCode: 500,
+				err:        acme.NewErrorISE("error binding external account key eakID to account accountID: force"),
+			}
+		},
+		"ok/new-account-eab": func(t *testing.T) test {
+			jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+			eab := newExternalAccountBinding(t, "eakID", newAccountURL, hmacKey, jwk)
+			nar := &NewAccountRequest{
+				Contact:                []string{"foo", "bar"},
+				ExternalAccountBinding: eab,
+			}
+			return test{
+				db: &acme.MockDB{
+					MockGetExternalAccountKey: getEAK,
+					MockCreateAccount: func(ctx context.Context, acc *acme.Account) error {
+						acc.ID = "accountID"
+						return nil
+					},
+					MockUpdateExternalAccountKey: func(ctx context.Context, provisionerID string, eak *acme.ExternalAccountKey) error {
+						assert.Equals(t, provisionerID, eabProv.GetID())
+						assert.Equals(t, eak.ID, "eakID")
+						assert.Equals(t, eak.AccountID, "accountID")
+						assert.True(t, eak.AlreadyBound())
+						assert.Equals(t, eak.KeyBytes, []byte{})
+						return nil
+					},
+				},
+				acc: &acme.Account{
+					ID:                     "accountID",
+					Key:                    jwk,
+					Status:                 acme.StatusValid,
+					Contact:                []string{"foo", "bar"},
+					OrdersURL:              fmt.Sprintf("%s/acme/%s/account/accountID/orders", baseURL.String(), escProvName),
+					ExternalAccountBinding: eab,
+				},
+				ctx:        newEABContext(t, nar, jwk),
+				statusCode: 201,
+			}
+		},
 		"ok/return-existing": func(t *testing.T) test {
 			nar := &NewAccountRequest{
 				OnlyReturnExisting: true,
@@ -693,3 +876,211 @@ func TestHandler_GetOrUpdateAccount(t *testing.T) {
 		})
 	}
 }
+
+func TestHandler_KeyChange(t *testing.T) {
+	accID := "accountID"
+	prov := newProv()
+	escProvName := url.PathEscape(prov.GetName())
+	baseURL := &url.URL{Scheme: "https", Host: "test.ca.smallstep.com"}
+	keyChangeURL := fmt.Sprintf("%s/acme/%s/key-change", baseURL.String(), escProvName)
+	accURL := fmt.Sprintf("%s/acme/%s/account/%s", baseURL.String(), escProvName, accID)
+
+	oldJWK, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	assert.FatalError(t, err)
+	newJWK, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	assert.FatalError(t, err)
+	oldPub, newPub := oldJWK.Public(), newJWK.Public()
+
+	sign := func(t *testing.T, key *jose.JSONWebKey, headers map[jose.HeaderKey]interface{}, payload []byte) *jose.JSONWebSignature {
+		so := new(jose.SignerOptions)
+		for k, v := range headers {
+			so.WithHeader(k, v)
+		}
+		signer, err := jose.NewSigner(jose.SigningKey{
+			Algorithm: jose.SignatureAlgorithm(key.Algorithm),
+			Key:       key.Key,
+		}, so)
+		assert.FatalError(t, err)
+		jws, err := signer.Sign(payload)
+		assert.FatalError(t, err)
+		raw, err := jws.CompactSerialize()
+		assert.FatalError(t, err)
+		parsed, err := jose.ParseJWS(raw)
+		assert.FatalError(t, err)
+		return parsed
+	}
+	innerPayload := func(t *testing.T, kcr *KeyChangeRequest, headers map[jose.HeaderKey]interface{}) []byte {
+		b, err := json.Marshal(kcr)
+		assert.FatalError(t, err)
+		raw, err := sign(t, newJWK, headers, b).CompactSerialize()
+		assert.FatalError(t, err)
+		return []byte(raw)
+	}
+	innerHeaders := map[jose.HeaderKey]interface{}{"jwk": newPub, "url": keyChangeURL}
+	outer := sign(t, oldJWK, map[jose.HeaderKey]interface{}{"kid": accURL, "url": keyChangeURL}, []byte("{}"))
+	newContext := func(payload []byte) context.Context {
+		acc := &acme.Account{ID: accID, Status: "valid", Key: &oldPub}
+		ctx := context.WithValue(context.Background(), provisionerContextKey, prov)
+		ctx = context.WithValue(ctx, baseURLContextKey, baseURL)
+		ctx = context.WithValue(ctx, accContextKey, acc)
+		ctx = context.WithValue(ctx, jwsContextKey, outer)
+		return context.WithValue(ctx, payloadContextKey, &payloadInfo{value: payload})
+	}
+	notFound := func(ctx context.Context, kid string) (*acme.Account, error) {
+		return nil, acme.ErrNotFound
+	}
+
+	type test struct {
+		db         acme.DB
+		ctx        context.Context
+		statusCode int
+		err        *acme.Error
+		location   string
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/no-account": func(t *testing.T) test {
+			return test{
+				ctx:        context.Background(),
+				statusCode: 400,
+				err:        acme.NewError(acme.ErrorAccountDoesNotExistType, "account does not exist"),
+			}
+		},
+		"fail/parse-inner-jws": func(t *testing.T) test {
+			return test{
+				ctx:        newContext([]byte("foo")),
+				statusCode: 400,
+				err:        acme.NewError(acme.ErrorMalformedType, "failed to parse inner JWS"),
+			}
+		},
+		"fail/inner-jws-without-jwk": func(t *testing.T) test {
+			payload := innerPayload(t, &KeyChangeRequest{Account: accURL, OldKey: &oldPub}, map[jose.HeaderKey]interface{}{"url": keyChangeURL})
+			return test{
+				ctx:        newContext(payload),
+				statusCode: 400,
+				err:        acme.NewError(acme.ErrorMalformedType, "inner JWS must contain a jwk"),
+			}
+		},
+		"fail/inner-jws-url-mismatch": func(t *testing.T) test {
+			payload := innerPayload(t, &KeyChangeRequest{Account: accURL, OldKey: &oldPub}, map[jose.HeaderKey]interface{}{"jwk": newPub, "url": "https://foo.com"})
+			return test{
+				ctx:        newContext(payload),
+				statusCode: 400,
+				err:        acme.NewError(acme.ErrorMalformedType, "url header in inner JWS does not match outer JWS"),
+			}
+		},
+		"fail/inner-jws-signature": func(t *testing.T) test {
+			// Signed with the old key, but the header contains the new key.
+			b, err := json.Marshal(&KeyChangeRequest{Account: accURL, OldKey: &oldPub})
+			assert.FatalError(t, err)
+			raw, err := sign(t, oldJWK, innerHeaders, b).CompactSerialize()
+			assert.FatalError(t, err)
+			return test{
+				ctx:        newContext([]byte(raw)),
+				statusCode: 400,
+				err:        acme.NewError(acme.ErrorMalformedType, "error verifying inner JWS"),
+			}
+		},
+		"fail/missing-old-key": func(t *testing.T) test {
+			return test{
+				ctx:        newContext(innerPayload(t, &KeyChangeRequest{Account: accURL}, innerHeaders)),
+				statusCode: 400,
+				err:        acme.NewError(acme.ErrorMalformedType, "oldKey cannot be empty"),
+			}
+		},
+		"fail/account-mismatch": func(t *testing.T) test {
+			return test{
+				ctx:        newContext(innerPayload(t, &KeyChangeRequest{Account: "https://foo.com", OldKey: &oldPub}, innerHeaders)),
+				statusCode: 400,
+				err:        acme.NewError(acme.ErrorMalformedType, "account 'https://foo.com' in key-change request does not match"),
+			}
+		},
+		"fail/old-key-mismatch": func(t *testing.T) test {
+			return test{
+				ctx:        newContext(innerPayload(t, &KeyChangeRequest{Account: accURL, OldKey: &newPub}, innerHeaders)),
+				statusCode: 400,
+				err:        acme.NewError(acme.ErrorMalformedType, "oldKey does not match the account key"),
+			}
+		},
+		"fail/key-in-use": func(t *testing.T) test {
+			return test{
+				db: &acme.MockDB{
+					MockGetAccountByKeyID: func(ctx context.Context, kid string) (*acme.Account, error) {
+						return &acme.Account{ID: "otherID"}, nil
+					},
+				},
+				ctx:        newContext(innerPayload(t, &KeyChangeRequest{Account: accURL, OldKey: &oldPub}, innerHeaders)),
+				statusCode: 409,
+				err:        acme.NewError(acme.ErrorMalformedType, "new key is already in use by account 'otherID'"),
+				location:   fmt.Sprintf("%s/acme/%s/account/otherID", baseURL.String(), escProvName),
+			}
+		},
+		"fail/db.UpdateAccountKey-error": func(t *testing.T) test {
+			return test{
+				db: &acme.MockDB{
+					MockGetAccountByKeyID: notFound,
+					MockUpdateAccountKey: func(ctx context.Context, acc *acme.Account, newKey *jose.JSONWebKey) error {
+						return acme.NewErrorISE("force")
+					},
+				},
+				ctx:        newContext(innerPayload(t, &KeyChangeRequest{Account: accURL, OldKey: &oldPub}, innerHeaders)),
+				statusCode: 500,
+				err:        acme.NewErrorISE("force"),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			newKid, err := acme.KeyToID(&newPub)
+			assert.FatalError(t, err)
+			return test{
+				db: &acme.MockDB{
+					MockGetAccountByKeyID: func(ctx context.Context, kid string) (*acme.Account, error) {
+						assert.Equals(t, kid, newKid)
+						return nil, acme.ErrNotFound
+					},
+					MockUpdateAccountKey: func(ctx context.Context, acc *acme.Account, newKey *jose.JSONWebKey) error {
+						assert.Equals(t, acc.ID, accID)
+						assert.Equals(t, newKey.KeyID, newKid)
+						assert.Equals(t, newKey.Key, newPub.Key)
+						return nil
+					},
+				},
+				ctx:        newContext(innerPayload(t, &KeyChangeRequest{Account: accURL, OldKey: &oldPub}, innerHeaders)),
+				statusCode: 200,
+				location:   accURL,
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			h := &Handler{db: tc.db, linker: NewLinker("dns", "acme")}
+			req := httptest.NewRequest("POST", keyChangeURL, nil)
+			req = req.WithContext(tc.ctx)
+			w := httptest.NewRecorder()
+			h.KeyChange(w, req)
+			res := w.Result()
+
+			assert.Equals(t, res.StatusCode, tc.statusCode)
+
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			assert.FatalError(t, err)
+
+			if tc.location != "" {
+				assert.Equals(t, res.Header["Location"], []string{tc.location})
+			}
+			if res.StatusCode >= 400 && assert.NotNil(t, tc.err) {
+				var ae acme.Error
+				assert.FatalError(t, json.Unmarshal(bytes.TrimSpace(body), &ae))
+
+				assert.Equals(t, ae.Type, tc.err.Type)
+				assert.HasPrefix(t, ae.Detail, tc.err.Detail)
+				assert.Equals(t, res.Header["Content-Type"], []string{"application/problem+json"})
+			} else {
+				var acc acme.Account
+				assert.FatalError(t, json.Unmarshal(bytes.TrimSpace(body), &acc))
+				assert.Equals(t, acc.Status, acme.StatusValid)
+				assert.Equals(t, acc.OrdersURL, fmt.Sprintf("%s/acme/%s/account/%s/orders", baseURL.String(), escProvName, accID))
+			}
+		})
+	}
+}
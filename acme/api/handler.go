@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
@@ -8,6 +9,7 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/go-chi/chi"
@@ -38,11 +40,13 @@ type payloadInfo struct {
 
 // Handler is the ACME API request handler.
 type Handler struct {
-	db                       acme.DB
-	backdate                 provisioner.Duration
-	ca                       acme.CertificateAuthority
-	linker                   Linker
-	validateChallengeOptions *acme.ValidateChallengeOptions
+	db        acme.DB
+	backdate  provisioner.Duration
+	ca        acme.CertificateAuthority
+	linker    Linker
+	validator *acme.ChallengeValidator
+	lookupCAA func(ctx context.Context, resolver, name string) ([]*acme.CAA, error)
+	email     acme.EmailTransport
 }
 
 // HandlerOptions required to create a new ACME API request handler.
@@ -60,6 +64,13 @@ type HandlerOptions struct {
 	// "acme" is the prefix from which the ACME api is accessed.
 	Prefix string
 	CA     acme.CertificateAuthority
+	// Email is the transport used by email-reply-00 challenges. Email
+	// identifiers are not supported if it is nil.
+	Email acme.EmailTransport
+	// Context is the context of the background validations of challenges,
+	// they are interrupted when it is canceled. Defaults to
+	// context.Background().
+	Context context.Context
 }
 
 // NewHandler returns a new ACME API handler.
@@ -76,19 +87,35 @@ func NewHandler(ops HandlerOptions) api.RouterHandler {
 	dialer := &net.Dialer{
 		Timeout: 30 * time.Second,
 	}
-	return &Handler{
-		ca:       ops.CA,
-		db:       ops.DB,
-		backdate: ops.Backdate,
-		linker:   NewLinker(ops.DNS, ops.Prefix),
-		validateChallengeOptions: &acme.ValidateChallengeOptions{
-			HTTPGet:   client.Get,
-			LookupTxt: net.LookupTXT,
-			TLSDial: func(network, addr string, config *tls.Config) (*tls.Conn, error) {
-				return tls.DialWithDialer(dialer, network, addr, config)
-			},
+	vo := &acme.ValidateChallengeOptions{
+		HTTPGet:   client.Get,
+		LookupTxt: net.LookupTXT,
+		TLSDial: func(network, addr string, config *tls.Config) (*tls.Conn, error) {
+			return tls.DialWithDialer(dialer, network, addr, config)
 		},
 	}
+	if ops.Email != nil {
+		vo.EmailReplies = ops.Email.Replies
+	}
+	ctx := ops.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &Handler{
+		ca:        ops.CA,
+		db:        ops.DB,
+		backdate:  ops.Backdate,
+		linker:    NewLinker(ops.DNS, ops.Prefix),
+		validator: acme.NewChallengeValidator(ctx, ops.DB, vo),
+		lookupCAA: acme.LookupCAA,
+		email:     ops.Email,
+	}
+}
+
+// Wait blocks until the background validations of challenges are completed,
+// or interrupted if the context in the options is canceled.
+func (h *Handler) Wait() {
+	h.validator.Wait()
 }
 
 // Route traffic and implement the Router interface.
@@ -99,6 +126,7 @@ func (h *Handler) Route(r api.Router) {
 	r.MethodFunc("HEAD", getPath(NewNonceLinkType, "{provisionerID}"), h.baseURLFromRequest(h.lookupProvisioner(h.addNonce(h.addDirLink(h.GetNonce)))))
 	r.MethodFunc("GET", getPath(DirectoryLinkType, "{provisionerID}"), h.baseURLFromRequest(h.lookupProvisioner(h.GetDirectory)))
 	r.MethodFunc("HEAD", getPath(DirectoryLinkType, "{provisionerID}"), h.baseURLFromRequest(h.lookupProvisioner(h.GetDirectory)))
+	r.MethodFunc("GET", getPath(RenewalInfoLinkType, "{provisionerID}", "{certID}"), h.baseURLFromRequest(h.lookupProvisioner(h.GetRenewalInfo)))
 
 	extractPayloadByJWK := func(next nextHTTP) nextHTTP {
 		return h.baseURLFromRequest(h.lookupProvisioner(h.addNonce(h.addDirLink(h.verifyContentType(h.parseJWS(h.validateJWS(h.extractJWK(h.verifyAndExtractJWSPayload(next)))))))))
@@ -106,17 +134,22 @@ func (h *Handler) Route(r api.Router) {
 	extractPayloadByKid := func(next nextHTTP) nextHTTP {
 		return h.baseURLFromRequest(h.lookupProvisioner(h.addNonce(h.addDirLink(h.verifyContentType(h.parseJWS(h.validateJWS(h.lookupJWK(h.verifyAndExtractJWSPayload(next)))))))))
 	}
+	extractPayloadByKidOrJWK := func(next nextHTTP) nextHTTP {
+		return h.baseURLFromRequest(h.lookupProvisioner(h.addNonce(h.addDirLink(h.verifyContentType(h.parseJWS(h.validateJWS(h.extractOrLookupJWK(h.verifyAndExtractJWSPayload(next)))))))))
+	}
 
 	r.MethodFunc("POST", getPath(NewAccountLinkType, "{provisionerID}"), extractPayloadByJWK(h.NewAccount))
 	r.MethodFunc("POST", getPath(AccountLinkType, "{provisionerID}", "{accID}"), extractPayloadByKid(h.GetOrUpdateAccount))
-	r.MethodFunc("POST", getPath(KeyChangeLinkType, "{provisionerID}", "{accID}"), extractPayloadByKid(h.NotImplemented))
+	r.MethodFunc("POST", getPath(KeyChangeLinkType, "{provisionerID}", "{accID}"), extractPayloadByKid(h.KeyChange))
 	r.MethodFunc("POST", getPath(NewOrderLinkType, "{provisionerID}"), extractPayloadByKid(h.NewOrder))
+	r.MethodFunc("POST", getPath(NewAuthzLinkType, "{provisionerID}"), extractPayloadByKid(h.NewAuthorization))
 	r.MethodFunc("POST", getPath(OrderLinkType, "{provisionerID}", "{ordID}"), extractPayloadByKid(h.isPostAsGet(h.GetOrder)))
 	r.MethodFunc("POST", getPath(OrdersByAccountLinkType, "{provisionerID}", "{accID}"), extractPayloadByKid(h.isPostAsGet(h.GetOrdersByAccountID)))
 	r.MethodFunc("POST", getPath(FinalizeLinkType, "{provisionerID}", "{ordID}"), extractPayloadByKid(h.FinalizeOrder))
 	r.MethodFunc("POST", getPath(AuthzLinkType, "{provisionerID}", "{authzID}"), extractPayloadByKid(h.isPostAsGet(h.GetAuthorization)))
 	r.MethodFunc("POST", getPath(ChallengeLinkType, "{provisionerID}", "{authzID}", "{chID}"), extractPayloadByKid(h.GetChallenge))
 	r.MethodFunc("POST", getPath(CertificateLinkType, "{provisionerID}", "{certID}"), extractPayloadByKid(h.isPostAsGet(h.GetCertificate)))
+	r.MethodFunc("POST", getPath(RevokeCertLinkType, "{provisionerID}"), extractPayloadByKidOrJWK(h.RevokeCert))
 }
 
 // GetNonce just sets the right header since a Nonce is added to each response
@@ -131,11 +164,20 @@ func (h *Handler) GetNonce(w http.ResponseWriter, r *http.Request) {
 
 // Directory represents an ACME directory for configuring clients.
 type Directory struct {
-	NewNonce   string `json:"newNonce"`
-	NewAccount string `json:"newAccount"`
-	NewOrder   string `json:"newOrder"`
-	RevokeCert string `json:"revokeCert"`
-	KeyChange  string `json:"keyChange"`
+	NewNonce    string `json:"newNonce"`
+	NewAccount  string `json:"newAccount"`
+	NewOrder    string `json:"newOrder"`
+	NewAuthz    string `json:"newAuthz,omitempty"`
+	RevokeCert  string `json:"revokeCert"`
+	KeyChange   string `json:"keyChange"`
+	RenewalInfo string `json:"renewalInfo"`
+	Meta        *Meta  `json:"meta,omitempty"`
+}
+
+// Meta represents the ACME directory metadata object.
+type Meta struct {
+	ExternalAccountRequired bool              `json:"externalAccountRequired,omitempty"`
+	Profiles                map[string]string `json:"profiles,omitempty"`
 }
 
 // ToLog enables response logging for the Directory type.
@@ -151,12 +193,33 @@ func (d *Directory) ToLog() (interface{}, error) {
 // for client configuration.
 func (h *Handler) GetDirectory(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
+	acmeProv, err := acmeProvisionerFromContext(ctx)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+
+	var meta *Meta
+	if profiles := acmeProv.GetProfileDescriptions(); acmeProv.RequireEAB || len(profiles) > 0 {
+		meta = &Meta{
+			ExternalAccountRequired: acmeProv.RequireEAB,
+			Profiles:                profiles,
+		}
+	}
+	// Pre-authorization is only advertised if it is enabled.
+	var newAuthz string
+	if acmeProv.GetAuthorizationLifetime() > 0 {
+		newAuthz = h.linker.GetLink(ctx, NewAuthzLinkType)
+	}
 	api.JSON(w, &Directory{
-		NewNonce:   h.linker.GetLink(ctx, NewNonceLinkType),
-		NewAccount: h.linker.GetLink(ctx, NewAccountLinkType),
-		NewOrder:   h.linker.GetLink(ctx, NewOrderLinkType),
-		RevokeCert: h.linker.GetLink(ctx, RevokeCertLinkType),
-		KeyChange:  h.linker.GetLink(ctx, KeyChangeLinkType),
+		NewNonce:    h.linker.GetLink(ctx, NewNonceLinkType),
+		NewAccount:  h.linker.GetLink(ctx, NewAccountLinkType),
+		NewOrder:    h.linker.GetLink(ctx, NewOrderLinkType),
+		NewAuthz:    newAuthz,
+		RevokeCert:  h.linker.GetLink(ctx, RevokeCertLinkType),
+		KeyChange:   h.linker.GetLink(ctx, KeyChangeLinkType),
+		RenewalInfo: h.linker.GetLink(ctx, RenewalInfoLinkType),
+		Meta:        meta,
 	})
 }
 
@@ -195,7 +258,57 @@ func (h *Handler) GetAuthorization(w http.ResponseWriter, r *http.Request) {
 	api.JSON(w, az)
 }
 
-// GetChallenge ACME api for retrieving a Challenge.
+// challengeValidationOptions returns the options used to validate the
+// challenges of a provisioner. Unset or zero durations use the defaults.
+func challengeValidationOptions(p acme.Provisioner) *acme.ValidationOptions {
+	opts := acme.DefaultValidationOptions
+	opts.FailedValidations = rateLimits(p).FailedValidationsPerHostname
+	acmeProv, ok := p.(*provisioner.ACME)
+	if !ok {
+		return &opts
+	}
+	if len(acmeProv.AttestationFormats) > 0 {
+		opts.Attestation = &acme.AttestationOptions{
+			Formats: acmeProv.AttestationFormats,
+			Roots:   acmeProv.GetAttestationRoots(),
+		}
+	}
+	if acmeProv.ChallengeValidation == nil {
+		return &opts
+	}
+	cv := acmeProv.ChallengeValidation
+	if cv.Retries != nil {
+		opts.Retries = *cv.Retries
+	}
+	if cv.Backoff != nil && cv.Backoff.Duration > 0 {
+		opts.Backoff = cv.Backoff.Duration
+	}
+	if cv.MaxBackoff != nil && cv.MaxBackoff.Duration > 0 {
+		opts.MaxBackoff = cv.MaxBackoff.Duration
+	}
+	if cv.DNSPropagationTimeout != nil && cv.DNSPropagationTimeout.Duration > 0 {
+		opts.DNSPropagationTimeout = cv.DNSPropagationTimeout.Duration
+	}
+	if cv.EmailReplyTimeout != nil && cv.EmailReplyTimeout.Duration > 0 {
+		opts.EmailReplyTimeout = cv.EmailReplyTimeout.Duration
+	}
+	return &opts
+}
+
+// retryAfter returns the value of the Retry-After header for a challenge
+// being validated, in seconds.
+func retryAfter(d time.Duration) string {
+	secs := int((d + time.Second - 1) / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	return strconv.Itoa(secs)
+}
+
+// GetChallenge ACME api for retrieving a Challenge. Pending challenges are
+// moved to the processing status and validated in the background, clients
+// are expected to poll the challenge respecting the Retry-After header until
+// the challenge is valid or invalid.
 func (h *Handler) GetChallenge(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	acc, err := accountFromContext(ctx)
@@ -205,7 +318,7 @@ func (h *Handler) GetChallenge(w http.ResponseWriter, r *http.Request) {
 	}
 	// Just verify that the payload was set, since we're not strictly adhering
 	// to ACME V2 spec for reasons specified below.
-	_, err = payloadFromContext(ctx)
+	payload, err := payloadFromContext(ctx)
 	if err != nil {
 		api.WriteError(w, err)
 		return
@@ -215,7 +328,8 @@ func (h *Handler) GetChallenge(w http.ResponseWriter, r *http.Request) {
 	// that the payload is an empty JSON block ({}). However, older ACME clients
 	// still send a vestigial body (rather than an empty JSON block) and
 	// strict enforcement would render these clients broken. For the time being
-	// we'll just ignore the body.
+	// we'll just ignore the body, except on device-attest-01 challenges, where
+	// it contains the attestation.
 
 	azID := chi.URLParam(r, "authzID")
 	ch, err := h.db.GetChallenge(ctx, chi.URLParam(r, "chID"), azID)
@@ -234,9 +348,32 @@ func (h *Handler) GetChallenge(w http.ResponseWriter, r *http.Request) {
 		api.WriteError(w, err)
 		return
 	}
-	if err = ch.Validate(ctx, h.db, jwk, h.validateChallengeOptions); err != nil {
-		api.WriteError(w, acme.WrapErrorISE(err, "error validating challenge"))
-		return
+	validate := ch.Status == acme.StatusPending || ch.Status == acme.StatusProcessing
+	if ch.Type == acme.DEVICEATTEST01 && ch.Status == acme.StatusPending {
+		// The validation starts when the client sends the attestation.
+		if payload.isPostAsGet || payload.isEmptyJSON {
+			validate = false
+		} else {
+			ch.Payload = payload.value
+		}
+	}
+	if validate {
+		prov, err := provisionerFromContext(ctx)
+		if err != nil {
+			api.WriteError(w, err)
+			return
+		}
+		if ch.Status == acme.StatusPending {
+			if err := rateLimits(prov).FailedValidationsPerHostname.Check(ctx, h.db, ch.Value); err != nil {
+				api.WriteError(w, err)
+				return
+			}
+		}
+		if _, err = h.validator.Start(ctx, ch, jwk, challengeValidationOptions(prov)); err != nil {
+			api.WriteError(w, acme.WrapErrorISE(err, "error validating challenge"))
+			return
+		}
+		w.Header().Set("Retry-After", retryAfter(h.validator.RetryAfter(ch.ID)))
 	}
 
 	h.linker.LinkChallenge(ctx, ch, azID)
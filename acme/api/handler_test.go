@@ -18,6 +18,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
 	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/authority/provisioner"
 	"go.step.sm/crypto/jose"
 	"go.step.sm/crypto/pemutil"
 )
@@ -59,20 +60,85 @@ func TestHandler_GetDirectory(t *testing.T) {
 	ctx = context.WithValue(ctx, baseURLContextKey, baseURL)
 
 	expDir := Directory{
-		NewNonce:   fmt.Sprintf("%s/acme/%s/new-nonce", baseURL.String(), provName),
-		NewAccount: fmt.Sprintf("%s/acme/%s/new-account", baseURL.String(), provName),
-		NewOrder:   fmt.Sprintf("%s/acme/%s/new-order", baseURL.String(), provName),
-		RevokeCert: fmt.Sprintf("%s/acme/%s/revoke-cert", baseURL.String(), provName),
-		KeyChange:  fmt.Sprintf("%s/acme/%s/key-change", baseURL.String(), provName),
+		NewNonce:    fmt.Sprintf("%s/acme/%s/new-nonce", baseURL.String(), provName),
+		NewAccount:  fmt.Sprintf("%s/acme/%s/new-account", baseURL.String(), provName),
+		NewOrder:    fmt.Sprintf("%s/acme/%s/new-order", baseURL.String(), provName),
+		RevokeCert:  fmt.Sprintf("%s/acme/%s/revoke-cert", baseURL.String(), provName),
+		KeyChange:   fmt.Sprintf("%s/acme/%s/key-change", baseURL.String(), provName),
+		RenewalInfo: fmt.Sprintf("%s/acme/%s/renewal-info", baseURL.String(), provName),
 	}
 
 	type test struct {
+		ctx        context.Context
+		expDir     Directory
 		statusCode int
 		err        *acme.Error
 	}
 	var tests = map[string]func(t *testing.T) test{
+		"fail/no-provisioner": func(t *testing.T) test {
+			return test{
+				ctx:        context.Background(),
+				statusCode: 500,
+				err:        acme.NewErrorISE("provisioner expected in request context"),
+			}
+		},
+		"fail/not-acme-provisioner": func(t *testing.T) test {
+			ctx := context.WithValue(context.Background(), provisionerContextKey, &acme.MockProvisioner{})
+			return test{
+				ctx:        ctx,
+				statusCode: 500,
+				err:        acme.NewErrorISE("provisioner in context is not an ACME provisioner"),
+			}
+		},
 		"ok": func(t *testing.T) test {
 			return test{
+				ctx:        ctx,
+				expDir:     expDir,
+				statusCode: 200,
+			}
+		},
+		"ok/eab-required": func(t *testing.T) test {
+			eabProv := newProv().(*provisioner.ACME)
+			eabProv.RequireEAB = true
+			ctx := context.WithValue(context.Background(), provisionerContextKey, eabProv)
+			ctx = context.WithValue(ctx, baseURLContextKey, baseURL)
+			dir := expDir
+			dir.Meta = &Meta{ExternalAccountRequired: true}
+			return test{
+				ctx:        ctx,
+				expDir:     dir,
+				statusCode: 200,
+			}
+		},
+		"ok/profiles": func(t *testing.T) test {
+			profilesProv := newProv().(*provisioner.ACME)
+			profilesProv.Profiles = []*provisioner.ACMEProfile{
+				{Name: "server", Description: "Short-lived server certificates"},
+				{Name: "client"},
+			}
+			ctx := context.WithValue(context.Background(), provisionerContextKey, profilesProv)
+			ctx = context.WithValue(ctx, baseURLContextKey, baseURL)
+			dir := expDir
+			dir.Meta = &Meta{Profiles: map[string]string{
+				"server": "Short-lived server certificates",
+				"client": "",
+			}}
+			return test{
+				ctx:        ctx,
+				expDir:     dir,
+				statusCode: 200,
+			}
+		},
+		"ok/pre-authorization": func(t *testing.T) test {
+			authzProv := newProv().(*provisioner.ACME)
+			authzProv.AuthorizationLifetime = &provisioner.Duration{Duration: 24 * time.Hour}
+			ctx := context.WithValue(context.Background(), provisionerContextKey, authzProv)
+			ctx = context.WithValue(ctx, baseURLContextKey, baseURL)
+			dir := expDir
+			dir.NewAuthz = fmt.Sprintf("%s/acme/%s/new-authz", baseURL.String(), provName)
+			return test{
+				ctx:        ctx,
+				expDir:     dir,
 				statusCode: 200,
 			}
 		},
@@ -82,7 +148,7 @@ func TestHandler_GetDirectory(t *testing.T) {
 		t.Run(name, func(t *testing.T) {
 			h := &Handler{linker: linker}
 			req := httptest.NewRequest("GET", "/foo/bar", nil)
-			req = req.WithContext(ctx)
+			req = req.WithContext(tc.ctx)
 			w := httptest.NewRecorder()
 			h.GetDirectory(w, req)
 			res := w.Result()
@@ -105,7 +171,7 @@ func TestHandler_GetDirectory(t *testing.T) {
 			} else {
 				var dir Directory
 				json.Unmarshal(bytes.TrimSpace(body), &dir)
-				assert.Equals(t, dir, expDir)
+				assert.Equals(t, dir, tc.expDir)
 				assert.Equals(t, res.Header["Content-Type"], []string{"application/json"})
 			}
 		})
@@ -444,6 +510,8 @@ func TestHandler_GetChallenge(t *testing.T) {
 		statusCode int
 		ch         *acme.Challenge
 		err        *acme.Error
+		retryAfter []string
+		updates    func() int
 	}
 	var tests = map[string]func(t *testing.T) test{
 		"fail/no-account": func(t *testing.T) test {
@@ -557,7 +625,31 @@ func TestHandler_GetChallenge(t *testing.T) {
 				err:        acme.NewErrorISE("nil jwk"),
 			}
 		},
-		"fail/validate-challenge-error": func(t *testing.T) test {
+		"fail/no-provisioner": func(t *testing.T) test {
+			acc := &acme.Account{ID: "accID"}
+			ctx := context.WithValue(context.Background(), accContextKey, acc)
+			ctx = context.WithValue(ctx, payloadContextKey, &payloadInfo{isEmptyJSON: true})
+			_jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+			_pub := _jwk.Public()
+			ctx = context.WithValue(ctx, jwkContextKey, &_pub)
+			ctx = context.WithValue(ctx, chi.RouteCtxKey, chiCtx)
+			return test{
+				db: &acme.MockDB{
+					MockGetChallenge: func(ctx context.Context, chID, azID string) (*acme.Challenge, error) {
+						return &acme.Challenge{
+							Status:    acme.StatusPending,
+							Type:      acme.HTTP01,
+							AccountID: "accID",
+						}, nil
+					},
+				},
+				ctx:        ctx,
+				statusCode: 500,
+				err:        acme.NewErrorISE("provisioner expected in request context"),
+			}
+		},
+		"fail/db.UpdateChallenge-error": func(t *testing.T) test {
 			acc := &acme.Account{ID: "accID"}
 			ctx := context.WithValue(context.Background(), provisionerContextKey, prov)
 			ctx = context.WithValue(ctx, accContextKey, acc)
@@ -579,27 +671,24 @@ func TestHandler_GetChallenge(t *testing.T) {
 						}, nil
 					},
 					MockUpdateChallenge: func(ctx context.Context, ch *acme.Challenge) error {
-						assert.Equals(t, ch.Status, acme.StatusPending)
+						assert.Equals(t, ch.Status, acme.StatusProcessing)
 						assert.Equals(t, ch.Type, acme.HTTP01)
 						assert.Equals(t, ch.AccountID, "accID")
 						assert.Equals(t, ch.AuthorizationID, "authzID")
-						assert.HasSuffix(t, ch.Error.Type, acme.ErrorConnectionType.String())
 						return acme.NewErrorISE("force")
 					},
 				},
-				vco: &acme.ValidateChallengeOptions{
-					HTTPGet: func(string) (*http.Response, error) {
-						return nil, errors.New("force")
-					},
-				},
 				ctx:        ctx,
 				statusCode: 500,
 				err:        acme.NewErrorISE("force"),
 			}
 		},
 		"ok": func(t *testing.T) test {
+			retries := 0
+			p := newProv().(*provisioner.ACME)
+			p.ChallengeValidation = &provisioner.ACMEChallengeValidation{Retries: &retries}
 			acc := &acme.Account{ID: "accID"}
-			ctx := context.WithValue(context.Background(), provisionerContextKey, prov)
+			ctx := context.WithValue(context.Background(), provisionerContextKey, p)
 			ctx = context.WithValue(ctx, accContextKey, acc)
 			ctx = context.WithValue(ctx, payloadContextKey, &payloadInfo{isEmptyJSON: true})
 			_jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
@@ -608,6 +697,7 @@ func TestHandler_GetChallenge(t *testing.T) {
 			ctx = context.WithValue(ctx, jwkContextKey, &_pub)
 			ctx = context.WithValue(ctx, baseURLContextKey, baseURL)
 			ctx = context.WithValue(ctx, chi.RouteCtxKey, chiCtx)
+			var updates int
 			return test{
 				db: &acme.MockDB{
 					MockGetChallenge: func(ctx context.Context, chID, azID string) (*acme.Challenge, error) {
@@ -621,22 +711,34 @@ func TestHandler_GetChallenge(t *testing.T) {
 						}, nil
 					},
 					MockUpdateChallenge: func(ctx context.Context, ch *acme.Challenge) error {
-						assert.Equals(t, ch.Status, acme.StatusPending)
+						updates++
 						assert.Equals(t, ch.Type, acme.HTTP01)
 						assert.Equals(t, ch.AccountID, "accID")
 						assert.Equals(t, ch.AuthorizationID, "authzID")
-						assert.HasSuffix(t, ch.Error.Type, acme.ErrorConnectionType.String())
+						switch updates {
+						case 1:
+							// Moved to processing by the request.
+							assert.Equals(t, ch.Status, acme.StatusProcessing)
+							assert.Nil(t, ch.Error)
+						case 2:
+							// Transient error stored by the validation.
+							assert.Equals(t, ch.Status, acme.StatusProcessing)
+							assert.HasSuffix(t, ch.Error.Type, acme.ErrorConnectionType.String())
+						default:
+							// No retries left.
+							assert.Equals(t, ch.Status, acme.StatusInvalid)
+							assert.HasSuffix(t, ch.Error.Type, acme.ErrorConnectionType.String())
+						}
 						return nil
 					},
 				},
 				ch: &acme.Challenge{
 					ID:              "chID",
-					Status:          acme.StatusPending,
+					Status:          acme.StatusProcessing,
 					AuthorizationID: "authzID",
 					Type:            acme.HTTP01,
 					AccountID:       "accID",
 					URL:             url,
-					Error:           acme.NewError(acme.ErrorConnectionType, "force"),
 				},
 				vco: &acme.ValidateChallengeOptions{
 					HTTPGet: func(string) (*http.Response, error) {
@@ -645,17 +747,95 @@ func TestHandler_GetChallenge(t *testing.T) {
 				},
 				ctx:        ctx,
 				statusCode: 200,
+				retryAfter: []string{"1"},
+				updates:    func() int { return updates },
+			}
+		},
+		"ok/device-attest-01-without-attestation": func(t *testing.T) test {
+			acc := &acme.Account{ID: "accID"}
+			ctx := context.WithValue(context.Background(), provisionerContextKey, prov)
+			ctx = context.WithValue(ctx, accContextKey, acc)
+			ctx = context.WithValue(ctx, payloadContextKey, &payloadInfo{value: []byte("{}"), isEmptyJSON: true})
+			_jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+			_pub := _jwk.Public()
+			ctx = context.WithValue(ctx, jwkContextKey, &_pub)
+			ctx = context.WithValue(ctx, baseURLContextKey, baseURL)
+			ctx = context.WithValue(ctx, chi.RouteCtxKey, chiCtx)
+			return test{
+				db: &acme.MockDB{
+					MockGetChallenge: func(ctx context.Context, chID, azID string) (*acme.Challenge, error) {
+						return &acme.Challenge{
+							ID:        "chID",
+							Status:    acme.StatusPending,
+							Type:      acme.DEVICEATTEST01,
+							AccountID: "accID",
+							Value:     "123456",
+						}, nil
+					},
+					MockUpdateChallenge: func(ctx context.Context, ch *acme.Challenge) error {
+						return errors.New("validation must not start without an attestation")
+					},
+				},
+				ch: &acme.Challenge{
+					ID:              "chID",
+					Status:          acme.StatusPending,
+					AuthorizationID: "authzID",
+					Type:            acme.DEVICEATTEST01,
+					AccountID:       "accID",
+					Value:           "123456",
+					URL:             url,
+				},
+				ctx:        ctx,
+				statusCode: 200,
+			}
+		},
+		"ok/valid": func(t *testing.T) test {
+			acc := &acme.Account{ID: "accID"}
+			ctx := context.WithValue(context.Background(), provisionerContextKey, prov)
+			ctx = context.WithValue(ctx, accContextKey, acc)
+			ctx = context.WithValue(ctx, payloadContextKey, &payloadInfo{isPostAsGet: true})
+			_jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+			_pub := _jwk.Public()
+			ctx = context.WithValue(ctx, jwkContextKey, &_pub)
+			ctx = context.WithValue(ctx, baseURLContextKey, baseURL)
+			ctx = context.WithValue(ctx, chi.RouteCtxKey, chiCtx)
+			return test{
+				db: &acme.MockDB{
+					MockGetChallenge: func(ctx context.Context, chID, azID string) (*acme.Challenge, error) {
+						return &acme.Challenge{
+							ID:          "chID",
+							Status:      acme.StatusValid,
+							Type:        acme.HTTP01,
+							AccountID:   "accID",
+							ValidatedAt: "2021-01-01T00:00:00Z",
+						}, nil
+					},
+				},
+				ch: &acme.Challenge{
+					ID:              "chID",
+					Status:          acme.StatusValid,
+					AuthorizationID: "authzID",
+					Type:            acme.HTTP01,
+					AccountID:       "accID",
+					ValidatedAt:     "2021-01-01T00:00:00Z",
+					URL:             url,
+				},
+				ctx:        ctx,
+				statusCode: 200,
 			}
 		},
 	}
 	for name, run := range tests {
 		tc := run(t)
 		t.Run(name, func(t *testing.T) {
-			h := &Handler{db: tc.db, linker: NewLinker("dns", "acme"), validateChallengeOptions: tc.vco}
+			h := &Handler{db: tc.db, linker: NewLinker("dns", "acme"), validator: acme.NewChallengeValidator(context.Background(), tc.db, tc.vco)}
 			req := httptest.NewRequest("GET", url, nil)
 			req = req.WithContext(tc.ctx)
 			w := httptest.NewRecorder()
 			h.GetChallenge(w, req)
+			h.validator.Wait()
 			res := w.Result()
 
 			assert.Equals(t, res.StatusCode, tc.statusCode)
@@ -679,8 +859,12 @@ func TestHandler_GetChallenge(t *testing.T) {
 				assert.Equals(t, bytes.TrimSpace(body), expB)
 				assert.Equals(t, res.Header["Link"], []string{fmt.Sprintf("<%s/acme/%s/authz/%s>;rel=\"up\"", baseURL, provName, "authzID")})
 				assert.Equals(t, res.Header["Location"], []string{url})
+				assert.Equals(t, res.Header["Retry-After"], tc.retryAfter)
 				assert.Equals(t, res.Header["Content-Type"], []string{"application/json"})
 			}
+			if tc.updates != nil {
+				assert.Equals(t, tc.updates(), 3)
+			}
 		})
 	}
 }
@@ -43,6 +43,11 @@ func (l *linker) GetUnescapedPathSuffix(typ LinkType, provisionerName string, in
 		return fmt.Sprintf("/%s/%s/%s/orders", provisionerName, AccountLinkType, inputs[0])
 	case FinalizeLinkType:
 		return fmt.Sprintf("/%s/%s/%s/finalize", provisionerName, OrderLinkType, inputs[0])
+	case RenewalInfoLinkType:
+		if len(inputs) == 0 {
+			return fmt.Sprintf("/%s/%s", provisionerName, typ)
+		}
+		return fmt.Sprintf("/%s/%s/%s", provisionerName, typ, inputs[0])
 	default:
 		return ""
 	}
@@ -111,6 +116,8 @@ const (
 	RevokeCertLinkType
 	// KeyChangeLinkType key rollover
 	KeyChangeLinkType
+	// RenewalInfoLinkType renewal information
+	RenewalInfoLinkType
 )
 
 func (l LinkType) String() string {
@@ -139,6 +146,8 @@ func (l LinkType) String() string {
 		return "revoke-cert"
 	case KeyChangeLinkType:
 		return "key-change"
+	case RenewalInfoLinkType:
+		return "renewal-info"
 	default:
 		return fmt.Sprintf("unexpected LinkType '%d'", int(l))
 	}
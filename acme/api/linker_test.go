@@ -28,6 +28,8 @@ func TestLinker_GetUnescapedPathSuffix(t *testing.T) {
 	assert.Equals(t, getPath(FinalizeLinkType, "{provisionerID}", "{ordID}"), "/{provisionerID}/order/{ordID}/finalize")
 	assert.Equals(t, getPath(AuthzLinkType, "{provisionerID}", "{authzID}"), "/{provisionerID}/authz/{authzID}")
 	assert.Equals(t, getPath(ChallengeLinkType, "{provisionerID}", "{authzID}", "{chID}"), "/{provisionerID}/challenge/{authzID}/{chID}")
+	assert.Equals(t, getPath(RenewalInfoLinkType, "{provisionerID}"), "/{provisionerID}/renewal-info")
+	assert.Equals(t, getPath(RenewalInfoLinkType, "{provisionerID}", "{certID}"), "/{provisionerID}/renewal-info/{certID}")
 	assert.Equals(t, getPath(CertificateLinkType, "{provisionerID}", "{certID}"), "/{provisionerID}/certificate/{certID}")
 }
 
@@ -76,6 +78,8 @@ func TestLinker_GetLink(t *testing.T) {
 
 	assert.Equals(t, linker.GetLink(ctx, KeyChangeLinkType), fmt.Sprintf("%s/acme/%s/key-change", baseURL, escProvName))
 
+	assert.Equals(t, linker.GetLink(ctx, RenewalInfoLinkType), fmt.Sprintf("%s/acme/%s/renewal-info", baseURL, escProvName))
+
 	assert.Equals(t, linker.GetLink(ctx, ChallengeLinkType, id, id), fmt.Sprintf("%s/acme/%s/challenge/%s/%s", baseURL, escProvName, id, id))
 
 	assert.Equals(t, linker.GetLink(ctx, CertificateLinkType, id), fmt.Sprintf("%s/acme/%s/certificate/1234", baseURL, escProvName))
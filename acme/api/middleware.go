@@ -176,27 +176,8 @@ func (h *Handler) validateJWS(next nextHTTP) nextHTTP {
 			return
 		}
 		hdr := sig.Protected
-		switch hdr.Algorithm {
-		case jose.RS256, jose.RS384, jose.RS512, jose.PS256, jose.PS384, jose.PS512:
-			if hdr.JSONWebKey != nil {
-				switch k := hdr.JSONWebKey.Key.(type) {
-				case *rsa.PublicKey:
-					if k.Size() < keyutil.MinRSAKeyBytes {
-						api.WriteError(w, acme.NewError(acme.ErrorMalformedType,
-							"rsa keys must be at least %d bits (%d bytes) in size",
-							8*keyutil.MinRSAKeyBytes, keyutil.MinRSAKeyBytes))
-						return
-					}
-				default:
-					api.WriteError(w, acme.NewError(acme.ErrorMalformedType,
-						"jws key type and algorithm do not match"))
-					return
-				}
-			}
-		case jose.ES256, jose.ES384, jose.ES512, jose.EdDSA:
-			// we good
-		default:
-			api.WriteError(w, acme.NewError(acme.ErrorBadSignatureAlgorithmType, "unsuitable algorithm: %s", hdr.Algorithm))
+		if acmeErr := validateJWSAlgorithm(hdr); acmeErr != nil {
+			api.WriteError(w, acmeErr)
 			return
 		}
 
@@ -231,6 +212,33 @@ func (h *Handler) validateJWS(next nextHTTP) nextHTTP {
 	}
 }
 
+// validateJWSAlgorithm checks that the algorithm in the protected header is
+// supported and that it matches the JWK, if present.
+func validateJWSAlgorithm(hdr jose.Header) *acme.Error {
+	switch hdr.Algorithm {
+	case jose.RS256, jose.RS384, jose.RS512, jose.PS256, jose.PS384, jose.PS512:
+		if hdr.JSONWebKey != nil {
+			switch k := hdr.JSONWebKey.Key.(type) {
+			case *rsa.PublicKey:
+				if k.Size() < keyutil.MinRSAKeyBytes {
+					return acme.NewError(acme.ErrorMalformedType,
+						"rsa keys must be at least %d bits (%d bytes) in size",
+						8*keyutil.MinRSAKeyBytes, keyutil.MinRSAKeyBytes)
+				}
+			default:
+				return acme.NewError(acme.ErrorMalformedType,
+					"jws key type and algorithm do not match")
+			}
+		}
+		return nil
+	case jose.ES256, jose.ES384, jose.ES512, jose.EdDSA:
+		// we good
+		return nil
+	default:
+		return acme.NewError(acme.ErrorBadSignatureAlgorithmType, "unsuitable algorithm: %s", hdr.Algorithm)
+	}
+}
+
 // extractJWK is a middleware that extracts the JWK from the JWS and saves it
 // in the context. Make sure to parse and validate the JWS before running this
 // middleware.
@@ -352,6 +360,28 @@ func (h *Handler) lookupJWK(next nextHTTP) nextHTTP {
 	}
 }
 
+// extractOrLookupJWK forwards handling to either extractJWK or lookupJWK based
+// on the presence of a JWK or a KID in the protected header of the JWS. Make
+// sure to parse and validate the JWS before running this middleware.
+func (h *Handler) extractOrLookupJWK(next nextHTTP) nextHTTP {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jws, err := jwsFromContext(r.Context())
+		if err != nil {
+			api.WriteError(w, err)
+			return
+		}
+		// A JWK is used when the request is signed with the private key of a
+		// certificate, e.g. on revokeCert requests.
+		if jws.Signatures[0].Protected.JSONWebKey != nil {
+			h.extractJWK(next)(w, r)
+			return
+		}
+		// By default the request is signed by an account and the JWK is
+		// looked up using the KID.
+		h.lookupJWK(next)(w, r)
+	}
+}
+
 // verifyAndExtractJWSPayload extracts the JWK from the JWS and saves it in the context.
 // Make sure to parse and validate the JWS before running this middleware.
 func (h *Handler) verifyAndExtractJWSPayload(next nextHTTP) nextHTTP {
@@ -457,6 +487,20 @@ func jwsFromContext(ctx context.Context) (*jose.JSONWebSignature, error) {
 	return val, nil
 }
 
+// acmeProvisionerFromContext searches the context for an ACME provisioner.
+// Returns a pointer to the ACME provisioner or an error.
+func acmeProvisionerFromContext(ctx context.Context) (*provisioner.ACME, error) {
+	prov, err := provisionerFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	acmeProv, ok := prov.(*provisioner.ACME)
+	if !ok || acmeProv == nil {
+		return nil, acme.NewErrorISE("provisioner in context is not an ACME provisioner")
+	}
+	return acmeProv, nil
+}
+
 // provisionerFromContext searches the context for a provisioner. Returns the
 // provisioner or an error.
 func provisionerFromContext(ctx context.Context) (acme.Provisioner, error) {
@@ -7,12 +7,14 @@ import (
 	"encoding/json"
 	"net"
 	"net/http"
+	"net/mail"
 	"strings"
 	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/smallstep/certificates/acme"
 	"github.com/smallstep/certificates/api"
+	"github.com/smallstep/certificates/authority/provisioner"
 	"go.step.sm/crypto/randutil"
 )
 
@@ -21,6 +23,7 @@ type NewOrderRequest struct {
 	Identifiers []acme.Identifier `json:"identifiers"`
 	NotBefore   time.Time         `json:"notBefore,omitempty"`
 	NotAfter    time.Time         `json:"notAfter,omitempty"`
+	Profile     string            `json:"profile,omitempty"`
 }
 
 // Validate validates a new-order request body.
@@ -28,17 +31,53 @@ func (n *NewOrderRequest) Validate() error {
 	if len(n.Identifiers) == 0 {
 		return acme.NewError(acme.ErrorMalformedType, "identifiers list cannot be empty")
 	}
+	if !n.NotBefore.IsZero() && !n.NotAfter.IsZero() && !n.NotAfter.After(n.NotBefore) {
+		return acme.NewError(acme.ErrorMalformedType, "notAfter must be after notBefore")
+	}
 	for _, id := range n.Identifiers {
-		if !(id.Type == acme.DNS || id.Type == acme.IP) {
+		switch id.Type {
+		case acme.PermanentIdentifier:
+			if id.Value == "" {
+				return acme.NewError(acme.ErrorMalformedType, "permanent identifier cannot be empty")
+			}
+			// Device certificates are issued only for the attested device.
+			if len(n.Identifiers) > 1 {
+				return acme.NewError(acme.ErrorMalformedType, "permanent identifiers cannot be combined with other identifiers")
+			}
+		case acme.DNS:
+		case acme.IP:
+			if net.ParseIP(id.Value) == nil {
+				return acme.NewError(acme.ErrorMalformedType, "invalid IP address: %s", id.Value)
+			}
+		case acme.Email:
+			if addr, err := mail.ParseAddress(id.Value); err != nil || addr.Address != id.Value {
+				return acme.NewError(acme.ErrorMalformedType, "invalid email address: %s", id.Value)
+			}
+		default:
 			return acme.NewError(acme.ErrorMalformedType, "identifier type unsupported: %s", id.Type)
 		}
-		if id.Type == acme.IP && net.ParseIP(id.Value) == nil {
-			return acme.NewError(acme.ErrorMalformedType, "invalid IP address: %s", id.Value)
-		}
 	}
 	return nil
 }
 
+// NewAuthorizationRequest represents the body for a NewAuthz request.
+type NewAuthorizationRequest struct {
+	Identifier acme.Identifier `json:"identifier"`
+}
+
+// Validate validates a new-authz request body. Wildcard identifiers cannot be
+// pre-authorized.
+func (n *NewAuthorizationRequest) Validate() error {
+	switch {
+	case n.Identifier.Value == "":
+		return acme.NewError(acme.ErrorMalformedType, "identifier value cannot be empty")
+	case strings.HasPrefix(n.Identifier.Value, "*."):
+		return acme.NewError(acme.ErrorMalformedType, "wildcard identifiers cannot be pre-authorized: %s", n.Identifier.Value)
+	}
+	nor := &NewOrderRequest{Identifiers: []acme.Identifier{n.Identifier}}
+	return nor.Validate()
+}
+
 // FinalizeRequest captures the body for a Finalize order request.
 type FinalizeRequest struct {
 	CSR string `json:"csr"`
@@ -94,8 +133,48 @@ func (h *Handler) NewOrder(w http.ResponseWriter, r *http.Request) {
 		api.WriteError(w, err)
 		return
 	}
+	if err := h.supportedIdentifiers(prov, nor.Identifiers); err != nil {
+		api.WriteError(w, err)
+		return
+	}
 
+	for _, identifier := range nor.Identifiers {
+		if err := prov.AuthorizeOrderIdentifier(ctx, provisioner.ACMEIdentifier{
+			Type:  provisioner.ACMEIdentifierType(identifier.Type),
+			Value: identifier.Value,
+		}); err != nil {
+			acmeErr := acme.WrapError(acme.ErrorRejectedIdentifierType, err, "error authorizing identifier")
+			acmeErr.Identifier = identifier
+			api.WriteError(w, acmeErr)
+			return
+		}
+	}
+
+	// The requested validity must be within the limits of the profile,
+	// orders without a profile use the provisioner claims.
+	profile, err := prov.GetProfile(nor.Profile)
+	if err != nil {
+		api.WriteError(w, acme.WrapError(acme.ErrorMalformedType, err, "unsupported order profile"))
+		return
+	}
 	now := clock.Now()
+	notBefore, notAfter := nor.NotBefore, nor.NotAfter
+	if notBefore.IsZero() {
+		notBefore = now
+	}
+	if notAfter.IsZero() {
+		notAfter = notBefore.Add(profile.DefaultTLSCertDuration())
+	}
+	if err := validateOrderValidity(profile, now, notBefore, notAfter); err != nil {
+		api.WriteError(w, err)
+		return
+	}
+
+	if err := rateLimits(prov).OrdersPerAccount.Take(ctx, h.db, acc.ID); err != nil {
+		api.WriteError(w, err)
+		return
+	}
+
 	// New order.
 	o := &acme.Order{
 		AccountID:        acc.ID,
@@ -104,15 +183,37 @@ func (h *Handler) NewOrder(w http.ResponseWriter, r *http.Request) {
 		Identifiers:      nor.Identifiers,
 		ExpiresAt:        now.Add(defaultOrderExpiry),
 		AuthorizationIDs: make([]string, len(nor.Identifiers)),
-		NotBefore:        nor.NotBefore,
-		NotAfter:         nor.NotAfter,
+		NotBefore:        notBefore,
+		NotAfter:         notAfter,
+		Profile:          nor.Profile,
 	}
 
+	// Valid authorizations of the same account are reused if the provisioner
+	// allows it, new authorizations are valid for the authorization lifetime.
+	lifetime := authorizationLifetime(prov)
+	azExpiresAt := authorizationExpiry(now, lifetime)
+	reused := 0
 	for i, identifier := range o.Identifiers {
+		if lifetime > 0 {
+			az, err := h.reusableAuthorization(ctx, acc.ID, identifier)
+			if err != nil {
+				api.WriteError(w, err)
+				return
+			}
+			if az != nil {
+				// The order cannot outlive its authorizations.
+				if az.ExpiresAt.Before(o.ExpiresAt) {
+					o.ExpiresAt = az.ExpiresAt
+				}
+				o.AuthorizationIDs[i] = az.ID
+				reused++
+				continue
+			}
+		}
 		az := &acme.Authorization{
 			AccountID:  acc.ID,
 			Identifier: identifier,
-			ExpiresAt:  o.ExpiresAt,
+			ExpiresAt:  azExpiresAt,
 			Status:     acme.StatusPending,
 		}
 		if err := h.newAuthorization(ctx, az); err != nil {
@@ -121,13 +222,10 @@ func (h *Handler) NewOrder(w http.ResponseWriter, r *http.Request) {
 		}
 		o.AuthorizationIDs[i] = az.ID
 	}
-
-	if o.NotBefore.IsZero() {
-		o.NotBefore = now
-	}
-	if o.NotAfter.IsZero() {
-		o.NotAfter = o.NotBefore.Add(prov.DefaultTLSCertDuration())
+	if reused == len(o.Identifiers) {
+		o.Status = acme.StatusReady
 	}
+
 	// If request NotBefore was empty then backdate the order.NotBefore (now)
 	// to avoid timing issues.
 	if nor.NotBefore.IsZero() {
@@ -145,6 +243,171 @@ func (h *Handler) NewOrder(w http.ResponseWriter, r *http.Request) {
 	api.JSONStatus(w, o, http.StatusCreated)
 }
 
+// NewAuthorization ACME api for pre-authorizing an identifier, see RFC 8555,
+// section 7.4.1. Pre-authorization is only enabled if the provisioner reuses
+// valid authorizations, otherwise new orders could not use them. A valid
+// authorization of the account for the same identifier is returned if it
+// exists.
+func (h *Handler) NewAuthorization(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	acc, err := accountFromContext(ctx)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	prov, err := provisionerFromContext(ctx)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	lifetime := authorizationLifetime(prov)
+	if lifetime == 0 {
+		api.WriteError(w, acme.NewError(acme.ErrorNotImplementedType,
+			"pre-authorization is not enabled by provisioner '%s'", prov.GetName()))
+		return
+	}
+	payload, err := payloadFromContext(ctx)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	var nar NewAuthorizationRequest
+	if err := json.Unmarshal(payload.value, &nar); err != nil {
+		api.WriteError(w, acme.WrapError(acme.ErrorMalformedType, err,
+			"failed to unmarshal new-authz request payload"))
+		return
+	}
+	if err := nar.Validate(); err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	if err := h.supportedIdentifiers(prov, []acme.Identifier{nar.Identifier}); err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	if err := prov.AuthorizeOrderIdentifier(ctx, provisioner.ACMEIdentifier{
+		Type:  provisioner.ACMEIdentifierType(nar.Identifier.Type),
+		Value: nar.Identifier.Value,
+	}); err != nil {
+		acmeErr := acme.WrapError(acme.ErrorRejectedIdentifierType, err, "error authorizing identifier")
+		acmeErr.Identifier = nar.Identifier
+		api.WriteError(w, acmeErr)
+		return
+	}
+
+	httpStatus := http.StatusCreated
+	az, err := h.reusableAuthorization(ctx, acc.ID, nar.Identifier)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	if az != nil {
+		httpStatus = http.StatusOK
+	} else {
+		az = &acme.Authorization{
+			AccountID:  acc.ID,
+			Identifier: nar.Identifier,
+			ExpiresAt:  authorizationExpiry(clock.Now(), lifetime),
+			Status:     acme.StatusPending,
+		}
+		if err := h.newAuthorization(ctx, az); err != nil {
+			api.WriteError(w, err)
+			return
+		}
+	}
+
+	h.linker.LinkAuthorization(ctx, az)
+
+	w.Header().Set("Location", h.linker.GetLink(ctx, AuthzLinkType, az.ID))
+	api.JSONStatus(w, az, httpStatus)
+}
+
+// validateOrderValidity checks that the certificate validity requested in a
+// new order is within the limits of the order profile.
+func validateOrderValidity(profile *provisioner.ACMEProfile, now, notBefore, notAfter time.Time) error {
+	min, max := profile.MinTLSCertDuration(), profile.MaxTLSCertDuration()
+	switch d := notAfter.Sub(notBefore); {
+	case !notAfter.After(now):
+		return acme.NewError(acme.ErrorMalformedType, "notAfter cannot be in the past")
+	case d < min:
+		return acme.NewError(acme.ErrorMalformedType,
+			"requested duration of %s is less than the authorized minimum certificate duration of %s", d, min)
+	case d > max:
+		return acme.NewError(acme.ErrorMalformedType,
+			"requested duration of %s is more than the authorized maximum certificate duration of %s", d, max)
+	default:
+		return nil
+	}
+}
+
+// authorizationLifetime returns the time a valid authorization of a
+// provisioner can be reused, 0 if authorizations are not reused.
+func authorizationLifetime(prov acme.Provisioner) time.Duration {
+	if acmeProv, ok := prov.(*provisioner.ACME); ok {
+		return acmeProv.GetAuthorizationLifetime()
+	}
+	return 0
+}
+
+// authorizationExpiry returns the expiration time of a new authorization, new
+// authorizations expire at the same time as a new order, or after the
+// authorization lifetime if it is longer.
+func authorizationExpiry(now time.Time, lifetime time.Duration) time.Time {
+	if lifetime > defaultOrderExpiry {
+		return now.Add(lifetime)
+	}
+	return now.Add(defaultOrderExpiry)
+}
+
+// reusableAuthorization returns the valid authorization of an account for an
+// identifier that expires the latest, or nil if there is none.
+func (h *Handler) reusableAuthorization(ctx context.Context, accID string, identifier acme.Identifier) (*acme.Authorization, error) {
+	azs, err := h.db.GetAuthorizationsByAccountID(ctx, accID, identifier)
+	if err != nil {
+		return nil, acme.WrapErrorISE(err, "error retrieving authorizations")
+	}
+	now := clock.Now()
+	var reuse *acme.Authorization
+	for _, az := range azs {
+		if az.Status != acme.StatusValid || !az.ExpiresAt.After(now) {
+			continue
+		}
+		if reuse == nil || az.ExpiresAt.After(reuse.ExpiresAt) {
+			reuse = az
+		}
+	}
+	return reuse, nil
+}
+
+// supportedIdentifiers checks that the CA is configured to validate the given
+// identifiers, email identifiers require an email transport, and permanent
+// identifiers a provisioner with attestation formats.
+func (h *Handler) supportedIdentifiers(p acme.Provisioner, ids []acme.Identifier) error {
+	for _, id := range ids {
+		var acmeErr *acme.Error
+		switch {
+		case id.Type == acme.Email && h.email == nil:
+			acmeErr = acme.NewError(acme.ErrorUnsupportedIdentifierType,
+				"email identifiers are not supported by this CA")
+		case id.Type == acme.PermanentIdentifier && !attestationEnabled(p):
+			acmeErr = acme.NewError(acme.ErrorUnsupportedIdentifierType,
+				"permanent-identifier identifiers are not supported by this provisioner")
+		}
+		if acmeErr != nil {
+			acmeErr.Identifier = id
+			return acmeErr
+		}
+	}
+	return nil
+}
+
+// attestationEnabled returns true if the provisioner validates
+// device-attest-01 challenges.
+func attestationEnabled(p acme.Provisioner) bool {
+	acmeProv, ok := p.(*provisioner.ACME)
+	return ok && len(acmeProv.AttestationFormats) > 0
+}
+
 func (h *Handler) newAuthorization(ctx context.Context, az *acme.Authorization) error {
 	if strings.HasPrefix(az.Identifier.Value, "*.") {
 		az.Wildcard = true
@@ -170,6 +433,15 @@ func (h *Handler) newAuthorization(ctx context.Context, az *acme.Authorization)
 			Token:     az.Token,
 			Status:    acme.StatusPending,
 		}
+		if typ == acme.EMAILREPLY00 {
+			if h.email == nil {
+				return acme.NewErrorISE("email transport is not configured")
+			}
+			ch.From = h.email.From()
+			if ch.TokenPart1, err = acme.NewEmailTokenPart1(); err != nil {
+				return err
+			}
+		}
 		if err := h.db.CreateChallenge(ctx, ch); err != nil {
 			return acme.WrapErrorISE(err, "error creating challenge")
 		}
@@ -178,6 +450,16 @@ func (h *Handler) newAuthorization(ctx context.Context, az *acme.Authorization)
 	if err = h.db.CreateAuthorization(ctx, az); err != nil {
 		return acme.WrapErrorISE(err, "error creating authorization")
 	}
+
+	// The challenge emails are sent once the authorization is stored, so the
+	// replies always match an existing challenge.
+	for _, ch := range az.Challenges {
+		if ch.Type == acme.EMAILREPLY00 {
+			if err := acme.SendEmailChallenge(ctx, h.email, ch); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
@@ -264,10 +546,32 @@ func (h *Handler) FinalizeOrder(w http.ResponseWriter, r *http.Request) {
 			"provisioner '%s' does not own order '%s'", prov.GetID(), o.ID))
 		return
 	}
-	if err = o.Finalize(ctx, h.db, fr.csr, h.ca, prov); err != nil {
+
+	// Certificates are only counted when they are issued, finalizing an
+	// order that is already valid returns the existing certificate.
+	limit := rateLimits(prov).CertificatesPerDomain
+	issue := o.Status != acme.StatusValid
+	domains := registeredDomains(o)
+	if issue {
+		for _, domain := range domains {
+			if err := limit.Check(ctx, h.db, domain); err != nil {
+				api.WriteError(w, err)
+				return
+			}
+		}
+	}
+	if err = o.Finalize(ctx, h.db, fr.csr, h.ca, prov, h.caaOptions(ctx, prov, acc)); err != nil {
 		api.WriteError(w, acme.WrapErrorISE(err, "error finalizing order"))
 		return
 	}
+	if issue && o.Status == acme.StatusValid {
+		for _, domain := range domains {
+			if err := limit.Record(ctx, h.db, domain); err != nil {
+				api.WriteError(w, err)
+				return
+			}
+		}
+	}
 
 	h.linker.LinkOrder(ctx, o)
 
@@ -275,6 +579,41 @@ func (h *Handler) FinalizeOrder(w http.ResponseWriter, r *http.Request) {
 	api.JSON(w, o)
 }
 
+// registeredDomains returns the unique registered domains of the DNS
+// identifiers of an order.
+func registeredDomains(o *acme.Order) []string {
+	var domains []string
+	seen := make(map[string]bool)
+	for _, identifier := range o.Identifiers {
+		if identifier.Type != acme.DNS {
+			continue
+		}
+		domain := acme.RegisteredDomain(identifier.Value)
+		if !seen[domain] {
+			seen[domain] = true
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
+
+// caaOptions returns the options used to check the CAA records of an order,
+// or nil if the provisioner does not require CAA checks.
+func (h *Handler) caaOptions(ctx context.Context, prov acme.Provisioner, acc *acme.Account) *acme.CAAOptions {
+	acmeProv, ok := prov.(*provisioner.ACME)
+	if !ok || acmeProv.CAA == nil {
+		return nil
+	}
+	resolver := acmeProv.CAA.Resolver
+	return &acme.CAAOptions{
+		IssuerDomainNames: acmeProv.CAA.IssuerDomainNames,
+		AccountURI:        h.linker.GetLink(ctx, AccountLinkType, acc.ID),
+		LookupCAA: func(ctx context.Context, name string) ([]*acme.CAA, error) {
+			return h.lookupCAA(ctx, resolver, name)
+		},
+	}
+}
+
 // challengeTypes determines the types of challenges that should be used
 // for the ACME authorization request.
 func challengeTypes(az *acme.Authorization) []acme.ChallengeType {
@@ -289,6 +628,10 @@ func challengeTypes(az *acme.Authorization) []acme.ChallengeType {
 		if !az.Wildcard {
 			chTypes = append(chTypes, []acme.ChallengeType{acme.HTTP01, acme.TLSALPN01}...)
 		}
+	case acme.Email:
+		chTypes = []acme.ChallengeType{acme.EMAILREPLY00}
+	case acme.PermanentIdentifier:
+		chTypes = []acme.ChallengeType{acme.DEVICEATTEST01}
 	default:
 		chTypes = []acme.ChallengeType{}
 	}
@@ -18,6 +18,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
 	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/authority/provisioner"
 	"go.step.sm/crypto/pemutil"
 )
 
@@ -45,6 +46,71 @@ func TestNewOrderRequest_Validate(t *testing.T) {
 				err: acme.NewError(acme.ErrorMalformedType, "identifier type unsupported: foo"),
 			}
 		},
+		"fail/naf-before-nbf": func(t *testing.T) test {
+			nbf := time.Now().UTC().Add(5 * time.Minute)
+			naf := time.Now().UTC().Add(time.Minute)
+			return test{
+				nor: &NewOrderRequest{
+					Identifiers: []acme.Identifier{
+						{Type: "dns", Value: "example.com"},
+					},
+					NotAfter:  naf,
+					NotBefore: nbf,
+				},
+				nbf: nbf,
+				naf: naf,
+				err: acme.NewError(acme.ErrorMalformedType, "notAfter must be after notBefore"),
+			}
+		},
+		"fail/bad-email": func(t *testing.T) test {
+			return test{
+				nor: &NewOrderRequest{
+					Identifiers: []acme.Identifier{
+						{Type: "email", Value: "Jane <jane@example.com>"},
+					},
+				},
+				err: acme.NewError(acme.ErrorMalformedType, "invalid email address: %s", "Jane <jane@example.com>"),
+			}
+		},
+		"ok/email": func(t *testing.T) test {
+			return test{
+				nor: &NewOrderRequest{
+					Identifiers: []acme.Identifier{
+						{Type: "email", Value: "jane@example.com"},
+					},
+				},
+			}
+		},
+		"fail/empty-permanent-identifier": func(t *testing.T) test {
+			return test{
+				nor: &NewOrderRequest{
+					Identifiers: []acme.Identifier{
+						{Type: "permanent-identifier", Value: ""},
+					},
+				},
+				err: acme.NewError(acme.ErrorMalformedType, "permanent identifier cannot be empty"),
+			}
+		},
+		"fail/permanent-identifier-combined": func(t *testing.T) test {
+			return test{
+				nor: &NewOrderRequest{
+					Identifiers: []acme.Identifier{
+						{Type: "dns", Value: "example.com"},
+						{Type: "permanent-identifier", Value: "123456"},
+					},
+				},
+				err: acme.NewError(acme.ErrorMalformedType, "permanent identifiers cannot be combined with other identifiers"),
+			}
+		},
+		"ok/permanent-identifier": func(t *testing.T) test {
+			return test{
+				nor: &NewOrderRequest{
+					Identifiers: []acme.Identifier{
+						{Type: "permanent-identifier", Value: "123456"},
+					},
+				},
+			}
+		},
 		"fail/bad-ip": func(t *testing.T) test {
 			nbf := time.Now().UTC().Add(time.Minute)
 			naf := time.Now().UTC().Add(5 * time.Minute)
@@ -152,19 +218,11 @@ func TestNewOrderRequest_Validate(t *testing.T) {
 					assert.Equals(t, ae.Type, tc.err.Type)
 				}
 			} else {
+				// The validity is not defaulted, orders without it use the
+				// one of the profile.
 				if assert.Nil(t, tc.err) {
-					if tc.nbf.IsZero() {
-						assert.True(t, tc.nor.NotBefore.Before(time.Now().Add(time.Minute)))
-						assert.True(t, tc.nor.NotBefore.After(time.Now().Add(-time.Minute)))
-					} else {
-						assert.Equals(t, tc.nor.NotBefore, tc.nbf)
-					}
-					if tc.naf.IsZero() {
-						assert.True(t, tc.nor.NotAfter.Before(time.Now().Add(24*time.Hour)))
-						assert.True(t, tc.nor.NotAfter.After(time.Now().Add(24*time.Hour-time.Minute)))
-					} else {
-						assert.Equals(t, tc.nor.NotAfter, tc.naf)
-					}
+					assert.Equals(t, tc.nor.NotBefore, tc.nbf)
+					assert.Equals(t, tc.nor.NotAfter, tc.naf)
 				}
 			}
 		})
@@ -455,11 +513,30 @@ func TestHandler_GetOrder(t *testing.T) {
 	}
 }
 
+type mockEmailTransport struct {
+	to  string
+	msg []byte
+	err error
+}
+
+func (m *mockEmailTransport) From() string { return "acme@ca.smallstep.com" }
+
+func (m *mockEmailTransport) Send(ctx context.Context, to string, msg []byte) error {
+	m.to, m.msg = to, msg
+	return m.err
+}
+
+func (m *mockEmailTransport) Replies(ctx context.Context, from string) ([][]byte, error) {
+	return nil, m.err
+}
+
 func TestHandler_newAuthorization(t *testing.T) {
 	type test struct {
-		az  *acme.Authorization
-		db  acme.DB
-		err *acme.Error
+		az    *acme.Authorization
+		db    acme.DB
+		email acme.EmailTransport
+		check func(t *testing.T)
+		err   *acme.Error
 	}
 	var tests = map[string]func(t *testing.T) test{
 		"fail/error-db.CreateChallenge": func(t *testing.T) test {
@@ -632,11 +709,73 @@ func TestHandler_newAuthorization(t *testing.T) {
 				az: az,
 			}
 		},
+		"fail/email-not-configured": func(t *testing.T) test {
+			return test{
+				az: &acme.Authorization{
+					AccountID:  "accID",
+					Identifier: acme.Identifier{Type: "email", Value: "jane@example.com"},
+				},
+				err: acme.NewErrorISE("email transport is not configured"),
+			}
+		},
+		"fail/error-send-email": func(t *testing.T) test {
+			return test{
+				db: &acme.MockDB{
+					MockCreateChallenge: func(ctx context.Context, ch *acme.Challenge) error {
+						return nil
+					},
+					MockCreateAuthorization: func(ctx context.Context, _az *acme.Authorization) error {
+						return nil
+					},
+				},
+				email: &mockEmailTransport{err: errors.New("force")},
+				az: &acme.Authorization{
+					AccountID:  "accID",
+					Identifier: acme.Identifier{Type: "email", Value: "jane@example.com"},
+				},
+				err: acme.NewErrorISE("error sending challenge email to jane@example.com: force"),
+			}
+		},
+		"ok/email": func(t *testing.T) test {
+			az := &acme.Authorization{
+				AccountID:  "accID",
+				Identifier: acme.Identifier{Type: "email", Value: "jane@example.com"},
+				Status:     acme.StatusPending,
+			}
+			et := new(mockEmailTransport)
+			var ch1 *acme.Challenge
+			return test{
+				db: &acme.MockDB{
+					MockCreateChallenge: func(ctx context.Context, ch *acme.Challenge) error {
+						ch.ID = "email"
+						assert.Equals(t, ch.Type, acme.EMAILREPLY00)
+						assert.Equals(t, ch.Token, az.Token)
+						assert.Equals(t, ch.Value, "jane@example.com")
+						assert.Equals(t, ch.From, "acme@ca.smallstep.com")
+						assert.Equals(t, len(ch.TokenPart1), 32)
+						ch1 = ch
+						return nil
+					},
+					MockCreateAuthorization: func(ctx context.Context, _az *acme.Authorization) error {
+						assert.Equals(t, _az.Challenges, []*acme.Challenge{ch1})
+						// The email is sent after the authorization is stored.
+						assert.Equals(t, et.to, "")
+						return nil
+					},
+				},
+				email: et,
+				az:    az,
+				check: func(t *testing.T) {
+					assert.Equals(t, et.to, "jane@example.com")
+					assert.True(t, bytes.Contains(et.msg, []byte("Subject: ACME: "+ch1.TokenPart1+"\r\n")))
+				},
+			}
+		},
 	}
 	for name, run := range tests {
 		t.Run(name, func(t *testing.T) {
 			tc := run(t)
-			h := &Handler{db: tc.db}
+			h := &Handler{db: tc.db, email: tc.email}
 			if err := h.newAuthorization(context.Background(), tc.az); err != nil {
 				if assert.NotNil(t, tc.err) {
 					switch k := err.(type) {
@@ -652,12 +791,32 @@ func TestHandler_newAuthorization(t *testing.T) {
 				}
 			} else {
 				assert.Nil(t, tc.err)
+				if tc.check != nil {
+					tc.check(t)
+				}
 			}
 		})
 
 	}
 }
 
+// assertErrorIdentifier checks the identifier of an ACME error decoded from a
+// response, where it is a map.
+func assertErrorIdentifier(t *testing.T, got, want interface{}) {
+	t.Helper()
+	if want == nil {
+		assert.Nil(t, got)
+		return
+	}
+	id, ok := want.(acme.Identifier)
+	assert.Fatal(t, ok, "expected identifier is not an acme.Identifier")
+	m, ok := got.(map[string]interface{})
+	if assert.True(t, ok, "identifier is not an object") {
+		assert.Equals(t, m["type"], string(id.Type))
+		assert.Equals(t, m["value"], id.Value)
+	}
+}
+
 func TestHandler_NewOrder(t *testing.T) {
 	// Request with chi context
 	prov := newProv()
@@ -671,6 +830,7 @@ func TestHandler_NewOrder(t *testing.T) {
 		ctx        context.Context
 		nor        *NewOrderRequest
 		statusCode int
+		retryAfter string
 		vr         func(t *testing.T, o *acme.Order)
 		err        *acme.Error
 	}
@@ -756,6 +916,169 @@ func TestHandler_NewOrder(t *testing.T) {
 				err:        acme.NewError(acme.ErrorMalformedType, "identifiers list cannot be empty"),
 			}
 		},
+		"fail/rejected-identifier": func(t *testing.T) test {
+			acc := &acme.Account{ID: "accID"}
+			p := &provisioner.ACME{
+				Type: "ACME",
+				Name: "test@acme-<test>provisioner.com",
+				Policy: &provisioner.ACMEPolicy{
+					Allow: &provisioner.ACMEIdentifierRules{DNSNames: []string{"*.internal"}},
+					Deny:  &provisioner.ACMEIdentifierRules{DNSNames: []string{"*.secret.internal"}},
+				},
+			}
+			assert.FatalError(t, p.Init(provisioner.Config{Claims: globalProvisionerClaims}))
+			fr := &NewOrderRequest{
+				Identifiers: []acme.Identifier{
+					{Type: "dns", Value: "zap.internal"},
+					{Type: "dns", Value: "zap.secret.internal"},
+				},
+			}
+			b, err := json.Marshal(fr)
+			assert.FatalError(t, err)
+			ctx := context.WithValue(context.Background(), provisionerContextKey, p)
+			ctx = context.WithValue(ctx, accContextKey, acc)
+			ctx = context.WithValue(ctx, payloadContextKey, &payloadInfo{value: b})
+			acmeErr := acme.NewError(acme.ErrorRejectedIdentifierType, "error authorizing identifier: identifier zap.secret.internal is denied by the provisioner policy")
+			acmeErr.Identifier = fr.Identifiers[1]
+			return test{
+				ctx:        ctx,
+				statusCode: 400,
+				err:        acmeErr,
+			}
+		},
+		"fail/email-not-supported": func(t *testing.T) test {
+			acc := &acme.Account{ID: "accID"}
+			nor := &NewOrderRequest{
+				Identifiers: []acme.Identifier{
+					{Type: "email", Value: "jane@example.com"},
+				},
+			}
+			b, err := json.Marshal(nor)
+			assert.FatalError(t, err)
+			ctx := context.WithValue(context.Background(), provisionerContextKey, prov)
+			ctx = context.WithValue(ctx, accContextKey, acc)
+			ctx = context.WithValue(ctx, payloadContextKey, &payloadInfo{value: b})
+			acmeErr := acme.NewError(acme.ErrorUnsupportedIdentifierType, "email identifiers are not supported by this CA")
+			acmeErr.Identifier = nor.Identifiers[0]
+			return test{
+				ctx:        ctx,
+				statusCode: 400,
+				err:        acmeErr,
+			}
+		},
+		"fail/permanent-identifier-not-supported": func(t *testing.T) test {
+			acc := &acme.Account{ID: "accID"}
+			nor := &NewOrderRequest{
+				Identifiers: []acme.Identifier{
+					{Type: "permanent-identifier", Value: "123456"},
+				},
+			}
+			b, err := json.Marshal(nor)
+			assert.FatalError(t, err)
+			ctx := context.WithValue(context.Background(), provisionerContextKey, prov)
+			ctx = context.WithValue(ctx, accContextKey, acc)
+			ctx = context.WithValue(ctx, payloadContextKey, &payloadInfo{value: b})
+			acmeErr := acme.NewError(acme.ErrorUnsupportedIdentifierType, "permanent-identifier identifiers are not supported by this provisioner")
+			acmeErr.Identifier = nor.Identifiers[0]
+			return test{
+				ctx:        ctx,
+				statusCode: 400,
+				err:        acmeErr,
+			}
+		},
+		"fail/unknown-profile": func(t *testing.T) test {
+			acc := &acme.Account{ID: "accID"}
+			nor := &NewOrderRequest{
+				Identifiers: []acme.Identifier{
+					{Type: "dns", Value: "zap.internal"},
+				},
+				Profile: "client",
+			}
+			b, err := json.Marshal(nor)
+			assert.FatalError(t, err)
+			ctx := context.WithValue(context.Background(), provisionerContextKey, prov)
+			ctx = context.WithValue(ctx, accContextKey, acc)
+			ctx = context.WithValue(ctx, payloadContextKey, &payloadInfo{value: b})
+			return test{
+				ctx:        ctx,
+				statusCode: 400,
+				err:        acme.NewError(acme.ErrorMalformedType, "unsupported order profile: profile client not found"),
+			}
+		},
+		"fail/duration-too-long": func(t *testing.T) test {
+			now := clock.Now()
+			acc := &acme.Account{ID: "accID"}
+			nor := &NewOrderRequest{
+				Identifiers: []acme.Identifier{
+					{Type: "dns", Value: "zap.internal"},
+				},
+				NotBefore: now,
+				NotAfter:  now.Add(48 * time.Hour),
+			}
+			b, err := json.Marshal(nor)
+			assert.FatalError(t, err)
+			ctx := context.WithValue(context.Background(), provisionerContextKey, prov)
+			ctx = context.WithValue(ctx, accContextKey, acc)
+			ctx = context.WithValue(ctx, payloadContextKey, &payloadInfo{value: b})
+			return test{
+				ctx:        ctx,
+				statusCode: 400,
+				err:        acme.NewError(acme.ErrorMalformedType, "requested duration of 48h0m0s is more than the authorized maximum certificate duration of 24h0m0s"),
+			}
+		},
+		"fail/duration-too-short": func(t *testing.T) test {
+			now := clock.Now()
+			acc := &acme.Account{ID: "accID"}
+			nor := &NewOrderRequest{
+				Identifiers: []acme.Identifier{
+					{Type: "dns", Value: "zap.internal"},
+				},
+				NotAfter: now.Add(time.Minute),
+			}
+			b, err := json.Marshal(nor)
+			assert.FatalError(t, err)
+			ctx := context.WithValue(context.Background(), provisionerContextKey, prov)
+			ctx = context.WithValue(ctx, accContextKey, acc)
+			ctx = context.WithValue(ctx, payloadContextKey, &payloadInfo{value: b})
+			return test{
+				ctx:        ctx,
+				statusCode: 400,
+				err:        acme.NewError(acme.ErrorMalformedType, "requested duration of 1m0s is less than the authorized minimum certificate duration of 5m0s"),
+			}
+		},
+		"fail/rate-limited": func(t *testing.T) test {
+			acc := &acme.Account{ID: "accID"}
+			p := &provisioner.ACME{
+				Type: "ACME",
+				Name: "test@acme-<test>provisioner.com",
+				RateLimits: &provisioner.ACMERateLimits{
+					OrdersPerAccount: &provisioner.ACMERateLimit{Limit: 1},
+				},
+			}
+			assert.FatalError(t, p.Init(provisioner.Config{Claims: globalProvisionerClaims}))
+			fr := &NewOrderRequest{
+				Identifiers: []acme.Identifier{
+					{Type: "dns", Value: "zap.internal"},
+				},
+			}
+			b, err := json.Marshal(fr)
+			assert.FatalError(t, err)
+			ctx := context.WithValue(context.Background(), provisionerContextKey, p)
+			ctx = context.WithValue(ctx, accContextKey, acc)
+			ctx = context.WithValue(ctx, payloadContextKey, &payloadInfo{value: b})
+			return test{
+				db: &acme.MockDB{
+					MockGetRateLimitEvents: func(ctx context.Context, key string) ([]time.Time, error) {
+						assert.Equals(t, key, "acme/test@acme-<test>provisioner.com/orders/accID")
+						return []time.Time{clock.Now().Add(-59 * time.Minute)}, nil
+					},
+				},
+				ctx:        ctx,
+				statusCode: 429,
+				retryAfter: "60",
+				err:        acme.NewError(acme.ErrorRateLimitedType, "orders rate limit exceeded for accID: 1 every 1h0m0s"),
+			}
+		},
 		"fail/error-h.newAuthorization": func(t *testing.T) test {
 			acc := &acme.Account{ID: "accID"}
 			fr := &NewOrderRequest{
@@ -1058,66 +1381,179 @@ func TestHandler_NewOrder(t *testing.T) {
 				},
 			}
 		},
-		"ok/nbf-no-naf": func(t *testing.T) test {
+		"ok/reused-authorization": func(t *testing.T) test {
 			now := clock.Now()
-			expNbf := now.Add(10 * time.Minute)
+			p := &provisioner.ACME{
+				Type:                  "ACME",
+				Name:                  "test@acme-<test>provisioner.com",
+				AuthorizationLifetime: &provisioner.Duration{Duration: 720 * time.Hour},
+			}
+			assert.FatalError(t, p.Init(provisioner.Config{Claims: globalProvisionerClaims}))
 			acc := &acme.Account{ID: "accID"}
 			nor := &NewOrderRequest{
 				Identifiers: []acme.Identifier{
 					{Type: "dns", Value: "zap.internal"},
+					{Type: "dns", Value: "*.zar.internal"},
 				},
-				NotBefore: expNbf,
 			}
 			b, err := json.Marshal(nor)
 			assert.FatalError(t, err)
-			ctx := context.WithValue(context.Background(), provisionerContextKey, prov)
+			ctx := context.WithValue(context.Background(), provisionerContextKey, p)
 			ctx = context.WithValue(ctx, accContextKey, acc)
 			ctx = context.WithValue(ctx, payloadContextKey, &payloadInfo{value: b})
 			ctx = context.WithValue(ctx, baseURLContextKey, baseURL)
-			var (
-				ch1, ch2, ch3 **acme.Challenge
-				az1ID         *string
-				count         = 0
-			)
 			return test{
 				ctx:        ctx,
 				statusCode: 201,
 				nor:        nor,
 				db: &acme.MockDB{
-					MockCreateChallenge: func(ctx context.Context, ch *acme.Challenge) error {
-						switch count {
-						case 0:
-							ch.ID = "dns"
-							assert.Equals(t, ch.Type, acme.DNS01)
-							ch1 = &ch
-						case 1:
-							ch.ID = "http"
-							assert.Equals(t, ch.Type, acme.HTTP01)
-							ch2 = &ch
-						case 2:
-							ch.ID = "tls"
-							assert.Equals(t, ch.Type, acme.TLSALPN01)
-							ch3 = &ch
-						default:
-							assert.FatalError(t, errors.New("test logic error"))
-							return errors.New("force")
+					MockGetAuthorizationsByAccountID: func(ctx context.Context, accID string, identifier acme.Identifier) ([]*acme.Authorization, error) {
+						assert.Equals(t, accID, "accID")
+						if identifier.Value == "*.zar.internal" {
+							return []*acme.Authorization{
+								{ID: "invalid", Status: acme.StatusInvalid, ExpiresAt: now.Add(time.Hour)},
+							}, nil
 						}
-						count++
-						assert.Equals(t, ch.AccountID, "accID")
-						assert.NotEquals(t, ch.Token, "")
-						assert.Equals(t, ch.Status, acme.StatusPending)
-						assert.Equals(t, ch.Value, "zap.internal")
+						assert.Equals(t, identifier, nor.Identifiers[0])
+						return []*acme.Authorization{
+							{ID: "expired", Status: acme.StatusValid, ExpiresAt: now.Add(-time.Hour)},
+							{ID: "az1ID", Status: acme.StatusValid, ExpiresAt: now.Add(2 * time.Hour)},
+							{ID: "pending", Status: acme.StatusPending, ExpiresAt: now.Add(3 * time.Hour)},
+						}, nil
+					},
+					MockCreateChallenge: func(ctx context.Context, ch *acme.Challenge) error {
+						assert.Equals(t, ch.Value, "zar.internal")
 						return nil
 					},
 					MockCreateAuthorization: func(ctx context.Context, az *acme.Authorization) error {
-						az.ID = "az1ID"
-						az1ID = &az.ID
-						assert.Equals(t, az.AccountID, "accID")
-						assert.NotEquals(t, az.Token, "")
-						assert.Equals(t, az.Status, acme.StatusPending)
-						assert.Equals(t, az.Identifier, nor.Identifiers[0])
-						assert.Equals(t, az.Challenges, []*acme.Challenge{*ch1, *ch2, *ch3})
-						assert.Equals(t, az.Wildcard, false)
+						az.ID = "az2ID"
+						assert.Equals(t, az.Identifier, acme.Identifier{Type: "dns", Value: "zar.internal"})
+						assert.Equals(t, az.Wildcard, true)
+						assert.True(t, az.ExpiresAt.After(now.Add(719*time.Hour)))
+						return nil
+					},
+					MockCreateOrder: func(ctx context.Context, o *acme.Order) error {
+						o.ID = "ordID"
+						assert.Equals(t, o.Status, acme.StatusPending)
+						assert.Equals(t, o.AuthorizationIDs, []string{"az1ID", "az2ID"})
+						assert.Equals(t, o.ExpiresAt, now.Add(2*time.Hour))
+						return nil
+					},
+				},
+				vr: func(t *testing.T, o *acme.Order) {
+					assert.Equals(t, o.ID, "ordID")
+					assert.Equals(t, o.Status, acme.StatusPending)
+					assert.Equals(t, o.AuthorizationURLs, []string{
+						fmt.Sprintf("%s/acme/%s/authz/az1ID", baseURL.String(), escProvName),
+						fmt.Sprintf("%s/acme/%s/authz/az2ID", baseURL.String(), escProvName),
+					})
+				},
+			}
+		},
+		"ok/all-authorizations-reused": func(t *testing.T) test {
+			now := clock.Now()
+			p := &provisioner.ACME{
+				Type:                  "ACME",
+				Name:                  "test@acme-<test>provisioner.com",
+				AuthorizationLifetime: &provisioner.Duration{Duration: 720 * time.Hour},
+			}
+			assert.FatalError(t, p.Init(provisioner.Config{Claims: globalProvisionerClaims}))
+			acc := &acme.Account{ID: "accID"}
+			nor := &NewOrderRequest{
+				Identifiers: []acme.Identifier{
+					{Type: "dns", Value: "zap.internal"},
+				},
+			}
+			b, err := json.Marshal(nor)
+			assert.FatalError(t, err)
+			ctx := context.WithValue(context.Background(), provisionerContextKey, p)
+			ctx = context.WithValue(ctx, accContextKey, acc)
+			ctx = context.WithValue(ctx, payloadContextKey, &payloadInfo{value: b})
+			ctx = context.WithValue(ctx, baseURLContextKey, baseURL)
+			return test{
+				ctx:        ctx,
+				statusCode: 201,
+				nor:        nor,
+				db: &acme.MockDB{
+					MockGetAuthorizationsByAccountID: func(ctx context.Context, accID string, identifier acme.Identifier) ([]*acme.Authorization, error) {
+						return []*acme.Authorization{
+							{ID: "az1ID", Status: acme.StatusValid, ExpiresAt: now.Add(600 * time.Hour)},
+						}, nil
+					},
+					MockCreateOrder: func(ctx context.Context, o *acme.Order) error {
+						o.ID = "ordID"
+						assert.Equals(t, o.Status, acme.StatusReady)
+						assert.Equals(t, o.AuthorizationIDs, []string{"az1ID"})
+						assert.True(t, o.ExpiresAt.After(now.Add(defaultOrderExpiry-time.Minute)))
+						assert.True(t, o.ExpiresAt.Before(now.Add(defaultOrderExpiry+time.Minute)))
+						return nil
+					},
+				},
+				vr: func(t *testing.T, o *acme.Order) {
+					assert.Equals(t, o.Status, acme.StatusReady)
+				},
+			}
+		},
+		"ok/nbf-no-naf": func(t *testing.T) test {
+			now := clock.Now()
+			expNbf := now.Add(10 * time.Minute)
+			acc := &acme.Account{ID: "accID"}
+			nor := &NewOrderRequest{
+				Identifiers: []acme.Identifier{
+					{Type: "dns", Value: "zap.internal"},
+				},
+				NotBefore: expNbf,
+			}
+			b, err := json.Marshal(nor)
+			assert.FatalError(t, err)
+			ctx := context.WithValue(context.Background(), provisionerContextKey, prov)
+			ctx = context.WithValue(ctx, accContextKey, acc)
+			ctx = context.WithValue(ctx, payloadContextKey, &payloadInfo{value: b})
+			ctx = context.WithValue(ctx, baseURLContextKey, baseURL)
+			var (
+				ch1, ch2, ch3 **acme.Challenge
+				az1ID         *string
+				count         = 0
+			)
+			return test{
+				ctx:        ctx,
+				statusCode: 201,
+				nor:        nor,
+				db: &acme.MockDB{
+					MockCreateChallenge: func(ctx context.Context, ch *acme.Challenge) error {
+						switch count {
+						case 0:
+							ch.ID = "dns"
+							assert.Equals(t, ch.Type, acme.DNS01)
+							ch1 = &ch
+						case 1:
+							ch.ID = "http"
+							assert.Equals(t, ch.Type, acme.HTTP01)
+							ch2 = &ch
+						case 2:
+							ch.ID = "tls"
+							assert.Equals(t, ch.Type, acme.TLSALPN01)
+							ch3 = &ch
+						default:
+							assert.FatalError(t, errors.New("test logic error"))
+							return errors.New("force")
+						}
+						count++
+						assert.Equals(t, ch.AccountID, "accID")
+						assert.NotEquals(t, ch.Token, "")
+						assert.Equals(t, ch.Status, acme.StatusPending)
+						assert.Equals(t, ch.Value, "zap.internal")
+						return nil
+					},
+					MockCreateAuthorization: func(ctx context.Context, az *acme.Authorization) error {
+						az.ID = "az1ID"
+						az1ID = &az.ID
+						assert.Equals(t, az.AccountID, "accID")
+						assert.NotEquals(t, az.Token, "")
+						assert.Equals(t, az.Status, acme.StatusPending)
+						assert.Equals(t, az.Identifier, nor.Identifiers[0])
+						assert.Equals(t, az.Challenges, []*acme.Challenge{*ch1, *ch2, *ch3})
+						assert.Equals(t, az.Wildcard, false)
 						return nil
 					},
 					MockCreateOrder: func(ctx context.Context, o *acme.Order) error {
@@ -1149,6 +1585,65 @@ func TestHandler_NewOrder(t *testing.T) {
 				},
 			}
 		},
+		"ok/profile": func(t *testing.T) test {
+			now := clock.Now()
+			p := &provisioner.ACME{
+				Type: "ACME",
+				Name: "test@acme-<test>provisioner.com",
+				Profiles: []*provisioner.ACMEProfile{{
+					Name: "client",
+					Claims: &provisioner.Claims{
+						DefaultTLSDur: &provisioner.Duration{Duration: 72 * time.Hour},
+						MaxTLSDur:     &provisioner.Duration{Duration: 168 * time.Hour},
+					},
+				}},
+			}
+			assert.FatalError(t, p.Init(provisioner.Config{Claims: globalProvisionerClaims}))
+			acc := &acme.Account{ID: "accID"}
+			nor := &NewOrderRequest{
+				Identifiers: []acme.Identifier{
+					{Type: "dns", Value: "zap.internal"},
+				},
+				Profile: "client",
+			}
+			b, err := json.Marshal(nor)
+			assert.FatalError(t, err)
+			ctx := context.WithValue(context.Background(), provisionerContextKey, p)
+			ctx = context.WithValue(ctx, accContextKey, acc)
+			ctx = context.WithValue(ctx, payloadContextKey, &payloadInfo{value: b})
+			ctx = context.WithValue(ctx, baseURLContextKey, baseURL)
+			return test{
+				ctx:        ctx,
+				statusCode: 201,
+				nor:        nor,
+				db: &acme.MockDB{
+					MockCreateChallenge: func(ctx context.Context, ch *acme.Challenge) error {
+						return nil
+					},
+					MockCreateAuthorization: func(ctx context.Context, az *acme.Authorization) error {
+						az.ID = "az1ID"
+						return nil
+					},
+					MockCreateOrder: func(ctx context.Context, o *acme.Order) error {
+						o.ID = "ordID"
+						assert.Equals(t, o.Profile, "client")
+						return nil
+					},
+				},
+				vr: func(t *testing.T, o *acme.Order) {
+					testBufferDur := 5 * time.Second
+					expNbf := now.Add(-defaultOrderBackdate)
+					expNaf := now.Add(72 * time.Hour)
+
+					assert.Equals(t, o.ID, "ordID")
+					assert.Equals(t, o.Profile, "client")
+					assert.True(t, o.NotBefore.Add(-testBufferDur).Before(expNbf))
+					assert.True(t, o.NotBefore.Add(testBufferDur).After(expNbf))
+					assert.True(t, o.NotAfter.Add(-testBufferDur).Before(expNaf))
+					assert.True(t, o.NotAfter.Add(testBufferDur).After(expNaf))
+				},
+			}
+		},
 		"ok/naf-no-nbf": func(t *testing.T) test {
 			now := clock.Now()
 			expNaf := now.Add(15 * time.Minute)
@@ -1353,9 +1848,12 @@ func TestHandler_NewOrder(t *testing.T) {
 
 				assert.Equals(t, ae.Type, tc.err.Type)
 				assert.Equals(t, ae.Detail, tc.err.Detail)
-				assert.Equals(t, ae.Identifier, tc.err.Identifier)
+				assertErrorIdentifier(t, ae.Identifier, tc.err.Identifier)
 				assert.Equals(t, ae.Subproblems, tc.err.Subproblems)
 				assert.Equals(t, res.Header["Content-Type"], []string{"application/problem+json"})
+				if tc.retryAfter != "" {
+					assert.Equals(t, res.Header["Retry-After"], []string{tc.retryAfter})
+				}
 			} else {
 				ro := new(acme.Order)
 				assert.FatalError(t, json.Unmarshal(body, ro))
@@ -1370,6 +1868,245 @@ func TestHandler_NewOrder(t *testing.T) {
 	}
 }
 
+func TestNewAuthorizationRequest_Validate(t *testing.T) {
+	type test struct {
+		nar *NewAuthorizationRequest
+		err *acme.Error
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/empty-value": func(t *testing.T) test {
+			return test{
+				nar: &NewAuthorizationRequest{Identifier: acme.Identifier{Type: "dns"}},
+				err: acme.NewError(acme.ErrorMalformedType, "identifier value cannot be empty"),
+			}
+		},
+		"fail/wildcard": func(t *testing.T) test {
+			return test{
+				nar: &NewAuthorizationRequest{Identifier: acme.Identifier{Type: "dns", Value: "*.example.com"}},
+				err: acme.NewError(acme.ErrorMalformedType, "wildcard identifiers cannot be pre-authorized: *.example.com"),
+			}
+		},
+		"fail/bad-identifier": func(t *testing.T) test {
+			return test{
+				nar: &NewAuthorizationRequest{Identifier: acme.Identifier{Type: "foo", Value: "bar.com"}},
+				err: acme.NewError(acme.ErrorMalformedType, "identifier type unsupported: foo"),
+			}
+		},
+		"ok/dns": func(t *testing.T) test {
+			return test{
+				nar: &NewAuthorizationRequest{Identifier: acme.Identifier{Type: "dns", Value: "example.com"}},
+			}
+		},
+		"ok/ip": func(t *testing.T) test {
+			return test{
+				nar: &NewAuthorizationRequest{Identifier: acme.Identifier{Type: "ip", Value: "192.168.42.42"}},
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			if err := tc.nar.Validate(); err != nil {
+				if assert.NotNil(t, tc.err) {
+					ae, ok := err.(*acme.Error)
+					assert.True(t, ok)
+					assert.HasPrefix(t, ae.Error(), tc.err.Error())
+					assert.Equals(t, ae.StatusCode(), tc.err.StatusCode())
+					assert.Equals(t, ae.Type, tc.err.Type)
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+func TestHandler_NewAuthorization(t *testing.T) {
+	baseURL := &url.URL{Scheme: "https", Host: "test.ca.smallstep.com"}
+	acc := &acme.Account{ID: "accID"}
+	newAuthzProv := func(t *testing.T) *provisioner.ACME {
+		p := &provisioner.ACME{
+			Type:                  "ACME",
+			Name:                  "test@acme-<test>provisioner.com",
+			AuthorizationLifetime: &provisioner.Duration{Duration: 720 * time.Hour},
+			Policy: &provisioner.ACMEPolicy{
+				Deny: &provisioner.ACMEIdentifierRules{DNSNames: []string{"*.secret.internal"}},
+			},
+		}
+		assert.FatalError(t, p.Init(provisioner.Config{Claims: globalProvisionerClaims}))
+		return p
+	}
+	newCtx := func(p acme.Provisioner, nar *NewAuthorizationRequest) context.Context {
+		b, err := json.Marshal(nar)
+		assert.FatalError(t, err)
+		ctx := context.WithValue(context.Background(), provisionerContextKey, p)
+		ctx = context.WithValue(ctx, accContextKey, acc)
+		ctx = context.WithValue(ctx, payloadContextKey, &payloadInfo{value: b})
+		return context.WithValue(ctx, baseURLContextKey, baseURL)
+	}
+	escProvName := url.PathEscape("test@acme-<test>provisioner.com")
+
+	type test struct {
+		db         acme.DB
+		ctx        context.Context
+		statusCode int
+		location   string
+		vr         func(t *testing.T, az *acme.Authorization)
+		err        *acme.Error
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/no-account": func(t *testing.T) test {
+			return test{
+				ctx:        context.WithValue(context.Background(), provisionerContextKey, newAuthzProv(t)),
+				statusCode: 400,
+				err:        acme.NewError(acme.ErrorAccountDoesNotExistType, "account does not exist"),
+			}
+		},
+		"fail/not-enabled": func(t *testing.T) test {
+			nar := &NewAuthorizationRequest{Identifier: acme.Identifier{Type: "dns", Value: "zap.internal"}}
+			return test{
+				ctx:        newCtx(newProv(), nar),
+				statusCode: 501,
+				err:        acme.NewError(acme.ErrorNotImplementedType, "pre-authorization is not enabled by provisioner 'test@acme-<test>provisioner.com'"),
+			}
+		},
+		"fail/unmarshal-payload-error": func(t *testing.T) test {
+			ctx := newCtx(newAuthzProv(t), nil)
+			ctx = context.WithValue(ctx, payloadContextKey, &payloadInfo{})
+			return test{
+				ctx:        ctx,
+				statusCode: 400,
+				err:        acme.NewError(acme.ErrorMalformedType, "failed to unmarshal new-authz request payload: unexpected end of JSON input"),
+			}
+		},
+		"fail/wildcard": func(t *testing.T) test {
+			nar := &NewAuthorizationRequest{Identifier: acme.Identifier{Type: "dns", Value: "*.zap.internal"}}
+			return test{
+				ctx:        newCtx(newAuthzProv(t), nar),
+				statusCode: 400,
+				err:        acme.NewError(acme.ErrorMalformedType, "wildcard identifiers cannot be pre-authorized: *.zap.internal"),
+			}
+		},
+		"fail/rejected-identifier": func(t *testing.T) test {
+			nar := &NewAuthorizationRequest{Identifier: acme.Identifier{Type: "dns", Value: "zap.secret.internal"}}
+			err := acme.NewError(acme.ErrorRejectedIdentifierType, "error authorizing identifier: identifier zap.secret.internal is denied by the provisioner policy")
+			err.Identifier = nar.Identifier
+			return test{
+				ctx:        newCtx(newAuthzProv(t), nar),
+				statusCode: 400,
+				err:        err,
+			}
+		},
+		"fail/db.GetAuthorizationsByAccountID-error": func(t *testing.T) test {
+			nar := &NewAuthorizationRequest{Identifier: acme.Identifier{Type: "dns", Value: "zap.internal"}}
+			return test{
+				ctx: newCtx(newAuthzProv(t), nar),
+				db: &acme.MockDB{
+					MockGetAuthorizationsByAccountID: func(ctx context.Context, accID string, identifier acme.Identifier) ([]*acme.Authorization, error) {
+						return nil, acme.NewErrorISE("force")
+					},
+				},
+				statusCode: 500,
+				err:        acme.NewErrorISE("force"),
+			}
+		},
+		"ok/reused": func(t *testing.T) test {
+			now := clock.Now()
+			nar := &NewAuthorizationRequest{Identifier: acme.Identifier{Type: "dns", Value: "zap.internal"}}
+			return test{
+				ctx: newCtx(newAuthzProv(t), nar),
+				db: &acme.MockDB{
+					MockGetAuthorizationsByAccountID: func(ctx context.Context, accID string, identifier acme.Identifier) ([]*acme.Authorization, error) {
+						assert.Equals(t, accID, "accID")
+						assert.Equals(t, identifier, nar.Identifier)
+						return []*acme.Authorization{
+							{ID: "azID", Status: acme.StatusValid, Identifier: nar.Identifier, ExpiresAt: now.Add(time.Hour)},
+						}, nil
+					},
+				},
+				statusCode: 200,
+				location:   fmt.Sprintf("%s/acme/%s/authz/azID", baseURL.String(), escProvName),
+				vr: func(t *testing.T, az *acme.Authorization) {
+					// The ID is not in the response, only in the location.
+					assert.Equals(t, az.Identifier, nar.Identifier)
+					assert.Equals(t, az.Status, acme.StatusValid)
+				},
+			}
+		},
+		"ok/created": func(t *testing.T) test {
+			now := clock.Now()
+			nar := &NewAuthorizationRequest{Identifier: acme.Identifier{Type: "dns", Value: "zap.internal"}}
+			return test{
+				ctx: newCtx(newAuthzProv(t), nar),
+				db: &acme.MockDB{
+					MockGetAuthorizationsByAccountID: func(ctx context.Context, accID string, identifier acme.Identifier) ([]*acme.Authorization, error) {
+						return []*acme.Authorization{
+							{ID: "expired", Status: acme.StatusValid, ExpiresAt: now.Add(-time.Hour)},
+						}, nil
+					},
+					MockCreateChallenge: func(ctx context.Context, ch *acme.Challenge) error {
+						ch.ID = "chID"
+						assert.Equals(t, ch.AccountID, "accID")
+						assert.Equals(t, ch.Value, "zap.internal")
+						return nil
+					},
+					MockCreateAuthorization: func(ctx context.Context, az *acme.Authorization) error {
+						az.ID = "azID"
+						assert.Equals(t, az.AccountID, "accID")
+						assert.Equals(t, az.Status, acme.StatusPending)
+						assert.Equals(t, az.Identifier, nar.Identifier)
+						assert.True(t, az.ExpiresAt.After(now.Add(719*time.Hour)))
+						return nil
+					},
+				},
+				statusCode: 201,
+				location:   fmt.Sprintf("%s/acme/%s/authz/azID", baseURL.String(), escProvName),
+				vr: func(t *testing.T, az *acme.Authorization) {
+					assert.Equals(t, az.Identifier, nar.Identifier)
+					assert.Equals(t, az.Status, acme.StatusPending)
+					assert.Equals(t, len(az.Challenges), 3)
+				},
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			h := &Handler{linker: NewLinker("dns", "acme"), db: tc.db}
+			req := httptest.NewRequest("POST", "/foo/bar", nil)
+			req = req.WithContext(tc.ctx)
+			w := httptest.NewRecorder()
+			h.NewAuthorization(w, req)
+			res := w.Result()
+
+			assert.Equals(t, res.StatusCode, tc.statusCode)
+
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			assert.FatalError(t, err)
+
+			if res.StatusCode >= 400 && assert.NotNil(t, tc.err) {
+				var ae acme.Error
+				assert.FatalError(t, json.Unmarshal(bytes.TrimSpace(body), &ae))
+
+				assert.Equals(t, ae.Type, tc.err.Type)
+				assert.Equals(t, ae.Detail, tc.err.Detail)
+				assertErrorIdentifier(t, ae.Identifier, tc.err.Identifier)
+				assert.Equals(t, res.Header["Content-Type"], []string{"application/problem+json"})
+			} else {
+				az := new(acme.Authorization)
+				assert.FatalError(t, json.Unmarshal(body, az))
+				if tc.vr != nil {
+					tc.vr(t, az)
+				}
+
+				assert.Equals(t, res.Header["Location"], []string{tc.location})
+				assert.Equals(t, res.Header["Content-Type"], []string{"application/json"})
+			}
+		})
+	}
+}
+
 func TestHandler_FinalizeOrder(t *testing.T) {
 	prov := newProv()
 	escProvName := url.PathEscape(prov.GetName())
@@ -1558,6 +2295,44 @@ func TestHandler_FinalizeOrder(t *testing.T) {
 				err:        acme.NewError(acme.ErrorUnauthorizedType, "provisioner id mismatch"),
 			}
 		},
+		"fail/rate-limited": func(t *testing.T) test {
+			p := &provisioner.ACME{
+				Type: "ACME",
+				Name: "test@acme-<test>provisioner.com",
+				RateLimits: &provisioner.ACMERateLimits{
+					CertificatesPerDomain: &provisioner.ACMERateLimit{Limit: 1},
+				},
+			}
+			assert.FatalError(t, p.Init(provisioner.Config{Claims: globalProvisionerClaims}))
+			acc := &acme.Account{ID: "accountID"}
+			ctx := context.WithValue(context.Background(), provisionerContextKey, p)
+			ctx = context.WithValue(ctx, accContextKey, acc)
+			ctx = context.WithValue(ctx, payloadContextKey, &payloadInfo{value: payloadBytes})
+			ctx = context.WithValue(ctx, chi.RouteCtxKey, chiCtx)
+			return test{
+				db: &acme.MockDB{
+					MockGetOrder: func(ctx context.Context, id string) (*acme.Order, error) {
+						return &acme.Order{
+							AccountID:     "accountID",
+							ProvisionerID: p.GetID(),
+							ExpiresAt:     naf,
+							Status:        acme.StatusReady,
+							Identifiers: []acme.Identifier{
+								{Type: "dns", Value: "www.smallstep.com"},
+								{Type: "dns", Value: "*.smallstep.com"},
+							},
+						}, nil
+					},
+					MockGetRateLimitEvents: func(ctx context.Context, key string) ([]time.Time, error) {
+						assert.Equals(t, key, "acme/test@acme-<test>provisioner.com/certificates/smallstep.com")
+						return []time.Time{clock.Now().Add(-time.Hour)}, nil
+					},
+				},
+				ctx:        ctx,
+				statusCode: 429,
+				err:        acme.NewError(acme.ErrorRateLimitedType, "certificates rate limit exceeded for smallstep.com: 1 every 168h0m0s"),
+			}
+		},
 		"fail/order-finalize-error": func(t *testing.T) test {
 			acc := &acme.Account{ID: "accountID"}
 			ctx := context.WithValue(context.Background(), provisionerContextKey, prov)
@@ -1700,6 +2475,24 @@ func TestHandler_challengeTypes(t *testing.T) {
 			},
 			want: []acme.ChallengeType{acme.HTTP01, acme.TLSALPN01},
 		},
+		{
+			name: "ok/email",
+			args: args{
+				az: &acme.Authorization{
+					Identifier: acme.Identifier{Type: "email", Value: "jane@example.com"},
+				},
+			},
+			want: []acme.ChallengeType{acme.EMAILREPLY00},
+		},
+		{
+			name: "ok/permanent-identifier",
+			args: args{
+				az: &acme.Authorization{
+					Identifier: acme.Identifier{Type: "permanent-identifier", Value: "123456"},
+				},
+			},
+			want: []acme.ChallengeType{acme.DEVICEATTEST01},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -1709,3 +2502,34 @@ func TestHandler_challengeTypes(t *testing.T) {
 		})
 	}
 }
+
+func TestHandler_caaOptions(t *testing.T) {
+	baseURL := &url.URL{Scheme: "https", Host: "test.ca.smallstep.com"}
+	acc := &acme.Account{ID: "accID"}
+
+	prov := newProv()
+	ctx := context.WithValue(context.Background(), provisionerContextKey, prov)
+	ctx = context.WithValue(ctx, baseURLContextKey, baseURL)
+
+	h := &Handler{linker: NewLinker("dns", "acme")}
+	assert.Nil(t, h.caaOptions(ctx, prov, acc))
+
+	p := newProv().(*provisioner.ACME)
+	p.CAA = &provisioner.ACMECAA{
+		IssuerDomainNames: []string{"ca.internal"},
+		Resolver:          "127.0.0.1:5353",
+	}
+	h.lookupCAA = func(ctx context.Context, resolver, name string) ([]*acme.CAA, error) {
+		assert.Equals(t, resolver, "127.0.0.1:5353")
+		assert.Equals(t, name, "foo.internal")
+		return []*acme.CAA{{Tag: "issue", Value: "ca.internal"}}, nil
+	}
+	caa := h.caaOptions(ctx, p, acc)
+	if assert.NotNil(t, caa) {
+		assert.Equals(t, caa.IssuerDomainNames, []string{"ca.internal"})
+		assert.Equals(t, caa.AccountURI, fmt.Sprintf("%s/acme/%s/account/accID", baseURL, url.PathEscape(prov.GetName())))
+		records, err := caa.LookupCAA(ctx, "foo.internal")
+		assert.FatalError(t, err)
+		assert.Equals(t, records, []*acme.CAA{{Tag: "issue", Value: "ca.internal"}})
+	}
+}
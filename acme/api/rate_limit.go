@@ -0,0 +1,54 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/authority/provisioner"
+)
+
+// Default windows of the ACME rate limits.
+const (
+	defaultOrdersPerAccountWindow             = time.Hour
+	defaultCertificatesPerDomainWindow        = 7 * 24 * time.Hour
+	defaultFailedValidationsPerHostnameWindow = time.Hour
+	defaultAccountsPerIPWindow                = 3 * time.Hour
+)
+
+// rateLimits returns the rate limits configured in a provisioner. The limits
+// are scoped by provisioner, limits that are not configured are nil.
+func rateLimits(p acme.Provisioner) *acme.RateLimits {
+	rls := new(acme.RateLimits)
+	acmeProv, ok := p.(*provisioner.ACME)
+	if !ok || acmeProv.RateLimits == nil {
+		return rls
+	}
+	newRateLimit := func(name string, rl *provisioner.ACMERateLimit, window time.Duration) *acme.RateLimit {
+		if rl == nil {
+			return nil
+		}
+		return &acme.RateLimit{
+			Name:   name,
+			Scope:  p.GetID(),
+			Limit:  rl.Limit,
+			Window: rl.GetWindow(window),
+		}
+	}
+	cfg := acmeProv.RateLimits
+	rls.OrdersPerAccount = newRateLimit("orders", cfg.OrdersPerAccount, defaultOrdersPerAccountWindow)
+	rls.CertificatesPerDomain = newRateLimit("certificates", cfg.CertificatesPerDomain, defaultCertificatesPerDomainWindow)
+	rls.FailedValidationsPerHostname = newRateLimit("failedValidations", cfg.FailedValidationsPerHostname, defaultFailedValidationsPerHostnameWindow)
+	rls.AccountsPerIP = newRateLimit("accounts", cfg.AccountsPerIP, defaultAccountsPerIPWindow)
+	return rls
+}
+
+// remoteIP returns the IP address of the client of a request, using the
+// trusted proxies configured in the provisioner.
+func remoteIP(r *http.Request, p acme.Provisioner) string {
+	var rls *provisioner.ACMERateLimits
+	if acmeProv, ok := p.(*provisioner.ACME); ok {
+		rls = acmeProv.RateLimits
+	}
+	return rls.ClientIP(r)
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/authority/provisioner"
+)
+
+func Test_rateLimits(t *testing.T) {
+	assert.Equals(t, rateLimits(&acme.MockProvisioner{}), &acme.RateLimits{})
+	assert.Equals(t, rateLimits(newProv()), &acme.RateLimits{})
+
+	p := &provisioner.ACME{
+		Type: "ACME",
+		Name: "acme",
+		RateLimits: &provisioner.ACMERateLimits{
+			OrdersPerAccount:      &provisioner.ACMERateLimit{Limit: 300, Window: &provisioner.Duration{Duration: 3 * time.Hour}},
+			CertificatesPerDomain: &provisioner.ACMERateLimit{Limit: 50},
+			AccountsPerIP:         &provisioner.ACMERateLimit{Limit: 10},
+		},
+	}
+	assert.Equals(t, rateLimits(p), &acme.RateLimits{
+		OrdersPerAccount:      &acme.RateLimit{Name: "orders", Scope: "acme/acme", Limit: 300, Window: 3 * time.Hour},
+		CertificatesPerDomain: &acme.RateLimit{Name: "certificates", Scope: "acme/acme", Limit: 50, Window: 7 * 24 * time.Hour},
+		AccountsPerIP:         &acme.RateLimit{Name: "accounts", Scope: "acme/acme", Limit: 10, Window: 3 * time.Hour},
+	})
+}
+
+func Test_remoteIP(t *testing.T) {
+	r := httptest.NewRequest("POST", "/acme/acme/new-account", nil)
+	r.Header.Set("X-Forwarded-For", "1.1.1.1")
+	r.RemoteAddr = "10.0.0.1:443"
+	assert.Equals(t, remoteIP(r, &acme.MockProvisioner{}), "10.0.0.1")
+	assert.Equals(t, remoteIP(r, newProv()), "10.0.0.1")
+	r.RemoteAddr = "[::1]:443"
+	assert.Equals(t, remoteIP(r, newProv()), "::1")
+	r.RemoteAddr = "10.0.0.1"
+	assert.Equals(t, remoteIP(r, newProv()), "10.0.0.1")
+
+	p := &provisioner.ACME{
+		Type: "ACME",
+		Name: "acme",
+		RateLimits: &provisioner.ACMERateLimits{
+			TrustedProxies: []string{"10.0.0.0/8"},
+		},
+	}
+	assert.FatalError(t, p.Init(provisioner.Config{Claims: globalProvisionerClaims}))
+	assert.Equals(t, remoteIP(r, p), "1.1.1.1")
+	r.RemoteAddr = "[::1]:443"
+	assert.Equals(t, remoteIP(r, p), "::1")
+}
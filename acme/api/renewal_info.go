@@ -0,0 +1,131 @@
+package api
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/api"
+)
+
+// renewalInfoRetryAfter is the time clients should wait before polling the
+// renewal information of a certificate again.
+var renewalInfoRetryAfter = 6 * time.Hour
+
+// RenewalInfo is the ACME Renewal Information (ARI) resource as defined in
+// draft-ietf-acme-ari.
+type RenewalInfo struct {
+	SuggestedWindow *Window `json:"suggestedWindow"`
+	ExplanationURL  string  `json:"explanationURL,omitempty"`
+}
+
+// Window is the suggested renewal window of a certificate.
+type Window struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// suggestedWindow returns the default renewal window of a certificate, the
+// window starts when two thirds of the validity period have elapsed and ends
+// when five sixths of the validity period have elapsed.
+func suggestedWindow(cert *x509.Certificate) *Window {
+	validity := cert.NotAfter.Sub(cert.NotBefore)
+	return &Window{
+		Start: cert.NotAfter.Add(-validity / 3).UTC().Truncate(time.Second),
+		End:   cert.NotAfter.Add(-validity / 6).UTC().Truncate(time.Second),
+	}
+}
+
+// immediateWindow returns a renewal window in the past, clients will renew
+// the certificate immediately.
+func immediateWindow(now time.Time) *Window {
+	now = now.UTC().Truncate(time.Second)
+	return &Window{
+		Start: now.Add(-2 * time.Hour),
+		End:   now.Add(-time.Hour),
+	}
+}
+
+// parseCertID parses the certificate identifier used in the renewal
+// information URL. The identifier is the base64url encoding of the authority
+// key identifier and the base64url encoding of the serial number bytes
+// joined by a dot.
+func parseCertID(certID string) ([]byte, *big.Int, error) {
+	parts := strings.Split(certID, ".")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, nil, acme.NewError(acme.ErrorMalformedType, "certificate identifier '%s' is not valid", certID)
+	}
+	aki, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, nil, acme.WrapError(acme.ErrorMalformedType, err, "error decoding authority key identifier")
+	}
+	serial, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, nil, acme.WrapError(acme.ErrorMalformedType, err, "error decoding serial number")
+	}
+	return aki, new(big.Int).SetBytes(serial), nil
+}
+
+// GetRenewalInfo returns the suggested renewal window of a certificate issued
+// by the ACME provisioner.
+func (h *Handler) GetRenewalInfo(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	acmeProv, err := acmeProvisionerFromContext(ctx)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+
+	aki, sn, err := parseCertID(chi.URLParam(r, "certID"))
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+
+	serial := sn.String()
+	dbCert, err := h.db.GetCertificateBySerial(ctx, serial)
+	switch {
+	case errors.Is(err, acme.ErrNotFound):
+		acmeErr := acme.NewError(acme.ErrorMalformedType, "certificate with serial number %s was not issued by the ACME server", serial)
+		acmeErr.Status = http.StatusNotFound
+		api.WriteError(w, acmeErr)
+		return
+	case err != nil:
+		api.WriteError(w, acme.WrapErrorISE(err, "error retrieving certificate by serial"))
+		return
+	}
+	leaf := dbCert.Leaf
+	if !bytes.Equal(leaf.AuthorityKeyId, aki) {
+		acmeErr := acme.NewError(acme.ErrorMalformedType, "authority key identifier does not match certificate with serial number %s", serial)
+		acmeErr.Status = http.StatusNotFound
+		api.WriteError(w, acmeErr)
+		return
+	}
+
+	isRevoked, err := h.ca.IsRevoked(serial)
+	if err != nil {
+		api.WriteError(w, acme.WrapErrorISE(err, "error checking revocation status"))
+		return
+	}
+
+	ri := new(RenewalInfo)
+	if isRevoked || acmeProv.RenewalInfo.ShouldRenewNow(leaf) {
+		ri.SuggestedWindow = immediateWindow(time.Now())
+	} else {
+		ri.SuggestedWindow = suggestedWindow(leaf)
+	}
+	if acmeProv.RenewalInfo != nil {
+		ri.ExplanationURL = acmeProv.RenewalInfo.ExplanationURL
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(renewalInfoRetryAfter.Seconds())))
+	api.JSON(w, ri)
+}
@@ -0,0 +1,256 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/authority/provisioner"
+)
+
+func generateRenewalInfoCertificate(t *testing.T, notBefore, notAfter time.Time) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(1234),
+		Subject:        pkix.Name{CommonName: "test.example.com"},
+		DNSNames:       []string{"test.example.com"},
+		NotBefore:      notBefore,
+		NotAfter:       notAfter,
+		AuthorityKeyId: []byte{1, 2, 3, 4},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	assert.FatalError(t, err)
+	crt, err := x509.ParseCertificate(der)
+	assert.FatalError(t, err)
+	return crt
+}
+
+func certID(crt *x509.Certificate) string {
+	return base64.RawURLEncoding.EncodeToString(crt.AuthorityKeyId) + "." +
+		base64.RawURLEncoding.EncodeToString(crt.SerialNumber.Bytes())
+}
+
+func Test_parseCertID(t *testing.T) {
+	tests := []struct {
+		name       string
+		certID     string
+		wantAKI    []byte
+		wantSerial *big.Int
+		wantErr    bool
+	}{
+		{"ok", "AQIDBA.BNI", []byte{1, 2, 3, 4}, big.NewInt(1234), false},
+		{"fail/empty", "", nil, nil, true},
+		{"fail/no-dot", "AQIDBA", nil, nil, true},
+		{"fail/empty-serial", "AQIDBA.", nil, nil, true},
+		{"fail/too-many-parts", "AQIDBA.BNI.BNI", nil, nil, true},
+		{"fail/aki", "AQIDBA=.BNI", nil, nil, true},
+		{"fail/serial", "AQIDBA.BNI=", nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aki, serial, err := parseCertID(tt.certID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCertID() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			assert.Equals(t, aki, tt.wantAKI)
+			assert.Equals(t, serial.Cmp(tt.wantSerial), 0)
+		})
+	}
+}
+
+func Test_suggestedWindow(t *testing.T) {
+	notBefore := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	crt := &x509.Certificate{NotBefore: notBefore, NotAfter: notBefore.Add(24 * time.Hour)}
+	assert.Equals(t, suggestedWindow(crt), &Window{
+		Start: notBefore.Add(16 * time.Hour),
+		End:   notBefore.Add(20 * time.Hour),
+	})
+}
+
+func TestHandler_GetRenewalInfo(t *testing.T) {
+	now := time.Now()
+	crt := generateRenewalInfoCertificate(t, now.Add(-time.Hour), now.Add(23*time.Hour))
+	prov := newProv()
+	baseURL := &url.URL{Scheme: "https", Host: "test.ca.smallstep.com"}
+	immediate := func(ri *RenewalInfo) bool {
+		return ri.SuggestedWindow.End.Before(time.Now())
+	}
+	newContext := func(p acme.Provisioner, id string) context.Context {
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("certID", id)
+		ctx := context.WithValue(context.Background(), chi.RouteCtxKey, chiCtx)
+		ctx = context.WithValue(ctx, provisionerContextKey, p)
+		return context.WithValue(ctx, baseURLContextKey, baseURL)
+	}
+	getCertificate := func(ctx context.Context, serial string) (*acme.Certificate, error) {
+		assert.Equals(t, serial, "1234")
+		return &acme.Certificate{ID: "certID", Leaf: crt}, nil
+	}
+
+	type test struct {
+		db         acme.DB
+		ca         acme.CertificateAuthority
+		ctx        context.Context
+		statusCode int
+		err        *acme.Error
+		check      func(ri *RenewalInfo) bool
+		explURL    string
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/no-provisioner": func(t *testing.T) test {
+			return test{
+				ctx:        context.Background(),
+				statusCode: 500,
+				err:        acme.NewErrorISE("provisioner expected in request context"),
+			}
+		},
+		"fail/bad-cert-id": func(t *testing.T) test {
+			return test{
+				ctx:        newContext(prov, "foo"),
+				statusCode: 400,
+				err:        acme.NewError(acme.ErrorMalformedType, "certificate identifier 'foo' is not valid"),
+			}
+		},
+		"fail/not-found": func(t *testing.T) test {
+			return test{
+				db: &acme.MockDB{
+					MockGetCertificateBySerial: func(ctx context.Context, serial string) (*acme.Certificate, error) {
+						return nil, acme.ErrNotFound
+					},
+				},
+				ctx:        newContext(prov, certID(crt)),
+				statusCode: 404,
+				err:        acme.NewError(acme.ErrorMalformedType, "certificate with serial number 1234 was not issued by the ACME server"),
+			}
+		},
+		"fail/db.GetCertificateBySerial-error": func(t *testing.T) test {
+			return test{
+				db: &acme.MockDB{
+					MockGetCertificateBySerial: func(ctx context.Context, serial string) (*acme.Certificate, error) {
+						return nil, errors.New("force")
+					},
+				},
+				ctx:        newContext(prov, certID(crt)),
+				statusCode: 500,
+				err:        acme.NewErrorISE("error retrieving certificate by serial: force"),
+			}
+		},
+		"fail/aki-mismatch": func(t *testing.T) test {
+			return test{
+				db:         &acme.MockDB{MockGetCertificateBySerial: getCertificate},
+				ctx:        newContext(prov, "AQID."+base64.RawURLEncoding.EncodeToString(crt.SerialNumber.Bytes())),
+				statusCode: 404,
+				err:        acme.NewError(acme.ErrorMalformedType, "authority key identifier does not match certificate with serial number 1234"),
+			}
+		},
+		"fail/ca.IsRevoked-error": func(t *testing.T) test {
+			return test{
+				db: &acme.MockDB{MockGetCertificateBySerial: getCertificate},
+				ca: &mockCA{
+					MockIsRevoked: func(sn string) (bool, error) {
+						return false, errors.New("force")
+					},
+				},
+				ctx:        newContext(prov, certID(crt)),
+				statusCode: 500,
+				err:        acme.NewErrorISE("error checking revocation status: force"),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			return test{
+				db:         &acme.MockDB{MockGetCertificateBySerial: getCertificate},
+				ca:         &mockCA{},
+				ctx:        newContext(prov, certID(crt)),
+				statusCode: 200,
+				check: func(ri *RenewalInfo) bool {
+					return ri.SuggestedWindow.Start.Equal(suggestedWindow(crt).Start) &&
+						ri.SuggestedWindow.End.Equal(suggestedWindow(crt).End)
+				},
+			}
+		},
+		"ok/revoked": func(t *testing.T) test {
+			return test{
+				db: &acme.MockDB{MockGetCertificateBySerial: getCertificate},
+				ca: &mockCA{
+					MockIsRevoked: func(sn string) (bool, error) {
+						assert.Equals(t, sn, "1234")
+						return true, nil
+					},
+				},
+				ctx:        newContext(prov, certID(crt)),
+				statusCode: 200,
+				check:      immediate,
+			}
+		},
+		"ok/renew-issued-before": func(t *testing.T) test {
+			p := newProv().(*provisioner.ACME)
+			p.RenewalInfo = &provisioner.ACMERenewalInfo{
+				RenewIssuedBefore: &now,
+				ExplanationURL:    "https://status.example.com/incident",
+			}
+			return test{
+				db:         &acme.MockDB{MockGetCertificateBySerial: getCertificate},
+				ca:         &mockCA{},
+				ctx:        newContext(p, certID(crt)),
+				statusCode: 200,
+				check:      immediate,
+				explURL:    "https://status.example.com/incident",
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			h := &Handler{db: tc.db, ca: tc.ca, linker: NewLinker("dns", "acme")}
+			req := httptest.NewRequest("GET", "/foo/bar", nil)
+			req = req.WithContext(tc.ctx)
+			w := httptest.NewRecorder()
+			h.GetRenewalInfo(w, req)
+			res := w.Result()
+
+			assert.Equals(t, res.StatusCode, tc.statusCode)
+
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			assert.FatalError(t, err)
+
+			if res.StatusCode >= 400 && assert.NotNil(t, tc.err) {
+				var ae acme.Error
+				assert.FatalError(t, json.Unmarshal(bytes.TrimSpace(body), &ae))
+
+				assert.Equals(t, ae.Type, tc.err.Type)
+				assert.Equals(t, ae.Detail, tc.err.Detail)
+				assert.Equals(t, res.Header["Content-Type"], []string{"application/problem+json"})
+			} else {
+				var ri RenewalInfo
+				assert.FatalError(t, json.Unmarshal(bytes.TrimSpace(body), &ri))
+				assert.True(t, tc.check(&ri))
+				assert.True(t, ri.SuggestedWindow.Start.Before(ri.SuggestedWindow.End))
+				assert.Equals(t, ri.ExplanationURL, tc.explURL)
+				assert.Equals(t, res.Header["Retry-After"], []string{"21600"})
+				assert.Equals(t, res.Header["Content-Type"], []string{"application/json"})
+			}
+		})
+	}
+}
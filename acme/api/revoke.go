@@ -0,0 +1,207 @@
+package api
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/api"
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/logging"
+	"golang.org/x/crypto/ocsp"
+)
+
+// revokePayload is the payload of a revokeCert request as defined in RFC 8555,
+// section 7.6.
+type revokePayload struct {
+	Certificate string `json:"certificate"`
+	ReasonCode  *int   `json:"reason,omitempty"`
+}
+
+// Validate validates a revokePayload.
+func (p *revokePayload) Validate() error {
+	if p.Certificate == "" {
+		return acme.NewError(acme.ErrorMalformedType, "certificate is required")
+	}
+	// The reason codes are the ones defined in RFC 5280, section 5.3.1, the
+	// value 7 is not used.
+	if p.ReasonCode != nil {
+		if rc := *p.ReasonCode; rc < ocsp.Unspecified || rc > ocsp.AACompromise || rc == 7 {
+			return acme.NewError(acme.ErrorBadRevocationReasonType, "reason code %d is not allowed", rc)
+		}
+	}
+	return nil
+}
+
+// RevokeCert attempts to revoke a certificate.
+//
+// The request can be signed by the account that ordered the certificate, in
+// that case the JWS must include the kid header, or by the private key of the
+// certificate, in that case the JWS must include the jwk header with the
+// public key of the certificate.
+func (h *Handler) RevokeCert(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	jws, err := jwsFromContext(ctx)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	prov, err := provisionerFromContext(ctx)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	payload, err := payloadFromContext(ctx)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+
+	var p revokePayload
+	if err := json.Unmarshal(payload.value, &p); err != nil {
+		api.WriteError(w, acme.WrapError(acme.ErrorMalformedType, err, "failed to unmarshal revokeCert request payload"))
+		return
+	}
+	if err := p.Validate(); err != nil {
+		api.WriteError(w, err)
+		return
+	}
+
+	der, err := base64.RawURLEncoding.DecodeString(p.Certificate)
+	if err != nil {
+		api.WriteError(w, acme.WrapError(acme.ErrorMalformedType, err, "error decoding certificate"))
+		return
+	}
+	crt, err := x509.ParseCertificate(der)
+	if err != nil {
+		api.WriteError(w, acme.WrapError(acme.ErrorMalformedType, err, "error parsing certificate"))
+		return
+	}
+
+	serial := crt.SerialNumber.String()
+	dbCert, err := h.db.GetCertificateBySerial(ctx, serial)
+	switch {
+	case errors.Is(err, acme.ErrNotFound):
+		api.WriteError(w, acme.NewError(acme.ErrorMalformedType, "certificate with serial number %s was not issued by the ACME server", serial))
+		return
+	case err != nil:
+		api.WriteError(w, acme.WrapErrorISE(err, "error retrieving certificate by serial"))
+		return
+	}
+	if !bytes.Equal(dbCert.Leaf.Raw, crt.Raw) {
+		api.WriteError(w, acme.NewError(acme.ErrorMalformedType, "certificate does not match the stored certificate with serial number %s", serial))
+		return
+	}
+
+	// Only the provisioner that issued the certificate can revoke it.
+	o, err := h.db.GetOrder(ctx, dbCert.OrderID)
+	if err != nil {
+		api.WriteError(w, acme.WrapErrorISE(err, "error retrieving order"))
+		return
+	}
+	if prov.GetID() != o.ProvisionerID {
+		api.WriteError(w, acme.NewError(acme.ErrorUnauthorizedType,
+			"provisioner '%s' did not issue certificate '%s'", prov.GetID(), dbCert.ID))
+		return
+	}
+
+	if len(jws.Signatures[0].Protected.KeyID) > 0 {
+		// The request is signed by an account, only the account that ordered
+		// the certificate can revoke it.
+		acc, err := accountFromContext(ctx)
+		if err != nil {
+			api.WriteError(w, err)
+			return
+		}
+		if acc.ID != dbCert.AccountID {
+			api.WriteError(w, acme.NewError(acme.ErrorUnauthorizedType,
+				"account '%s' does not own certificate '%s'", acc.ID, dbCert.ID))
+			return
+		}
+	} else {
+		// The request must be signed by the private key of the certificate.
+		if _, err := jws.Verify(crt.PublicKey); err != nil {
+			api.WriteError(w, acme.WrapError(acme.ErrorUnauthorizedType, err, "error verifying jws with the certificate public key"))
+			return
+		}
+	}
+
+	isRevoked, err := h.ca.IsRevoked(serial)
+	if err != nil {
+		api.WriteError(w, acme.WrapErrorISE(err, "error checking revocation status"))
+		return
+	}
+	if isRevoked {
+		api.WriteError(w, acme.NewError(acme.ErrorAlreadyRevokedType, "certificate with serial number %s has already been revoked", serial))
+		return
+	}
+
+	ctx = provisioner.NewContextWithMethod(ctx, provisioner.RevokeMethod)
+	if err := prov.AuthorizeRevoke(ctx, ""); err != nil {
+		api.WriteError(w, acme.WrapError(acme.ErrorUnauthorizedType, err, "error authorizing revocation on provisioner"))
+		return
+	}
+
+	opts := &authority.RevokeOptions{
+		Serial: serial,
+		ACME:   true,
+		Crt:    crt,
+	}
+	if p.ReasonCode != nil {
+		opts.ReasonCode = *p.ReasonCode
+		opts.Reason = revocationReason(*p.ReasonCode)
+	}
+	if err := h.ca.Revoke(ctx, opts); err != nil {
+		api.WriteError(w, acme.WrapErrorISE(err, "error revoking certificate"))
+		return
+	}
+
+	logRevoke(w, opts)
+	w.Header().Add("Link", link(h.linker.GetLink(ctx, DirectoryLinkType), "index"))
+	w.WriteHeader(http.StatusOK)
+}
+
+// revocationReason returns the name of the given reason code as defined in
+// RFC 5280, section 5.3.1.
+func revocationReason(reasonCode int) string {
+	switch reasonCode {
+	case ocsp.Unspecified:
+		return "unspecified"
+	case ocsp.KeyCompromise:
+		return "key compromised"
+	case ocsp.CACompromise:
+		return "ca compromised"
+	case ocsp.AffiliationChanged:
+		return "affiliation changed"
+	case ocsp.Superseded:
+		return "superseded"
+	case ocsp.CessationOfOperation:
+		return "cessation of operation"
+	case ocsp.CertificateHold:
+		return "certificate hold"
+	case ocsp.RemoveFromCRL:
+		return "remove from crl"
+	case ocsp.PrivilegeWithdrawn:
+		return "privilege withdrawn"
+	case ocsp.AACompromise:
+		return "aa compromised"
+	default:
+		return "unknown reason"
+	}
+}
+
+func logRevoke(w http.ResponseWriter, ri *authority.RevokeOptions) {
+	if rl, ok := w.(logging.ResponseLogger); ok {
+		rl.WithFields(map[string]interface{}{
+			"serial":     ri.Serial,
+			"reasonCode": ri.ReasonCode,
+			"reason":     ri.Reason,
+			"acme":       ri.ACME,
+		})
+	}
+}
@@ -0,0 +1,358 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"go.step.sm/crypto/jose"
+)
+
+type mockCA struct {
+	MockIsRevoked func(sn string) (bool, error)
+	MockRevoke    func(ctx context.Context, opts *authority.RevokeOptions) error
+}
+
+func (m *mockCA) Sign(cr *x509.CertificateRequest, opts provisioner.SignOptions, signOpts ...provisioner.SignOption) ([]*x509.Certificate, error) {
+	return nil, nil
+}
+
+func (m *mockCA) IsRevoked(sn string) (bool, error) {
+	if m.MockIsRevoked != nil {
+		return m.MockIsRevoked(sn)
+	}
+	return false, nil
+}
+
+func (m *mockCA) Revoke(ctx context.Context, opts *authority.RevokeOptions) error {
+	if m.MockRevoke != nil {
+		return m.MockRevoke(ctx, opts)
+	}
+	return nil
+}
+
+func (m *mockCA) LoadProvisionerByName(string) (provisioner.Interface, error) {
+	return nil, nil
+}
+
+func generateRevokeCertificate(t *testing.T) (*x509.Certificate, crypto.Signer) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1234),
+		Subject:      pkix.Name{CommonName: "test.example.com"},
+		DNSNames:     []string{"test.example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	assert.FatalError(t, err)
+	crt, err := x509.ParseCertificate(der)
+	assert.FatalError(t, err)
+	return crt, key
+}
+
+func signRevokePayload(t *testing.T, payload []byte, key crypto.Signer, headers map[jose.HeaderKey]interface{}) *jose.JSONWebSignature {
+	t.Helper()
+	so := new(jose.SignerOptions)
+	for k, v := range headers {
+		so.WithHeader(k, v)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{
+		Algorithm: jose.ES256,
+		Key:       key,
+	}, so)
+	assert.FatalError(t, err)
+	jws, err := signer.Sign(payload)
+	assert.FatalError(t, err)
+	raw, err := jws.CompactSerialize()
+	assert.FatalError(t, err)
+	parsed, err := jose.ParseJWS(raw)
+	assert.FatalError(t, err)
+	return parsed
+}
+
+func TestRevokePayload_Validate(t *testing.T) {
+	reason := func(i int) *int { return &i }
+	tests := map[string]struct {
+		p   *revokePayload
+		err *acme.Error
+	}{
+		"fail/missing-certificate": {&revokePayload{}, acme.NewError(acme.ErrorMalformedType, "certificate is required")},
+		"fail/negative-reason":     {&revokePayload{Certificate: "foo", ReasonCode: reason(-1)}, acme.NewError(acme.ErrorBadRevocationReasonType, "reason code -1 is not allowed")},
+		"fail/unused-reason":       {&revokePayload{Certificate: "foo", ReasonCode: reason(7)}, acme.NewError(acme.ErrorBadRevocationReasonType, "reason code 7 is not allowed")},
+		"fail/large-reason":        {&revokePayload{Certificate: "foo", ReasonCode: reason(11)}, acme.NewError(acme.ErrorBadRevocationReasonType, "reason code 11 is not allowed")},
+		"ok":                       {&revokePayload{Certificate: "foo"}, nil},
+		"ok/reason":                {&revokePayload{Certificate: "foo", ReasonCode: reason(1)}, nil},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.p.Validate()
+			if tc.err == nil {
+				assert.FatalError(t, err)
+				return
+			}
+			if assert.NotNil(t, err) {
+				ae, ok := err.(*acme.Error)
+				assert.True(t, ok)
+				assert.Equals(t, ae.Type, tc.err.Type)
+				assert.Equals(t, ae.Err.Error(), tc.err.Err.Error())
+			}
+		})
+	}
+}
+
+func TestHandler_RevokeCert(t *testing.T) {
+	crt, key := generateRevokeCertificate(t)
+	otherCrt, _ := generateRevokeCertificate(t)
+	_, otherKey := generateRevokeCertificate(t)
+
+	prov := newProv()
+	provName := url.PathEscape(prov.GetName())
+	baseURL := &url.URL{Scheme: "https", Host: "test.ca.smallstep.com"}
+	url := fmt.Sprintf("%s/acme/%s/revoke-cert", baseURL.String(), provName)
+
+	newPayload := func(c *x509.Certificate, reason *int) []byte {
+		b, err := json.Marshal(&revokePayload{
+			Certificate: base64.RawURLEncoding.EncodeToString(c.Raw),
+			ReasonCode:  reason,
+		})
+		assert.FatalError(t, err)
+		return b
+	}
+	newContext := func(jws *jose.JSONWebSignature, payload []byte, acc *acme.Account) context.Context {
+		ctx := context.WithValue(context.Background(), provisionerContextKey, prov)
+		ctx = context.WithValue(ctx, baseURLContextKey, baseURL)
+		ctx = context.WithValue(ctx, jwsContextKey, jws)
+		ctx = context.WithValue(ctx, payloadContextKey, &payloadInfo{value: payload})
+		if acc != nil {
+			ctx = context.WithValue(ctx, accContextKey, acc)
+		}
+		return ctx
+	}
+	kidHeaders := map[jose.HeaderKey]interface{}{"kid": "accID"}
+	jwkHeaders := map[jose.HeaderKey]interface{}{"jwk": jose.JSONWebKey{Key: key.Public()}}
+	acmeDB := &acme.MockDB{
+		MockGetCertificateBySerial: func(ctx context.Context, serial string) (*acme.Certificate, error) {
+			assert.Equals(t, serial, crt.SerialNumber.String())
+			return &acme.Certificate{ID: "certID", AccountID: "accID", OrderID: "ordID", Leaf: crt}, nil
+		},
+		MockGetOrder: func(ctx context.Context, id string) (*acme.Order, error) {
+			assert.Equals(t, id, "ordID")
+			return &acme.Order{ID: "ordID", ProvisionerID: prov.GetID()}, nil
+		},
+	}
+
+	type test struct {
+		db         acme.DB
+		ca         acme.CertificateAuthority
+		ctx        context.Context
+		statusCode int
+		err        *acme.Error
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/no-jws": func(t *testing.T) test {
+			return test{
+				ctx:        context.Background(),
+				statusCode: 500,
+				err:        acme.NewErrorISE("jws expected in request context"),
+			}
+		},
+		"fail/unmarshal-payload": func(t *testing.T) test {
+			payload := []byte("foo")
+			return test{
+				ctx:        newContext(signRevokePayload(t, payload, key, kidHeaders), payload, nil),
+				statusCode: 400,
+				err:        acme.NewError(acme.ErrorMalformedType, "failed to unmarshal revokeCert request payload"),
+			}
+		},
+		"fail/bad-reason": func(t *testing.T) test {
+			reason := 7
+			payload := newPayload(crt, &reason)
+			return test{
+				ctx:        newContext(signRevokePayload(t, payload, key, kidHeaders), payload, nil),
+				statusCode: 400,
+				err:        acme.NewError(acme.ErrorBadRevocationReasonType, "reason code 7 is not allowed"),
+			}
+		},
+		"fail/not-found": func(t *testing.T) test {
+			payload := newPayload(crt, nil)
+			return test{
+				db: &acme.MockDB{
+					MockGetCertificateBySerial: func(ctx context.Context, serial string) (*acme.Certificate, error) {
+						return nil, acme.ErrNotFound
+					},
+				},
+				ctx:        newContext(signRevokePayload(t, payload, key, kidHeaders), payload, nil),
+				statusCode: 400,
+				err:        acme.NewError(acme.ErrorMalformedType, "certificate with serial number 1234 was not issued by the ACME server"),
+			}
+		},
+		"fail/certificate-mismatch": func(t *testing.T) test {
+			payload := newPayload(crt, nil)
+			return test{
+				db: &acme.MockDB{
+					MockGetCertificateBySerial: func(ctx context.Context, serial string) (*acme.Certificate, error) {
+						return &acme.Certificate{ID: "certID", AccountID: "accID", Leaf: otherCrt}, nil
+					},
+				},
+				ctx:        newContext(signRevokePayload(t, payload, key, kidHeaders), payload, nil),
+				statusCode: 400,
+				err:        acme.NewError(acme.ErrorMalformedType, "certificate does not match the stored certificate with serial number 1234"),
+			}
+		},
+		"fail/db.GetOrder-error": func(t *testing.T) test {
+			payload := newPayload(crt, nil)
+			return test{
+				db: &acme.MockDB{
+					MockGetCertificateBySerial: acmeDB.MockGetCertificateBySerial,
+					MockGetOrder: func(ctx context.Context, id string) (*acme.Order, error) {
+						return nil, errors.New("force")
+					},
+				},
+				ctx:        newContext(signRevokePayload(t, payload, key, kidHeaders), payload, &acme.Account{ID: "accID"}),
+				statusCode: 500,
+				err:        acme.NewErrorISE("error retrieving order: force"),
+			}
+		},
+		"fail/provisioner-mismatch": func(t *testing.T) test {
+			payload := newPayload(crt, nil)
+			return test{
+				db: &acme.MockDB{
+					MockGetCertificateBySerial: acmeDB.MockGetCertificateBySerial,
+					MockGetOrder: func(ctx context.Context, id string) (*acme.Order, error) {
+						return &acme.Order{ID: "ordID", ProvisionerID: "acme/other"}, nil
+					},
+				},
+				ctx:        newContext(signRevokePayload(t, payload, key, kidHeaders), payload, &acme.Account{ID: "accID"}),
+				statusCode: 401,
+				err:        acme.NewError(acme.ErrorUnauthorizedType, fmt.Sprintf("provisioner '%s' did not issue certificate 'certID'", prov.GetID())),
+			}
+		},
+		"fail/account-mismatch": func(t *testing.T) test {
+			payload := newPayload(crt, nil)
+			return test{
+				db:         acmeDB,
+				ctx:        newContext(signRevokePayload(t, payload, key, kidHeaders), payload, &acme.Account{ID: "otherID"}),
+				statusCode: 401,
+				err:        acme.NewError(acme.ErrorUnauthorizedType, "account 'otherID' does not own certificate 'certID'"),
+			}
+		},
+		"fail/certificate-key-mismatch": func(t *testing.T) test {
+			payload := newPayload(crt, nil)
+			headers := map[jose.HeaderKey]interface{}{"jwk": jose.JSONWebKey{Key: otherKey.Public()}}
+			return test{
+				db:         acmeDB,
+				ctx:        newContext(signRevokePayload(t, payload, otherKey, headers), payload, nil),
+				statusCode: 401,
+				err:        acme.NewError(acme.ErrorUnauthorizedType, "error verifying jws with the certificate public key"),
+			}
+		},
+		"fail/already-revoked": func(t *testing.T) test {
+			payload := newPayload(crt, nil)
+			return test{
+				db: acmeDB,
+				ca: &mockCA{
+					MockIsRevoked: func(sn string) (bool, error) {
+						return true, nil
+					},
+				},
+				ctx:        newContext(signRevokePayload(t, payload, key, kidHeaders), payload, &acme.Account{ID: "accID"}),
+				statusCode: 400,
+				err:        acme.NewError(acme.ErrorAlreadyRevokedType, "certificate with serial number 1234 has already been revoked"),
+			}
+		},
+		"fail/ca.Revoke-error": func(t *testing.T) test {
+			payload := newPayload(crt, nil)
+			return test{
+				db: acmeDB,
+				ca: &mockCA{
+					MockRevoke: func(ctx context.Context, opts *authority.RevokeOptions) error {
+						return errors.New("force")
+					},
+				},
+				ctx:        newContext(signRevokePayload(t, payload, key, kidHeaders), payload, &acme.Account{ID: "accID"}),
+				statusCode: 500,
+				err:        acme.NewErrorISE("error revoking certificate: force"),
+			}
+		},
+		"ok/account": func(t *testing.T) test {
+			reason := 4
+			payload := newPayload(crt, &reason)
+			return test{
+				db: acmeDB,
+				ca: &mockCA{
+					MockRevoke: func(ctx context.Context, opts *authority.RevokeOptions) error {
+						assert.Equals(t, provisioner.MethodFromContext(ctx), provisioner.RevokeMethod)
+						assert.Equals(t, opts.Serial, "1234")
+						assert.Equals(t, opts.ReasonCode, 4)
+						assert.Equals(t, opts.Reason, "superseded")
+						assert.True(t, opts.ACME)
+						assert.Equals(t, opts.Crt, crt)
+						return nil
+					},
+				},
+				ctx:        newContext(signRevokePayload(t, payload, key, kidHeaders), payload, &acme.Account{ID: "accID"}),
+				statusCode: 200,
+			}
+		},
+		"ok/certificate-key": func(t *testing.T) test {
+			payload := newPayload(crt, nil)
+			return test{
+				db:         acmeDB,
+				ca:         &mockCA{},
+				ctx:        newContext(signRevokePayload(t, payload, key, jwkHeaders), payload, nil),
+				statusCode: 200,
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			h := &Handler{db: tc.db, ca: tc.ca, linker: NewLinker("dns", "acme")}
+			req := httptest.NewRequest("POST", url, nil)
+			req = req.WithContext(tc.ctx)
+			w := httptest.NewRecorder()
+			h.RevokeCert(w, req)
+			res := w.Result()
+
+			assert.Equals(t, res.StatusCode, tc.statusCode)
+
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			assert.FatalError(t, err)
+
+			if res.StatusCode >= 400 && assert.NotNil(t, tc.err) {
+				var ae acme.Error
+				assert.FatalError(t, json.Unmarshal(bytes.TrimSpace(body), &ae))
+
+				assert.Equals(t, ae.Type, tc.err.Type)
+				assert.HasPrefix(t, ae.Detail, tc.err.Detail)
+				assert.Equals(t, res.Header["Content-Type"], []string{"application/problem+json"})
+			} else {
+				assert.Equals(t, res.Header["Link"], []string{fmt.Sprintf("<%s/acme/%s/directory>;rel=\"index\"", baseURL, provName)})
+				assert.Equals(t, len(body), 0)
+			}
+		})
+	}
+}
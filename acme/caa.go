@@ -0,0 +1,347 @@
+package acme
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// caaType is the DNS resource record type of CAA records.
+const caaType = dnsmessage.Type(257)
+
+// caaFlagCritical is the issuer critical flag of a CAA record.
+const caaFlagCritical = 128
+
+// dnsTimeout is the maximum time to wait for the response of a DNS query.
+var dnsTimeout = 10 * time.Second
+
+// CAA is a Certification Authority Authorization record as defined in
+// RFC 8659.
+type CAA struct {
+	Flag  uint8
+	Tag   string
+	Value string
+}
+
+// CAAOptions are the options used to check the CAA records of the DNS
+// identifiers of an order.
+type CAAOptions struct {
+	// IssuerDomainNames are the CA identities that are allowed to issue in
+	// the issue and issuewild properties.
+	IssuerDomainNames []string
+	// AccountURI is the URL of the ACME account, it must match the
+	// accounturi parameter of the CAA records (RFC 8657).
+	AccountURI string
+	// LookupCAA returns the CAA records of a domain name. It must return an
+	// empty list if the domain does not exist.
+	LookupCAA func(ctx context.Context, name string) ([]*CAA, error)
+}
+
+// checkCAA verifies that the CAA records of the DNS identifiers of the order
+// allow the issuance of the certificate. The records of each identifier are
+// checked with the method used to validate its authorization.
+func (o *Order) checkCAA(ctx context.Context, db DB, caa *CAAOptions) error {
+	if caa == nil {
+		return nil
+	}
+	for _, azID := range o.AuthorizationIDs {
+		az, err := db.GetAuthorization(ctx, azID)
+		if err != nil {
+			return WrapErrorISE(err, "error retrieving authorization %s", azID)
+		}
+		if az.Identifier.Type != DNS {
+			continue
+		}
+
+		var method ChallengeType
+		for _, ch := range az.Challenges {
+			if ch.Status == StatusValid {
+				method = ch.Type
+				break
+			}
+		}
+
+		records, err := caa.relevantRecords(ctx, az.Identifier.Value)
+		if err != nil {
+			return WrapError(ErrorDNSType, err, "error looking up CAA records for %s", az.Identifier.Value)
+		}
+		if !caa.allows(records, az.Wildcard, method) {
+			acmeErr := NewError(ErrorCaaType, "CAA records for %s do not authorize the issuance of the certificate", az.Identifier.Value)
+			acmeErr.Identifier = az.Identifier
+			return acmeErr
+		}
+	}
+	return nil
+}
+
+// relevantRecords returns the relevant CAA record set of a domain name, it
+// climbs the DNS tree until a non empty set is found.
+func (caa *CAAOptions) relevantRecords(ctx context.Context, domain string) ([]*CAA, error) {
+	labels := strings.Split(strings.TrimSuffix(domain, "."), ".")
+	for i := range labels {
+		records, err := caa.LookupCAA(ctx, strings.Join(labels[i:], "."))
+		if err != nil {
+			return nil, err
+		}
+		if len(records) > 0 {
+			return records, nil
+		}
+	}
+	return nil, nil
+}
+
+// allows returns true if the given CAA record set authorizes the issuance
+// for a domain, or a wildcard domain, validated with the given method.
+func (caa *CAAOptions) allows(records []*CAA, wildcard bool, method ChallengeType) bool {
+	tag := "issue"
+	for _, r := range records {
+		switch strings.ToLower(r.Tag) {
+		case "issue", "iodef", "issuemail", "contactemail", "contactphone":
+		case "issuewild":
+			if wildcard {
+				tag = "issuewild"
+			}
+		default:
+			// Unknown properties with the critical flag forbid the issuance.
+			if r.Flag&caaFlagCritical != 0 {
+				return false
+			}
+		}
+	}
+
+	var relevant []*CAA
+	for _, r := range records {
+		if strings.EqualFold(r.Tag, tag) {
+			relevant = append(relevant, r)
+		}
+	}
+	if len(relevant) == 0 {
+		return true
+	}
+
+	for _, r := range relevant {
+		issuer, params, err := parseCAAIssueValue(r.Value)
+		if err != nil || !caa.isIssuer(issuer) {
+			continue
+		}
+		if uri, ok := params["accounturi"]; ok && uri != caa.AccountURI {
+			continue
+		}
+		if methods, ok := params["validationmethods"]; ok && !containsMethod(methods, method) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func (caa *CAAOptions) isIssuer(issuer string) bool {
+	if issuer == "" {
+		return false
+	}
+	for _, name := range caa.IssuerDomainNames {
+		if strings.EqualFold(name, issuer) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsMethod(methods string, method ChallengeType) bool {
+	for _, m := range strings.Split(methods, ",") {
+		if strings.TrimSpace(m) == string(method) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCAAIssueValue parses the value of an issue or issuewild property. The
+// value is an optional issuer domain name followed by a list of parameters
+// separated by semicolons.
+func parseCAAIssueValue(value string) (string, map[string]string, error) {
+	parts := strings.Split(value, ";")
+	issuer := strings.TrimSpace(parts[0])
+	params := make(map[string]string)
+	for _, p := range parts[1:] {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return "", nil, errors.Errorf("malformed CAA parameter '%s'", p)
+		}
+		params[strings.ToLower(strings.TrimSpace(kv[0]))] = strings.TrimSpace(kv[1])
+	}
+	return issuer, params, nil
+}
+
+// parseCAA parses the wire format of a CAA record.
+func parseCAA(data []byte) (*CAA, error) {
+	if len(data) < 2 {
+		return nil, errors.New("malformed CAA record")
+	}
+	tagLen := int(data[1])
+	if tagLen == 0 || len(data) < 2+tagLen {
+		return nil, errors.New("malformed CAA record")
+	}
+	return &CAA{
+		Flag:  data[0],
+		Tag:   string(data[2 : 2+tagLen]),
+		Value: string(data[2+tagLen:]),
+	}, nil
+}
+
+// LookupCAA returns the CAA records of a domain name using the given DNS
+// resolver, or the first nameserver in /etc/resolv.conf if the resolver is
+// empty. Aliases are followed by the recursive resolver. An empty list is
+// returned if the domain does not exist.
+func LookupCAA(ctx context.Context, resolver, name string) ([]*CAA, error) {
+	if resolver == "" {
+		resolver = systemResolver()
+	}
+	if _, _, err := net.SplitHostPort(resolver); err != nil {
+		resolver = net.JoinHostPort(resolver, "53")
+	}
+
+	id, query, err := newCAAQuery(name)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := exchangeDNS(ctx, "udp", resolver, query)
+	if err == nil && resp.Truncated {
+		resp, err = exchangeDNS(ctx, "tcp", resolver, query)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "error querying %s", resolver)
+	}
+	if resp.ID != id {
+		return nil, errors.Errorf("unexpected DNS response id %d", resp.ID)
+	}
+
+	switch resp.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, nil
+	default:
+		return nil, errors.Errorf("DNS query for %s failed with %s", name, resp.RCode)
+	}
+
+	var records []*CAA
+	for _, a := range resp.Answers {
+		u, ok := a.Body.(*dnsmessage.UnknownResource)
+		if !ok || a.Header.Type != caaType {
+			continue
+		}
+		r, err := parseCAA(u.Data)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// systemResolver returns the first nameserver in /etc/resolv.conf.
+func systemResolver() string {
+	b, err := ioutil.ReadFile("/etc/resolv.conf")
+	if err == nil {
+		for _, line := range strings.Split(string(b), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) >= 2 && fields[0] == "nameserver" {
+				return fields[1]
+			}
+		}
+	}
+	return "127.0.0.1"
+}
+
+func newCAAQuery(name string) (uint16, []byte, error) {
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	n, err := dnsmessage.NewName(name)
+	if err != nil {
+		return 0, nil, errors.Wrapf(err, "error creating DNS query for %s", name)
+	}
+	var b [2]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0, nil, errors.Wrap(err, "error generating DNS query id")
+	}
+	id := binary.BigEndian.Uint16(b[:])
+
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+	if err := builder.StartQuestions(); err != nil {
+		return 0, nil, errors.Wrap(err, "error creating DNS query")
+	}
+	if err := builder.Question(dnsmessage.Question{Name: n, Type: caaType, Class: dnsmessage.ClassINET}); err != nil {
+		return 0, nil, errors.Wrap(err, "error creating DNS query")
+	}
+	msg, err := builder.Finish()
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "error creating DNS query")
+	}
+	return id, msg, nil
+}
+
+// exchangeDNS sends a DNS query and reads the response. TCP messages are
+// prefixed with their length.
+func exchangeDNS(ctx context.Context, network, addr string, query []byte) (*dnsmessage.Message, error) {
+	d := net.Dialer{Timeout: dnsTimeout}
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(dnsTimeout)
+	if dl, ok := ctx.Deadline(); ok && dl.Before(deadline) {
+		deadline = dl
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	var buf []byte
+	if network == "tcp" {
+		msg := make([]byte, 2+len(query))
+		binary.BigEndian.PutUint16(msg, uint16(len(query)))
+		copy(msg[2:], query)
+		if _, err := conn.Write(msg); err != nil {
+			return nil, err
+		}
+		var l [2]byte
+		if _, err := io.ReadFull(conn, l[:]); err != nil {
+			return nil, err
+		}
+		buf = make([]byte, binary.BigEndian.Uint16(l[:]))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		buf = make([]byte, 65535)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		buf = buf[:n]
+	}
+
+	resp := new(dnsmessage.Message)
+	if err := resp.Unpack(buf); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,144 @@
+package acme
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/smallstep/assert"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestCAAOptions_allows(t *testing.T) {
+	caa := &CAAOptions{
+		IssuerDomainNames: []string{"ca.internal"},
+		AccountURI:        "https://ca.internal/acme/acme/account/accID",
+	}
+	tests := []struct {
+		name     string
+		records  []*CAA
+		wildcard bool
+		method   ChallengeType
+		want     bool
+	}{
+		{"ok/no-records", nil, false, DNS01, true},
+		{"ok/no-issue", []*CAA{{Tag: "iodef", Value: "mailto:security@internal"}}, false, DNS01, true},
+		{"ok/issue", []*CAA{{Tag: "issue", Value: "other.internal"}, {Tag: "issue", Value: "CA.internal"}}, false, DNS01, true},
+		{"ok/issue-wildcard", []*CAA{{Tag: "issue", Value: "ca.internal"}}, true, DNS01, true},
+		{"ok/issuewild", []*CAA{{Tag: "issue", Value: "other.internal"}, {Tag: "issuewild", Value: "ca.internal"}}, true, DNS01, true},
+		{"ok/issuewild-not-wildcard", []*CAA{{Tag: "issue", Value: "ca.internal"}, {Tag: "issuewild", Value: ";"}}, false, DNS01, true},
+		{"ok/accounturi", []*CAA{{Tag: "issue", Value: "ca.internal; accounturi=https://ca.internal/acme/acme/account/accID"}}, false, DNS01, true},
+		{"ok/validationmethods", []*CAA{{Tag: "issue", Value: "ca.internal; validationmethods=http-01,dns-01"}}, false, DNS01, true},
+		{"ok/unknown-not-critical", []*CAA{{Tag: "foo", Value: "bar"}, {Tag: "issue", Value: "ca.internal"}}, false, DNS01, true},
+		{"fail/issue", []*CAA{{Tag: "issue", Value: "other.internal"}}, false, DNS01, false},
+		{"fail/empty-issuer", []*CAA{{Tag: "issue", Value: ";"}}, false, DNS01, false},
+		{"fail/issuewild", []*CAA{{Tag: "issue", Value: "ca.internal"}, {Tag: "issuewild", Value: ";"}}, true, DNS01, false},
+		{"fail/accounturi", []*CAA{{Tag: "issue", Value: "ca.internal; accounturi=https://ca.internal/acme/acme/account/other"}}, false, DNS01, false},
+		{"fail/validationmethods", []*CAA{{Tag: "issue", Value: "ca.internal; validationmethods=http-01"}}, false, DNS01, false},
+		{"fail/malformed", []*CAA{{Tag: "issue", Value: "ca.internal; foo"}}, false, DNS01, false},
+		{"fail/unknown-critical", []*CAA{{Flag: 128, Tag: "foo", Value: "bar"}, {Tag: "issue", Value: "ca.internal"}}, false, DNS01, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equals(t, caa.allows(tt.records, tt.wildcard, tt.method), tt.want)
+		})
+	}
+}
+
+func TestCAAOptions_relevantRecords(t *testing.T) {
+	var lookups []string
+	caa := &CAAOptions{
+		LookupCAA: func(ctx context.Context, name string) ([]*CAA, error) {
+			lookups = append(lookups, name)
+			if name == "example.internal" {
+				return []*CAA{{Tag: "issue", Value: "ca.internal"}}, nil
+			}
+			return nil, nil
+		},
+	}
+	records, err := caa.relevantRecords(context.Background(), "a.b.example.internal")
+	assert.FatalError(t, err)
+	assert.Equals(t, records, []*CAA{{Tag: "issue", Value: "ca.internal"}})
+	assert.Equals(t, lookups, []string{"a.b.example.internal", "b.example.internal", "example.internal"})
+}
+
+func Test_parseCAA(t *testing.T) {
+	r, err := parseCAA(append([]byte{128, 5}, []byte("issueca.internal")...))
+	assert.FatalError(t, err)
+	assert.Equals(t, r, &CAA{Flag: 128, Tag: "issue", Value: "ca.internal"})
+
+	_, err = parseCAA([]byte{0})
+	assert.Error(t, err)
+	_, err = parseCAA([]byte{0, 0, 'a'})
+	assert.Error(t, err)
+	_, err = parseCAA([]byte{0, 5, 'a'})
+	assert.Error(t, err)
+}
+
+func startDNSServer(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.FatalError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var q dnsmessage.Message
+			if err := q.Unpack(buf[:n]); err != nil || len(q.Questions) != 1 {
+				continue
+			}
+			question := q.Questions[0]
+			resp := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: q.ID, Response: true, RecursionAvailable: true},
+				Questions: q.Questions,
+			}
+			switch question.Name.String() {
+			case "caa.internal.":
+				resp.Answers = []dnsmessage.Resource{
+					{
+						Header: dnsmessage.ResourceHeader{Name: question.Name, Type: caaType, Class: dnsmessage.ClassINET},
+						Body:   &dnsmessage.UnknownResource{Type: caaType, Data: append([]byte{0, 5}, []byte("issueca.internal")...)},
+					},
+					{
+						Header: dnsmessage.ResourceHeader{Name: question.Name, Type: caaType, Class: dnsmessage.ClassINET},
+						Body:   &dnsmessage.UnknownResource{Type: caaType, Data: append([]byte{0, 9}, []byte("issuewild;")...)},
+					},
+				}
+			case "nx.internal.":
+				resp.RCode = dnsmessage.RCodeNameError
+			default:
+				resp.RCode = dnsmessage.RCodeServerFailure
+			}
+			b, err := resp.Pack()
+			if err != nil {
+				continue
+			}
+			conn.WriteTo(b, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestLookupCAA(t *testing.T) {
+	resolver := startDNSServer(t)
+	ctx := context.Background()
+
+	records, err := LookupCAA(ctx, resolver, "caa.internal")
+	assert.FatalError(t, err)
+	assert.Equals(t, records, []*CAA{
+		{Tag: "issue", Value: "ca.internal"},
+		{Tag: "issuewild", Value: ";"},
+	})
+
+	records, err = LookupCAA(ctx, resolver, "nx.internal")
+	assert.FatalError(t, err)
+	assert.Len(t, 0, records)
+
+	_, err = LookupCAA(ctx, resolver, "fail.internal")
+	assert.Error(t, err)
+}
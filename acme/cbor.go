@@ -0,0 +1,156 @@
+package acme
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// cborMaxDepth is the maximum nesting of arrays and maps in a CBOR data item.
+const cborMaxDepth = 16
+
+var errCBORUnexpectedEnd = errors.New("cbor: unexpected end of data")
+
+// cborDecode decodes a CBOR data item as defined in RFC 8949. Only the types
+// used in the attestation objects are supported: integers, byte and text
+// strings, arrays, maps with text keys, booleans and null. Indefinite
+// lengths, tags and floating-point numbers are not supported.
+//
+// Integers are decoded as int64, byte strings as []byte, text strings as
+// string, arrays as []interface{} and maps as map[string]interface{}.
+func cborDecode(data []byte) (interface{}, error) {
+	d := &cborDecoder{data: data}
+	v, err := d.decode(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.off != len(d.data) {
+		return nil, errors.New("cbor: unexpected data after the data item")
+	}
+	return v, nil
+}
+
+type cborDecoder struct {
+	data []byte
+	off  int
+}
+
+// head reads the initial byte of a data item and its argument.
+func (d *cborDecoder) head() (byte, uint64, error) {
+	if d.off >= len(d.data) {
+		return 0, 0, errCBORUnexpectedEnd
+	}
+	b := d.data[d.off]
+	d.off++
+	major, info := b>>5, b&0x1f
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info <= 27:
+		n := 1 << (info - 24)
+		if len(d.data)-d.off < n {
+			return 0, 0, errCBORUnexpectedEnd
+		}
+		var arg uint64
+		for _, c := range d.data[d.off : d.off+n] {
+			arg = arg<<8 | uint64(c)
+		}
+		d.off += n
+		return major, arg, nil
+	default:
+		return 0, 0, fmt.Errorf("cbor: additional information %d is not supported", info)
+	}
+}
+
+// length returns the argument as a length, it fails if there are not enough
+// bytes left for it.
+func (d *cborDecoder) length(arg uint64) (int, error) {
+	if arg > uint64(len(d.data)-d.off) {
+		return 0, errCBORUnexpectedEnd
+	}
+	return int(arg), nil
+}
+
+func (d *cborDecoder) decode(depth int) (interface{}, error) {
+	if depth > cborMaxDepth {
+		return nil, errors.New("cbor: maximum nesting depth exceeded")
+	}
+	major, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case 0, 1:
+		if arg > math.MaxInt64 {
+			return nil, errors.New("cbor: integer overflows int64")
+		}
+		if major == 1 {
+			return -1 - int64(arg), nil
+		}
+		return int64(arg), nil
+	case 2, 3:
+		n, err := d.length(arg)
+		if err != nil {
+			return nil, err
+		}
+		b := d.data[d.off : d.off+n]
+		d.off += n
+		if major == 3 {
+			return string(b), nil
+		}
+		return b, nil
+	case 4:
+		// Every item has at least one byte.
+		n, err := d.length(arg)
+		if err != nil {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = d.decode(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	case 5:
+		// Every pair has at least two bytes.
+		if arg > math.MaxInt32 {
+			return nil, errCBORUnexpectedEnd
+		}
+		n, err := d.length(arg * 2)
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[string]interface{}, n/2)
+		for i := 0; i < n/2; i++ {
+			k, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, errors.New("cbor: map keys must be text strings")
+			}
+			if _, ok := m[key]; ok {
+				return nil, fmt.Errorf("cbor: duplicated map key %s", key)
+			}
+			if m[key], err = d.decode(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case 6:
+		return nil, errors.New("cbor: tags are not supported")
+	default:
+		switch arg {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22:
+			return nil, nil
+		default:
+			return nil, fmt.Errorf("cbor: simple value %d is not supported", arg)
+		}
+	}
+}
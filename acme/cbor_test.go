@@ -0,0 +1,65 @@
+package acme
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/smallstep/assert"
+)
+
+func Test_cborDecode(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want interface{}
+		err  string
+	}{
+		{"ok/uint", "00", int64(0), ""},
+		{"ok/uint-23", "17", int64(23), ""},
+		{"ok/uint-24", "1818", int64(24), ""},
+		{"ok/uint-1000", "1903e8", int64(1000), ""},
+		{"ok/uint-64", "1b000000e8d4a51000", int64(1000000000000), ""},
+		{"ok/negative", "20", int64(-1), ""},
+		{"ok/negative-100", "3863", int64(-100), ""},
+		{"ok/negative-257", "390100", int64(-257), ""},
+		{"ok/bytes", "4401020304", []byte{1, 2, 3, 4}, ""},
+		{"ok/empty-bytes", "40", []byte{}, ""},
+		{"ok/text", "6449455446", "IETF", ""},
+		{"ok/array", "83010203", []interface{}{int64(1), int64(2), int64(3)}, ""},
+		{"ok/map", "a26161016162820203", map[string]interface{}{
+			"a": int64(1),
+			"b": []interface{}{int64(2), int64(3)},
+		}, ""},
+		{"ok/false", "f4", false, ""},
+		{"ok/true", "f5", true, ""},
+		{"ok/null", "f6", nil, ""},
+		{"fail/empty", "", nil, "cbor: unexpected end of data"},
+		{"fail/short-argument", "19", nil, "cbor: unexpected end of data"},
+		{"fail/short-bytes", "4401", nil, "cbor: unexpected end of data"},
+		{"fail/short-array", "9b0000000100000000", nil, "cbor: unexpected end of data"},
+		{"fail/short-map", "bb7fffffffffffffff", nil, "cbor: unexpected end of data"},
+		{"fail/indefinite", "5f", nil, "cbor: additional information 31 is not supported"},
+		{"fail/overflow", "1bffffffffffffffff", nil, "cbor: integer overflows int64"},
+		{"fail/tag", "c0", nil, "cbor: tags are not supported"},
+		{"fail/float", "f93c00", nil, "cbor: simple value 15360 is not supported"},
+		{"fail/map-key", "a10102", nil, "cbor: map keys must be text strings"},
+		{"fail/duplicated-key", "a2616101616102", nil, "cbor: duplicated map key a"},
+		{"fail/trailing-data", "0000", nil, "cbor: unexpected data after the data item"},
+		{"fail/depth", "818181818181818181818181818181818100", nil, "cbor: maximum nesting depth exceeded"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := hex.DecodeString(tt.data)
+			assert.FatalError(t, err)
+			got, err := cborDecode(data)
+			if tt.err != "" {
+				if assert.Error(t, err) {
+					assert.Equals(t, err.Error(), tt.err)
+				}
+				return
+			}
+			assert.FatalError(t, err)
+			assert.Equals(t, got, tt.want)
+		})
+	}
+}
@@ -29,6 +29,12 @@ const (
 	HTTP01    ChallengeType = "http-01"
 	DNS01     ChallengeType = "dns-01"
 	TLSALPN01 ChallengeType = "tls-alpn-01"
+	// EMAILREPLY00 is the challenge type of email identifiers, see RFC 8823.
+	EMAILREPLY00 ChallengeType = "email-reply-00"
+	// DEVICEATTEST01 is the challenge type of permanent-identifier
+	// identifiers, the client proves the identity of the device with an
+	// attestation statement.
+	DEVICEATTEST01 ChallengeType = "device-attest-01"
 )
 
 // Challenge represents an ACME response Challenge type.
@@ -43,6 +49,15 @@ type Challenge struct {
 	ValidatedAt     string        `json:"validated,omitempty"`
 	URL             string        `json:"url"`
 	Error           *Error        `json:"error,omitempty"`
+	// From and TokenPart1 are only used by email-reply-00 challenges, the
+	// first part of the token is only sent in the challenge email.
+	From       string `json:"from,omitempty"`
+	TokenPart1 string `json:"-"`
+	// Payload is the attestation sent by the client to validate a
+	// device-attest-01 challenge, and Fingerprint is the fingerprint of the
+	// attested key once the challenge is valid.
+	Payload     []byte `json:"-"`
+	Fingerprint string `json:"-"`
 }
 
 // ToLog enables response logging.
@@ -63,6 +78,12 @@ func (ch *Challenge) Validate(ctx context.Context, db DB, jwk *jose.JSONWebKey,
 	if ch.Status != StatusPending {
 		return nil
 	}
+	return ch.validate(ctx, db, jwk, vo)
+}
+
+// validate runs the validation method of the challenge type. Transient errors
+// are stored in the challenge without modifying the status.
+func (ch *Challenge) validate(ctx context.Context, db DB, jwk *jose.JSONWebKey, vo *ValidateChallengeOptions) error {
 	switch ch.Type {
 	case HTTP01:
 		return http01Validate(ctx, ch, db, jwk, vo)
@@ -70,6 +91,10 @@ func (ch *Challenge) Validate(ctx context.Context, db DB, jwk *jose.JSONWebKey,
 		return dns01Validate(ctx, ch, db, jwk, vo)
 	case TLSALPN01:
 		return tlsalpn01Validate(ctx, ch, db, jwk, vo)
+	case EMAILREPLY00:
+		return emailReply00Validate(ctx, ch, db, jwk, vo)
+	case DEVICEATTEST01:
+		return deviceAttest01Validate(ctx, ch, db, jwk, vo)
 	default:
 		return NewErrorISE("unexpected challenge type '%s'", ch.Type)
 	}
@@ -366,10 +391,17 @@ func storeError(ctx context.Context, db DB, ch *Challenge, markInvalid bool, err
 type httpGetter func(string) (*http.Response, error)
 type lookupTxt func(string) ([]string, error)
 type tlsDialer func(network, addr string, config *tls.Config) (*tls.Conn, error)
+type emailReplies func(ctx context.Context, from string) ([][]byte, error)
 
 // ValidateChallengeOptions are ACME challenge validator functions.
 type ValidateChallengeOptions struct {
 	HTTPGet   httpGetter
 	LookupTxt lookupTxt
 	TLSDial   tlsDialer
+	// EmailReplies returns the raw email messages received from an address,
+	// it is nil if email-reply-00 challenges are not supported.
+	EmailReplies emailReplies
+	// Attestation are the options used to validate device-attest-01
+	// challenges, it is nil if they are not supported.
+	Attestation *AttestationOptions
 }
@@ -5,12 +5,16 @@ import (
 	"crypto/x509"
 	"time"
 
+	"github.com/smallstep/certificates/authority"
 	"github.com/smallstep/certificates/authority/provisioner"
+	"go.step.sm/crypto/x509util"
 )
 
 // CertificateAuthority is the interface implemented by a CA authority.
 type CertificateAuthority interface {
 	Sign(cr *x509.CertificateRequest, opts provisioner.SignOptions, signOpts ...provisioner.SignOption) ([]*x509.Certificate, error)
+	IsRevoked(sn string) (bool, error)
+	Revoke(context.Context, *authority.RevokeOptions) error
 	LoadProvisionerByName(string) (provisioner.Interface, error)
 }
 
@@ -27,22 +31,30 @@ var clock Clock
 // Provisioner is an interface that implements a subset of the provisioner.Interface --
 // only those methods required by the ACME api/authority.
 type Provisioner interface {
+	AuthorizeOrderIdentifier(ctx context.Context, identifier provisioner.ACMEIdentifier) error
 	AuthorizeSign(ctx context.Context, token string) ([]provisioner.SignOption, error)
+	AuthorizeRevoke(ctx context.Context, token string) error
 	GetID() string
 	GetName() string
 	DefaultTLSCertDuration() time.Duration
 	GetOptions() *provisioner.Options
+	GetProfile(name string) (*provisioner.ACMEProfile, error)
+	WebhookOption(data x509util.TemplateData) provisioner.SignOption
 }
 
 // MockProvisioner for testing
 type MockProvisioner struct {
-	Mret1                   interface{}
-	Merr                    error
-	MgetID                  func() string
-	MgetName                func() string
-	MauthorizeSign          func(ctx context.Context, ott string) ([]provisioner.SignOption, error)
-	MdefaultTLSCertDuration func() time.Duration
-	MgetOptions             func() *provisioner.Options
+	Mret1                     interface{}
+	Merr                      error
+	MgetID                    func() string
+	MgetName                  func() string
+	MauthorizeOrderIdentifier func(ctx context.Context, identifier provisioner.ACMEIdentifier) error
+	MauthorizeSign            func(ctx context.Context, ott string) ([]provisioner.SignOption, error)
+	MauthorizeRevoke          func(ctx context.Context, token string) error
+	MdefaultTLSCertDuration   func() time.Duration
+	MgetOptions               func() *provisioner.Options
+	MgetProfile               func(name string) (*provisioner.ACMEProfile, error)
+	MwebhookOption            func(data x509util.TemplateData) provisioner.SignOption
 }
 
 // GetName mock
@@ -53,6 +65,14 @@ func (m *MockProvisioner) GetName() string {
 	return m.Mret1.(string)
 }
 
+// AuthorizeOrderIdentifier mock
+func (m *MockProvisioner) AuthorizeOrderIdentifier(ctx context.Context, identifier provisioner.ACMEIdentifier) error {
+	if m.MauthorizeOrderIdentifier != nil {
+		return m.MauthorizeOrderIdentifier(ctx, identifier)
+	}
+	return nil
+}
+
 // AuthorizeSign mock
 func (m *MockProvisioner) AuthorizeSign(ctx context.Context, ott string) ([]provisioner.SignOption, error) {
 	if m.MauthorizeSign != nil {
@@ -61,6 +81,14 @@ func (m *MockProvisioner) AuthorizeSign(ctx context.Context, ott string) ([]prov
 	return m.Mret1.([]provisioner.SignOption), m.Merr
 }
 
+// AuthorizeRevoke mock
+func (m *MockProvisioner) AuthorizeRevoke(ctx context.Context, token string) error {
+	if m.MauthorizeRevoke != nil {
+		return m.MauthorizeRevoke(ctx, token)
+	}
+	return m.Merr
+}
+
 // DefaultTLSCertDuration mock
 func (m *MockProvisioner) DefaultTLSCertDuration() time.Duration {
 	if m.MdefaultTLSCertDuration != nil {
@@ -77,6 +105,22 @@ func (m *MockProvisioner) GetOptions() *provisioner.Options {
 	return m.Mret1.(*provisioner.Options)
 }
 
+// GetProfile mock
+func (m *MockProvisioner) GetProfile(name string) (*provisioner.ACMEProfile, error) {
+	if m.MgetProfile != nil {
+		return m.MgetProfile(name)
+	}
+	return nil, m.Merr
+}
+
+// WebhookOption mock
+func (m *MockProvisioner) WebhookOption(data x509util.TemplateData) provisioner.SignOption {
+	if m.MwebhookOption != nil {
+		return m.MwebhookOption(data)
+	}
+	return nil
+}
+
 // GetID mock
 func (m *MockProvisioner) GetID() string {
 	if m.MgetID != nil {
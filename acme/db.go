@@ -2,8 +2,10 @@ package acme
 
 import (
 	"context"
+	"time"
 
 	"github.com/pkg/errors"
+	"go.step.sm/crypto/jose"
 )
 
 // ErrNotFound is an error that should be used by the acme.DB interface to
@@ -18,16 +20,29 @@ type DB interface {
 	GetAccount(ctx context.Context, id string) (*Account, error)
 	GetAccountByKeyID(ctx context.Context, kid string) (*Account, error)
 	UpdateAccount(ctx context.Context, acc *Account) error
+	UpdateAccountKey(ctx context.Context, acc *Account, newKey *jose.JSONWebKey) error
+	GetAccounts(ctx context.Context, provisionerID string) ([]*Account, error)
+	GetAccountOrders(ctx context.Context, accountID string) ([]*Order, error)
+	GetAccountCertificates(ctx context.Context, accountID string) ([]*Certificate, error)
+
+	CreateExternalAccountKey(ctx context.Context, provisionerID, reference string) (*ExternalAccountKey, error)
+	GetExternalAccountKey(ctx context.Context, provisionerID, keyID string) (*ExternalAccountKey, error)
+	GetExternalAccountKeys(ctx context.Context, provisionerID string) ([]*ExternalAccountKey, error)
+	GetExternalAccountKeyByReference(ctx context.Context, provisionerID, reference string) (*ExternalAccountKey, error)
+	DeleteExternalAccountKey(ctx context.Context, provisionerID, keyID string) error
+	UpdateExternalAccountKey(ctx context.Context, provisionerID string, eak *ExternalAccountKey) error
 
 	CreateNonce(ctx context.Context) (Nonce, error)
 	DeleteNonce(ctx context.Context, nonce Nonce) error
 
 	CreateAuthorization(ctx context.Context, az *Authorization) error
 	GetAuthorization(ctx context.Context, id string) (*Authorization, error)
+	GetAuthorizationsByAccountID(ctx context.Context, accountID string, identifier Identifier) ([]*Authorization, error)
 	UpdateAuthorization(ctx context.Context, az *Authorization) error
 
 	CreateCertificate(ctx context.Context, cert *Certificate) error
 	GetCertificate(ctx context.Context, id string) (*Certificate, error)
+	GetCertificateBySerial(ctx context.Context, serial string) (*Certificate, error)
 
 	CreateChallenge(ctx context.Context, ch *Challenge) error
 	GetChallenge(ctx context.Context, id, authzID string) (*Challenge, error)
@@ -37,6 +52,9 @@ type DB interface {
 	GetOrder(ctx context.Context, id string) (*Order, error)
 	GetOrdersByAccountID(ctx context.Context, accountID string) ([]string, error)
 	UpdateOrder(ctx context.Context, o *Order) error
+
+	GetRateLimitEvents(ctx context.Context, key string) ([]time.Time, error)
+	AddRateLimitEvent(ctx context.Context, key string, t time.Time, window time.Duration) error
 }
 
 // MockDB is an implementation of the DB interface that should only be used as
@@ -46,6 +64,18 @@ type MockDB struct {
 	MockGetAccount        func(ctx context.Context, id string) (*Account, error)
 	MockGetAccountByKeyID func(ctx context.Context, kid string) (*Account, error)
 	MockUpdateAccount     func(ctx context.Context, acc *Account) error
+	MockUpdateAccountKey  func(ctx context.Context, acc *Account, newKey *jose.JSONWebKey) error
+
+	MockGetAccounts            func(ctx context.Context, provisionerID string) ([]*Account, error)
+	MockGetAccountOrders       func(ctx context.Context, accountID string) ([]*Order, error)
+	MockGetAccountCertificates func(ctx context.Context, accountID string) ([]*Certificate, error)
+
+	MockCreateExternalAccountKey         func(ctx context.Context, provisionerID, reference string) (*ExternalAccountKey, error)
+	MockGetExternalAccountKey            func(ctx context.Context, provisionerID, keyID string) (*ExternalAccountKey, error)
+	MockGetExternalAccountKeys           func(ctx context.Context, provisionerID string) ([]*ExternalAccountKey, error)
+	MockGetExternalAccountKeyByReference func(ctx context.Context, provisionerID, reference string) (*ExternalAccountKey, error)
+	MockDeleteExternalAccountKey         func(ctx context.Context, provisionerID, keyID string) error
+	MockUpdateExternalAccountKey         func(ctx context.Context, provisionerID string, eak *ExternalAccountKey) error
 
 	MockCreateNonce func(ctx context.Context) (Nonce, error)
 	MockDeleteNonce func(ctx context.Context, nonce Nonce) error
@@ -54,9 +84,13 @@ type MockDB struct {
 	MockGetAuthorization    func(ctx context.Context, id string) (*Authorization, error)
 	MockUpdateAuthorization func(ctx context.Context, az *Authorization) error
 
+	MockGetAuthorizationsByAccountID func(ctx context.Context, accountID string, identifier Identifier) ([]*Authorization, error)
+
 	MockCreateCertificate func(ctx context.Context, cert *Certificate) error
 	MockGetCertificate    func(ctx context.Context, id string) (*Certificate, error)
 
+	MockGetCertificateBySerial func(ctx context.Context, serial string) (*Certificate, error)
+
 	MockCreateChallenge func(ctx context.Context, ch *Challenge) error
 	MockGetChallenge    func(ctx context.Context, id, authzID string) (*Challenge, error)
 	MockUpdateChallenge func(ctx context.Context, ch *Challenge) error
@@ -66,6 +100,9 @@ type MockDB struct {
 	MockGetOrdersByAccountID func(ctx context.Context, accountID string) ([]string, error)
 	MockUpdateOrder          func(ctx context.Context, o *Order) error
 
+	MockGetRateLimitEvents func(ctx context.Context, key string) ([]time.Time, error)
+	MockAddRateLimitEvent  func(ctx context.Context, key string, t time.Time, window time.Duration) error
+
 	MockRet1  interface{}
 	MockError error
 }
@@ -110,6 +147,106 @@ func (m *MockDB) UpdateAccount(ctx context.Context, acc *Account) error {
 	return m.MockError
 }
 
+// UpdateAccountKey mock
+func (m *MockDB) UpdateAccountKey(ctx context.Context, acc *Account, newKey *jose.JSONWebKey) error {
+	if m.MockUpdateAccountKey != nil {
+		return m.MockUpdateAccountKey(ctx, acc, newKey)
+	} else if m.MockError != nil {
+		return m.MockError
+	}
+	return m.MockError
+}
+
+// GetAccounts mock
+func (m *MockDB) GetAccounts(ctx context.Context, provisionerID string) ([]*Account, error) {
+	if m.MockGetAccounts != nil {
+		return m.MockGetAccounts(ctx, provisionerID)
+	} else if m.MockError != nil {
+		return nil, m.MockError
+	}
+	return m.MockRet1.([]*Account), m.MockError
+}
+
+// GetAccountOrders mock
+func (m *MockDB) GetAccountOrders(ctx context.Context, accountID string) ([]*Order, error) {
+	if m.MockGetAccountOrders != nil {
+		return m.MockGetAccountOrders(ctx, accountID)
+	} else if m.MockError != nil {
+		return nil, m.MockError
+	}
+	return m.MockRet1.([]*Order), m.MockError
+}
+
+// GetAccountCertificates mock
+func (m *MockDB) GetAccountCertificates(ctx context.Context, accountID string) ([]*Certificate, error) {
+	if m.MockGetAccountCertificates != nil {
+		return m.MockGetAccountCertificates(ctx, accountID)
+	} else if m.MockError != nil {
+		return nil, m.MockError
+	}
+	return m.MockRet1.([]*Certificate), m.MockError
+}
+
+// CreateExternalAccountKey mock
+func (m *MockDB) CreateExternalAccountKey(ctx context.Context, provisionerID, reference string) (*ExternalAccountKey, error) {
+	if m.MockCreateExternalAccountKey != nil {
+		return m.MockCreateExternalAccountKey(ctx, provisionerID, reference)
+	} else if m.MockError != nil {
+		return nil, m.MockError
+	}
+	return m.MockRet1.(*ExternalAccountKey), m.MockError
+}
+
+// GetExternalAccountKey mock
+func (m *MockDB) GetExternalAccountKey(ctx context.Context, provisionerID, keyID string) (*ExternalAccountKey, error) {
+	if m.MockGetExternalAccountKey != nil {
+		return m.MockGetExternalAccountKey(ctx, provisionerID, keyID)
+	} else if m.MockError != nil {
+		return nil, m.MockError
+	}
+	return m.MockRet1.(*ExternalAccountKey), m.MockError
+}
+
+// GetExternalAccountKeys mock
+func (m *MockDB) GetExternalAccountKeys(ctx context.Context, provisionerID string) ([]*ExternalAccountKey, error) {
+	if m.MockGetExternalAccountKeys != nil {
+		return m.MockGetExternalAccountKeys(ctx, provisionerID)
+	} else if m.MockError != nil {
+		return nil, m.MockError
+	}
+	return m.MockRet1.([]*ExternalAccountKey), m.MockError
+}
+
+// GetExternalAccountKeyByReference mock
+func (m *MockDB) GetExternalAccountKeyByReference(ctx context.Context, provisionerID, reference string) (*ExternalAccountKey, error) {
+	if m.MockGetExternalAccountKeyByReference != nil {
+		return m.MockGetExternalAccountKeyByReference(ctx, provisionerID, reference)
+	} else if m.MockError != nil {
+		return nil, m.MockError
+	}
+	return m.MockRet1.(*ExternalAccountKey), m.MockError
+}
+
+// DeleteExternalAccountKey mock
+func (m *MockDB) DeleteExternalAccountKey(ctx context.Context, provisionerID, keyID string) error {
+	if m.MockDeleteExternalAccountKey != nil {
+		return m.MockDeleteExternalAccountKey(ctx, provisionerID, keyID)
+	} else if m.MockError != nil {
+		return m.MockError
+	}
+	return m.MockError
+}
+
+// UpdateExternalAccountKey mock
+func (m *MockDB) UpdateExternalAccountKey(ctx context.Context, provisionerID string, eak *ExternalAccountKey) error {
+	if m.MockUpdateExternalAccountKey != nil {
+		return m.MockUpdateExternalAccountKey(ctx, provisionerID, eak)
+	} else if m.MockError != nil {
+		return m.MockError
+	}
+	return m.MockError
+}
+
 // CreateNonce mock
 func (m *MockDB) CreateNonce(ctx context.Context) (Nonce, error) {
 	if m.MockCreateNonce != nil {
@@ -150,6 +287,16 @@ func (m *MockDB) GetAuthorization(ctx context.Context, id string) (*Authorizatio
 	return m.MockRet1.(*Authorization), m.MockError
 }
 
+// GetAuthorizationsByAccountID mock
+func (m *MockDB) GetAuthorizationsByAccountID(ctx context.Context, accountID string, identifier Identifier) ([]*Authorization, error) {
+	if m.MockGetAuthorizationsByAccountID != nil {
+		return m.MockGetAuthorizationsByAccountID(ctx, accountID, identifier)
+	} else if m.MockError != nil {
+		return nil, m.MockError
+	}
+	return m.MockRet1.([]*Authorization), m.MockError
+}
+
 // UpdateAuthorization mock
 func (m *MockDB) UpdateAuthorization(ctx context.Context, az *Authorization) error {
 	if m.MockUpdateAuthorization != nil {
@@ -180,6 +327,16 @@ func (m *MockDB) GetCertificate(ctx context.Context, id string) (*Certificate, e
 	return m.MockRet1.(*Certificate), m.MockError
 }
 
+// GetCertificateBySerial mock
+func (m *MockDB) GetCertificateBySerial(ctx context.Context, serial string) (*Certificate, error) {
+	if m.MockGetCertificateBySerial != nil {
+		return m.MockGetCertificateBySerial(ctx, serial)
+	} else if m.MockError != nil {
+		return nil, m.MockError
+	}
+	return m.MockRet1.(*Certificate), m.MockError
+}
+
 // CreateChallenge mock
 func (m *MockDB) CreateChallenge(ctx context.Context, ch *Challenge) error {
 	if m.MockCreateChallenge != nil {
@@ -249,3 +406,23 @@ func (m *MockDB) GetOrdersByAccountID(ctx context.Context, accID string) ([]stri
 	}
 	return m.MockRet1.([]string), m.MockError
 }
+
+// GetRateLimitEvents mock
+func (m *MockDB) GetRateLimitEvents(ctx context.Context, key string) ([]time.Time, error) {
+	if m.MockGetRateLimitEvents != nil {
+		return m.MockGetRateLimitEvents(ctx, key)
+	} else if m.MockError != nil {
+		return nil, m.MockError
+	}
+	return m.MockRet1.([]time.Time), m.MockError
+}
+
+// AddRateLimitEvent mock
+func (m *MockDB) AddRateLimitEvent(ctx context.Context, key string, t time.Time, window time.Duration) error {
+	if m.MockAddRateLimitEvent != nil {
+		return m.MockAddRateLimitEvent(ctx, key, t, window)
+	} else if m.MockError != nil {
+		return m.MockError
+	}
+	return m.MockError
+}
@@ -8,12 +8,14 @@ import (
 	"github.com/pkg/errors"
 	"github.com/smallstep/certificates/acme"
 	nosqlDB "github.com/smallstep/nosql"
+	"github.com/smallstep/nosql/database"
 	"go.step.sm/crypto/jose"
 )
 
 // dbAccount represents an ACME account.
 type dbAccount struct {
 	ID            string           `json:"id"`
+	ProvisionerID string           `json:"provisionerID,omitempty"`
 	Key           *jose.JSONWebKey `json:"key"`
 	Contact       []string         `json:"contact,omitempty"`
 	Status        acme.Status      `json:"status"`
@@ -26,6 +28,17 @@ func (dba *dbAccount) clone() *dbAccount {
 	return &nu
 }
 
+func (dba *dbAccount) toACME() *acme.Account {
+	return &acme.Account{
+		ID:            dba.ID,
+		ProvisionerID: dba.ProvisionerID,
+		CreatedAt:     dba.CreatedAt,
+		Status:        dba.Status,
+		Contact:       dba.Contact,
+		Key:           dba.Key,
+	}
+}
+
 func (db *DB) getAccountIDByKeyID(ctx context.Context, kid string) (string, error) {
 	id, err := db.db.Get(accountByKeyIDTable, []byte(kid))
 	if err != nil {
@@ -60,13 +73,30 @@ func (db *DB) GetAccount(ctx context.Context, id string) (*acme.Account, error)
 	if err != nil {
 		return nil, err
 	}
+	return dbacc.toACME(), nil
+}
+
+// GetAccounts retrieves all the ACME accounts of a provisioner. Accounts
+// created before the provisioner was stored with them are returned for every
+// provisioner.
+func (db *DB) GetAccounts(ctx context.Context, provisionerID string) ([]*acme.Account, error) {
+	entries, err := db.db.List(accountTable)
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing accounts")
+	}
 
-	return &acme.Account{
-		Status:  dbacc.Status,
-		Contact: dbacc.Contact,
-		Key:     dbacc.Key,
-		ID:      dbacc.ID,
-	}, nil
+	accounts := []*acme.Account{}
+	for _, entry := range entries {
+		dbacc := new(dbAccount)
+		if err = json.Unmarshal(entry.Value, dbacc); err != nil {
+			return nil, errors.Wrapf(err, "error unmarshaling account %s into dbAccount", string(entry.Key))
+		}
+		if dbacc.ProvisionerID != "" && dbacc.ProvisionerID != provisionerID {
+			continue
+		}
+		accounts = append(accounts, dbacc.toACME())
+	}
+	return accounts, nil
 }
 
 // GetAccountByKeyID retrieves an ACME account by KeyID (thumbprint of the Account Key -- JWK).
@@ -87,11 +117,12 @@ func (db *DB) CreateAccount(ctx context.Context, acc *acme.Account) error {
 	}
 
 	dba := &dbAccount{
-		ID:        acc.ID,
-		Key:       acc.Key,
-		Contact:   acc.Contact,
-		Status:    acc.Status,
-		CreatedAt: clock.Now(),
+		ID:            acc.ID,
+		ProvisionerID: acc.ProvisionerID,
+		Key:           acc.Key,
+		Contact:       acc.Contact,
+		Status:        acc.Status,
+		CreatedAt:     clock.Now(),
 	}
 
 	kid, err := acme.KeyToID(dba.Key)
@@ -134,3 +165,67 @@ func (db *DB) UpdateAccount(ctx context.Context, acc *acme.Account) error {
 
 	return db.save(ctx, old.ID, nu, old, "account", accountTable)
 }
+
+// UpdateAccountKey implements the AcmeDB.UpdateAccountKey interface. It
+// replaces the key of the account and updates the key-account index.
+func (db *DB) UpdateAccountKey(ctx context.Context, acc *acme.Account, newKey *jose.JSONWebKey) error {
+	old, err := db.getDBAccount(ctx, acc.ID)
+	if err != nil {
+		return err
+	}
+	oldKid, err := acme.KeyToID(old.Key)
+	if err != nil {
+		return err
+	}
+	newKid, err := acme.KeyToID(newKey)
+	if err != nil {
+		return err
+	}
+
+	nu := old.clone()
+	nu.Key = newKey
+	nuB, err := json.Marshal(nu)
+	if err != nil {
+		return errors.Wrapf(err, "error marshaling acme type: account, value: %v", nu)
+	}
+
+	// Set the new jwkID -> acme account ID index, this will fail if the key
+	// is already used by another account.
+	newKidB := []byte(newKid)
+	_, swapped, err := db.db.CmpAndSwap(accountByKeyIDTable, newKidB, nil, []byte(acc.ID))
+	switch {
+	case err != nil:
+		return errors.Wrap(err, "error storing keyID to accountID index")
+	case !swapped:
+		return errors.Errorf("key-id to account-id index already exists")
+	}
+
+	// Update the account and remove the old index in the same transaction.
+	// The transaction will fail if the old index has already been removed by
+	// a concurrent key change.
+	oldKidB := []byte(oldKid)
+	if err := db.db.Update(&database.Tx{
+		Operations: []*database.TxEntry{
+			{
+				Bucket: accountByKeyIDTable,
+				Key:    oldKidB,
+				Cmd:    database.Get,
+			},
+			{
+				Bucket: accountTable,
+				Key:    []byte(acc.ID),
+				Value:  nuB,
+				Cmd:    database.Set,
+			},
+			{
+				Bucket: accountByKeyIDTable,
+				Key:    oldKidB,
+				Cmd:    database.Delete,
+			},
+		},
+	}); err != nil {
+		db.db.Del(accountByKeyIDTable, newKidB)
+		return errors.Wrapf(err, "error updating key of account %s", acc.ID)
+	}
+	return nil
+}
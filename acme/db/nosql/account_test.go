@@ -227,6 +227,7 @@ func TestDB_GetAccount(t *testing.T) {
 			assert.FatalError(t, err)
 			dbacc := &dbAccount{
 				ID:            accID,
+				ProvisionerID: "provID",
 				Status:        acme.StatusDeactivated,
 				CreatedAt:     now,
 				DeactivatedAt: now,
@@ -269,6 +270,7 @@ func TestDB_GetAccount(t *testing.T) {
 			} else {
 				if assert.Nil(t, tc.err) {
 					assert.Equals(t, acc.ID, tc.dbacc.ID)
+					assert.Equals(t, acc.ProvisionerID, tc.dbacc.ProvisionerID)
 					assert.Equals(t, acc.Status, tc.dbacc.Status)
 					assert.Equals(t, acc.Contact, tc.dbacc.Contact)
 					assert.Equals(t, acc.Key.KeyID, tc.dbacc.Key.KeyID)
@@ -278,6 +280,77 @@ func TestDB_GetAccount(t *testing.T) {
 	}
 }
 
+func TestDB_GetAccounts(t *testing.T) {
+	provID := "provID"
+	mustEntry := func(t *testing.T, dbacc *dbAccount) *nosqldb.Entry {
+		b, err := json.Marshal(dbacc)
+		assert.FatalError(t, err)
+		return &nosqldb.Entry{Bucket: accountTable, Key: []byte(dbacc.ID), Value: b}
+	}
+	type test struct {
+		db       nosql.DB
+		err      error
+		accounts []string
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/db.List-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MList: func(bucket []byte) ([]*nosqldb.Entry, error) {
+						assert.Equals(t, bucket, accountTable)
+						return nil, errors.New("force")
+					},
+				},
+				err: errors.New("error listing accounts: force"),
+			}
+		},
+		"fail/unmarshal-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MList: func(bucket []byte) ([]*nosqldb.Entry, error) {
+						return []*nosqldb.Entry{{Bucket: bucket, Key: []byte("foo"), Value: []byte("foo")}}, nil
+					},
+				},
+				err: errors.New("error unmarshaling account foo into dbAccount"),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MList: func(bucket []byte) ([]*nosqldb.Entry, error) {
+						return []*nosqldb.Entry{
+							mustEntry(t, &dbAccount{ID: "acc1", ProvisionerID: provID}),
+							mustEntry(t, &dbAccount{ID: "acc2", ProvisionerID: "otherProvID"}),
+							mustEntry(t, &dbAccount{ID: "acc3"}),
+						}, nil
+					},
+				},
+				accounts: []string{"acc1", "acc3"},
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			db := DB{db: tc.db}
+			accs, err := db.GetAccounts(context.Background(), provID)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+				return
+			}
+			if assert.Nil(t, tc.err) {
+				var ids []string
+				for _, acc := range accs {
+					ids = append(ids, acc.ID)
+				}
+				assert.Equals(t, ids, tc.accounts)
+			}
+		})
+	}
+}
+
 func TestDB_GetAccountByKeyID(t *testing.T) {
 	accID := "accID"
 	kid := "kid"
@@ -487,9 +560,10 @@ func TestDB_CreateAccount(t *testing.T) {
 			jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
 			assert.FatalError(t, err)
 			acc := &acme.Account{
-				Status:  acme.StatusValid,
-				Contact: []string{"foo", "bar"},
-				Key:     jwk,
+				ProvisionerID: "provID",
+				Status:        acme.StatusValid,
+				Contact:       []string{"foo", "bar"},
+				Key:           jwk,
 			}
 			return test{
 				db: &db.MockNoSQLDB{
@@ -507,6 +581,7 @@ func TestDB_CreateAccount(t *testing.T) {
 							dbacc := new(dbAccount)
 							assert.FatalError(t, json.Unmarshal(nu, dbacc))
 							assert.Equals(t, dbacc.ID, string(key))
+							assert.Equals(t, dbacc.ProvisionerID, "provID")
 							assert.Equals(t, dbacc.Contact, acc.Contact)
 							assert.Equals(t, dbacc.Key.KeyID, acc.Key.KeyID)
 							assert.True(t, clock.Now().Add(-time.Minute).Before(dbacc.CreatedAt))
@@ -704,3 +779,134 @@ func TestDB_UpdateAccount(t *testing.T) {
 		})
 	}
 }
+
+func TestDB_UpdateAccountKey(t *testing.T) {
+	accID := "accID"
+	oldKey, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	assert.FatalError(t, err)
+	newKey, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	assert.FatalError(t, err)
+	oldKid, err := acme.KeyToID(oldKey)
+	assert.FatalError(t, err)
+	newKid, err := acme.KeyToID(newKey)
+	assert.FatalError(t, err)
+	dbacc := &dbAccount{
+		ID:        accID,
+		Status:    acme.StatusValid,
+		CreatedAt: clock.Now(),
+		Contact:   []string{"foo", "bar"},
+		Key:       oldKey,
+	}
+	b, err := json.Marshal(dbacc)
+	assert.FatalError(t, err)
+	getAccount := func(bucket, key []byte) ([]byte, error) {
+		assert.Equals(t, bucket, accountTable)
+		assert.Equals(t, string(key), accID)
+		return b, nil
+	}
+	type test struct {
+		db  nosql.DB
+		err error
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/db.Get-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, errors.New("force")
+					},
+				},
+				err: errors.New("error loading account accID: force"),
+			}
+		},
+		"fail/db.CmpAndSwap-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: getAccount,
+					MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+						return nil, false, errors.New("force")
+					},
+				},
+				err: errors.New("error storing keyID to accountID index: force"),
+			}
+		},
+		"fail/db.CmpAndSwap-false": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: getAccount,
+					MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+						return []byte("otherID"), false, nil
+					},
+				},
+				err: errors.New("key-id to account-id index already exists"),
+			}
+		},
+		"fail/db.Update-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: getAccount,
+					MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+						return nu, true, nil
+					},
+					MUpdate: func(tx *nosqldb.Tx) error {
+						return errors.New("force")
+					},
+					MDel: func(bucket, key []byte) error {
+						assert.Equals(t, bucket, accountByKeyIDTable)
+						assert.Equals(t, string(key), newKid)
+						return nil
+					},
+				},
+				err: errors.New("error updating key of account accID: force"),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: getAccount,
+					MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+						assert.Equals(t, bucket, accountByKeyIDTable)
+						assert.Equals(t, string(key), newKid)
+						assert.Equals(t, old, nil)
+						assert.Equals(t, string(nu), accID)
+						return nu, true, nil
+					},
+					MUpdate: func(tx *nosqldb.Tx) error {
+						assert.Len(t, 3, tx.Operations)
+						assert.Equals(t, tx.Operations[0].Cmd, nosqldb.Get)
+						assert.Equals(t, tx.Operations[0].Bucket, accountByKeyIDTable)
+						assert.Equals(t, string(tx.Operations[0].Key), oldKid)
+
+						assert.Equals(t, tx.Operations[1].Cmd, nosqldb.Set)
+						assert.Equals(t, tx.Operations[1].Bucket, accountTable)
+						assert.Equals(t, string(tx.Operations[1].Key), accID)
+						dbNew := new(dbAccount)
+						assert.FatalError(t, json.Unmarshal(tx.Operations[1].Value, dbNew))
+						assert.Equals(t, dbNew.ID, accID)
+						assert.Equals(t, dbNew.Contact, dbacc.Contact)
+						assert.Equals(t, dbNew.Status, dbacc.Status)
+						assert.Equals(t, dbNew.Key.KeyID, newKey.KeyID)
+
+						assert.Equals(t, tx.Operations[2].Cmd, nosqldb.Delete)
+						assert.Equals(t, tx.Operations[2].Bucket, accountByKeyIDTable)
+						assert.Equals(t, string(tx.Operations[2].Key), oldKid)
+						return nil
+					},
+				},
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			db := DB{db: tc.db}
+			if err := db.UpdateAccountKey(context.Background(), &acme.Account{ID: accID}, newKey); err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
@@ -3,6 +3,8 @@ package nosql
 import (
 	"context"
 	"encoding/json"
+	"net/http"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -10,6 +12,9 @@ import (
 	"github.com/smallstep/nosql"
 )
 
+// Mutex for locking authzsByAccount index operations.
+var authzsByAccountMux sync.Mutex
+
 // dbAuthz is the base authz type that others build from.
 type dbAuthz struct {
 	ID           string          `json:"id"`
@@ -100,7 +105,98 @@ func (db *DB) CreateAuthorization(ctx context.Context, az *acme.Authorization) e
 		Wildcard:     az.Wildcard,
 	}
 
-	return db.save(ctx, az.ID, dbaz, nil, "authz", authzTable)
+	if err := db.save(ctx, az.ID, dbaz, nil, "authz", authzTable); err != nil {
+		return err
+	}
+	if err := db.addExpiration(ctx, authzTable, az.ID, az.ExpiresAt); err != nil {
+		return err
+	}
+	if err := db.addAuthzID(ctx, az.AccountID, authzIdentifier(az.Identifier, az.Wildcard), az.ID); err != nil {
+		// Ignore error from delete -- we tried our best.
+		db.db.Del(authzTable, []byte(az.ID))
+		return err
+	}
+	return nil
+}
+
+// authzIdentifier returns the identifier of an authorization as it appears in
+// an order, wildcard identifiers start with "*.".
+func authzIdentifier(identifier acme.Identifier, wildcard bool) acme.Identifier {
+	if wildcard {
+		identifier.Value = "*." + identifier.Value
+	}
+	return identifier
+}
+
+// authzIndexKey returns the key of the index of authorizations by account and
+// identifier.
+func authzIndexKey(accID string, identifier acme.Identifier) []byte {
+	return []byte(accID + "#" + string(identifier.Type) + "#" + identifier.Value)
+}
+
+// addAuthzID adds an authorization to the index of authorizations by account
+// and identifier.
+func (db *DB) addAuthzID(ctx context.Context, accID string, identifier acme.Identifier, azID string) error {
+	authzsByAccountMux.Lock()
+	defer authzsByAccountMux.Unlock()
+
+	key := authzIndexKey(accID, identifier)
+	var oldIDs []string
+	b, err := db.db.Get(authzsByAccountIDTable, key)
+	if err != nil {
+		if !nosql.IsErrNotFound(err) {
+			return errors.Wrapf(err, "error loading authzIDs for account %s", accID)
+		}
+		b = nil
+	} else if err := json.Unmarshal(b, &oldIDs); err != nil {
+		return errors.Wrapf(err, "error unmarshaling authzIDs for account %s", accID)
+	}
+
+	newB, err := json.Marshal(append(oldIDs, azID))
+	if err != nil {
+		return errors.Wrapf(err, "error marshaling authzIDs for account %s", accID)
+	}
+	_, swapped, err := db.db.CmpAndSwap(authzsByAccountIDTable, key, b, newB)
+	switch {
+	case err != nil:
+		return errors.Wrapf(err, "error saving authzIDs index for account %s", accID)
+	case !swapped:
+		return errors.Errorf("error saving authzIDs index for account %s; changed since last read", accID)
+	default:
+		return nil
+	}
+}
+
+// GetAuthorizationsByAccountID returns the authorizations of an account for an
+// identifier, wildcard identifiers start with "*.". Authorizations that no
+// longer exist are skipped.
+func (db *DB) GetAuthorizationsByAccountID(ctx context.Context, accID string, identifier acme.Identifier) ([]*acme.Authorization, error) {
+	b, err := db.db.Get(authzsByAccountIDTable, authzIndexKey(accID, identifier))
+	switch {
+	case nosql.IsErrNotFound(err):
+		return []*acme.Authorization{}, nil
+	case err != nil:
+		return nil, errors.Wrapf(err, "error loading authzIDs for account %s", accID)
+	}
+	var ids []string
+	if err := json.Unmarshal(b, &ids); err != nil {
+		return nil, errors.Wrapf(err, "error unmarshaling authzIDs for account %s", accID)
+	}
+
+	azs := make([]*acme.Authorization, 0, len(ids))
+	for _, id := range ids {
+		az, err := db.GetAuthorization(ctx, id)
+		if err != nil {
+			// getDBAuthz returns a malformed error if the authz does not exist.
+			var acmeErr *acme.Error
+			if errors.As(err, &acmeErr) && acmeErr.Status == http.StatusBadRequest {
+				continue
+			}
+			return nil, err
+		}
+		azs = append(azs, az)
+	}
+	return azs, nil
 }
 
 // UpdateAuthorization saves an updated ACME Authorization to the database.
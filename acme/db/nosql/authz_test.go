@@ -332,10 +332,11 @@ func TestDB_GetAuthorization(t *testing.T) {
 func TestDB_CreateAuthorization(t *testing.T) {
 	azID := "azID"
 	type test struct {
-		db  nosql.DB
-		az  *acme.Authorization
-		err error
-		_id *string
+		db    nosql.DB
+		az    *acme.Authorization
+		err   error
+		_id   *string
+		check func(t *testing.T)
 	}
 	var tests = map[string]func(t *testing.T) test{
 		"fail/cmpAndSwap-error": func(t *testing.T) test {
@@ -387,6 +388,40 @@ func TestDB_CreateAuthorization(t *testing.T) {
 				err: errors.New("error saving acme authz: force"),
 			}
 		},
+		"fail/index-error": func(t *testing.T) test {
+			az := &acme.Authorization{
+				AccountID:  "accountID",
+				Identifier: acme.Identifier{Type: "dns", Value: "test.ca.smallstep.com"},
+				Status:     acme.StatusPending,
+			}
+			var deleted bool
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, nosqldb.ErrNotFound
+					},
+					MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+						if string(bucket) == string(authzsByAccountIDTable) {
+							assert.Equals(t, string(key), "accountID#dns#test.ca.smallstep.com")
+							assert.Nil(t, old)
+							return nil, false, nil
+						}
+						return nu, true, nil
+					},
+					MDel: func(bucket, key []byte) error {
+						assert.Equals(t, bucket, authzTable)
+						assert.Equals(t, string(key), az.ID)
+						deleted = true
+						return nil
+					},
+				},
+				az:  az,
+				err: errors.New("error saving authzIDs index for account accountID; changed since last read"),
+				check: func(t *testing.T) {
+					assert.True(t, deleted)
+				},
+			}
+		},
 		"ok": func(t *testing.T) test {
 			var (
 				id    string
@@ -412,7 +447,18 @@ func TestDB_CreateAuthorization(t *testing.T) {
 			)
 			return test{
 				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						assert.Equals(t, bucket, authzsByAccountIDTable)
+						assert.Equals(t, string(key), "accountID#dns#*.test.ca.smallstep.com")
+						return []byte(`["azOld"]`), nil
+					},
 					MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+						if string(bucket) == string(authzsByAccountIDTable) {
+							assert.Equals(t, string(key), "accountID#dns#*.test.ca.smallstep.com")
+							assert.Equals(t, old, []byte(`["azOld"]`))
+							assert.Equals(t, string(nu), `["azOld","`+*idPtr+`"]`)
+							return nu, true, nil
+						}
 						*idPtr = string(key)
 						assert.Equals(t, bucket, authzTable)
 						assert.Equals(t, string(key), az.ID)
@@ -455,6 +501,111 @@ func TestDB_CreateAuthorization(t *testing.T) {
 					assert.Equals(t, tc.az.ID, *tc._id)
 				}
 			}
+			if tc.check != nil {
+				tc.check(t)
+			}
+		})
+	}
+}
+
+func TestDB_GetAuthorizationsByAccountID(t *testing.T) {
+	identifier := acme.Identifier{Type: "dns", Value: "*.example.com"}
+	type test struct {
+		db  nosql.DB
+		err error
+		ids []string
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"ok/not-found": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						assert.Equals(t, bucket, authzsByAccountIDTable)
+						assert.Equals(t, string(key), "accID#dns#*.example.com")
+						return nil, nosqldb.ErrNotFound
+					},
+				},
+				ids: []string{},
+			}
+		},
+		"fail/db.Get-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, errors.New("force")
+					},
+				},
+				err: errors.New("error loading authzIDs for account accID: force"),
+			}
+		},
+		"fail/unmarshal-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return []byte("foo"), nil
+					},
+				},
+				err: errors.New("error unmarshaling authzIDs for account accID"),
+			}
+		},
+		"fail/db.GetAuthorization-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						if string(bucket) == string(authzsByAccountIDTable) {
+							return []byte(`["az1"]`), nil
+						}
+						return nil, errors.New("force")
+					},
+				},
+				err: errors.New("error loading authz az1: force"),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			b, err := json.Marshal(&dbAuthz{
+				ID:         "az2",
+				AccountID:  "accID",
+				Identifier: acme.Identifier{Type: "dns", Value: "example.com"},
+				Status:     acme.StatusValid,
+				Wildcard:   true,
+			})
+			assert.FatalError(t, err)
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						switch {
+						case string(bucket) == string(authzsByAccountIDTable):
+							return []byte(`["az1","az2"]`), nil
+						case string(key) == "az2":
+							return b, nil
+						default:
+							// az1 was deleted
+							return nil, nosqldb.ErrNotFound
+						}
+					},
+				},
+				ids: []string{"az2"},
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			db := DB{db: tc.db}
+			azs, err := db.GetAuthorizationsByAccountID(context.Background(), "accID", identifier)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+				return
+			}
+			if assert.Nil(t, tc.err) {
+				ids := []string{}
+				for _, az := range azs {
+					ids = append(ids, az.ID)
+				}
+				assert.Equals(t, ids, tc.ids)
+			}
 		})
 	}
 }
@@ -21,6 +21,18 @@ type dbCert struct {
 	Intermediates []byte    `json:"intermediates"`
 }
 
+// dbSerial is the index from the serial number of a certificate to the ID of
+// the ACME certificate.
+type dbSerial struct {
+	Serial        string `json:"serial"`
+	CertificateID string `json:"certificateID"`
+}
+
+// serialIndexMigration is the key in the migrationTable set once the index of
+// certificates by serial number has been populated with the certificates
+// created by previous versions.
+var serialIndexMigration = []byte("serialIndex")
+
 // CreateCertificate creates and stores an ACME certificate type.
 func (db *DB) CreateCertificate(ctx context.Context, cert *acme.Certificate) error {
 	var err error
@@ -49,7 +61,18 @@ func (db *DB) CreateCertificate(ctx context.Context, cert *acme.Certificate) err
 		Intermediates: intermediates,
 		CreatedAt:     time.Now().UTC(),
 	}
-	return db.save(ctx, cert.ID, dbch, nil, "certificate", certTable)
+	if err := db.save(ctx, cert.ID, dbch, nil, "certificate", certTable); err != nil {
+		return err
+	}
+
+	serialIndex := &dbSerial{
+		Serial:        cert.Leaf.SerialNumber.String(),
+		CertificateID: cert.ID,
+	}
+	if err := db.save(ctx, serialIndex.Serial, serialIndex, nil, "certificate serial index", certBySerialTable); err != nil {
+		return err
+	}
+	return db.addIndexID(certsByAccountIDTable, []byte(cert.AccountID), cert.ID)
 }
 
 // GetCertificate retrieves and unmarshals an ACME certificate type from the
@@ -65,10 +88,43 @@ func (db *DB) GetCertificate(ctx context.Context, id string) (*acme.Certificate,
 	if err := json.Unmarshal(b, dbC); err != nil {
 		return nil, errors.Wrapf(err, "error unmarshaling certificate %s", id)
 	}
+	return dbC.toACME()
+}
+
+// GetAccountCertificates retrieves all the certificates issued to an ACME
+// account using the index of certificates by account.
+func (db *DB) GetAccountCertificates(ctx context.Context, accountID string) ([]*acme.Certificate, error) {
+	ids, _, err := db.getIndexIDs(certsByAccountIDTable, []byte(accountID))
+	if err != nil {
+		return nil, err
+	}
+
+	certs := []*acme.Certificate{}
+	for _, id := range ids {
+		b, err := db.db.Get(certTable, []byte(id))
+		if err != nil {
+			return nil, errors.Wrapf(err, "error loading certificate %s", id)
+		}
+		dbC := new(dbCert)
+		if err := json.Unmarshal(b, dbC); err != nil {
+			return nil, errors.Wrapf(err, "error unmarshaling certificate %s", id)
+		}
+		cert, err := dbC.toACME()
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
 
+func (dbC *dbCert) toACME() (*acme.Certificate, error) {
 	certs, err := parseBundle(append(dbC.Leaf, dbC.Intermediates...))
 	if err != nil {
-		return nil, errors.Wrapf(err, "error parsing certificate chain for ACME certificate with ID %s", id)
+		return nil, errors.Wrapf(err, "error parsing certificate chain for ACME certificate with ID %s", dbC.ID)
+	}
+	if len(certs) == 0 {
+		return nil, errors.Errorf("error parsing certificate chain for ACME certificate with ID %s: no certificates found", dbC.ID)
 	}
 
 	return &acme.Certificate{
@@ -80,6 +136,67 @@ func (db *DB) GetCertificate(ctx context.Context, id string) (*acme.Certificate,
 	}, nil
 }
 
+// GetCertificateBySerial retrieves and unmarshals an ACME certificate type from
+// the datastore using the serial number of the certificate.
+func (db *DB) GetCertificateBySerial(ctx context.Context, serial string) (*acme.Certificate, error) {
+	b, err := db.db.Get(certBySerialTable, []byte(serial))
+	if nosql.IsErrNotFound(err) {
+		return nil, acme.ErrNotFound
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "error loading certificate serial index %s", serial)
+	}
+	dbs := new(dbSerial)
+	if err := json.Unmarshal(b, dbs); err != nil {
+		return nil, errors.Wrapf(err, "error unmarshaling certificate serial index %s", serial)
+	}
+	return db.GetCertificate(ctx, dbs.CertificateID)
+}
+
+// migrateSerialIndex adds the certificates created before the index of
+// certificates by serial number existed, so they can be found, and revoked,
+// using their serial number. It only lists the table once, the migration is
+// recorded in the migrationTable.
+func (db *DB) migrateSerialIndex(ctx context.Context) error {
+	_, err := db.db.Get(migrationTable, serialIndexMigration)
+	switch {
+	case err == nil:
+		return nil
+	case !nosql.IsErrNotFound(err):
+		return errors.Wrap(err, "error loading acme migrations")
+	}
+
+	certs, err := db.db.List(certTable)
+	if err != nil {
+		return errors.Wrap(err, "error listing certificates")
+	}
+	for _, e := range certs {
+		dbc := new(dbCert)
+		if err := json.Unmarshal(e.Value, dbc); err != nil {
+			continue
+		}
+		leaf, err := parseBundle(dbc.Leaf)
+		if err != nil || len(leaf) == 0 {
+			continue
+		}
+		b, err := json.Marshal(&dbSerial{
+			Serial:        leaf[0].SerialNumber.String(),
+			CertificateID: dbc.ID,
+		})
+		if err != nil {
+			return errors.Wrapf(err, "error marshaling certificate serial index %s", leaf[0].SerialNumber)
+		}
+		// Entries already in the index are not modified.
+		if _, _, err := db.db.CmpAndSwap(certBySerialTable, []byte(leaf[0].SerialNumber.String()), nil, b); err != nil {
+			return errors.Wrapf(err, "error saving certificate serial index %s", leaf[0].SerialNumber)
+		}
+	}
+
+	if err := db.db.Set(migrationTable, serialIndexMigration, []byte{}); err != nil {
+		return errors.Wrap(err, "error saving acme migrations")
+	}
+	return nil
+}
+
 func parseBundle(b []byte) ([]*x509.Certificate, error) {
 	var (
 		err    error
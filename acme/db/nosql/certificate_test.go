@@ -74,7 +74,29 @@ func TestDB_CreateCertificate(t *testing.T) {
 
 			return test{
 				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						assert.Equals(t, bucket, certsByAccountIDTable)
+						assert.Equals(t, string(key), cert.AccountID)
+						return nil, nosqldb.ErrNotFound
+					},
 					MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+						if string(bucket) == string(certsByAccountIDTable) {
+							assert.Equals(t, string(key), cert.AccountID)
+							assert.Equals(t, old, nil)
+							assert.Equals(t, string(nu), `["`+cert.ID+`"]`)
+							return nu, true, nil
+						}
+						if string(bucket) == string(certBySerialTable) {
+							assert.Equals(t, key, []byte(leaf.SerialNumber.String()))
+							assert.Equals(t, old, nil)
+
+							dbs := new(dbSerial)
+							assert.FatalError(t, json.Unmarshal(nu, dbs))
+							assert.Equals(t, dbs.Serial, string(key))
+							assert.Equals(t, dbs.CertificateID, cert.ID)
+							return nil, true, nil
+						}
+
 						*idPtr = string(key)
 						assert.Equals(t, bucket, certTable)
 						assert.Equals(t, key, []byte(cert.ID))
@@ -258,6 +280,338 @@ func TestDB_GetCertificate(t *testing.T) {
 	}
 }
 
+func TestDB_GetCertificateBySerial(t *testing.T) {
+	leaf, err := pemutil.ReadCertificate("../../../authority/testdata/certs/foo.crt")
+	assert.FatalError(t, err)
+
+	serial := leaf.SerialNumber.String()
+	certID := "certID"
+	type test struct {
+		db  nosql.DB
+		err error
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/not-found": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						assert.Equals(t, bucket, certBySerialTable)
+						assert.Equals(t, string(key), serial)
+						return nil, nosqldb.ErrNotFound
+					},
+				},
+				err: acme.ErrNotFound,
+			}
+		},
+		"fail/db.Get-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, errors.New("force")
+					},
+				},
+				err: errors.Errorf("error loading certificate serial index %s: force", serial),
+			}
+		},
+		"fail/unmarshal-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return []byte("foobar"), nil
+					},
+				},
+				err: errors.Errorf("error unmarshaling certificate serial index %s", serial),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						switch string(bucket) {
+						case string(certBySerialTable):
+							assert.Equals(t, string(key), serial)
+							return json.Marshal(dbSerial{Serial: serial, CertificateID: certID})
+						case string(certTable):
+							assert.Equals(t, string(key), certID)
+							return json.Marshal(dbCert{
+								ID:        certID,
+								AccountID: "accountID",
+								OrderID:   "orderID",
+								Leaf: pem.EncodeToMemory(&pem.Block{
+									Type:  "CERTIFICATE",
+									Bytes: leaf.Raw,
+								}),
+								CreatedAt: clock.Now(),
+							})
+						default:
+							return nil, errors.Errorf("unexpected bucket %s", bucket)
+						}
+					},
+				},
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			db := DB{db: tc.db}
+			cert, err := db.GetCertificateBySerial(context.Background(), serial)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else if assert.Nil(t, tc.err) {
+				assert.Equals(t, cert.ID, certID)
+				assert.Equals(t, cert.AccountID, "accountID")
+				assert.Equals(t, cert.Leaf, leaf)
+			}
+		})
+	}
+}
+
+func TestDB_migrateSerialIndex(t *testing.T) {
+	leaf, err := pemutil.ReadCertificate("../../../authority/testdata/certs/foo.crt")
+	assert.FatalError(t, err)
+
+	serial := leaf.SerialNumber.String()
+	certB, err := json.Marshal(dbCert{
+		ID:        "certID",
+		AccountID: "accountID",
+		OrderID:   "orderID",
+		Leaf: pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: leaf.Raw,
+		}),
+		CreatedAt: clock.Now(),
+	})
+	assert.FatalError(t, err)
+
+	type test struct {
+		db  nosql.DB
+		err error
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"ok/already-migrated": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						assert.Equals(t, bucket, migrationTable)
+						assert.Equals(t, string(key), "serialIndex")
+						return []byte{}, nil
+					},
+				},
+			}
+		},
+		"fail/db.Get-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, errors.New("force")
+					},
+				},
+				err: errors.New("error loading acme migrations: force"),
+			}
+		},
+		"fail/db.List-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, nosqldb.ErrNotFound
+					},
+					MList: func(bucket []byte) ([]*nosqldb.Entry, error) {
+						return nil, errors.New("force")
+					},
+				},
+				err: errors.New("error listing certificates: force"),
+			}
+		},
+		"fail/db.CmpAndSwap-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, nosqldb.ErrNotFound
+					},
+					MList: func(bucket []byte) ([]*nosqldb.Entry, error) {
+						return []*nosqldb.Entry{{Key: []byte("certID"), Value: certB}}, nil
+					},
+					MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+						return nil, false, errors.New("force")
+					},
+				},
+				err: errors.Errorf("error saving certificate serial index %s: force", serial),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			var indexed, migrated bool
+			t.Cleanup(func() {
+				assert.True(t, indexed)
+				assert.True(t, migrated)
+			})
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, nosqldb.ErrNotFound
+					},
+					MList: func(bucket []byte) ([]*nosqldb.Entry, error) {
+						assert.Equals(t, bucket, certTable)
+						return []*nosqldb.Entry{
+							{Key: []byte("bad"), Value: []byte("foo")},
+							{Key: []byte("certID"), Value: certB},
+						}, nil
+					},
+					MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+						assert.Equals(t, bucket, certBySerialTable)
+						assert.Equals(t, string(key), serial)
+						assert.Nil(t, old)
+						dbs := new(dbSerial)
+						assert.FatalError(t, json.Unmarshal(nu, dbs))
+						assert.Equals(t, dbs, &dbSerial{Serial: serial, CertificateID: "certID"})
+						indexed = true
+						return nu, true, nil
+					},
+					MSet: func(bucket, key, value []byte) error {
+						assert.Equals(t, bucket, migrationTable)
+						assert.Equals(t, string(key), "serialIndex")
+						migrated = true
+						return nil
+					},
+				},
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			db := DB{db: tc.db}
+			if err := db.migrateSerialIndex(context.Background()); err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+func TestDB_GetAccountCertificates(t *testing.T) {
+	leaf, err := pemutil.ReadCertificate("../../../authority/testdata/certs/foo.crt")
+	assert.FatalError(t, err)
+	inter, err := pemutil.ReadCertificate("../../../authority/testdata/certs/intermediate_ca.crt")
+	assert.FatalError(t, err)
+
+	accID := "accID"
+	mustCert := func(t *testing.T, id, accountID string, leaf *x509.Certificate) []byte {
+		b, err := json.Marshal(dbCert{
+			ID:        id,
+			AccountID: accountID,
+			OrderID:   "orderID",
+			Leaf: pem.EncodeToMemory(&pem.Block{
+				Type:  "CERTIFICATE",
+				Bytes: leaf.Raw,
+			}),
+			Intermediates: pem.EncodeToMemory(&pem.Block{
+				Type:  "CERTIFICATE",
+				Bytes: inter.Raw,
+			}),
+			CreatedAt: clock.Now(),
+		})
+		assert.FatalError(t, err)
+		return b
+	}
+	index := func(ids ...string) []byte {
+		b, err := json.Marshal(ids)
+		assert.FatalError(t, err)
+		return b
+	}
+	type test struct {
+		db    nosql.DB
+		err   error
+		certs []string
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/index-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						assert.Equals(t, bucket, certsByAccountIDTable)
+						assert.Equals(t, string(key), accID)
+						return nil, errors.New("force")
+					},
+				},
+				err: errors.New("error loading acme_account_certs_index entry accID: force"),
+			}
+		},
+		"fail/db.Get-error": func(t *testing.T) test {
+			return test{
+				db: memoryDB(map[string]map[string][]byte{
+					string(certsByAccountIDTable): {accID: index("cert1")},
+				}),
+				err: errors.New("error loading certificate cert1: not found"),
+			}
+		},
+		"fail/unmarshal-error": func(t *testing.T) test {
+			return test{
+				db: memoryDB(map[string]map[string][]byte{
+					string(certsByAccountIDTable): {accID: index("foo")},
+					string(certTable):             {"foo": []byte("foo")},
+				}),
+				err: errors.New("error unmarshaling certificate foo"),
+			}
+		},
+		"fail/parseBundle-error": func(t *testing.T) test {
+			b, err := json.Marshal(dbCert{ID: "cert1", AccountID: accID})
+			assert.FatalError(t, err)
+			return test{
+				db: memoryDB(map[string]map[string][]byte{
+					string(certsByAccountIDTable): {accID: index("cert1")},
+					string(certTable):             {"cert1": b},
+				}),
+				err: errors.New("error parsing certificate chain for ACME certificate with ID cert1: no certificates found"),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			return test{
+				db: memoryDB(map[string]map[string][]byte{
+					string(certsByAccountIDTable): {
+						accID:        index("cert1", "cert3"),
+						"otherAccID": index("cert2"),
+					},
+					string(certTable): {
+						"cert1": mustCert(t, "cert1", accID, leaf),
+						"cert2": mustCert(t, "cert2", "otherAccID", leaf),
+						"cert3": mustCert(t, "cert3", accID, leaf),
+					},
+				}),
+				certs: []string{"cert1", "cert3"},
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			db := DB{db: tc.db}
+			certs, err := db.GetAccountCertificates(context.Background(), accID)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+				return
+			}
+			if assert.Nil(t, tc.err) {
+				var ids []string
+				for _, cert := range certs {
+					assert.Equals(t, cert.AccountID, accID)
+					assert.Equals(t, cert.Leaf, leaf)
+					assert.Equals(t, cert.Intermediates, []*x509.Certificate{inter})
+					ids = append(ids, cert.ID)
+				}
+				assert.Equals(t, ids, tc.certs)
+			}
+		})
+	}
+}
+
 func Test_parseBundle(t *testing.T) {
 	leaf, err := pemutil.ReadCertificate("../../../authority/testdata/certs/foo.crt")
 	assert.FatalError(t, err)
@@ -20,6 +20,10 @@ type dbChallenge struct {
 	ValidatedAt string             `json:"validatedAt"`
 	CreatedAt   time.Time          `json:"createdAt"`
 	Error       *acme.Error        `json:"error"`
+	From        string             `json:"from,omitempty"`
+	TokenPart1  string             `json:"tokenPart1,omitempty"`
+	Payload     []byte             `json:"payload,omitempty"`
+	Fingerprint string             `json:"fingerprint,omitempty"`
 }
 
 func (dbc *dbChallenge) clone() *dbChallenge {
@@ -52,13 +56,15 @@ func (db *DB) CreateChallenge(ctx context.Context, ch *acme.Challenge) error {
 	}
 
 	dbch := &dbChallenge{
-		ID:        ch.ID,
-		AccountID: ch.AccountID,
-		Value:     ch.Value,
-		Status:    acme.StatusPending,
-		Token:     ch.Token,
-		CreatedAt: clock.Now(),
-		Type:      ch.Type,
+		ID:         ch.ID,
+		AccountID:  ch.AccountID,
+		Value:      ch.Value,
+		Status:     acme.StatusPending,
+		Token:      ch.Token,
+		CreatedAt:  clock.Now(),
+		Type:       ch.Type,
+		From:       ch.From,
+		TokenPart1: ch.TokenPart1,
 	}
 
 	return db.save(ctx, ch.ID, dbch, nil, "challenge", challengeTable)
@@ -81,6 +87,10 @@ func (db *DB) GetChallenge(ctx context.Context, id, authzID string) (*acme.Chall
 		Token:       dbch.Token,
 		Error:       dbch.Error,
 		ValidatedAt: dbch.ValidatedAt,
+		From:        dbch.From,
+		TokenPart1:  dbch.TokenPart1,
+		Payload:     dbch.Payload,
+		Fingerprint: dbch.Fingerprint,
 	}
 	return ch, nil
 }
@@ -98,6 +108,14 @@ func (db *DB) UpdateChallenge(ctx context.Context, ch *acme.Challenge) error {
 	nu.Status = ch.Status
 	nu.Error = ch.Error
 	nu.ValidatedAt = ch.ValidatedAt
+	// The attestation of device-attest-01 challenges is stored when the
+	// validation starts, and the attested key when it succeeds.
+	if len(ch.Payload) > 0 {
+		nu.Payload = ch.Payload
+	}
+	if ch.Fingerprint != "" {
+		nu.Fingerprint = ch.Fingerprint
+	}
 
 	return db.save(ctx, old.ID, nu, old, "challenge", challengeTable)
 }
@@ -202,6 +202,37 @@ func TestDB_CreateChallenge(t *testing.T) {
 				_id: idPtr,
 			}
 		},
+		"ok/email-reply-00": func(t *testing.T) test {
+			var (
+				id    string
+				idPtr = &id
+				ch    = &acme.Challenge{
+					AccountID:  "accountID",
+					Type:       "email-reply-00",
+					Status:     acme.StatusPending,
+					Token:      "token",
+					Value:      "jane@example.com",
+					From:       "acme@ca.smallstep.com",
+					TokenPart1: "part1",
+				}
+			)
+
+			return test{
+				ch: ch,
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+						*idPtr = string(key)
+						dbc := new(dbChallenge)
+						assert.FatalError(t, json.Unmarshal(nu, dbc))
+						assert.Equals(t, dbc.Type, ch.Type)
+						assert.Equals(t, dbc.From, ch.From)
+						assert.Equals(t, dbc.TokenPart1, ch.TokenPart1)
+						return nil, true, nil
+					},
+				},
+				_id: idPtr,
+			}
+		},
 	}
 	for name, run := range tests {
 		tc := run(t)
@@ -267,6 +298,10 @@ func TestDB_GetChallenge(t *testing.T) {
 				CreatedAt:   clock.Now(),
 				ValidatedAt: "foobar",
 				Error:       acme.NewErrorISE("force"),
+				From:        "acme@ca.smallstep.com",
+				TokenPart1:  "part1",
+				Payload:     []byte(`{"attObj":"foo"}`),
+				Fingerprint: "fingerprint",
 			}
 			b, err := json.Marshal(dbc)
 			assert.FatalError(t, err)
@@ -312,6 +347,10 @@ func TestDB_GetChallenge(t *testing.T) {
 					assert.Equals(t, ch.Value, tc.dbc.Value)
 					assert.Equals(t, ch.ValidatedAt, tc.dbc.ValidatedAt)
 					assert.Equals(t, ch.Error.Error(), tc.dbc.Error.Error())
+					assert.Equals(t, ch.From, tc.dbc.From)
+					assert.Equals(t, ch.TokenPart1, tc.dbc.TokenPart1)
+					assert.Equals(t, ch.Payload, tc.dbc.Payload)
+					assert.Equals(t, ch.Fingerprint, tc.dbc.Fingerprint)
 				}
 			}
 		})
@@ -404,6 +443,8 @@ func TestDB_UpdateChallenge(t *testing.T) {
 				Status:      acme.StatusValid,
 				ValidatedAt: "foobar",
 				Error:       acme.NewError(acme.ErrorMalformedType, "malformed"),
+				Payload:     []byte(`{"attObj":"foo"}`),
+				Fingerprint: "fingerprint",
 			}
 			return test{
 				ch: updCh,
@@ -433,6 +474,8 @@ func TestDB_UpdateChallenge(t *testing.T) {
 						assert.Equals(t, dbNew.Status, acme.StatusValid)
 						assert.Equals(t, dbNew.ValidatedAt, "foobar")
 						assert.Equals(t, dbNew.Error.Error(), acme.NewError(acme.ErrorMalformedType, "malformed").Error())
+						assert.Equals(t, dbNew.Payload, []byte(`{"attObj":"foo"}`))
+						assert.Equals(t, dbNew.Fingerprint, "fingerprint")
 						return nu, true, nil
 					},
 				},
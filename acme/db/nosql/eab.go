@@ -0,0 +1,221 @@
+package nosql
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/acme"
+	nosqlDB "github.com/smallstep/nosql"
+)
+
+// eabKeyLen is the length in bytes of the generated HMAC keys.
+var eabKeyLen = 32
+
+// dbExternalAccountKey represents an ACME External Account Binding key.
+type dbExternalAccountKey struct {
+	ID            string    `json:"id"`
+	ProvisionerID string    `json:"provisionerID"`
+	Reference     string    `json:"reference"`
+	AccountID     string    `json:"accountID,omitempty"`
+	KeyBytes      []byte    `json:"key"`
+	CreatedAt     time.Time `json:"createdAt"`
+	BoundAt       time.Time `json:"boundAt"`
+}
+
+func (dbeak *dbExternalAccountKey) clone() *dbExternalAccountKey {
+	nu := *dbeak
+	return &nu
+}
+
+func (dbeak *dbExternalAccountKey) toACME() *acme.ExternalAccountKey {
+	return &acme.ExternalAccountKey{
+		ID:            dbeak.ID,
+		ProvisionerID: dbeak.ProvisionerID,
+		Reference:     dbeak.Reference,
+		AccountID:     dbeak.AccountID,
+		KeyBytes:      dbeak.KeyBytes,
+		CreatedAt:     dbeak.CreatedAt,
+		BoundAt:       dbeak.BoundAt,
+	}
+}
+
+// dbExternalAccountKeyReference is the value of the reference to External
+// Account Binding key index.
+type dbExternalAccountKeyReference struct {
+	Reference            string `json:"reference"`
+	ExternalAccountKeyID string `json:"externalAccountKeyID"`
+}
+
+// referenceKey returns the key used in the reference index. References are
+// unique per provisioner.
+func referenceKey(provisionerID, reference string) []byte {
+	return []byte(provisionerID + "." + reference)
+}
+
+// getDBExternalAccountKey retrieves and unmarshals dbExternalAccountKey.
+func (db *DB) getDBExternalAccountKey(ctx context.Context, id string) (*dbExternalAccountKey, error) {
+	data, err := db.db.Get(externalAccountKeyTable, []byte(id))
+	if err != nil {
+		if nosqlDB.IsErrNotFound(err) {
+			return nil, acme.ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "error loading external account key %s", id)
+	}
+
+	dbeak := new(dbExternalAccountKey)
+	if err = json.Unmarshal(data, dbeak); err != nil {
+		return nil, errors.Wrapf(err, "error unmarshaling external account key %s into dbExternalAccountKey", id)
+	}
+	return dbeak, nil
+}
+
+// getDBExternalAccountKeyForProvisioner retrieves a dbExternalAccountKey and
+// verifies that it belongs to the given provisioner.
+func (db *DB) getDBExternalAccountKeyForProvisioner(ctx context.Context, provisionerID, keyID string) (*dbExternalAccountKey, error) {
+	dbeak, err := db.getDBExternalAccountKey(ctx, keyID)
+	if err != nil {
+		return nil, err
+	}
+	if dbeak.ProvisionerID != provisionerID {
+		return nil, acme.NewError(acme.ErrorUnauthorizedType, "provisioner does not match provisioner for which the EAB key was created")
+	}
+	return dbeak, nil
+}
+
+// CreateExternalAccountKey creates a new External Account Binding key with a
+// random HMAC key for the given provisioner. The reference, if not empty,
+// must be unique for the provisioner.
+func (db *DB) CreateExternalAccountKey(ctx context.Context, provisionerID, reference string) (*acme.ExternalAccountKey, error) {
+	id, err := randID()
+	if err != nil {
+		return nil, err
+	}
+
+	keyBytes := make([]byte, eabKeyLen)
+	if _, err := rand.Read(keyBytes); err != nil {
+		return nil, errors.Wrap(err, "error generating external account key")
+	}
+
+	dbeak := &dbExternalAccountKey{
+		ID:            id,
+		ProvisionerID: provisionerID,
+		Reference:     reference,
+		KeyBytes:      keyBytes,
+		CreatedAt:     clock.Now(),
+	}
+
+	// Set the reference -> external account key ID index, this will fail if
+	// the reference is already in use.
+	refKey := referenceKey(provisionerID, reference)
+	if reference != "" {
+		dbRef := &dbExternalAccountKeyReference{
+			Reference:            reference,
+			ExternalAccountKeyID: id,
+		}
+		if err := db.save(ctx, string(refKey), dbRef, nil, "external_account_key_reference", externalAccountKeysByReferenceTable); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := db.save(ctx, id, dbeak, nil, "external_account_key", externalAccountKeyTable); err != nil {
+		if reference != "" {
+			db.db.Del(externalAccountKeysByReferenceTable, refKey)
+		}
+		return nil, err
+	}
+	return dbeak.toACME(), nil
+}
+
+// GetExternalAccountKey retrieves an External Account Binding key by ID.
+func (db *DB) GetExternalAccountKey(ctx context.Context, provisionerID, keyID string) (*acme.ExternalAccountKey, error) {
+	dbeak, err := db.getDBExternalAccountKeyForProvisioner(ctx, provisionerID, keyID)
+	if err != nil {
+		return nil, err
+	}
+	return dbeak.toACME(), nil
+}
+
+// GetExternalAccountKeys retrieves all the External Account Binding keys of a
+// provisioner.
+func (db *DB) GetExternalAccountKeys(ctx context.Context, provisionerID string) ([]*acme.ExternalAccountKey, error) {
+	entries, err := db.db.List(externalAccountKeyTable)
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing external account keys")
+	}
+
+	keys := []*acme.ExternalAccountKey{}
+	for _, entry := range entries {
+		dbeak := new(dbExternalAccountKey)
+		if err = json.Unmarshal(entry.Value, dbeak); err != nil {
+			return nil, errors.Wrapf(err, "error unmarshaling external account key %s into dbExternalAccountKey", string(entry.Key))
+		}
+		if dbeak.ProvisionerID != provisionerID {
+			continue
+		}
+		keys = append(keys, dbeak.toACME())
+	}
+	return keys, nil
+}
+
+// GetExternalAccountKeyByReference retrieves an External Account Binding key
+// by the reference given on creation.
+func (db *DB) GetExternalAccountKeyByReference(ctx context.Context, provisionerID, reference string) (*acme.ExternalAccountKey, error) {
+	if reference == "" {
+		return nil, acme.ErrNotFound
+	}
+	data, err := db.db.Get(externalAccountKeysByReferenceTable, referenceKey(provisionerID, reference))
+	if err != nil {
+		if nosqlDB.IsErrNotFound(err) {
+			return nil, acme.ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "error loading external account key reference %s", reference)
+	}
+
+	dbRef := new(dbExternalAccountKeyReference)
+	if err = json.Unmarshal(data, dbRef); err != nil {
+		return nil, errors.Wrapf(err, "error unmarshaling external account key reference %s", reference)
+	}
+	return db.GetExternalAccountKey(ctx, provisionerID, dbRef.ExternalAccountKeyID)
+}
+
+// DeleteExternalAccountKey deletes an External Account Binding key and its
+// reference index.
+func (db *DB) DeleteExternalAccountKey(ctx context.Context, provisionerID, keyID string) error {
+	dbeak, err := db.getDBExternalAccountKeyForProvisioner(ctx, provisionerID, keyID)
+	if err != nil {
+		return err
+	}
+	if dbeak.Reference != "" {
+		if err := db.db.Del(externalAccountKeysByReferenceTable, referenceKey(provisionerID, dbeak.Reference)); err != nil {
+			return errors.Wrapf(err, "error deleting external account key reference %s", dbeak.Reference)
+		}
+	}
+	if err := db.db.Del(externalAccountKeyTable, []byte(keyID)); err != nil {
+		return errors.Wrapf(err, "error deleting external account key %s", keyID)
+	}
+	return nil
+}
+
+// UpdateExternalAccountKey updates the binding attributes of an External
+// Account Binding key. The update fails if the key has been modified
+// concurrently, this guarantees that a key is only bound to one account.
+func (db *DB) UpdateExternalAccountKey(ctx context.Context, provisionerID string, eak *acme.ExternalAccountKey) error {
+	old, err := db.getDBExternalAccountKeyForProvisioner(ctx, provisionerID, eak.ID)
+	if err != nil {
+		return err
+	}
+	if !old.BoundAt.IsZero() {
+		return acme.NewError(acme.ErrorUnauthorizedType, "external account binding key with id '%s' was already bound to account '%s' on %s",
+			old.ID, old.AccountID, old.BoundAt)
+	}
+
+	nu := old.clone()
+	nu.AccountID = eak.AccountID
+	nu.KeyBytes = eak.KeyBytes
+	nu.BoundAt = eak.BoundAt
+
+	return db.save(ctx, old.ID, nu, old, "external_account_key", externalAccountKeyTable)
+}
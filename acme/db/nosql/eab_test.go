@@ -0,0 +1,562 @@
+package nosql
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/nosql"
+	nosqldb "github.com/smallstep/nosql/database"
+)
+
+func TestDB_getDBExternalAccountKeyForProvisioner(t *testing.T) {
+	keyID := "keyID"
+	provID := "provID"
+	type test struct {
+		db      nosql.DB
+		err     error
+		acmeErr *acme.Error
+		dbeak   *dbExternalAccountKey
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/not-found": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						assert.Equals(t, bucket, externalAccountKeyTable)
+						assert.Equals(t, string(key), keyID)
+
+						return nil, nosqldb.ErrNotFound
+					},
+				},
+				err: acme.ErrNotFound,
+			}
+		},
+		"fail/db.Get-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, errors.New("force")
+					},
+				},
+				err: errors.New("error loading external account key keyID: force"),
+			}
+		},
+		"fail/unmarshal-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return []byte("foo"), nil
+					},
+				},
+				err: errors.New("error unmarshaling external account key keyID into dbExternalAccountKey"),
+			}
+		},
+		"fail/provisioner-mismatch": func(t *testing.T) test {
+			b, err := json.Marshal(&dbExternalAccountKey{ID: keyID, ProvisionerID: "otherProvID"})
+			assert.FatalError(t, err)
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return b, nil
+					},
+				},
+				acmeErr: acme.NewError(acme.ErrorUnauthorizedType, "provisioner does not match provisioner for which the EAB key was created"),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			dbeak := &dbExternalAccountKey{
+				ID:            keyID,
+				ProvisionerID: provID,
+				Reference:     "ref",
+				KeyBytes:      []byte{1, 3, 3, 7},
+				CreatedAt:     clock.Now(),
+			}
+			b, err := json.Marshal(dbeak)
+			assert.FatalError(t, err)
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						assert.Equals(t, bucket, externalAccountKeyTable)
+						assert.Equals(t, string(key), keyID)
+						return b, nil
+					},
+				},
+				dbeak: dbeak,
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			db := DB{db: tc.db}
+			if dbeak, err := db.getDBExternalAccountKeyForProvisioner(context.Background(), provID, keyID); err != nil {
+				switch k := err.(type) {
+				case *acme.Error:
+					if assert.NotNil(t, tc.acmeErr) {
+						assert.Equals(t, k.Type, tc.acmeErr.Type)
+						assert.Equals(t, k.Detail, tc.acmeErr.Detail)
+						assert.Equals(t, k.Status, tc.acmeErr.Status)
+						assert.Equals(t, k.Err.Error(), tc.acmeErr.Err.Error())
+					}
+				default:
+					if assert.NotNil(t, tc.err) {
+						assert.HasPrefix(t, err.Error(), tc.err.Error())
+					}
+				}
+			} else if assert.Nil(t, tc.err) && assert.Nil(t, tc.acmeErr) {
+				assert.Equals(t, dbeak.ID, tc.dbeak.ID)
+				assert.Equals(t, dbeak.ProvisionerID, tc.dbeak.ProvisionerID)
+				assert.Equals(t, dbeak.Reference, tc.dbeak.Reference)
+				assert.Equals(t, dbeak.KeyBytes, tc.dbeak.KeyBytes)
+				assert.Equals(t, dbeak.CreatedAt, tc.dbeak.CreatedAt)
+				assert.True(t, dbeak.BoundAt.IsZero())
+			}
+		})
+	}
+}
+
+func TestDB_CreateExternalAccountKey(t *testing.T) {
+	provID := "provID"
+	type test struct {
+		db        nosql.DB
+		reference string
+		err       error
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/reference-cmpAndSwap-false": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+						assert.Equals(t, bucket, externalAccountKeysByReferenceTable)
+						assert.Equals(t, string(key), "provID.ref")
+						assert.Equals(t, old, nil)
+						return []byte("foo"), false, nil
+					},
+				},
+				reference: "ref",
+				err:       errors.New("error saving acme external_account_key_reference; changed since last read"),
+			}
+		},
+		"fail/key-save-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+						switch string(bucket) {
+						case string(externalAccountKeysByReferenceTable):
+							return nu, true, nil
+						case string(externalAccountKeyTable):
+							return nil, false, errors.New("force")
+						default:
+							assert.FatalError(t, errors.Errorf("unrecognized bucket %s", string(bucket)))
+							return nil, false, errors.New("force")
+						}
+					},
+					MDel: func(bucket, key []byte) error {
+						assert.Equals(t, bucket, externalAccountKeysByReferenceTable)
+						assert.Equals(t, string(key), "provID.ref")
+						return nil
+					},
+				},
+				reference: "ref",
+				err:       errors.New("error saving acme external_account_key: force"),
+			}
+		},
+		"ok/no-reference": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+						assert.Equals(t, bucket, externalAccountKeyTable)
+						assert.Equals(t, old, nil)
+						return nu, true, nil
+					},
+				},
+			}
+		},
+		"ok": func(t *testing.T) test {
+			var keyID string
+			return test{
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+						assert.Equals(t, old, nil)
+						switch string(bucket) {
+						case string(externalAccountKeysByReferenceTable):
+							dbRef := new(dbExternalAccountKeyReference)
+							assert.FatalError(t, json.Unmarshal(nu, dbRef))
+							assert.Equals(t, dbRef.Reference, "ref")
+							keyID = dbRef.ExternalAccountKeyID
+						case string(externalAccountKeyTable):
+							assert.Equals(t, string(key), keyID)
+							dbeak := new(dbExternalAccountKey)
+							assert.FatalError(t, json.Unmarshal(nu, dbeak))
+							assert.Equals(t, dbeak.ID, keyID)
+							assert.Equals(t, dbeak.ProvisionerID, provID)
+							assert.Equals(t, dbeak.Reference, "ref")
+						default:
+							assert.FatalError(t, errors.Errorf("unrecognized bucket %s", string(bucket)))
+						}
+						return nu, true, nil
+					},
+				},
+				reference: "ref",
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			db := DB{db: tc.db}
+			eak, err := db.CreateExternalAccountKey(context.Background(), provID, tc.reference)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+				return
+			}
+			if assert.Nil(t, tc.err) {
+				assert.Equals(t, len(eak.ID), idLen)
+				assert.Equals(t, eak.ProvisionerID, provID)
+				assert.Equals(t, eak.Reference, tc.reference)
+				assert.Equals(t, len(eak.KeyBytes), eabKeyLen)
+				assert.Equals(t, eak.AccountID, "")
+				assert.False(t, eak.AlreadyBound())
+				assert.True(t, clock.Now().Add(-time.Minute).Before(eak.CreatedAt))
+			}
+		})
+	}
+}
+
+func TestDB_GetExternalAccountKeys(t *testing.T) {
+	provID := "provID"
+	mustEntry := func(t *testing.T, dbeak *dbExternalAccountKey) *nosqldb.Entry {
+		b, err := json.Marshal(dbeak)
+		assert.FatalError(t, err)
+		return &nosqldb.Entry{Bucket: externalAccountKeyTable, Key: []byte(dbeak.ID), Value: b}
+	}
+	type test struct {
+		db   nosql.DB
+		err  error
+		keys []string
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/db.List-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MList: func(bucket []byte) ([]*nosqldb.Entry, error) {
+						assert.Equals(t, bucket, externalAccountKeyTable)
+						return nil, errors.New("force")
+					},
+				},
+				err: errors.New("error listing external account keys: force"),
+			}
+		},
+		"fail/unmarshal-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MList: func(bucket []byte) ([]*nosqldb.Entry, error) {
+						return []*nosqldb.Entry{{Bucket: bucket, Key: []byte("foo"), Value: []byte("foo")}}, nil
+					},
+				},
+				err: errors.New("error unmarshaling external account key foo into dbExternalAccountKey"),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MList: func(bucket []byte) ([]*nosqldb.Entry, error) {
+						return []*nosqldb.Entry{
+							mustEntry(t, &dbExternalAccountKey{ID: "key1", ProvisionerID: provID}),
+							mustEntry(t, &dbExternalAccountKey{ID: "key2", ProvisionerID: "otherProvID"}),
+							mustEntry(t, &dbExternalAccountKey{ID: "key3", ProvisionerID: provID}),
+						}, nil
+					},
+				},
+				keys: []string{"key1", "key3"},
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			db := DB{db: tc.db}
+			eaks, err := db.GetExternalAccountKeys(context.Background(), provID)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+				return
+			}
+			if assert.Nil(t, tc.err) {
+				var ids []string
+				for _, eak := range eaks {
+					assert.Equals(t, eak.ProvisionerID, provID)
+					ids = append(ids, eak.ID)
+				}
+				assert.Equals(t, ids, tc.keys)
+			}
+		})
+	}
+}
+
+func TestDB_GetExternalAccountKeyByReference(t *testing.T) {
+	provID := "provID"
+	type test struct {
+		db        nosql.DB
+		reference string
+		err       error
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/empty-reference": func(t *testing.T) test {
+			return test{
+				db:  &db.MockNoSQLDB{},
+				err: acme.ErrNotFound,
+			}
+		},
+		"fail/not-found": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						assert.Equals(t, bucket, externalAccountKeysByReferenceTable)
+						assert.Equals(t, string(key), "provID.ref")
+						return nil, nosqldb.ErrNotFound
+					},
+				},
+				reference: "ref",
+				err:       acme.ErrNotFound,
+			}
+		},
+		"fail/db.Get-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, errors.New("force")
+					},
+				},
+				reference: "ref",
+				err:       errors.New("error loading external account key reference ref: force"),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			refB, err := json.Marshal(&dbExternalAccountKeyReference{Reference: "ref", ExternalAccountKeyID: "keyID"})
+			assert.FatalError(t, err)
+			eakB, err := json.Marshal(&dbExternalAccountKey{ID: "keyID", ProvisionerID: provID, Reference: "ref"})
+			assert.FatalError(t, err)
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						switch string(bucket) {
+						case string(externalAccountKeysByReferenceTable):
+							return refB, nil
+						case string(externalAccountKeyTable):
+							assert.Equals(t, string(key), "keyID")
+							return eakB, nil
+						default:
+							assert.FatalError(t, errors.Errorf("unrecognized bucket %s", string(bucket)))
+							return nil, errors.New("force")
+						}
+					},
+				},
+				reference: "ref",
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			db := DB{db: tc.db}
+			eak, err := db.GetExternalAccountKeyByReference(context.Background(), provID, tc.reference)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+				return
+			}
+			if assert.Nil(t, tc.err) {
+				assert.Equals(t, eak.ID, "keyID")
+				assert.Equals(t, eak.Reference, tc.reference)
+			}
+		})
+	}
+}
+
+func TestDB_DeleteExternalAccountKey(t *testing.T) {
+	provID := "provID"
+	type test struct {
+		db  nosql.DB
+		err error
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/not-found": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, nosqldb.ErrNotFound
+					},
+				},
+				err: acme.ErrNotFound,
+			}
+		},
+		"fail/delete-reference-error": func(t *testing.T) test {
+			b, err := json.Marshal(&dbExternalAccountKey{ID: "keyID", ProvisionerID: provID, Reference: "ref"})
+			assert.FatalError(t, err)
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return b, nil
+					},
+					MDel: func(bucket, key []byte) error {
+						assert.Equals(t, bucket, externalAccountKeysByReferenceTable)
+						return errors.New("force")
+					},
+				},
+				err: errors.New("error deleting external account key reference ref: force"),
+			}
+		},
+		"fail/delete-key-error": func(t *testing.T) test {
+			b, err := json.Marshal(&dbExternalAccountKey{ID: "keyID", ProvisionerID: provID})
+			assert.FatalError(t, err)
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return b, nil
+					},
+					MDel: func(bucket, key []byte) error {
+						assert.Equals(t, bucket, externalAccountKeyTable)
+						return errors.New("force")
+					},
+				},
+				err: errors.New("error deleting external account key keyID: force"),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			b, err := json.Marshal(&dbExternalAccountKey{ID: "keyID", ProvisionerID: provID, Reference: "ref"})
+			assert.FatalError(t, err)
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return b, nil
+					},
+					MDel: func(bucket, key []byte) error {
+						switch string(bucket) {
+						case string(externalAccountKeysByReferenceTable):
+							assert.Equals(t, string(key), "provID.ref")
+						case string(externalAccountKeyTable):
+							assert.Equals(t, string(key), "keyID")
+						default:
+							assert.FatalError(t, errors.Errorf("unrecognized bucket %s", string(bucket)))
+						}
+						return nil
+					},
+				},
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			db := DB{db: tc.db}
+			if err := db.DeleteExternalAccountKey(context.Background(), provID, "keyID"); err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+func TestDB_UpdateExternalAccountKey(t *testing.T) {
+	provID := "provID"
+	boundAt := clock.Now()
+	type test struct {
+		db      nosql.DB
+		eak     *acme.ExternalAccountKey
+		err     error
+		acmeErr *acme.Error
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/already-bound": func(t *testing.T) test {
+			b, err := json.Marshal(&dbExternalAccountKey{ID: "keyID", ProvisionerID: provID, AccountID: "otherID", BoundAt: boundAt})
+			assert.FatalError(t, err)
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return b, nil
+					},
+				},
+				eak:     &acme.ExternalAccountKey{ID: "keyID", AccountID: "accID", BoundAt: boundAt},
+				acmeErr: acme.NewError(acme.ErrorUnauthorizedType, "external account binding key with id 'keyID' was already bound to account 'otherID' on %s", boundAt),
+			}
+		},
+		"fail/save-error": func(t *testing.T) test {
+			b, err := json.Marshal(&dbExternalAccountKey{ID: "keyID", ProvisionerID: provID, KeyBytes: []byte{1, 2, 3}})
+			assert.FatalError(t, err)
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return b, nil
+					},
+					MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+						return nil, false, errors.New("force")
+					},
+				},
+				eak: &acme.ExternalAccountKey{ID: "keyID", AccountID: "accID", BoundAt: boundAt},
+				err: errors.New("error saving acme external_account_key: force"),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			b, err := json.Marshal(&dbExternalAccountKey{ID: "keyID", ProvisionerID: provID, Reference: "ref", KeyBytes: []byte{1, 2, 3}})
+			assert.FatalError(t, err)
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return b, nil
+					},
+					MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+						assert.Equals(t, bucket, externalAccountKeyTable)
+						assert.Equals(t, string(key), "keyID")
+						assert.Equals(t, old, b)
+
+						dbeak := new(dbExternalAccountKey)
+						assert.FatalError(t, json.Unmarshal(nu, dbeak))
+						assert.Equals(t, dbeak.ProvisionerID, provID)
+						assert.Equals(t, dbeak.Reference, "ref")
+						assert.Equals(t, dbeak.AccountID, "accID")
+						assert.Equals(t, dbeak.BoundAt, boundAt)
+						assert.Equals(t, dbeak.KeyBytes, []byte{})
+						return nu, true, nil
+					},
+				},
+				eak: &acme.ExternalAccountKey{ID: "keyID", AccountID: "accID", KeyBytes: []byte{}, BoundAt: boundAt},
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			db := DB{db: tc.db}
+			if err := db.UpdateExternalAccountKey(context.Background(), provID, tc.eak); err != nil {
+				switch k := err.(type) {
+				case *acme.Error:
+					if assert.NotNil(t, tc.acmeErr) {
+						assert.Equals(t, k.Type, tc.acmeErr.Type)
+						assert.Equals(t, k.Err.Error(), tc.acmeErr.Err.Error())
+					}
+				default:
+					if assert.NotNil(t, tc.err) {
+						assert.HasPrefix(t, err.Error(), tc.err.Error())
+					}
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Nil(t, tc.acmeErr)
+			}
+		})
+	}
+}
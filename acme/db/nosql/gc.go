@@ -0,0 +1,262 @@
+package nosql
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/nosql"
+)
+
+// expirationCursorKey is the key in the expirationTable of the first hour of
+// expirations that has not been pruned yet.
+var expirationCursorKey = []byte("cursor")
+
+// expirationBucket returns the table that indexes the objects that expire in
+// the hour of the given time.
+func expirationBucket(t time.Time) []byte {
+	return []byte(string(expirationTable) + "_" + strconv.FormatInt(t.Truncate(time.Hour).Unix(), 10))
+}
+
+// addExpiration adds an object to the index of objects by the hour they
+// expire, the garbage collection uses it to find the expired objects without
+// listing whole tables. The table of each hour is created on first use.
+func (db *DB) addExpiration(ctx context.Context, table []byte, id string, t time.Time) error {
+	if t.IsZero() {
+		return nil
+	}
+	bucket := expirationBucket(t)
+	if _, ok := db.expirationTables.Load(string(bucket)); !ok {
+		if err := db.db.CreateTable(bucket); err != nil {
+			return errors.Wrapf(err, "error creating table %s", bucket)
+		}
+		db.expirationTables.Store(string(bucket), true)
+	}
+	if err := db.db.Set(bucket, []byte(string(table)+"/"+id), []byte{}); err != nil {
+		return errors.Wrapf(err, "error saving expiration of %s %s", table, id)
+	}
+	return nil
+}
+
+// initExpirationCursor sets the cursor of the garbage collection to the
+// current hour if it does not exist. The objects indexed afterwards never
+// expire before it.
+func (db *DB) initExpirationCursor() error {
+	value := []byte(strconv.FormatInt(clock.Now().Truncate(time.Hour).Unix(), 10))
+	if _, _, err := db.db.CmpAndSwap(expirationTable, expirationCursorKey, nil, value); err != nil {
+		return errors.Wrap(err, "error initializing acme expiration cursor")
+	}
+	return nil
+}
+
+// getExpirationCursor returns the first hour of expirations that has not
+// been pruned yet.
+func (db *DB) getExpirationCursor() (time.Time, error) {
+	b, err := db.db.Get(expirationTable, expirationCursorKey)
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "error loading acme expiration cursor")
+	}
+	sec, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "error parsing acme expiration cursor %s", b)
+	}
+	return time.Unix(sec, 0).UTC(), nil
+}
+
+// PruneExpired deletes the orders, authorizations and rate limits that expired
+// before the given time, the challenges of the deleted authorizations and the
+// nonces created before the given time. The deleted orders and authorizations
+// are also removed from the indexes by account.
+//
+// The expired objects are found in the index of objects by the hour they
+// expire, starting from the first hour not pruned yet, so only the hours that
+// ended before the given time are read. At most batchSize objects are
+// processed per call, the next call continues where the previous one
+// stopped. A batchSize of 0 processes all of them.
+//
+// It returns the number of deleted objects by type, the index entries
+// updated are reported as orderIndexes and authzIndexes.
+func (db *DB) PruneExpired(ctx context.Context, before time.Time, batchSize int) (map[string]int, error) {
+	stats := map[string]int{
+		"orders":         0,
+		"authorizations": 0,
+		"challenges":     0,
+		"nonces":         0,
+		"orderIndexes":   0,
+		"authzIndexes":   0,
+		"rateLimits":     0,
+	}
+
+	cursor, err := db.getExpirationCursor()
+	if err != nil {
+		return stats, err
+	}
+
+	var processed int
+	for hour := cursor; !hour.Add(time.Hour).After(before); hour = hour.Add(time.Hour) {
+		bucket := expirationBucket(hour)
+		// The table does not exist if nothing expires in this hour.
+		if err := db.db.CreateTable(bucket); err != nil {
+			return stats, errors.Wrapf(err, "error creating table %s", bucket)
+		}
+		entries, err := db.db.List(bucket)
+		if err != nil {
+			return stats, errors.Wrapf(err, "error listing %s", bucket)
+		}
+		for _, e := range entries {
+			if batchSize > 0 && processed >= batchSize {
+				return stats, nil
+			}
+			if err := db.pruneExpiration(ctx, string(e.Key), before, stats); err != nil {
+				return stats, err
+			}
+			if err := db.db.Del(bucket, e.Key); err != nil {
+				return stats, errors.Wrapf(err, "error deleting %s entry %s", bucket, e.Key)
+			}
+			processed++
+		}
+		if err := db.db.DeleteTable(bucket); err != nil {
+			return stats, errors.Wrapf(err, "error deleting table %s", bucket)
+		}
+		db.expirationTables.Delete(string(bucket))
+
+		next := []byte(strconv.FormatInt(hour.Add(time.Hour).Unix(), 10))
+		if err := db.db.Set(expirationTable, expirationCursorKey, next); err != nil {
+			return stats, errors.Wrap(err, "error saving acme expiration cursor")
+		}
+	}
+	return stats, nil
+}
+
+// pruneExpiration deletes the object of an entry of the expiration index if
+// it expired before the given time. Objects that no longer exist are
+// skipped, and so are the rate limits updated after the entry was added, they
+// have a newer entry.
+func (db *DB) pruneExpiration(ctx context.Context, key string, before time.Time, stats map[string]int) error {
+	parts := strings.SplitN(key, "/", 2)
+	if len(parts) != 2 {
+		return nil
+	}
+	table, id := parts[0], parts[1]
+
+	switch table {
+	case string(orderTable):
+		o := new(dbOrder)
+		if ok, err := db.loadExpired(orderTable, id, o); err != nil || !ok || !isExpired(o.ExpiresAt, before) {
+			return err
+		}
+		if err := db.db.Del(orderTable, []byte(id)); err != nil {
+			return errors.Wrapf(err, "error deleting acme order %s", id)
+		}
+		stats["orders"]++
+		ordersByAccountMux.Lock()
+		updated, err := db.removeIndexID(ordersByAccountIDTable, []byte(o.AccountID), id)
+		ordersByAccountMux.Unlock()
+		if updated {
+			stats["orderIndexes"]++
+		}
+		if err != nil {
+			return err
+		}
+		updated, err = db.removeIndexID(allOrdersByAccountIDTable, []byte(o.AccountID), id)
+		if updated {
+			stats["orderIndexes"]++
+		}
+		return err
+	case string(authzTable):
+		az := new(dbAuthz)
+		if ok, err := db.loadExpired(authzTable, id, az); err != nil || !ok || !isExpired(az.ExpiresAt, before) {
+			return err
+		}
+		for _, chID := range az.ChallengeIDs {
+			if err := db.db.Del(challengeTable, []byte(chID)); err != nil {
+				return errors.Wrapf(err, "error deleting acme challenge %s", chID)
+			}
+			stats["challenges"]++
+		}
+		if err := db.db.Del(authzTable, []byte(id)); err != nil {
+			return errors.Wrapf(err, "error deleting acme authorization %s", id)
+		}
+		stats["authorizations"]++
+		authzsByAccountMux.Lock()
+		updated, err := db.removeIndexID(authzsByAccountIDTable, authzIndexKey(az.AccountID, authzIdentifier(az.Identifier, az.Wildcard)), id)
+		authzsByAccountMux.Unlock()
+		if updated {
+			stats["authzIndexes"]++
+		}
+		return err
+	case string(nonceTable):
+		n := new(dbNonce)
+		if ok, err := db.loadExpired(nonceTable, id, n); err != nil || !ok || !isExpired(n.CreatedAt, before) {
+			return err
+		}
+		if err := db.db.Del(nonceTable, []byte(id)); err != nil {
+			return errors.Wrapf(err, "error deleting acme nonce %s", id)
+		}
+		stats["nonces"]++
+	case string(rateLimitTable):
+		rl := new(dbRateLimit)
+		if ok, err := db.loadExpired(rateLimitTable, id, rl); err != nil || !ok || !isExpired(rl.ExpiresAt, before) {
+			return err
+		}
+		if err := db.db.Del(rateLimitTable, []byte(id)); err != nil {
+			return errors.Wrapf(err, "error deleting acme rate limit %s", id)
+		}
+		stats["rateLimits"]++
+	}
+	return nil
+}
+
+// loadExpired loads an object of the expiration index into v. It returns
+// false if the object does not exist or cannot be unmarshaled.
+func (db *DB) loadExpired(table []byte, id string, v interface{}) (bool, error) {
+	b, err := db.db.Get(table, []byte(id))
+	switch {
+	case nosql.IsErrNotFound(err):
+		return false, nil
+	case err != nil:
+		return false, errors.Wrapf(err, "error loading %s %s", table, id)
+	}
+	return json.Unmarshal(b, v) == nil, nil
+}
+
+// removeIndexID removes a deleted ID from an index of IDs by account. It
+// returns true if the entry was updated. The update is retried if the entry is
+// modified concurrently.
+func (db *DB) removeIndexID(table, key []byte, id string) (bool, error) {
+	for i := 0; i < indexRetries; i++ {
+		ids, b, err := db.getIndexIDs(table, key)
+		if err != nil {
+			return false, err
+		}
+		keep := []string{}
+		for _, v := range ids {
+			if v != id {
+				keep = append(keep, v)
+			}
+		}
+		if len(keep) == len(ids) {
+			return false, nil
+		}
+
+		newB, err := json.Marshal(keep)
+		if err != nil {
+			return false, errors.Wrapf(err, "error marshaling %s entry %s", table, key)
+		}
+		_, swapped, err := db.db.CmpAndSwap(table, key, b, newB)
+		if err != nil {
+			return false, errors.Wrapf(err, "error saving %s entry %s", table, key)
+		}
+		if swapped {
+			return true, nil
+		}
+	}
+	return false, errors.Errorf("error saving %s entry %s; too many concurrent updates", table, key)
+}
+
+func isExpired(t, before time.Time) bool {
+	return !t.IsZero() && t.Before(before)
+}
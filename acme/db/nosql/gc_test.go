@@ -0,0 +1,284 @@
+package nosql
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/nosql/database"
+)
+
+// memoryDB returns a MockNoSQLDB that stores the values in the given map of
+// tables.
+func memoryDB(tables map[string]map[string][]byte) *db.MockNoSQLDB {
+	return &db.MockNoSQLDB{
+		MCreateTable: func(bucket []byte) error {
+			if _, ok := tables[string(bucket)]; !ok {
+				tables[string(bucket)] = map[string][]byte{}
+			}
+			return nil
+		},
+		MDeleteTable: func(bucket []byte) error {
+			delete(tables, string(bucket))
+			return nil
+		},
+		MGet: func(bucket, key []byte) ([]byte, error) {
+			if v, ok := tables[string(bucket)][string(key)]; ok {
+				return v, nil
+			}
+			return nil, database.ErrNotFound
+		},
+		MSet: func(bucket, key, value []byte) error {
+			tables[string(bucket)][string(key)] = value
+			return nil
+		},
+		MDel: func(bucket, key []byte) error {
+			delete(tables[string(bucket)], string(key))
+			return nil
+		},
+		MList: func(bucket []byte) ([]*database.Entry, error) {
+			var entries []*database.Entry
+			for k, v := range tables[string(bucket)] {
+				entries = append(entries, &database.Entry{Bucket: bucket, Key: []byte(k), Value: v})
+			}
+			sort.Slice(entries, func(i, j int) bool {
+				return string(entries[i].Key) < string(entries[j].Key)
+			})
+			return entries, nil
+		},
+		MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+			if string(tables[string(bucket)][string(key)]) != string(old) {
+				return tables[string(bucket)][string(key)], false, nil
+			}
+			tables[string(bucket)][string(key)] = nu
+			return nu, true, nil
+		},
+	}
+}
+
+func TestDB_addExpiration(t *testing.T) {
+	now := clock.Now()
+	bucket := string(expirationBucket(now))
+	assert.Equals(t, bucket, "acme_expirations_"+strconv.FormatInt(now.Truncate(time.Hour).Unix(), 10))
+
+	var created int
+	tables := map[string]map[string][]byte{}
+	mdb := memoryDB(tables)
+	createTable := mdb.MCreateTable
+	mdb.MCreateTable = func(bucket []byte) error {
+		created++
+		return createTable(bucket)
+	}
+	d := &DB{db: mdb}
+	assert.FatalError(t, d.addExpiration(context.Background(), orderTable, "o1", now))
+	assert.FatalError(t, d.addExpiration(context.Background(), rateLimitTable, "provID/orders/accID", now))
+	assert.FatalError(t, d.addExpiration(context.Background(), nonceTable, "n1", time.Time{}))
+	assert.Equals(t, created, 1)
+	assert.Equals(t, tables, map[string]map[string][]byte{
+		bucket: {
+			"acme_orders/o1":                       {},
+			"acme_rate_limits/provID/orders/accID": {},
+		},
+	})
+
+	d = &DB{db: &db.MockNoSQLDB{
+		MCreateTable: func(bucket []byte) error {
+			return errors.New("force")
+		},
+	}}
+	err := d.addExpiration(context.Background(), orderTable, "o1", now)
+	if assert.Error(t, err) {
+		assert.Equals(t, err.Error(), "error creating table "+bucket+": force")
+	}
+}
+
+func TestDB_PruneExpired(t *testing.T) {
+	now := clock.Now()
+	before := now.Add(-time.Hour)
+	expired := now.Add(-3 * time.Hour)
+	cursor := []byte(strconv.FormatInt(expired.Add(-time.Hour).Truncate(time.Hour).Unix(), 10))
+
+	marshal := func(t *testing.T, v interface{}) []byte {
+		b, err := json.Marshal(v)
+		assert.FatalError(t, err)
+		return b
+	}
+	identifier := acme.Identifier{Type: "dns", Value: "example.com"}
+	store := func(t *testing.T) map[string]map[string][]byte {
+		return map[string]map[string][]byte{
+			string(expirationTable): {
+				"cursor": cursor,
+			},
+			string(expirationBucket(expired)): {
+				"acme_orders/o1":       {},
+				"acme_orders/o3":       {},
+				"acme_orders/o4":       {},
+				"acme_authzs/az1":      {},
+				"nonces/n1":            {},
+				"nonces/n3":            {},
+				"acme_rate_limits/rl1": {},
+				"acme_rate_limits/rl2": {},
+				"bad":                  {},
+			},
+			string(expirationBucket(now)): {
+				"acme_orders/o2":  {},
+				"acme_authzs/az2": {},
+				"nonces/n2":       {},
+			},
+			string(orderTable): {
+				"o1": marshal(t, &dbOrder{ID: "o1", AccountID: "acc1", ExpiresAt: expired}),
+				"o2": marshal(t, &dbOrder{ID: "o2", AccountID: "acc1", ExpiresAt: now}),
+				"o3": marshal(t, &dbOrder{ID: "o3", AccountID: "acc2", ExpiresAt: expired}),
+			},
+			string(authzTable): {
+				"az1": marshal(t, &dbAuthz{ID: "az1", AccountID: "acc1", Identifier: identifier, ExpiresAt: expired, ChallengeIDs: []string{"ch1", "ch2"}}),
+				"az2": marshal(t, &dbAuthz{ID: "az2", AccountID: "acc1", Identifier: identifier, ExpiresAt: now, ChallengeIDs: []string{"ch3"}}),
+			},
+			string(challengeTable): {
+				"ch1": []byte("{}"), "ch2": []byte("{}"), "ch3": []byte("{}"),
+			},
+			string(nonceTable): {
+				"n1": marshal(t, &dbNonce{ID: "n1", CreatedAt: expired}),
+				"n2": marshal(t, &dbNonce{ID: "n2", CreatedAt: now}),
+			},
+			string(rateLimitTable): {
+				"rl1": marshal(t, &dbRateLimit{Key: "rl1", Events: []time.Time{expired}, ExpiresAt: expired}),
+				"rl2": marshal(t, &dbRateLimit{Key: "rl2", Events: []time.Time{now}, ExpiresAt: now}),
+			},
+			string(ordersByAccountIDTable): {
+				"acc1": marshal(t, []string{"o1", "o2"}),
+				"acc2": marshal(t, []string{"o3"}),
+			},
+			string(allOrdersByAccountIDTable): {
+				"acc1": marshal(t, []string{"o0", "o1", "o2"}),
+				"acc2": marshal(t, []string{"o3"}),
+			},
+			string(authzsByAccountIDTable): {
+				"acc1#dns#example.com": marshal(t, []string{"az1", "az2"}),
+			},
+		}
+	}
+
+	type test struct {
+		tables    map[string]map[string][]byte
+		db        *db.MockNoSQLDB
+		batchSize int
+		want      map[string][]string
+		cursor    string
+		stats     map[string]int
+		err       error
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/cursor-error": func(t *testing.T) test {
+			return test{
+				db:  &db.MockNoSQLDB{Err: errors.New("force")},
+				err: errors.New("error loading acme expiration cursor: force"),
+			}
+		},
+		"fail/list-error": func(t *testing.T) test {
+			tables := store(t)
+			mdb := memoryDB(tables)
+			mdb.MList = func(bucket []byte) ([]*database.Entry, error) {
+				return nil, errors.New("force")
+			}
+			return test{
+				tables: tables,
+				db:     mdb,
+				err:    errors.Errorf("error listing %s: force", expirationBucket(expired.Add(-time.Hour))),
+			}
+		},
+		"fail/del-error": func(t *testing.T) test {
+			tables := store(t)
+			mdb := memoryDB(tables)
+			mdb.MDel = func(bucket, key []byte) error {
+				return errors.New("force")
+			}
+			return test{
+				tables: tables,
+				db:     mdb,
+				err:    errors.New("error deleting acme challenge ch1: force"),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			tables := store(t)
+			return test{
+				tables: tables,
+				db:     memoryDB(tables),
+				want: map[string][]string{
+					string(orderTable):                {"o2"},
+					string(authzTable):                {"az2"},
+					string(challengeTable):            {"ch3"},
+					string(nonceTable):                {"n2"},
+					string(rateLimitTable):            {"rl2"},
+					string(ordersByAccountIDTable):    {"acc1=[\"o2\"]", "acc2=[]"},
+					string(allOrdersByAccountIDTable): {"acc1=[\"o0\",\"o2\"]", "acc2=[]"},
+					string(authzsByAccountIDTable):    {"acc1#dns#example.com=[\"az2\"]"},
+				},
+				cursor: strconv.FormatInt(before.Truncate(time.Hour).Unix(), 10),
+				stats: map[string]int{
+					"orders": 2, "authorizations": 1, "challenges": 2, "nonces": 1,
+					"orderIndexes": 4, "authzIndexes": 1, "rateLimits": 1,
+				},
+			}
+		},
+		"ok/batch": func(t *testing.T) test {
+			tables := store(t)
+			return test{
+				tables:    tables,
+				db:        memoryDB(tables),
+				batchSize: 2,
+				want: map[string][]string{
+					string(orderTable):                {"o2", "o3"},
+					string(authzTable):                {"az2"},
+					string(challengeTable):            {"ch3"},
+					string(nonceTable):                {"n1", "n2"},
+					string(rateLimitTable):            {"rl1", "rl2"},
+					string(ordersByAccountIDTable):    {"acc1=[\"o2\"]", "acc2=[\"o3\"]"},
+					string(allOrdersByAccountIDTable): {"acc1=[\"o0\",\"o2\"]", "acc2=[\"o3\"]"},
+					string(authzsByAccountIDTable):    {"acc1#dns#example.com=[\"az2\"]"},
+				},
+				cursor: strconv.FormatInt(expired.Truncate(time.Hour).Unix(), 10),
+				stats: map[string]int{
+					"orders": 1, "authorizations": 1, "challenges": 2, "nonces": 0,
+					"orderIndexes": 2, "authzIndexes": 1, "rateLimits": 0,
+				},
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			d := DB{db: tc.db}
+			stats, err := d.PruneExpired(context.Background(), before, tc.batchSize)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+				return
+			}
+			if assert.Nil(t, tc.err) {
+				assert.Equals(t, stats, tc.stats)
+				for table, want := range tc.want {
+					var got []string
+					for k, v := range tc.tables[table] {
+						switch table {
+						case string(ordersByAccountIDTable), string(allOrdersByAccountIDTable), string(authzsByAccountIDTable):
+							k += "=" + string(v)
+						}
+						got = append(got, k)
+					}
+					sort.Strings(got)
+					assert.Equals(t, got, want)
+				}
+				assert.Equals(t, string(tc.tables[string(expirationTable)]["cursor"]), tc.cursor)
+			}
+		})
+	}
+}
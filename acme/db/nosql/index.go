@@ -0,0 +1,109 @@
+package nosql
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/nosql"
+)
+
+// indexRetries is the number of times an update of an index entry is retried
+// when it is modified concurrently.
+var indexRetries = 10
+
+// accountIndexesMigration is the key in the migrationTable set once the
+// indexes of orders and certificates by account have been populated with the
+// objects created by previous versions.
+var accountIndexesMigration = []byte("accountIndexes")
+
+// getIndexIDs returns the IDs of an entry of an index, and the stored bytes
+// to compare and swap the value. It returns nil values if the entry does not
+// exist.
+func (db *DB) getIndexIDs(table, key []byte) ([]string, []byte, error) {
+	b, err := db.db.Get(table, key)
+	switch {
+	case nosql.IsErrNotFound(err):
+		return nil, nil, nil
+	case err != nil:
+		return nil, nil, errors.Wrapf(err, "error loading %s entry %s", table, key)
+	}
+	var ids []string
+	if err := json.Unmarshal(b, &ids); err != nil {
+		return nil, nil, errors.Wrapf(err, "error unmarshaling %s entry %s", table, key)
+	}
+	return ids, b, nil
+}
+
+// addIndexID appends an ID to an entry of an index if it is not already
+// there. The update is retried if the entry is modified concurrently.
+func (db *DB) addIndexID(table, key []byte, id string) error {
+	for i := 0; i < indexRetries; i++ {
+		ids, b, err := db.getIndexIDs(table, key)
+		if err != nil {
+			return err
+		}
+		for _, v := range ids {
+			if v == id {
+				return nil
+			}
+		}
+		newB, err := json.Marshal(append(ids, id))
+		if err != nil {
+			return errors.Wrapf(err, "error marshaling %s entry %s", table, key)
+		}
+		_, swapped, err := db.db.CmpAndSwap(table, key, b, newB)
+		if err != nil {
+			return errors.Wrapf(err, "error saving %s entry %s", table, key)
+		}
+		if swapped {
+			return nil
+		}
+	}
+	return errors.Errorf("error saving %s entry %s; too many concurrent updates", table, key)
+}
+
+// migrateAccountIndexes adds the orders and certificates created before the
+// indexes of all orders and certificates by account existed. It only lists
+// the tables once, the migration is recorded in the migrationTable.
+func (db *DB) migrateAccountIndexes(ctx context.Context) error {
+	_, err := db.db.Get(migrationTable, accountIndexesMigration)
+	switch {
+	case err == nil:
+		return nil
+	case !nosql.IsErrNotFound(err):
+		return errors.Wrap(err, "error loading acme migrations")
+	}
+
+	orders, err := db.db.List(orderTable)
+	if err != nil {
+		return errors.Wrap(err, "error listing orders")
+	}
+	for _, e := range orders {
+		dbo := new(dbOrder)
+		if err := json.Unmarshal(e.Value, dbo); err != nil {
+			continue
+		}
+		if err := db.addIndexID(allOrdersByAccountIDTable, []byte(dbo.AccountID), dbo.ID); err != nil {
+			return err
+		}
+	}
+	certs, err := db.db.List(certTable)
+	if err != nil {
+		return errors.Wrap(err, "error listing certificates")
+	}
+	for _, e := range certs {
+		dbc := new(dbCert)
+		if err := json.Unmarshal(e.Value, dbc); err != nil {
+			continue
+		}
+		if err := db.addIndexID(certsByAccountIDTable, []byte(dbc.AccountID), dbc.ID); err != nil {
+			return err
+		}
+	}
+
+	if err := db.db.Set(migrationTable, accountIndexesMigration, []byte{}); err != nil {
+		return errors.Wrap(err, "error saving acme migrations")
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package nosql
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/nosql/database"
+)
+
+func TestDB_addIndexID(t *testing.T) {
+	tables := map[string]map[string][]byte{
+		string(certsByAccountIDTable): {},
+	}
+	d := &DB{db: memoryDB(tables)}
+	assert.FatalError(t, d.addIndexID(certsByAccountIDTable, []byte("accID"), "cert1"))
+	assert.FatalError(t, d.addIndexID(certsByAccountIDTable, []byte("accID"), "cert2"))
+	assert.FatalError(t, d.addIndexID(certsByAccountIDTable, []byte("accID"), "cert1"))
+	assert.Equals(t, string(tables[string(certsByAccountIDTable)]["accID"]), `["cert1","cert2"]`)
+
+	// Concurrent updates
+	mdb := memoryDB(tables)
+	mdb.MCmpAndSwap = func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+		return nil, false, nil
+	}
+	d = &DB{db: mdb}
+	err := d.addIndexID(certsByAccountIDTable, []byte("accID"), "cert3")
+	if assert.Error(t, err) {
+		assert.Equals(t, err.Error(), "error saving acme_account_certs_index entry accID; too many concurrent updates")
+	}
+
+	tables[string(certsByAccountIDTable)]["accID"] = []byte("foo")
+	err = d.addIndexID(certsByAccountIDTable, []byte("accID"), "cert3")
+	if assert.Error(t, err) {
+		assert.HasPrefix(t, err.Error(), "error unmarshaling acme_account_certs_index entry accID")
+	}
+}
+
+func TestDB_migrateAccountIndexes(t *testing.T) {
+	marshal := func(t *testing.T, v interface{}) []byte {
+		b, err := json.Marshal(v)
+		assert.FatalError(t, err)
+		return b
+	}
+	tables := map[string]map[string][]byte{
+		string(migrationTable):            {},
+		string(allOrdersByAccountIDTable): {"acc1": marshal(t, []string{"o1"})},
+		string(certsByAccountIDTable):     {},
+		string(orderTable): {
+			"o1":  marshal(t, &dbOrder{ID: "o1", AccountID: "acc1"}),
+			"o2":  marshal(t, &dbOrder{ID: "o2", AccountID: "acc1"}),
+			"o3":  marshal(t, &dbOrder{ID: "o3", AccountID: "acc2"}),
+			"bad": []byte("foo"),
+		},
+		string(certTable): {
+			"c1": marshal(t, &dbCert{ID: "c1", AccountID: "acc1"}),
+			"c2": marshal(t, &dbCert{ID: "c2", AccountID: "acc2"}),
+		},
+	}
+	d := &DB{db: memoryDB(tables)}
+	assert.FatalError(t, d.migrateAccountIndexes(context.Background()))
+	assert.Equals(t, tables[string(allOrdersByAccountIDTable)], map[string][]byte{
+		"acc1": []byte(`["o1","o2"]`),
+		"acc2": []byte(`["o3"]`),
+	})
+	assert.Equals(t, tables[string(certsByAccountIDTable)], map[string][]byte{
+		"acc1": []byte(`["c1"]`),
+		"acc2": []byte(`["c2"]`),
+	})
+	assert.Equals(t, tables[string(migrationTable)], map[string][]byte{
+		"accountIndexes": {},
+	})
+
+	// The tables are not listed again.
+	mdb := memoryDB(tables)
+	mdb.MList = func(bucket []byte) ([]*database.Entry, error) {
+		return nil, errors.New("force")
+	}
+	d = &DB{db: mdb}
+	assert.FatalError(t, d.migrateAccountIndexes(context.Background()))
+
+	delete(tables[string(migrationTable)], "accountIndexes")
+	err := d.migrateAccountIndexes(context.Background())
+	if assert.Error(t, err) {
+		assert.Equals(t, err.Error(), "error listing orders: force")
+	}
+}
@@ -34,6 +34,9 @@ func (db *DB) CreateNonce(ctx context.Context) (acme.Nonce, error) {
 	if err = db.save(ctx, id, n, nil, "nonce", nonceTable); err != nil {
 		return "", err
 	}
+	if err = db.addExpiration(ctx, nonceTable, id, n.CreatedAt); err != nil {
+		return "", err
+	}
 	return acme.Nonce(id), nil
 }
 
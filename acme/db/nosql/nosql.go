@@ -3,6 +3,7 @@ package nosql
 import (
 	"context"
 	"encoding/json"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -14,29 +15,60 @@ var (
 	accountTable           = []byte("acme_accounts")
 	accountByKeyIDTable    = []byte("acme_keyID_accountID_index")
 	authzTable             = []byte("acme_authzs")
+	authzsByAccountIDTable = []byte("acme_account_identifier_authzs_index")
 	challengeTable         = []byte("acme_challenges")
 	nonceTable             = []byte("nonces")
 	orderTable             = []byte("acme_orders")
 	ordersByAccountIDTable = []byte("acme_account_orders_index")
 	certTable              = []byte("acme_certs")
+	certBySerialTable      = []byte("acme_serial_certs_index")
+
+	allOrdersByAccountIDTable = []byte("acme_account_all_orders_index")
+	certsByAccountIDTable     = []byte("acme_account_certs_index")
+
+	externalAccountKeyTable             = []byte("acme_external_account_keys")
+	externalAccountKeysByReferenceTable = []byte("acme_external_account_key_reference_index")
+
+	rateLimitTable = []byte("acme_rate_limits")
+
+	expirationTable = []byte("acme_expirations")
+
+	migrationTable = []byte("acme_migrations")
 )
 
 // DB is a struct that implements the AcmeDB interface.
 type DB struct {
 	db nosqlDB.DB
+	// expirationTables caches the tables of the expiration index already
+	// created.
+	expirationTables sync.Map
 }
 
 // New configures and returns a new ACME DB backend implemented using a nosql DB.
 func New(db nosqlDB.DB) (*DB, error) {
 	tables := [][]byte{accountTable, accountByKeyIDTable, authzTable,
-		challengeTable, nonceTable, orderTable, ordersByAccountIDTable, certTable}
+		authzsByAccountIDTable, challengeTable, nonceTable, orderTable,
+		ordersByAccountIDTable, certTable, certBySerialTable,
+		externalAccountKeyTable, externalAccountKeysByReferenceTable,
+		rateLimitTable, expirationTable, allOrdersByAccountIDTable,
+		certsByAccountIDTable, migrationTable}
 	for _, b := range tables {
 		if err := db.CreateTable(b); err != nil {
 			return nil, errors.Wrapf(err, "error creating table %s",
 				string(b))
 		}
 	}
-	return &DB{db}, nil
+	acmeDB := &DB{db: db}
+	if err := acmeDB.initExpirationCursor(); err != nil {
+		return nil, err
+	}
+	if err := acmeDB.migrateSerialIndex(context.Background()); err != nil {
+		return nil, err
+	}
+	if err := acmeDB.migrateAccountIndexes(context.Background()); err != nil {
+		return nil, err
+	}
+	return acmeDB, nil
 }
 
 // save writes the new data to the database, overwriting the old data if it
@@ -23,6 +23,7 @@ type dbOrder struct {
 	Status           acme.Status       `json:"status"`
 	NotBefore        time.Time         `json:"notBefore,omitempty"`
 	NotAfter         time.Time         `json:"notAfter,omitempty"`
+	Profile          string            `json:"profile,omitempty"`
 	CreatedAt        time.Time         `json:"createdAt"`
 	ExpiresAt        time.Time         `json:"expiresAt,omitempty"`
 	CertificateID    string            `json:"certificate,omitempty"`
@@ -34,6 +35,23 @@ func (a *dbOrder) clone() *dbOrder {
 	return &b
 }
 
+func (a *dbOrder) toACME() *acme.Order {
+	return &acme.Order{
+		ID:               a.ID,
+		AccountID:        a.AccountID,
+		ProvisionerID:    a.ProvisionerID,
+		CertificateID:    a.CertificateID,
+		Status:           a.Status,
+		ExpiresAt:        a.ExpiresAt,
+		Identifiers:      a.Identifiers,
+		NotBefore:        a.NotBefore,
+		NotAfter:         a.NotAfter,
+		Profile:          a.Profile,
+		AuthorizationIDs: a.AuthorizationIDs,
+		Error:            a.Error,
+	}
+}
+
 // getDBOrder retrieves and unmarshals an ACME Order type from the database.
 func (db *DB) getDBOrder(ctx context.Context, id string) (*dbOrder, error) {
 	b, err := db.db.Get(orderTable, []byte(id))
@@ -55,22 +73,34 @@ func (db *DB) GetOrder(ctx context.Context, id string) (*acme.Order, error) {
 	if err != nil {
 		return nil, err
 	}
+	return dbo.toACME(), nil
+}
 
-	o := &acme.Order{
-		ID:               dbo.ID,
-		AccountID:        dbo.AccountID,
-		ProvisionerID:    dbo.ProvisionerID,
-		CertificateID:    dbo.CertificateID,
-		Status:           dbo.Status,
-		ExpiresAt:        dbo.ExpiresAt,
-		Identifiers:      dbo.Identifiers,
-		NotBefore:        dbo.NotBefore,
-		NotAfter:         dbo.NotAfter,
-		AuthorizationIDs: dbo.AuthorizationIDs,
-		Error:            dbo.Error,
+// GetAccountOrders retrieves all the orders of an ACME account, in any
+// status. Unlike GetOrdersByAccountID, that only returns the pending orders,
+// it uses the index of all the orders by account. Orders deleted after they
+// expired are skipped.
+func (db *DB) GetAccountOrders(ctx context.Context, accountID string) ([]*acme.Order, error) {
+	ids, _, err := db.getIndexIDs(allOrdersByAccountIDTable, []byte(accountID))
+	if err != nil {
+		return nil, err
 	}
 
-	return o, nil
+	orders := []*acme.Order{}
+	for _, id := range ids {
+		b, err := db.db.Get(orderTable, []byte(id))
+		if nosql.IsErrNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "error loading order %s", id)
+		}
+		dbo := new(dbOrder)
+		if err := json.Unmarshal(b, dbo); err != nil {
+			return nil, errors.Wrapf(err, "error unmarshaling order %s into dbOrder", id)
+		}
+		orders = append(orders, dbo.toACME())
+	}
+	return orders, nil
 }
 
 // CreateOrder creates ACME Order resources and saves them to the DB.
@@ -92,17 +122,21 @@ func (db *DB) CreateOrder(ctx context.Context, o *acme.Order) error {
 		Identifiers:      o.Identifiers,
 		NotBefore:        o.NotBefore,
 		NotAfter:         o.NotAfter,
+		Profile:          o.Profile,
 		AuthorizationIDs: o.AuthorizationIDs,
 	}
 	if err := db.save(ctx, o.ID, dbo, nil, "order", orderTable); err != nil {
 		return err
 	}
+	if err := db.addExpiration(ctx, orderTable, o.ID, o.ExpiresAt); err != nil {
+		return err
+	}
 
 	_, err = db.updateAddOrderIDs(ctx, o.AccountID, o.ID)
 	if err != nil {
 		return err
 	}
-	return nil
+	return db.addIndexID(allOrdersByAccountIDTable, []byte(o.AccountID), o.ID)
 }
 
 // UpdateOrder saves an updated ACME Order to the database.
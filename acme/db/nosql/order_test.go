@@ -76,6 +76,7 @@ func TestDB_getDBOrder(t *testing.T) {
 				CreatedAt:     now,
 				NotBefore:     now,
 				NotAfter:      now,
+				Profile:       "server",
 				Identifiers: []acme.Identifier{
 					{Type: "dns", Value: "test.ca.smallstep.com"},
 					{Type: "dns", Value: "example.foo.com"},
@@ -183,6 +184,7 @@ func TestDB_GetOrder(t *testing.T) {
 				CreatedAt:     now,
 				NotBefore:     now,
 				NotAfter:      now,
+				Profile:       "server",
 				Identifiers: []acme.Identifier{
 					{Type: "dns", Value: "test.ca.smallstep.com"},
 					{Type: "dns", Value: "example.foo.com"},
@@ -233,6 +235,7 @@ func TestDB_GetOrder(t *testing.T) {
 					assert.Equals(t, o.ExpiresAt, tc.dbo.ExpiresAt)
 					assert.Equals(t, o.NotBefore, tc.dbo.NotBefore)
 					assert.Equals(t, o.NotAfter, tc.dbo.NotAfter)
+					assert.Equals(t, o.Profile, tc.dbo.Profile)
 					assert.Equals(t, o.Identifiers, tc.dbo.Identifiers)
 					assert.Equals(t, o.AuthorizationIDs, tc.dbo.AuthorizationIDs)
 					assert.Equals(t, o.Error.Error(), tc.dbo.Error.Error())
@@ -242,6 +245,102 @@ func TestDB_GetOrder(t *testing.T) {
 	}
 }
 
+func TestDB_GetAccountOrders(t *testing.T) {
+	accID := "accID"
+	marshal := func(t *testing.T, v interface{}) []byte {
+		b, err := json.Marshal(v)
+		assert.FatalError(t, err)
+		return b
+	}
+	type test struct {
+		db     nosql.DB
+		err    error
+		orders []string
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/index-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						assert.Equals(t, bucket, allOrdersByAccountIDTable)
+						assert.Equals(t, string(key), accID)
+						return nil, errors.New("force")
+					},
+				},
+				err: errors.New("error loading acme_account_all_orders_index entry accID: force"),
+			}
+		},
+		"fail/db.Get-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						if string(bucket) == string(allOrdersByAccountIDTable) {
+							return marshal(t, []string{"order1"}), nil
+						}
+						return nil, errors.New("force")
+					},
+				},
+				err: errors.New("error loading order order1: force"),
+			}
+		},
+		"fail/unmarshal-error": func(t *testing.T) test {
+			tables := map[string]map[string][]byte{
+				string(allOrdersByAccountIDTable): {accID: marshal(t, []string{"foo"})},
+				string(orderTable):                {"foo": []byte("foo")},
+			}
+			return test{
+				db:  memoryDB(tables),
+				err: errors.New("error unmarshaling order foo into dbOrder"),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			tables := map[string]map[string][]byte{
+				string(allOrdersByAccountIDTable): {
+					accID:        marshal(t, []string{"order1", "order2", "order3"}),
+					"otherAccID": marshal(t, []string{"order4"}),
+				},
+				string(orderTable): {
+					// order2 has been deleted by the garbage collection.
+					"order1": marshal(t, &dbOrder{ID: "order1", AccountID: accID, Status: acme.StatusPending}),
+					"order3": marshal(t, &dbOrder{ID: "order3", AccountID: accID, Status: acme.StatusValid}),
+					"order4": marshal(t, &dbOrder{ID: "order4", AccountID: "otherAccID", Status: acme.StatusValid}),
+				},
+			}
+			return test{
+				db:     memoryDB(tables),
+				orders: []string{"order1", "order3"},
+			}
+		},
+		"ok/empty": func(t *testing.T) test {
+			return test{
+				db:     memoryDB(map[string]map[string][]byte{}),
+				orders: nil,
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			db := DB{db: tc.db}
+			orders, err := db.GetAccountOrders(context.Background(), accID)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+				return
+			}
+			if assert.Nil(t, tc.err) {
+				var ids []string
+				for _, o := range orders {
+					assert.Equals(t, o.AccountID, accID)
+					ids = append(ids, o.ID)
+				}
+				assert.Equals(t, ids, tc.orders)
+			}
+		})
+	}
+}
+
 func TestDB_UpdateOrder(t *testing.T) {
 	orderID := "orderID"
 	now := clock.Now()
@@ -501,6 +600,7 @@ func TestDB_CreateOrder(t *testing.T) {
 				ExpiresAt:     now,
 				NotBefore:     nbf,
 				NotAfter:      naf,
+				Profile:       "server",
 				Identifiers: []acme.Identifier{
 					{Type: "dns", Value: "test.ca.smallstep.com"},
 					{Type: "dns", Value: "example.foo.com"},
@@ -510,13 +610,18 @@ func TestDB_CreateOrder(t *testing.T) {
 			return test{
 				db: &db.MockNoSQLDB{
 					MGet: func(bucket, key []byte) ([]byte, error) {
-						assert.Equals(t, string(bucket), string(ordersByAccountIDTable))
-						assert.Equals(t, string(key), o.AccountID)
-						return nil, nosqldb.ErrNotFound
+						switch string(bucket) {
+						case string(ordersByAccountIDTable), string(allOrdersByAccountIDTable):
+							assert.Equals(t, string(key), o.AccountID)
+							return nil, nosqldb.ErrNotFound
+						default:
+							assert.FatalError(t, errors.Errorf("unexpected bucket %s", string(bucket)))
+							return nil, errors.New("force")
+						}
 					},
 					MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
 						switch string(bucket) {
-						case string(ordersByAccountIDTable):
+						case string(ordersByAccountIDTable), string(allOrdersByAccountIDTable):
 							b, err := json.Marshal([]string{o.ID})
 							assert.FatalError(t, err)
 							assert.Equals(t, string(key), "accID")
@@ -540,6 +645,7 @@ func TestDB_CreateOrder(t *testing.T) {
 							assert.Equals(t, dbo.ExpiresAt, o.ExpiresAt)
 							assert.Equals(t, dbo.NotBefore, o.NotBefore)
 							assert.Equals(t, dbo.NotAfter, o.NotAfter)
+							assert.Equals(t, dbo.Profile, o.Profile)
 							assert.Equals(t, dbo.AuthorizationIDs, o.AuthorizationIDs)
 							assert.Equals(t, dbo.Identifiers, o.Identifiers)
 							assert.Equals(t, dbo.Error, nil)
@@ -0,0 +1,86 @@
+package nosql
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/nosql"
+)
+
+// rateLimitRetries is the number of times an update of a rate limit is
+// retried when it is modified concurrently.
+var rateLimitRetries = 10
+
+// dbRateLimit stores the events of an ACME rate limit. The rate limit can be
+// deleted after ExpiresAt, when all its events are out of the window.
+type dbRateLimit struct {
+	Key       string      `json:"key"`
+	Events    []time.Time `json:"events"`
+	ExpiresAt time.Time   `json:"expiresAt"`
+}
+
+// getDBRateLimit retrieves and unmarshals a dbRateLimit, it also returns the
+// stored bytes to compare and swap the value. It returns nil values if the
+// rate limit does not exist.
+func (db *DB) getDBRateLimit(ctx context.Context, key string) (*dbRateLimit, []byte, error) {
+	data, err := db.db.Get(rateLimitTable, []byte(key))
+	switch {
+	case nosql.IsErrNotFound(err):
+		return nil, nil, nil
+	case err != nil:
+		return nil, nil, errors.Wrapf(err, "error loading rate limit %s", key)
+	}
+
+	dbrl := new(dbRateLimit)
+	if err := json.Unmarshal(data, dbrl); err != nil {
+		return nil, nil, errors.Wrapf(err, "error unmarshaling rate limit %s into dbRateLimit", key)
+	}
+	return dbrl, data, nil
+}
+
+// GetRateLimitEvents returns the events registered with a rate limit key.
+func (db *DB) GetRateLimitEvents(ctx context.Context, key string) ([]time.Time, error) {
+	dbrl, _, err := db.getDBRateLimit(ctx, key)
+	if err != nil || dbrl == nil {
+		return nil, err
+	}
+	return dbrl.Events, nil
+}
+
+// AddRateLimitEvent registers an event with a rate limit key, the events
+// older than the window are removed. The update is retried if the rate limit
+// is modified concurrently.
+func (db *DB) AddRateLimitEvent(ctx context.Context, key string, t time.Time, window time.Duration) error {
+	since := t.Add(-window)
+	for i := 0; i < rateLimitRetries; i++ {
+		old, oldB, err := db.getDBRateLimit(ctx, key)
+		if err != nil {
+			return err
+		}
+
+		nu := &dbRateLimit{Key: key, ExpiresAt: t.Add(window)}
+		if old != nil {
+			for _, e := range old.Events {
+				if e.After(since) {
+					nu.Events = append(nu.Events, e)
+				}
+			}
+		}
+		nu.Events = append(nu.Events, t)
+
+		newB, err := json.Marshal(nu)
+		if err != nil {
+			return errors.Wrapf(err, "error marshaling rate limit %s", key)
+		}
+		_, swapped, err := db.db.CmpAndSwap(rateLimitTable, []byte(key), oldB, newB)
+		if err != nil {
+			return errors.Wrapf(err, "error saving rate limit %s", key)
+		}
+		if swapped {
+			return db.addExpiration(ctx, rateLimitTable, key, nu.ExpiresAt)
+		}
+	}
+	return errors.Errorf("error saving rate limit %s; too many concurrent updates", key)
+}
@@ -0,0 +1,194 @@
+package nosql
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/nosql"
+	"github.com/smallstep/nosql/database"
+)
+
+func TestDB_GetRateLimitEvents(t *testing.T) {
+	key := "provID/orders/accID"
+	now := clock.Now().Round(time.Second)
+	type test struct {
+		db     nosql.DB
+		err    error
+		events []time.Time
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"ok/not-found": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, k []byte) ([]byte, error) {
+						assert.Equals(t, bucket, rateLimitTable)
+						assert.Equals(t, string(k), key)
+						return nil, database.ErrNotFound
+					},
+				},
+			}
+		},
+		"fail/db.Get-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, k []byte) ([]byte, error) {
+						return nil, errors.New("force")
+					},
+				},
+				err: errors.New("error loading rate limit provID/orders/accID: force"),
+			}
+		},
+		"fail/unmarshal-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, k []byte) ([]byte, error) {
+						return []byte("foo"), nil
+					},
+				},
+				err: errors.New("error unmarshaling rate limit provID/orders/accID into dbRateLimit"),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			b, err := json.Marshal(&dbRateLimit{Key: key, Events: []time.Time{now}})
+			assert.FatalError(t, err)
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, k []byte) ([]byte, error) {
+						return b, nil
+					},
+				},
+				events: []time.Time{now},
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			d := DB{db: tc.db}
+			events, err := d.GetRateLimitEvents(context.Background(), key)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else if assert.Nil(t, tc.err) {
+				assert.Equals(t, len(events), len(tc.events))
+				for i := range events {
+					assert.True(t, events[i].Equal(tc.events[i]))
+				}
+			}
+		})
+	}
+}
+
+func TestDB_AddRateLimitEvent(t *testing.T) {
+	key := "provID/orders/accID"
+	now := clock.Now().Round(time.Second)
+	old := now.Add(-2 * time.Hour)
+	recent := now.Add(-time.Minute)
+	stored, err := json.Marshal(&dbRateLimit{Key: key, Events: []time.Time{old, recent}})
+	assert.FatalError(t, err)
+
+	type test struct {
+		db  nosql.DB
+		err error
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/db.Get-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, k []byte) ([]byte, error) {
+						return nil, errors.New("force")
+					},
+				},
+				err: errors.New("error loading rate limit provID/orders/accID: force"),
+			}
+		},
+		"fail/cmpAndSwap-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, k []byte) ([]byte, error) {
+						return nil, database.ErrNotFound
+					},
+					MCmpAndSwap: func(bucket, k, o, nu []byte) ([]byte, bool, error) {
+						return nil, false, errors.New("force")
+					},
+				},
+				err: errors.New("error saving rate limit provID/orders/accID: force"),
+			}
+		},
+		"fail/too-many-updates": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, k []byte) ([]byte, error) {
+						return stored, nil
+					},
+					MCmpAndSwap: func(bucket, k, o, nu []byte) ([]byte, bool, error) {
+						return nil, false, nil
+					},
+				},
+				err: errors.New("error saving rate limit provID/orders/accID; too many concurrent updates"),
+			}
+		},
+		"ok/new": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, k []byte) ([]byte, error) {
+						return nil, database.ErrNotFound
+					},
+					MCmpAndSwap: func(bucket, k, o, nu []byte) ([]byte, bool, error) {
+						assert.Equals(t, bucket, rateLimitTable)
+						assert.Equals(t, string(k), key)
+						assert.Nil(t, o)
+
+						dbrl := new(dbRateLimit)
+						assert.FatalError(t, json.Unmarshal(nu, dbrl))
+						assert.Equals(t, dbrl.Key, key)
+						assert.Equals(t, len(dbrl.Events), 1)
+						assert.True(t, dbrl.Events[0].Equal(now))
+						assert.True(t, dbrl.ExpiresAt.Equal(now.Add(time.Hour)))
+						return nu, true, nil
+					},
+				},
+			}
+		},
+		"ok/prune-and-retry": func(t *testing.T) test {
+			var calls int
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, k []byte) ([]byte, error) {
+						return stored, nil
+					},
+					MCmpAndSwap: func(bucket, k, o, nu []byte) ([]byte, bool, error) {
+						calls++
+						assert.Equals(t, o, stored)
+
+						dbrl := new(dbRateLimit)
+						assert.FatalError(t, json.Unmarshal(nu, dbrl))
+						assert.Equals(t, len(dbrl.Events), 2)
+						assert.True(t, dbrl.Events[0].Equal(recent))
+						assert.True(t, dbrl.Events[1].Equal(now))
+						return nu, calls > 1, nil
+					},
+				},
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			d := DB{db: tc.db}
+			if err := d.AddRateLimitEvent(context.Background(), key, now, time.Hour); err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,787 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/smallstep/certificates/authority/provisioner"
+	"go.step.sm/crypto/jose"
+)
+
+var (
+	oidSubjectAltName      = asn1.ObjectIdentifier{2, 5, 29, 17}
+	oidPermanentIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 8, 3}
+	oidAppleSerialNumber   = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 8, 9, 1}
+	oidAppleUDID           = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 8, 9, 2}
+	oidAppleNonce          = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 8, 11, 1}
+	oidYubicoSerialNumber  = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 41482, 3, 7}
+	oidTCGKpAIKCertificate = asn1.ObjectIdentifier{2, 23, 133, 8, 3}
+)
+
+// AttestationOptions are the options used to validate the device-attest-01
+// challenges of a provisioner.
+type AttestationOptions struct {
+	// Formats are the attestation statement formats accepted.
+	Formats []provisioner.ACMEAttestationFormat
+	// Roots are the root certificates used to verify the attestation
+	// certificates.
+	Roots *x509.CertPool
+}
+
+func (o *AttestationOptions) isEnabled(format provisioner.ACMEAttestationFormat) bool {
+	for _, f := range o.Formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// attestationObject is the CBOR attestation object sent in the payload of a
+// device-attest-01 challenge.
+type attestationObject struct {
+	Format       provisioner.ACMEAttestationFormat
+	AttStatement map[string]interface{}
+}
+
+// attestationData is the information verified by an attestation statement.
+type attestationData struct {
+	// PermanentIdentifier is the identifier of the device.
+	PermanentIdentifier string
+	// Fingerprint is the fingerprint of the attested key, the CSR of the
+	// order must use the same key.
+	Fingerprint string
+}
+
+// KeyFingerprint returns the base64url encoded SHA-256 hash of the
+// SubjectPublicKeyInfo of the given key.
+func KeyFingerprint(key crypto.PublicKey) (string, error) {
+	b, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", WrapErrorISE(err, "error marshaling public key")
+	}
+	sum := sha256.Sum256(b)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func deviceAttest01Validate(ctx context.Context, ch *Challenge, db DB, jwk *jose.JSONWebKey, vo *ValidateChallengeOptions) error {
+	if vo.Attestation == nil {
+		return storeError(ctx, db, ch, true, NewError(ErrorServerInternalType,
+			"device-attest-01 challenges are not supported"))
+	}
+
+	var p struct {
+		AttObj string `json:"attObj"`
+	}
+	if err := json.Unmarshal(ch.Payload, &p); err != nil {
+		return storeError(ctx, db, ch, true, WrapError(ErrorMalformedType, err,
+			"error unmarshaling challenge payload"))
+	}
+	if p.AttObj == "" {
+		return storeError(ctx, db, ch, true, NewError(ErrorMalformedType,
+			"challenge payload is missing the attObj"))
+	}
+	b, err := base64.RawURLEncoding.DecodeString(p.AttObj)
+	if err != nil {
+		return storeError(ctx, db, ch, true, WrapError(ErrorMalformedType, err,
+			"error base64 decoding attObj"))
+	}
+	att, err := parseAttestationObject(b)
+	if err != nil {
+		return storeError(ctx, db, ch, true, WrapError(ErrorBadAttestationStatementType, err,
+			"error parsing attestation object"))
+	}
+	if !vo.Attestation.isEnabled(att.Format) {
+		return storeError(ctx, db, ch, true, NewError(ErrorBadAttestationStatementType,
+			"attestation format %s is not allowed", att.Format))
+	}
+
+	keyAuth, err := KeyAuthorization(ch.Token, jwk)
+	if err != nil {
+		return err
+	}
+
+	var data *attestationData
+	switch att.Format {
+	case provisioner.ACMEAttestationApple:
+		data, err = appleAttestation(ch, att.AttStatement, vo.Attestation.Roots)
+	case provisioner.ACMEAttestationStep:
+		data, err = stepAttestation(keyAuth, att.AttStatement, vo.Attestation.Roots)
+	case provisioner.ACMEAttestationPacked:
+		data, err = packedAttestation(keyAuth, att.AttStatement, vo.Attestation.Roots)
+	case provisioner.ACMEAttestationTPM:
+		data, err = tpmAttestation(keyAuth, att.AttStatement, vo.Attestation.Roots)
+	}
+	if err != nil {
+		return storeError(ctx, db, ch, true, WrapError(ErrorBadAttestationStatementType, err,
+			"error validating %s attestation statement", att.Format))
+	}
+	if data.PermanentIdentifier != ch.Value {
+		return storeError(ctx, db, ch, true, NewError(ErrorRejectedIdentifierType,
+			"permanent identifier does not match; expected %s, but got %s", ch.Value, data.PermanentIdentifier))
+	}
+
+	// Update and store the challenge.
+	ch.Status = StatusValid
+	ch.Error = nil
+	ch.ValidatedAt = clock.Now().Format(time.RFC3339)
+	ch.Fingerprint = data.Fingerprint
+
+	if err = db.UpdateChallenge(ctx, ch); err != nil {
+		return WrapErrorISE(err, "error updating challenge")
+	}
+	return nil
+}
+
+// parseAttestationObject decodes the CBOR attestation object, a map with the
+// format in the "fmt" key and the statement in the "attStmt" key.
+func parseAttestationObject(b []byte) (*attestationObject, error) {
+	v, err := cborDecode(b)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("attestation object is not a map")
+	}
+	format, ok := m["fmt"].(string)
+	if !ok || format == "" {
+		return nil, errors.New("attestation object is missing the fmt")
+	}
+	stmt, ok := m["attStmt"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("attestation object is missing the attStmt")
+	}
+	return &attestationObject{
+		Format:       provisioner.ACMEAttestationFormat(format),
+		AttStatement: stmt,
+	}, nil
+}
+
+// appleAttestation verifies the attestation of an Apple managed device. The
+// nonce in the attestation certificate must be the hash of the challenge
+// token, and the identifier the serial number or the UDID of the device.
+func appleAttestation(ch *Challenge, stmt map[string]interface{}, roots *x509.CertPool) (*attestationData, error) {
+	leaf, err := verifyX5C(stmt, roots)
+	if err != nil {
+		return nil, err
+	}
+
+	var serialNumber, udid string
+	var nonce []byte
+	for _, ext := range leaf.Extensions {
+		switch {
+		case ext.Id.Equal(oidAppleSerialNumber):
+			serialNumber = string(ext.Value)
+		case ext.Id.Equal(oidAppleUDID):
+			udid = string(ext.Value)
+		case ext.Id.Equal(oidAppleNonce):
+			nonce = ext.Value
+		}
+	}
+
+	sum := sha256.Sum256([]byte(ch.Token))
+	if subtle.ConstantTimeCompare(nonce, sum[:]) != 1 {
+		return nil, errors.New("attestation nonce does not match the challenge token")
+	}
+
+	data := &attestationData{PermanentIdentifier: serialNumber}
+	if udid != "" && ch.Value == udid {
+		data.PermanentIdentifier = udid
+	}
+	if data.Fingerprint, err = KeyFingerprint(leaf.PublicKey); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// stepAttestation verifies the attestation of a key generated in a YubiKey
+// PIV slot. The attestation certificate certifies the key, and the key
+// signs the key authorization. The identifier is the serial number of the
+// YubiKey.
+func stepAttestation(keyAuth string, stmt map[string]interface{}, roots *x509.CertPool) (*attestationData, error) {
+	leaf, err := verifyX5C(stmt, roots)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyStatementSignature(stmt, leaf.PublicKey, []byte(keyAuth)); err != nil {
+		return nil, err
+	}
+
+	data := &attestationData{}
+	for _, ext := range leaf.Extensions {
+		if ext.Id.Equal(oidYubicoSerialNumber) {
+			var serialNumber int64
+			if _, err := asn1.Unmarshal(ext.Value, &serialNumber); err != nil {
+				return nil, fmt.Errorf("error parsing serial number: %w", err)
+			}
+			data.PermanentIdentifier = strconv.FormatInt(serialNumber, 10)
+			break
+		}
+	}
+	if data.PermanentIdentifier == "" {
+		return nil, errors.New("attestation certificate is missing the serial number")
+	}
+	if data.Fingerprint, err = KeyFingerprint(leaf.PublicKey); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// packedAttestation verifies an attestation signed with a device key whose
+// certificate includes the permanent identifier of the device, in a
+// permanentIdentifier subject alternative name or in the serialNumber of the
+// subject. The key signs the key authorization.
+func packedAttestation(keyAuth string, stmt map[string]interface{}, roots *x509.CertPool) (*attestationData, error) {
+	leaf, err := verifyX5C(stmt, roots)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyStatementSignature(stmt, leaf.PublicKey, []byte(keyAuth)); err != nil {
+		return nil, err
+	}
+
+	data := &attestationData{}
+	if data.PermanentIdentifier, err = certificatePermanentIdentifier(leaf); err != nil {
+		return nil, err
+	}
+	if data.Fingerprint, err = KeyFingerprint(leaf.PublicKey); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// tpmAttestation verifies a TPM 2.0 key certification. The attestation key
+// certificate includes the permanent identifier of the device, and the key
+// signs the certInfo, a TPMS_ATTEST structure that certifies the key in the
+// pubArea and includes the hash of the key authorization.
+func tpmAttestation(keyAuth string, stmt map[string]interface{}, roots *x509.CertPool) (*attestationData, error) {
+	if ver, _ := stmt["ver"].(string); ver != "2.0" {
+		return nil, errors.New("tpm version is not supported")
+	}
+	certInfo, ok := stmt["certInfo"].([]byte)
+	if !ok {
+		return nil, errors.New("attestation statement is missing the certInfo")
+	}
+	pubArea, ok := stmt["pubArea"].([]byte)
+	if !ok {
+		return nil, errors.New("attestation statement is missing the pubArea")
+	}
+	leaf, err := verifyX5C(stmt, roots)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyAIKCertificate(leaf); err != nil {
+		return nil, err
+	}
+	if err := verifyStatementSignature(stmt, leaf.PublicKey, certInfo); err != nil {
+		return nil, err
+	}
+
+	pub, attributes, name, err := parseTPMPublic(pubArea)
+	if err != nil {
+		return nil, err
+	}
+	if attributes&tpmKeyAttributes != tpmKeyAttributes {
+		return nil, errors.New("tpm key must have the fixedTPM, fixedParent and sensitiveDataOrigin attributes")
+	}
+	sum := sha256.Sum256([]byte(keyAuth))
+	if err := verifyTPMCertifyInfo(certInfo, sum[:], name); err != nil {
+		return nil, err
+	}
+
+	data := &attestationData{}
+	if data.PermanentIdentifier, err = certificatePermanentIdentifier(leaf); err != nil {
+		return nil, err
+	}
+	if data.Fingerprint, err = KeyFingerprint(pub); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// verifyX5C verifies the certificate chain in the x5c of the statement and
+// returns the leaf.
+func verifyX5C(stmt map[string]interface{}, roots *x509.CertPool) (*x509.Certificate, error) {
+	if roots == nil {
+		return nil, errors.New("attestation roots are not configured")
+	}
+	x5c, ok := stmt["x5c"].([]interface{})
+	if !ok || len(x5c) == 0 {
+		return nil, errors.New("attestation statement is missing the x5c")
+	}
+	certs := make([]*x509.Certificate, len(x5c))
+	for i, v := range x5c {
+		der, ok := v.([]byte)
+		if !ok {
+			return nil, errors.New("x5c is not valid")
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing x5c certificate: %w", err)
+		}
+		certs[i] = cert
+	}
+
+	// The subject alternative name of AIK certificates is critical and it
+	// only has names not supported by Go, the permanent identifier is parsed
+	// by certificatePermanentIdentifier.
+	unhandled := certs[0].UnhandledCriticalExtensions[:0]
+	for _, oid := range certs[0].UnhandledCriticalExtensions {
+		if !oid.Equal(oidSubjectAltName) {
+			unhandled = append(unhandled, oid)
+		}
+	}
+	certs[0].UnhandledCriticalExtensions = unhandled
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   clock.Now(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return nil, fmt.Errorf("error verifying x5c certificate chain: %w", err)
+	}
+	return certs[0], nil
+}
+
+// verifyAIKCertificate verifies that the attestation certificate of a tpm
+// statement is an AIK certificate, see the requirements of section 8.3.1 of
+// the WebAuthn specification.
+func verifyAIKCertificate(cert *x509.Certificate) error {
+	if cert.Version != 3 {
+		return errors.New("aik certificate version must be 3")
+	}
+	if len(cert.Subject.ToRDNSequence()) != 0 {
+		return errors.New("aik certificate subject must be empty")
+	}
+	for _, eku := range cert.UnknownExtKeyUsage {
+		if eku.Equal(oidTCGKpAIKCertificate) {
+			return nil
+		}
+	}
+	return errors.New("aik certificate is missing the tcg-kp-AIKCertificate extended key usage")
+}
+
+// verifyStatementSignature verifies the sig of the statement, alg is the
+// COSE algorithm used.
+func verifyStatementSignature(stmt map[string]interface{}, key crypto.PublicKey, message []byte) error {
+	alg, ok := stmt["alg"].(int64)
+	if !ok {
+		return errors.New("attestation statement is missing the alg")
+	}
+	sig, ok := stmt["sig"].([]byte)
+	if !ok {
+		return errors.New("attestation statement is missing the sig")
+	}
+
+	var valid bool
+	switch alg {
+	case -7: // ES256
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("alg does not match the key type")
+		}
+		sum := sha256.Sum256(message)
+		valid = verifyECDSA(k, sum[:], sig)
+	case -35: // ES384
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("alg does not match the key type")
+		}
+		sum := sha512.Sum384(message)
+		valid = verifyECDSA(k, sum[:], sig)
+	case -257: // RS256
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("alg does not match the key type")
+		}
+		sum := sha256.Sum256(message)
+		valid = rsa.VerifyPKCS1v15(k, crypto.SHA256, sum[:], sig) == nil
+	case -37: // PS256
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("alg does not match the key type")
+		}
+		sum := sha256.Sum256(message)
+		valid = rsa.VerifyPSS(k, crypto.SHA256, sum[:], sig, nil) == nil
+	case -8: // EdDSA
+		k, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return errors.New("alg does not match the key type")
+		}
+		valid = ed25519.Verify(k, message, sig)
+	default:
+		return fmt.Errorf("alg %d is not supported", alg)
+	}
+	if !valid {
+		return errors.New("attestation signature is not valid")
+	}
+	return nil
+}
+
+func verifyECDSA(key *ecdsa.PublicKey, digest, sig []byte) bool {
+	var s struct {
+		R, S *big.Int
+	}
+	if rest, err := asn1.Unmarshal(sig, &s); err != nil || len(rest) > 0 {
+		return false
+	}
+	return ecdsa.Verify(key, digest, s.R, s.S)
+}
+
+// otherName is the otherName choice of a GeneralName, the value is
+// explicitly tagged with [0].
+type otherName struct {
+	TypeID asn1.ObjectIdentifier
+	Value  asn1.RawValue
+}
+
+// permanentIdentifier is the permanentIdentifier otherName defined in
+// RFC 4043.
+type permanentIdentifier struct {
+	IdentifierValue string                `asn1:"utf8,optional"`
+	Assigner        asn1.ObjectIdentifier `asn1:"optional"`
+}
+
+// certificatePermanentIdentifier returns the permanent identifier in the
+// subject alternative names of the certificate, or the serial number of the
+// subject if there is none.
+func certificatePermanentIdentifier(cert *x509.Certificate) (string, error) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidSubjectAltName) {
+			continue
+		}
+		var names []asn1.RawValue
+		if _, err := asn1.Unmarshal(ext.Value, &names); err != nil {
+			return "", fmt.Errorf("error parsing subject alternative names: %w", err)
+		}
+		for _, n := range names {
+			if n.Class != asn1.ClassContextSpecific || n.Tag != 0 {
+				continue
+			}
+			var on otherName
+			if _, err := asn1.UnmarshalWithParams(n.FullBytes, &on, "tag:0"); err != nil {
+				return "", fmt.Errorf("error parsing otherName: %w", err)
+			}
+			if !on.TypeID.Equal(oidPermanentIdentifier) || on.Value.Class != asn1.ClassContextSpecific || on.Value.Tag != 0 {
+				continue
+			}
+			var pi permanentIdentifier
+			if _, err := asn1.Unmarshal(on.Value.Bytes, &pi); err != nil {
+				return "", fmt.Errorf("error parsing permanentIdentifier: %w", err)
+			}
+			if pi.IdentifierValue != "" {
+				return pi.IdentifierValue, nil
+			}
+		}
+	}
+	if cert.Subject.SerialNumber != "" {
+		return cert.Subject.SerialNumber, nil
+	}
+	return "", errors.New("attestation certificate is missing the permanent identifier")
+}
+
+// TPM constants, see the TPM 2.0 specification, part 2.
+const (
+	tpmGeneratedValue  = 0xff544347
+	tpmSTAttestCertify = 0x8017
+	tpmAlgRSA          = 0x0001
+	tpmAlgSHA1         = 0x0004
+	tpmAlgSHA256       = 0x000b
+	tpmAlgSHA384       = 0x000c
+	tpmAlgNull         = 0x0010
+	tpmAlgECC          = 0x0023
+	tpmECCNistP256     = 0x0003
+	tpmECCNistP384     = 0x0004
+	tpmECCNistP521     = 0x0005
+
+	// Object attributes of the certified keys: fixedTPM, fixedParent and
+	// sensitiveDataOrigin.
+	tpmKeyAttributes = 0x00000002 | 0x00000010 | 0x00000020
+)
+
+// tpmReader reads the big-endian TPM structures.
+type tpmReader struct {
+	b   []byte
+	err error
+}
+
+func (r *tpmReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > len(r.b) {
+		r.err = errors.New("tpm structure is too short")
+		return nil
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *tpmReader) uint16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *tpmReader) uint32() uint32 {
+	if b := r.bytes(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+// tpm2b reads a sized buffer.
+func (r *tpmReader) tpm2b() []byte {
+	return r.bytes(int(r.uint16()))
+}
+
+// scheme reads an algorithm and its details, all the schemes used in the
+// public area have a hash algorithm or a key size as details.
+func (r *tpmReader) scheme() {
+	if alg := r.uint16(); alg != tpmAlgNull {
+		r.uint16()
+	}
+}
+
+func tpmHash(alg uint16) (hash.Hash, error) {
+	switch alg {
+	case tpmAlgSHA1:
+		return sha1.New(), nil
+	case tpmAlgSHA256:
+		return sha256.New(), nil
+	case tpmAlgSHA384:
+		return sha512.New384(), nil
+	default:
+		return nil, fmt.Errorf("tpm hash algorithm %#04x is not supported", alg)
+	}
+}
+
+// parseTPMPublic parses the TPMT_PUBLIC structure of the certified key, it
+// returns the public key, its object attributes and the TPM name of the key.
+func parseTPMPublic(pubArea []byte) (crypto.PublicKey, uint32, []byte, error) {
+	r := &tpmReader{b: pubArea}
+	typ := r.uint16()
+	nameAlg := r.uint16()
+	attributes := r.uint32()
+	r.tpm2b() // authPolicy
+
+	var pub crypto.PublicKey
+	switch typ {
+	case tpmAlgRSA:
+		// TPMS_RSA_PARMS: symmetric, scheme, keyBits and exponent.
+		if alg := r.uint16(); alg != tpmAlgNull {
+			r.uint16()
+			r.uint16()
+		}
+		r.scheme()
+		r.uint16()
+		exponent := r.uint32()
+		if exponent == 0 {
+			exponent = 65537
+		}
+		modulus := r.tpm2b()
+		if r.err == nil {
+			pub = &rsa.PublicKey{N: new(big.Int).SetBytes(modulus), E: int(exponent)}
+		}
+	case tpmAlgECC:
+		// TPMS_ECC_PARMS: symmetric, scheme, curveID and kdf.
+		if alg := r.uint16(); alg != tpmAlgNull {
+			r.uint16()
+			r.uint16()
+		}
+		r.scheme()
+		curveID := r.uint16()
+		r.scheme()
+		x, y := r.tpm2b(), r.tpm2b()
+		if r.err == nil {
+			var curve elliptic.Curve
+			switch curveID {
+			case tpmECCNistP256:
+				curve = elliptic.P256()
+			case tpmECCNistP384:
+				curve = elliptic.P384()
+			case tpmECCNistP521:
+				curve = elliptic.P521()
+			default:
+				return nil, 0, nil, fmt.Errorf("tpm curve %#04x is not supported", curveID)
+			}
+			pub = &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	default:
+		return nil, 0, nil, fmt.Errorf("tpm key type %#04x is not supported", typ)
+	}
+	if r.err != nil {
+		return nil, 0, nil, fmt.Errorf("error parsing pubArea: %w", r.err)
+	}
+
+	h, err := tpmHash(nameAlg)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	h.Write(pubArea)
+	name := make([]byte, 2, 2+h.Size())
+	binary.BigEndian.PutUint16(name, nameAlg)
+	return pub, attributes, h.Sum(name), nil
+}
+
+// verifyTPMCertifyInfo verifies that the TPMS_ATTEST structure certifies
+// the key with the given name and includes the given extra data.
+func verifyTPMCertifyInfo(certInfo, extraData, name []byte) error {
+	r := &tpmReader{b: certInfo}
+	magic := r.uint32()
+	typ := r.uint16()
+	r.tpm2b() // qualifiedSigner
+	data := r.tpm2b()
+	r.bytes(17) // clockInfo
+	r.bytes(8)  // firmwareVersion
+	attestedName := r.tpm2b()
+	r.tpm2b() // qualifiedName
+	switch {
+	case r.err != nil:
+		return fmt.Errorf("error parsing certInfo: %w", r.err)
+	case magic != tpmGeneratedValue:
+		return errors.New("certInfo magic is not valid")
+	case typ != tpmSTAttestCertify:
+		return errors.New("certInfo type is not valid")
+	case subtle.ConstantTimeCompare(data, extraData) != 1:
+		return errors.New("certInfo extraData does not match the key authorization")
+	case !bytes.Equal(attestedName, name):
+		return errors.New("certInfo name does not match the pubArea")
+	}
+	return nil
+}
+
+// permanentIdentifierSANs returns a certificate enforcer that adds the
+// permanent identifiers to the subject alternative names of the
+// certificate. The extension is encoded with the other names in the
+// certificate, Go does not support otherNames.
+func permanentIdentifierSANs(ids []string) provisioner.CertificateEnforcerFunc {
+	return func(cert *x509.Certificate) error {
+		var names []asn1.RawValue
+		for _, name := range cert.DNSNames {
+			names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, Bytes: []byte(name)})
+		}
+		for _, email := range cert.EmailAddresses {
+			names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, Bytes: []byte(email)})
+		}
+		for _, ip := range cert.IPAddresses {
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 7, Bytes: []byte(ip)})
+		}
+		for _, uri := range cert.URIs {
+			names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 6, Bytes: []byte(uri.String())})
+		}
+		for _, id := range ids {
+			value, err := asn1.Marshal(permanentIdentifier{IdentifierValue: id})
+			if err != nil {
+				return err
+			}
+			b, err := asn1.MarshalWithParams(otherName{
+				TypeID: oidPermanentIdentifier,
+				Value:  asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: value},
+			}, "tag:0")
+			if err != nil {
+				return err
+			}
+			names = append(names, asn1.RawValue{FullBytes: b})
+		}
+		value, err := asn1.Marshal(names)
+		if err != nil {
+			return err
+		}
+
+		exts := cert.ExtraExtensions[:0:0]
+		for _, ext := range cert.ExtraExtensions {
+			if !ext.Id.Equal(oidSubjectAltName) {
+				exts = append(exts, ext)
+			}
+		}
+		cert.ExtraExtensions = append(exts, pkix.Extension{
+			Id:       oidSubjectAltName,
+			Critical: len(cert.Subject.ToRDNSequence()) == 0,
+			Value:    value,
+		})
+		return nil
+	}
+}
+
+// checkAttestation verifies that the CSR uses the keys attested in the
+// device-attest-01 challenges of the permanent identifiers of the order.
+func (o *Order) checkAttestation(ctx context.Context, db DB, csr *x509.CertificateRequest) error {
+	if len(o.permanentIdentifiers()) == 0 {
+		return nil
+	}
+
+	var fingerprint string
+	for _, azID := range o.AuthorizationIDs {
+		az, err := db.GetAuthorization(ctx, azID)
+		if err != nil {
+			return WrapErrorISE(err, "error retrieving authorization %s", azID)
+		}
+		if az.Identifier.Type != PermanentIdentifier {
+			continue
+		}
+		if fingerprint == "" {
+			if fingerprint, err = KeyFingerprint(csr.PublicKey); err != nil {
+				return err
+			}
+		}
+
+		var attested bool
+		for _, ch := range az.Challenges {
+			if ch.Type == DEVICEATTEST01 && ch.Status == StatusValid {
+				attested = subtle.ConstantTimeCompare([]byte(ch.Fingerprint), []byte(fingerprint)) == 1
+				break
+			}
+		}
+		if !attested {
+			acmeErr := NewError(ErrorBadCSRType, "CSR key does not match the key attested for %s", az.Identifier.Value)
+			acmeErr.Identifier = az.Identifier
+			return acmeErr
+		}
+	}
+	return nil
+}
+
+// permanentIdentifiers returns the values of the permanent identifiers of
+// the order.
+func (o *Order) permanentIdentifiers() []string {
+	var ids []string
+	for _, id := range o.Identifiers {
+		if id.Type == PermanentIdentifier {
+			ids = append(ids, id.Value)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,629 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"go.step.sm/crypto/jose"
+)
+
+// cborEncode encodes the values used in the attestation objects of the
+// tests.
+func cborEncode(v interface{}) []byte {
+	head := func(major byte, n int) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n < 256:
+			return []byte{major<<5 | 24, byte(n)}
+		default:
+			b := []byte{major<<5 | 25, 0, 0}
+			binary.BigEndian.PutUint16(b[1:], uint16(n))
+			return b
+		}
+	}
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return head(1, -1-v)
+		}
+		return head(0, v)
+	case []byte:
+		return append(head(2, len(v)), v...)
+	case string:
+		return append(head(3, len(v)), v...)
+	case []interface{}:
+		b := head(4, len(v))
+		for _, item := range v {
+			b = append(b, cborEncode(item)...)
+		}
+		return b
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b := head(5, len(v))
+		for _, k := range keys {
+			b = append(b, cborEncode(k)...)
+			b = append(b, cborEncode(v[k])...)
+		}
+		return b
+	default:
+		panic("unsupported type")
+	}
+}
+
+type attestationCA struct {
+	root    *x509.Certificate
+	rootKey crypto.Signer
+	roots   *x509.CertPool
+}
+
+func newAttestationCA(t *testing.T, name string) *attestationCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	assert.FatalError(t, err)
+	root, err := x509.ParseCertificate(der)
+	assert.FatalError(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	return &attestationCA{root: root, rootKey: key, roots: roots}
+}
+
+// leaf returns a new attestation certificate and its key, the template
+// function can modify the certificate template.
+func (ca *attestationCA) leaf(t *testing.T, fn func(*x509.Certificate)) ([]byte, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "Attestation Certificate"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if fn != nil {
+		fn(template)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.root, key.Public(), ca.rootKey)
+	assert.FatalError(t, err)
+	return der, key
+}
+
+func mustASN1(t *testing.T, v interface{}) []byte {
+	b, err := asn1.Marshal(v)
+	assert.FatalError(t, err)
+	return b
+}
+
+func mustSign(t *testing.T, key *ecdsa.PrivateKey, message []byte) []byte {
+	sum := sha256.Sum256(message)
+	sig, err := key.Sign(rand.Reader, sum[:], crypto.SHA256)
+	assert.FatalError(t, err)
+	return sig
+}
+
+func mustFingerprint(t *testing.T, key crypto.PublicKey) string {
+	fp, err := KeyFingerprint(key)
+	assert.FatalError(t, err)
+	return fp
+}
+
+// tpmTestPublic returns the TPMT_PUBLIC structure of an ECC P-256 key with
+// the given object attributes.
+func tpmTestPublic(key *ecdsa.PublicKey, attributes uint32) []byte {
+	var buf bytes.Buffer
+	w := func(v interface{}) { binary.Write(&buf, binary.BigEndian, v) }
+	w(uint16(tpmAlgECC))
+	w(uint16(tpmAlgSHA256))
+	w(attributes)
+	w(uint16(0))          // authPolicy
+	w(uint16(tpmAlgNull)) // symmetric
+	w(uint16(tpmAlgNull)) // scheme
+	w(uint16(tpmECCNistP256))
+	w(uint16(tpmAlgNull)) // kdf
+	pad := func(b []byte) []byte { return append(make([]byte, 32-len(b)), b...) }
+	x, y := pad(key.X.Bytes()), pad(key.Y.Bytes())
+	w(uint16(len(x)))
+	buf.Write(x)
+	w(uint16(len(y)))
+	buf.Write(y)
+	return buf.Bytes()
+}
+
+// tpmTestCertifyInfo returns the TPMS_ATTEST structure that certifies the
+// given public area.
+func tpmTestCertifyInfo(pubArea, extraData []byte) []byte {
+	sum := sha256.Sum256(pubArea)
+	name := append([]byte{0x00, 0x0b}, sum[:]...)
+	var buf bytes.Buffer
+	w := func(v interface{}) { binary.Write(&buf, binary.BigEndian, v) }
+	w(uint32(tpmGeneratedValue))
+	w(uint16(tpmSTAttestCertify))
+	w(uint16(0)) // qualifiedSigner
+	w(uint16(len(extraData)))
+	buf.Write(extraData)
+	buf.Write(make([]byte, 17)) // clockInfo
+	buf.Write(make([]byte, 8))  // firmwareVersion
+	w(uint16(len(name)))
+	buf.Write(name)
+	w(uint16(0)) // qualifiedName
+	return buf.Bytes()
+}
+
+func TestDeviceAttest01Validate(t *testing.T) {
+	jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	assert.FatalError(t, err)
+	keyAuth, err := KeyAuthorization("token", jwk)
+	assert.FatalError(t, err)
+	ca := newAttestationCA(t, "Attestation Root CA")
+	otherCA := newAttestationCA(t, "Other Root CA")
+
+	payload := func(format string, stmt map[string]interface{}) []byte {
+		attObj := cborEncode(map[string]interface{}{
+			"fmt":      format,
+			"attStmt":  stmt,
+			"authData": []byte{},
+		})
+		b, err := json.Marshal(map[string]string{
+			"attObj": base64.RawURLEncoding.EncodeToString(attObj),
+		})
+		assert.FatalError(t, err)
+		return b
+	}
+	yubikeySerial := func(serial int) func(*x509.Certificate) {
+		return func(cert *x509.Certificate) {
+			cert.ExtraExtensions = []pkix.Extension{
+				{Id: oidYubicoSerialNumber, Value: mustASN1(t, serial)},
+			}
+		}
+	}
+	nonce := sha256.Sum256([]byte("token"))
+	appleDevice := func(cert *x509.Certificate) {
+		cert.ExtraExtensions = []pkix.Extension{
+			{Id: oidAppleSerialNumber, Value: []byte("SERIAL123")},
+			{Id: oidAppleUDID, Value: []byte("UDID-0001")},
+			{Id: oidAppleNonce, Value: nonce[:]},
+		}
+	}
+	permanentID := func(id string) func(*x509.Certificate) {
+		return func(cert *x509.Certificate) {
+			assert.FatalError(t, permanentIdentifierSANs([]string{id})(cert))
+		}
+	}
+
+	// aik returns a template function for tpm AIK certificates.
+	aik := func(id string) func(*x509.Certificate) {
+		return func(cert *x509.Certificate) {
+			cert.Subject = pkix.Name{}
+			cert.UnknownExtKeyUsage = []asn1.ObjectIdentifier{oidTCGKpAIKCertificate}
+			permanentID(id)(cert)
+		}
+	}
+	tpmPayload := func(t *testing.T, aikFn func(*x509.Certificate), pubArea, extraData []byte) []byte {
+		aik, aikKey := ca.leaf(t, aikFn)
+		certInfo := tpmTestCertifyInfo(pubArea, extraData)
+		return payload("tpm", map[string]interface{}{
+			"ver":      "2.0",
+			"alg":      -7,
+			"x5c":      []interface{}{aik},
+			"sig":      mustSign(t, aikKey, certInfo),
+			"certInfo": certInfo,
+			"pubArea":  pubArea,
+		})
+	}
+
+	type test struct {
+		value           string
+		payload         []byte
+		vo              *ValidateChallengeOptions
+		jwk             *jose.JSONWebKey
+		wantStatus      Status
+		wantError       *Error
+		wantFingerprint string
+		err             *Error
+	}
+	attestation := func(formats ...provisioner.ACMEAttestationFormat) *ValidateChallengeOptions {
+		return &ValidateChallengeOptions{
+			Attestation: &AttestationOptions{Formats: formats, Roots: ca.roots},
+		}
+	}
+	tests := map[string]func(t *testing.T) test{
+		"fail/not-supported": func(t *testing.T) test {
+			return test{
+				vo:         &ValidateChallengeOptions{},
+				wantStatus: StatusInvalid,
+				wantError:  NewError(ErrorServerInternalType, "device-attest-01 challenges are not supported"),
+			}
+		},
+		"fail/payload": func(t *testing.T) test {
+			return test{
+				payload:    []byte("foo"),
+				vo:         attestation(provisioner.ACMEAttestationStep),
+				wantStatus: StatusInvalid,
+				wantError:  NewError(ErrorMalformedType, "error unmarshaling challenge payload: invalid character 'o' in literal false (expecting 'a')"),
+			}
+		},
+		"fail/missing-attObj": func(t *testing.T) test {
+			return test{
+				payload:    []byte("{}"),
+				vo:         attestation(provisioner.ACMEAttestationStep),
+				wantStatus: StatusInvalid,
+				wantError:  NewError(ErrorMalformedType, "challenge payload is missing the attObj"),
+			}
+		},
+		"fail/attObj-base64": func(t *testing.T) test {
+			return test{
+				payload:    []byte(`{"attObj":"?"}`),
+				vo:         attestation(provisioner.ACMEAttestationStep),
+				wantStatus: StatusInvalid,
+				wantError:  NewError(ErrorMalformedType, "error base64 decoding attObj: illegal base64 data at input byte 0"),
+			}
+		},
+		"fail/attObj-cbor": func(t *testing.T) test {
+			return test{
+				payload:    []byte(`{"attObj":"oQ"}`),
+				vo:         attestation(provisioner.ACMEAttestationStep),
+				wantStatus: StatusInvalid,
+				wantError:  NewError(ErrorBadAttestationStatementType, "error parsing attestation object: cbor: unexpected end of data"),
+			}
+		},
+		"fail/format-not-allowed": func(t *testing.T) test {
+			return test{
+				payload:    payload("tpm", map[string]interface{}{}),
+				vo:         attestation(provisioner.ACMEAttestationStep),
+				wantStatus: StatusInvalid,
+				wantError:  NewError(ErrorBadAttestationStatementType, "attestation format tpm is not allowed"),
+			}
+		},
+		"fail/key-auth-gen-error": func(t *testing.T) test {
+			badJWK, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+			badJWK.Key = "foo"
+			return test{
+				payload: payload("step", map[string]interface{}{}),
+				vo:      attestation(provisioner.ACMEAttestationStep),
+				jwk:     badJWK,
+				err:     NewErrorISE("error generating JWK thumbprint: square/go-jose: unknown key type 'string'"),
+			}
+		},
+		"fail/step-untrusted": func(t *testing.T) test {
+			der, key := otherCA.leaf(t, yubikeySerial(123456))
+			return test{
+				payload: payload("step", map[string]interface{}{
+					"alg": -7,
+					"sig": mustSign(t, key, []byte(keyAuth)),
+					"x5c": []interface{}{der},
+				}),
+				vo:         attestation(provisioner.ACMEAttestationStep),
+				wantStatus: StatusInvalid,
+				wantError: NewError(ErrorBadAttestationStatementType, "error validating step attestation statement: "+
+					"error verifying x5c certificate chain: x509: certificate signed by unknown authority"),
+			}
+		},
+		"fail/step-signature": func(t *testing.T) test {
+			der, key := ca.leaf(t, yubikeySerial(123456))
+			return test{
+				payload: payload("step", map[string]interface{}{
+					"alg": -7,
+					"sig": mustSign(t, key, []byte("foo")),
+					"x5c": []interface{}{der},
+				}),
+				vo:         attestation(provisioner.ACMEAttestationStep),
+				wantStatus: StatusInvalid,
+				wantError:  NewError(ErrorBadAttestationStatementType, "error validating step attestation statement: attestation signature is not valid"),
+			}
+		},
+		"fail/step-identifier": func(t *testing.T) test {
+			der, key := ca.leaf(t, yubikeySerial(654321))
+			return test{
+				payload: payload("step", map[string]interface{}{
+					"alg": -7,
+					"sig": mustSign(t, key, []byte(keyAuth)),
+					"x5c": []interface{}{der},
+				}),
+				vo:         attestation(provisioner.ACMEAttestationStep),
+				wantStatus: StatusInvalid,
+				wantError:  NewError(ErrorRejectedIdentifierType, "permanent identifier does not match; expected 123456, but got 654321"),
+			}
+		},
+		"ok/step": func(t *testing.T) test {
+			der, key := ca.leaf(t, yubikeySerial(123456))
+			return test{
+				payload: payload("step", map[string]interface{}{
+					"alg": -7,
+					"sig": mustSign(t, key, []byte(keyAuth)),
+					"x5c": []interface{}{der},
+				}),
+				vo:              attestation(provisioner.ACMEAttestationStep),
+				wantStatus:      StatusValid,
+				wantFingerprint: mustFingerprint(t, key.Public()),
+			}
+		},
+		"fail/apple-nonce": func(t *testing.T) test {
+			der, _ := ca.leaf(t, func(cert *x509.Certificate) {
+				cert.ExtraExtensions = []pkix.Extension{
+					{Id: oidAppleSerialNumber, Value: []byte("SERIAL123")},
+					{Id: oidAppleNonce, Value: []byte("foo")},
+				}
+			})
+			return test{
+				value:      "SERIAL123",
+				payload:    payload("apple", map[string]interface{}{"x5c": []interface{}{der}}),
+				vo:         attestation(provisioner.ACMEAttestationApple),
+				wantStatus: StatusInvalid,
+				wantError:  NewError(ErrorBadAttestationStatementType, "error validating apple attestation statement: attestation nonce does not match the challenge token"),
+			}
+		},
+		"ok/apple-serial-number": func(t *testing.T) test {
+			der, key := ca.leaf(t, appleDevice)
+			return test{
+				value:           "SERIAL123",
+				payload:         payload("apple", map[string]interface{}{"x5c": []interface{}{der}}),
+				vo:              attestation(provisioner.ACMEAttestationApple),
+				wantStatus:      StatusValid,
+				wantFingerprint: mustFingerprint(t, key.Public()),
+			}
+		},
+		"ok/apple-udid": func(t *testing.T) test {
+			der, key := ca.leaf(t, appleDevice)
+			return test{
+				value:           "UDID-0001",
+				payload:         payload("apple", map[string]interface{}{"x5c": []interface{}{der}}),
+				vo:              attestation(provisioner.ACMEAttestationApple),
+				wantStatus:      StatusValid,
+				wantFingerprint: mustFingerprint(t, key.Public()),
+			}
+		},
+		"fail/packed-identifier": func(t *testing.T) test {
+			der, key := ca.leaf(t, nil)
+			return test{
+				payload: payload("packed", map[string]interface{}{
+					"alg": -7,
+					"sig": mustSign(t, key, []byte(keyAuth)),
+					"x5c": []interface{}{der},
+				}),
+				vo:         attestation(provisioner.ACMEAttestationPacked),
+				wantStatus: StatusInvalid,
+				wantError:  NewError(ErrorBadAttestationStatementType, "error validating packed attestation statement: attestation certificate is missing the permanent identifier"),
+			}
+		},
+		"ok/packed": func(t *testing.T) test {
+			der, key := ca.leaf(t, permanentID("123456"))
+			return test{
+				payload: payload("packed", map[string]interface{}{
+					"alg": -7,
+					"sig": mustSign(t, key, []byte(keyAuth)),
+					"x5c": []interface{}{der},
+				}),
+				vo:              attestation(provisioner.ACMEAttestationPacked),
+				wantStatus:      StatusValid,
+				wantFingerprint: mustFingerprint(t, key.Public()),
+			}
+		},
+		"ok/packed-subject-serial-number": func(t *testing.T) test {
+			der, key := ca.leaf(t, func(cert *x509.Certificate) {
+				cert.Subject.SerialNumber = "123456"
+			})
+			return test{
+				payload: payload("packed", map[string]interface{}{
+					"alg": -7,
+					"sig": mustSign(t, key, []byte(keyAuth)),
+					"x5c": []interface{}{der},
+				}),
+				vo:              attestation(provisioner.ACMEAttestationPacked),
+				wantStatus:      StatusValid,
+				wantFingerprint: mustFingerprint(t, key.Public()),
+			}
+		},
+		"fail/tpm-not-aik": func(t *testing.T) test {
+			key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			assert.FatalError(t, err)
+			sum := sha256.Sum256([]byte(keyAuth))
+			return test{
+				payload:    tpmPayload(t, permanentID("123456"), tpmTestPublic(&key.PublicKey, 0x00040072), sum[:]),
+				vo:         attestation(provisioner.ACMEAttestationTPM),
+				wantStatus: StatusInvalid,
+				wantError:  NewError(ErrorBadAttestationStatementType, "error validating tpm attestation statement: aik certificate subject must be empty"),
+			}
+		},
+		"fail/tpm-aik-eku": func(t *testing.T) test {
+			key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			assert.FatalError(t, err)
+			sum := sha256.Sum256([]byte(keyAuth))
+			noEKU := func(cert *x509.Certificate) {
+				aik("123456")(cert)
+				cert.UnknownExtKeyUsage = nil
+			}
+			return test{
+				payload:    tpmPayload(t, noEKU, tpmTestPublic(&key.PublicKey, 0x00040072), sum[:]),
+				vo:         attestation(provisioner.ACMEAttestationTPM),
+				wantStatus: StatusInvalid,
+				wantError:  NewError(ErrorBadAttestationStatementType, "error validating tpm attestation statement: aik certificate is missing the tcg-kp-AIKCertificate extended key usage"),
+			}
+		},
+		"fail/tpm-attributes": func(t *testing.T) test {
+			key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			assert.FatalError(t, err)
+			sum := sha256.Sum256([]byte(keyAuth))
+			// Key without fixedTPM, it could have been imported.
+			return test{
+				payload:    tpmPayload(t, aik("123456"), tpmTestPublic(&key.PublicKey, 0x00040070), sum[:]),
+				vo:         attestation(provisioner.ACMEAttestationTPM),
+				wantStatus: StatusInvalid,
+				wantError:  NewError(ErrorBadAttestationStatementType, "error validating tpm attestation statement: tpm key must have the fixedTPM, fixedParent and sensitiveDataOrigin attributes"),
+			}
+		},
+		"fail/tpm-extra-data": func(t *testing.T) test {
+			key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			assert.FatalError(t, err)
+			return test{
+				payload:    tpmPayload(t, aik("123456"), tpmTestPublic(&key.PublicKey, 0x00040072), []byte("foo")),
+				vo:         attestation(provisioner.ACMEAttestationTPM),
+				wantStatus: StatusInvalid,
+				wantError:  NewError(ErrorBadAttestationStatementType, "error validating tpm attestation statement: certInfo extraData does not match the key authorization"),
+			}
+		},
+		"ok/tpm": func(t *testing.T) test {
+			key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			assert.FatalError(t, err)
+			sum := sha256.Sum256([]byte(keyAuth))
+			return test{
+				payload:         tpmPayload(t, aik("123456"), tpmTestPublic(&key.PublicKey, 0x00040072), sum[:]),
+				vo:              attestation(provisioner.ACMEAttestationTPM),
+				wantStatus:      StatusValid,
+				wantFingerprint: mustFingerprint(t, key.Public()),
+			}
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			if tc.value == "" {
+				tc.value = "123456"
+			}
+			ch := &Challenge{
+				ID:      "chID",
+				Type:    DEVICEATTEST01,
+				Status:  StatusProcessing,
+				Value:   tc.value,
+				Token:   "token",
+				Payload: tc.payload,
+			}
+			if tc.jwk == nil {
+				tc.jwk = jwk
+			}
+			var updated bool
+			db := &MockDB{
+				MockUpdateChallenge: func(ctx context.Context, updch *Challenge) error {
+					updated = true
+					return nil
+				},
+			}
+			if err := deviceAttest01Validate(context.Background(), ch, db, tc.jwk, tc.vo); err != nil {
+				if assert.NotNil(t, tc.err) {
+					switch k := err.(type) {
+					case *Error:
+						assert.Equals(t, k.Type, tc.err.Type)
+						assert.Equals(t, k.Detail, tc.err.Detail)
+						assert.Equals(t, k.Status, tc.err.Status)
+						assert.Equals(t, k.Err.Error(), tc.err.Err.Error())
+					default:
+						assert.FatalError(t, errors.New("unexpected error type"))
+					}
+				}
+				return
+			}
+			if assert.Nil(t, tc.err) {
+				assert.True(t, updated)
+				assert.Equals(t, ch.Status, tc.wantStatus)
+				assert.Equals(t, ch.Fingerprint, tc.wantFingerprint)
+				if tc.wantError == nil {
+					assert.Nil(t, ch.Error)
+					assert.NotEquals(t, ch.ValidatedAt, "")
+				} else if assert.NotNil(t, ch.Error) {
+					assert.Equals(t, ch.Error.Type, tc.wantError.Type)
+					assert.Equals(t, ch.Error.Detail, tc.wantError.Detail)
+					assert.Equals(t, ch.Error.Err.Error(), tc.wantError.Err.Error())
+				}
+			}
+		})
+	}
+}
+
+func Test_permanentIdentifierSANs(t *testing.T) {
+	ca := newAttestationCA(t, "Attestation Root CA")
+	der, _ := ca.leaf(t, func(cert *x509.Certificate) {
+		cert.DNSNames = []string{"device.example.com"}
+		cert.IPAddresses = []net.IP{net.ParseIP("10.0.0.1")}
+		cert.ExtraExtensions = []pkix.Extension{{Id: oidSubjectAltName, Value: []byte("foo")}}
+		assert.FatalError(t, permanentIdentifierSANs([]string{"123456"})(cert))
+		assert.Len(t, 1, cert.ExtraExtensions)
+	})
+	cert, err := x509.ParseCertificate(der)
+	assert.FatalError(t, err)
+	assert.Equals(t, cert.DNSNames, []string{"device.example.com"})
+	assert.True(t, cert.IPAddresses[0].Equal(net.ParseIP("10.0.0.1")))
+	id, err := certificatePermanentIdentifier(cert)
+	assert.FatalError(t, err)
+	assert.Equals(t, id, "123456")
+}
+
+func TestOrder_checkAttestation(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	csr := &x509.CertificateRequest{PublicKey: key.Public()}
+	fingerprint := mustFingerprint(t, key.Public())
+
+	authz := func(fp string) *Authorization {
+		return &Authorization{
+			ID:         "azID",
+			Identifier: Identifier{Type: PermanentIdentifier, Value: "123456"},
+			Challenges: []*Challenge{
+				{Type: DEVICEATTEST01, Status: StatusValid, Fingerprint: fp},
+			},
+		}
+	}
+	tests := map[string]struct {
+		az  *Authorization
+		err *Error
+	}{
+		"ok":     {authz(fingerprint), nil},
+		"ok/dns": {&Authorization{ID: "azID", Identifier: Identifier{Type: DNS, Value: "example.com"}}, nil},
+		"fail/fingerprint": {authz("foo"),
+			NewError(ErrorBadCSRType, "CSR key does not match the key attested for 123456")},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			o := &Order{AuthorizationIDs: []string{"azID"}, Identifiers: []Identifier{tc.az.Identifier}}
+			db := &MockDB{
+				MockGetAuthorization: func(ctx context.Context, id string) (*Authorization, error) {
+					return tc.az, nil
+				},
+			}
+			err := o.checkAttestation(context.Background(), db, csr)
+			if tc.err == nil {
+				assert.FatalError(t, err)
+				return
+			}
+			if assert.Error(t, err) {
+				k, ok := err.(*Error)
+				if assert.True(t, ok) {
+					assert.Equals(t, k.Type, tc.err.Type)
+					assert.Equals(t, k.Err.Error(), tc.err.Err.Error())
+					assert.Equals(t, k.Identifier, tc.az.Identifier)
+				}
+			}
+		})
+	}
+}
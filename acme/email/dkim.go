@@ -0,0 +1,310 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type lookupTXTFunc func(ctx context.Context, name string) ([]string, error)
+
+var (
+	dkimWhitespace = regexp.MustCompile(`[ \t]+`)
+	dkimSignature  = regexp.MustCompile(`(^|;)([ \t\r\n]*b[ \t\r\n]*=)[^;]*`)
+)
+
+// verifyDKIM verifies that a raw message has a valid DKIM signature (RFC
+// 6376) of the domain of the from address, or of one of its parent domains.
+// The signature must cover the From header and the whole body, and it must
+// use rsa-sha256 or ed25519-sha256 (RFC 8463).
+func verifyDKIM(ctx context.Context, lookupTXT lookupTXTFunc, raw []byte, from string) error {
+	i := strings.LastIndex(from, "@")
+	if i < 0 {
+		return errors.Errorf("invalid address %s", from)
+	}
+	domain := strings.ToLower(from[i+1:])
+
+	headers, body := splitMessage(raw)
+	var lastErr error = errors.New("message does not have a DKIM signature")
+	for _, field := range headers {
+		if !strings.EqualFold(headerName(field), "DKIM-Signature") {
+			continue
+		}
+		err := verifyDKIMSignature(ctx, lookupTXT, headers, body, field, domain)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+	}
+	return lastErr
+}
+
+func verifyDKIMSignature(ctx context.Context, lookupTXT lookupTXTFunc, headers []string, body []byte, field, domain string) error {
+	tags, err := parseTags(headerValue(field))
+	if err != nil {
+		return errors.Wrap(err, "error parsing DKIM signature")
+	}
+	for _, name := range []string{"v", "a", "b", "bh", "d", "h", "s"} {
+		if tags[name] == "" {
+			return errors.Errorf("DKIM signature is missing the %s tag", name)
+		}
+	}
+	switch {
+	case tags["v"] != "1":
+		return errors.Errorf("unsupported DKIM version %s", tags["v"])
+	case tags["l"] != "":
+		return errors.New("DKIM signatures with a body length are not supported")
+	}
+
+	// The signing domain must be the domain of the address or a parent.
+	d := strings.ToLower(strings.TrimSuffix(tags["d"], "."))
+	if d != domain && !strings.HasSuffix(domain, "."+d) {
+		return errors.Errorf("DKIM signature domain %s does not match %s", d, domain)
+	}
+	signed := strings.Split(tags["h"], ":")
+	var fromSigned bool
+	for i := range signed {
+		signed[i] = strings.TrimSpace(signed[i])
+		fromSigned = fromSigned || strings.EqualFold(signed[i], "From")
+	}
+	if !fromSigned {
+		return errors.New("DKIM signature does not cover the From header")
+	}
+	if x := tags["x"]; x != "" {
+		exp, err := strconv.ParseInt(x, 10, 64)
+		if err != nil || time.Now().Unix() > exp {
+			return errors.New("DKIM signature has expired")
+		}
+	}
+
+	headerCanon, bodyCanon := "simple", "simple"
+	if c := tags["c"]; c != "" {
+		parts := strings.SplitN(c, "/", 2)
+		headerCanon = parts[0]
+		if len(parts) == 2 {
+			bodyCanon = parts[1]
+		}
+	}
+	for _, c := range []string{headerCanon, bodyCanon} {
+		if c != "simple" && c != "relaxed" {
+			return errors.Errorf("unsupported DKIM canonicalization %s", tags["c"])
+		}
+	}
+
+	// Body hash
+	bh := sha256.Sum256(canonicalBody(body, bodyCanon))
+	if base64.StdEncoding.EncodeToString(bh[:]) != tags["bh"] {
+		return errors.New("DKIM body hash does not match")
+	}
+
+	// Signed headers, each name selects the last unused instance of the
+	// header, and the signature header without the signature value.
+	h := sha256.New()
+	used := make(map[int]bool)
+	for _, name := range signed {
+		for i := len(headers) - 1; i >= 0; i-- {
+			if !used[i] && strings.EqualFold(headerName(headers[i]), name) {
+				used[i] = true
+				h.Write([]byte(canonicalHeader(headers[i], headerCanon)))
+				break
+			}
+		}
+	}
+	i := strings.Index(field, ":")
+	unsigned := field[:i+1] + dkimSignature.ReplaceAllString(field[i+1:], "$1$2")
+	h.Write([]byte(strings.TrimSuffix(canonicalHeader(unsigned, headerCanon), "\r\n")))
+	digest := h.Sum(nil)
+
+	sig, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return errors.Wrap(err, "error decoding DKIM signature")
+	}
+	key, err := lookupDKIMKey(ctx, lookupTXT, tags["s"]+"._domainkey."+d)
+	if err != nil {
+		return err
+	}
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if tags["a"] != "rsa-sha256" {
+			return errors.Errorf("DKIM algorithm %s does not match the rsa key", tags["a"])
+		}
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, digest, sig); err != nil {
+			return errors.Wrap(err, "error verifying DKIM signature")
+		}
+	case ed25519.PublicKey:
+		if tags["a"] != "ed25519-sha256" {
+			return errors.Errorf("DKIM algorithm %s does not match the ed25519 key", tags["a"])
+		}
+		if !ed25519.Verify(k, digest, sig) {
+			return errors.New("error verifying DKIM signature")
+		}
+	}
+	return nil
+}
+
+// lookupDKIMKey returns the public key published in the DKIM record with the
+// given name.
+func lookupDKIMKey(ctx context.Context, lookupTXT lookupTXTFunc, name string) (crypto.PublicKey, error) {
+	txts, err := lookupTXT(ctx, name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error looking up DKIM key %s", name)
+	}
+	if len(txts) == 0 {
+		return nil, errors.Errorf("DKIM key %s not found", name)
+	}
+	tags, err := parseTags(strings.Join(txts, ""))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing DKIM key %s", name)
+	}
+	if v := tags["v"]; v != "" && v != "DKIM1" {
+		return nil, errors.Errorf("unsupported DKIM key version %s", v)
+	}
+	if tags["p"] == "" {
+		return nil, errors.Errorf("DKIM key %s has been revoked", name)
+	}
+	b, err := base64.StdEncoding.DecodeString(tags["p"])
+	if err != nil {
+		return nil, errors.Wrapf(err, "error decoding DKIM key %s", name)
+	}
+
+	switch k := tags["k"]; k {
+	case "", "rsa":
+		pub, err := x509.ParsePKIXPublicKey(b)
+		if err != nil {
+			if pub, err = x509.ParsePKCS1PublicKey(b); err != nil {
+				return nil, errors.Wrapf(err, "error parsing DKIM key %s", name)
+			}
+		}
+		rsaKey, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return nil, errors.Errorf("DKIM key %s is not an rsa key", name)
+		}
+		return rsaKey, nil
+	case "ed25519":
+		if len(b) != ed25519.PublicKeySize {
+			return nil, errors.Errorf("DKIM key %s is not a valid ed25519 key", name)
+		}
+		return ed25519.PublicKey(b), nil
+	default:
+		return nil, errors.Errorf("unsupported DKIM key type %s", k)
+	}
+}
+
+// parseTags parses a DKIM tag list, the folding whitespace of the values is
+// removed.
+func parseTags(s string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, spec := range strings.Split(s, ";") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		i := strings.Index(spec, "=")
+		if i < 0 {
+			return nil, errors.Errorf("invalid tag %s", spec)
+		}
+		name := strings.TrimSpace(spec[:i])
+		if _, ok := tags[name]; ok {
+			return nil, errors.Errorf("duplicated tag %s", name)
+		}
+		tags[name] = strings.Map(func(r rune) rune {
+			if r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+				return -1
+			}
+			return r
+		}, spec[i+1:])
+	}
+	return tags, nil
+}
+
+// splitMessage returns the raw header fields of a message, with their CRLF,
+// and its body. Bare line feeds are converted to CRLF.
+func splitMessage(raw []byte) ([]string, []byte) {
+	raw = bytes.ReplaceAll(raw, []byte("\r\n"), []byte("\n"))
+	raw = bytes.ReplaceAll(raw, []byte("\n"), []byte("\r\n"))
+
+	var body []byte
+	header := string(raw)
+	if i := strings.Index(header, "\r\n\r\n"); i >= 0 {
+		header, body = header[:i+2], raw[i+4:]
+	}
+	var fields []string
+	for _, line := range strings.SplitAfter(header, "\r\n") {
+		switch {
+		case line == "":
+		case (line[0] == ' ' || line[0] == '\t') && len(fields) > 0:
+			fields[len(fields)-1] += line
+		default:
+			fields = append(fields, line)
+		}
+	}
+	return fields, body
+}
+
+func headerName(field string) string {
+	if i := strings.Index(field, ":"); i >= 0 {
+		return strings.TrimRight(field[:i], " \t")
+	}
+	return ""
+}
+
+func headerValue(field string) string {
+	if i := strings.Index(field, ":"); i >= 0 {
+		return field[i+1:]
+	}
+	return ""
+}
+
+// canonicalHeader returns a header field using the given canonicalization
+// algorithm.
+func canonicalHeader(field, canon string) string {
+	if canon == "simple" {
+		return field
+	}
+	value := strings.ReplaceAll(headerValue(field), "\r\n", "")
+	value = strings.TrimSpace(dkimWhitespace.ReplaceAllString(value, " "))
+	return strings.ToLower(headerName(field)) + ":" + value + "\r\n"
+}
+
+// canonicalBody returns a message body using the given canonicalization
+// algorithm.
+func canonicalBody(body []byte, canon string) []byte {
+	if canon == "relaxed" {
+		lines := bytes.SplitAfter(body, []byte("\r\n"))
+		for i, line := range lines {
+			crlf := bytes.HasSuffix(line, []byte("\r\n"))
+			line = dkimWhitespace.ReplaceAll(bytes.TrimSuffix(line, []byte("\r\n")), []byte(" "))
+			line = bytes.TrimRight(line, " ")
+			if crlf {
+				line = append(line, '\r', '\n')
+			}
+			lines[i] = line
+		}
+		body = bytes.Join(lines, nil)
+	}
+	for bytes.HasSuffix(body, []byte("\r\n\r\n")) {
+		body = body[:len(body)-2]
+	}
+	if bytes.Equal(body, []byte("\r\n")) {
+		body = nil
+	}
+	switch {
+	case len(body) == 0 && canon == "simple":
+		return []byte("\r\n")
+	case len(body) == 0, body[len(body)-1] == '\n':
+		return body
+	default:
+		return append(body, '\r', '\n')
+	}
+}
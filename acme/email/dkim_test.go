@@ -0,0 +1,144 @@
+package email
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/smallstep/assert"
+)
+
+// signDKIM returns the message with a DKIM-Signature header added with the
+// given tags, the bh and b tags are computed.
+func signDKIM(t *testing.T, key crypto.Signer, msg, tags string) string {
+	t.Helper()
+	c := "simple/simple"
+	if i := strings.Index(tags, "c="); i >= 0 {
+		c = strings.SplitN(tags[i+2:], ";", 2)[0]
+	}
+	canon := strings.SplitN(c, "/", 2)
+	headers, body := splitMessage([]byte(msg))
+	bh := sha256.Sum256(canonicalBody(body, canon[1]))
+	field := "DKIM-Signature: " + tags + "; bh=" + base64.StdEncoding.EncodeToString(bh[:]) + "; b=\r\n"
+
+	h := sha256.New()
+	tagMap, err := parseTags(tags)
+	assert.FatalError(t, err)
+	used := make(map[int]bool)
+	for _, name := range strings.Split(tagMap["h"], ":") {
+		for i := len(headers) - 1; i >= 0; i-- {
+			if !used[i] && strings.EqualFold(headerName(headers[i]), name) {
+				used[i] = true
+				h.Write([]byte(canonicalHeader(headers[i], canon[0])))
+				break
+			}
+		}
+	}
+	h.Write([]byte(strings.TrimSuffix(canonicalHeader(field, canon[0]), "\r\n")))
+
+	var sig []byte
+	if _, ok := key.(ed25519.PrivateKey); ok {
+		sig, err = key.Sign(rand.Reader, h.Sum(nil), crypto.Hash(0))
+	} else {
+		sig, err = key.Sign(rand.Reader, h.Sum(nil), crypto.SHA256)
+	}
+	assert.FatalError(t, err)
+	return strings.TrimSuffix(field, "\r\n") + base64.StdEncoding.EncodeToString(sig) + "\r\n" + msg
+}
+
+func mustDKIMRecord(t *testing.T, pub crypto.PublicKey) string {
+	t.Helper()
+	if k, ok := pub.(ed25519.PublicKey); ok {
+		return "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(k)
+	}
+	b, err := x509.MarshalPKIXPublicKey(pub)
+	assert.FatalError(t, err)
+	return "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(b)
+}
+
+func Test_canonicalization(t *testing.T) {
+	// Example of RFC 6376, section 3.4.5.
+	headers, body := splitMessage([]byte("A: X\r\nB : Y\t\r\n\tZ  \r\n\r\n C \r\nD \t E\r\n\r\n\r\n"))
+	assert.Equals(t, headers, []string{"A: X\r\n", "B : Y\t\r\n\tZ  \r\n"})
+	assert.Equals(t, canonicalHeader(headers[0], "relaxed"), "a:X\r\n")
+	assert.Equals(t, canonicalHeader(headers[1], "relaxed"), "b:Y Z\r\n")
+	assert.Equals(t, canonicalHeader(headers[1], "simple"), "B : Y\t\r\n\tZ  \r\n")
+	assert.Equals(t, string(canonicalBody(body, "relaxed")), " C\r\nD E\r\n")
+	assert.Equals(t, string(canonicalBody(body, "simple")), " C \r\nD \t E\r\n")
+
+	assert.Equals(t, string(canonicalBody(nil, "simple")), "\r\n")
+	assert.Equals(t, string(canonicalBody([]byte("\r\n\r\n"), "simple")), "\r\n")
+	assert.Equals(t, string(canonicalBody(nil, "relaxed")), "")
+	assert.Equals(t, string(canonicalBody([]byte("\r\n"), "relaxed")), "")
+	assert.Equals(t, string(canonicalBody([]byte("foo  "), "relaxed")), "foo\r\n")
+}
+
+func Test_verifyDKIM(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.FatalError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.FatalError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.FatalError(t, err)
+
+	records := map[string]string{
+		"rsa._domainkey.example.com":     mustDKIMRecord(t, rsaKey.Public()),
+		"ed._domainkey.example.com":      mustDKIMRecord(t, edKey.Public()),
+		"revoked._domainkey.example.com": "v=DKIM1; k=rsa; p=",
+	}
+	lookupTXT := func(ctx context.Context, name string) ([]string, error) {
+		if r, ok := records[name]; ok {
+			// Long records are split in multiple strings.
+			return []string{r[:10], r[10:]}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+
+	msg := "From: Jane <jane@example.com>\r\nTo: acme@ca.example.com\r\nSubject: Re: ACME: token\r\n\r\nbody\r\n"
+	tests := []struct {
+		name string
+		msg  string
+		from string
+		err  string
+	}{
+		{"ok/rsa", signDKIM(t, rsaKey, msg, "v=1; a=rsa-sha256; d=example.com; s=rsa; c=relaxed/relaxed; h=From:To:Subject"), "jane@example.com", ""},
+		{"ok/ed25519", signDKIM(t, edKey, msg, "v=1; a=ed25519-sha256; d=example.com; s=ed; h=from:subject"), "jane@example.com", ""},
+		{"ok/lf", strings.ReplaceAll(signDKIM(t, rsaKey, msg, "v=1; a=rsa-sha256; d=example.com; s=rsa; c=relaxed/simple; h=From"), "\r\n", "\n"), "jane@example.com", ""},
+		{"ok/subdomain", signDKIM(t, rsaKey, strings.Replace(msg, "example.com", "mail.example.com", 1),
+			"v=1; a=rsa-sha256; d=example.com; s=rsa; h=From"), "jane@mail.example.com", ""},
+		{"ok/other-signature", signDKIM(t, otherKey, signDKIM(t, rsaKey, msg, "v=1; a=rsa-sha256; d=example.com; s=rsa; h=From"),
+			"v=1; a=rsa-sha256; d=example.com; s=rsa; h=From"), "jane@example.com", ""},
+		{"fail/unsigned", msg, "jane@example.com", "message does not have a DKIM signature"},
+		{"fail/domain", signDKIM(t, rsaKey, msg, "v=1; a=rsa-sha256; d=example.com; s=rsa; h=From"), "jane@example.org", "DKIM signature domain example.com does not match example.org"},
+		{"fail/parent-domain", signDKIM(t, rsaKey, msg, "v=1; a=rsa-sha256; d=mail.example.com; s=rsa; h=From"), "jane@example.com", "DKIM signature domain mail.example.com does not match example.com"},
+		{"fail/from-not-signed", signDKIM(t, rsaKey, msg, "v=1; a=rsa-sha256; d=example.com; s=rsa; h=Subject"), "jane@example.com", "DKIM signature does not cover the From header"},
+		{"fail/length", signDKIM(t, rsaKey, msg, "v=1; a=rsa-sha256; d=example.com; s=rsa; l=4; h=From"), "jane@example.com", "DKIM signatures with a body length are not supported"},
+		{"fail/missing-tag", signDKIM(t, rsaKey, msg, "v=1; a=rsa-sha256; s=rsa; h=From"), "jane@example.com", "DKIM signature is missing the d tag"},
+		{"fail/body", strings.Replace(signDKIM(t, rsaKey, msg, "v=1; a=rsa-sha256; d=example.com; s=rsa; h=From"), "body", "other", 1), "jane@example.com", "DKIM body hash does not match"},
+		{"fail/header", strings.Replace(signDKIM(t, rsaKey, msg, "v=1; a=rsa-sha256; d=example.com; s=rsa; h=From:Subject"), "token", "other", 1), "jane@example.com", "error verifying DKIM signature"},
+		{"fail/added-from", strings.Replace(signDKIM(t, rsaKey, msg, "v=1; a=rsa-sha256; d=example.com; s=rsa; h=From"), "To:", "From: mallory@example.com\r\nTo:", 1), "jane@example.com", "error verifying DKIM signature"},
+		{"fail/wrong-key", signDKIM(t, otherKey, msg, "v=1; a=rsa-sha256; d=example.com; s=rsa; h=From"), "jane@example.com", "error verifying DKIM signature"},
+		{"fail/algorithm", signDKIM(t, rsaKey, msg, "v=1; a=ed25519-sha256; d=example.com; s=rsa; h=From"), "jane@example.com", "DKIM algorithm ed25519-sha256 does not match the rsa key"},
+		{"fail/lookup", signDKIM(t, rsaKey, msg, "v=1; a=rsa-sha256; d=example.com; s=missing; h=From"), "jane@example.com", "error looking up DKIM key missing._domainkey.example.com: no such host"},
+		{"fail/revoked", signDKIM(t, rsaKey, msg, "v=1; a=rsa-sha256; d=example.com; s=revoked; h=From"), "jane@example.com", "DKIM key revoked._domainkey.example.com has been revoked"},
+		{"fail/expired", signDKIM(t, rsaKey, msg, "v=1; a=rsa-sha256; d=example.com; s=rsa; x=1000; h=From"), "jane@example.com", "DKIM signature has expired"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyDKIM(context.Background(), lookupTXT, []byte(tt.msg), tt.from)
+			if tt.err == "" {
+				assert.FatalError(t, err)
+			} else if assert.Error(t, err) {
+				assert.HasPrefix(t, err.Error(), tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+// Package email implements the transport used by the ACME email-reply-00
+// challenges. The challenge emails are sent through an SMTP relay, and the
+// replies are read from an IMAP mailbox or received by an inbound SMTP
+// listener. Only the replies with a valid DKIM signature of the domain of the
+// sender are accepted.
+package email
+
+import (
+	"context"
+	"net"
+	"net/mail"
+	"net/smtp"
+
+	"github.com/pkg/errors"
+)
+
+// Config is the configuration of the email transport.
+type Config struct {
+	// From is the address the challenge emails are sent from and the replies
+	// are sent to.
+	From string `json:"from"`
+	// SMTP is the relay used to send the challenge emails.
+	SMTP *SMTPConfig `json:"smtp"`
+	// IMAP is the mailbox the replies are read from.
+	IMAP *IMAPConfig `json:"imap,omitempty"`
+	// Listen is the address of an SMTP listener that receives the replies
+	// sent to the From address.
+	Listen string `json:"listen,omitempty"`
+}
+
+// SMTPConfig is the configuration of the SMTP relay.
+type SMTPConfig struct {
+	Address  string `json:"address"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// IMAPConfig is the configuration of the IMAP mailbox, the connection always
+// uses TLS.
+type IMAPConfig struct {
+	Address  string `json:"address"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Mailbox  string `json:"mailbox,omitempty"`
+}
+
+// Validate validates the email configuration.
+func (c *Config) Validate() error {
+	switch {
+	case c == nil:
+		return nil
+	case c.From == "":
+		return errors.New("acmeEmail.from cannot be empty")
+	case c.SMTP == nil || c.SMTP.Address == "":
+		return errors.New("acmeEmail.smtp.address cannot be empty")
+	case c.IMAP == nil && c.Listen == "":
+		return errors.New("acmeEmail requires an imap mailbox or a listen address")
+	case c.IMAP != nil && c.Listen != "":
+		return errors.New("acmeEmail imap and listen cannot be used together")
+	case c.IMAP != nil && c.IMAP.Address == "":
+		return errors.New("acmeEmail.imap.address cannot be empty")
+	}
+	if addr, err := mail.ParseAddress(c.From); err != nil || addr.Address != c.From {
+		return errors.Errorf("acmeEmail.from %s is not a valid email address", c.From)
+	}
+	if _, _, err := net.SplitHostPort(c.SMTP.Address); err != nil {
+		return errors.Wrapf(err, "acmeEmail.smtp.address %s is not valid", c.SMTP.Address)
+	}
+	if c.IMAP != nil {
+		if _, _, err := net.SplitHostPort(c.IMAP.Address); err != nil {
+			return errors.Wrapf(err, "acmeEmail.imap.address %s is not valid", c.IMAP.Address)
+		}
+	}
+	return nil
+}
+
+type sendFunc func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+
+// Transport sends the challenge emails and reads the replies. It implements
+// the acme.EmailTransport interface.
+type Transport struct {
+	config    *Config
+	send      sendFunc
+	imapDial  dialFunc
+	lookupTXT lookupTXTFunc
+	server    *server
+}
+
+// New creates a new transport with the given configuration. If the
+// configuration uses a listen address, the SMTP listener is started, and it
+// will be stopped when the transport is closed.
+func New(c *Config) (*Transport, error) {
+	if c == nil {
+		return nil, errors.New("acmeEmail cannot be empty")
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	t := &Transport{
+		config:    c,
+		send:      smtp.SendMail,
+		imapDial:  dialTLS,
+		lookupTXT: net.DefaultResolver.LookupTXT,
+	}
+	if c.Listen != "" {
+		s, err := listen(c.Listen, c.From)
+		if err != nil {
+			return nil, err
+		}
+		t.server = s
+	}
+	return t, nil
+}
+
+// From returns the address the challenge emails are sent from.
+func (t *Transport) From() string {
+	return t.config.From
+}
+
+// Send sends the raw message to the given address using the SMTP relay.
+func (t *Transport) Send(ctx context.Context, to string, msg []byte) error {
+	var auth smtp.Auth
+	if c := t.config.SMTP; c.Username != "" {
+		host, _, _ := net.SplitHostPort(c.Address)
+		auth = smtp.PlainAuth("", c.Username, c.Password, host)
+	}
+	if err := t.send(t.config.SMTP.Address, auth, t.config.From, []string{to}, msg); err != nil {
+		return errors.Wrapf(err, "error sending email to %s", to)
+	}
+	return nil
+}
+
+// Replies returns the raw messages received from the given address. The From
+// header can be set by anyone, so only the messages with a valid DKIM
+// signature of the domain of the address are returned.
+func (t *Transport) Replies(ctx context.Context, from string) ([][]byte, error) {
+	var messages [][]byte
+	if t.server != nil {
+		messages = t.server.messagesFrom(from)
+	} else {
+		var err error
+		if messages, err = fetchIMAP(ctx, t.imapDial, t.config.IMAP, from); err != nil {
+			return nil, err
+		}
+	}
+
+	var replies [][]byte
+	for _, msg := range messages {
+		if err := verifyDKIM(ctx, t.lookupTXT, msg, from); err == nil {
+			replies = append(replies, msg)
+		}
+	}
+	return replies, nil
+}
+
+// Close stops the SMTP listener if this is the last transport using it.
+func (t *Transport) Close() error {
+	if t.server != nil {
+		s := t.server
+		t.server = nil
+		return s.release()
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"net/smtp"
+	"testing"
+
+	"github.com/smallstep/assert"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	smtpConfig := &SMTPConfig{Address: "smtp.example.com:587"}
+	imapConfig := &IMAPConfig{Address: "imap.example.com:993", Username: "acme", Password: "pass"}
+	tests := map[string]struct {
+		config *Config
+		err    error
+	}{
+		"ok/nil":    {nil, nil},
+		"ok/imap":   {&Config{From: "acme@example.com", SMTP: smtpConfig, IMAP: imapConfig}, nil},
+		"ok/listen": {&Config{From: "acme@example.com", SMTP: smtpConfig, Listen: ":2525"}, nil},
+		"fail/from": {&Config{SMTP: smtpConfig, Listen: ":2525"},
+			errors.New("acmeEmail.from cannot be empty")},
+		"fail/from-address": {&Config{From: "ACME <acme@example.com>", SMTP: smtpConfig, Listen: ":2525"},
+			errors.New("acmeEmail.from ACME <acme@example.com> is not a valid email address")},
+		"fail/smtp": {&Config{From: "acme@example.com", Listen: ":2525"},
+			errors.New("acmeEmail.smtp.address cannot be empty")},
+		"fail/smtp-address": {&Config{From: "acme@example.com", SMTP: &SMTPConfig{Address: "smtp.example.com"}, Listen: ":2525"},
+			errors.New("acmeEmail.smtp.address smtp.example.com is not valid")},
+		"fail/no-replies": {&Config{From: "acme@example.com", SMTP: smtpConfig},
+			errors.New("acmeEmail requires an imap mailbox or a listen address")},
+		"fail/imap-and-listen": {&Config{From: "acme@example.com", SMTP: smtpConfig, IMAP: imapConfig, Listen: ":2525"},
+			errors.New("acmeEmail imap and listen cannot be used together")},
+		"fail/imap": {&Config{From: "acme@example.com", SMTP: smtpConfig, IMAP: &IMAPConfig{}},
+			errors.New("acmeEmail.imap.address cannot be empty")},
+		"fail/imap-address": {&Config{From: "acme@example.com", SMTP: smtpConfig, IMAP: &IMAPConfig{Address: "imap.example.com"}},
+			errors.New("acmeEmail.imap.address imap.example.com is not valid")},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.config.Validate()
+			if tc.err == nil {
+				assert.FatalError(t, err)
+			} else if assert.Error(t, err) {
+				assert.HasPrefix(t, err.Error(), tc.err.Error())
+			}
+		})
+	}
+}
+
+func TestTransport_Send(t *testing.T) {
+	_, err := New(nil)
+	assert.Error(t, err)
+
+	tr, err := New(&Config{
+		From: "acme@example.com",
+		SMTP: &SMTPConfig{Address: "smtp.example.com:587", Username: "acme", Password: "pass"},
+		IMAP: &IMAPConfig{Address: "imap.example.com:993"},
+	})
+	assert.FatalError(t, err)
+	assert.Equals(t, tr.From(), "acme@example.com")
+
+	var sent bool
+	tr.send = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		sent = true
+		assert.Equals(t, addr, "smtp.example.com:587")
+		assert.NotNil(t, a)
+		assert.Equals(t, from, "acme@example.com")
+		assert.Equals(t, to, []string{"jane@example.com"})
+		assert.Equals(t, msg, []byte("message"))
+		return nil
+	}
+	assert.FatalError(t, tr.Send(context.Background(), "jane@example.com", []byte("message")))
+	assert.True(t, sent)
+
+	tr.send = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		return errors.New("force")
+	}
+	err = tr.Send(context.Background(), "jane@example.com", []byte("message"))
+	if assert.Error(t, err) {
+		assert.Equals(t, err.Error(), "error sending email to jane@example.com: force")
+	}
+	assert.FatalError(t, tr.Close())
+}
@@ -0,0 +1,193 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// maxIMAPMessages is the maximum number of messages read from the mailbox,
+// the most recent messages are read.
+const maxIMAPMessages = 20
+
+type dialFunc func(ctx context.Context, addr string) (net.Conn, error)
+
+func dialTLS(ctx context.Context, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	d := &net.Dialer{Timeout: 30 * time.Second}
+	if deadline, ok := ctx.Deadline(); ok {
+		d.Deadline = deadline
+	}
+	return tls.DialWithDialer(d, "tcp", addr, &tls.Config{
+		ServerName: host,
+		MinVersion: tls.VersionTLS12,
+	})
+}
+
+// imapResponse is an untagged response with the literals in it.
+type imapResponse struct {
+	line     string
+	literals [][]byte
+}
+
+// imapClient is a minimal IMAP4rev1 client that supports the commands needed
+// to read the replies to the challenge emails.
+type imapClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+	tag  int
+}
+
+// fetchIMAP returns the most recent messages of the mailbox sent from the
+// given address. The messages are not marked as seen.
+func fetchIMAP(ctx context.Context, dial dialFunc, c *IMAPConfig, from string) ([][]byte, error) {
+	conn, err := dial(ctx, c.Address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error connecting to %s", c.Address)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(time.Minute))
+	}
+
+	client := &imapClient{conn: conn, r: bufio.NewReader(conn)}
+	if line, err := client.readLine(); err != nil {
+		return nil, err
+	} else if !strings.HasPrefix(line, "* OK") && !strings.HasPrefix(line, "* PREAUTH") {
+		return nil, errors.Errorf("unexpected imap greeting: %s", line)
+	}
+
+	mailbox := c.Mailbox
+	if mailbox == "" {
+		mailbox = "INBOX"
+	}
+	if _, err := client.cmd("LOGIN %s %s", imapQuote(c.Username), imapQuote(c.Password)); err != nil {
+		return nil, err
+	}
+	if _, err := client.cmd("SELECT %s", imapQuote(mailbox)); err != nil {
+		return nil, err
+	}
+	resps, err := client.cmd("UID SEARCH FROM %s", imapQuote(from))
+	if err != nil {
+		return nil, err
+	}
+
+	var uids []string
+	for _, r := range resps {
+		if strings.HasPrefix(r.line, "* SEARCH") {
+			uids = append(uids, strings.Fields(strings.TrimPrefix(r.line, "* SEARCH"))...)
+		}
+	}
+	if len(uids) > maxIMAPMessages {
+		uids = uids[len(uids)-maxIMAPMessages:]
+	}
+
+	var messages [][]byte
+	if len(uids) > 0 {
+		if resps, err = client.cmd("UID FETCH %s BODY.PEEK[]", strings.Join(uids, ",")); err != nil {
+			return nil, err
+		}
+		for _, r := range resps {
+			if strings.Contains(r.line, "FETCH") && len(r.literals) > 0 {
+				messages = append(messages, r.literals[0])
+			}
+		}
+	}
+
+	// Ignore logout errors, the messages have been already read.
+	_, _ = client.cmd("LOGOUT")
+	return messages, nil
+}
+
+// cmd sends a command and returns the untagged responses, it fails if the
+// tagged response is not OK.
+func (c *imapClient) cmd(format string, args ...interface{}) ([]imapResponse, error) {
+	c.tag++
+	tag := "a" + strconv.Itoa(c.tag)
+	cmd := fmt.Sprintf(format, args...)
+	if _, err := fmt.Fprintf(c.conn, "%s %s\r\n", tag, cmd); err != nil {
+		return nil, errors.Wrap(err, "error writing imap command")
+	}
+
+	name := strings.Fields(cmd)[0]
+	var resps []imapResponse
+	for {
+		r, err := c.readResponse()
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(r.line, tag+" ") {
+			status := strings.TrimPrefix(r.line, tag+" ")
+			if !strings.HasPrefix(status, "OK") {
+				return nil, errors.Errorf("imap %s failed: %s", name, status)
+			}
+			return resps, nil
+		}
+		resps = append(resps, r)
+	}
+}
+
+// readResponse reads a response line and the literals in it.
+func (c *imapClient) readResponse() (imapResponse, error) {
+	var r imapResponse
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return r, err
+		}
+		n, ok := literalSize(line)
+		if !ok {
+			r.line += line
+			return r, nil
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			return r, errors.Wrap(err, "error reading imap literal")
+		}
+		r.line += line
+		r.literals = append(r.literals, b)
+	}
+}
+
+func (c *imapClient) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", errors.Wrap(err, "error reading imap response")
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// literalSize returns the size of the literal at the end of the line.
+func literalSize(line string) (int, bool) {
+	if !strings.HasSuffix(line, "}") {
+		return 0, false
+	}
+	i := strings.LastIndex(line, "{")
+	if i < 0 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(line[i+1 : len(line)-1])
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+func imapQuote(s string) string {
+	s = strings.NewReplacer("\r", "", "\n", "").Replace(s)
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+	return `"` + s + `"`
+}
@@ -0,0 +1,112 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/smallstep/assert"
+)
+
+// fakeIMAPServer answers the commands sent by the client with the responses
+// in the map, indexed by command name.
+func fakeIMAPServer(responses map[string]string) dialFunc {
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		client, srv := net.Pipe()
+		go func() {
+			defer srv.Close()
+			fmt.Fprint(srv, "* OK IMAP4rev1 ready\r\n")
+			r := bufio.NewReader(srv)
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				parts := strings.SplitN(strings.TrimSpace(line), " ", 3)
+				tag, name := parts[0], parts[1]
+				if name == "UID" {
+					name += " " + strings.Fields(parts[2])[0]
+				}
+				resp, ok := responses[name]
+				if !ok {
+					resp = "TAG OK done\r\n"
+				}
+				if _, err := fmt.Fprint(srv, strings.ReplaceAll(resp, "TAG", tag)); err != nil {
+					return
+				}
+				if name == "LOGOUT" {
+					return
+				}
+			}
+		}()
+		return client, nil
+	}
+}
+
+func Test_fetchIMAP(t *testing.T) {
+	msg1 := "From: jane@example.com\r\nSubject: Re: ACME: token\r\n\r\nbody\r\n"
+	msg2 := "From: jane@example.com\r\nSubject: hello\r\n\r\n"
+	config := &IMAPConfig{Address: "imap.example.com:993", Username: "acme", Password: `pa"ss`}
+
+	tests := map[string]struct {
+		dial dialFunc
+		want [][]byte
+		err  error
+	}{
+		"fail/dial": {
+			dial: func(ctx context.Context, addr string) (net.Conn, error) {
+				return nil, errors.New("force")
+			},
+			err: errors.New("error connecting to imap.example.com:993: force"),
+		},
+		"fail/login": {
+			dial: fakeIMAPServer(map[string]string{"LOGIN": "TAG NO invalid credentials\r\n"}),
+			err:  errors.New("imap LOGIN failed: NO invalid credentials"),
+		},
+		"ok/empty": {
+			dial: fakeIMAPServer(map[string]string{"UID SEARCH": "* SEARCH\r\nTAG OK done\r\n"}),
+		},
+		"ok": {
+			dial: fakeIMAPServer(map[string]string{
+				"UID SEARCH": "* SEARCH 3 7\r\nTAG OK done\r\n",
+				"UID FETCH": fmt.Sprintf("* 1 FETCH (UID 3 BODY[] {%d}\r\n%s)\r\n* 2 FETCH (UID 7 BODY[] {%d}\r\n%s)\r\nTAG OK done\r\n",
+					len(msg1), msg1, len(msg2), msg2),
+				"LOGOUT": "* BYE\r\nTAG OK done\r\n",
+			}),
+			want: [][]byte{[]byte(msg1), []byte(msg2)},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := fetchIMAP(context.Background(), tc.dial, config, "jane@example.com")
+			if tc.err != nil {
+				if assert.Error(t, err) {
+					assert.Equals(t, err.Error(), tc.err.Error())
+				}
+				return
+			}
+			assert.FatalError(t, err)
+			assert.Equals(t, got, tc.want)
+		})
+	}
+}
+
+func Test_imapQuote(t *testing.T) {
+	assert.Equals(t, imapQuote("INBOX"), `"INBOX"`)
+	assert.Equals(t, imapQuote(`a"b\c`), `"a\"b\\c"`)
+	assert.Equals(t, imapQuote("a\r\nb"), `"ab"`)
+}
+
+func Test_literalSize(t *testing.T) {
+	n, ok := literalSize("* 1 FETCH (BODY[] {42}")
+	assert.True(t, ok)
+	assert.Equals(t, n, 42)
+	_, ok = literalSize("* 1 FETCH (BODY[] {foo}")
+	assert.False(t, ok)
+	_, ok = literalSize("* OK done")
+	assert.False(t, ok)
+}
@@ -0,0 +1,244 @@
+package email
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxMessageSize is the maximum size of a received message.
+	maxMessageSize = 1 << 20
+	// maxMessages is the maximum number of messages kept in memory, the
+	// oldest messages are discarded first.
+	maxMessages = 1000
+	// messageLifetime is the time a received message is kept in memory.
+	messageLifetime = time.Hour
+)
+
+var (
+	serversMutex sync.Mutex
+	servers      = make(map[string]*server)
+)
+
+type message struct {
+	from       string
+	data       []byte
+	receivedAt time.Time
+}
+
+// server is an SMTP listener that only accepts messages for one address. The
+// servers are shared by address so a new transport can be created with the
+// same configuration before the old one is closed.
+type server struct {
+	addr     string
+	rcpt     string
+	listener net.Listener
+	refs     int
+	mu       sync.Mutex
+	messages []message
+}
+
+// listen starts, or reuses, the SMTP listener for the given address.
+func listen(addr, rcpt string) (*server, error) {
+	serversMutex.Lock()
+	defer serversMutex.Unlock()
+
+	if s, ok := servers[addr]; ok {
+		if !strings.EqualFold(s.rcpt, rcpt) {
+			return nil, errors.Errorf("smtp listener on %s is already used by %s", addr, s.rcpt)
+		}
+		s.refs++
+		return s, nil
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error listening on %s", addr)
+	}
+	s := &server{addr: addr, rcpt: rcpt, listener: ln, refs: 1}
+	servers[addr] = s
+	go s.serve()
+	return s, nil
+}
+
+// release closes the listener if it is not used by any other transport.
+func (s *server) release() error {
+	serversMutex.Lock()
+	defer serversMutex.Unlock()
+	s.refs--
+	if s.refs > 0 {
+		return nil
+	}
+	delete(servers, s.addr)
+	return s.listener.Close()
+}
+
+func (s *server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+// handle implements the minimum set of SMTP commands required to receive a
+// message.
+func (s *server) handle(conn net.Conn) {
+	defer conn.Close()
+	c := textproto.NewConn(conn)
+	reply := func(code int, msg string) bool {
+		conn.SetWriteDeadline(time.Now().Add(time.Minute))
+		return c.PrintfLine("%d %s", code, msg) == nil
+	}
+
+	var from string
+	var rcpt bool
+	if !reply(220, "step-ca ACME ESMTP") {
+		return
+	}
+	for {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Minute))
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i > 0 {
+			verb, arg = line[:i], strings.TrimSpace(line[i+1:])
+		}
+
+		var ok bool
+		switch strings.ToUpper(verb) {
+		case "HELO", "EHLO":
+			ok = reply(250, "step-ca")
+		case "MAIL":
+			from, rcpt = smtpPath(arg, "FROM:"), false
+			ok = reply(250, "OK")
+		case "RCPT":
+			if strings.EqualFold(smtpPath(arg, "TO:"), s.rcpt) {
+				rcpt = true
+				ok = reply(250, "OK")
+			} else {
+				ok = reply(550, "mailbox unavailable")
+			}
+		case "DATA":
+			if !rcpt {
+				ok = reply(503, "bad sequence of commands")
+				break
+			}
+			if !reply(354, "end data with <CR><LF>.<CR><LF>") {
+				return
+			}
+			dr := c.DotReader()
+			data, err := ioutil.ReadAll(io.LimitReader(dr, maxMessageSize+1))
+			if err != nil {
+				return
+			}
+			if len(data) > maxMessageSize {
+				// Discard the rest of the message.
+				if _, err := io.Copy(ioutil.Discard, dr); err != nil {
+					return
+				}
+				ok = reply(552, "message size exceeds the limit")
+			} else {
+				s.store(from, data)
+				ok = reply(250, "OK")
+			}
+			from, rcpt = "", false
+		case "RSET":
+			from, rcpt = "", false
+			ok = reply(250, "OK")
+		case "NOOP":
+			ok = reply(250, "OK")
+		case "QUIT":
+			reply(221, "bye")
+			return
+		default:
+			ok = reply(502, "command not implemented")
+		}
+		if !ok {
+			return
+		}
+	}
+}
+
+func (s *server) store(from string, data []byte) {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		log.Printf("error parsing acme email reply from %s: %v", from, err)
+		return
+	}
+	if addr, err := mail.ParseAddress(msg.Header.Get("From")); err == nil {
+		from = addr.Address
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+	if len(s.messages) >= maxMessages {
+		s.messages = s.messages[1:]
+	}
+	s.messages = append(s.messages, message{
+		from:       from,
+		data:       data,
+		receivedAt: time.Now(),
+	})
+}
+
+// messagesFrom returns the messages received from the given address.
+func (s *server) messagesFrom(from string) [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+	var messages [][]byte
+	for _, m := range s.messages {
+		if strings.EqualFold(m.from, from) {
+			messages = append(messages, m.data)
+		}
+	}
+	return messages
+}
+
+// expire removes the expired messages, it must be called with the lock held.
+func (s *server) expire() {
+	limit := time.Now().Add(-messageLifetime)
+	i := 0
+	for i < len(s.messages) && s.messages[i].receivedAt.Before(limit) {
+		i++
+	}
+	s.messages = s.messages[i:]
+}
+
+// smtpPath returns the address of a MAIL or RCPT argument, removing the
+// prefix, the angle brackets and the parameters.
+func smtpPath(arg, prefix string) string {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return ""
+	}
+	arg = strings.TrimSpace(arg[len(prefix):])
+	if strings.HasPrefix(arg, "<") {
+		if i := strings.IndexByte(arg, '>'); i > 0 {
+			return arg[1:i]
+		}
+	}
+	if i := strings.IndexByte(arg, ' '); i > 0 {
+		return arg[:i]
+	}
+	return arg
+}
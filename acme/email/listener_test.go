@@ -0,0 +1,68 @@
+package email
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/smtp"
+	"strings"
+	"testing"
+
+	"github.com/smallstep/assert"
+)
+
+func TestTransport_listener(t *testing.T) {
+	config := &Config{
+		From:   "acme@ca.example.com",
+		SMTP:   &SMTPConfig{Address: "smtp.example.com:25"},
+		Listen: "127.0.0.1:0",
+	}
+	tr1, err := New(config)
+	assert.FatalError(t, err)
+	tr2, err := New(config)
+	assert.FatalError(t, err)
+	assert.Equals(t, tr1.server, tr2.server)
+
+	_, err = New(&Config{From: "other@ca.example.com", SMTP: config.SMTP, Listen: config.Listen})
+	if assert.Error(t, err) {
+		assert.Equals(t, err.Error(), "smtp listener on 127.0.0.1:0 is already used by acme@ca.example.com")
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.FatalError(t, err)
+	tr2.lookupTXT = func(ctx context.Context, name string) ([]string, error) {
+		assert.Equals(t, name, "dkim._domainkey.example.com")
+		return []string{mustDKIMRecord(t, key.Public())}, nil
+	}
+
+	addr := tr1.server.listener.Addr().String()
+	unsigned := "From: Jane <jane@example.com>\r\nTo: acme@ca.example.com\r\nSubject: Re: ACME: token\r\n\r\nbody\r\n"
+	signed := signDKIM(t, key, unsigned, "v=1; a=rsa-sha256; d=example.com; s=dkim; h=From:Subject")
+	assert.FatalError(t, smtp.SendMail(addr, nil, "bounces@example.com", []string{"acme@ca.example.com"}, []byte(signed)))
+	assert.FatalError(t, smtp.SendMail(addr, nil, "bounces@example.com", []string{"acme@ca.example.com"}, []byte(unsigned)))
+	assert.Error(t, smtp.SendMail(addr, nil, "jane@example.com", []string{"other@ca.example.com"}, []byte(signed)))
+
+	// Only the signed message is returned.
+	replies, err := tr2.Replies(context.Background(), "JANE@example.com")
+	assert.FatalError(t, err)
+	if assert.Len(t, 1, replies) {
+		assert.Equals(t, string(replies[0]), strings.ReplaceAll(signed, "\r\n", "\n"))
+	}
+	replies, err = tr2.Replies(context.Background(), "bounces@example.com")
+	assert.FatalError(t, err)
+	assert.Len(t, 0, replies)
+
+	// The listener is closed with the last transport.
+	assert.FatalError(t, tr1.Close())
+	assert.FatalError(t, tr1.Close())
+	assert.FatalError(t, smtp.SendMail(addr, nil, "jane@example.com", []string{"acme@ca.example.com"}, []byte(signed)))
+	assert.FatalError(t, tr2.Close())
+	assert.Error(t, smtp.SendMail(addr, nil, "jane@example.com", []string{"acme@ca.example.com"}, []byte(signed)))
+}
+
+func Test_smtpPath(t *testing.T) {
+	assert.Equals(t, smtpPath("FROM:<jane@example.com>", "FROM:"), "jane@example.com")
+	assert.Equals(t, smtpPath("from: <jane@example.com> SIZE=100", "FROM:"), "jane@example.com")
+	assert.Equals(t, smtpPath("TO:jane@example.com SIZE=100", "TO:"), "jane@example.com")
+	assert.Equals(t, smtpPath("jane@example.com", "TO:"), "")
+}
@@ -0,0 +1,239 @@
+package acme
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"time"
+
+	"go.step.sm/crypto/jose"
+	"go.step.sm/crypto/randutil"
+)
+
+const (
+	emailResponseBegin = "-----BEGIN ACME RESPONSE-----"
+	emailResponseEnd   = "-----END ACME RESPONSE-----"
+)
+
+// EmailTransport is the interface used to send the email-reply-00 challenge
+// emails and to read the replies.
+type EmailTransport interface {
+	// From returns the address the challenge emails are sent from.
+	From() string
+	// Send sends a raw email message to the given address.
+	Send(ctx context.Context, to string, msg []byte) error
+	// Replies returns the raw email messages received from the given
+	// address. The sender of the messages must be authenticated, the
+	// challenge trusts their From header.
+	Replies(ctx context.Context, from string) ([][]byte, error)
+}
+
+// NewEmailTokenPart1 returns a new random token-part1 of an email-reply-00
+// challenge.
+func NewEmailTokenPart1() (string, error) {
+	s, err := randutil.Alphanumeric(32)
+	if err != nil {
+		return "", WrapErrorISE(err, "error generating random token")
+	}
+	return s, nil
+}
+
+// EmailChallengeMessage returns the challenge email of an email-reply-00
+// challenge as described in RFC 8823, section 3.1.
+func EmailChallengeMessage(ch *Challenge, messageID string) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", ch.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", ch.Value)
+	fmt.Fprintf(&buf, "Subject: ACME: %s\r\n", ch.TokenPart1)
+	fmt.Fprintf(&buf, "Date: %s\r\n", clock.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s>\r\n", messageID)
+	buf.WriteString("Auto-Submitted: auto-generated; type=acme\r\n")
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString("This is an automatically generated ACME challenge for the email address\r\n")
+	fmt.Fprintf(&buf, "%s. If you did not request an S/MIME certificate for this address,\r\n", ch.Value)
+	buf.WriteString("please ignore this message.\r\n")
+	return buf.Bytes()
+}
+
+// SendEmailChallenge sends the challenge email of an email-reply-00 challenge
+// using the given transport.
+func SendEmailChallenge(ctx context.Context, et EmailTransport, ch *Challenge) error {
+	id, err := randutil.Alphanumeric(24)
+	if err != nil {
+		return WrapErrorISE(err, "error generating message id")
+	}
+	domain := ch.From
+	if i := strings.LastIndex(domain, "@"); i >= 0 {
+		domain = domain[i+1:]
+	}
+	if err := et.Send(ctx, ch.Value, EmailChallengeMessage(ch, id+"@"+domain)); err != nil {
+		return WrapErrorISE(err, "error sending challenge email to %s", ch.Value)
+	}
+	return nil
+}
+
+func emailReply00Validate(ctx context.Context, ch *Challenge, db DB, jwk *jose.JSONWebKey, vo *ValidateChallengeOptions) error {
+	if vo.EmailReplies == nil {
+		return storeError(ctx, db, ch, true, NewError(ErrorServerInternalType,
+			"email-reply-00 challenges are not supported"))
+	}
+
+	replies, err := vo.EmailReplies(ctx, ch.Value)
+	if err != nil {
+		return storeError(ctx, db, ch, false, WrapError(ErrorConnectionType, err,
+			"error reading email replies from %s", ch.Value))
+	}
+
+	expectedKeyAuth, err := KeyAuthorization(ch.TokenPart1+ch.Token, jwk)
+	if err != nil {
+		return err
+	}
+	h := sha256.Sum256([]byte(expectedKeyAuth))
+	expected := base64.RawURLEncoding.EncodeToString(h[:])
+	subject := "ACME: " + ch.TokenPart1
+
+	var answered bool
+	for _, b := range replies {
+		msg, err := mail.ReadMessage(bytes.NewReader(b))
+		if err != nil {
+			continue
+		}
+		from, err := mail.ParseAddress(msg.Header.Get("From"))
+		if err != nil || !strings.EqualFold(from.Address, ch.Value) {
+			continue
+		}
+		if !strings.Contains(decodeHeader(msg.Header.Get("Subject")), subject) {
+			continue
+		}
+		answered = true
+		body, err := emailText(msg.Header, msg.Body)
+		if err != nil {
+			continue
+		}
+		if emailResponse(body) == expected {
+			// Update and store the challenge.
+			ch.Status = StatusValid
+			ch.Error = nil
+			ch.ValidatedAt = clock.Now().Format(time.RFC3339)
+
+			if err = db.UpdateChallenge(ctx, ch); err != nil {
+				return WrapErrorISE(err, "error updating challenge")
+			}
+			return nil
+		}
+	}
+
+	if answered {
+		return storeError(ctx, db, ch, true, NewError(ErrorIncorrectResponseType,
+			"keyAuthorization does not match; expected response %s", expected))
+	}
+	return storeError(ctx, db, ch, false, NewError(ErrorIncorrectResponseType,
+		"no reply to the challenge email has been received from %s", ch.Value))
+}
+
+func decodeHeader(s string) string {
+	dec := new(mime.WordDecoder)
+	if v, err := dec.DecodeHeader(s); err == nil {
+		return v
+	}
+	return s
+}
+
+// emailText returns the decoded text of a message body, for multipart messages
+// it returns the concatenation of the text parts.
+func emailText(header mail.Header, body io.Reader) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		var sb strings.Builder
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := mr.NextPart()
+			if err == io.EOF {
+				return sb.String(), nil
+			}
+			if err != nil {
+				return "", err
+			}
+			if ct := p.Header.Get("Content-Type"); ct != "" && !strings.HasPrefix(ct, "text/plain") {
+				continue
+			}
+			b, err := ioutil.ReadAll(decodeTransfer(p.Header.Get("Content-Transfer-Encoding"), p))
+			if err != nil {
+				return "", err
+			}
+			sb.Write(b)
+			sb.WriteString("\n")
+		}
+	}
+
+	b, err := ioutil.ReadAll(decodeTransfer(header.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func decodeTransfer(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &newlineStripper{r: r})
+	default:
+		return r
+	}
+}
+
+// emailResponse returns the content of the ACME response block of the given
+// text. Quoted lines of the reply are accepted.
+func emailResponse(text string) string {
+	var (
+		inside   bool
+		response string
+	)
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimLeft(scanner.Text(), "> \t"))
+		switch {
+		case line == emailResponseBegin:
+			inside, response = true, ""
+		case line == emailResponseEnd && inside:
+			return response
+		case inside:
+			response += line
+		}
+	}
+	return ""
+}
+
+type newlineStripper struct {
+	r io.Reader
+}
+
+func (n *newlineStripper) Read(p []byte) (int, error) {
+	c, err := n.r.Read(p)
+	j := 0
+	for _, b := range p[:c] {
+		if b != '\r' && b != '\n' {
+			p[j] = b
+			j++
+		}
+	}
+	return j, err
+}
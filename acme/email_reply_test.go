@@ -0,0 +1,242 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/smallstep/assert"
+	"go.step.sm/crypto/jose"
+)
+
+type mockEmailTransport struct {
+	from     string
+	to       string
+	msg      []byte
+	err      error
+	messages [][]byte
+}
+
+func (m *mockEmailTransport) From() string { return m.from }
+
+func (m *mockEmailTransport) Send(ctx context.Context, to string, msg []byte) error {
+	m.to, m.msg = to, msg
+	return m.err
+}
+
+func (m *mockEmailTransport) Replies(ctx context.Context, from string) ([][]byte, error) {
+	return m.messages, m.err
+}
+
+func TestSendEmailChallenge(t *testing.T) {
+	ch := &Challenge{
+		Type:       EMAILREPLY00,
+		Value:      "jane@example.com",
+		From:       "acme@ca.example.com",
+		Token:      "part2",
+		TokenPart1: "part1",
+	}
+
+	et := &mockEmailTransport{from: ch.From}
+	assert.FatalError(t, SendEmailChallenge(context.Background(), et, ch))
+	assert.Equals(t, et.to, "jane@example.com")
+
+	msg, err := mail.ReadMessage(bytes.NewReader(et.msg))
+	assert.FatalError(t, err)
+	assert.Equals(t, msg.Header.Get("From"), "acme@ca.example.com")
+	assert.Equals(t, msg.Header.Get("To"), "jane@example.com")
+	assert.Equals(t, msg.Header.Get("Subject"), "ACME: part1")
+	assert.Equals(t, msg.Header.Get("Auto-Submitted"), "auto-generated; type=acme")
+	assert.True(t, strings.HasSuffix(msg.Header.Get("Message-ID"), "@ca.example.com>"))
+
+	et.err = errors.New("force")
+	err = SendEmailChallenge(context.Background(), et, ch)
+	if assert.Error(t, err) {
+		k, ok := err.(*Error)
+		if assert.True(t, ok) {
+			assert.Equals(t, k.Type, NewErrorISE("").Type)
+			assert.Equals(t, k.Err.Error(), "error sending challenge email to jane@example.com: force")
+		}
+	}
+}
+
+func TestEmailReply00Validate(t *testing.T) {
+	jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	assert.FatalError(t, err)
+	keyAuth, err := KeyAuthorization("part1part2", jwk)
+	assert.FatalError(t, err)
+	sum := sha256.Sum256([]byte(keyAuth))
+	response := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	reply := func(from, subject, headers, body string) []byte {
+		return []byte(fmt.Sprintf("From: %s\r\nTo: acme@ca.example.com\r\nSubject: %s\r\n%s\r\n%s", from, subject, headers, body))
+	}
+	block := "-----BEGIN ACME RESPONSE-----\r\n" + response + "\r\n-----END ACME RESPONSE-----\r\n"
+
+	type test struct {
+		vo         *ValidateChallengeOptions
+		jwk        *jose.JSONWebKey
+		wantStatus Status
+		wantError  *Error
+		err        *Error
+	}
+	tests := map[string]func(t *testing.T) test{
+		"fail/not-supported": func(t *testing.T) test {
+			return test{
+				vo:         &ValidateChallengeOptions{},
+				wantStatus: StatusInvalid,
+				wantError:  NewError(ErrorServerInternalType, "email-reply-00 challenges are not supported"),
+			}
+		},
+		"fail/key-auth-gen-error": func(t *testing.T) test {
+			badJWK, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+			badJWK.Key = "foo"
+			return test{
+				vo: &ValidateChallengeOptions{
+					EmailReplies: func(ctx context.Context, from string) ([][]byte, error) {
+						return nil, nil
+					},
+				},
+				jwk: badJWK,
+				err: NewErrorISE("error generating JWK thumbprint: square/go-jose: unknown key type 'string'"),
+			}
+		},
+		"ok/replies-error": func(t *testing.T) test {
+			return test{
+				vo: &ValidateChallengeOptions{
+					EmailReplies: func(ctx context.Context, from string) ([][]byte, error) {
+						return nil, errors.New("force")
+					},
+				},
+				wantStatus: StatusPending,
+				wantError:  NewError(ErrorConnectionType, "error reading email replies from jane@example.com: force"),
+			}
+		},
+		"ok/no-reply": func(t *testing.T) test {
+			return test{
+				vo: &ValidateChallengeOptions{
+					EmailReplies: func(ctx context.Context, from string) ([][]byte, error) {
+						return [][]byte{
+							[]byte("not an email"),
+							reply("john@example.com", "Re: ACME: part1", "", block),
+							reply("jane@example.com", "Re: ACME: other", "", block),
+						}, nil
+					},
+				},
+				wantStatus: StatusPending,
+				wantError:  NewError(ErrorIncorrectResponseType, "no reply to the challenge email has been received from jane@example.com"),
+			}
+		},
+		"fail/incorrect-response": func(t *testing.T) test {
+			return test{
+				vo: &ValidateChallengeOptions{
+					EmailReplies: func(ctx context.Context, from string) ([][]byte, error) {
+						return [][]byte{
+							reply("jane@example.com", "Re: ACME: part1", "", "-----BEGIN ACME RESPONSE-----\r\nfoo\r\n-----END ACME RESPONSE-----\r\n"),
+						}, nil
+					},
+				},
+				wantStatus: StatusInvalid,
+				wantError:  NewError(ErrorIncorrectResponseType, "keyAuthorization does not match; expected response %s", response),
+			}
+		},
+		"ok/plain": func(t *testing.T) test {
+			return test{
+				vo: &ValidateChallengeOptions{
+					EmailReplies: func(ctx context.Context, from string) ([][]byte, error) {
+						assert.Equals(t, from, "jane@example.com")
+						return [][]byte{
+							reply("Jane <JANE@example.com>", "Re: ACME: part1", "", "> quoted text\r\n"+block),
+						}, nil
+					},
+				},
+				wantStatus: StatusValid,
+			}
+		},
+		"ok/base64": func(t *testing.T) test {
+			return test{
+				vo: &ValidateChallengeOptions{
+					EmailReplies: func(ctx context.Context, from string) ([][]byte, error) {
+						return [][]byte{
+							reply("jane@example.com", "Re: ACME: part1", "Content-Transfer-Encoding: base64\r\n",
+								base64.StdEncoding.EncodeToString([]byte(block))),
+						}, nil
+					},
+				},
+				wantStatus: StatusValid,
+			}
+		},
+		"ok/multipart": func(t *testing.T) test {
+			body := "--b1\r\nContent-Type: text/html\r\n\r\n<p>hello</p>\r\n" +
+				"--b1\r\nContent-Type: text/plain; charset=UTF-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n" + block +
+				"--b1--\r\n"
+			return test{
+				vo: &ValidateChallengeOptions{
+					EmailReplies: func(ctx context.Context, from string) ([][]byte, error) {
+						return [][]byte{
+							reply("jane@example.com", "=?UTF-8?Q?Re:_ACME:_part1?=", "MIME-Version: 1.0\r\nContent-Type: multipart/alternative; boundary=b1\r\n", body),
+						}, nil
+					},
+				},
+				wantStatus: StatusValid,
+			}
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			ch := &Challenge{
+				ID:         "chID",
+				Type:       EMAILREPLY00,
+				Status:     StatusPending,
+				Value:      "jane@example.com",
+				From:       "acme@ca.example.com",
+				Token:      "part2",
+				TokenPart1: "part1",
+			}
+			if tc.jwk == nil {
+				tc.jwk = jwk
+			}
+			var updated bool
+			db := &MockDB{
+				MockUpdateChallenge: func(ctx context.Context, updch *Challenge) error {
+					updated = true
+					return nil
+				},
+			}
+			if err := emailReply00Validate(context.Background(), ch, db, tc.jwk, tc.vo); err != nil {
+				if assert.NotNil(t, tc.err) {
+					switch k := err.(type) {
+					case *Error:
+						assert.Equals(t, k.Type, tc.err.Type)
+						assert.Equals(t, k.Detail, tc.err.Detail)
+						assert.Equals(t, k.Status, tc.err.Status)
+						assert.Equals(t, k.Err.Error(), tc.err.Err.Error())
+					default:
+						assert.FatalError(t, errors.New("unexpected error type"))
+					}
+				}
+				return
+			}
+			if assert.Nil(t, tc.err) {
+				assert.True(t, updated)
+				assert.Equals(t, ch.Status, tc.wantStatus)
+				if tc.wantError == nil {
+					assert.Nil(t, ch.Error)
+					assert.NotEquals(t, ch.ValidatedAt, "")
+				} else if assert.NotNil(t, ch.Error) {
+					assert.Equals(t, ch.Error.Type, tc.wantError.Type)
+					assert.Equals(t, ch.Error.Detail, tc.wantError.Detail)
+					assert.Equals(t, ch.Error.Err.Error(), tc.wantError.Err.Error())
+				}
+			}
+		})
+	}
+}
@@ -4,8 +4,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/certificates/errs"
@@ -66,6 +69,8 @@ const (
 	ErrorUserActionRequiredType
 	// ErrorNotImplementedType operation is not implemented
 	ErrorNotImplementedType
+	// ErrorBadAttestationStatementType attestation statement cannot be verified
+	ErrorBadAttestationStatementType
 )
 
 // String returns the string representation of the acme problem type,
@@ -120,6 +125,8 @@ func (ap ProblemType) String() string {
 		return "userActionRequired"
 	case ErrorNotImplementedType:
 		return "notImplemented"
+	case ErrorBadAttestationStatementType:
+		return "badAttestationStatement"
 	default:
 		return fmt.Sprintf("unsupported type ACME error type '%d'", int(ap))
 	}
@@ -260,6 +267,11 @@ var (
 			details: "Visit the “instance” URL and take actions specified there",
 			status:  400,
 		},
+		ErrorBadAttestationStatementType: {
+			typ:     officialACMEPrefix + ErrorBadAttestationStatementType.String(),
+			details: "The attestation statement cannot be verified",
+			status:  400,
+		},
 		ErrorServerInternalType: errorServerInternalMetadata,
 	}
 )
@@ -272,6 +284,9 @@ type Error struct {
 	Identifier  interface{}   `json:"identifier,omitempty"`
 	Err         error         `json:"-"`
 	Status      int           `json:"-"`
+	// RetryAfter, if set, is the time clients should wait before retrying
+	// the request, it is sent in the Retry-After header.
+	RetryAfter time.Duration `json:"-"`
 }
 
 // NewError creates a new Error type.
@@ -356,6 +371,9 @@ func (e *Error) ToLog() (interface{}, error) {
 // WriteError writes to w a JSON representation of the given error.
 func WriteError(w http.ResponseWriter, err *Error) {
 	w.Header().Set("Content-Type", "application/problem+json")
+	if err.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(err.RetryAfter.Seconds()))))
+	}
 	w.WriteHeader(err.StatusCode())
 
 	// Write errors in the response writer
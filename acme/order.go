@@ -19,8 +19,26 @@ type IdentifierType string
 const (
 	IP  IdentifierType = "ip"
 	DNS IdentifierType = "dns"
+	// Email is the identifier type of email addresses, see RFC 8823.
+	Email IdentifierType = "email"
+	// PermanentIdentifier is the identifier type of devices, it is validated
+	// with an attestation of the device.
+	PermanentIdentifier IdentifierType = "permanent-identifier"
 )
 
+// DefaultSMIMETemplate is the template used by default for orders with only
+// email identifiers, it is ignored if the provisioner defines a template.
+const DefaultSMIMETemplate = `{
+	"subject": {{ toJson .Subject }},
+	"sans": {{ toJson .SANs }},
+{{- if typeIs "*rsa.PublicKey" .Insecure.CR.PublicKey }}
+	"keyUsage": ["keyEncipherment", "digitalSignature"],
+{{- else }}
+	"keyUsage": ["digitalSignature"],
+{{- end }}
+	"extKeyUsage": ["emailProtection"]
+}`
+
 // Identifier encodes the type that an order pertains to.
 type Identifier struct {
 	Type  IdentifierType `json:"type"`
@@ -37,6 +55,7 @@ type Order struct {
 	Identifiers       []Identifier `json:"identifiers"`
 	NotBefore         time.Time    `json:"notBefore"`
 	NotAfter          time.Time    `json:"notAfter"`
+	Profile           string       `json:"profile,omitempty"`
 	Error             *Error       `json:"error,omitempty"`
 	AuthorizationIDs  []string     `json:"-"`
 	AuthorizationURLs []string     `json:"authorizations"`
@@ -121,8 +140,9 @@ func (o *Order) UpdateStatus(ctx context.Context, db DB) error {
 }
 
 // Finalize signs a certificate if the necessary conditions for Order completion
-// have been met.
-func (o *Order) Finalize(ctx context.Context, db DB, csr *x509.CertificateRequest, auth CertificateAuthority, p Provisioner) error {
+// have been met. If caa is not nil, the CAA records of the DNS identifiers must
+// authorize the issuance.
+func (o *Order) Finalize(ctx context.Context, db DB, csr *x509.CertificateRequest, auth CertificateAuthority, p Provisioner, caa *CAAOptions) error {
 	if err := o.UpdateStatus(ctx, db); err != nil {
 		return err
 	}
@@ -140,8 +160,18 @@ func (o *Order) Finalize(ctx context.Context, db DB, csr *x509.CertificateReques
 		return NewErrorISE("unexpected status %s for order %s", o.Status, o.ID)
 	}
 
-	// canonicalize the CSR to allow for comparison
+	// canonicalize the CSR to allow for comparison, the common name of a
+	// device certificate can be its permanent identifier.
+	permanentIDs := o.permanentIdentifiers()
+	cn := csr.Subject.CommonName
+	for _, id := range permanentIDs {
+		if cn == id {
+			csr.Subject.CommonName = ""
+			break
+		}
+	}
 	csr = canonicalize(csr)
+	csr.Subject.CommonName = cn
 
 	// retrieve the requested SANs for the Order
 	sans, err := o.sans(csr)
@@ -149,6 +179,41 @@ func (o *Order) Finalize(ctx context.Context, db DB, csr *x509.CertificateReques
 		return err
 	}
 
+	// Check the names in the CSR against the provisioner policy, it might
+	// have changed since the order was created.
+	for _, san := range sans {
+		identifier := provisioner.ACMEIdentifier{Type: provisioner.ACMEIdentifierType(san.Type), Value: san.Value}
+		if err := p.AuthorizeOrderIdentifier(ctx, identifier); err != nil {
+			acmeErr := WrapError(ErrorRejectedIdentifierType, err, "error authorizing identifier")
+			acmeErr.Identifier = Identifier{Type: IdentifierType(san.Type), Value: san.Value}
+			return acmeErr
+		}
+	}
+	for _, id := range permanentIDs {
+		identifier := provisioner.ACMEIdentifier{Type: provisioner.ACMEPermanentIdentifier, Value: id}
+		if err := p.AuthorizeOrderIdentifier(ctx, identifier); err != nil {
+			acmeErr := WrapError(ErrorRejectedIdentifierType, err, "error authorizing identifier")
+			acmeErr.Identifier = Identifier{Type: PermanentIdentifier, Value: id}
+			return acmeErr
+		}
+	}
+
+	if err := o.checkCAA(ctx, db, caa); err != nil {
+		return err
+	}
+	if err := o.checkAttestation(ctx, db, csr); err != nil {
+		return err
+	}
+
+	// Orders with a profile use the profile claims and options.
+	var profile *provisioner.ACMEProfile
+	if o.Profile != "" {
+		if profile, err = p.GetProfile(o.Profile); err != nil {
+			return WrapErrorISE(err, "error retrieving profile %s for order %s", o.Profile, o.ID)
+		}
+		ctx = provisioner.NewContextWithACMEProfile(ctx, o.Profile)
+	}
+
 	// Get authorizations from the ACME provisioner.
 	ctx = provisioner.NewContextWithMethod(ctx, provisioner.SignMethod)
 	signOps, err := p.AuthorizeSign(ctx, "")
@@ -161,11 +226,32 @@ func (o *Order) Finalize(ctx context.Context, db DB, csr *x509.CertificateReques
 	data.SetCommonName(csr.Subject.CommonName)
 	data.Set(x509util.SANsKey, sans)
 
-	templateOptions, err := provisioner.TemplateOptions(p.GetOptions(), data)
+	var (
+		options  *provisioner.Options
+		webhooks provisioner.SignOption
+	)
+	if profile != nil {
+		options = profile.GetOptions()
+		webhooks = profile.WebhookOption(data)
+	} else {
+		options = p.GetOptions()
+		webhooks = p.WebhookOption(data)
+	}
+	defaultTemplate := x509util.DefaultLeafTemplate
+	if o.emailOnly() {
+		defaultTemplate = DefaultSMIMETemplate
+	}
+	templateOptions, err := provisioner.CustomTemplateOptions(options, data, defaultTemplate)
 	if err != nil {
 		return WrapErrorISE(err, "error creating template options from ACME provisioner")
 	}
 	signOps = append(signOps, templateOptions)
+	if webhooks != nil {
+		signOps = append(signOps, webhooks)
+	}
+	if len(permanentIDs) > 0 {
+		signOps = append(signOps, permanentIdentifierSANs(permanentIDs))
+	}
 
 	// Sign a new certificate.
 	certChain, err := auth.Sign(csr, provisioner.SignOptions{
@@ -194,6 +280,17 @@ func (o *Order) Finalize(ctx context.Context, db DB, csr *x509.CertificateReques
 	return nil
 }
 
+// emailOnly returns true if all the identifiers of the order are email
+// addresses, these orders issue S/MIME certificates.
+func (o *Order) emailOnly() bool {
+	for _, id := range o.Identifiers {
+		if id.Type != Email {
+			return false
+		}
+	}
+	return len(o.Identifiers) > 0
+}
+
 func (o *Order) sans(csr *x509.CertificateRequest) ([]x509util.SubjectAlternativeName, error) {
 
 	var sans []x509util.SubjectAlternativeName
@@ -201,7 +298,8 @@ func (o *Order) sans(csr *x509.CertificateRequest) ([]x509util.SubjectAlternativ
 	// order the DNS names and IP addresses, so that they can be compared against the canonicalized CSR
 	orderNames := make([]string, numberOfIdentifierType(DNS, o.Identifiers))
 	orderIPs := make([]net.IP, numberOfIdentifierType(IP, o.Identifiers))
-	indexDNS, indexIP := 0, 0
+	orderEmails := make([]string, numberOfIdentifierType(Email, o.Identifiers))
+	indexDNS, indexIP, indexEmail := 0, 0, 0
 	for _, n := range o.Identifiers {
 		switch n.Type {
 		case DNS:
@@ -210,14 +308,21 @@ func (o *Order) sans(csr *x509.CertificateRequest) ([]x509util.SubjectAlternativ
 		case IP:
 			orderIPs[indexIP] = net.ParseIP(n.Value) // NOTE: this assumes are all valid IPs at this time; or will result in nil entries
 			indexIP++
+		case Email:
+			orderEmails[indexEmail] = n.Value
+			indexEmail++
+		case PermanentIdentifier:
+			// Permanent identifiers are not in the CSR, they are added to
+			// the certificate after the attestation of the CSR key.
 		default:
 			return sans, NewErrorISE("unsupported identifier type in order: %s", n.Type)
 		}
 	}
 	orderNames = uniqueSortedLowerNames(orderNames)
 	orderIPs = uniqueSortedIPs(orderIPs)
+	orderEmails = uniqueSortedLowerNames(orderEmails)
 
-	totalNumberOfSANs := len(csr.DNSNames) + len(csr.IPAddresses)
+	totalNumberOfSANs := len(csr.DNSNames) + len(csr.IPAddresses) + len(csr.EmailAddresses)
 	sans = make([]x509util.SubjectAlternativeName, totalNumberOfSANs)
 	index := 0
 
@@ -260,6 +365,23 @@ func (o *Order) sans(csr *x509.CertificateRequest) ([]x509util.SubjectAlternativ
 		index++
 	}
 
+	if len(csr.EmailAddresses) != len(orderEmails) {
+		return sans, NewError(ErrorBadCSRType, "CSR emails do not match identifiers exactly: "+
+			"CSR emails = %v, Order emails = %v", csr.EmailAddresses, orderEmails)
+	}
+
+	for i := range csr.EmailAddresses {
+		if csr.EmailAddresses[i] != orderEmails[i] {
+			return sans, NewError(ErrorBadCSRType, "CSR emails do not match identifiers exactly: "+
+				"CSR emails = %v, Order emails = %v", csr.EmailAddresses, orderEmails)
+		}
+		sans[index] = x509util.SubjectAlternativeName{
+			Type:  x509util.EmailType,
+			Value: csr.EmailAddresses[i],
+		}
+		index++
+	}
+
 	return sans, nil
 }
 
@@ -287,12 +409,20 @@ func canonicalize(csr *x509.CertificateRequest) (canonicalized *x509.Certificate
 	// identifiers as the initial newOrder request. Identifiers of type "dns"
 	// MUST appear either in the commonName portion of the requested subject
 	// name or in an extensionRequest attribute [RFC2985] requesting a
-	// subjectAltName extension, or both.
-	if csr.Subject.CommonName != "" {
-		canonicalized.DNSNames = append(csr.DNSNames, csr.Subject.CommonName)
+	// subjectAltName extension, or both. The commonName of S/MIME certificates
+	// (RFC 8823) is an email address instead.
+	if cn := csr.Subject.CommonName; cn != "" {
+		if strings.Contains(cn, "@") {
+			canonicalized.EmailAddresses = append(csr.EmailAddresses, cn)
+		} else {
+			canonicalized.DNSNames = append(csr.DNSNames, cn)
+		}
 	}
 	canonicalized.DNSNames = uniqueSortedLowerNames(csr.DNSNames)
 	canonicalized.IPAddresses = uniqueSortedIPs(csr.IPAddresses)
+	if len(csr.EmailAddresses) > 0 {
+		canonicalized.EmailAddresses = uniqueSortedLowerNames(csr.EmailAddresses)
+	}
 
 	return canonicalized
 }
@@ -12,6 +12,7 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority"
 	"github.com/smallstep/certificates/authority/provisioner"
 	"go.step.sm/crypto/x509util"
 )
@@ -279,6 +280,14 @@ func (m *mockSignAuth) Sign(csr *x509.CertificateRequest, signOpts provisioner.S
 	return []*x509.Certificate{m.ret1.(*x509.Certificate), m.ret2.(*x509.Certificate)}, m.err
 }
 
+func (m *mockSignAuth) IsRevoked(sn string) (bool, error) {
+	return false, nil
+}
+
+func (m *mockSignAuth) Revoke(ctx context.Context, opts *authority.RevokeOptions) error {
+	return nil
+}
+
 func (m *mockSignAuth) LoadProvisionerByName(name string) (provisioner.Interface, error) {
 	if m.loadProvisionerByName != nil {
 		return m.loadProvisionerByName(name)
@@ -294,6 +303,7 @@ func TestOrder_Finalize(t *testing.T) {
 		ca   CertificateAuthority
 		csr  *x509.CertificateRequest
 		prov Provisioner
+		caa  *CAAOptions
 	}
 	tests := map[string]func(t *testing.T) test{
 		"fail/invalid": func(t *testing.T) test {
@@ -662,6 +672,316 @@ func TestOrder_Finalize(t *testing.T) {
 				},
 			}
 		},
+		"ok/new-cert-webhooks": func(t *testing.T) test {
+			now := clock.Now()
+			o := &Order{
+				ID:               "oID",
+				AccountID:        "accID",
+				Status:           StatusReady,
+				ExpiresAt:        now.Add(5 * time.Minute),
+				AuthorizationIDs: []string{"a", "b"},
+				Identifiers: []Identifier{
+					{Type: "dns", Value: "foo.internal"},
+				},
+			}
+			csr := &x509.CertificateRequest{
+				Subject: pkix.Name{
+					CommonName: "foo.internal",
+				},
+			}
+
+			foo := &x509.Certificate{Subject: pkix.Name{CommonName: "foo"}}
+			webhooks := &struct{ data x509util.TemplateData }{}
+			return test{
+				o:   o,
+				csr: csr,
+				prov: &MockProvisioner{
+					MauthorizeSign: func(ctx context.Context, token string) ([]provisioner.SignOption, error) {
+						return nil, nil
+					},
+					MgetOptions: func() *provisioner.Options {
+						return nil
+					},
+					MwebhookOption: func(data x509util.TemplateData) provisioner.SignOption {
+						webhooks.data = data
+						return webhooks
+					},
+				},
+				ca: &mockSignAuth{
+					sign: func(_csr *x509.CertificateRequest, signOpts provisioner.SignOptions, extraOpts ...provisioner.SignOption) ([]*x509.Certificate, error) {
+						// The webhooks share the data of the template.
+						assert.Equals(t, len(extraOpts), 2)
+						assert.Equals(t, extraOpts[1], webhooks)
+						assert.Equals(t, webhooks.data[x509util.SubjectKey], x509util.Subject{CommonName: "foo.internal"})
+						return []*x509.Certificate{foo}, nil
+					},
+				},
+				db: &MockDB{
+					MockCreateCertificate: func(ctx context.Context, cert *Certificate) error {
+						cert.ID = "certID"
+						return nil
+					},
+					MockUpdateOrder: func(ctx context.Context, updo *Order) error {
+						assert.Equals(t, updo.CertificateID, "certID")
+						assert.Equals(t, updo.Status, StatusValid)
+						return nil
+					},
+				},
+			}
+		},
+		"fail/profile-not-found": func(t *testing.T) test {
+			now := clock.Now()
+			o := &Order{
+				ID:               "oID",
+				AccountID:        "accID",
+				Status:           StatusReady,
+				ExpiresAt:        now.Add(5 * time.Minute),
+				AuthorizationIDs: []string{"a", "b"},
+				Identifiers: []Identifier{
+					{Type: "dns", Value: "foo.internal"},
+				},
+				Profile: "client",
+			}
+			csr := &x509.CertificateRequest{
+				Subject: pkix.Name{
+					CommonName: "foo.internal",
+				},
+			}
+
+			return test{
+				o:   o,
+				csr: csr,
+				prov: &MockProvisioner{
+					MgetProfile: func(name string) (*provisioner.ACMEProfile, error) {
+						assert.Equals(t, name, "client")
+						return nil, errors.New("profile client not found")
+					},
+				},
+				err: NewErrorISE("error retrieving profile client for order oID: profile client not found"),
+			}
+		},
+		"ok/new-cert-profile": func(t *testing.T) test {
+			now := clock.Now()
+			o := &Order{
+				ID:               "oID",
+				AccountID:        "accID",
+				Status:           StatusReady,
+				ExpiresAt:        now.Add(5 * time.Minute),
+				AuthorizationIDs: []string{"a", "b"},
+				Identifiers: []Identifier{
+					{Type: "dns", Value: "foo.internal"},
+				},
+				Profile: "client",
+			}
+			csr := &x509.CertificateRequest{
+				Subject: pkix.Name{
+					CommonName: "foo.internal",
+				},
+			}
+
+			foo := &x509.Certificate{Subject: pkix.Name{CommonName: "foo"}}
+
+			return test{
+				o:   o,
+				csr: csr,
+				prov: &MockProvisioner{
+					MgetProfile: func(name string) (*provisioner.ACMEProfile, error) {
+						assert.Equals(t, name, "client")
+						return &provisioner.ACMEProfile{Name: "client"}, nil
+					},
+					MauthorizeSign: func(ctx context.Context, token string) ([]provisioner.SignOption, error) {
+						assert.Equals(t, provisioner.ACMEProfileFromContext(ctx), "client")
+						return nil, nil
+					},
+					MgetOptions: func() *provisioner.Options {
+						t.Error("provisioner options should not be used")
+						return nil
+					},
+				},
+				ca: &mockSignAuth{
+					sign: func(_csr *x509.CertificateRequest, signOpts provisioner.SignOptions, extraOpts ...provisioner.SignOption) ([]*x509.Certificate, error) {
+						assert.Equals(t, _csr, csr)
+						return []*x509.Certificate{foo}, nil
+					},
+				},
+				db: &MockDB{
+					MockCreateCertificate: func(ctx context.Context, cert *Certificate) error {
+						cert.ID = "certID"
+						return nil
+					},
+					MockUpdateOrder: func(ctx context.Context, updo *Order) error {
+						assert.Equals(t, updo.CertificateID, "certID")
+						assert.Equals(t, updo.Status, StatusValid)
+						assert.Equals(t, updo.Profile, "client")
+						return nil
+					},
+				},
+			}
+		},
+		"fail/policy": func(t *testing.T) test {
+			now := clock.Now()
+			o := &Order{
+				ID:               "oID",
+				AccountID:        "accID",
+				Status:           StatusReady,
+				ExpiresAt:        now.Add(5 * time.Minute),
+				AuthorizationIDs: []string{"a", "b"},
+				Identifiers: []Identifier{
+					{Type: "dns", Value: "foo.internal"},
+					{Type: "ip", Value: "192.168.42.42"},
+				},
+			}
+			csr := &x509.CertificateRequest{
+				Subject: pkix.Name{
+					CommonName: "foo.internal",
+				},
+				IPAddresses: []net.IP{net.ParseIP("192.168.42.42")},
+			}
+			return test{
+				o:   o,
+				csr: csr,
+				prov: &MockProvisioner{
+					MauthorizeOrderIdentifier: func(ctx context.Context, identifier provisioner.ACMEIdentifier) error {
+						if identifier.Type == provisioner.ACMEIPIdentifier {
+							assert.Equals(t, identifier.Value, "192.168.42.42")
+							return errors.New("force")
+						}
+						assert.Equals(t, identifier, provisioner.ACMEIdentifier{Type: provisioner.ACMEDNSIdentifier, Value: "foo.internal"})
+						return nil
+					},
+				},
+				err: NewError(ErrorRejectedIdentifierType, "error authorizing identifier: force"),
+			}
+		},
+		"fail/caa": func(t *testing.T) test {
+			now := clock.Now()
+			o := &Order{
+				ID:               "oID",
+				AccountID:        "accID",
+				Status:           StatusReady,
+				ExpiresAt:        now.Add(5 * time.Minute),
+				AuthorizationIDs: []string{"a"},
+				Identifiers: []Identifier{
+					{Type: "dns", Value: "foo.internal"},
+				},
+			}
+			csr := &x509.CertificateRequest{
+				Subject: pkix.Name{
+					CommonName: "foo.internal",
+				},
+			}
+			return test{
+				o:    o,
+				csr:  csr,
+				prov: &MockProvisioner{},
+				db: &MockDB{
+					MockGetAuthorization: func(ctx context.Context, id string) (*Authorization, error) {
+						assert.Equals(t, id, "a")
+						return &Authorization{
+							ID:         "a",
+							Identifier: Identifier{Type: "dns", Value: "foo.internal"},
+							Challenges: []*Challenge{{Type: DNS01, Status: StatusValid}},
+						}, nil
+					},
+				},
+				caa: &CAAOptions{
+					IssuerDomainNames: []string{"ca.internal"},
+					LookupCAA: func(ctx context.Context, name string) ([]*CAA, error) {
+						assert.Equals(t, name, "foo.internal")
+						return []*CAA{{Tag: "issue", Value: "other-ca.internal"}}, nil
+					},
+				},
+				err: NewError(ErrorCaaType, "CAA records for foo.internal do not authorize the issuance of the certificate"),
+			}
+		},
+		"ok/new-cert-caa": func(t *testing.T) test {
+			now := clock.Now()
+			o := &Order{
+				ID:               "oID",
+				AccountID:        "accID",
+				Status:           StatusReady,
+				ExpiresAt:        now.Add(5 * time.Minute),
+				AuthorizationIDs: []string{"a", "b"},
+				Identifiers: []Identifier{
+					{Type: "dns", Value: "foo.internal"},
+					{Type: "ip", Value: "192.168.42.42"},
+				},
+			}
+			csr := &x509.CertificateRequest{
+				Subject: pkix.Name{
+					CommonName: "foo.internal",
+				},
+				IPAddresses: []net.IP{net.ParseIP("192.168.42.42")},
+			}
+
+			foo := &x509.Certificate{Subject: pkix.Name{CommonName: "foo"}}
+			bar := &x509.Certificate{Subject: pkix.Name{CommonName: "bar"}}
+
+			return test{
+				o:   o,
+				csr: csr,
+				prov: &MockProvisioner{
+					MauthorizeSign: func(ctx context.Context, token string) ([]provisioner.SignOption, error) {
+						return nil, nil
+					},
+					MgetOptions: func() *provisioner.Options {
+						return nil
+					},
+				},
+				ca: &mockSignAuth{
+					sign: func(_csr *x509.CertificateRequest, signOpts provisioner.SignOptions, extraOpts ...provisioner.SignOption) ([]*x509.Certificate, error) {
+						return []*x509.Certificate{foo, bar}, nil
+					},
+				},
+				db: &MockDB{
+					MockGetAuthorization: func(ctx context.Context, id string) (*Authorization, error) {
+						switch id {
+						case "a":
+							return &Authorization{
+								ID:         "a",
+								Identifier: Identifier{Type: "dns", Value: "foo.internal"},
+								Challenges: []*Challenge{
+									{Type: HTTP01, Status: StatusPending},
+									{Type: DNS01, Status: StatusValid},
+								},
+							}, nil
+						case "b":
+							return &Authorization{
+								ID:         "b",
+								Identifier: Identifier{Type: "ip", Value: "192.168.42.42"},
+							}, nil
+						default:
+							return nil, errors.Errorf("unexpected authorization %s", id)
+						}
+					},
+					MockCreateCertificate: func(ctx context.Context, cert *Certificate) error {
+						cert.ID = "certID"
+						return nil
+					},
+					MockUpdateOrder: func(ctx context.Context, updo *Order) error {
+						assert.Equals(t, updo.CertificateID, "certID")
+						assert.Equals(t, updo.Status, StatusValid)
+						return nil
+					},
+				},
+				caa: &CAAOptions{
+					IssuerDomainNames: []string{"ca.internal"},
+					AccountURI:        "https://ca.internal/acme/acme/account/accID",
+					LookupCAA: func(ctx context.Context, name string) ([]*CAA, error) {
+						switch name {
+						case "foo.internal":
+							return nil, nil
+						case "internal":
+							return []*CAA{
+								{Tag: "issue", Value: "ca.internal; accounturi=https://ca.internal/acme/acme/account/accID; validationmethods=dns-01"},
+							}, nil
+						default:
+							return nil, errors.Errorf("unexpected lookup %s", name)
+						}
+					},
+				},
+			}
+		},
 		"ok/new-cert-ip": func(t *testing.T) test {
 			now := clock.Now()
 			o := &Order{
@@ -791,7 +1111,7 @@ func TestOrder_Finalize(t *testing.T) {
 	for name, run := range tests {
 		t.Run(name, func(t *testing.T) {
 			tc := run(t)
-			if err := tc.o.Finalize(context.Background(), tc.db, tc.csr, tc.ca, tc.prov); err != nil {
+			if err := tc.o.Finalize(context.Background(), tc.db, tc.csr, tc.ca, tc.prov, tc.caa); err != nil {
 				if assert.NotNil(t, tc.err) {
 					switch k := err.(type) {
 					case *Error:
@@ -1191,6 +1511,25 @@ func Test_canonicalize(t *testing.T) {
 				IPAddresses: []net.IP{net.ParseIP("192.168.42.42"), net.ParseIP("192.168.43.42")},
 			},
 		},
+		{
+			name: "ok/email-common-name",
+			args: args{
+				csr: &x509.CertificateRequest{
+					Subject: pkix.Name{
+						CommonName: "Jane@example.com",
+					},
+					EmailAddresses: []string{"jane@example.com", "jane@smallstep.com"},
+				},
+			},
+			wantCanonicalized: &x509.CertificateRequest{
+				Subject: pkix.Name{
+					CommonName: "Jane@example.com",
+				},
+				DNSNames:       []string{},
+				IPAddresses:    []net.IP{},
+				EmailAddresses: []string{"jane@example.com", "jane@smallstep.com"},
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -1201,6 +1540,18 @@ func Test_canonicalize(t *testing.T) {
 	}
 }
 
+func TestOrder_emailOnly(t *testing.T) {
+	assert.False(t, (&Order{}).emailOnly())
+	assert.False(t, (&Order{Identifiers: []Identifier{
+		{Type: "email", Value: "jane@example.com"},
+		{Type: "dns", Value: "example.com"},
+	}}).emailOnly())
+	assert.True(t, (&Order{Identifiers: []Identifier{
+		{Type: "email", Value: "jane@example.com"},
+		{Type: "email", Value: "john@example.com"},
+	}}).emailOnly())
+}
+
 func TestOrder_sans(t *testing.T) {
 	type fields struct {
 		Identifiers []Identifier
@@ -1229,6 +1580,48 @@ func TestOrder_sans(t *testing.T) {
 			},
 			err: nil,
 		},
+		{
+			name: "ok/email",
+			fields: fields{
+				Identifiers: []Identifier{
+					{Type: "email", Value: "jane@example.com"},
+				},
+			},
+			csr: &x509.CertificateRequest{
+				Subject: pkix.Name{
+					CommonName: "jane@example.com",
+				},
+			},
+			want: []x509util.SubjectAlternativeName{
+				{Type: "email", Value: "jane@example.com"},
+			},
+			err: nil,
+		},
+		{
+			name: "ok/permanent-identifier",
+			fields: fields{
+				Identifiers: []Identifier{
+					{Type: "permanent-identifier", Value: "123456"},
+				},
+			},
+			csr:  &x509.CertificateRequest{},
+			want: []x509util.SubjectAlternativeName{},
+			err:  nil,
+		},
+		{
+			name: "fail/error-emails-mismatch",
+			fields: fields{
+				Identifiers: []Identifier{
+					{Type: "email", Value: "jane@example.com"},
+				},
+			},
+			csr: &x509.CertificateRequest{
+				EmailAddresses: []string{"joe@example.com"},
+			},
+			want: []x509util.SubjectAlternativeName{},
+			err: NewError(ErrorBadCSRType, "CSR emails do not match identifiers exactly: "+
+				"CSR emails = %v, Order emails = %v", []string{"joe@example.com"}, []string{"jane@example.com"}),
+		},
 		{
 			name: "fail/error-names-length-mismatch",
 			fields: fields{
@@ -0,0 +1,104 @@
+package acme
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// RateLimit is a limit of events in a sliding window of time. The events are
+// stored using the DB interface, so the limit is shared by all the instances
+// using the same database.
+type RateLimit struct {
+	// Name is the name of the rate limit, e.g. orders.
+	Name string
+	// Scope is the prefix of the keys of the events, e.g. a provisioner ID.
+	Scope string
+	// Limit is the maximum number of events in the window.
+	Limit int
+	// Window is the duration of the sliding window.
+	Window time.Duration
+}
+
+// RateLimits are the rate limits enforced by the ACME API. A nil rate limit
+// is not enforced.
+type RateLimits struct {
+	OrdersPerAccount             *RateLimit
+	CertificatesPerDomain        *RateLimit
+	FailedValidationsPerHostname *RateLimit
+	AccountsPerIP                *RateLimit
+}
+
+func (rl *RateLimit) key(value string) string {
+	return rl.Scope + "/" + rl.Name + "/" + value
+}
+
+// Check returns a rateLimited error if the number of events registered for
+// the given value reached the limit. The error includes the time until the
+// next event is allowed.
+func (rl *RateLimit) Check(ctx context.Context, db DB, value string) error {
+	if rl == nil {
+		return nil
+	}
+	events, err := db.GetRateLimitEvents(ctx, rl.key(value))
+	if err != nil {
+		return WrapErrorISE(err, "error retrieving %s rate limit for %s", rl.Name, value)
+	}
+
+	now := clock.Now()
+	since := now.Add(-rl.Window)
+	var inWindow []time.Time
+	for _, t := range events {
+		if t.After(since) {
+			inWindow = append(inWindow, t)
+		}
+	}
+	if len(inWindow) < rl.Limit {
+		return nil
+	}
+
+	// The next event is allowed when enough events leave the window.
+	sort.Slice(inWindow, func(i, j int) bool { return inWindow[i].Before(inWindow[j]) })
+	next := inWindow[len(inWindow)-rl.Limit].Add(rl.Window)
+
+	acmeErr := NewError(ErrorRateLimitedType, "%s rate limit exceeded for %s: %d every %s", rl.Name, value, rl.Limit, rl.Window)
+	acmeErr.Status = http.StatusTooManyRequests
+	acmeErr.RetryAfter = next.Sub(now)
+	return acmeErr
+}
+
+// Record registers a new event for the given value.
+func (rl *RateLimit) Record(ctx context.Context, db DB, value string) error {
+	if rl == nil {
+		return nil
+	}
+	if err := db.AddRateLimitEvent(ctx, rl.key(value), clock.Now(), rl.Window); err != nil {
+		return WrapErrorISE(err, "error updating %s rate limit for %s", rl.Name, value)
+	}
+	return nil
+}
+
+// Take checks the rate limit and registers a new event if the limit has not
+// been reached. Concurrent requests in different instances might exceed the
+// limit by a few events.
+func (rl *RateLimit) Take(ctx context.Context, db DB, value string) error {
+	if err := rl.Check(ctx, db, value); err != nil {
+		return err
+	}
+	return rl.Record(ctx, db, value)
+}
+
+// RegisteredDomain returns the registered domain, the public suffix plus one
+// label, of a DNS name. Unknown top level domains are considered public
+// suffixes, and names that are a public suffix are returned as they are.
+func RegisteredDomain(name string) string {
+	name = strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(name, "*."), "."))
+	if domain, err := publicsuffix.EffectiveTLDPlusOne(name); err == nil {
+		return domain
+	}
+	return name
+}
@@ -0,0 +1,142 @@
+package acme
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+)
+
+func TestRateLimit_Check(t *testing.T) {
+	now := clock.Now()
+	rl := &RateLimit{Name: "orders", Scope: "acme/acme", Limit: 2, Window: time.Hour}
+	type test struct {
+		rl         *RateLimit
+		db         DB
+		err        *Error
+		retryAfter time.Duration
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"ok/nil": func(t *testing.T) test {
+			return test{
+				db: &MockDB{},
+			}
+		},
+		"fail/db.GetRateLimitEvents-error": func(t *testing.T) test {
+			return test{
+				rl: rl,
+				db: &MockDB{
+					MockGetRateLimitEvents: func(ctx context.Context, key string) ([]time.Time, error) {
+						return nil, errors.New("force")
+					},
+				},
+				err: NewErrorISE("error retrieving orders rate limit for accID: force"),
+			}
+		},
+		"ok/under-limit": func(t *testing.T) test {
+			return test{
+				rl: rl,
+				db: &MockDB{
+					MockGetRateLimitEvents: func(ctx context.Context, key string) ([]time.Time, error) {
+						assert.Equals(t, key, "acme/acme/orders/accID")
+						return []time.Time{now.Add(-2 * time.Hour), now.Add(-time.Minute)}, nil
+					},
+				},
+			}
+		},
+		"fail/limit": func(t *testing.T) test {
+			return test{
+				rl: rl,
+				db: &MockDB{
+					MockGetRateLimitEvents: func(ctx context.Context, key string) ([]time.Time, error) {
+						return []time.Time{now.Add(-time.Minute), now.Add(-30 * time.Minute), now.Add(-2 * time.Hour)}, nil
+					},
+				},
+				err:        NewError(ErrorRateLimitedType, "orders rate limit exceeded for accID: 2 every 1h0m0s"),
+				retryAfter: 30 * time.Minute,
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			if err := tc.rl.Check(context.Background(), tc.db, "accID"); err != nil {
+				if assert.NotNil(t, tc.err) {
+					k, ok := err.(*Error)
+					assert.True(t, ok)
+					assert.HasPrefix(t, k.Error(), tc.err.Error())
+					assert.Equals(t, k.Type, tc.err.Type)
+					assert.Equals(t, k.Detail, tc.err.Detail)
+					if tc.retryAfter > 0 {
+						assert.Equals(t, k.Status, http.StatusTooManyRequests)
+						assert.True(t, k.RetryAfter > tc.retryAfter-time.Minute)
+						assert.True(t, k.RetryAfter <= tc.retryAfter)
+					}
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+func TestRateLimit_Take(t *testing.T) {
+	rl := &RateLimit{Name: "accounts", Scope: "acme/acme", Limit: 1, Window: 3 * time.Hour}
+
+	var recorded []string
+	db := &MockDB{
+		MockGetRateLimitEvents: func(ctx context.Context, key string) ([]time.Time, error) {
+			if len(recorded) > 0 {
+				return []time.Time{clock.Now()}, nil
+			}
+			return nil, nil
+		},
+		MockAddRateLimitEvent: func(ctx context.Context, key string, tm time.Time, window time.Duration) error {
+			assert.Equals(t, window, 3*time.Hour)
+			recorded = append(recorded, key)
+			return nil
+		},
+	}
+	assert.FatalError(t, rl.Take(context.Background(), db, "127.0.0.1"))
+	assert.Equals(t, recorded, []string{"acme/acme/accounts/127.0.0.1"})
+
+	err := rl.Take(context.Background(), db, "127.0.0.1")
+	if assert.NotNil(t, err) {
+		assert.Equals(t, err.(*Error).Type, NewError(ErrorRateLimitedType, "").Type)
+	}
+	assert.Len(t, 1, recorded)
+
+	db.MockAddRateLimitEvent = func(ctx context.Context, key string, tm time.Time, window time.Duration) error {
+		return errors.New("force")
+	}
+	err = rl.Record(context.Background(), db, "127.0.0.1")
+	if assert.NotNil(t, err) {
+		assert.HasPrefix(t, err.(*Error).Err.Error(), "error updating accounts rate limit for 127.0.0.1: force")
+	}
+
+	var nilRL *RateLimit
+	assert.Nil(t, nilRL.Take(context.Background(), db, "127.0.0.1"))
+}
+
+func TestRegisteredDomain(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"example.com", "example.com"},
+		{"www.example.com", "example.com"},
+		{"*.a.b.Example.COM.", "example.com"},
+		{"foo.bar.co.uk", "bar.co.uk"},
+		{"host.internal", "host.internal"},
+		{"a.host.internal", "host.internal"},
+		{"com", "com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equals(t, RegisteredDomain(tt.name), tt.want)
+		})
+	}
+}
@@ -0,0 +1,233 @@
+package acme
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"go.step.sm/crypto/jose"
+)
+
+// ValidationOptions configures the retries of the background validation of a
+// challenge.
+type ValidationOptions struct {
+	// Retries is the number of times a validation is retried after a
+	// transient error.
+	Retries int
+	// Backoff is the time to wait before the first retry, it doubles on each
+	// retry.
+	Backoff time.Duration
+	// MaxBackoff is the maximum time to wait between retries.
+	MaxBackoff time.Duration
+	// DNSPropagationTimeout is the minimum time a dns-01 challenge is retried,
+	// regardless of the number of retries, to tolerate slow DNS propagation.
+	DNSPropagationTimeout time.Duration
+	// EmailReplyTimeout is the minimum time an email-reply-00 challenge is
+	// retried, regardless of the number of retries, to give time to the
+	// reply to arrive.
+	EmailReplyTimeout time.Duration
+	// Attestation are the options of the provisioner used to validate
+	// device-attest-01 challenges.
+	Attestation *AttestationOptions
+	// FailedValidations is the rate limit that registers the hostnames of
+	// the challenges that become invalid.
+	FailedValidations *RateLimit
+}
+
+// DefaultValidationOptions are the validation options used when a provisioner
+// does not configure them.
+var DefaultValidationOptions = ValidationOptions{
+	Retries:               3,
+	Backoff:               5 * time.Second,
+	MaxBackoff:            30 * time.Second,
+	DNSPropagationTimeout: 2 * time.Minute,
+	EmailReplyTimeout:     15 * time.Minute,
+}
+
+// backoff returns the time to wait before the given retry, starting at 1.
+func (o *ValidationOptions) backoff(retry int) time.Duration {
+	d := o.Backoff
+	for i := 1; i < retry; i++ {
+		if o.MaxBackoff > 0 && d >= o.MaxBackoff {
+			break
+		}
+		d *= 2
+	}
+	if o.MaxBackoff > 0 && d > o.MaxBackoff {
+		d = o.MaxBackoff
+	}
+	return d
+}
+
+// shouldRetry returns true if a challenge that failed with a transient error
+// after the given number of retries must be validated again.
+func (o *ValidationOptions) shouldRetry(ch *Challenge, retries int, elapsed time.Duration) bool {
+	if retries < o.Retries {
+		return true
+	}
+	switch ch.Type {
+	case DNS01:
+		return elapsed < o.DNSPropagationTimeout
+	case EMAILREPLY00:
+		return elapsed < o.EmailReplyTimeout
+	default:
+		return false
+	}
+}
+
+// ChallengeValidator validates challenges in the background. Transient errors
+// are retried with an exponential backoff and the challenge is kept in the
+// processing status until the validation succeeds or fails permanently.
+type ChallengeValidator struct {
+	ctx  context.Context
+	db   DB
+	vo   *ValidateChallengeOptions
+	mu   sync.Mutex
+	jobs map[string]time.Time
+	wg   sync.WaitGroup
+}
+
+// NewChallengeValidator returns a new ChallengeValidator. The validations run
+// with the given context, when it is canceled they are interrupted, leaving
+// the challenges in the processing status.
+func NewChallengeValidator(ctx context.Context, db DB, vo *ValidateChallengeOptions) *ChallengeValidator {
+	return &ChallengeValidator{
+		ctx:  ctx,
+		db:   db,
+		vo:   vo,
+		jobs: make(map[string]time.Time),
+	}
+}
+
+// Start marks the challenge as processing and starts its validation in the
+// background. It returns false if the challenge is already being validated.
+//
+// Challenges in the processing status are also accepted, this allows to
+// resume validations interrupted by a restart of the server.
+func (v *ChallengeValidator) Start(ctx context.Context, ch *Challenge, jwk *jose.JSONWebKey, opts *ValidationOptions) (bool, error) {
+	switch ch.Status {
+	case StatusPending, StatusProcessing:
+	default:
+		return false, nil
+	}
+
+	v.mu.Lock()
+	if _, ok := v.jobs[ch.ID]; ok {
+		v.mu.Unlock()
+		return false, nil
+	}
+	v.jobs[ch.ID] = time.Now()
+	v.mu.Unlock()
+
+	if ch.Status == StatusPending {
+		ch.Status = StatusProcessing
+		if err := v.db.UpdateChallenge(ctx, ch); err != nil {
+			ch.Status = StatusPending
+			v.done(ch.ID)
+			return false, WrapErrorISE(err, "error updating challenge")
+		}
+	}
+
+	// The caller keeps using the challenge, so the validation works on a copy.
+	job := *ch
+	v.wg.Add(1)
+	go v.run(&job, jwk, opts)
+	return true, nil
+}
+
+// RetryAfter returns the time until the next validation attempt of the given
+// challenge. It returns 0 if an attempt is running or the challenge is not
+// being validated.
+func (v *ChallengeValidator) RetryAfter(chID string) time.Duration {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	next, ok := v.jobs[chID]
+	if !ok {
+		return 0
+	}
+	if d := time.Until(next); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// Wait blocks until all the running validations are completed.
+func (v *ChallengeValidator) Wait() {
+	v.wg.Wait()
+}
+
+func (v *ChallengeValidator) done(chID string) {
+	v.mu.Lock()
+	delete(v.jobs, chID)
+	v.mu.Unlock()
+}
+
+func (v *ChallengeValidator) next(chID string, d time.Duration) {
+	v.mu.Lock()
+	v.jobs[chID] = time.Now().Add(d)
+	v.mu.Unlock()
+}
+
+// failed registers a failed validation of the challenge hostname. Errors are
+// ignored, the request that started the validation is already gone.
+func (v *ChallengeValidator) failed(ctx context.Context, ch *Challenge, opts *ValidationOptions) {
+	opts.FailedValidations.Record(ctx, v.db, ch.Value)
+}
+
+// run validates the challenge until it becomes valid or invalid. The request
+// that started the validation is already gone, so the context of the
+// validator is used. If the validator context is canceled, or the last update
+// fails, the challenge stays in the processing status and the validation is
+// started again on the next request.
+func (v *ChallengeValidator) run(ch *Challenge, jwk *jose.JSONWebKey, opts *ValidationOptions) {
+	defer v.wg.Done()
+	defer v.done(ch.ID)
+
+	vo := v.vo
+	if opts.Attestation != nil {
+		o := *v.vo
+		o.Attestation = opts.Attestation
+		vo = &o
+	}
+
+	ctx := v.ctx
+	start := time.Now()
+	for retries := 0; ; retries++ {
+		err := ch.validate(ctx, v.db, jwk, vo)
+		if err == nil && ch.Status != StatusProcessing {
+			if ch.Status == StatusInvalid {
+				v.failed(ctx, ch, opts)
+			}
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if !opts.shouldRetry(ch, retries, time.Since(start)) {
+			if err != nil {
+				var acmeErr *Error
+				if !errors.As(err, &acmeErr) {
+					acmeErr = WrapErrorISE(err, "error validating challenge")
+				}
+				ch.Error = acmeErr
+			}
+			ch.Status = StatusInvalid
+			if err := v.db.UpdateChallenge(ctx, ch); err != nil {
+				log.Printf("error updating challenge %s: %v", ch.ID, err)
+			}
+			v.failed(ctx, ch, opts)
+			return
+		}
+		d := opts.backoff(retries + 1)
+		v.next(ch.ID, d)
+		timer := time.NewTimer(d)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package acme
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"go.step.sm/crypto/jose"
+)
+
+func TestValidationOptions_backoff(t *testing.T) {
+	opts := &ValidationOptions{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	tests := []struct {
+		retry int
+		want  time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{100, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		assert.Equals(t, opts.backoff(tt.retry), tt.want)
+	}
+
+	opts = &ValidationOptions{Backoff: time.Second}
+	assert.Equals(t, opts.backoff(4), 8*time.Second)
+}
+
+func TestValidationOptions_shouldRetry(t *testing.T) {
+	opts := &ValidationOptions{Retries: 2, DNSPropagationTimeout: time.Minute, EmailReplyTimeout: 10 * time.Minute}
+	http01 := &Challenge{Type: HTTP01}
+	dns01 := &Challenge{Type: DNS01}
+	email := &Challenge{Type: EMAILREPLY00}
+
+	assert.True(t, opts.shouldRetry(http01, 0, 0))
+	assert.True(t, opts.shouldRetry(http01, 1, 0))
+	assert.False(t, opts.shouldRetry(http01, 2, 0))
+	assert.False(t, opts.shouldRetry(http01, 2, 30*time.Second))
+	assert.True(t, opts.shouldRetry(dns01, 2, 30*time.Second))
+	assert.True(t, opts.shouldRetry(dns01, 10, 59*time.Second))
+	assert.False(t, opts.shouldRetry(dns01, 10, time.Minute))
+	assert.True(t, opts.shouldRetry(email, 10, 9*time.Minute))
+	assert.False(t, opts.shouldRetry(email, 10, 10*time.Minute))
+}
+
+func TestChallengeValidator_Start(t *testing.T) {
+	jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	assert.FatalError(t, err)
+	keyAuth, err := KeyAuthorization("token", jwk)
+	assert.FatalError(t, err)
+	sum := sha256.Sum256([]byte(keyAuth))
+	txt := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	type test struct {
+		ch         *Challenge
+		db         *MockDB
+		vo         *ValidateChallengeOptions
+		opts       *ValidationOptions
+		running    bool
+		cancel     bool
+		started    bool
+		err        *Error
+		wantStatus Status
+		wantError  *Error
+	}
+	tests := map[string]func(t *testing.T) test{
+		"ok/valid": func(t *testing.T) test {
+			return test{
+				ch: &Challenge{ID: "chID", Type: DNS01, Status: StatusValid},
+			}
+		},
+		"ok/already-running": func(t *testing.T) test {
+			return test{
+				ch:      &Challenge{ID: "chID", Type: DNS01, Status: StatusProcessing},
+				running: true,
+			}
+		},
+		"fail/db.UpdateChallenge-error": func(t *testing.T) test {
+			return test{
+				ch: &Challenge{ID: "chID", Type: DNS01, Status: StatusPending},
+				db: &MockDB{
+					MockUpdateChallenge: func(ctx context.Context, ch *Challenge) error {
+						assert.Equals(t, ch.Status, StatusProcessing)
+						return errors.New("force")
+					},
+				},
+				err: NewErrorISE("error updating challenge: force"),
+			}
+		},
+		"ok/retries-exhausted": func(t *testing.T) test {
+			var gets int
+			return test{
+				ch: &Challenge{ID: "chID", Type: HTTP01, Status: StatusPending, Value: "zap.internal", Token: "token"},
+				db: &MockDB{
+					MockUpdateChallenge: func(ctx context.Context, ch *Challenge) error {
+						return nil
+					},
+				},
+				vo: &ValidateChallengeOptions{
+					HTTPGet: func(url string) (*http.Response, error) {
+						gets++
+						assert.True(t, gets <= 3)
+						return nil, errors.New("force")
+					},
+				},
+				opts:       &ValidationOptions{Retries: 2, Backoff: time.Millisecond, DNSPropagationTimeout: time.Minute},
+				started:    true,
+				wantStatus: StatusInvalid,
+				wantError:  NewError(ErrorConnectionType, "force"),
+			}
+		},
+		"ok/dns-propagation": func(t *testing.T) test {
+			var lookups int
+			return test{
+				ch: &Challenge{ID: "chID", Type: DNS01, Status: StatusPending, Value: "zap.internal", Token: "token"},
+				db: &MockDB{
+					MockUpdateChallenge: func(ctx context.Context, ch *Challenge) error {
+						return nil
+					},
+				},
+				vo: &ValidateChallengeOptions{
+					LookupTxt: func(name string) ([]string, error) {
+						assert.Equals(t, name, "_acme-challenge.zap.internal")
+						lookups++
+						switch lookups {
+						case 1:
+							return nil, errors.New("force")
+						case 2:
+							return []string{"foo"}, nil
+						default:
+							return []string{"foo", txt}, nil
+						}
+					},
+				},
+				opts:       &ValidationOptions{Retries: 0, Backoff: time.Millisecond, DNSPropagationTimeout: time.Minute},
+				started:    true,
+				wantStatus: StatusValid,
+			}
+		},
+		"ok/canceled": func(t *testing.T) test {
+			return test{
+				ch: &Challenge{ID: "chID", Type: HTTP01, Status: StatusPending, Value: "zap.internal", Token: "token"},
+				db: &MockDB{
+					MockUpdateChallenge: func(ctx context.Context, ch *Challenge) error {
+						return nil
+					},
+				},
+				vo: &ValidateChallengeOptions{
+					HTTPGet: func(url string) (*http.Response, error) {
+						return nil, errors.New("force")
+					},
+				},
+				opts:       &ValidationOptions{Retries: 2, Backoff: time.Hour},
+				cancel:     true,
+				started:    true,
+				wantStatus: StatusProcessing,
+				wantError:  NewError(ErrorConnectionType, "force"),
+			}
+		},
+		"ok/processing-resumed": func(t *testing.T) test {
+			return test{
+				ch: &Challenge{ID: "chID", Type: DNS01, Status: StatusProcessing, Value: "zap.internal", Token: "token"},
+				db: &MockDB{
+					MockUpdateChallenge: func(ctx context.Context, ch *Challenge) error {
+						assert.NotEquals(t, ch.Status, StatusPending)
+						return nil
+					},
+				},
+				vo: &ValidateChallengeOptions{
+					LookupTxt: func(name string) ([]string, error) {
+						return []string{txt}, nil
+					},
+				},
+				opts:       &ValidationOptions{},
+				started:    true,
+				wantStatus: StatusValid,
+			}
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			var final *Challenge
+			if tc.db != nil && tc.db.MockUpdateChallenge != nil {
+				update := tc.db.MockUpdateChallenge
+				tc.db.MockUpdateChallenge = func(ctx context.Context, ch *Challenge) error {
+					c := *ch
+					final = &c
+					return update(ctx, ch)
+				}
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			v := NewChallengeValidator(ctx, tc.db, tc.vo)
+			if tc.running {
+				v.jobs[tc.ch.ID] = time.Now().Add(time.Minute)
+				assert.True(t, v.RetryAfter(tc.ch.ID) > 50*time.Second)
+			}
+			started, err := v.Start(context.Background(), tc.ch, jwk, tc.opts)
+			if tc.cancel {
+				cancel()
+			}
+			v.Wait()
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					var k *Error
+					assert.True(t, errors.As(err, &k))
+					assert.Equals(t, k.Type, tc.err.Type)
+					assert.Equals(t, k.Detail, tc.err.Detail)
+					assert.Equals(t, k.Err.Error(), tc.err.Err.Error())
+					assert.Equals(t, tc.ch.Status, StatusPending)
+				}
+				return
+			}
+			assert.Nil(t, tc.err)
+			assert.Equals(t, started, tc.started)
+			if !tc.started {
+				return
+			}
+			assert.Equals(t, tc.ch.Status, StatusProcessing)
+			assert.Equals(t, v.RetryAfter(tc.ch.ID), time.Duration(0))
+			if assert.NotNil(t, final) {
+				assert.Equals(t, final.Status, tc.wantStatus)
+				if tc.wantError != nil {
+					assert.Equals(t, final.Error.Type, tc.wantError.Type)
+				} else {
+					assert.Nil(t, final.Error)
+				}
+			}
+		})
+	}
+}
@@ -45,6 +45,8 @@ type Authority interface {
 	GetEncryptedKey(kid string) (string, error)
 	GetRoots() (federation []*x509.Certificate, err error)
 	GetFederation() ([]*x509.Certificate, error)
+	GetCertificateRevocationList() ([]byte, error)
+	GetOCSPResponse(der []byte) (*authority.OCSPResponse, error)
 	Version() authority.Version
 }
 
@@ -258,6 +260,12 @@ func (h *caHandler) Route(r Router) {
 	r.MethodFunc("GET", "/provisioners/{kid}/encrypted-key", h.ProvisionerKey)
 	r.MethodFunc("GET", "/roots", h.Roots)
 	r.MethodFunc("GET", "/federation", h.Federation)
+	r.MethodFunc("GET", "/crl", h.CRL)
+	r.MethodFunc("GET", "/ocsp/*", h.OCSPGet)
+	r.MethodFunc("POST", "/ocsp", h.OCSPPost)
+	r.MethodFunc("POST", "/tpm/{provisioner}/challenge", h.TPMChallenge)
+	r.MethodFunc("GET", "/spiffe/bundle", h.SPIFFEBundle)
+	r.MethodFunc("GET", "/spiffe/federation", h.SPIFFEFederation)
 	// SSH CA
 	r.MethodFunc("POST", "/ssh/sign", h.SSHSign)
 	r.MethodFunc("POST", "/ssh/renew", h.SSHRenew)
@@ -567,6 +567,8 @@ type mockAuthority struct {
 	getEncryptedKey              func(kid string) (string, error)
 	getRoots                     func() ([]*x509.Certificate, error)
 	getFederation                func() ([]*x509.Certificate, error)
+	getCertificateRevocationList func() ([]byte, error)
+	getOCSPResponse              func(der []byte) (*authority.OCSPResponse, error)
 	signSSH                      func(ctx context.Context, key ssh.PublicKey, opts provisioner.SignSSHOptions, signOpts ...provisioner.SignOption) (*ssh.Certificate, error)
 	signSSHAddUser               func(ctx context.Context, key ssh.PublicKey, cert *ssh.Certificate) (*ssh.Certificate, error)
 	renewSSH                     func(ctx context.Context, cert *ssh.Certificate) (*ssh.Certificate, error)
@@ -676,6 +678,20 @@ func (m *mockAuthority) GetFederation() ([]*x509.Certificate, error) {
 	return m.ret1.([]*x509.Certificate), m.err
 }
 
+func (m *mockAuthority) GetCertificateRevocationList() ([]byte, error) {
+	if m.getCertificateRevocationList != nil {
+		return m.getCertificateRevocationList()
+	}
+	return m.ret1.([]byte), m.err
+}
+
+func (m *mockAuthority) GetOCSPResponse(der []byte) (*authority.OCSPResponse, error) {
+	if m.getOCSPResponse != nil {
+		return m.getOCSPResponse(der)
+	}
+	return m.ret1.(*authority.OCSPResponse), m.err
+}
+
 func (m *mockAuthority) SignSSH(ctx context.Context, key ssh.PublicKey, opts provisioner.SignSSHOptions, signOpts ...provisioner.SignOption) (*ssh.Certificate, error) {
 	if m.signSSH != nil {
 		return m.signSSH(ctx, key, opts, signOpts...)
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"encoding/pem"
+	"net/http"
+
+	"github.com/smallstep/certificates/errs"
+)
+
+// NewCRLHandler returns an HTTP handler that serves the certificate revocation
+// list. It is used to serve the CRL in routers without the rest of the CA
+// endpoints, like the one used by the insecure server.
+func NewCRLHandler(authority Authority) http.HandlerFunc {
+	h := &caHandler{Authority: authority}
+	return h.CRL
+}
+
+// CRL is an HTTP handler that returns the current certificate revocation
+// list in DER format, or in PEM format if the pem query parameter is set.
+func (h *caHandler) CRL(w http.ResponseWriter, r *http.Request) {
+	crlBytes, err := h.Authority.GetCertificateRevocationList()
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	if _, ok := r.URL.Query()["pem"]; ok {
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"crl.pem\"")
+		crlBytes = pem.EncodeToMemory(&pem.Block{
+			Type:  "X509 CRL",
+			Bytes: crlBytes,
+		})
+	} else {
+		w.Header().Set("Content-Type", "application/pkix-crl")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"crl.der\"")
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(crlBytes); err != nil {
+		LogError(w, errs.Wrap(http.StatusInternalServerError, err, "error writing crl"))
+	}
+}
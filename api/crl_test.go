@@ -0,0 +1,60 @@
+package api
+
+import (
+	"bytes"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/smallstep/certificates/errs"
+)
+
+func Test_caHandler_CRL(t *testing.T) {
+	crl := []byte("fake-crl")
+	crlPEM := pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: crl})
+
+	tests := []struct {
+		name        string
+		url         string
+		crl         []byte
+		err         error
+		statusCode  int
+		contentType string
+		expected    []byte
+	}{
+		{"ok", "http://example.com/crl", crl, nil, http.StatusOK, "application/pkix-crl", crl},
+		{"ok pem", "http://example.com/crl?pem", crl, nil, http.StatusOK, "application/x-pem-file", crlPEM},
+		{"fail not found", "http://example.com/crl", nil, errs.NotFound("not enabled"), http.StatusNotFound, "application/json", nil},
+		{"fail", "http://example.com/crl", nil, errs.InternalServer("force"), http.StatusInternalServerError, "application/json", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New(&mockAuthority{ret1: tt.crl, err: tt.err}).(*caHandler)
+			req := httptest.NewRequest("GET", tt.url, nil)
+			w := httptest.NewRecorder()
+			h.CRL(w, req)
+			res := w.Result()
+
+			if res.StatusCode != tt.statusCode {
+				t.Errorf("caHandler.CRL StatusCode = %d, wants %d", res.StatusCode, tt.statusCode)
+			}
+			if ct := res.Header.Get("Content-Type"); ct != tt.contentType {
+				t.Errorf("caHandler.CRL Content-Type = %s, wants %s", ct, tt.contentType)
+			}
+
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if err != nil {
+				t.Errorf("caHandler.CRL unexpected error = %v", err)
+			}
+			if tt.statusCode < http.StatusBadRequest {
+				if !bytes.Equal(body, tt.expected) {
+					t.Errorf("caHandler.CRL Body = %s, wants %s", body, tt.expected)
+				}
+			}
+		})
+	}
+}
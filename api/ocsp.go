@@ -0,0 +1,103 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/smallstep/certificates/errs"
+	"golang.org/x/crypto/ocsp"
+)
+
+// maxOCSPRequestSize is the maximum size of an OCSP request, requests are
+// usually around 100 bytes.
+const maxOCSPRequestSize = 10 * 1024
+
+// NewOCSPHandler returns an HTTP handler that serves OCSP requests using GET
+// and POST methods. It is used to serve OCSP in routers without the rest of
+// the CA endpoints, like the one used by the insecure server.
+func NewOCSPHandler(authority Authority) http.HandlerFunc {
+	h := &caHandler{Authority: authority}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			h.OCSPPost(w, r)
+			return
+		}
+		h.OCSPGet(w, r)
+	}
+}
+
+// OCSPGet is an HTTP handler that returns the OCSP response for the base64
+// encoded OCSP request in the URL. GET responses can be cached by HTTP
+// proxies, see RFC 5019, section 5.
+func (h *caHandler) OCSPGet(w http.ResponseWriter, r *http.Request) {
+	s, err := url.PathUnescape(chi.URLParam(r, "*"))
+	if err != nil {
+		writeOCSPResponse(w, ocsp.MalformedRequestErrorResponse)
+		return
+	}
+	der, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		writeOCSPResponse(w, ocsp.MalformedRequestErrorResponse)
+		return
+	}
+	h.ocsp(w, der, true)
+}
+
+// OCSPPost is an HTTP handler that returns the OCSP response for the OCSP
+// request in the body.
+func (h *caHandler) OCSPPost(w http.ResponseWriter, r *http.Request) {
+	der, err := ioutil.ReadAll(io.LimitReader(r.Body, maxOCSPRequestSize))
+	if err != nil {
+		writeOCSPResponse(w, ocsp.MalformedRequestErrorResponse)
+		return
+	}
+	h.ocsp(w, der, false)
+}
+
+func (h *caHandler) ocsp(w http.ResponseWriter, der []byte, cacheable bool) {
+	resp, err := h.Authority.GetOCSPResponse(der)
+	if err != nil {
+		if sc, ok := err.(errs.StatusCoder); ok && sc.StatusCode() == http.StatusNotFound {
+			WriteError(w, err)
+			return
+		}
+		LogError(w, err)
+		writeOCSPResponse(w, ocsp.InternalErrorErrorResponse)
+		return
+	}
+
+	// Only successful responses have an update time.
+	if cacheable && !resp.NextUpdate.IsZero() {
+		now := time.Now()
+		maxAge := int64(resp.NextUpdate.Sub(now).Seconds())
+		if maxAge < 0 {
+			maxAge = 0
+		}
+		sum := sha256.Sum256(resp.Raw)
+		w.Header().Set("Cache-Control", "max-age="+strconv.FormatInt(maxAge, 10)+", public, no-transform, must-revalidate")
+		w.Header().Set("Last-Modified", resp.ThisUpdate.UTC().Format(http.TimeFormat))
+		w.Header().Set("Expires", resp.NextUpdate.UTC().Format(http.TimeFormat))
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+	}
+
+	writeOCSPResponse(w, resp.Raw)
+}
+
+// writeOCSPResponse writes the given DER encoded OCSP response. As defined in
+// RFC 6960, appendix A.1, OCSP errors are also returned with a 200 status
+// code.
+func writeOCSPResponse(w http.ResponseWriter, b []byte) {
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(b); err != nil {
+		LogError(w, err)
+	}
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/errs"
+	"golang.org/x/crypto/ocsp"
+)
+
+func Test_caHandler_OCSP(t *testing.T) {
+	now := time.Now()
+	request := []byte("fake-request")
+	response := &authority.OCSPResponse{
+		Raw:        []byte("fake-response"),
+		ThisUpdate: now,
+		NextUpdate: now.Add(time.Hour),
+	}
+
+	tests := []struct {
+		name         string
+		method       string
+		url          string
+		body         []byte
+		resp         *authority.OCSPResponse
+		err          error
+		statusCode   int
+		contentType  string
+		cacheControl bool
+		expected     []byte
+	}{
+		{"ok get", "GET", "/ocsp/" + base64.StdEncoding.EncodeToString(request), nil, response, nil, http.StatusOK, "application/ocsp-response", true, response.Raw},
+		{"ok post", "POST", "/ocsp", request, response, nil, http.StatusOK, "application/ocsp-response", false, response.Raw},
+		{"ok malformed", "GET", "/ocsp/not-base64!", nil, response, nil, http.StatusOK, "application/ocsp-response", false, ocsp.MalformedRequestErrorResponse},
+		{"ok internal error", "POST", "/ocsp", request, nil, errs.InternalServer("force"), http.StatusOK, "application/ocsp-response", false, ocsp.InternalErrorErrorResponse},
+		{"fail not enabled", "POST", "/ocsp", request, nil, errs.NotFound("not enabled"), http.StatusNotFound, "application/json", false, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := chi.NewRouter()
+			New(&mockAuthority{
+				getOCSPResponse: func(der []byte) (*authority.OCSPResponse, error) {
+					if tt.err == nil && !bytes.Equal(der, request) {
+						t.Errorf("caHandler.OCSP request = %s, wants %s", der, request)
+					}
+					return tt.resp, tt.err
+				},
+			}).Route(mux)
+
+			req := httptest.NewRequest(tt.method, "http://example.com"+tt.url, bytes.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+			res := w.Result()
+
+			if res.StatusCode != tt.statusCode {
+				t.Errorf("caHandler.OCSP StatusCode = %d, wants %d", res.StatusCode, tt.statusCode)
+			}
+			if ct := res.Header.Get("Content-Type"); ct != tt.contentType {
+				t.Errorf("caHandler.OCSP Content-Type = %s, wants %s", ct, tt.contentType)
+			}
+			if cc := res.Header.Get("Cache-Control"); (cc != "") != tt.cacheControl {
+				t.Errorf("caHandler.OCSP Cache-Control = %s, wants %v", cc, tt.cacheControl)
+			}
+
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if err != nil {
+				t.Errorf("caHandler.OCSP unexpected error = %v", err)
+			}
+			if tt.statusCode < http.StatusBadRequest {
+				if !bytes.Equal(body, tt.expected) {
+					t.Errorf("caHandler.OCSP Body = %s, wants %s", body, tt.expected)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"crypto/x509"
+	"net/http"
+	"time"
+
+	"github.com/smallstep/certificates/errs"
+	"go.step.sm/crypto/jose"
+)
+
+const (
+	// spiffeX509SVIDUse is the use of the keys in a SPIFFE bundle used to
+	// validate X509-SVIDs.
+	spiffeX509SVIDUse = "x509-svid"
+	// spiffeRefreshHint is the interval suggested to the consumers of a SPIFFE
+	// bundle to poll for updates.
+	spiffeRefreshHint = 5 * time.Minute
+)
+
+// SPIFFEBundleResponse is the response object of the SPIFFE bundle requests.
+// It is a JWK Set as defined in the SPIFFE Trust Domain and Bundle
+// specification.
+type SPIFFEBundleResponse struct {
+	Keys        []jose.JSONWebKey `json:"keys"`
+	RefreshHint int64             `json:"spiffe_refresh_hint,omitempty"`
+}
+
+// newSPIFFEBundleResponse returns a SPIFFE bundle with the given root
+// certificates.
+func newSPIFFEBundleResponse(roots []*x509.Certificate) (*SPIFFEBundleResponse, error) {
+	keys := make([]jose.JSONWebKey, len(roots))
+	for i, crt := range roots {
+		keys[i] = jose.JSONWebKey{
+			Key:          crt.PublicKey,
+			Certificates: []*x509.Certificate{crt},
+			Use:          spiffeX509SVIDUse,
+		}
+		if !keys[i].Valid() {
+			return nil, errs.InternalServer("error creating spiffe bundle: unsupported root key type %T", crt.PublicKey)
+		}
+	}
+	return &SPIFFEBundleResponse{
+		Keys:        keys,
+		RefreshHint: int64(spiffeRefreshHint / time.Second),
+	}, nil
+}
+
+// SPIFFEBundle is an HTTP handler that returns the root certificates of the CA
+// as a SPIFFE bundle, the trust bundle of the SPIFFE trust domain served by the
+// CA. Other SPIFFE trust domains can use this endpoint to federate with it.
+func (h *caHandler) SPIFFEBundle(w http.ResponseWriter, r *http.Request) {
+	roots, err := h.Authority.GetRoots()
+	if err != nil {
+		WriteError(w, errs.ForbiddenErr(err))
+		return
+	}
+	bundle, err := newSPIFFEBundleResponse(roots)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSON(w, bundle)
+}
+
+// SPIFFEFederation is an HTTP handler that returns the root and federated
+// certificates of the CA as a SPIFFE bundle.
+func (h *caHandler) SPIFFEFederation(w http.ResponseWriter, r *http.Request) {
+	federated, err := h.Authority.GetFederation()
+	if err != nil {
+		WriteError(w, errs.ForbiddenErr(err))
+		return
+	}
+	bundle, err := newSPIFFEBundleResponse(federated)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSON(w, bundle)
+}
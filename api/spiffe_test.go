@@ -0,0 +1,65 @@
+package api
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/smallstep/assert"
+)
+
+func Test_caHandler_SPIFFEBundle(t *testing.T) {
+	root := parseCertificate(rootPEM)
+	tests := []struct {
+		name       string
+		federation bool
+		certs      []*x509.Certificate
+		err        error
+		statusCode int
+	}{
+		{"ok", false, []*x509.Certificate{root}, nil, http.StatusOK},
+		{"ok federation", true, []*x509.Certificate{root}, nil, http.StatusOK},
+		{"fail", false, nil, fmt.Errorf("an error"), http.StatusForbidden},
+		{"fail federation", true, nil, fmt.Errorf("an error"), http.StatusForbidden},
+		{"fail key", false, []*x509.Certificate{{PublicKey: "not a key"}}, nil, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New(&mockAuthority{ret1: tt.certs, err: tt.err}).(*caHandler)
+			w := httptest.NewRecorder()
+			if tt.federation {
+				h.SPIFFEFederation(w, httptest.NewRequest("GET", "http://example.com/spiffe/federation", nil))
+			} else {
+				h.SPIFFEBundle(w, httptest.NewRequest("GET", "http://example.com/spiffe/bundle", nil))
+			}
+			res := w.Result()
+			defer res.Body.Close()
+			if res.StatusCode != tt.statusCode {
+				t.Errorf("caHandler.SPIFFEBundle StatusCode = %d, wants %d", res.StatusCode, tt.statusCode)
+			}
+			if tt.statusCode >= http.StatusBadRequest {
+				return
+			}
+
+			var bundle struct {
+				Keys []struct {
+					Kty string   `json:"kty"`
+					Use string   `json:"use"`
+					X5c []string `json:"x5c"`
+				} `json:"keys"`
+				RefreshHint int64 `json:"spiffe_refresh_hint"`
+			}
+			assert.FatalError(t, json.NewDecoder(res.Body).Decode(&bundle))
+			assert.Equals(t, int64(300), bundle.RefreshHint)
+			if assert.Len(t, 1, bundle.Keys) {
+				assert.Equals(t, "RSA", bundle.Keys[0].Kty)
+				assert.Equals(t, "x509-svid", bundle.Keys[0].Use)
+				assert.Equals(t, []string{base64.StdEncoding.EncodeToString(root.Raw)}, bundle.Keys[0].X5c)
+			}
+		})
+	}
+}
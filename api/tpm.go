@@ -0,0 +1,70 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/errs"
+)
+
+// TPMChallengeRequest is the request body for a TPM credential activation
+// challenge.
+type TPMChallengeRequest = provisioner.TPMChallengeRequest
+
+// TPMChallengeResponse is the response object of a TPM credential activation
+// challenge.
+type TPMChallengeResponse = provisioner.TPMChallenge
+
+// validateTPMChallengeRequest checks the fields of the TPMChallengeRequest and
+// returns nil if they are ok or an error if something is wrong.
+func validateTPMChallengeRequest(body *TPMChallengeRequest) error {
+	if len(body.EKCerts) == 0 && len(body.EKPub) == 0 {
+		return errs.BadRequest("missing ekCerts or ekPub")
+	}
+	if len(body.AKPub) == 0 {
+		return errs.BadRequest("missing akPub")
+	}
+	return nil
+}
+
+// TPMChallenge is an HTTP handler that returns a credential activation
+// challenge for the endorsement and attestation keys of a TPM. The secret
+// recovered with TPM2_ActivateCredential is used to sign the token of a TPM
+// provisioner.
+func (h *caHandler) TPMChallenge(w http.ResponseWriter, r *http.Request) {
+	var body TPMChallengeRequest
+	if err := ReadJSON(r.Body, &body); err != nil {
+		WriteError(w, errs.Wrap(http.StatusBadRequest, err, "error reading request body"))
+		return
+	}
+	if err := validateTPMChallengeRequest(&body); err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	name, err := url.PathUnescape(chi.URLParam(r, "provisioner"))
+	if err != nil {
+		WriteError(w, errs.Wrap(http.StatusBadRequest, err, "error unescaping provisioner name"))
+		return
+	}
+	p, err := h.Authority.LoadProvisionerByName(name)
+	if err != nil {
+		WriteError(w, errs.NotFoundErr(err))
+		return
+	}
+	tpm, ok := p.(*provisioner.TPM)
+	if !ok {
+		WriteError(w, errs.NotFoundErr(errors.Errorf("provisioner %s is not a TPM provisioner", name)))
+		return
+	}
+
+	ch, err := tpm.CreateChallenge(&body)
+	if err != nil {
+		WriteError(w, errs.Wrap(http.StatusInternalServerError, err, "cahandler.TPMChallenge"))
+		return
+	}
+	JSON(w, ch)
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/config"
+	"github.com/smallstep/certificates/authority/provisioner"
+)
+
+func Test_caHandler_TPMChallenge(t *testing.T) {
+	p := &provisioner.TPM{
+		Type:   "TPM",
+		Name:   "tpm",
+		EKKeys: []string{hex.EncodeToString(make([]byte, sha256.Size))},
+		// There is no database to store the challenge key.
+		ChallengeKey: base64.StdEncoding.EncodeToString(make([]byte, 32)),
+	}
+	assert.FatalError(t, p.Init(provisioner.Config{
+		Claims: config.GlobalProvisionerClaims,
+	}))
+
+	ekPub, err := x509.MarshalPKIXPublicKey(sshUserKey.Public())
+	assert.FatalError(t, err)
+
+	body := func(req *TPMChallengeRequest) []byte {
+		b, err := json.Marshal(req)
+		assert.FatalError(t, err)
+		return b
+	}
+
+	tests := []struct {
+		name       string
+		body       []byte
+		auth       Authority
+		statusCode int
+	}{
+		{"fail body", []byte("{"), &mockAuthority{}, http.StatusBadRequest},
+		{"fail missing ek", body(&TPMChallengeRequest{AKPub: []byte("ak")}), &mockAuthority{}, http.StatusBadRequest},
+		{"fail missing ak", body(&TPMChallengeRequest{EKPub: ekPub}), &mockAuthority{}, http.StatusBadRequest},
+		{"fail provisioner", body(&TPMChallengeRequest{EKPub: ekPub, AKPub: []byte("ak")}), &mockAuthority{
+			loadProvisionerByName: func(name string) (provisioner.Interface, error) {
+				return nil, fmt.Errorf("not found")
+			},
+		}, http.StatusNotFound},
+		{"fail type", body(&TPMChallengeRequest{EKPub: ekPub, AKPub: []byte("ak")}), &mockAuthority{
+			loadProvisionerByName: func(name string) (provisioner.Interface, error) {
+				return &provisioner.JWK{Name: name}, nil
+			},
+		}, http.StatusNotFound},
+		{"fail ek", body(&TPMChallengeRequest{EKPub: ekPub, AKPub: []byte("ak")}), &mockAuthority{
+			loadProvisionerByName: func(name string) (provisioner.Interface, error) {
+				return p, nil
+			},
+		}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chiCtx := chi.NewRouteContext()
+			chiCtx.URLParams.Add("provisioner", "tpm")
+			req := httptest.NewRequest("POST", "http://example.com/tpm/tpm/challenge", bytes.NewReader(tt.body))
+			req = req.WithContext(context.WithValue(context.Background(), chi.RouteCtxKey, chiCtx))
+			w := httptest.NewRecorder()
+			h := New(tt.auth).(*caHandler)
+			h.TPMChallenge(w, req)
+			res := w.Result()
+			defer res.Body.Close()
+			if res.StatusCode != tt.statusCode {
+				t.Errorf("caHandler.TPMChallenge StatusCode = %d, wants %d", res.StatusCode, tt.statusCode)
+			}
+		})
+	}
+
+	// The provisioner name is escaped in the path.
+	for param, statusCode := range map[string]int{"tpm%2Fca": http.StatusNotFound, "tpm%zz": http.StatusBadRequest} {
+		t.Run("escaped "+param, func(t *testing.T) {
+			chiCtx := chi.NewRouteContext()
+			chiCtx.URLParams.Add("provisioner", param)
+			req := httptest.NewRequest("POST", "http://example.com/tpm/tpm/challenge", bytes.NewReader(body(&TPMChallengeRequest{EKPub: ekPub, AKPub: []byte("ak")})))
+			req = req.WithContext(context.WithValue(context.Background(), chi.RouteCtxKey, chiCtx))
+			w := httptest.NewRecorder()
+			h := New(&mockAuthority{
+				loadProvisionerByName: func(name string) (provisioner.Interface, error) {
+					assert.Equals(t, "tpm/ca", name)
+					return nil, fmt.Errorf("not found")
+				},
+			}).(*caHandler)
+			h.TPMChallenge(w, req)
+			res := w.Result()
+			defer res.Body.Close()
+			if res.StatusCode != statusCode {
+				t.Errorf("caHandler.TPMChallenge StatusCode = %d, wants %d", res.StatusCode, statusCode)
+			}
+		})
+	}
+}
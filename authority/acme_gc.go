@@ -0,0 +1,128 @@
+package authority
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ACMEGarbageCollector is the interface implemented by the ACME databases that
+// can delete expired objects.
+type ACMEGarbageCollector interface {
+	// PruneExpired deletes the objects that expired before the given time,
+	// processing at most batchSize objects. It returns the number of deleted
+	// objects by type.
+	PruneExpired(ctx context.Context, before time.Time, batchSize int) (map[string]int, error)
+}
+
+// ACMEGarbageCollectionStats are the metrics of the garbage collection of
+// expired ACME objects since the authority started.
+type ACMEGarbageCollectionStats struct {
+	Runs    int64            `json:"runs"`
+	Errors  int64            `json:"errors"`
+	LastRun time.Time        `json:"lastRun,omitempty"`
+	Pruned  map[string]int64 `json:"pruned"`
+}
+
+// CollectACMEGarbage deletes the ACME objects that expired before the
+// configured retention. It does nothing if the garbage collection is not
+// enabled or started.
+func (a *Authority) CollectACMEGarbage() error {
+	cfg := a.config.ACMEGC
+	if !cfg.IsEnabled() || a.acmeGC == nil {
+		return nil
+	}
+
+	before := time.Now().UTC().Add(-cfg.Retention.Duration)
+	pruned, err := a.acmeGC.PruneExpired(context.Background(), before, cfg.BatchSize)
+
+	a.acmeGCMutex.Lock()
+	a.acmeGCStats.Runs++
+	a.acmeGCStats.LastRun = time.Now().UTC()
+	for k, v := range pruned {
+		a.acmeGCStats.Pruned[k] += int64(v)
+	}
+	if err != nil {
+		a.acmeGCStats.Errors++
+	}
+	a.acmeGCMutex.Unlock()
+
+	if len(pruned) > 0 {
+		keys := make([]string, 0, len(pruned))
+		for k := range pruned {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fields := make([]string, len(keys))
+		for i, k := range keys {
+			fields[i] = k + "=" + strconv.Itoa(pruned[k])
+		}
+		log.Printf("acme garbage collection pruned %s", strings.Join(fields, " "))
+	}
+	return errors.Wrap(err, "error pruning expired acme objects")
+}
+
+// GetACMEGarbageCollectionStats returns the metrics of the garbage collection
+// of expired ACME objects.
+func (a *Authority) GetACMEGarbageCollectionStats() ACMEGarbageCollectionStats {
+	a.acmeGCMutex.Lock()
+	defer a.acmeGCMutex.Unlock()
+	stats := a.acmeGCStats
+	stats.Pruned = make(map[string]int64, len(a.acmeGCStats.Pruned))
+	for k, v := range a.acmeGCStats.Pruned {
+		stats.Pruned[k] = v
+	}
+	return stats
+}
+
+// StartACMEGarbageCollector starts a background process that deletes the
+// expired ACME objects of the given database every configured interval. The
+// first run starts immediately. It does nothing if the garbage collection is
+// not enabled.
+func (a *Authority) StartACMEGarbageCollector(gc ACMEGarbageCollector) error {
+	if !a.config.ACMEGC.IsEnabled() {
+		return nil
+	}
+	if err := a.config.ACMEGC.Validate(); err != nil {
+		return err
+	}
+
+	a.stopACMEGarbageCollector()
+	a.acmeGC = gc
+	a.acmeGCStats = ACMEGarbageCollectionStats{Pruned: make(map[string]int64)}
+	a.acmeGCTicker = time.NewTicker(a.config.ACMEGC.Interval.Duration)
+	a.acmeGCStopper = make(chan struct{})
+	go func(ticker *time.Ticker, stop chan struct{}) {
+		collect := func() {
+			if err := a.CollectACMEGarbage(); err != nil {
+				log.Printf("error collecting acme garbage: %v", err)
+			}
+		}
+		collect()
+		for {
+			select {
+			case <-ticker.C:
+				collect()
+			case <-stop:
+				return
+			}
+		}
+	}(a.acmeGCTicker, a.acmeGCStopper)
+
+	return nil
+}
+
+// stopACMEGarbageCollector stops the background process started by
+// StartACMEGarbageCollector.
+func (a *Authority) stopACMEGarbageCollector() {
+	if a.acmeGCTicker != nil {
+		a.acmeGCTicker.Stop()
+		close(a.acmeGCStopper)
+		a.acmeGCTicker = nil
+	}
+}
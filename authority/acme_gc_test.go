@@ -0,0 +1,83 @@
+package authority
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/config"
+	"github.com/smallstep/certificates/authority/provisioner"
+)
+
+type mockACMEGarbageCollector func(ctx context.Context, before time.Time, batchSize int) (map[string]int, error)
+
+func (m mockACMEGarbageCollector) PruneExpired(ctx context.Context, before time.Time, batchSize int) (map[string]int, error) {
+	return m(ctx, before, batchSize)
+}
+
+func TestAuthority_CollectACMEGarbage(t *testing.T) {
+	a := testAuthority(t)
+	assert.FatalError(t, a.CollectACMEGarbage())
+
+	a.config.ACMEGC = &config.ACMEGCConfig{
+		Enabled:   true,
+		Retention: &provisioner.Duration{Duration: 24 * time.Hour},
+		BatchSize: 10,
+	}
+	assert.FatalError(t, a.config.ACMEGC.Validate())
+
+	var fail bool
+	a.acmeGC = mockACMEGarbageCollector(func(ctx context.Context, before time.Time, batchSize int) (map[string]int, error) {
+		assert.Equals(t, batchSize, 10)
+		assert.True(t, before.Before(time.Now().Add(-23*time.Hour)))
+		assert.True(t, before.After(time.Now().Add(-25*time.Hour)))
+		if fail {
+			return map[string]int{"orders": 1}, errors.New("force")
+		}
+		return map[string]int{"orders": 2, "nonces": 5}, nil
+	})
+	a.acmeGCStats = ACMEGarbageCollectionStats{Pruned: make(map[string]int64)}
+
+	assert.FatalError(t, a.CollectACMEGarbage())
+	fail = true
+	err := a.CollectACMEGarbage()
+	if assert.Error(t, err) {
+		assert.Equals(t, err.Error(), "error pruning expired acme objects: force")
+	}
+
+	stats := a.GetACMEGarbageCollectionStats()
+	assert.Equals(t, stats.Runs, int64(2))
+	assert.Equals(t, stats.Errors, int64(1))
+	assert.False(t, stats.LastRun.IsZero())
+	assert.Equals(t, stats.Pruned, map[string]int64{"orders": 3, "nonces": 5})
+}
+
+func TestAuthority_StartACMEGarbageCollector(t *testing.T) {
+	called := make(chan struct{}, 1)
+	gc := mockACMEGarbageCollector(func(ctx context.Context, before time.Time, batchSize int) (map[string]int, error) {
+		select {
+		case called <- struct{}{}:
+		default:
+		}
+		return map[string]int{}, nil
+	})
+
+	a := testAuthority(t)
+	assert.FatalError(t, a.StartACMEGarbageCollector(gc))
+	assert.Nil(t, a.acmeGCTicker)
+
+	a.config.ACMEGC = &config.ACMEGCConfig{Enabled: true, Interval: &provisioner.Duration{}}
+	assert.Error(t, a.StartACMEGarbageCollector(gc))
+
+	a.config.ACMEGC = &config.ACMEGCConfig{Enabled: true}
+	assert.FatalError(t, a.StartACMEGarbageCollector(gc))
+	select {
+	case <-called:
+	case <-time.After(5 * time.Second):
+		t.Fatal("garbage collector was not started")
+	}
+	a.stopACMEGarbageCollector()
+	assert.Nil(t, a.acmeGCTicker)
+}
@@ -0,0 +1,217 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/api"
+	"github.com/smallstep/certificates/authority/admin"
+	"github.com/smallstep/certificates/authority/provisioner"
+)
+
+// CreateExternalAccountKeyRequest is the type for POST /admin/acme/eab requests.
+type CreateExternalAccountKeyRequest struct {
+	Reference string `json:"reference"`
+}
+
+// Validate validates a new ACME EAB Key request body.
+func (r *CreateExternalAccountKeyRequest) Validate() error {
+	if len(r.Reference) > 256 {
+		return admin.NewError(admin.ErrorBadRequestType, "reference length %d exceeds the maximum (256)", len(r.Reference))
+	}
+	return nil
+}
+
+// ExternalAccountKey is the representation of an ACME EAB key in the
+// administration API. The HMAC key is only returned when the key is created.
+type ExternalAccountKey struct {
+	ID          string     `json:"id"`
+	Provisioner string     `json:"provisioner"`
+	Reference   string     `json:"reference,omitempty"`
+	Account     string     `json:"account,omitempty"`
+	HmacKey     []byte     `json:"hmacKey,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	BoundAt     *time.Time `json:"boundAt,omitempty"`
+}
+
+// GetExternalAccountKeysResponse is the type for GET /admin/acme/eab responses.
+type GetExternalAccountKeysResponse struct {
+	EAKs []*ExternalAccountKey `json:"eaks"`
+}
+
+func newExternalAccountKey(prov *provisioner.ACME, eak *acme.ExternalAccountKey) *ExternalAccountKey {
+	res := &ExternalAccountKey{
+		ID:          eak.ID,
+		Provisioner: prov.GetName(),
+		Reference:   eak.Reference,
+		Account:     eak.AccountID,
+		CreatedAt:   eak.CreatedAt,
+	}
+	if eak.AlreadyBound() {
+		boundAt := eak.BoundAt
+		res.BoundAt = &boundAt
+	}
+	return res
+}
+
+// requireACMEProvisioner is a middleware that ensures the provisioner in the
+// URL is an ACME provisioner before servicing requests. The ACME provisioner
+// is stored in the request context.
+func (h *Handler) requireACMEProvisioner(next nextHTTP) nextHTTP {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.acmeDB == nil {
+			api.WriteError(w, admin.NewError(admin.ErrorNotImplementedType, "ACME database not configured"))
+			return
+		}
+
+		name := chi.URLParam(r, "prov")
+		p, err := h.auth.LoadProvisionerByName(name)
+		if err != nil {
+			api.WriteError(w, admin.WrapError(admin.ErrorNotFoundType, err, "error loading provisioner %s", name))
+			return
+		}
+		acmeProv, ok := p.(*provisioner.ACME)
+		if !ok {
+			api.WriteError(w, admin.NewError(admin.ErrorBadRequestType, "provisioner %s is not an ACME provisioner", name))
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), acmeProvisionerContextKey, acmeProv)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// requireEABEnabled is a middleware that ensures ACME EAB is enabled for the
+// provisioner in the URL before servicing requests. The ACME provisioner is
+// stored in the request context.
+func (h *Handler) requireEABEnabled(next nextHTTP) nextHTTP {
+	return h.requireACMEProvisioner(func(w http.ResponseWriter, r *http.Request) {
+		acmeProv, err := acmeProvisionerFromContext(r.Context())
+		if err != nil {
+			api.WriteError(w, err)
+			return
+		}
+		if !acmeProv.RequireEAB {
+			api.WriteError(w, admin.NewError(admin.ErrorBadRequestType, "ACME EAB not enabled for provisioner %s", acmeProv.GetName()))
+			return
+		}
+		next(w, r)
+	})
+}
+
+// acmeProvisionerFromContext returns the ACME provisioner stored by the
+// requireACMEProvisioner middleware.
+func acmeProvisionerFromContext(ctx context.Context) (*provisioner.ACME, error) {
+	p, ok := ctx.Value(acmeProvisionerContextKey).(*provisioner.ACME)
+	if !ok || p == nil {
+		return nil, admin.NewErrorISE("ACME provisioner expected in request context")
+	}
+	return p, nil
+}
+
+// GetExternalAccountKeys returns the ACME EAB keys of a provisioner. The keys
+// can be filtered by reference using the reference query parameter.
+func (h *Handler) GetExternalAccountKeys(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	prov, err := acmeProvisionerFromContext(ctx)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+
+	var keys []*acme.ExternalAccountKey
+	if reference := r.URL.Query().Get("reference"); reference != "" {
+		eak, err := h.acmeDB.GetExternalAccountKeyByReference(ctx, prov.GetID(), reference)
+		switch {
+		case errors.Is(err, acme.ErrNotFound):
+			keys = []*acme.ExternalAccountKey{}
+		case err != nil:
+			api.WriteError(w, admin.WrapErrorISE(err, "error retrieving external account key with reference %s", reference))
+			return
+		default:
+			keys = []*acme.ExternalAccountKey{eak}
+		}
+	} else {
+		if keys, err = h.acmeDB.GetExternalAccountKeys(ctx, prov.GetID()); err != nil {
+			api.WriteError(w, admin.WrapErrorISE(err, "error retrieving external account keys"))
+			return
+		}
+	}
+
+	eaks := make([]*ExternalAccountKey, len(keys))
+	for i, k := range keys {
+		eaks[i] = newExternalAccountKey(prov, k)
+	}
+	api.JSON(w, &GetExternalAccountKeysResponse{
+		EAKs: eaks,
+	})
+}
+
+// CreateExternalAccountKey creates a new ACME EAB key for a provisioner. This
+// is the only response where the HMAC key is returned.
+func (h *Handler) CreateExternalAccountKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	prov, err := acmeProvisionerFromContext(ctx)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+
+	var body CreateExternalAccountKeyRequest
+	if err := api.ReadJSON(r.Body, &body); err != nil {
+		api.WriteError(w, admin.WrapError(admin.ErrorBadRequestType, err, "error reading request body"))
+		return
+	}
+	if err := body.Validate(); err != nil {
+		api.WriteError(w, err)
+		return
+	}
+
+	if body.Reference != "" {
+		_, err := h.acmeDB.GetExternalAccountKeyByReference(ctx, prov.GetID(), body.Reference)
+		switch {
+		case err == nil:
+			api.WriteError(w, admin.NewError(admin.ErrorBadRequestType, "an ACME EAB key for provisioner %s with reference %s already exists", prov.GetName(), body.Reference))
+			return
+		case !errors.Is(err, acme.ErrNotFound):
+			api.WriteError(w, admin.WrapErrorISE(err, "error looking up external account key with reference %s", body.Reference))
+			return
+		}
+	}
+
+	eak, err := h.acmeDB.CreateExternalAccountKey(ctx, prov.GetID(), body.Reference)
+	if err != nil {
+		api.WriteError(w, admin.WrapErrorISE(err, "error creating external account key for provisioner %s", prov.GetName()))
+		return
+	}
+
+	res := newExternalAccountKey(prov, eak)
+	res.HmacKey = eak.KeyBytes
+	api.JSONStatus(w, res, http.StatusCreated)
+}
+
+// DeleteExternalAccountKey deletes an ACME EAB key.
+func (h *Handler) DeleteExternalAccountKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	prov, err := acmeProvisionerFromContext(ctx)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	if err := h.acmeDB.DeleteExternalAccountKey(ctx, prov.GetID(), id); err != nil {
+		if errors.Is(err, acme.ErrNotFound) {
+			api.WriteError(w, admin.NewError(admin.ErrorNotFoundType, "external account key %s not found", id))
+			return
+		}
+		api.WriteError(w, admin.WrapErrorISE(err, "error deleting external account key %s", id))
+		return
+	}
+
+	api.JSON(w, &DeleteResponse{Status: "ok"})
+}
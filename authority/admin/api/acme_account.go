@@ -0,0 +1,358 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/api"
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/authority/admin"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	// DefaultACMEAccountsLimit is the default limit for listing ACME accounts.
+	DefaultACMEAccountsLimit = 20
+	// DefaultACMEAccountsMax is the maximum limit for listing ACME accounts.
+	DefaultACMEAccountsMax = 100
+)
+
+// ACMEAccount is the representation of an ACME account in the administration
+// API. The orders and certificates are only returned when a single account is
+// requested.
+type ACMEAccount struct {
+	ID           string             `json:"id"`
+	Provisioner  string             `json:"provisioner"`
+	Status       acme.Status        `json:"status"`
+	Contact      []string           `json:"contact,omitempty"`
+	CreatedAt    *time.Time         `json:"createdAt,omitempty"`
+	Orders       []*ACMEOrder       `json:"orders,omitempty"`
+	Certificates []*ACMECertificate `json:"certificates,omitempty"`
+}
+
+// ACMEOrder is the representation of an ACME order in the administration API.
+type ACMEOrder struct {
+	ID            string            `json:"id"`
+	Status        acme.Status       `json:"status"`
+	Identifiers   []acme.Identifier `json:"identifiers"`
+	Profile       string            `json:"profile,omitempty"`
+	NotBefore     time.Time         `json:"notBefore"`
+	NotAfter      time.Time         `json:"notAfter"`
+	ExpiresAt     time.Time         `json:"expiresAt"`
+	CertificateID string            `json:"certificate,omitempty"`
+	Error         *acme.Error       `json:"error,omitempty"`
+}
+
+// ACMECertificate is the representation of a certificate issued to an ACME
+// account in the administration API.
+type ACMECertificate struct {
+	ID             string    `json:"id"`
+	OrderID        string    `json:"order"`
+	Serial         string    `json:"serial"`
+	Subject        string    `json:"subject"`
+	DNSNames       []string  `json:"dnsNames,omitempty"`
+	IPAddresses    []string  `json:"ipAddresses,omitempty"`
+	EmailAddresses []string  `json:"emailAddresses,omitempty"`
+	NotBefore      time.Time `json:"notBefore"`
+	NotAfter       time.Time `json:"notAfter"`
+	Revoked        bool      `json:"revoked"`
+}
+
+// GetACMEAccountsResponse is the type for GET /admin/acme/accounts responses.
+type GetACMEAccountsResponse struct {
+	Accounts   []*ACMEAccount `json:"accounts"`
+	NextCursor string         `json:"nextCursor"`
+}
+
+// RevokeACMEAccountCertificatesRequest is the type for POST
+// /admin/acme/accounts/{prov}/{id}/revoke requests.
+type RevokeACMEAccountCertificatesRequest struct {
+	ReasonCode int    `json:"reasonCode"`
+	Reason     string `json:"reason"`
+}
+
+// Validate validates a revoke ACME account certificates request body.
+func (r *RevokeACMEAccountCertificatesRequest) Validate() error {
+	if r.ReasonCode < ocsp.Unspecified || r.ReasonCode > ocsp.AACompromise {
+		return admin.NewError(admin.ErrorBadRequestType, "reasonCode out of bounds")
+	}
+	return nil
+}
+
+// RevokeACMEAccountCertificatesResponse is the type for POST
+// /admin/acme/accounts/{prov}/{id}/revoke responses. It contains the serial
+// numbers of the certificates revoked by the request and of the ones that were
+// already revoked.
+type RevokeACMEAccountCertificatesResponse struct {
+	Revoked        []string `json:"revoked"`
+	AlreadyRevoked []string `json:"alreadyRevoked"`
+}
+
+func newACMEAccount(prov *provisioner.ACME, acc *acme.Account) *ACMEAccount {
+	res := &ACMEAccount{
+		ID:          acc.ID,
+		Provisioner: prov.GetName(),
+		Status:      acc.Status,
+		Contact:     acc.Contact,
+	}
+	if !acc.CreatedAt.IsZero() {
+		createdAt := acc.CreatedAt
+		res.CreatedAt = &createdAt
+	}
+	return res
+}
+
+func newACMEOrder(o *acme.Order) *ACMEOrder {
+	return &ACMEOrder{
+		ID:            o.ID,
+		Status:        o.Status,
+		Identifiers:   o.Identifiers,
+		Profile:       o.Profile,
+		NotBefore:     o.NotBefore,
+		NotAfter:      o.NotAfter,
+		ExpiresAt:     o.ExpiresAt,
+		CertificateID: o.CertificateID,
+		Error:         o.Error,
+	}
+}
+
+func newACMECertificate(cert *acme.Certificate, revoked bool) *ACMECertificate {
+	res := &ACMECertificate{
+		ID:             cert.ID,
+		OrderID:        cert.OrderID,
+		Serial:         cert.Leaf.SerialNumber.String(),
+		Subject:        cert.Leaf.Subject.String(),
+		DNSNames:       cert.Leaf.DNSNames,
+		EmailAddresses: cert.Leaf.EmailAddresses,
+		NotBefore:      cert.Leaf.NotBefore,
+		NotAfter:       cert.Leaf.NotAfter,
+		Revoked:        revoked,
+	}
+	for _, ip := range cert.Leaf.IPAddresses {
+		res.IPAddresses = append(res.IPAddresses, ip.String())
+	}
+	return res
+}
+
+// accountBelongsTo returns true if the account was created using the given
+// provisioner. Accounts created before the provisioner was stored with them
+// belong to every ACME provisioner.
+func accountBelongsTo(acc *acme.Account, prov *provisioner.ACME) bool {
+	return acc.ProvisionerID == "" || acc.ProvisionerID == prov.GetID()
+}
+
+// accountMatches returns true if the account has the given status and a
+// contact containing the given string, empty values match any account.
+func accountMatches(acc *acme.Account, status, contact string) bool {
+	if status != "" && string(acc.Status) != status {
+		return false
+	}
+	if contact == "" {
+		return true
+	}
+	contact = strings.ToLower(contact)
+	for _, c := range acc.Contact {
+		if strings.Contains(strings.ToLower(c), contact) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadACMEAccount returns the account in the URL if it belongs to the ACME
+// provisioner in the request context.
+func (h *Handler) loadACMEAccount(ctx context.Context, r *http.Request) (*provisioner.ACME, *acme.Account, error) {
+	prov, err := acmeProvisionerFromContext(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	id := chi.URLParam(r, "id")
+	acc, err := h.acmeDB.GetAccount(ctx, id)
+	switch {
+	case errors.Is(err, acme.ErrNotFound):
+		return nil, nil, admin.NewError(admin.ErrorNotFoundType, "ACME account %s not found", id)
+	case err != nil:
+		return nil, nil, admin.WrapErrorISE(err, "error retrieving ACME account %s", id)
+	case !accountBelongsTo(acc, prov):
+		return nil, nil, admin.NewError(admin.ErrorNotFoundType, "ACME account %s not found", id)
+	}
+	return prov, acc, nil
+}
+
+// GetACMEAccounts returns a segment of the ACME accounts of a provisioner. The
+// accounts can be filtered by contact, using a case-insensitive substring
+// match, and by status using the contact and status query parameters.
+func (h *Handler) GetACMEAccounts(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	prov, err := acmeProvisionerFromContext(ctx)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+
+	cursor, limit, err := api.ParseCursor(r)
+	if err != nil {
+		api.WriteError(w, admin.WrapError(admin.ErrorBadRequestType, err,
+			"error parsing cursor and limit from query params"))
+		return
+	}
+	switch {
+	case limit <= 0:
+		limit = DefaultACMEAccountsLimit
+	case limit > DefaultACMEAccountsMax:
+		limit = DefaultACMEAccountsMax
+	}
+
+	accounts, err := h.acmeDB.GetAccounts(ctx, prov.GetID())
+	if err != nil {
+		api.WriteError(w, admin.WrapErrorISE(err, "error retrieving ACME accounts"))
+		return
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].ID < accounts[j].ID
+	})
+
+	q := r.URL.Query()
+	status, contact := q.Get("status"), q.Get("contact")
+	res := &GetACMEAccountsResponse{
+		Accounts: []*ACMEAccount{},
+	}
+	for _, acc := range accounts {
+		if acc.ID < cursor || !accountMatches(acc, status, contact) {
+			continue
+		}
+		if len(res.Accounts) == limit {
+			res.NextCursor = acc.ID
+			break
+		}
+		res.Accounts = append(res.Accounts, newACMEAccount(prov, acc))
+	}
+	api.JSON(w, res)
+}
+
+// GetACMEAccount returns an ACME account with all its orders and the
+// certificates issued to it.
+func (h *Handler) GetACMEAccount(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	prov, acc, err := h.loadACMEAccount(ctx, r)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+
+	orders, err := h.acmeDB.GetAccountOrders(ctx, acc.ID)
+	if err != nil {
+		api.WriteError(w, admin.WrapErrorISE(err, "error retrieving orders for ACME account %s", acc.ID))
+		return
+	}
+	certs, err := h.acmeDB.GetAccountCertificates(ctx, acc.ID)
+	if err != nil {
+		api.WriteError(w, admin.WrapErrorISE(err, "error retrieving certificates for ACME account %s", acc.ID))
+		return
+	}
+
+	res := newACMEAccount(prov, acc)
+	res.Orders = make([]*ACMEOrder, len(orders))
+	for i, o := range orders {
+		res.Orders[i] = newACMEOrder(o)
+	}
+	res.Certificates = make([]*ACMECertificate, len(certs))
+	for i, cert := range certs {
+		revoked, err := h.auth.IsRevoked(cert.Leaf.SerialNumber.String())
+		if err != nil {
+			api.WriteError(w, admin.WrapErrorISE(err, "error checking revocation status of certificate %s", cert.ID))
+			return
+		}
+		res.Certificates[i] = newACMECertificate(cert, revoked)
+	}
+	api.JSON(w, res)
+}
+
+// DeactivateACMEAccount deactivates an ACME account. A deactivated account
+// cannot be used to create new orders or to revoke certificates, the
+// certificates already issued to it are not revoked.
+func (h *Handler) DeactivateACMEAccount(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	prov, acc, err := h.loadACMEAccount(ctx, r)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+
+	if acc.Status != acme.StatusDeactivated {
+		acc.Status = acme.StatusDeactivated
+		if err := h.acmeDB.UpdateAccount(ctx, acc); err != nil {
+			api.WriteError(w, admin.WrapErrorISE(err, "error deactivating ACME account %s", acc.ID))
+			return
+		}
+	}
+
+	api.JSON(w, newACMEAccount(prov, acc))
+}
+
+// RevokeACMEAccountCertificates revokes all the certificates issued to an ACME
+// account. Certificates already revoked are skipped.
+func (h *Handler) RevokeACMEAccountCertificates(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	_, acc, err := h.loadACMEAccount(ctx, r)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+
+	var body RevokeACMEAccountCertificatesRequest
+	if r.ContentLength != 0 {
+		if err := api.ReadJSON(r.Body, &body); err != nil {
+			api.WriteError(w, admin.WrapError(admin.ErrorBadRequestType, err, "error reading request body"))
+			return
+		}
+	}
+	if err := body.Validate(); err != nil {
+		api.WriteError(w, err)
+		return
+	}
+
+	certs, err := h.acmeDB.GetAccountCertificates(ctx, acc.ID)
+	if err != nil {
+		api.WriteError(w, admin.WrapErrorISE(err, "error retrieving certificates for ACME account %s", acc.ID))
+		return
+	}
+
+	res := &RevokeACMEAccountCertificatesResponse{
+		Revoked:        []string{},
+		AlreadyRevoked: []string{},
+	}
+	ctx = provisioner.NewContextWithMethod(ctx, provisioner.RevokeMethod)
+	for _, cert := range certs {
+		serial := cert.Leaf.SerialNumber.String()
+		revoked, err := h.auth.IsRevoked(serial)
+		if err != nil {
+			api.WriteError(w, admin.WrapErrorISE(err, "error checking revocation status of certificate %s", cert.ID))
+			return
+		}
+		if revoked {
+			res.AlreadyRevoked = append(res.AlreadyRevoked, serial)
+			continue
+		}
+		if err := h.auth.Revoke(ctx, &authority.RevokeOptions{
+			Serial:     serial,
+			Reason:     body.Reason,
+			ReasonCode: body.ReasonCode,
+			ACME:       true,
+			Crt:        cert.Leaf,
+		}); err != nil {
+			api.WriteError(w, admin.WrapErrorISE(err, "error revoking certificate %s", serial))
+			return
+		}
+		res.Revoked = append(res.Revoked, serial)
+	}
+	api.JSON(w, res)
+}
@@ -0,0 +1,13 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/smallstep/certificates/api"
+)
+
+// GetACMEGarbageCollectionStats returns the metrics of the garbage collection
+// of expired ACME objects since the authority started.
+func (h *Handler) GetACMEGarbageCollectionStats(w http.ResponseWriter, r *http.Request) {
+	api.JSON(w, h.auth.GetACMEGarbageCollectionStats())
+}
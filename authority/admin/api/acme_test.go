@@ -0,0 +1,90 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/authority/admin"
+	"github.com/smallstep/certificates/authority/config"
+	"github.com/smallstep/certificates/authority/policy"
+	"go.step.sm/linkedca"
+)
+
+func TestHandler_CreateExternalAccountKey_adminProvisioner(t *testing.T) {
+	acmeProv := &linkedca.Provisioner{
+		Id:   "acme-id",
+		Type: linkedca.Provisioner_ACME,
+		Name: "acme",
+		Details: &linkedca.ProvisionerDetails{
+			Data: &linkedca.ProvisionerDetails_ACME{
+				ACME: &linkedca.ACMEProvisioner{},
+			},
+		},
+	}
+	adminDB := &admin.MockDB{
+		MockGetProvisioners: func(ctx context.Context) ([]*linkedca.Provisioner, error) {
+			return []*linkedca.Provisioner{acmeProv}, nil
+		},
+		MockGetAdmins: func(ctx context.Context) ([]*linkedca.Admin, error) {
+			return []*linkedca.Admin{}, nil
+		},
+		MockGetPolicies: func(ctx context.Context) (map[string]*policy.Options, error) {
+			return map[string]*policy.Options{}, nil
+		},
+		MockGetAllProvisionerAttributes: func(ctx context.Context) (map[string]json.RawMessage, error) {
+			return map[string]json.RawMessage{
+				"acme-id": json.RawMessage(`{"requireEAB":true}`),
+			}, nil
+		},
+	}
+	auth, err := authority.New(&config.Config{
+		Address:          "127.0.0.1:443",
+		Root:             []string{"../../testdata/certs/root_ca.crt"},
+		IntermediateCert: "../../testdata/certs/intermediate_ca.crt",
+		IntermediateKey:  "../../testdata/secrets/intermediate_ca_key",
+		DNSNames:         []string{"example.com"},
+		Password:         "pass",
+		AuthorityConfig: &config.AuthConfig{
+			EnableAdmin: true,
+		},
+	}, authority.WithAdminDB(adminDB))
+	assert.FatalError(t, err)
+
+	createdAt := time.Now()
+	h := &Handler{db: adminDB, auth: auth, acmeDB: &acme.MockDB{
+		MockCreateExternalAccountKey: func(ctx context.Context, provisionerID, reference string) (*acme.ExternalAccountKey, error) {
+			assert.Equals(t, "acme-id", provisionerID)
+			return &acme.ExternalAccountKey{
+				ID:            "eak-id",
+				ProvisionerID: provisionerID,
+				KeyBytes:      []byte("the-hmac-key"),
+				CreatedAt:     createdAt,
+			}, nil
+		},
+	}}
+
+	chiCtx := chi.NewRouteContext()
+	chiCtx.URLParams.Add("prov", "acme")
+	req := httptest.NewRequest("POST", "/admin/acme/eab/acme", bytes.NewReader([]byte("{}")))
+	req = req.WithContext(context.WithValue(context.Background(), chi.RouteCtxKey, chiCtx))
+	w := httptest.NewRecorder()
+	h.requireEABEnabled(h.CreateExternalAccountKey)(w, req)
+	res := w.Result()
+	defer res.Body.Close()
+	assert.Equals(t, http.StatusCreated, res.StatusCode)
+
+	var eak ExternalAccountKey
+	assert.FatalError(t, json.NewDecoder(res.Body).Decode(&eak))
+	assert.Equals(t, "eak-id", eak.ID)
+	assert.Equals(t, "acme", eak.Provisioner)
+	assert.Equals(t, []byte("the-hmac-key"), eak.HmacKey)
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"github.com/smallstep/certificates/acme"
 	"github.com/smallstep/certificates/api"
 	"github.com/smallstep/certificates/authority"
 	"github.com/smallstep/certificates/authority/admin"
@@ -8,13 +9,14 @@ import (
 
 // Handler is the ACME API request handler.
 type Handler struct {
-	db   admin.DB
-	auth *authority.Authority
+	db     admin.DB
+	auth   *authority.Authority
+	acmeDB acme.DB
 }
 
 // NewHandler returns a new Authority Config Handler.
-func NewHandler(auth *authority.Authority) api.RouterHandler {
-	h := &Handler{db: auth.GetAdminDatabase(), auth: auth}
+func NewHandler(auth *authority.Authority, acmeDB acme.DB) api.RouterHandler {
+	h := &Handler{db: auth.GetAdminDatabase(), auth: auth, acmeDB: acmeDB}
 
 	return h
 }
@@ -31,6 +33,17 @@ func (h *Handler) Route(r api.Router) {
 	r.MethodFunc("POST", "/provisioners", authnz(h.CreateProvisioner))
 	r.MethodFunc("PUT", "/provisioners/{name}", authnz(h.UpdateProvisioner))
 	r.MethodFunc("DELETE", "/provisioners/{name}", authnz(h.DeleteProvisioner))
+	r.MethodFunc("GET", "/provisioners/{name}/attributes", authnz(h.GetProvisionerAttributes))
+	r.MethodFunc("PUT", "/provisioners/{name}/attributes", authnz(h.UpdateProvisionerAttributes))
+	r.MethodFunc("DELETE", "/provisioners/{name}/attributes", authnz(h.DeleteProvisionerAttributes))
+
+	// Name-constraint policies
+	r.MethodFunc("GET", "/policy", authnz(h.GetAuthorityPolicy))
+	r.MethodFunc("PUT", "/policy", authnz(h.UpdateAuthorityPolicy))
+	r.MethodFunc("DELETE", "/policy", authnz(h.DeleteAuthorityPolicy))
+	r.MethodFunc("GET", "/provisioners/{name}/policy", authnz(h.GetProvisionerPolicy))
+	r.MethodFunc("PUT", "/provisioners/{name}/policy", authnz(h.UpdateProvisionerPolicy))
+	r.MethodFunc("DELETE", "/provisioners/{name}/policy", authnz(h.DeleteProvisionerPolicy))
 
 	// Admins
 	r.MethodFunc("GET", "/admins/{id}", authnz(h.GetAdmin))
@@ -38,4 +51,18 @@ func (h *Handler) Route(r api.Router) {
 	r.MethodFunc("POST", "/admins", authnz(h.CreateAdmin))
 	r.MethodFunc("PATCH", "/admins/{id}", authnz(h.UpdateAdmin))
 	r.MethodFunc("DELETE", "/admins/{id}", authnz(h.DeleteAdmin))
+
+	// ACME External Account Binding Keys
+	r.MethodFunc("GET", "/acme/eab/{prov}", authnz(h.requireEABEnabled(h.GetExternalAccountKeys)))
+	r.MethodFunc("POST", "/acme/eab/{prov}", authnz(h.requireEABEnabled(h.CreateExternalAccountKey)))
+	r.MethodFunc("DELETE", "/acme/eab/{prov}/{id}", authnz(h.requireEABEnabled(h.DeleteExternalAccountKey)))
+
+	// ACME Accounts
+	r.MethodFunc("GET", "/acme/accounts/{prov}", authnz(h.requireACMEProvisioner(h.GetACMEAccounts)))
+	r.MethodFunc("GET", "/acme/accounts/{prov}/{id}", authnz(h.requireACMEProvisioner(h.GetACMEAccount)))
+	r.MethodFunc("POST", "/acme/accounts/{prov}/{id}/deactivate", authnz(h.requireACMEProvisioner(h.DeactivateACMEAccount)))
+	r.MethodFunc("POST", "/acme/accounts/{prov}/{id}/revoke", authnz(h.requireACMEProvisioner(h.RevokeACMEAccountCertificates)))
+
+	// ACME garbage collection
+	r.MethodFunc("GET", "/acme/gc", authnz(h.GetACMEGarbageCollectionStats))
 }
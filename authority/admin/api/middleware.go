@@ -51,4 +51,6 @@ type ContextKey string
 const (
 	// adminContextKey account key
 	adminContextKey = ContextKey("admin")
+	// acmeProvisionerContextKey ACME provisioner key
+	acmeProvisionerContextKey = ContextKey("acmeProvisioner")
 )
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/smallstep/certificates/api"
+	"github.com/smallstep/certificates/authority/admin"
+	"github.com/smallstep/certificates/authority/policy"
+)
+
+// GetAuthorityPolicy returns the name-constraint policy of the authority.
+func (h *Handler) GetAuthorityPolicy(w http.ResponseWriter, r *http.Request) {
+	pol := h.auth.GetAuthorityPolicy()
+	if pol == nil {
+		api.WriteError(w, admin.NewError(admin.ErrorNotFoundType, "authority policy does not exist"))
+		return
+	}
+	api.JSON(w, pol)
+}
+
+// UpdateAuthorityPolicy creates or replaces the name-constraint policy of the
+// authority.
+func (h *Handler) UpdateAuthorityPolicy(w http.ResponseWriter, r *http.Request) {
+	var pol = new(policy.Options)
+	if err := api.ReadJSON(r.Body, pol); err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	if err := h.auth.UpdateAuthorityPolicy(r.Context(), pol); err != nil {
+		api.WriteError(w, admin.WrapErrorISE(err, "error updating authority policy"))
+		return
+	}
+	api.JSON(w, pol)
+}
+
+// DeleteAuthorityPolicy deletes the name-constraint policy of the authority.
+func (h *Handler) DeleteAuthorityPolicy(w http.ResponseWriter, r *http.Request) {
+	if err := h.auth.RemoveAuthorityPolicy(r.Context()); err != nil {
+		api.WriteError(w, admin.WrapErrorISE(err, "error deleting authority policy"))
+		return
+	}
+	api.JSON(w, &DeleteResponse{Status: "ok"})
+}
+
+// GetProvisionerPolicy returns the name-constraint policy of a provisioner.
+func (h *Handler) GetProvisionerPolicy(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	p, err := h.auth.LoadProvisionerByName(name)
+	if err != nil {
+		api.WriteError(w, admin.WrapError(admin.ErrorNotFoundType, err, "error loading provisioner %s", name))
+		return
+	}
+
+	pol, err := h.auth.GetProvisionerPolicy(r.Context(), p.GetID())
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	if pol == nil {
+		api.WriteError(w, admin.NewError(admin.ErrorNotFoundType, "provisioner %s does not have a policy", name))
+		return
+	}
+	api.JSON(w, pol)
+}
+
+// UpdateProvisionerPolicy creates or replaces the name-constraint policy of a
+// provisioner.
+func (h *Handler) UpdateProvisionerPolicy(w http.ResponseWriter, r *http.Request) {
+	var pol = new(policy.Options)
+	if err := api.ReadJSON(r.Body, pol); err != nil {
+		api.WriteError(w, err)
+		return
+	}
+
+	name := chi.URLParam(r, "name")
+	p, err := h.auth.LoadProvisionerByName(name)
+	if err != nil {
+		api.WriteError(w, admin.WrapError(admin.ErrorNotFoundType, err, "error loading provisioner %s", name))
+		return
+	}
+
+	if err := h.auth.UpdateProvisionerPolicy(r.Context(), p.GetID(), pol); err != nil {
+		api.WriteError(w, admin.WrapErrorISE(err, "error updating policy of provisioner %s", name))
+		return
+	}
+	api.JSON(w, pol)
+}
+
+// DeleteProvisionerPolicy deletes the name-constraint policy of a
+// provisioner.
+func (h *Handler) DeleteProvisionerPolicy(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	p, err := h.auth.LoadProvisionerByName(name)
+	if err != nil {
+		api.WriteError(w, admin.WrapError(admin.ErrorNotFoundType, err, "error loading provisioner %s", name))
+		return
+	}
+
+	if err := h.auth.RemoveProvisionerPolicy(r.Context(), p.GetID()); err != nil {
+		api.WriteError(w, admin.WrapErrorISE(err, "error deleting policy of provisioner %s", name))
+		return
+	}
+	api.JSON(w, &DeleteResponse{Status: "ok"})
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/smallstep/certificates/api"
+	"github.com/smallstep/certificates/authority/admin"
+)
+
+// GetProvisionerAttributes returns the attributes of a provisioner that are
+// not part of the linkedca provisioner type.
+func (h *Handler) GetProvisionerAttributes(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	p, err := h.auth.LoadProvisionerByName(name)
+	if err != nil {
+		api.WriteError(w, admin.WrapError(admin.ErrorNotFoundType, err, "error loading provisioner %s", name))
+		return
+	}
+
+	attrs, err := h.auth.GetProvisionerAttributes(r.Context(), p.GetID())
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	if attrs == nil {
+		api.WriteError(w, admin.NewError(admin.ErrorNotFoundType, "provisioner %s does not have attributes", name))
+		return
+	}
+	api.JSON(w, attrs)
+}
+
+// UpdateProvisionerAttributes creates or replaces the attributes of a
+// provisioner that are not part of the linkedca provisioner type.
+func (h *Handler) UpdateProvisionerAttributes(w http.ResponseWriter, r *http.Request) {
+	var attrs json.RawMessage
+	if err := api.ReadJSON(r.Body, &attrs); err != nil {
+		api.WriteError(w, err)
+		return
+	}
+
+	name := chi.URLParam(r, "name")
+	p, err := h.auth.LoadProvisionerByName(name)
+	if err != nil {
+		api.WriteError(w, admin.WrapError(admin.ErrorNotFoundType, err, "error loading provisioner %s", name))
+		return
+	}
+
+	if err := h.auth.UpdateProvisionerAttributes(r.Context(), p.GetID(), attrs); err != nil {
+		api.WriteError(w, admin.WrapErrorISE(err, "error updating attributes of provisioner %s", name))
+		return
+	}
+	api.JSON(w, attrs)
+}
+
+// DeleteProvisionerAttributes deletes the attributes of a provisioner that are
+// not part of the linkedca provisioner type.
+func (h *Handler) DeleteProvisionerAttributes(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	p, err := h.auth.LoadProvisionerByName(name)
+	if err != nil {
+		api.WriteError(w, admin.WrapError(admin.ErrorNotFoundType, err, "error loading provisioner %s", name))
+		return
+	}
+
+	if err := h.auth.RemoveProvisionerAttributes(r.Context(), p.GetID()); err != nil {
+		api.WriteError(w, admin.WrapErrorISE(err, "error deleting attributes of provisioner %s", name))
+		return
+	}
+	api.JSON(w, &DeleteResponse{Status: "ok"})
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 
 	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/policy"
 	"go.step.sm/linkedca"
 )
 
@@ -14,6 +15,11 @@ const (
 	// of the first Authority created, as well as the default AuthorityID
 	// if one is not specified in the configuration.
 	DefaultAuthorityID = "00000000-0000-0000-0000-000000000000"
+
+	// AuthorityPolicyID is the id used to store the name-constraint policy of
+	// the authority. The policies of the provisioners are stored using the id
+	// of the provisioner.
+	AuthorityPolicyID = "authority"
 )
 
 // ErrNotFound is an error that should be used by the authority.DB interface to
@@ -67,6 +73,16 @@ type DB interface {
 	GetAdmins(ctx context.Context) ([]*linkedca.Admin, error)
 	UpdateAdmin(ctx context.Context, admin *linkedca.Admin) error
 	DeleteAdmin(ctx context.Context, id string) error
+
+	GetPolicy(ctx context.Context, id string) (*policy.Options, error)
+	GetPolicies(ctx context.Context) (map[string]*policy.Options, error)
+	UpdatePolicy(ctx context.Context, id string, pol *policy.Options) error
+	DeletePolicy(ctx context.Context, id string) error
+
+	GetProvisionerAttributes(ctx context.Context, id string) (json.RawMessage, error)
+	GetAllProvisionerAttributes(ctx context.Context) (map[string]json.RawMessage, error)
+	UpdateProvisionerAttributes(ctx context.Context, id string, attrs json.RawMessage) error
+	DeleteProvisionerAttributes(ctx context.Context, id string) error
 }
 
 // MockDB is an implementation of the DB interface that should only be used as
@@ -84,6 +100,16 @@ type MockDB struct {
 	MockUpdateAdmin func(ctx context.Context, adm *linkedca.Admin) error
 	MockDeleteAdmin func(ctx context.Context, id string) error
 
+	MockGetPolicy    func(ctx context.Context, id string) (*policy.Options, error)
+	MockGetPolicies  func(ctx context.Context) (map[string]*policy.Options, error)
+	MockUpdatePolicy func(ctx context.Context, id string, pol *policy.Options) error
+	MockDeletePolicy func(ctx context.Context, id string) error
+
+	MockGetProvisionerAttributes    func(ctx context.Context, id string) (json.RawMessage, error)
+	MockGetAllProvisionerAttributes func(ctx context.Context) (map[string]json.RawMessage, error)
+	MockUpdateProvisionerAttributes func(ctx context.Context, id string, attrs json.RawMessage) error
+	MockDeleteProvisionerAttributes func(ctx context.Context, id string) error
+
 	MockError error
 	MockRet1  interface{}
 }
@@ -177,3 +203,75 @@ func (m *MockDB) DeleteAdmin(ctx context.Context, id string) error {
 	}
 	return m.MockError
 }
+
+// GetPolicy mock.
+func (m *MockDB) GetPolicy(ctx context.Context, id string) (*policy.Options, error) {
+	if m.MockGetPolicy != nil {
+		return m.MockGetPolicy(ctx, id)
+	} else if m.MockError != nil {
+		return nil, m.MockError
+	}
+	return m.MockRet1.(*policy.Options), m.MockError
+}
+
+// GetPolicies mock
+func (m *MockDB) GetPolicies(ctx context.Context) (map[string]*policy.Options, error) {
+	if m.MockGetPolicies != nil {
+		return m.MockGetPolicies(ctx)
+	} else if m.MockError != nil {
+		return nil, m.MockError
+	}
+	return m.MockRet1.(map[string]*policy.Options), m.MockError
+}
+
+// UpdatePolicy mock
+func (m *MockDB) UpdatePolicy(ctx context.Context, id string, pol *policy.Options) error {
+	if m.MockUpdatePolicy != nil {
+		return m.MockUpdatePolicy(ctx, id, pol)
+	}
+	return m.MockError
+}
+
+// DeletePolicy mock
+func (m *MockDB) DeletePolicy(ctx context.Context, id string) error {
+	if m.MockDeletePolicy != nil {
+		return m.MockDeletePolicy(ctx, id)
+	}
+	return m.MockError
+}
+
+// GetProvisionerAttributes mock.
+func (m *MockDB) GetProvisionerAttributes(ctx context.Context, id string) (json.RawMessage, error) {
+	if m.MockGetProvisionerAttributes != nil {
+		return m.MockGetProvisionerAttributes(ctx, id)
+	} else if m.MockError != nil {
+		return nil, m.MockError
+	}
+	return m.MockRet1.(json.RawMessage), m.MockError
+}
+
+// GetAllProvisionerAttributes mock.
+func (m *MockDB) GetAllProvisionerAttributes(ctx context.Context) (map[string]json.RawMessage, error) {
+	if m.MockGetAllProvisionerAttributes != nil {
+		return m.MockGetAllProvisionerAttributes(ctx)
+	} else if m.MockError != nil {
+		return nil, m.MockError
+	}
+	return m.MockRet1.(map[string]json.RawMessage), m.MockError
+}
+
+// UpdateProvisionerAttributes mock.
+func (m *MockDB) UpdateProvisionerAttributes(ctx context.Context, id string, attrs json.RawMessage) error {
+	if m.MockUpdateProvisionerAttributes != nil {
+		return m.MockUpdateProvisionerAttributes(ctx, id, attrs)
+	}
+	return m.MockError
+}
+
+// DeleteProvisionerAttributes mock.
+func (m *MockDB) DeleteProvisionerAttributes(ctx context.Context, id string) error {
+	if m.MockDeleteProvisionerAttributes != nil {
+		return m.MockDeleteProvisionerAttributes(ctx, id)
+	}
+	return m.MockError
+}
@@ -13,6 +13,9 @@ import (
 var (
 	adminsTable       = []byte("admins")
 	provisionersTable = []byte("provisioners")
+	policiesTable     = []byte("policies")
+
+	provisionerAttributesTable = []byte("provisioner_attributes")
 )
 
 // DB is a struct that implements the AdminDB interface.
@@ -23,7 +26,7 @@ type DB struct {
 
 // New configures and returns a new Authority DB backend implemented using a nosql DB.
 func New(db nosqlDB.DB, authorityID string) (*DB, error) {
-	tables := [][]byte{adminsTable, provisionersTable}
+	tables := [][]byte{adminsTable, provisionersTable, policiesTable, provisionerAttributesTable}
 	for _, b := range tables {
 		if err := db.CreateTable(b); err != nil {
 			return nil, errors.Wrapf(err, "error creating table %s",
@@ -0,0 +1,109 @@
+package nosql
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/admin"
+	"github.com/smallstep/certificates/authority/policy"
+	"github.com/smallstep/nosql"
+)
+
+// dbPolicy is the database representation of a name-constraint policy. The ID
+// is the id of the provisioner the policy belongs to, or admin.AuthorityPolicyID
+// for the policy of the authority.
+type dbPolicy struct {
+	ID          string          `json:"id"`
+	AuthorityID string          `json:"authorityID"`
+	Policy      *policy.Options `json:"policy"`
+	UpdatedAt   time.Time       `json:"updatedAt"`
+}
+
+func (db *DB) unmarshalDBPolicy(data []byte, id string) (*dbPolicy, error) {
+	var dbp = new(dbPolicy)
+	if err := json.Unmarshal(data, dbp); err != nil {
+		return nil, errors.Wrapf(err, "error unmarshaling policy %s into dbPolicy", id)
+	}
+	if dbp.AuthorityID != db.authorityID {
+		return nil, admin.NewError(admin.ErrorAuthorityMismatchType,
+			"policy %s is not owned by authority %s", id, db.authorityID)
+	}
+	return dbp, nil
+}
+
+func (db *DB) getDBPolicy(ctx context.Context, id string) (*dbPolicy, error) {
+	data, err := db.db.Get(policiesTable, []byte(id))
+	if nosql.IsErrNotFound(err) {
+		return nil, admin.NewError(admin.ErrorNotFoundType, "policy %s not found", id)
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "error loading policy %s", id)
+	}
+	return db.unmarshalDBPolicy(data, id)
+}
+
+// GetPolicy retrieves and unmarshals the policy with the given id from the
+// database.
+func (db *DB) GetPolicy(ctx context.Context, id string) (*policy.Options, error) {
+	dbp, err := db.getDBPolicy(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return dbp.Policy, nil
+}
+
+// GetPolicies retrieves and unmarshals all the policies of the authority from
+// the database. The policies are indexed by their id.
+func (db *DB) GetPolicies(ctx context.Context) (map[string]*policy.Options, error) {
+	dbEntries, err := db.db.List(policiesTable)
+	if err != nil {
+		return nil, errors.Wrap(err, "error loading policies")
+	}
+	policies := make(map[string]*policy.Options)
+	for _, entry := range dbEntries {
+		dbp, err := db.unmarshalDBPolicy(entry.Value, string(entry.Key))
+		if err != nil {
+			if k, ok := err.(*admin.Error); ok && k.IsType(admin.ErrorAuthorityMismatchType) {
+				continue
+			}
+			return nil, err
+		}
+		policies[dbp.ID] = dbp.Policy
+	}
+	return policies, nil
+}
+
+// UpdatePolicy creates or replaces the policy with the given id.
+func (db *DB) UpdatePolicy(ctx context.Context, id string, pol *policy.Options) error {
+	old, err := db.getDBPolicy(ctx, id)
+	if err != nil {
+		if k, ok := err.(*admin.Error); !ok || !k.IsType(admin.ErrorNotFoundType) {
+			return err
+		}
+		old = nil
+	}
+
+	nu := &dbPolicy{
+		ID:          id,
+		AuthorityID: db.authorityID,
+		Policy:      pol,
+		UpdatedAt:   clock.Now(),
+	}
+	// Passing a nil *dbPolicy would be marshaled as null.
+	if old != nil {
+		return db.save(ctx, id, nu, old, "policy", policiesTable)
+	}
+	return db.save(ctx, id, nu, nil, "policy", policiesTable)
+}
+
+// DeletePolicy deletes the policy with the given id.
+func (db *DB) DeletePolicy(ctx context.Context, id string) error {
+	if _, err := db.getDBPolicy(ctx, id); err != nil {
+		return err
+	}
+	if err := db.db.Del(policiesTable, []byte(id)); err != nil {
+		return errors.Wrapf(err, "error deleting policy %s", id)
+	}
+	return nil
+}
@@ -0,0 +1,372 @@
+package nosql
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/admin"
+	"github.com/smallstep/certificates/authority/policy"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/nosql"
+	"github.com/smallstep/nosql/database"
+	nosqldb "github.com/smallstep/nosql/database"
+)
+
+func defaultDBPolicy(t *testing.T) *dbPolicy {
+	return &dbPolicy{
+		ID:          "provID",
+		AuthorityID: admin.DefaultAuthorityID,
+		Policy: &policy.Options{
+			X509: &policy.X509Options{
+				Allow: &policy.X509NameOptions{DNSDomains: []string{"*.example.com"}},
+			},
+		},
+		UpdatedAt: clock.Now(),
+	}
+}
+
+func TestDB_GetPolicy(t *testing.T) {
+	provID := "provID"
+	type test struct {
+		db       nosql.DB
+		err      error
+		adminErr *admin.Error
+		pol      *policy.Options
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/not-found": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						assert.Equals(t, bucket, policiesTable)
+						assert.Equals(t, string(key), provID)
+
+						return nil, nosqldb.ErrNotFound
+					},
+				},
+				adminErr: admin.NewError(admin.ErrorNotFoundType, "policy provID not found"),
+			}
+		},
+		"fail/db.Get-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, errors.New("force")
+					},
+				},
+				err: errors.New("error loading policy provID: force"),
+			}
+		},
+		"fail/unmarshal-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return []byte("foo"), nil
+					},
+				},
+				err: errors.New("error unmarshaling policy provID into dbPolicy"),
+			}
+		},
+		"fail/authorityID-mismatch": func(t *testing.T) test {
+			dbp := defaultDBPolicy(t)
+			dbp.AuthorityID = "foo"
+			data, err := json.Marshal(dbp)
+			assert.FatalError(t, err)
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return data, nil
+					},
+				},
+				adminErr: admin.NewError(admin.ErrorAuthorityMismatchType,
+					"policy provID is not owned by authority %s", admin.DefaultAuthorityID),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			dbp := defaultDBPolicy(t)
+			data, err := json.Marshal(dbp)
+			assert.FatalError(t, err)
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						assert.Equals(t, bucket, policiesTable)
+						assert.Equals(t, string(key), provID)
+
+						return data, nil
+					},
+				},
+				pol: dbp.Policy,
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			db := DB{db: tc.db, authorityID: admin.DefaultAuthorityID}
+			if pol, err := db.GetPolicy(context.Background(), provID); err != nil {
+				switch k := err.(type) {
+				case *admin.Error:
+					if assert.NotNil(t, tc.adminErr) {
+						assert.Equals(t, k.Type, tc.adminErr.Type)
+						assert.Equals(t, k.Detail, tc.adminErr.Detail)
+						assert.Equals(t, k.Status, tc.adminErr.Status)
+						assert.Equals(t, k.Err.Error(), tc.adminErr.Err.Error())
+					}
+				default:
+					if assert.NotNil(t, tc.err) {
+						assert.HasPrefix(t, err.Error(), tc.err.Error())
+					}
+				}
+			} else {
+				if assert.Nil(t, tc.err) && assert.Nil(t, tc.adminErr) {
+					assert.Equals(t, pol, tc.pol)
+				}
+			}
+		})
+	}
+}
+
+func TestDB_GetPolicies(t *testing.T) {
+	type test struct {
+		db       nosql.DB
+		err      error
+		policies map[string]*policy.Options
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/db.List-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MList: func(bucket []byte) ([]*database.Entry, error) {
+						assert.Equals(t, bucket, policiesTable)
+						return nil, errors.New("force")
+					},
+				},
+				err: errors.New("error loading policies: force"),
+			}
+		},
+		"fail/unmarshal-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MList: func(bucket []byte) ([]*database.Entry, error) {
+						return []*database.Entry{
+							{Bucket: policiesTable, Key: []byte("provID"), Value: []byte("foo")},
+						}, nil
+					},
+				},
+				err: errors.New("error unmarshaling policy provID into dbPolicy"),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			dbp := defaultDBPolicy(t)
+			data, err := json.Marshal(dbp)
+			assert.FatalError(t, err)
+			foo := defaultDBPolicy(t)
+			foo.ID = "fooID"
+			foo.AuthorityID = "foo"
+			fooData, err := json.Marshal(foo)
+			assert.FatalError(t, err)
+			return test{
+				db: &db.MockNoSQLDB{
+					MList: func(bucket []byte) ([]*database.Entry, error) {
+						assert.Equals(t, bucket, policiesTable)
+						return []*database.Entry{
+							{Bucket: policiesTable, Key: []byte("provID"), Value: data},
+							{Bucket: policiesTable, Key: []byte("fooID"), Value: fooData},
+						}, nil
+					},
+				},
+				policies: map[string]*policy.Options{"provID": dbp.Policy},
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			db := DB{db: tc.db, authorityID: admin.DefaultAuthorityID}
+			if policies, err := db.GetPolicies(context.Background()); err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				if assert.Nil(t, tc.err) {
+					assert.Equals(t, policies, tc.policies)
+				}
+			}
+		})
+	}
+}
+
+func TestDB_UpdatePolicy(t *testing.T) {
+	provID := "provID"
+	pol := &policy.Options{
+		SSH: &policy.SSHOptions{
+			Allow: &policy.SSHNameOptions{Principals: []string{"*@example.com"}},
+		},
+	}
+	type test struct {
+		db  nosql.DB
+		err error
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/db.Get-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, errors.New("force")
+					},
+				},
+				err: errors.New("error loading policy provID: force"),
+			}
+		},
+		"fail/save-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, nosqldb.ErrNotFound
+					},
+					MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+						return nil, false, errors.New("force")
+					},
+				},
+				err: errors.New("error saving authority policy: force"),
+			}
+		},
+		"ok/create": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, nosqldb.ErrNotFound
+					},
+					MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+						assert.Equals(t, bucket, policiesTable)
+						assert.Equals(t, string(key), provID)
+						assert.Nil(t, old)
+
+						var _dbp = new(dbPolicy)
+						assert.FatalError(t, json.Unmarshal(nu, _dbp))
+						assert.Equals(t, _dbp.ID, provID)
+						assert.Equals(t, _dbp.AuthorityID, admin.DefaultAuthorityID)
+						assert.Equals(t, _dbp.Policy, pol)
+						assert.True(t, clock.Now().Add(-time.Minute).Before(_dbp.UpdatedAt))
+
+						return nu, true, nil
+					},
+				},
+			}
+		},
+		"ok/update": func(t *testing.T) test {
+			dbp := defaultDBPolicy(t)
+			data, err := json.Marshal(dbp)
+			assert.FatalError(t, err)
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return data, nil
+					},
+					MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+						assert.Equals(t, bucket, policiesTable)
+						assert.Equals(t, string(key), provID)
+						assert.Equals(t, string(old), string(data))
+
+						var _dbp = new(dbPolicy)
+						assert.FatalError(t, json.Unmarshal(nu, _dbp))
+						assert.Equals(t, _dbp.Policy, pol)
+
+						return nu, true, nil
+					},
+				},
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			db := DB{db: tc.db, authorityID: admin.DefaultAuthorityID}
+			if err := db.UpdatePolicy(context.Background(), provID, pol); err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+func TestDB_DeletePolicy(t *testing.T) {
+	provID := "provID"
+	type test struct {
+		db       nosql.DB
+		err      error
+		adminErr *admin.Error
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/not-found": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, nosqldb.ErrNotFound
+					},
+				},
+				adminErr: admin.NewError(admin.ErrorNotFoundType, "policy provID not found"),
+			}
+		},
+		"fail/db.Del-error": func(t *testing.T) test {
+			data, err := json.Marshal(defaultDBPolicy(t))
+			assert.FatalError(t, err)
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return data, nil
+					},
+					MDel: func(bucket, key []byte) error {
+						return errors.New("force")
+					},
+				},
+				err: errors.New("error deleting policy provID: force"),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			data, err := json.Marshal(defaultDBPolicy(t))
+			assert.FatalError(t, err)
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return data, nil
+					},
+					MDel: func(bucket, key []byte) error {
+						assert.Equals(t, bucket, policiesTable)
+						assert.Equals(t, string(key), provID)
+						return nil
+					},
+				},
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			db := DB{db: tc.db, authorityID: admin.DefaultAuthorityID}
+			if err := db.DeletePolicy(context.Background(), provID); err != nil {
+				switch k := err.(type) {
+				case *admin.Error:
+					if assert.NotNil(t, tc.adminErr) {
+						assert.Equals(t, k.Type, tc.adminErr.Type)
+						assert.Equals(t, k.Detail, tc.adminErr.Detail)
+					}
+				default:
+					if assert.NotNil(t, tc.err) {
+						assert.HasPrefix(t, err.Error(), tc.err.Error())
+					}
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Nil(t, tc.adminErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package nosql
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/admin"
+	"github.com/smallstep/nosql"
+)
+
+// dbProvisionerAttributes is the database representation of the attributes of
+// a provisioner that are not part of the linkedca provisioner type. The ID is
+// the id of the provisioner, and the attributes are a JSON object with the
+// same format used in the ca.json.
+type dbProvisionerAttributes struct {
+	ID          string          `json:"id"`
+	AuthorityID string          `json:"authorityID"`
+	Attributes  json.RawMessage `json:"attributes"`
+	UpdatedAt   time.Time       `json:"updatedAt"`
+}
+
+func (db *DB) unmarshalDBProvisionerAttributes(data []byte, id string) (*dbProvisionerAttributes, error) {
+	var dba = new(dbProvisionerAttributes)
+	if err := json.Unmarshal(data, dba); err != nil {
+		return nil, errors.Wrapf(err, "error unmarshaling attributes of provisioner %s into dbProvisionerAttributes", id)
+	}
+	if dba.AuthorityID != db.authorityID {
+		return nil, admin.NewError(admin.ErrorAuthorityMismatchType,
+			"attributes of provisioner %s are not owned by authority %s", id, db.authorityID)
+	}
+	return dba, nil
+}
+
+func (db *DB) getDBProvisionerAttributes(ctx context.Context, id string) (*dbProvisionerAttributes, error) {
+	data, err := db.db.Get(provisionerAttributesTable, []byte(id))
+	if nosql.IsErrNotFound(err) {
+		return nil, admin.NewError(admin.ErrorNotFoundType, "attributes of provisioner %s not found", id)
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "error loading attributes of provisioner %s", id)
+	}
+	return db.unmarshalDBProvisionerAttributes(data, id)
+}
+
+// GetProvisionerAttributes retrieves the attributes of the provisioner with the
+// given id from the database.
+func (db *DB) GetProvisionerAttributes(ctx context.Context, id string) (json.RawMessage, error) {
+	dba, err := db.getDBProvisionerAttributes(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return dba.Attributes, nil
+}
+
+// GetAllProvisionerAttributes retrieves the attributes of all the provisioners
+// of the authority from the database. The attributes are indexed by the id of
+// the provisioner.
+func (db *DB) GetAllProvisionerAttributes(ctx context.Context) (map[string]json.RawMessage, error) {
+	dbEntries, err := db.db.List(provisionerAttributesTable)
+	if err != nil {
+		return nil, errors.Wrap(err, "error loading provisioner attributes")
+	}
+	attributes := make(map[string]json.RawMessage)
+	for _, entry := range dbEntries {
+		dba, err := db.unmarshalDBProvisionerAttributes(entry.Value, string(entry.Key))
+		if err != nil {
+			if k, ok := err.(*admin.Error); ok && k.IsType(admin.ErrorAuthorityMismatchType) {
+				continue
+			}
+			return nil, err
+		}
+		attributes[dba.ID] = dba.Attributes
+	}
+	return attributes, nil
+}
+
+// UpdateProvisionerAttributes creates or replaces the attributes of the
+// provisioner with the given id.
+func (db *DB) UpdateProvisionerAttributes(ctx context.Context, id string, attrs json.RawMessage) error {
+	old, err := db.getDBProvisionerAttributes(ctx, id)
+	if err != nil {
+		if k, ok := err.(*admin.Error); !ok || !k.IsType(admin.ErrorNotFoundType) {
+			return err
+		}
+		old = nil
+	}
+
+	nu := &dbProvisionerAttributes{
+		ID:          id,
+		AuthorityID: db.authorityID,
+		Attributes:  attrs,
+		UpdatedAt:   clock.Now(),
+	}
+	// Passing a nil *dbProvisionerAttributes would be marshaled as null.
+	if old != nil {
+		return db.save(ctx, id, nu, old, "provisioner attributes", provisionerAttributesTable)
+	}
+	return db.save(ctx, id, nu, nil, "provisioner attributes", provisionerAttributesTable)
+}
+
+// DeleteProvisionerAttributes deletes the attributes of the provisioner with
+// the given id.
+func (db *DB) DeleteProvisionerAttributes(ctx context.Context, id string) error {
+	if _, err := db.getDBProvisionerAttributes(ctx, id); err != nil {
+		return err
+	}
+	if err := db.db.Del(provisionerAttributesTable, []byte(id)); err != nil {
+		return errors.Wrapf(err, "error deleting attributes of provisioner %s", id)
+	}
+	return nil
+}
@@ -0,0 +1,322 @@
+package nosql
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/admin"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/nosql"
+	"github.com/smallstep/nosql/database"
+	nosqldb "github.com/smallstep/nosql/database"
+)
+
+func defaultDBProvisionerAttributes(t *testing.T) *dbProvisionerAttributes {
+	return &dbProvisionerAttributes{
+		ID:          "provID",
+		AuthorityID: admin.DefaultAuthorityID,
+		Attributes:  json.RawMessage(`{"requireEAB":true}`),
+		UpdatedAt:   clock.Now(),
+	}
+}
+
+func TestDB_GetProvisionerAttributes(t *testing.T) {
+	provID := "provID"
+	type test struct {
+		db       nosql.DB
+		err      error
+		adminErr *admin.Error
+		attrs    json.RawMessage
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/not-found": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						assert.Equals(t, bucket, provisionerAttributesTable)
+						assert.Equals(t, string(key), provID)
+
+						return nil, nosqldb.ErrNotFound
+					},
+				},
+				adminErr: admin.NewError(admin.ErrorNotFoundType, "attributes of provisioner provID not found"),
+			}
+		},
+		"fail/db.Get-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, errors.New("force")
+					},
+				},
+				err: errors.New("error loading attributes of provisioner provID: force"),
+			}
+		},
+		"fail/unmarshal-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return []byte("foo"), nil
+					},
+				},
+				err: errors.New("error unmarshaling attributes of provisioner provID into dbProvisionerAttributes"),
+			}
+		},
+		"fail/authorityID-mismatch": func(t *testing.T) test {
+			dba := defaultDBProvisionerAttributes(t)
+			dba.AuthorityID = "foo"
+			data, err := json.Marshal(dba)
+			assert.FatalError(t, err)
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return data, nil
+					},
+				},
+				adminErr: admin.NewError(admin.ErrorAuthorityMismatchType,
+					"attributes of provisioner provID are not owned by authority %s", admin.DefaultAuthorityID),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			dba := defaultDBProvisionerAttributes(t)
+			data, err := json.Marshal(dba)
+			assert.FatalError(t, err)
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return data, nil
+					},
+				},
+				attrs: dba.Attributes,
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			db := DB{db: tc.db, authorityID: admin.DefaultAuthorityID}
+			if attrs, err := db.GetProvisionerAttributes(context.Background(), provID); err != nil {
+				switch k := err.(type) {
+				case *admin.Error:
+					if assert.NotNil(t, tc.adminErr) {
+						assert.Equals(t, k.Type, tc.adminErr.Type)
+						assert.Equals(t, k.Detail, tc.adminErr.Detail)
+					}
+				default:
+					if assert.NotNil(t, tc.err) {
+						assert.HasPrefix(t, err.Error(), tc.err.Error())
+					}
+				}
+			} else {
+				if assert.Nil(t, tc.err) && assert.Nil(t, tc.adminErr) {
+					assert.Equals(t, string(attrs), string(tc.attrs))
+				}
+			}
+		})
+	}
+}
+
+func TestDB_GetAllProvisionerAttributes(t *testing.T) {
+	type test struct {
+		db    nosql.DB
+		err   error
+		attrs map[string]string
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/db.List-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MList: func(bucket []byte) ([]*database.Entry, error) {
+						assert.Equals(t, bucket, provisionerAttributesTable)
+						return nil, errors.New("force")
+					},
+				},
+				err: errors.New("error loading provisioner attributes: force"),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			data, err := json.Marshal(defaultDBProvisionerAttributes(t))
+			assert.FatalError(t, err)
+			foo := defaultDBProvisionerAttributes(t)
+			foo.ID = "fooID"
+			foo.AuthorityID = "foo"
+			fooData, err := json.Marshal(foo)
+			assert.FatalError(t, err)
+			return test{
+				db: &db.MockNoSQLDB{
+					MList: func(bucket []byte) ([]*database.Entry, error) {
+						assert.Equals(t, bucket, provisionerAttributesTable)
+						return []*database.Entry{
+							{Bucket: provisionerAttributesTable, Key: []byte("provID"), Value: data},
+							{Bucket: provisionerAttributesTable, Key: []byte("fooID"), Value: fooData},
+						}, nil
+					},
+				},
+				attrs: map[string]string{"provID": `{"requireEAB":true}`},
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			db := DB{db: tc.db, authorityID: admin.DefaultAuthorityID}
+			if attrs, err := db.GetAllProvisionerAttributes(context.Background()); err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				if assert.Nil(t, tc.err) {
+					got := make(map[string]string)
+					for k, v := range attrs {
+						got[k] = string(v)
+					}
+					assert.Equals(t, got, tc.attrs)
+				}
+			}
+		})
+	}
+}
+
+func TestDB_UpdateProvisionerAttributes(t *testing.T) {
+	provID := "provID"
+	attrs := json.RawMessage(`{"requireEAB":true,"forceCN":true}`)
+	type test struct {
+		db  nosql.DB
+		err error
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/save-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, nosqldb.ErrNotFound
+					},
+					MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+						return nil, false, errors.New("force")
+					},
+				},
+				err: errors.New("error saving authority provisioner attributes: force"),
+			}
+		},
+		"ok/create": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, nosqldb.ErrNotFound
+					},
+					MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+						assert.Equals(t, bucket, provisionerAttributesTable)
+						assert.Equals(t, string(key), provID)
+						assert.Nil(t, old)
+
+						var _dba = new(dbProvisionerAttributes)
+						assert.FatalError(t, json.Unmarshal(nu, _dba))
+						assert.Equals(t, _dba.ID, provID)
+						assert.Equals(t, _dba.AuthorityID, admin.DefaultAuthorityID)
+						assert.Equals(t, string(_dba.Attributes), string(attrs))
+						assert.True(t, clock.Now().Add(-time.Minute).Before(_dba.UpdatedAt))
+
+						return nu, true, nil
+					},
+				},
+			}
+		},
+		"ok/update": func(t *testing.T) test {
+			data, err := json.Marshal(defaultDBProvisionerAttributes(t))
+			assert.FatalError(t, err)
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return data, nil
+					},
+					MCmpAndSwap: func(bucket, key, old, nu []byte) ([]byte, bool, error) {
+						assert.Equals(t, bucket, provisionerAttributesTable)
+						assert.Equals(t, string(old), string(data))
+
+						var _dba = new(dbProvisionerAttributes)
+						assert.FatalError(t, json.Unmarshal(nu, _dba))
+						assert.Equals(t, string(_dba.Attributes), string(attrs))
+
+						return nu, true, nil
+					},
+				},
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			db := DB{db: tc.db, authorityID: admin.DefaultAuthorityID}
+			if err := db.UpdateProvisionerAttributes(context.Background(), provID, attrs); err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+func TestDB_DeleteProvisionerAttributes(t *testing.T) {
+	provID := "provID"
+	type test struct {
+		db       nosql.DB
+		err      error
+		adminErr *admin.Error
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/not-found": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, nosqldb.ErrNotFound
+					},
+				},
+				adminErr: admin.NewError(admin.ErrorNotFoundType, "attributes of provisioner provID not found"),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			data, err := json.Marshal(defaultDBProvisionerAttributes(t))
+			assert.FatalError(t, err)
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return data, nil
+					},
+					MDel: func(bucket, key []byte) error {
+						assert.Equals(t, bucket, provisionerAttributesTable)
+						assert.Equals(t, string(key), provID)
+						return nil
+					},
+				},
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			db := DB{db: tc.db, authorityID: admin.DefaultAuthorityID}
+			if err := db.DeleteProvisionerAttributes(context.Background(), provID); err != nil {
+				switch k := err.(type) {
+				case *admin.Error:
+					if assert.NotNil(t, tc.adminErr) {
+						assert.Equals(t, k.Type, tc.adminErr.Type)
+						assert.Equals(t, k.Detail, tc.adminErr.Detail)
+					}
+				default:
+					if assert.NotNil(t, tc.err) {
+						assert.HasPrefix(t, err.Error(), tc.err.Error())
+					}
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Nil(t, tc.adminErr)
+			}
+		})
+	}
+}
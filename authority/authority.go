@@ -4,9 +4,11 @@ import (
 	"context"
 	"crypto"
 	"crypto/sha256"
+	"crypto/tls"
 	"crypto/x509"
 	"encoding/hex"
 	"log"
+	"net/http"
 	"strings"
 	"sync"
 	"time"
@@ -16,6 +18,7 @@ import (
 	adminDBNosql "github.com/smallstep/certificates/authority/admin/db/nosql"
 	"github.com/smallstep/certificates/authority/administrator"
 	"github.com/smallstep/certificates/authority/config"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/certificates/authority/provisioner"
 	"github.com/smallstep/certificates/cas"
 	casapi "github.com/smallstep/certificates/cas/apiv1"
@@ -43,11 +46,28 @@ type Authority struct {
 	linkedCAToken string
 
 	// X509 CA
-	x509CAService      cas.CertificateAuthorityService
-	rootX509Certs      []*x509.Certificate
-	rootX509CertPool   *x509.CertPool
-	federatedX509Certs []*x509.Certificate
-	certificates       *sync.Map
+	x509CAService         cas.CertificateAuthorityService
+	intermediateX509Certs []*x509.Certificate
+	rootX509Certs         []*x509.Certificate
+	rootX509CertPool      *x509.CertPool
+	federatedX509Certs    []*x509.Certificate
+	certificates          *sync.Map
+
+	// CRL generation
+	crlTicker  *time.Ticker
+	crlStopper chan struct{}
+	crlMutex   sync.Mutex
+
+	// ACME garbage collection
+	acmeGC        ACMEGarbageCollector
+	acmeGCTicker  *time.Ticker
+	acmeGCStopper chan struct{}
+	acmeGCMutex   sync.Mutex
+	acmeGCStats   ACMEGarbageCollectionStats
+
+	// OCSP responder
+	ocspResponder *ocspResponder
+	ocspMutex     sync.Mutex
 
 	// SCEP CA
 	scepService *scep.Service
@@ -70,6 +90,15 @@ type Authority struct {
 	sshGetHostsFunc  func(ctx context.Context, cert *x509.Certificate) ([]config.Host, error)
 	getIdentityFunc  provisioner.GetIdentityFunc
 
+	// Name-constraint policy of the authority
+	policyOptions *policy.Options
+	namePolicy    *policy.Engine
+
+	// Client used to call the provisioner webhooks
+	webhookClient      *http.Client
+	webhookCertificate *tls.Certificate
+	webhookMutex       sync.Mutex
+
 	adminMutex sync.RWMutex
 }
 
@@ -138,18 +167,28 @@ func NewEmbedded(opts ...Option) (*Authority, error) {
 	return a, nil
 }
 
-// reloadAdminResources reloads admins and provisioners from the DB.
+// reloadAdminResources reloads admins, provisioners, provisioner attributes
+// and policies from the DB.
 func (a *Authority) reloadAdminResources(ctx context.Context) error {
 	var (
-		provList  provisioner.List
-		adminList []*linkedca.Admin
+		provList        provisioner.List
+		adminList       []*linkedca.Admin
+		authorityPolicy = a.config.AuthorityConfig.Policy
 	)
 	if a.config.AuthorityConfig.EnableAdmin {
 		provs, err := a.adminDB.GetProvisioners(ctx)
 		if err != nil {
 			return admin.WrapErrorISE(err, "error getting provisioners to initialize authority")
 		}
-		provList, err = provisionerListToCertificates(provs)
+		policies, err := a.adminDB.GetPolicies(ctx)
+		if err != nil {
+			return admin.WrapErrorISE(err, "error getting policies to initialize authority")
+		}
+		attributes, err := a.adminDB.GetAllProvisionerAttributes(ctx)
+		if err != nil {
+			return admin.WrapErrorISE(err, "error getting provisioner attributes to initialize authority")
+		}
+		provList, err = provisionerListToCertificates(provs, policies, attributes)
 		if err != nil {
 			return admin.WrapErrorISE(err, "error converting provisioner list to certificates")
 		}
@@ -157,11 +196,21 @@ func (a *Authority) reloadAdminResources(ctx context.Context) error {
 		if err != nil {
 			return admin.WrapErrorISE(err, "error getting admins to initialize authority")
 		}
+		// The policy in the database takes precedence over the one in the
+		// configuration file.
+		if pol, ok := policies[admin.AuthorityPolicyID]; ok {
+			authorityPolicy = pol
+		}
 	} else {
 		provList = a.config.AuthorityConfig.Provisioners
 		adminList = a.config.AuthorityConfig.Admins
 	}
 
+	namePolicy, err := policy.New(authorityPolicy)
+	if err != nil {
+		return admin.WrapErrorISE(err, "error initializing authority policy")
+	}
+
 	provisionerConfig, err := a.generateProvisionerConfig(ctx)
 	if err != nil {
 		return admin.WrapErrorISE(err, "error generating provisioner config")
@@ -194,6 +243,8 @@ func (a *Authority) reloadAdminResources(ctx context.Context) error {
 	a.provisioners = provClxn
 	a.config.AuthorityConfig.Admins = adminList
 	a.admins = adminClxn
+	a.policyOptions = authorityPolicy
+	a.namePolicy = namePolicy
 	return nil
 }
 
@@ -251,6 +302,7 @@ func (a *Authority) init() error {
 			if err != nil {
 				return err
 			}
+			a.intermediateX509Certs = options.CertificateChain
 		}
 
 		a.x509CAService, err = cas.New(context.Background(), options)
@@ -445,6 +497,15 @@ func (a *Authority) init() error {
 	}
 
 	if a.config.AuthorityConfig.EnableAdmin {
+		// The provisioners in the ca.json are not used with the admin
+		// database, fail instead of ignoring the types that cannot be stored
+		// in it.
+		for _, p := range a.config.AuthorityConfig.Provisioners {
+			if err := checkLinkedcaSupport(p); err != nil {
+				return err
+			}
+		}
+
 		// Initialize step-ca Admin Database if it's not already initialized using
 		// WithAdminDB.
 		if a.adminDB == nil {
@@ -491,6 +552,9 @@ func (a *Authority) init() error {
 		}
 	}
 
+	// Configure the client used to call the provisioner webhooks.
+	a.webhookClient = a.newWebhookClient()
+
 	// Load Provisioners and Admins
 	if err := a.reloadAdminResources(context.Background()); err != nil {
 		return err
@@ -508,6 +572,16 @@ func (a *Authority) init() error {
 		a.templates.Data["Step"] = tmplVars
 	}
 
+	// Initialize the OCSP responder defaults, nil is ok.
+	if err := a.config.OCSP.Validate(); err != nil {
+		return err
+	}
+
+	// Start the CRL generator if it's enabled.
+	if err := a.startCRLGenerator(); err != nil {
+		return err
+	}
+
 	// JWT numeric dates are seconds.
 	a.startTime = time.Now().Truncate(time.Second)
 	// Set flag indicating that initialization has been completed, and should
@@ -536,6 +610,8 @@ func (a *Authority) IsAdminAPIEnabled() bool {
 
 // Shutdown safely shuts down any clients, databases, etc. held by the Authority.
 func (a *Authority) Shutdown() error {
+	a.stopCRLGenerator()
+	a.stopACMEGarbageCollector()
 	if err := a.keyManager.Close(); err != nil {
 		log.Printf("error closing the key manager: %v", err)
 	}
@@ -544,6 +620,8 @@ func (a *Authority) Shutdown() error {
 
 // CloseForReload closes internal services, to allow a safe reload.
 func (a *Authority) CloseForReload() {
+	a.stopCRLGenerator()
+	a.stopACMEGarbageCollector()
 	if err := a.keyManager.Close(); err != nil {
 		log.Printf("error closing the key manager: %v", err)
 	}
@@ -130,6 +130,19 @@ func TestAuthorityNew(t *testing.T) {
 				err:    errors.New("error reading wrong: no such file or directory"),
 			}
 		},
+		"fail admin unsupported provisioner": func(t *testing.T) *newTest {
+			c, err := LoadConfiguration("../ca/testdata/ca.json")
+			assert.FatalError(t, err)
+			c.AuthorityConfig.EnableAdmin = true
+			c.AuthorityConfig.Provisioners = append(c.AuthorityConfig.Provisioners, &provisioner.Workload{
+				Type: "Workload",
+				Name: "workload",
+			})
+			return &newTest{
+				config: c,
+				err:    errors.New("Workload provisioner workload is not supported by the admin database"),
+			}
+		},
 	}
 
 	for name, genTestCase := range tests {
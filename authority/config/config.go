@@ -8,6 +8,8 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/acme/email"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/certificates/authority/provisioner"
 	cas "github.com/smallstep/certificates/cas/apiv1"
 	"github.com/smallstep/certificates/db"
@@ -26,6 +28,23 @@ var (
 	DefaultBackdate = time.Minute
 	// DefaultDisableRenewal disables renewals per provisioner.
 	DefaultDisableRenewal = false
+	// DefaultCRLCacheDuration is the default validity of a generated
+	// certificate revocation list.
+	DefaultCRLCacheDuration = 24 * time.Hour
+	// DefaultOCSPValidity is the default validity of an OCSP response.
+	DefaultOCSPValidity = 24 * time.Hour
+	// DefaultOCSPResponderValidity is the default validity of the delegated
+	// OCSP responder certificate.
+	DefaultOCSPResponderValidity = 7 * 24 * time.Hour
+	// DefaultACMEGCInterval is the default time between two runs of the
+	// garbage collection of expired ACME objects.
+	DefaultACMEGCInterval = time.Hour
+	// DefaultACMEGCRetention is the default time expired ACME objects are
+	// kept before being deleted.
+	DefaultACMEGCRetention = 7 * 24 * time.Hour
+	// DefaultACMEGCBatchSize is the default maximum number of expired objects
+	// processed in a run of the garbage collection.
+	DefaultACMEGCBatchSize = 1000
 	// DefaultEnableSSHCA enable SSH CA features per provisioner or globally
 	// for all provisioners.
 	DefaultEnableSSHCA = false
@@ -64,6 +83,122 @@ type Config struct {
 	TLS              *TLSOptions          `json:"tls,omitempty"`
 	Password         string               `json:"password,omitempty"`
 	Templates        *templates.Templates `json:"templates,omitempty"`
+	CRL              *CRLConfig           `json:"crl,omitempty"`
+	OCSP             *OCSPConfig          `json:"ocsp,omitempty"`
+	ACMEGC           *ACMEGCConfig        `json:"acmeGC,omitempty"`
+	ACMEEmail        *email.Config        `json:"acmeEmail,omitempty"`
+}
+
+// ACMEGCConfig represents the configuration of the garbage collection of
+// expired ACME orders, authorizations, challenges and nonces.
+type ACMEGCConfig struct {
+	Enabled   bool                  `json:"enabled"`
+	Interval  *provisioner.Duration `json:"interval,omitempty"`
+	Retention *provisioner.Duration `json:"retention,omitempty"`
+	BatchSize int                   `json:"batchSize,omitempty"`
+}
+
+// IsEnabled returns if the garbage collection of ACME objects is enabled.
+func (c *ACMEGCConfig) IsEnabled() bool {
+	return c != nil && c.Enabled
+}
+
+// Validate validates the ACME garbage collection configuration and
+// initializes the default values.
+func (c *ACMEGCConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.Interval == nil {
+		c.Interval = &provisioner.Duration{Duration: DefaultACMEGCInterval}
+	}
+	if c.Retention == nil {
+		c.Retention = &provisioner.Duration{Duration: DefaultACMEGCRetention}
+	}
+	if c.BatchSize == 0 {
+		c.BatchSize = DefaultACMEGCBatchSize
+	}
+	switch {
+	case c.Interval.Duration <= 0:
+		return errors.New("acmeGC.interval must be greater than 0")
+	case c.Retention.Duration < 0:
+		return errors.New("acmeGC.retention cannot be negative")
+	case c.BatchSize < 0:
+		return errors.New("acmeGC.batchSize cannot be negative")
+	}
+	return nil
+}
+
+// CRLConfig represents the configuration of the certificate revocation lists
+// generated by the authority.
+type CRLConfig struct {
+	Enabled          bool                  `json:"enabled"`
+	GenerateOnRevoke bool                  `json:"generateOnRevoke,omitempty"`
+	CacheDuration    *provisioner.Duration `json:"cacheDuration,omitempty"`
+	RenewPeriod      *provisioner.Duration `json:"renewPeriod,omitempty"`
+}
+
+// IsEnabled returns if the generation of CRLs is enabled.
+func (c *CRLConfig) IsEnabled() bool {
+	return c != nil && c.Enabled
+}
+
+// Validate validates the CRL configuration and initializes the default
+// values.
+func (c *CRLConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.CacheDuration == nil {
+		c.CacheDuration = &provisioner.Duration{Duration: DefaultCRLCacheDuration}
+	}
+	if c.CacheDuration.Duration <= 0 {
+		return errors.New("crl.cacheDuration must be greater than 0")
+	}
+	if c.RenewPeriod == nil {
+		c.RenewPeriod = &provisioner.Duration{Duration: c.CacheDuration.Duration * 2 / 3}
+	}
+	switch {
+	case c.RenewPeriod.Duration <= 0:
+		return errors.New("crl.renewPeriod must be greater than 0")
+	case c.RenewPeriod.Duration > c.CacheDuration.Duration:
+		return errors.New("crl.renewPeriod cannot be greater than crl.cacheDuration")
+	}
+	return nil
+}
+
+// OCSPConfig represents the configuration of the OCSP responder.
+type OCSPConfig struct {
+	Enabled           bool                  `json:"enabled"`
+	Validity          *provisioner.Duration `json:"validity,omitempty"`
+	Delegated         bool                  `json:"delegated,omitempty"`
+	ResponderValidity *provisioner.Duration `json:"responderValidity,omitempty"`
+}
+
+// IsEnabled returns if the OCSP responder is enabled.
+func (c *OCSPConfig) IsEnabled() bool {
+	return c != nil && c.Enabled
+}
+
+// Validate validates the OCSP configuration and initializes the default
+// values.
+func (c *OCSPConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.Validity == nil {
+		c.Validity = &provisioner.Duration{Duration: DefaultOCSPValidity}
+	}
+	if c.Validity.Duration <= 0 {
+		return errors.New("ocsp.validity must be greater than 0")
+	}
+	if c.ResponderValidity == nil {
+		c.ResponderValidity = &provisioner.Duration{Duration: DefaultOCSPResponderValidity}
+	}
+	if c.ResponderValidity.Duration <= 0 {
+		return errors.New("ocsp.responderValidity must be greater than 0")
+	}
+	return nil
 }
 
 // ASN1DN contains ASN1.DN attributes that are used in Subject and Issuer
@@ -93,6 +228,7 @@ type AuthConfig struct {
 	DisableIssuedAtCheck bool                  `json:"disableIssuedAtCheck,omitempty"`
 	Backdate             *provisioner.Duration `json:"backdate,omitempty"`
 	EnableAdmin          bool                  `json:"enableAdmin,omitempty"`
+	Policy               *policy.Options       `json:"policy,omitempty"`
 }
 
 // init initializes the required fields in the AuthConfig if they are not
@@ -120,21 +256,26 @@ func (c *AuthConfig) Validate(audiences provisioner.Audiences) error {
 	// Initialize required fields.
 	c.init()
 
-	// Check that only one K8sSA is enabled
+	// Check that only one K8sSA for legacy tokens is enabled, the ones for
+	// projected tokens are identified by the issuer and audience.
 	var k8sCount int
 	for _, p := range c.Provisioners {
-		if p.GetType() == provisioner.TypeK8sSA {
+		if p, ok := p.(*provisioner.K8sSA); ok && p.Issuer == "" {
 			k8sCount++
 		}
 	}
 	if k8sCount > 1 {
-		return errors.New("cannot have more than one kubernetes service account provisioner")
+		return errors.New("cannot have more than one kubernetes service account provisioner without issuer")
 	}
 
 	if c.Backdate.Duration < 0 {
 		return errors.New("authority.backdate cannot be less than 0")
 	}
 
+	if err := c.Policy.Validate(); err != nil {
+		return errors.Wrap(err, "authority.policy is not valid")
+	}
+
 	return nil
 }
 
@@ -253,6 +394,26 @@ func (c *Config) Validate() error {
 		return err
 	}
 
+	// Validate crl: nil is ok
+	if err := c.CRL.Validate(); err != nil {
+		return err
+	}
+
+	// Validate ocsp: nil is ok
+	if err := c.OCSP.Validate(); err != nil {
+		return err
+	}
+
+	// Validate acmeGC: nil is ok
+	if err := c.ACMEGC.Validate(); err != nil {
+		return err
+	}
+
+	// Validate acmeEmail: nil is ok
+	if err := c.ACMEEmail.Validate(); err != nil {
+		return err
+	}
+
 	return c.AuthorityConfig.Validate(c.GetAudiences())
 }
 
@@ -3,9 +3,11 @@ package config
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/certificates/authority/provisioner"
 	"go.step.sm/crypto/jose"
 
@@ -280,6 +282,55 @@ func TestAuthConfigValidate(t *testing.T) {
 				asn1dn: asn1dn,
 			}
 		},
+		"ok-policy": func(t *testing.T) AuthConfigValidateTest {
+			return AuthConfigValidateTest{
+				ac: &AuthConfig{
+					Provisioners: p,
+					Policy: &policy.Options{
+						X509: &policy.X509Options{
+							Allow: &policy.X509NameOptions{DNSDomains: []string{"*.example.com"}},
+						},
+					},
+				},
+				asn1dn: ASN1DN{},
+			}
+		},
+		"ok-multiple-k8ssa": func(t *testing.T) AuthConfigValidateTest {
+			return AuthConfigValidateTest{
+				ac: &AuthConfig{
+					Provisioners: provisioner.List{
+						&provisioner.K8sSA{Name: "legacy", Type: "K8sSA"},
+						&provisioner.K8sSA{Name: "cluster-1", Type: "K8sSA", Issuer: "https://cluster-1.example.com", Audience: "step-ca"},
+						&provisioner.K8sSA{Name: "cluster-2", Type: "K8sSA", Issuer: "https://cluster-2.example.com", Audience: "step-ca"},
+					},
+				},
+				asn1dn: ASN1DN{},
+			}
+		},
+		"fail-multiple-legacy-k8ssa": func(t *testing.T) AuthConfigValidateTest {
+			return AuthConfigValidateTest{
+				ac: &AuthConfig{
+					Provisioners: provisioner.List{
+						&provisioner.K8sSA{Name: "legacy-1", Type: "K8sSA"},
+						&provisioner.K8sSA{Name: "legacy-2", Type: "K8sSA"},
+					},
+				},
+				err: errors.New("cannot have more than one kubernetes service account provisioner without issuer"),
+			}
+		},
+		"fail-policy": func(t *testing.T) AuthConfigValidateTest {
+			return AuthConfigValidateTest{
+				ac: &AuthConfig{
+					Provisioners: p,
+					Policy: &policy.Options{
+						X509: &policy.X509Options{
+							Allow: &policy.X509NameOptions{IPRanges: []string{"10.0.0.0/33"}},
+						},
+					},
+				},
+				err: errors.New(`authority.policy is not valid: error parsing x509 allowed names: ip range "10.0.0.0/33" is not valid`),
+			}
+		},
 	}
 
 	for name, get := range tests {
@@ -298,3 +349,42 @@ func TestAuthConfigValidate(t *testing.T) {
 		})
 	}
 }
+
+func TestACMEGCConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name string
+		c    *ACMEGCConfig
+		want *ACMEGCConfig
+		err  error
+	}{
+		{"ok/nil", nil, nil, nil},
+		{"ok/defaults", &ACMEGCConfig{Enabled: true}, &ACMEGCConfig{
+			Enabled:   true,
+			Interval:  &provisioner.Duration{Duration: DefaultACMEGCInterval},
+			Retention: &provisioner.Duration{Duration: DefaultACMEGCRetention},
+			BatchSize: DefaultACMEGCBatchSize,
+		}, nil},
+		{"ok/zero-retention", &ACMEGCConfig{Enabled: true, Retention: &provisioner.Duration{}, BatchSize: 10}, &ACMEGCConfig{
+			Enabled:   true,
+			Interval:  &provisioner.Duration{Duration: DefaultACMEGCInterval},
+			Retention: &provisioner.Duration{},
+			BatchSize: 10,
+		}, nil},
+		{"fail/interval", &ACMEGCConfig{Interval: &provisioner.Duration{}}, nil, errors.New("acmeGC.interval must be greater than 0")},
+		{"fail/retention", &ACMEGCConfig{Retention: &provisioner.Duration{Duration: -time.Hour}}, nil, errors.New("acmeGC.retention cannot be negative")},
+		{"fail/batchSize", &ACMEGCConfig{BatchSize: -1}, nil, errors.New("acmeGC.batchSize cannot be negative")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.c.Validate()
+			if tt.err != nil {
+				if assert.Error(t, err) {
+					assert.Equals(t, err.Error(), tt.err.Error())
+				}
+				return
+			}
+			assert.FatalError(t, err)
+			assert.Equals(t, tt.c, tt.want)
+		})
+	}
+}
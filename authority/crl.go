@@ -0,0 +1,208 @@
+package authority
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"log"
+	"math/big"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	casapi "github.com/smallstep/certificates/cas/apiv1"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+)
+
+// oidExtensionReasonCode is the CRL entry extension defined in RFC 5280,
+// section 5.3.1.
+var oidExtensionReasonCode = asn1.ObjectIdentifier{2, 5, 29, 21}
+
+// GetCertificateRevocationList returns the DER representation of the last
+// certificate revocation list generated by the authority.
+func (a *Authority) GetCertificateRevocationList() ([]byte, error) {
+	if !a.config.CRL.IsEnabled() {
+		return nil, errs.NotFound("authority.GetCertificateRevocationList; certificate revocation lists are not enabled")
+	}
+
+	crlDB, ok := a.db.(db.CertificateRevocationListDB)
+	if !ok {
+		return nil, errs.NotImplemented("authority.GetCertificateRevocationList; persistence layer does not support certificate revocation lists")
+	}
+
+	crlInfo, err := crlDB.GetCRL()
+	if errors.Is(err, db.ErrNotFound) {
+		if err := a.GenerateCertificateRevocationList(); err != nil {
+			return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GetCertificateRevocationList")
+		}
+		crlInfo, err = crlDB.GetCRL()
+	}
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GetCertificateRevocationList")
+	}
+
+	return crlInfo.DER, nil
+}
+
+// GenerateCertificateRevocationList generates a new certificate revocation
+// list with all the non-expired revoked certificates and stores it in the
+// database. Every new CRL will use the next CRL number.
+func (a *Authority) GenerateCertificateRevocationList() error {
+	if !a.config.CRL.IsEnabled() {
+		return nil
+	}
+
+	crlDB, ok := a.db.(db.CertificateRevocationListDB)
+	if !ok {
+		return errors.New("persistence layer does not support certificate revocation lists")
+	}
+	crlGenerator, ok := a.x509CAService.(casapi.CertificateAuthorityCRLGenerator)
+	if !ok {
+		return errors.New("certificate authority service does not support certificate revocation lists")
+	}
+
+	a.crlMutex.Lock()
+	defer a.crlMutex.Unlock()
+
+	revokedList, err := a.getRevokedCertificates(crlDB)
+	if err != nil {
+		return errors.Wrap(err, "error getting revoked certificates")
+	}
+
+	// The CRL number must be monotonically increasing.
+	number := int64(1)
+	crlInfo, err := crlDB.GetCRL()
+	switch {
+	case err == nil:
+		number = crlInfo.Number + 1
+	case !errors.Is(err, db.ErrNotFound):
+		return errors.Wrap(err, "error getting certificate revocation list")
+	}
+
+	now := time.Now().Truncate(time.Second).UTC()
+	revokedCertificates := make([]pkix.RevokedCertificate, 0, len(revokedList))
+	for _, rci := range revokedList {
+		// Expired certificates do not need to be in the CRL.
+		if !rci.ExpiresAt.IsZero() && rci.ExpiresAt.Before(now) {
+			continue
+		}
+		sn, ok := new(big.Int).SetString(rci.Serial, 10)
+		if !ok {
+			log.Printf("skipping revoked certificate with invalid serial number %q", rci.Serial)
+			continue
+		}
+		revokedCert := pkix.RevokedCertificate{
+			SerialNumber:   sn,
+			RevocationTime: rci.RevokedAt,
+		}
+		// The reason code unspecified should not be used, RFC 5280 recommends
+		// to omit the extension instead.
+		if rci.ReasonCode > 0 {
+			ext, err := newReasonCodeExtension(rci.ReasonCode)
+			if err != nil {
+				return err
+			}
+			revokedCert.Extensions = []pkix.Extension{ext}
+		}
+		revokedCertificates = append(revokedCertificates, revokedCert)
+	}
+
+	// Sort by serial number to generate a predictable list.
+	sort.Slice(revokedCertificates, func(i, j int) bool {
+		return revokedCertificates[i].SerialNumber.Cmp(revokedCertificates[j].SerialNumber) < 0
+	})
+
+	revocationList := &x509.RevocationList{
+		Number:              big.NewInt(number),
+		ThisUpdate:          now,
+		NextUpdate:          now.Add(a.config.CRL.CacheDuration.Duration),
+		RevokedCertificates: revokedCertificates,
+	}
+	resp, err := crlGenerator.CreateCRL(&casapi.CreateCRLRequest{
+		RevocationList: revocationList,
+	})
+	if err != nil {
+		return errors.Wrap(err, "error creating certificate revocation list")
+	}
+
+	return crlDB.StoreCRL(&db.CertificateRevocationListInfo{
+		Number:    number,
+		ExpiresAt: revocationList.NextUpdate,
+		DER:       resp.CRL,
+	})
+}
+
+// getRevokedCertificates returns the revoked certificates from the same
+// persistence layer used by IsRevoked, so the CRL includes all the
+// certificates revoked through a linked CA.
+func (a *Authority) getRevokedCertificates(crlDB db.CertificateRevocationListDB) ([]db.RevokedCertificateInfo, error) {
+	switch lca := a.adminDB.(type) {
+	case interface {
+		GetRevokedCertificates() ([]db.RevokedCertificateInfo, error)
+	}:
+		return lca.GetRevokedCertificates()
+	case interface {
+		IsRevoked(string) (bool, error)
+	}:
+		return nil, errors.New("linked ca does not support listing the revoked certificates")
+	default:
+		return crlDB.GetRevokedCertificates()
+	}
+}
+
+// startCRLGenerator starts a background process that generates a new
+// certificate revocation list every renew period. The first CRL is generated
+// synchronously so a valid CRL is available when the CA starts.
+func (a *Authority) startCRLGenerator() error {
+	if !a.config.CRL.IsEnabled() {
+		return nil
+	}
+	if err := a.config.CRL.Validate(); err != nil {
+		return err
+	}
+	if err := a.GenerateCertificateRevocationList(); err != nil {
+		return errors.Wrap(err, "error generating certificate revocation list")
+	}
+
+	a.crlTicker = time.NewTicker(a.config.CRL.RenewPeriod.Duration)
+	a.crlStopper = make(chan struct{})
+	go func(ticker *time.Ticker, stop chan struct{}) {
+		for {
+			select {
+			case <-ticker.C:
+				if err := a.GenerateCertificateRevocationList(); err != nil {
+					log.Printf("error generating certificate revocation list: %v", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}(a.crlTicker, a.crlStopper)
+
+	return nil
+}
+
+// stopCRLGenerator stops the background process started by
+// startCRLGenerator.
+func (a *Authority) stopCRLGenerator() {
+	if a.crlTicker != nil {
+		a.crlTicker.Stop()
+		close(a.crlStopper)
+		a.crlTicker = nil
+	}
+}
+
+// newReasonCodeExtension returns the CRL entry extension with the given
+// reason code.
+func newReasonCodeExtension(reasonCode int) (pkix.Extension, error) {
+	b, err := asn1.Marshal(asn1.Enumerated(reasonCode))
+	if err != nil {
+		return pkix.Extension{}, errors.Wrap(err, "error marshaling reason code")
+	}
+	return pkix.Extension{
+		Id:    oidExtensionReasonCode,
+		Value: b,
+	}, nil
+}
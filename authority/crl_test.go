@@ -0,0 +1,205 @@
+package authority
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/admin"
+	"github.com/smallstep/certificates/authority/config"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"go.step.sm/crypto/pemutil"
+)
+
+// mockLinkedCA is an admin.DB that stores the revocations, like the linked CA
+// client.
+type mockLinkedCA struct {
+	admin.MockDB
+}
+
+func (m *mockLinkedCA) IsRevoked(sn string) (bool, error) {
+	return false, nil
+}
+
+// mockListLinkedCA is a mockLinkedCA that can also list the revocations.
+type mockListLinkedCA struct {
+	mockLinkedCA
+	revoked []db.RevokedCertificateInfo
+}
+
+func (m *mockListLinkedCA) GetRevokedCertificates() ([]db.RevokedCertificateInfo, error) {
+	return m.revoked, nil
+}
+
+func TestAuthority_GenerateCertificateRevocationList(t *testing.T) {
+	intermediate, err := pemutil.ReadCertificate("testdata/certs/intermediate_ca.crt")
+	assert.FatalError(t, err)
+
+	now := time.Now().UTC()
+	revoked := []db.RevokedCertificateInfo{
+		{Serial: "1234", ReasonCode: 1, RevokedAt: now, ExpiresAt: now.Add(time.Hour)},
+		{Serial: "5678", RevokedAt: now},
+		{Serial: "9012", ReasonCode: 4, RevokedAt: now, ExpiresAt: now.Add(-time.Hour)},
+	}
+
+	type test struct {
+		auth       *Authority
+		crlInfo    *db.CertificateRevocationListInfo
+		wantNumber int64
+		wantSerial []int64
+		err        error
+	}
+	tests := map[string]func(t *testing.T) *test{
+		"ok/disabled": func(t *testing.T) *test {
+			return &test{
+				auth: testAuthority(t),
+			}
+		},
+		"ok/first": func(t *testing.T) *test {
+			tc := &test{wantNumber: 1, wantSerial: []int64{1234, 5678}}
+			tc.auth = testAuthority(t, WithDatabase(&db.MockAuthDB{
+				MGetRevokedCerts: func() ([]db.RevokedCertificateInfo, error) {
+					return revoked, nil
+				},
+				MGetCRL: func() (*db.CertificateRevocationListInfo, error) {
+					return nil, db.ErrNotFound
+				},
+				MStoreCRL: func(crlInfo *db.CertificateRevocationListInfo) error {
+					tc.crlInfo = crlInfo
+					return nil
+				},
+			}))
+			tc.auth.config.CRL = &config.CRLConfig{Enabled: true}
+			assert.FatalError(t, tc.auth.config.CRL.Validate())
+			return tc
+		},
+		"ok/next": func(t *testing.T) *test {
+			tc := &test{wantNumber: 11, wantSerial: []int64{1234, 5678}}
+			tc.auth = testAuthority(t, WithDatabase(&db.MockAuthDB{
+				MGetRevokedCerts: func() ([]db.RevokedCertificateInfo, error) {
+					return revoked, nil
+				},
+				MGetCRL: func() (*db.CertificateRevocationListInfo, error) {
+					return &db.CertificateRevocationListInfo{Number: 10}, nil
+				},
+				MStoreCRL: func(crlInfo *db.CertificateRevocationListInfo) error {
+					tc.crlInfo = crlInfo
+					return nil
+				},
+			}))
+			tc.auth.config.CRL = &config.CRLConfig{
+				Enabled:       true,
+				CacheDuration: &provisioner.Duration{Duration: time.Hour},
+			}
+			assert.FatalError(t, tc.auth.config.CRL.Validate())
+			return tc
+		},
+		"fail/GetRevokedCertificates": func(t *testing.T) *test {
+			a := testAuthority(t, WithDatabase(&db.MockAuthDB{
+				MGetRevokedCerts: func() ([]db.RevokedCertificateInfo, error) {
+					return nil, errors.New("force")
+				},
+			}))
+			a.config.CRL = &config.CRLConfig{Enabled: true}
+			assert.FatalError(t, a.config.CRL.Validate())
+			return &test{
+				auth: a,
+				err:  errors.New("error getting revoked certificates: force"),
+			}
+		},
+		"ok/linkedca": func(t *testing.T) *test {
+			tc := &test{wantNumber: 1, wantSerial: []int64{1234, 5678}}
+			tc.auth = testAuthority(t, WithDatabase(&db.MockAuthDB{
+				MGetRevokedCerts: func() ([]db.RevokedCertificateInfo, error) {
+					return nil, errors.New("unexpected call")
+				},
+				MGetCRL: func() (*db.CertificateRevocationListInfo, error) {
+					return nil, db.ErrNotFound
+				},
+				MStoreCRL: func(crlInfo *db.CertificateRevocationListInfo) error {
+					tc.crlInfo = crlInfo
+					return nil
+				},
+			}))
+			tc.auth.adminDB = &mockListLinkedCA{revoked: revoked}
+			tc.auth.config.CRL = &config.CRLConfig{Enabled: true}
+			assert.FatalError(t, tc.auth.config.CRL.Validate())
+			return tc
+		},
+		"fail/linkedca": func(t *testing.T) *test {
+			a := testAuthority(t, WithDatabase(&db.MockAuthDB{
+				MGetRevokedCerts: func() ([]db.RevokedCertificateInfo, error) {
+					return revoked, nil
+				},
+			}))
+			a.adminDB = &mockLinkedCA{}
+			a.config.CRL = &config.CRLConfig{Enabled: true}
+			assert.FatalError(t, a.config.CRL.Validate())
+			return &test{
+				auth: a,
+				err:  errors.New("error getting revoked certificates: linked ca does not support listing the revoked certificates"),
+			}
+		},
+		"fail/GetCRL": func(t *testing.T) *test {
+			a := testAuthority(t, WithDatabase(&db.MockAuthDB{
+				MGetRevokedCerts: func() ([]db.RevokedCertificateInfo, error) {
+					return revoked, nil
+				},
+				MGetCRL: func() (*db.CertificateRevocationListInfo, error) {
+					return nil, errors.New("force")
+				},
+			}))
+			a.config.CRL = &config.CRLConfig{Enabled: true}
+			assert.FatalError(t, a.config.CRL.Validate())
+			return &test{
+				auth: a,
+				err:  errors.New("error getting certificate revocation list: force"),
+			}
+		},
+	}
+	for name, genTestCase := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := genTestCase(t)
+			err := tc.auth.GenerateCertificateRevocationList()
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+				return
+			}
+			assert.Nil(t, tc.err)
+			if tc.wantNumber == 0 {
+				assert.Nil(t, tc.crlInfo)
+				return
+			}
+
+			if assert.NotNil(t, tc.crlInfo) {
+				assert.Equals(t, tc.wantNumber, tc.crlInfo.Number)
+				crl, err := x509.ParseCRL(tc.crlInfo.DER)
+				assert.FatalError(t, err)
+				assert.FatalError(t, intermediate.CheckCRLSignature(crl))
+				assert.Equals(t, tc.crlInfo.ExpiresAt.Unix(), crl.TBSCertList.NextUpdate.Unix())
+
+				revokedCerts := crl.TBSCertList.RevokedCertificates
+				if assert.Len(t, len(tc.wantSerial), revokedCerts) {
+					for i, sn := range tc.wantSerial {
+						assert.Equals(t, big.NewInt(sn), revokedCerts[i].SerialNumber)
+					}
+					// The first one has a reason code, the second one doesn't.
+					if assert.Len(t, 1, revokedCerts[0].Extensions) {
+						var reason asn1.Enumerated
+						_, err := asn1.Unmarshal(revokedCerts[0].Extensions[0].Value, &reason)
+						assert.FatalError(t, err)
+						assert.Equals(t, asn1.Enumerated(1), reason)
+					}
+					assert.Len(t, 0, revokedCerts[1].Extensions)
+				}
+			}
+		})
+	}
+}
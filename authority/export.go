@@ -19,6 +19,13 @@ import (
 //
 // Note that export will not export neither the pki password nor the certificate
 // issuer password.
+//
+// The name-constraint policies are not part of the linkedca configuration, and
+// they are exported as JSON files, "policies/authority.json" for the policy of
+// the authority and "policies/provisioners/<name>.json" for the policy of each
+// provisioner. The attributes of the provisioners that are not part of the
+// linkedca provisioner types are exported in the same way, as
+// "attributes/provisioners/<name>.json".
 func (a *Authority) Export() (c *linkedca.Configuration, err error) {
 	// Recover from panics
 	defer func() {
@@ -143,6 +150,16 @@ func (a *Authority) Export() (c *linkedca.Configuration, err error) {
 				return nil, err
 			}
 			c.Authority.Provisioners = append(c.Authority.Provisioners, lp)
+			if pol := provisionerPolicy(p); pol != nil {
+				files["policies/provisioners/"+p.GetName()+".json"] = mustMarshalJSON(pol)
+			}
+			attrs, err := marshalProvisionerAttributes(p)
+			if err != nil {
+				return nil, err
+			}
+			if attrs != nil {
+				files["attributes/provisioners/"+p.GetName()+".json"] = attrs
+			}
 		}
 		if cursor == "" {
 			break
@@ -150,6 +167,10 @@ func (a *Authority) Export() (c *linkedca.Configuration, err error) {
 	}
 	// global claims
 	c.Authority.Claims = claimsToLinkedca(a.config.AuthorityConfig.Claims)
+	// global policy
+	if a.policyOptions != nil {
+		files["policies/authority.json"] = mustMarshalJSON(a.policyOptions)
+	}
 	// Distinguished names template
 	if v := a.config.AuthorityConfig.Template; v != nil {
 		c.Authority.Template = &linkedca.DistinguishedName{
@@ -240,6 +261,14 @@ func mustMarshalToStruct(v interface{}) *structpb.Struct {
 	return r
 }
 
+func mustMarshalJSON(v interface{}) []byte {
+	b, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		panic(errors.Wrapf(err, "error marshaling %T", v))
+	}
+	return b
+}
+
 func mustReadFileOrURI(fn string, m map[string][]byte) string {
 	if fn == "" {
 		return ""
@@ -7,6 +7,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/hex"
+	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"net/url"
@@ -15,6 +16,8 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/admin"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/certificates/db"
 	"go.step.sm/crypto/jose"
 	"go.step.sm/crypto/keyutil"
@@ -228,6 +231,48 @@ func (c *linkedCaClient) DeleteAdmin(ctx context.Context, id string) error {
 	return errors.Wrap(err, "error deleting admin")
 }
 
+// GetPolicy returns a not found error, name-constraint policies are not yet
+// supported by linked authorities.
+func (c *linkedCaClient) GetPolicy(ctx context.Context, id string) (*policy.Options, error) {
+	return nil, admin.NewError(admin.ErrorNotFoundType, "policy %s not found", id)
+}
+
+// GetPolicies returns an empty list of policies, name-constraint policies are
+// not yet supported by linked authorities.
+func (c *linkedCaClient) GetPolicies(ctx context.Context) (map[string]*policy.Options, error) {
+	return map[string]*policy.Options{}, nil
+}
+
+func (c *linkedCaClient) UpdatePolicy(ctx context.Context, id string, pol *policy.Options) error {
+	return admin.NewError(admin.ErrorNotImplementedType, "policies are not supported by linked authorities")
+}
+
+func (c *linkedCaClient) DeletePolicy(ctx context.Context, id string) error {
+	return admin.NewError(admin.ErrorNotImplementedType, "policies are not supported by linked authorities")
+}
+
+// GetProvisionerAttributes returns a not found error, the attributes that are
+// not part of the linkedca provisioners are not yet supported by linked
+// authorities.
+func (c *linkedCaClient) GetProvisionerAttributes(ctx context.Context, id string) (json.RawMessage, error) {
+	return nil, admin.NewError(admin.ErrorNotFoundType, "attributes of provisioner %s not found", id)
+}
+
+// GetAllProvisionerAttributes returns an empty map, the attributes that are
+// not part of the linkedca provisioners are not yet supported by linked
+// authorities.
+func (c *linkedCaClient) GetAllProvisionerAttributes(ctx context.Context) (map[string]json.RawMessage, error) {
+	return map[string]json.RawMessage{}, nil
+}
+
+func (c *linkedCaClient) UpdateProvisionerAttributes(ctx context.Context, id string, attrs json.RawMessage) error {
+	return admin.NewError(admin.ErrorNotImplementedType, "provisioner attributes are not supported by linked authorities")
+}
+
+func (c *linkedCaClient) DeleteProvisionerAttributes(ctx context.Context, id string) error {
+	return admin.NewError(admin.ErrorNotImplementedType, "provisioner attributes are not supported by linked authorities")
+}
+
 func (c *linkedCaClient) StoreCertificateChain(fullchain ...*x509.Certificate) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
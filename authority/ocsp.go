@@ -0,0 +1,259 @@
+package authority
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	casapi "github.com/smallstep/certificates/cas/apiv1"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+	"go.step.sm/crypto/keyutil"
+	"go.step.sm/crypto/x509util"
+	"golang.org/x/crypto/ocsp"
+)
+
+// oidOCSPNoCheck is the id-pkix-ocsp-nocheck extension defined in RFC 6960,
+// section 4.2.2.2.1. It indicates that the status of the delegated responder
+// certificate should not be checked.
+var oidOCSPNoCheck = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 5}
+
+// OCSPResponse contains a DER encoded OCSP response and the period in which
+// it can be cached.
+type OCSPResponse struct {
+	Raw        []byte
+	ThisUpdate time.Time
+	NextUpdate time.Time
+}
+
+// ocspResponder holds the delegated OCSP signing certificate and its key.
+type ocspResponder struct {
+	Certificate *x509.Certificate
+	Issuer      *x509.Certificate
+	Signer      crypto.Signer
+}
+
+// GetOCSPResponse parses the given DER encoded OCSP request and returns a
+// signed OCSP response with the status of the requested certificate.
+// Malformed requests and requests for certificates of other issuers will
+// return the proper OCSP error response.
+func (a *Authority) GetOCSPResponse(der []byte) (*OCSPResponse, error) {
+	if !a.config.OCSP.IsEnabled() {
+		return nil, errs.NotFound("authority.GetOCSPResponse; ocsp responder is not enabled")
+	}
+
+	req, err := ocsp.ParseRequest(der)
+	if err != nil {
+		return &OCSPResponse{Raw: ocsp.MalformedRequestErrorResponse}, nil
+	}
+
+	responder, err := a.getOCSPResponder()
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GetOCSPResponse")
+	}
+
+	// Only the status of certificates issued by the current issuer can be
+	// returned.
+	if ok, err := isOCSPRequestIssuer(req, responder.Issuer); err != nil || !ok {
+		return &OCSPResponse{Raw: ocsp.UnauthorizedErrorResponse}, nil
+	}
+
+	now := time.Now().Truncate(time.Minute).UTC()
+	template := ocsp.Response{
+		SerialNumber: req.SerialNumber,
+		IssuerHash:   req.HashAlgorithm,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(a.config.OCSP.Validity.Duration),
+	}
+
+	if err := a.setOCSPStatus(&template, responder.Issuer); err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GetOCSPResponse")
+	}
+
+	var raw []byte
+	if responder.Signer != nil {
+		// Delegated responses must include the responder certificate.
+		template.Certificate = responder.Certificate
+		raw, err = ocsp.CreateResponse(responder.Issuer, responder.Certificate, template, responder.Signer)
+	} else {
+		var resp *casapi.CreateOCSPResponseResponse
+		resp, err = a.x509CAService.(casapi.CertificateAuthorityOCSPSigner).CreateOCSPResponse(&casapi.CreateOCSPResponseRequest{
+			Template: template,
+		})
+		if err == nil {
+			raw = resp.Response
+		}
+	}
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GetOCSPResponse; error signing ocsp response")
+	}
+
+	return &OCSPResponse{
+		Raw:        raw,
+		ThisUpdate: template.ThisUpdate,
+		NextUpdate: template.NextUpdate,
+	}, nil
+}
+
+// setOCSPStatus sets the status of the certificate in the given template.
+// Revoked certificates will be reported as revoked, certificates issued by
+// the authority as good and everything else as unknown.
+func (a *Authority) setOCSPStatus(template *ocsp.Response, issuer *x509.Certificate) error {
+	serial := template.SerialNumber.String()
+	isRevoked, err := a.db.IsRevoked(serial)
+	if err != nil {
+		return err
+	}
+
+	if isRevoked {
+		template.Status = ocsp.Revoked
+		template.RevokedAt = template.ThisUpdate
+		template.RevocationReason = ocsp.Unspecified
+		if rdb, ok := a.db.(interface {
+			GetRevokedCertificate(string) (*db.RevokedCertificateInfo, error)
+		}); ok {
+			rci, err := rdb.GetRevokedCertificate(serial)
+			if err != nil {
+				return err
+			}
+			template.RevokedAt = rci.RevokedAt
+			template.RevocationReason = rci.ReasonCode
+		}
+		return nil
+	}
+
+	// Certificates not stored in the database, or issued by an older
+	// intermediate, are unknown.
+	cert, err := a.db.GetCertificate(serial)
+	if err != nil || !bytes.Equal(cert.RawIssuer, issuer.RawSubject) {
+		template.Status = ocsp.Unknown
+		return nil
+	}
+	if len(cert.AuthorityKeyId) > 0 && len(issuer.SubjectKeyId) > 0 && !bytes.Equal(cert.AuthorityKeyId, issuer.SubjectKeyId) {
+		template.Status = ocsp.Unknown
+		return nil
+	}
+
+	template.Status = ocsp.Good
+	return nil
+}
+
+// getOCSPResponder returns the responder used to sign OCSP responses. If the
+// delegated mode is enabled it will issue a new OCSP signing certificate if
+// the current one does not exist or it is close to its expiration.
+func (a *Authority) getOCSPResponder() (*ocspResponder, error) {
+	if !a.config.OCSP.Delegated {
+		if _, ok := a.x509CAService.(casapi.CertificateAuthorityOCSPSigner); !ok {
+			return nil, errors.New("certificate authority service does not support signing ocsp responses")
+		}
+		if len(a.intermediateX509Certs) == 0 {
+			return nil, errors.New("issuer certificate is not available")
+		}
+		return &ocspResponder{
+			Certificate: a.intermediateX509Certs[0],
+			Issuer:      a.intermediateX509Certs[0],
+		}, nil
+	}
+
+	a.ocspMutex.Lock()
+	defer a.ocspMutex.Unlock()
+
+	// Renew the responder certificate after two thirds of its lifetime.
+	if r := a.ocspResponder; r != nil {
+		lifetime := r.Certificate.NotAfter.Sub(r.Certificate.NotBefore)
+		if time.Now().Before(r.Certificate.NotAfter.Add(-lifetime / 3)) {
+			return r, nil
+		}
+	}
+
+	r, err := a.createOCSPResponder()
+	if err != nil {
+		return nil, err
+	}
+	a.ocspResponder = r
+	return r, nil
+}
+
+// createOCSPResponder generates a new key and issues a delegated OCSP signing
+// certificate using the x509CAService.
+func (a *Authority) createOCSPResponder() (*ocspResponder, error) {
+	priv, err := keyutil.GenerateDefaultKey()
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("private key is not a crypto.Signer")
+	}
+
+	cr, err := x509util.CreateCertificateRequest("Step OCSP Responder", nil, signer)
+	if err != nil {
+		return nil, err
+	}
+	template, err := x509util.NewCertificate(cr)
+	if err != nil {
+		return nil, err
+	}
+
+	lifetime := a.config.OCSP.ResponderValidity.Duration
+	now := time.Now()
+	certTpl := template.GetCertificate()
+	certTpl.NotBefore = now.Add(-1 * time.Minute)
+	certTpl.NotAfter = now.Add(lifetime)
+	certTpl.KeyUsage = x509.KeyUsageDigitalSignature
+	certTpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning}
+	certTpl.ExtraExtensions = append(certTpl.ExtraExtensions, pkix.Extension{
+		Id:    oidOCSPNoCheck,
+		Value: asn1.NullBytes,
+	})
+
+	resp, err := a.x509CAService.CreateCertificate(&casapi.CreateCertificateRequest{
+		Template: certTpl,
+		CSR:      cr,
+		Lifetime: lifetime,
+		Backdate: 1 * time.Minute,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating ocsp responder certificate")
+	}
+	if len(resp.CertificateChain) == 0 {
+		return nil, errors.New("error creating ocsp responder certificate: certificate chain is empty")
+	}
+
+	return &ocspResponder{
+		Certificate: resp.Certificate,
+		Issuer:      resp.CertificateChain[0],
+		Signer:      signer,
+	}, nil
+}
+
+// isOCSPRequestIssuer returns true if the issuer name and key hashes in the
+// request match the given issuer certificate.
+func isOCSPRequestIssuer(req *ocsp.Request, issuer *x509.Certificate) (bool, error) {
+	if !req.HashAlgorithm.Available() {
+		return false, errors.Errorf("unsupported hash algorithm %v", req.HashAlgorithm)
+	}
+
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return false, errors.Wrap(err, "error parsing issuer public key")
+	}
+
+	h := req.HashAlgorithm.New()
+	h.Write(issuer.RawSubject)
+	nameHash := h.Sum(nil)
+
+	h.Reset()
+	h.Write(spki.PublicKey.RightAlign())
+	keyHash := h.Sum(nil)
+
+	return bytes.Equal(req.IssuerNameHash, nameHash) && bytes.Equal(req.IssuerKeyHash, keyHash), nil
+}
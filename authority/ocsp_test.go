@@ -0,0 +1,166 @@
+package authority
+
+import (
+	"crypto/x509"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/config"
+	"github.com/smallstep/certificates/db"
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestAuthority_GetOCSPResponse(t *testing.T) {
+	a := testAuthority(t)
+	issuer := getDefaultIssuer(a)
+	signer := getDefaultSigner(a)
+	leaf := generateCertificate(t, "test.smallstep.com", []string{"test.smallstep.com"}, withSigner(issuer, signer))
+	otherRoot, otherSigner := generateRootCertificate(t)
+	otherLeaf := generateCertificate(t, "other.smallstep.com", []string{"other.smallstep.com"}, withSigner(otherRoot, otherSigner))
+
+	mustRequest := func(cert, issuer *x509.Certificate) []byte {
+		b, err := ocsp.CreateRequest(cert, issuer, nil)
+		assert.FatalError(t, err)
+		return b
+	}
+
+	revokedAt := time.Now().Add(-time.Hour).Truncate(time.Second).UTC()
+	newDB := func(isRevoked bool, getErr error) *db.MockAuthDB {
+		return &db.MockAuthDB{
+			MIsRevoked: func(sn string) (bool, error) {
+				return isRevoked, nil
+			},
+			MGetRevokedCert: func(sn string) (*db.RevokedCertificateInfo, error) {
+				return &db.RevokedCertificateInfo{
+					Serial:     sn,
+					ReasonCode: ocsp.KeyCompromise,
+					RevokedAt:  revokedAt,
+				}, nil
+			},
+			MGetCertificate: func(sn string) (*x509.Certificate, error) {
+				if getErr != nil {
+					return nil, getErr
+				}
+				return leaf, nil
+			},
+		}
+	}
+
+	type test struct {
+		auth          *Authority
+		der           []byte
+		wantRaw       []byte
+		wantStatus    int
+		wantReason    int
+		wantResponder bool
+		err           error
+	}
+	tests := map[string]func(t *testing.T) *test{
+		"fail/disabled": func(t *testing.T) *test {
+			return &test{
+				auth: testAuthority(t),
+				der:  mustRequest(leaf, issuer),
+				err:  errors.New("authority.GetOCSPResponse; ocsp responder is not enabled"),
+			}
+		},
+		"ok/malformed": func(t *testing.T) *test {
+			a := testAuthority(t, WithDatabase(newDB(false, nil)))
+			a.config.OCSP = &config.OCSPConfig{Enabled: true}
+			assert.FatalError(t, a.config.OCSP.Validate())
+			return &test{
+				auth:    a,
+				der:     []byte("foo"),
+				wantRaw: ocsp.MalformedRequestErrorResponse,
+			}
+		},
+		"ok/unauthorized": func(t *testing.T) *test {
+			a := testAuthority(t, WithDatabase(newDB(false, nil)))
+			a.config.OCSP = &config.OCSPConfig{Enabled: true}
+			assert.FatalError(t, a.config.OCSP.Validate())
+			return &test{
+				auth:    a,
+				der:     mustRequest(otherLeaf, otherRoot),
+				wantRaw: ocsp.UnauthorizedErrorResponse,
+			}
+		},
+		"ok/good": func(t *testing.T) *test {
+			a := testAuthority(t, WithDatabase(newDB(false, nil)))
+			a.config.OCSP = &config.OCSPConfig{Enabled: true}
+			assert.FatalError(t, a.config.OCSP.Validate())
+			return &test{
+				auth:       a,
+				der:        mustRequest(leaf, issuer),
+				wantStatus: ocsp.Good,
+			}
+		},
+		"ok/unknown": func(t *testing.T) *test {
+			a := testAuthority(t, WithDatabase(newDB(false, errors.New("not found"))))
+			a.config.OCSP = &config.OCSPConfig{Enabled: true}
+			assert.FatalError(t, a.config.OCSP.Validate())
+			return &test{
+				auth:       a,
+				der:        mustRequest(leaf, issuer),
+				wantStatus: ocsp.Unknown,
+			}
+		},
+		"ok/revoked": func(t *testing.T) *test {
+			a := testAuthority(t, WithDatabase(newDB(true, nil)))
+			a.config.OCSP = &config.OCSPConfig{Enabled: true}
+			assert.FatalError(t, a.config.OCSP.Validate())
+			return &test{
+				auth:       a,
+				der:        mustRequest(leaf, issuer),
+				wantStatus: ocsp.Revoked,
+				wantReason: ocsp.KeyCompromise,
+			}
+		},
+		"ok/delegated": func(t *testing.T) *test {
+			a := testAuthority(t, WithDatabase(newDB(false, nil)))
+			a.config.OCSP = &config.OCSPConfig{Enabled: true, Delegated: true}
+			assert.FatalError(t, a.config.OCSP.Validate())
+			return &test{
+				auth:          a,
+				der:           mustRequest(leaf, issuer),
+				wantStatus:    ocsp.Good,
+				wantResponder: true,
+			}
+		},
+	}
+	for name, genTestCase := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := genTestCase(t)
+			resp, err := tc.auth.GetOCSPResponse(tc.der)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+				return
+			}
+			assert.Nil(t, tc.err)
+
+			if tc.wantRaw != nil {
+				assert.Equals(t, tc.wantRaw, resp.Raw)
+				return
+			}
+
+			res, err := ocsp.ParseResponseForCert(resp.Raw, leaf, issuer)
+			assert.FatalError(t, err)
+			assert.Equals(t, tc.wantStatus, res.Status)
+			assert.Equals(t, leaf.SerialNumber, res.SerialNumber)
+			assert.Equals(t, resp.NextUpdate.Unix(), res.NextUpdate.Unix())
+			if tc.wantStatus == ocsp.Revoked {
+				assert.Equals(t, tc.wantReason, res.RevocationReason)
+				assert.Equals(t, revokedAt.Unix(), res.RevokedAt.Unix())
+			}
+			if tc.wantResponder {
+				if assert.NotNil(t, res.Certificate) {
+					assert.Equals(t, []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning}, res.Certificate.ExtKeyUsage)
+				}
+			} else {
+				assert.Nil(t, res.Certificate)
+			}
+		})
+	}
+}
@@ -105,6 +105,7 @@ func WithX509Signer(crt *x509.Certificate, s crypto.Signer) Option {
 			return err
 		}
 		a.x509CAService = srv
+		a.intermediateX509Certs = []*x509.Certificate{crt}
 		return nil
 	}
 }
@@ -0,0 +1,101 @@
+package authority
+
+import (
+	"context"
+
+	"github.com/smallstep/certificates/authority/admin"
+	"github.com/smallstep/certificates/authority/policy"
+)
+
+// GetAuthorityPolicy returns the name-constraint policy of the authority. The
+// policy stored in the admin database takes precedence over the one in the
+// configuration.
+func (a *Authority) GetAuthorityPolicy() *policy.Options {
+	a.adminMutex.RLock()
+	defer a.adminMutex.RUnlock()
+	return a.policyOptions
+}
+
+// getNamePolicy returns the engine of the name-constraint policy of the
+// authority, it is replaced when the admin resources are reloaded.
+func (a *Authority) getNamePolicy() *policy.Engine {
+	a.adminMutex.RLock()
+	defer a.adminMutex.RUnlock()
+	return a.namePolicy
+}
+
+// UpdateAuthorityPolicy stores the name-constraint policy of the authority in
+// the admin database and reloads it.
+func (a *Authority) UpdateAuthorityPolicy(ctx context.Context, pol *policy.Options) error {
+	return a.updatePolicy(ctx, admin.AuthorityPolicyID, pol)
+}
+
+// RemoveAuthorityPolicy removes the name-constraint policy of the authority
+// from the admin database. The policy in the configuration, if any, will be
+// used after this.
+func (a *Authority) RemoveAuthorityPolicy(ctx context.Context) error {
+	return a.removePolicy(ctx, admin.AuthorityPolicyID)
+}
+
+// GetProvisionerPolicy returns the name-constraint policy of the provisioner
+// with the given id. It returns a nil policy if the provisioner does not have
+// one.
+func (a *Authority) GetProvisionerPolicy(ctx context.Context, id string) (*policy.Options, error) {
+	a.adminMutex.RLock()
+	defer a.adminMutex.RUnlock()
+	return a.getPolicy(ctx, id)
+}
+
+// UpdateProvisionerPolicy stores the name-constraint policy of the provisioner
+// with the given id and reloads the provisioner.
+func (a *Authority) UpdateProvisionerPolicy(ctx context.Context, id string, pol *policy.Options) error {
+	return a.updatePolicy(ctx, id, pol)
+}
+
+// RemoveProvisionerPolicy removes the name-constraint policy of the
+// provisioner with the given id.
+func (a *Authority) RemoveProvisionerPolicy(ctx context.Context, id string) error {
+	return a.removePolicy(ctx, id)
+}
+
+// getPolicy returns the policy with the given id from the admin database, or
+// nil if it does not exist.
+func (a *Authority) getPolicy(ctx context.Context, id string) (*policy.Options, error) {
+	pol, err := a.adminDB.GetPolicy(ctx, id)
+	if err != nil {
+		if k, ok := err.(*admin.Error); ok && k.IsType(admin.ErrorNotFoundType) {
+			return nil, nil
+		}
+		return nil, admin.WrapErrorISE(err, "error getting policy %s", id)
+	}
+	return pol, nil
+}
+
+func (a *Authority) updatePolicy(ctx context.Context, id string, pol *policy.Options) error {
+	a.adminMutex.Lock()
+	defer a.adminMutex.Unlock()
+
+	if err := pol.Validate(); err != nil {
+		return admin.WrapError(admin.ErrorBadRequestType, err, "error validating policy")
+	}
+	if err := a.adminDB.UpdatePolicy(ctx, id, pol); err != nil {
+		return admin.WrapErrorISE(err, "error updating policy %s", id)
+	}
+	if err := a.reloadAdminResources(ctx); err != nil {
+		return admin.WrapErrorISE(err, "error reloading admin resources on policy update")
+	}
+	return nil
+}
+
+func (a *Authority) removePolicy(ctx context.Context, id string) error {
+	a.adminMutex.Lock()
+	defer a.adminMutex.Unlock()
+
+	if err := a.adminDB.DeletePolicy(ctx, id); err != nil {
+		return admin.WrapErrorISE(err, "error deleting policy %s", id)
+	}
+	if err := a.reloadAdminResources(ctx); err != nil {
+		return admin.WrapErrorISE(err, "error reloading admin resources on policy removal")
+	}
+	return nil
+}
@@ -0,0 +1,397 @@
+package policy
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// Name types used in the errors.
+const (
+	DNSNameType       = "dns"
+	IPNameType        = "ip"
+	EmailNameType     = "email"
+	URINameType       = "uri"
+	PrincipalNameType = "principal"
+)
+
+// NamePolicyError is the error returned when a name in a certificate is not
+// allowed by a policy.
+type NamePolicyError struct {
+	NameType string
+	Name     string
+	Denied   bool
+}
+
+// Error implements the error interface.
+func (e *NamePolicyError) Error() string {
+	if e.Denied {
+		return fmt.Sprintf("%s name %q is denied by the policy", e.NameType, e.Name)
+	}
+	return fmt.Sprintf("%s name %q is not allowed by the policy", e.NameType, e.Name)
+}
+
+// Engine is a compiled name-constraint policy. A nil engine allows any name.
+type Engine struct {
+	x509Allow *nameSet
+	x509Deny  *nameSet
+	sshAllow  *nameSet
+	sshDeny   *nameSet
+	spiffe    *spiffeConstraint
+}
+
+// New compiles the given policy options. It returns a nil engine if there are
+// no options.
+func New(o *Options) (*Engine, error) {
+	if o == nil {
+		return nil, nil
+	}
+
+	var err error
+	e := new(Engine)
+	if x := o.GetX509Options(); x != nil {
+		if e.x509Allow, err = newX509NameSet(x.Allow); err != nil {
+			return nil, errors.Wrap(err, "error parsing x509 allowed names")
+		}
+		if e.x509Deny, err = newX509NameSet(x.Deny); err != nil {
+			return nil, errors.Wrap(err, "error parsing x509 denied names")
+		}
+		if e.spiffe, err = newSPIFFEConstraint(x.SPIFFE); err != nil {
+			return nil, errors.Wrap(err, "error parsing x509 spiffe options")
+		}
+	}
+	if s := o.GetSSHOptions(); s != nil {
+		if e.sshAllow, err = newSSHNameSet(s.Allow); err != nil {
+			return nil, errors.Wrap(err, "error parsing ssh allowed names")
+		}
+		if e.sshDeny, err = newSSHNameSet(s.Deny); err != nil {
+			return nil, errors.Wrap(err, "error parsing ssh denied names")
+		}
+	}
+	if e.x509Allow.isEmpty() && e.x509Deny.isEmpty() && e.sshAllow.isEmpty() && e.sshDeny.isEmpty() && e.spiffe == nil {
+		return nil, nil
+	}
+	return e, nil
+}
+
+// IsX509CertificateAllowed returns an error if any of the names in the given
+// certificate is not allowed by the policy. The subject common name is also
+// checked if it looks like a DNS name, an IP or an email address. If the
+// policy has SPIFFE options, the certificate must also be an X509-SVID of the
+// trust domain.
+func (e *Engine) IsX509CertificateAllowed(cert *x509.Certificate) error {
+	if e == nil {
+		return nil
+	}
+	if err := e.spiffe.isAllowed(cert); err != nil {
+		return err
+	}
+	if e.x509Allow.isEmpty() && e.x509Deny.isEmpty() {
+		return nil
+	}
+
+	var names []name
+	for _, s := range cert.DNSNames {
+		names = append(names, name{DNSNameType, s})
+	}
+	for _, ip := range cert.IPAddresses {
+		names = append(names, name{IPNameType, ip.String()})
+	}
+	for _, s := range cert.EmailAddresses {
+		names = append(names, name{EmailNameType, s})
+	}
+	for _, u := range cert.URIs {
+		names = append(names, name{URINameType, u.String()})
+	}
+	if cn := commonName(cert.Subject.CommonName); cn.value != "" && !containsName(names, cn) {
+		names = append(names, cn)
+	}
+	return checkNames(names, e.x509Allow, e.x509Deny)
+}
+
+// IsSSHCertificateAllowed returns an error if any of the principals in the
+// given certificate is not allowed by the policy.
+func (e *Engine) IsSSHCertificateAllowed(cert *ssh.Certificate) error {
+	if e == nil || (e.sshAllow.isEmpty() && e.sshDeny.isEmpty()) {
+		return nil
+	}
+
+	names := make([]name, len(cert.ValidPrincipals))
+	for i, p := range cert.ValidPrincipals {
+		switch {
+		case cert.CertType == ssh.UserCert:
+			names[i] = name{PrincipalNameType, p}
+		case net.ParseIP(p) != nil:
+			names[i] = name{IPNameType, p}
+		default:
+			names[i] = name{DNSNameType, p}
+		}
+	}
+	return checkNames(names, e.sshAllow, e.sshDeny)
+}
+
+type name struct {
+	typ   string
+	value string
+}
+
+// commonName returns the name type of the given common name, or an empty name
+// if it does not look like a DNS name, an IP or an email address.
+func commonName(cn string) name {
+	switch {
+	case cn == "":
+		return name{}
+	case net.ParseIP(cn) != nil:
+		return name{IPNameType, cn}
+	case strings.Contains(cn, "@"):
+		return name{EmailNameType, cn}
+	case strings.Contains(cn, "://"):
+		return name{URINameType, cn}
+	}
+	for _, c := range cn {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-._*", c)) {
+			return name{}
+		}
+	}
+	return name{DNSNameType, cn}
+}
+
+func containsName(names []name, n name) bool {
+	for _, v := range names {
+		if v.typ == n.typ && strings.EqualFold(v.value, n.value) {
+			return true
+		}
+	}
+	return false
+}
+
+func checkNames(names []name, allow, deny *nameSet) error {
+	for _, n := range names {
+		if deny.matches(n) || deny.coversWildcard(n) {
+			return &NamePolicyError{NameType: n.typ, Name: n.value, Denied: true}
+		}
+		if !allow.isEmpty() && !allow.matches(n) {
+			return &NamePolicyError{NameType: n.typ, Name: n.value}
+		}
+	}
+	return nil
+}
+
+// nameSet is a compiled list of names.
+type nameSet struct {
+	dns        []string
+	ips        []*net.IPNet
+	emails     []string
+	uris       []string
+	principals []string
+}
+
+func newX509NameSet(o *X509NameOptions) (*nameSet, error) {
+	if o.IsEmpty() {
+		return nil, nil
+	}
+	s := new(nameSet)
+	if err := s.addDNS(o.DNSDomains); err != nil {
+		return nil, err
+	}
+	if err := s.addIPs(o.IPRanges); err != nil {
+		return nil, err
+	}
+	for _, v := range o.EmailAddresses {
+		v = strings.ToLower(strings.TrimSpace(v))
+		domain := v
+		if i := strings.LastIndex(v, "@"); i >= 0 {
+			if i == 0 {
+				return nil, errors.Errorf("email %q is not valid", v)
+			}
+			domain = v[i+1:]
+		}
+		if err := validateDNSPattern(domain); err != nil {
+			return nil, errors.Wrapf(err, "email %q is not valid", v)
+		}
+		s.emails = append(s.emails, v)
+	}
+	for _, v := range o.URIDomains {
+		if v = strings.TrimSpace(v); v == "" {
+			return nil, errors.New("uri cannot be empty")
+		}
+		s.uris = append(s.uris, v)
+	}
+	return s, nil
+}
+
+func newSSHNameSet(o *SSHNameOptions) (*nameSet, error) {
+	if o.IsEmpty() {
+		return nil, nil
+	}
+	s := new(nameSet)
+	if err := s.addDNS(o.DNSDomains); err != nil {
+		return nil, err
+	}
+	if err := s.addIPs(o.IPRanges); err != nil {
+		return nil, err
+	}
+	for _, v := range o.Principals {
+		if v = strings.TrimSpace(v); v == "" {
+			return nil, errors.New("principal cannot be empty")
+		}
+		s.principals = append(s.principals, v)
+	}
+	return s, nil
+}
+
+func (s *nameSet) addDNS(domains []string) error {
+	for _, v := range domains {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if err := validateDNSPattern(v); err != nil {
+			return err
+		}
+		s.dns = append(s.dns, v)
+	}
+	return nil
+}
+
+func (s *nameSet) addIPs(ranges []string) error {
+	for _, v := range ranges {
+		v = strings.TrimSpace(v)
+		if strings.Contains(v, "/") {
+			_, ipNet, err := net.ParseCIDR(v)
+			if err != nil {
+				return errors.Errorf("ip range %q is not valid", v)
+			}
+			s.ips = append(s.ips, ipNet)
+			continue
+		}
+		ip := net.ParseIP(v)
+		if ip == nil {
+			return errors.Errorf("ip %q is not valid", v)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			s.ips = append(s.ips, &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)})
+		} else {
+			s.ips = append(s.ips, &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)})
+		}
+	}
+	return nil
+}
+
+func (s *nameSet) isEmpty() bool {
+	return s == nil || (len(s.dns) == 0 && len(s.ips) == 0 && len(s.emails) == 0 &&
+		len(s.uris) == 0 && len(s.principals) == 0)
+}
+
+func (s *nameSet) matches(n name) bool {
+	if s == nil {
+		return false
+	}
+	switch n.typ {
+	case DNSNameType:
+		v := strings.TrimSuffix(strings.ToLower(n.value), ".")
+		for _, pattern := range s.dns {
+			if matchDNS(pattern, v) {
+				return true
+			}
+		}
+	case IPNameType:
+		ip := net.ParseIP(n.value)
+		for _, ipNet := range s.ips {
+			if ip != nil && ipNet.Contains(ip) {
+				return true
+			}
+		}
+	case EmailNameType:
+		v := strings.ToLower(n.value)
+		i := strings.LastIndex(v, "@")
+		if i <= 0 {
+			return false
+		}
+		for _, pattern := range s.emails {
+			if strings.Contains(pattern, "@") {
+				if pattern == v {
+					return true
+				}
+			} else if matchDNS(pattern, v[i+1:]) {
+				return true
+			}
+		}
+	case URINameType:
+		for _, pattern := range s.uris {
+			if matchGlob(pattern, n.value) {
+				return true
+			}
+		}
+	case PrincipalNameType:
+		for _, pattern := range s.principals {
+			if matchGlob(pattern, n.value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// coversWildcard returns true if the name is a wildcard DNS name, and one of
+// the DNS patterns of the set is a subdomain of it. Without this check a
+// wildcard name, e.g. "*.example.com", would bypass the denied names of its
+// domain, e.g. "secret.example.com".
+func (s *nameSet) coversWildcard(n name) bool {
+	if s == nil || n.typ != DNSNameType {
+		return false
+	}
+	v := strings.TrimSuffix(strings.ToLower(n.value), ".")
+	if !strings.HasPrefix(v, "*.") {
+		return false
+	}
+	domain := v[1:]
+	for _, pattern := range s.dns {
+		if strings.HasSuffix(strings.TrimPrefix(pattern, "*"), domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateDNSPattern validates a DNS name, optionally prefixed by the "*."
+// wildcard.
+func validateDNSPattern(v string) error {
+	s := strings.TrimPrefix(v, "*.")
+	if s == "" || strings.Contains(s, "*") || strings.HasPrefix(s, ".") ||
+		strings.HasSuffix(s, ".") || strings.Contains(s, "..") {
+		return errors.Errorf("dns name %q is not valid", v)
+	}
+	return nil
+}
+
+// matchDNS returns true if the name is equal to the pattern or if it is a
+// subdomain of a wildcard pattern.
+func matchDNS(pattern, v string) bool {
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(v, pattern[1:]) && len(v) > len(pattern)-1
+	}
+	return pattern == v
+}
+
+// matchGlob returns true if the name matches the pattern, where "*" matches
+// any sequence of characters.
+func matchGlob(pattern, v string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == v
+	}
+	if !strings.HasPrefix(v, parts[0]) {
+		return false
+	}
+	v = v[len(parts[0]):]
+	for _, p := range parts[1 : len(parts)-1] {
+		i := strings.Index(v, p)
+		if i < 0 {
+			return false
+		}
+		v = v[i+len(p):]
+	}
+	return strings.HasSuffix(v, parts[len(parts)-1])
+}
@@ -0,0 +1,257 @@
+package policy
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/smallstep/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func mustURL(t *testing.T, s string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(s)
+	assert.FatalError(t, err)
+	return u
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		options *Options
+		isNil   bool
+		err     string
+	}{
+		{"ok/nil", nil, true, ""},
+		{"ok/empty", &Options{X509: &X509Options{Allow: &X509NameOptions{}}, SSH: &SSHOptions{}}, true, ""},
+		{"ok/x509", &Options{X509: &X509Options{
+			Allow: &X509NameOptions{
+				DNSDomains:     []string{"*.example.com", "example.com"},
+				IPRanges:       []string{"10.0.0.0/8", "2001:db8::/32", "192.168.1.1", "::1"},
+				EmailAddresses: []string{"jane@example.com", "example.org", "*.example.org"},
+				URIDomains:     []string{"spiffe://example.com/*"},
+			},
+		}}, false, ""},
+		{"ok/ssh", &Options{SSH: &SSHOptions{
+			Deny: &SSHNameOptions{Principals: []string{"root"}},
+		}}, false, ""},
+		{"fail/dns", &Options{X509: &X509Options{Allow: &X509NameOptions{DNSDomains: []string{"foo.*.com"}}}}, true,
+			`error parsing x509 allowed names: dns name "foo.*.com" is not valid`},
+		{"fail/dns-empty", &Options{X509: &X509Options{Deny: &X509NameOptions{DNSDomains: []string{"*."}}}}, true,
+			`error parsing x509 denied names: dns name "*." is not valid`},
+		{"fail/ip", &Options{X509: &X509Options{Allow: &X509NameOptions{IPRanges: []string{"10.0.0.256"}}}}, true,
+			`error parsing x509 allowed names: ip "10.0.0.256" is not valid`},
+		{"fail/ip-range", &Options{X509: &X509Options{Allow: &X509NameOptions{IPRanges: []string{"10.0.0.0/33"}}}}, true,
+			`error parsing x509 allowed names: ip range "10.0.0.0/33" is not valid`},
+		{"fail/email", &Options{X509: &X509Options{Allow: &X509NameOptions{EmailAddresses: []string{"@example.com"}}}}, true,
+			`error parsing x509 allowed names: email "@example.com" is not valid`},
+		{"fail/email-domain", &Options{X509: &X509Options{Allow: &X509NameOptions{EmailAddresses: []string{"jane@"}}}}, true,
+			`error parsing x509 allowed names: email "jane@" is not valid: dns name "" is not valid`},
+		{"fail/uri", &Options{X509: &X509Options{Allow: &X509NameOptions{URIDomains: []string{" "}}}}, true,
+			`error parsing x509 allowed names: uri cannot be empty`},
+		{"fail/principal", &Options{SSH: &SSHOptions{Deny: &SSHNameOptions{Principals: []string{""}}}}, true,
+			`error parsing ssh denied names: principal cannot be empty`},
+		{"fail/ssh-ip", &Options{SSH: &SSHOptions{Allow: &SSHNameOptions{IPRanges: []string{"foo"}}}}, true,
+			`error parsing ssh allowed names: ip "foo" is not valid`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(tt.options)
+			if tt.err != "" {
+				if assert.Error(t, err) {
+					assert.Equals(t, err.Error(), tt.err)
+				}
+				assert.Equals(t, tt.err, tt.options.Validate().Error())
+				return
+			}
+			assert.FatalError(t, err)
+			assert.Nil(t, tt.options.Validate())
+			assert.Equals(t, tt.isNil, got == nil)
+		})
+	}
+}
+
+func TestEngine_IsX509CertificateAllowed(t *testing.T) {
+	allow, err := New(&Options{X509: &X509Options{
+		Allow: &X509NameOptions{
+			DNSDomains:     []string{"*.corp.example.com", "example.com"},
+			IPRanges:       []string{"10.0.0.0/8", "2001:db8::/32"},
+			EmailAddresses: []string{"jane@example.com", "corp.example.com"},
+			URIDomains:     []string{"spiffe://corp/*"},
+		},
+		Deny: &X509NameOptions{
+			DNSDomains: []string{"*.internal.corp.example.com"},
+			IPRanges:   []string{"10.1.2.3"},
+		},
+	}})
+	assert.FatalError(t, err)
+	deny, err := New(&Options{X509: &X509Options{
+		Deny: &X509NameOptions{
+			DNSDomains:     []string{"forbidden.example.com"},
+			EmailAddresses: []string{"*.example.org"},
+			URIDomains:     []string{"https://*"},
+		},
+	}})
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name   string
+		engine *Engine
+		cert   *x509.Certificate
+		err    string
+	}{
+		{"ok/nil", nil, &x509.Certificate{DNSNames: []string{"foo.bar"}}, ""},
+		{"ok/allow", allow, &x509.Certificate{
+			Subject:        pkix.Name{CommonName: "www.corp.example.com"},
+			DNSNames:       []string{"www.corp.example.com", "a.b.corp.example.com", "example.com", "EXAMPLE.COM."},
+			IPAddresses:    []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("2001:db8::1")},
+			EmailAddresses: []string{"jane@example.com", "joe@corp.example.com"},
+			URIs:           []*url.URL{mustURL(t, "spiffe://corp/ns/default/sa/foo")},
+		}, ""},
+		{"ok/common-name-not-a-name", allow, &x509.Certificate{
+			Subject:  pkix.Name{CommonName: "Jane Doe"},
+			DNSNames: []string{"example.com"},
+		}, ""},
+		{"ok/deny", deny, &x509.Certificate{
+			Subject:        pkix.Name{CommonName: "foo"},
+			DNSNames:       []string{"foo.example.com", "*.foo.example.com"},
+			IPAddresses:    []net.IP{net.ParseIP("127.0.0.1")},
+			EmailAddresses: []string{"jane@example.org"},
+			URIs:           []*url.URL{mustURL(t, "spiffe://foo")},
+		}, ""},
+		{"fail/allow-dns", allow, &x509.Certificate{
+			DNSNames: []string{"www.example.com"},
+		}, `dns name "www.example.com" is not allowed by the policy`},
+		{"fail/allow-wildcard-apex", allow, &x509.Certificate{
+			DNSNames: []string{"corp.example.com"},
+		}, `dns name "corp.example.com" is not allowed by the policy`},
+		{"fail/allow-ip", allow, &x509.Certificate{
+			IPAddresses: []net.IP{net.ParseIP("192.168.0.1")},
+		}, `ip name "192.168.0.1" is not allowed by the policy`},
+		{"fail/allow-email", allow, &x509.Certificate{
+			EmailAddresses: []string{"joe@example.com"},
+		}, `email name "joe@example.com" is not allowed by the policy`},
+		{"fail/allow-uri", allow, &x509.Certificate{
+			URIs: []*url.URL{mustURL(t, "spiffe://other/foo")},
+		}, `uri name "spiffe://other/foo" is not allowed by the policy`},
+		{"fail/allow-common-name", allow, &x509.Certificate{
+			Subject:  pkix.Name{CommonName: "localhost"},
+			DNSNames: []string{"example.com"},
+		}, `dns name "localhost" is not allowed by the policy`},
+		{"fail/deny-dns", allow, &x509.Certificate{
+			DNSNames: []string{"db.internal.corp.example.com"},
+		}, `dns name "db.internal.corp.example.com" is denied by the policy`},
+		{"fail/deny-wildcard", deny, &x509.Certificate{
+			DNSNames: []string{"*.example.com"},
+		}, `dns name "*.example.com" is denied by the policy`},
+		{"fail/deny-wildcard-pattern", allow, &x509.Certificate{
+			DNSNames: []string{"*.corp.example.com"},
+		}, `dns name "*.corp.example.com" is denied by the policy`},
+		{"fail/deny-ip", allow, &x509.Certificate{
+			IPAddresses: []net.IP{net.ParseIP("10.1.2.3")},
+		}, `ip name "10.1.2.3" is denied by the policy`},
+		{"fail/deny-email", deny, &x509.Certificate{
+			EmailAddresses: []string{"jane@mail.example.org"},
+		}, `email name "jane@mail.example.org" is denied by the policy`},
+		{"fail/deny-uri", deny, &x509.Certificate{
+			URIs: []*url.URL{mustURL(t, "https://example.com")},
+		}, `uri name "https://example.com" is denied by the policy`},
+		{"fail/deny-common-name", deny, &x509.Certificate{
+			Subject: pkix.Name{CommonName: "forbidden.example.com"},
+		}, `dns name "forbidden.example.com" is denied by the policy`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.engine.IsX509CertificateAllowed(tt.cert)
+			if tt.err != "" {
+				if assert.Error(t, err) {
+					assert.Equals(t, err.Error(), tt.err)
+					_, ok := err.(*NamePolicyError)
+					assert.True(t, ok)
+				}
+				return
+			}
+			assert.Nil(t, err)
+		})
+	}
+}
+
+func TestEngine_IsSSHCertificateAllowed(t *testing.T) {
+	engine, err := New(&Options{SSH: &SSHOptions{
+		Allow: &SSHNameOptions{
+			DNSDomains: []string{"*.corp.example.com"},
+			IPRanges:   []string{"10.0.0.0/8"},
+			Principals: []string{"*@corp.example.com", "ops-*"},
+		},
+		Deny: &SSHNameOptions{
+			DNSDomains: []string{"bastion.corp.example.com"},
+			Principals: []string{"ops-root"},
+		},
+	}})
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name   string
+		engine *Engine
+		cert   *ssh.Certificate
+		err    string
+	}{
+		{"ok/nil", nil, &ssh.Certificate{CertType: ssh.UserCert, ValidPrincipals: []string{"root"}}, ""},
+		{"ok/user", engine, &ssh.Certificate{CertType: ssh.UserCert, ValidPrincipals: []string{"jane@corp.example.com", "ops-admin"}}, ""},
+		{"ok/host", engine, &ssh.Certificate{CertType: ssh.HostCert, ValidPrincipals: []string{"www.corp.example.com", "10.0.0.1"}}, ""},
+		{"fail/user", engine, &ssh.Certificate{CertType: ssh.UserCert, ValidPrincipals: []string{"jane"}},
+			`principal name "jane" is not allowed by the policy`},
+		{"fail/user-denied", engine, &ssh.Certificate{CertType: ssh.UserCert, ValidPrincipals: []string{"ops-root"}},
+			`principal name "ops-root" is denied by the policy`},
+		{"fail/host-dns", engine, &ssh.Certificate{CertType: ssh.HostCert, ValidPrincipals: []string{"www.example.com"}},
+			`dns name "www.example.com" is not allowed by the policy`},
+		{"fail/host-ip", engine, &ssh.Certificate{CertType: ssh.HostCert, ValidPrincipals: []string{"192.168.0.1"}},
+			`ip name "192.168.0.1" is not allowed by the policy`},
+		{"fail/host-denied", engine, &ssh.Certificate{CertType: ssh.HostCert, ValidPrincipals: []string{"bastion.corp.example.com"}},
+			`dns name "bastion.corp.example.com" is denied by the policy`},
+		{"fail/host-denied-wildcard", engine, &ssh.Certificate{CertType: ssh.HostCert, ValidPrincipals: []string{"*.corp.example.com"}},
+			`dns name "*.corp.example.com" is denied by the policy`},
+		{"fail/host-as-principal", engine, &ssh.Certificate{CertType: ssh.HostCert, ValidPrincipals: []string{"ops-admin"}},
+			`dns name "ops-admin" is not allowed by the policy`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.engine.IsSSHCertificateAllowed(tt.cert)
+			if tt.err != "" {
+				if assert.Error(t, err) {
+					assert.Equals(t, err.Error(), tt.err)
+				}
+				return
+			}
+			assert.Nil(t, err)
+		})
+	}
+}
+
+func Test_matchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"foo", "foo", true},
+		{"foo", "foobar", false},
+		{"*", "anything", true},
+		{"foo*", "foobar", true},
+		{"*bar", "foobar", true},
+		{"*bar", "barfoo", false},
+		{"spiffe://corp/*", "spiffe://corp/ns/default", true},
+		{"spiffe://corp/*", "spiffe://corporate/ns", false},
+		{"a*b*c", "abc", true},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "acb", false},
+		{"ab*ba", "aba", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+"/"+tt.name, func(t *testing.T) {
+			assert.Equals(t, tt.want, matchGlob(tt.pattern, tt.name))
+		})
+	}
+}
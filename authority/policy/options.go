@@ -0,0 +1,89 @@
+// Package policy implements the name-constraint policies that restrict the
+// names that can be included in the X.509 and SSH certificates signed by the
+// authority.
+package policy
+
+// Options are the name-constraint policies that can be configured globally
+// in the authority and in each provisioner.
+type Options struct {
+	X509 *X509Options `json:"x509,omitempty"`
+	SSH  *SSHOptions  `json:"ssh,omitempty"`
+}
+
+// GetX509Options returns the X.509 policy options.
+func (o *Options) GetX509Options() *X509Options {
+	if o == nil {
+		return nil
+	}
+	return o.X509
+}
+
+// GetSSHOptions returns the SSH policy options.
+func (o *Options) GetSSHOptions() *SSHOptions {
+	if o == nil {
+		return nil
+	}
+	return o.SSH
+}
+
+// Validate returns an error if the policy options are not valid.
+func (o *Options) Validate() error {
+	_, err := New(o)
+	return err
+}
+
+// X509Options are the names allowed and denied in X.509 certificates. If any
+// name is allowed, all the names in a certificate must be allowed. Denied names
+// take precedence over the allowed ones. SPIFFE restricts the certificates to
+// the X509-SVIDs of a trust domain.
+type X509Options struct {
+	Allow  *X509NameOptions `json:"allow,omitempty"`
+	Deny   *X509NameOptions `json:"deny,omitempty"`
+	SPIFFE *SPIFFEOptions   `json:"spiffe,omitempty"`
+}
+
+// X509NameOptions are the lists of names in X.509 certificates.
+//
+// DNS names can be exact names or wildcards like "*.example.com", that match
+// any subdomain of example.com. IP addresses can be single addresses or CIDR
+// ranges. Email addresses can be exact addresses, or a domain, optionally with
+// a wildcard, that matches any mailbox on it. URIs are patterns where "*"
+// matches any sequence of characters, like "spiffe://example.com/*".
+type X509NameOptions struct {
+	DNSDomains     []string `json:"dns,omitempty"`
+	IPRanges       []string `json:"ip,omitempty"`
+	EmailAddresses []string `json:"email,omitempty"`
+	URIDomains     []string `json:"uri,omitempty"`
+}
+
+// IsEmpty returns true if there are no names in the options.
+func (o *X509NameOptions) IsEmpty() bool {
+	return o == nil || (len(o.DNSDomains) == 0 && len(o.IPRanges) == 0 &&
+		len(o.EmailAddresses) == 0 && len(o.URIDomains) == 0)
+}
+
+// SSHOptions are the principals allowed and denied in SSH certificates. If
+// any principal is allowed, all the principals in a certificate must be
+// allowed. Denied principals take precedence over the allowed ones.
+type SSHOptions struct {
+	Allow *SSHNameOptions `json:"allow,omitempty"`
+	Deny  *SSHNameOptions `json:"deny,omitempty"`
+}
+
+// SSHNameOptions are the lists of principals in SSH certificates.
+//
+// The principals of host certificates are checked against the DNS names and
+// IP ranges, using the same format as in X.509 policies. The principals of user
+// certificates are checked against the user principals, patterns where "*"
+// matches any sequence of characters.
+type SSHNameOptions struct {
+	DNSDomains []string `json:"dns,omitempty"`
+	IPRanges   []string `json:"ip,omitempty"`
+	Principals []string `json:"principals,omitempty"`
+}
+
+// IsEmpty returns true if there are no principals in the options.
+func (o *SSHNameOptions) IsEmpty() bool {
+	return o == nil || (len(o.DNSDomains) == 0 && len(o.IPRanges) == 0 &&
+		len(o.Principals) == 0)
+}
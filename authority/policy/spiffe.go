@@ -0,0 +1,159 @@
+package policy
+
+import (
+	"crypto/x509"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// spiffeScheme is the prefix of a SPIFFE ID.
+const spiffeScheme = "spiffe://"
+
+// SPIFFEOptions enable the issuance of X509-SVIDs of a SPIFFE trust domain. If
+// they are set, the X.509 certificates must contain exactly one URI SAN, a
+// SPIFFE ID of the trust domain, and they cannot be CA certificates.
+//
+// The paths are templates of the paths of the SPIFFE IDs, where a "*" segment
+// matches any single segment, like "/ns/*/sa/*". If no paths are set, any
+// path in the trust domain is allowed.
+type SPIFFEOptions struct {
+	TrustDomain        string   `json:"trustDomain"`
+	Paths              []string `json:"paths,omitempty"`
+	DenyDNSNames       bool     `json:"denyDNSNames,omitempty"`
+	DenyEmailAddresses bool     `json:"denyEmailAddresses,omitempty"`
+}
+
+// spiffeConstraint is a compiled SPIFFEOptions.
+type spiffeConstraint struct {
+	trustDomain        string
+	paths              [][]string
+	denyDNSNames       bool
+	denyEmailAddresses bool
+}
+
+func newSPIFFEConstraint(o *SPIFFEOptions) (*spiffeConstraint, error) {
+	if o == nil {
+		return nil, nil
+	}
+	if err := validateSPIFFETrustDomain(o.TrustDomain); err != nil {
+		return nil, err
+	}
+	c := &spiffeConstraint{
+		trustDomain:        o.TrustDomain,
+		denyDNSNames:       o.DenyDNSNames,
+		denyEmailAddresses: o.DenyEmailAddresses,
+	}
+	for _, p := range o.Paths {
+		segments, err := parseSPIFFEPath(p, true)
+		if err != nil {
+			return nil, errors.Wrapf(err, "spiffe path template %q is not valid", p)
+		}
+		c.paths = append(c.paths, segments)
+	}
+	return c, nil
+}
+
+// isAllowed returns an error if the certificate is not an X509-SVID of the
+// trust domain.
+func (c *spiffeConstraint) isAllowed(cert *x509.Certificate) error {
+	if c == nil {
+		return nil
+	}
+	if cert.IsCA {
+		return errors.New("spiffe certificates cannot be CA certificates")
+	}
+	if cert.KeyUsage&x509.KeyUsageDigitalSignature == 0 || cert.KeyUsage&(x509.KeyUsageCertSign|x509.KeyUsageCRLSign) != 0 {
+		return errors.New("spiffe certificates must have the digitalSignature key usage and cannot have the certSign or crlSign key usages")
+	}
+	if len(cert.URIs) != 1 {
+		return errors.Errorf("spiffe certificates must have exactly one URI SAN, found %d", len(cert.URIs))
+	}
+	id := cert.URIs[0].String()
+	if !strings.HasPrefix(id, spiffeScheme) {
+		return &NamePolicyError{NameType: URINameType, Name: id}
+	}
+	td, path := id[len(spiffeScheme):], ""
+	if i := strings.Index(td, "/"); i >= 0 {
+		td, path = td[:i], td[i:]
+	}
+	if td != c.trustDomain {
+		return &NamePolicyError{NameType: URINameType, Name: id}
+	}
+	segments, err := parseSPIFFEPath(path, false)
+	if err != nil {
+		return errors.Wrapf(err, "uri name %q is not a valid SPIFFE ID", id)
+	}
+	if len(c.paths) > 0 && !matchSPIFFEPath(c.paths, segments) {
+		return &NamePolicyError{NameType: URINameType, Name: id}
+	}
+	if c.denyDNSNames && len(cert.DNSNames) > 0 {
+		return &NamePolicyError{NameType: DNSNameType, Name: cert.DNSNames[0], Denied: true}
+	}
+	if c.denyEmailAddresses && len(cert.EmailAddresses) > 0 {
+		return &NamePolicyError{NameType: EmailNameType, Name: cert.EmailAddresses[0], Denied: true}
+	}
+	return nil
+}
+
+// validateSPIFFETrustDomain validates the name of a trust domain. It can only
+// contain lowercase letters, numbers, dots, dashes and underscores.
+func validateSPIFFETrustDomain(td string) error {
+	if td == "" {
+		return errors.New("spiffe trust domain cannot be empty")
+	}
+	for _, c := range td {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.ContainsRune(".-_", c)) {
+			return errors.Errorf("spiffe trust domain %q is not valid", td)
+		}
+	}
+	return nil
+}
+
+// parseSPIFFEPath validates and returns the segments of the path of a SPIFFE
+// ID. The path cannot be empty and the segments can only contain letters,
+// numbers, dots, dashes and underscores, but they cannot be "." or "..". If
+// wildcard is true, the "*" segment is also allowed.
+func parseSPIFFEPath(path string, wildcard bool) ([]string, error) {
+	if !strings.HasPrefix(path, "/") || len(path) == 1 {
+		return nil, errors.New("path must start with / and cannot be empty")
+	}
+	segments := strings.Split(path[1:], "/")
+	for _, s := range segments {
+		switch {
+		case s == "":
+			return nil, errors.New("path cannot contain empty segments")
+		case s == "." || s == "..":
+			return nil, errors.New("path cannot contain . or .. segments")
+		case s == "*" && wildcard:
+			continue
+		}
+		for _, c := range s {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune(".-_", c)) {
+				return nil, errors.Errorf("path segment %q is not valid", s)
+			}
+		}
+	}
+	return segments, nil
+}
+
+// matchSPIFFEPath returns true if the segments of a path match one of the
+// path templates.
+func matchSPIFFEPath(templates [][]string, segments []string) bool {
+	for _, t := range templates {
+		if len(t) != len(segments) {
+			continue
+		}
+		ok := true
+		for i := range t {
+			if t[i] != "*" && t[i] != segments[i] {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
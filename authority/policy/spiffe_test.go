@@ -0,0 +1,133 @@
+package policy
+
+import (
+	"crypto/x509"
+	"net/url"
+	"testing"
+
+	"github.com/smallstep/assert"
+)
+
+func TestNew_spiffe(t *testing.T) {
+	tests := []struct {
+		name    string
+		options *SPIFFEOptions
+		err     string
+	}{
+		{"ok", &SPIFFEOptions{TrustDomain: "example.org"}, ""},
+		{"ok/paths", &SPIFFEOptions{TrustDomain: "example.org", Paths: []string{"/ns/*/sa/*", "/web_server-1.0"}}, ""},
+		{"fail/trust-domain-empty", &SPIFFEOptions{}, "error parsing x509 spiffe options: spiffe trust domain cannot be empty"},
+		{"fail/trust-domain", &SPIFFEOptions{TrustDomain: "Example.org"}, `error parsing x509 spiffe options: spiffe trust domain "Example.org" is not valid`},
+		{"fail/trust-domain-scheme", &SPIFFEOptions{TrustDomain: "spiffe://example.org"}, `error parsing x509 spiffe options: spiffe trust domain "spiffe://example.org" is not valid`},
+		{"fail/path-prefix", &SPIFFEOptions{TrustDomain: "example.org", Paths: []string{"ns/*"}}, `error parsing x509 spiffe options: spiffe path template "ns/*" is not valid: path must start with / and cannot be empty`},
+		{"fail/path-root", &SPIFFEOptions{TrustDomain: "example.org", Paths: []string{"/"}}, `error parsing x509 spiffe options: spiffe path template "/" is not valid: path must start with / and cannot be empty`},
+		{"fail/path-empty-segment", &SPIFFEOptions{TrustDomain: "example.org", Paths: []string{"/ns//sa"}}, `error parsing x509 spiffe options: spiffe path template "/ns//sa" is not valid: path cannot contain empty segments`},
+		{"fail/path-dot-segment", &SPIFFEOptions{TrustDomain: "example.org", Paths: []string{"/ns/.."}}, `error parsing x509 spiffe options: spiffe path template "/ns/.." is not valid: path cannot contain . or .. segments`},
+		{"fail/path-segment", &SPIFFEOptions{TrustDomain: "example.org", Paths: []string{"/ns/foo*"}}, `error parsing x509 spiffe options: spiffe path template "/ns/foo*" is not valid: path segment "foo*" is not valid`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(&Options{X509: &X509Options{SPIFFE: tt.options}})
+			if tt.err != "" {
+				if assert.Error(t, err) {
+					assert.Equals(t, tt.err, err.Error())
+				}
+				return
+			}
+			assert.FatalError(t, err)
+			assert.NotNil(t, got)
+		})
+	}
+}
+
+func TestEngine_IsX509CertificateAllowed_spiffe(t *testing.T) {
+	spiffe, err := New(&Options{X509: &X509Options{
+		SPIFFE: &SPIFFEOptions{TrustDomain: "example.org"},
+	}})
+	assert.FatalError(t, err)
+	paths, err := New(&Options{X509: &X509Options{
+		Allow: &X509NameOptions{
+			DNSDomains: []string{"*.example.org"},
+			URIDomains: []string{"spiffe://example.org/*"},
+		},
+		SPIFFE: &SPIFFEOptions{
+			TrustDomain:        "example.org",
+			Paths:              []string{"/ns/*/sa/*", "/web"},
+			DenyEmailAddresses: true,
+		},
+	}})
+	assert.FatalError(t, err)
+	deny, err := New(&Options{X509: &X509Options{
+		SPIFFE: &SPIFFEOptions{
+			TrustDomain:        "example.org",
+			DenyDNSNames:       true,
+			DenyEmailAddresses: true,
+		},
+	}})
+	assert.FatalError(t, err)
+
+	svid := func(dnsNames []string, uris ...string) *x509.Certificate {
+		cert := &x509.Certificate{
+			DNSNames: dnsNames,
+			KeyUsage: x509.KeyUsageDigitalSignature,
+		}
+		for _, s := range uris {
+			cert.URIs = append(cert.URIs, mustURL(t, s))
+		}
+		return cert
+	}
+
+	tests := []struct {
+		name            string
+		engine          *Engine
+		cert            *x509.Certificate
+		err             string
+		namePolicyError bool
+	}{
+		{"ok", spiffe, svid(nil, "spiffe://example.org/foo"), "", false},
+		{"ok/dns", spiffe, svid([]string{"foo.example.com"}, "spiffe://example.org/foo/bar"), "", false},
+		{"ok/paths", paths, svid([]string{"www.example.org"}, "spiffe://example.org/ns/default/sa/web-1"), "", false},
+		{"ok/paths-literal", paths, svid(nil, "spiffe://example.org/web"), "", false},
+		{"ok/deny", deny, svid(nil, "spiffe://example.org/foo"), "", false},
+		{"fail/ca", spiffe, &x509.Certificate{
+			IsCA:     true,
+			KeyUsage: x509.KeyUsageCertSign,
+			URIs:     []*url.URL{mustURL(t, "spiffe://example.org/foo")},
+		}, "spiffe certificates cannot be CA certificates", false},
+		{"fail/key-usage", spiffe, &x509.Certificate{
+			KeyUsage: x509.KeyUsageKeyEncipherment,
+			URIs:     []*url.URL{mustURL(t, "spiffe://example.org/foo")},
+		}, "spiffe certificates must have the digitalSignature key usage and cannot have the certSign or crlSign key usages", false},
+		{"fail/no-uri", spiffe, svid([]string{"foo.example.org"}), "spiffe certificates must have exactly one URI SAN, found 0", false},
+		{"fail/multiple-uris", spiffe, svid(nil, "spiffe://example.org/foo", "spiffe://example.org/bar"), "spiffe certificates must have exactly one URI SAN, found 2", false},
+		{"fail/scheme", spiffe, svid(nil, "https://example.org/foo"), `uri name "https://example.org/foo" is not allowed by the policy`, true},
+		{"fail/trust-domain", spiffe, svid(nil, "spiffe://example.com/foo"), `uri name "spiffe://example.com/foo" is not allowed by the policy`, true},
+		{"fail/port", spiffe, svid(nil, "spiffe://example.org:443/foo"), `uri name "spiffe://example.org:443/foo" is not allowed by the policy`, true},
+		{"fail/root", spiffe, svid(nil, "spiffe://example.org"), `uri name "spiffe://example.org" is not a valid SPIFFE ID: path must start with / and cannot be empty`, false},
+		{"fail/trailing-slash", spiffe, svid(nil, "spiffe://example.org/foo/"), `uri name "spiffe://example.org/foo/" is not a valid SPIFFE ID: path cannot contain empty segments`, false},
+		{"fail/query", spiffe, svid(nil, "spiffe://example.org/foo?bar=baz"), `uri name "spiffe://example.org/foo?bar=baz" is not a valid SPIFFE ID: path segment "foo?bar=baz" is not valid`, false},
+		{"fail/path", paths, svid(nil, "spiffe://example.org/ns/default"), `uri name "spiffe://example.org/ns/default" is not allowed by the policy`, true},
+		{"fail/path-literal", paths, svid(nil, "spiffe://example.org/api"), `uri name "spiffe://example.org/api" is not allowed by the policy`, true},
+		{"fail/allow-dns", paths, svid([]string{"www.example.com"}, "spiffe://example.org/web"), `dns name "www.example.com" is not allowed by the policy`, true},
+		{"fail/deny-dns", deny, svid([]string{"foo.example.org"}, "spiffe://example.org/foo"), `dns name "foo.example.org" is denied by the policy`, true},
+		{"fail/deny-email", paths, &x509.Certificate{
+			KeyUsage:       x509.KeyUsageDigitalSignature,
+			EmailAddresses: []string{"jane@example.org"},
+			URIs:           []*url.URL{mustURL(t, "spiffe://example.org/web")},
+		}, `email name "jane@example.org" is denied by the policy`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.engine.IsX509CertificateAllowed(tt.cert)
+			if tt.err != "" {
+				if assert.Error(t, err) {
+					assert.Equals(t, tt.err, err.Error())
+					_, ok := err.(*NamePolicyError)
+					assert.Equals(t, tt.namePolicyError, ok)
+				}
+				return
+			}
+			assert.Nil(t, err)
+		})
+	}
+}
@@ -3,23 +3,331 @@ package provisioner
 import (
 	"context"
 	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/certificates/errs"
+	"go.step.sm/crypto/x509util"
 )
 
 // ACME is the acme provisioner type, an entity that can authorize the ACME
 // provisioning flow.
 type ACME struct {
 	*base
-	ID      string   `json:"-"`
-	Type    string   `json:"type"`
-	Name    string   `json:"name"`
-	ForceCN bool     `json:"forceCN,omitempty"`
-	Claims  *Claims  `json:"claims,omitempty"`
-	Options *Options `json:"options,omitempty"`
-	claimer *Claimer
+	ID      string `json:"-"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	ForceCN bool   `json:"forceCN,omitempty"`
+	// RequireEAB makes the provisioner require ACME External Account
+	// Binding, new accounts can only be created using an EAB key created
+	// through the admin API.
+	RequireEAB bool `json:"requireEAB,omitempty"`
+	// RenewalInfo configures the suggested renewal windows returned by the
+	// ACME Renewal Information (ARI) resource.
+	RenewalInfo *ACMERenewalInfo `json:"renewalInfo,omitempty"`
+	// ChallengeValidation configures the retries of the background
+	// validation of ACME challenges.
+	ChallengeValidation *ACMEChallengeValidation `json:"challengeValidation,omitempty"`
+	// CAA enables the verification of the CAA records (RFC 8659) of the DNS
+	// identifiers before issuing a certificate.
+	CAA *ACMECAA `json:"caa,omitempty"`
+	// Policy restricts the DNS names and IP addresses the provisioner can
+	// issue certificates for.
+	Policy *ACMEPolicy `json:"policy,omitempty"`
+	// RateLimits configures the limits of orders, certificates, failed
+	// validations and accounts enforced by the ACME API.
+	RateLimits *ACMERateLimits `json:"rateLimits,omitempty"`
+	// AuthorizationLifetime is the time a valid authorization can be reused
+	// by new orders of the same account for the same identifier. Valid
+	// authorizations are not reused if it is not set.
+	AuthorizationLifetime *Duration `json:"authorizationLifetime,omitempty"`
+	// Profiles are named certificate profiles that ACME clients can select
+	// with the profile field of a new order.
+	Profiles []*ACMEProfile `json:"profiles,omitempty"`
+	// AttestationFormats enables device-attest-01 challenges for
+	// permanent-identifier identifiers, only the attestation statements of
+	// the given formats are accepted.
+	AttestationFormats []ACMEAttestationFormat `json:"attestationFormats,omitempty"`
+	// AttestationRoots is a PEM bundle with the root certificates used to
+	// verify the attestation certificates.
+	AttestationRoots []byte   `json:"attestationRoots,omitempty"`
+	Claims           *Claims  `json:"claims,omitempty"`
+	Options          *Options `json:"options,omitempty"`
+	claimer          *Claimer
+	namePolicy       *policy.Engine
+	webhooks         *webhookController
+	attestationRoots *x509.CertPool
+}
+
+// ACMEAttestationFormat is the format of the attestation statement of a
+// device-attest-01 challenge.
+type ACMEAttestationFormat string
+
+const (
+	// ACMEAttestationApple is the format of the attestations of the Apple
+	// managed devices.
+	ACMEAttestationApple ACMEAttestationFormat = "apple"
+	// ACMEAttestationStep is the format of the attestations of the keys
+	// generated in a YubiKey PIV slot.
+	ACMEAttestationStep ACMEAttestationFormat = "step"
+	// ACMEAttestationTPM is the format of the attestations of the keys
+	// certified by a TPM 2.0 attestation key.
+	ACMEAttestationTPM ACMEAttestationFormat = "tpm"
+	// ACMEAttestationPacked is the format of the attestations signed with a
+	// key whose certificate includes the permanent identifier.
+	ACMEAttestationPacked ACMEAttestationFormat = "packed"
+)
+
+// Validate returns an error if the attestation format is not supported.
+func (f ACMEAttestationFormat) Validate() error {
+	switch f {
+	case ACMEAttestationApple, ACMEAttestationStep, ACMEAttestationTPM, ACMEAttestationPacked:
+		return nil
+	default:
+		return errors.Errorf("attestation format %s is not supported", f)
+	}
+}
+
+// ACMEProfile is a named certificate profile of an ACME provisioner. The
+// claims and options of a profile overwrite the provisioner ones in the orders
+// that select it. The name-constraint policy is always the one defined in the
+// provisioner options.
+type ACMEProfile struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Claims      *Claims  `json:"claims,omitempty"`
+	Options     *Options `json:"options,omitempty"`
+	claimer     *Claimer
+	options     *Options
+	webhooks    *webhookController
+}
+
+// DefaultTLSCertDuration returns the default TLS cert duration of the profile.
+func (p *ACMEProfile) DefaultTLSCertDuration() time.Duration {
+	return p.claimer.DefaultTLSCertDuration()
+}
+
+// MinTLSCertDuration returns the minimum TLS cert duration of the profile.
+func (p *ACMEProfile) MinTLSCertDuration() time.Duration {
+	return p.claimer.MinTLSCertDuration()
+}
+
+// MaxTLSCertDuration returns the maximum TLS cert duration of the profile.
+func (p *ACMEProfile) MaxTLSCertDuration() time.Duration {
+	return p.claimer.MaxTLSCertDuration()
+}
+
+// GetOptions returns the options of the profile, or the provisioner options if
+// the profile does not define them.
+func (p *ACMEProfile) GetOptions() *Options {
+	return p.options
+}
+
+// WebhookOption returns the sign option that calls the webhooks of the
+// profile options with the certificate request. The responses are stored in
+// the given template data, so it must be the data of the template options.
+func (p *ACMEProfile) WebhookOption(data x509util.TemplateData) SignOption {
+	return p.webhooks.x509Option(data)
+}
+
+type acmeProfileKey struct{}
+
+// NewContextWithACMEProfile creates a new context from ctx and attaches the
+// name of the ACME profile used to sign a certificate.
+func NewContextWithACMEProfile(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, acmeProfileKey{}, name)
+}
+
+// ACMEProfileFromContext returns the name of the ACME profile saved in ctx.
+func ACMEProfileFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(acmeProfileKey{}).(string)
+	return name
+}
+
+// ACMERateLimits configures the rate limits of an ACME provisioner. The
+// counters are stored in the ACME database, so the limits are shared by all
+// the instances using the same database. Unset limits are not enforced.
+type ACMERateLimits struct {
+	// OrdersPerAccount limits the new orders of an account. The default
+	// window is one hour.
+	OrdersPerAccount *ACMERateLimit `json:"ordersPerAccount,omitempty"`
+	// CertificatesPerDomain limits the certificates issued for a registered
+	// domain, e.g. example.com for www.example.com. The default window is one
+	// week.
+	CertificatesPerDomain *ACMERateLimit `json:"certificatesPerDomain,omitempty"`
+	// FailedValidationsPerHostname limits the failed challenge validations
+	// of a hostname. The default window is one hour.
+	FailedValidationsPerHostname *ACMERateLimit `json:"failedValidationsPerHostname,omitempty"`
+	// AccountsPerIP limits the new accounts created from an IP address. The
+	// default window is three hours.
+	AccountsPerIP *ACMERateLimit `json:"accountsPerIP,omitempty"`
+	// TrustedProxies are the IP addresses or CIDR ranges of the reverse
+	// proxies in front of the CA. The client IP address of the requests
+	// coming from them is read from the X-Forwarded-For header. By default
+	// the client IP address is the address of the connection.
+	TrustedProxies []string `json:"trustedProxies,omitempty"`
+	trustedProxies []*net.IPNet
+}
+
+// ACMERateLimit is a limit of events in a sliding window of time.
+type ACMERateLimit struct {
+	Limit  int       `json:"limit"`
+	Window *Duration `json:"window,omitempty"`
+}
+
+// GetWindow returns the window of the rate limit or the given default.
+func (rl *ACMERateLimit) GetWindow(def time.Duration) time.Duration {
+	if rl.Window == nil || rl.Window.Duration == 0 {
+		return def
+	}
+	return rl.Window.Duration
+}
+
+// init validates the rate limits and parses the trusted proxies.
+func (rls *ACMERateLimits) init() error {
+	if rls == nil {
+		return nil
+	}
+	for _, l := range []struct {
+		name string
+		rl   *ACMERateLimit
+	}{
+		{"ordersPerAccount", rls.OrdersPerAccount},
+		{"certificatesPerDomain", rls.CertificatesPerDomain},
+		{"failedValidationsPerHostname", rls.FailedValidationsPerHostname},
+		{"accountsPerIP", rls.AccountsPerIP},
+	} {
+		switch {
+		case l.rl == nil:
+		case l.rl.Limit <= 0:
+			return errors.Errorf("rateLimits %s limit must be greater than 0, got %d", l.name, l.rl.Limit)
+		case l.rl.Window != nil && l.rl.Window.Duration < 0:
+			return errors.Errorf("rateLimits %s window cannot be negative, got %s", l.name, l.rl.Window)
+		}
+	}
+	rls.trustedProxies = make([]*net.IPNet, 0, len(rls.TrustedProxies))
+	for _, s := range rls.TrustedProxies {
+		ipNet, err := parseIPRange(s)
+		if err != nil {
+			return errors.Wrap(err, "rateLimits trustedProxies")
+		}
+		rls.trustedProxies = append(rls.trustedProxies, ipNet)
+	}
+	return nil
+}
+
+// ClientIP returns the IP address of the client of a request. If the
+// connection comes from a trusted proxy, the client IP address is the
+// rightmost address of the X-Forwarded-For header that is not a trusted
+// proxy. The addresses on its left can be set by the client and are ignored.
+func (rls *ACMERateLimits) ClientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if rls == nil || len(rls.trustedProxies) == 0 {
+		return ip
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0 && rls.isTrustedProxy(ip); i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+	}
+	return ip
+}
+
+func (rls *ACMERateLimits) isTrustedProxy(s string) bool {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range rls.trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ACMECAA configures the Certification Authority Authorization (CAA) checks
+// of an ACME provisioner.
+type ACMECAA struct {
+	// IssuerDomainNames are the identities of the CA in the issue and
+	// issuewild properties of the CAA records.
+	IssuerDomainNames []string `json:"issuerDomainNames"`
+	// Resolver is the address of the DNS resolver used for the CAA lookups.
+	// Defaults to the first nameserver in /etc/resolv.conf.
+	Resolver string `json:"resolver,omitempty"`
+}
+
+// ACMEChallengeValidation configures the retries of the background validation
+// of ACME challenges. Unset values use the ACME server defaults.
+type ACMEChallengeValidation struct {
+	// Retries is the number of times a validation is retried after a
+	// transient error, like a connection error or a DNS failure.
+	Retries *int `json:"retries,omitempty"`
+	// Backoff is the time to wait before the first retry, it doubles on each
+	// retry.
+	Backoff *Duration `json:"backoff,omitempty"`
+	// MaxBackoff is the maximum time to wait between retries.
+	MaxBackoff *Duration `json:"maxBackoff,omitempty"`
+	// DNSPropagationTimeout is the minimum time a dns-01 challenge is retried
+	// while the TXT record is not found or does not match, regardless of the
+	// number of retries.
+	DNSPropagationTimeout *Duration `json:"dnsPropagationTimeout,omitempty"`
+	// EmailReplyTimeout is the minimum time an email-reply-00 challenge is
+	// retried while the reply to the challenge email is not received,
+	// regardless of the number of retries.
+	EmailReplyTimeout *Duration `json:"emailReplyTimeout,omitempty"`
+}
+
+// Validate validates the challenge validation options.
+func (cv *ACMEChallengeValidation) Validate() error {
+	switch {
+	case cv == nil:
+		return nil
+	case cv.Retries != nil && *cv.Retries < 0:
+		return errors.Errorf("challengeValidation retries cannot be negative, got %d", *cv.Retries)
+	case cv.Backoff != nil && cv.Backoff.Duration < 0:
+		return errors.Errorf("challengeValidation backoff cannot be negative, got %s", cv.Backoff)
+	case cv.MaxBackoff != nil && cv.MaxBackoff.Duration < 0:
+		return errors.Errorf("challengeValidation maxBackoff cannot be negative, got %s", cv.MaxBackoff)
+	case cv.DNSPropagationTimeout != nil && cv.DNSPropagationTimeout.Duration < 0:
+		return errors.Errorf("challengeValidation dnsPropagationTimeout cannot be negative, got %s", cv.DNSPropagationTimeout)
+	case cv.EmailReplyTimeout != nil && cv.EmailReplyTimeout.Duration < 0:
+		return errors.Errorf("challengeValidation emailReplyTimeout cannot be negative, got %s", cv.EmailReplyTimeout)
+	default:
+		return nil
+	}
+}
+
+// ACMERenewalInfo configures the ACME Renewal Information (ARI) suggested
+// windows of an ACME provisioner.
+type ACMERenewalInfo struct {
+	// RenewIssuedBefore makes the suggested window of all the certificates
+	// issued before this time to start immediately. It can be used to pull
+	// forward renewals after a mass revocation or an intermediate rotation.
+	RenewIssuedBefore *time.Time `json:"renewIssuedBefore,omitempty"`
+	// ExplanationURL is an optional URL returned to clients with details
+	// about the suggested windows.
+	ExplanationURL string `json:"explanationURL,omitempty"`
+}
+
+// ShouldRenewNow returns true if the renewal information configuration
+// requires an immediate renewal of the given certificate.
+func (ri *ACMERenewalInfo) ShouldRenewNow(cert *x509.Certificate) bool {
+	if ri == nil || ri.RenewIssuedBefore == nil {
+		return false
+	}
+	return cert.NotBefore.Before(*ri.RenewIssuedBefore)
 }
 
 // GetID returns the provisioner unique identifier.
@@ -76,29 +384,183 @@ func (p *ACME) Init(config Config) (err error) {
 		return errors.New("provisioner name cannot be empty")
 	}
 
+	if err := p.ChallengeValidation.Validate(); err != nil {
+		return err
+	}
+	if p.CAA != nil && len(p.CAA.IssuerDomainNames) == 0 {
+		return errors.New("caa issuerDomainNames cannot be empty")
+	}
+	if err := p.Policy.init(); err != nil {
+		return err
+	}
+	if err := p.RateLimits.init(); err != nil {
+		return err
+	}
+	if p.AuthorizationLifetime != nil && p.AuthorizationLifetime.Duration < 0 {
+		return errors.Errorf("authorizationLifetime cannot be negative, got %s", p.AuthorizationLifetime)
+	}
+	if err := p.initAttestationRoots(); err != nil {
+		return err
+	}
+
 	// Update claims with global ones
 	if p.claimer, err = NewClaimer(p.Claims, config.Claims); err != nil {
 		return err
 	}
+	if p.namePolicy, err = newNamePolicyEngine(p.Options); err != nil {
+		return err
+	}
+	if p.webhooks, err = newWebhookController(p.Name, p.Options, config.WebhookClient); err != nil {
+		return err
+	}
+
+	// Profile claims overwrite the provisioner ones.
+	names := make(map[string]bool, len(p.Profiles))
+	for _, profile := range p.Profiles {
+		switch {
+		case profile == nil || profile.Name == "":
+			return errors.New("profile name cannot be empty")
+		case names[profile.Name]:
+			return errors.Errorf("profile %s is duplicated", profile.Name)
+		}
+		names[profile.Name] = true
+		if profile.claimer, err = NewClaimer(mergeClaims(profile.Claims, p.Claims), config.Claims); err != nil {
+			return errors.Wrapf(err, "error initializing profile %s", profile.Name)
+		}
+		profile.options = profile.Options
+		profile.webhooks = p.webhooks
+		if profile.options == nil {
+			profile.options = p.Options
+		} else if profile.webhooks, err = newWebhookController(p.Name, profile.options, config.WebhookClient); err != nil {
+			return errors.Wrapf(err, "error initializing profile %s", profile.Name)
+		}
+	}
 
 	return err
 }
 
+// GetProfile returns the profile with the given name. An empty name returns
+// the default profile, defined by the provisioner claims and options.
+func (p *ACME) GetProfile(name string) (*ACMEProfile, error) {
+	if name == "" {
+		return &ACMEProfile{claimer: p.claimer, options: p.Options, webhooks: p.webhooks}, nil
+	}
+	for _, profile := range p.Profiles {
+		if profile.Name == name {
+			return profile, nil
+		}
+	}
+	return nil, errors.Errorf("profile %s not found", name)
+}
+
+// WebhookOption returns the sign option that calls the webhooks of the
+// provisioner with the certificate request. The responses are stored in the
+// given template data, so it must be the data of the template options.
+func (p *ACME) WebhookOption(data x509util.TemplateData) SignOption {
+	return p.webhooks.x509Option(data)
+}
+
+// GetProfileDescriptions returns the descriptions of the profiles by name.
+func (p *ACME) GetProfileDescriptions() map[string]string {
+	if len(p.Profiles) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(p.Profiles))
+	for _, profile := range p.Profiles {
+		m[profile.Name] = profile.Description
+	}
+	return m
+}
+
+// GetAuthorizationLifetime returns the time a valid authorization can be
+// reused by new orders, 0 if authorizations are not reused.
+func (p *ACME) GetAuthorizationLifetime() time.Duration {
+	if p.AuthorizationLifetime == nil {
+		return 0
+	}
+	return p.AuthorizationLifetime.Duration
+}
+
+// initAttestationRoots validates the attestation formats and parses the
+// attestation roots.
+func (p *ACME) initAttestationRoots() error {
+	p.attestationRoots = nil
+	if len(p.AttestationFormats) == 0 {
+		return nil
+	}
+	for _, f := range p.AttestationFormats {
+		if err := f.Validate(); err != nil {
+			return err
+		}
+	}
+	if len(p.AttestationRoots) == 0 {
+		return errors.New("attestationRoots cannot be empty if attestationFormats are set")
+	}
+
+	pool := x509.NewCertPool()
+	var found bool
+	rest := p.AttestationRoots
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return errors.Wrap(err, "error parsing x509 certificate from attestationRoots")
+		}
+		pool.AddCert(cert)
+		found = true
+	}
+	if !found {
+		return errors.Errorf("no x509 certificates found in attestationRoots for provisioner '%s'", p.GetName())
+	}
+	p.attestationRoots = pool
+	return nil
+}
+
+// GetAttestationRoots returns the pool with the attestation roots, it is nil
+// if device-attest-01 challenges are not enabled.
+func (p *ACME) GetAttestationRoots() *x509.CertPool {
+	return p.attestationRoots
+}
+
+// AuthorizeOrderIdentifier verifies that the provisioner policy allows the
+// issuance of certificates for an ACME order identifier. It is called when
+// an order is created and when it is finalized.
+func (p *ACME) AuthorizeOrderIdentifier(ctx context.Context, identifier ACMEIdentifier) error {
+	return p.Policy.authorize(identifier)
+}
+
 // AuthorizeSign does not do any validation, because all validation is handled
 // in the ACME protocol. This method returns a list of modifiers / constraints
 // on the resulting certificate.
 func (p *ACME) AuthorizeSign(ctx context.Context, token string) ([]SignOption, error) {
+	profile, err := p.GetProfile(ACMEProfileFromContext(ctx))
+	if err != nil {
+		return nil, errs.Wrap(http.StatusBadRequest, err, "acme.AuthorizeSign")
+	}
 	return []SignOption{
 		// modifiers / withOptions
 		newProvisionerExtensionOption(TypeACME, p.Name, ""),
 		newForceCNOption(p.ForceCN),
-		profileDefaultDuration(p.claimer.DefaultTLSCertDuration()),
+		profileDefaultDuration(profile.DefaultTLSCertDuration()),
 		// validators
 		defaultPublicKeyValidator{},
-		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
+		newValidityValidator(profile.MinTLSCertDuration(), profile.MaxTLSCertDuration()),
+		newX509NamePolicyValidator(p.namePolicy),
 	}, nil
 }
 
+// AuthorizeRevoke is called just before a certificate is revoked using the ACME
+// revokeCert endpoint. The ACME API is responsible for verifying that the
+// request is signed by the account that ordered the certificate or by the
+// certificate key, so this method always authorizes the revocation.
+func (p *ACME) AuthorizeRevoke(ctx context.Context, token string) error {
+	return nil
+}
+
 // AuthorizeRenew returns an error if the renewal is disabled.
 // NOTE: This method does not actually validate the certificate or check it's
 // revocation status. Just confirms that the provisioner that created the
@@ -0,0 +1,230 @@
+package provisioner
+
+import (
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ACMEIdentifierType is the type of an ACME identifier.
+type ACMEIdentifierType string
+
+const (
+	// ACMEIPIdentifier is the ACME ip identifier type.
+	ACMEIPIdentifier ACMEIdentifierType = "ip"
+	// ACMEDNSIdentifier is the ACME dns identifier type.
+	ACMEDNSIdentifier ACMEIdentifierType = "dns"
+	// ACMEEmailIdentifier is the ACME email identifier type, see RFC 8823.
+	ACMEEmailIdentifier ACMEIdentifierType = "email"
+	// ACMEPermanentIdentifier is the ACME permanent-identifier identifier type
+	// of the device-attest-01 challenges.
+	ACMEPermanentIdentifier ACMEIdentifierType = "permanent-identifier"
+)
+
+// ACMEIdentifier is an identifier of an ACME order.
+type ACMEIdentifier struct {
+	Type  ACMEIdentifierType
+	Value string
+}
+
+// ACMEPolicy restricts the identifiers an ACME provisioner can issue
+// certificates for. An identifier is rejected if it matches a deny rule, and,
+// if allow rules are configured, it must match one of them. A wildcard DNS
+// identifier is also rejected if a denied DNS name is one of its subdomains,
+// or if there are denied DNS regexes.
+//
+// This policy does not replace the name-constraint policy in the provisioner
+// options, neither takes precedence over the other: the identifiers of an
+// order must be allowed by this policy, and the signed certificate must be
+// allowed by the name-constraint policies of the provisioner and the
+// authority.
+type ACMEPolicy struct {
+	Allow *ACMEIdentifierRules `json:"allow,omitempty"`
+	Deny  *ACMEIdentifierRules `json:"deny,omitempty"`
+}
+
+// ACMEIdentifierRules is a set of rules matching ACME identifiers.
+type ACMEIdentifierRules struct {
+	// DNSNames are exact DNS names, or suffixes if they start with "*.", e.g.
+	// "*.example.com" matches all the subdomains of example.com.
+	DNSNames []string `json:"dnsNames,omitempty"`
+	// DNSRegexes are regular expressions that must match the full DNS name.
+	// Wildcard identifiers are matched as they are, e.g. "*.example.com", but
+	// if there are deny regexes all the wildcard identifiers are rejected.
+	DNSRegexes []string `json:"dnsRegexes,omitempty"`
+	// IPRanges are IP addresses or CIDR ranges.
+	IPRanges []string `json:"ipRanges,omitempty"`
+	// Emails are exact email addresses, or domains if they start with "@",
+	// e.g. "@example.com" matches all the addresses of example.com.
+	Emails []string `json:"emails,omitempty"`
+	// PermanentIdentifiers are exact device identifiers, e.g. serial numbers.
+	PermanentIdentifiers []string `json:"permanentIdentifiers,omitempty"`
+	regexes              []*regexp.Regexp
+	ipNets               []*net.IPNet
+}
+
+func (pol *ACMEPolicy) init() error {
+	if pol == nil {
+		return nil
+	}
+	if err := pol.Allow.init(); err != nil {
+		return errors.Wrap(err, "error initializing policy allow rules")
+	}
+	if err := pol.Deny.init(); err != nil {
+		return errors.Wrap(err, "error initializing policy deny rules")
+	}
+	return nil
+}
+
+// authorize returns an error if the identifier is not allowed by the policy.
+func (pol *ACMEPolicy) authorize(identifier ACMEIdentifier) error {
+	if pol == nil {
+		return nil
+	}
+	if pol.Deny.matches(identifier) || pol.Deny.coversWildcard(identifier) {
+		return errors.Errorf("identifier %s is denied by the provisioner policy", identifier.Value)
+	}
+	if !pol.Allow.isEmpty() && !pol.Allow.matches(identifier) {
+		return errors.Errorf("identifier %s is not allowed by the provisioner policy", identifier.Value)
+	}
+	return nil
+}
+
+func (r *ACMEIdentifierRules) init() error {
+	if r == nil {
+		return nil
+	}
+	for _, name := range r.DNSNames {
+		if name == "" || name == "*." {
+			return errors.Errorf("invalid dns name '%s'", name)
+		}
+	}
+	for _, email := range r.Emails {
+		if i := strings.LastIndex(email, "@"); i < 0 || i == len(email)-1 {
+			return errors.Errorf("invalid email '%s'", email)
+		}
+	}
+	r.regexes = make([]*regexp.Regexp, 0, len(r.DNSRegexes))
+	for _, s := range r.DNSRegexes {
+		re, err := regexp.Compile("^(?:" + s + ")$")
+		if err != nil {
+			return errors.Wrapf(err, "error compiling dns regex '%s'", s)
+		}
+		r.regexes = append(r.regexes, re)
+	}
+	r.ipNets = make([]*net.IPNet, 0, len(r.IPRanges))
+	for _, s := range r.IPRanges {
+		ipNet, err := parseIPRange(s)
+		if err != nil {
+			return err
+		}
+		r.ipNets = append(r.ipNets, ipNet)
+	}
+	return nil
+}
+
+// parseIPRange parses an IP address or a CIDR range.
+func parseIPRange(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error parsing ip range '%s'", s)
+		}
+		return ipNet, nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, errors.Errorf("error parsing ip range '%s'", s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	bits := len(ip) * 8
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+func (r *ACMEIdentifierRules) isEmpty() bool {
+	return r == nil || (len(r.DNSNames) == 0 && len(r.DNSRegexes) == 0 && len(r.IPRanges) == 0 &&
+		len(r.Emails) == 0 && len(r.PermanentIdentifiers) == 0)
+}
+
+// matches returns true if the identifier matches any of the rules.
+func (r *ACMEIdentifierRules) matches(identifier ACMEIdentifier) bool {
+	if r == nil {
+		return false
+	}
+	switch identifier.Type {
+	case ACMEDNSIdentifier:
+		name := strings.ToLower(strings.TrimSuffix(identifier.Value, "."))
+		for _, n := range r.DNSNames {
+			n = strings.ToLower(n)
+			if strings.HasPrefix(n, "*.") {
+				if strings.HasSuffix(name, n[1:]) {
+					return true
+				}
+			} else if name == n {
+				return true
+			}
+		}
+		for _, re := range r.regexes {
+			if re.MatchString(name) {
+				return true
+			}
+		}
+	case ACMEIPIdentifier:
+		ip := net.ParseIP(identifier.Value)
+		if ip == nil {
+			return false
+		}
+		for _, ipNet := range r.ipNets {
+			if ipNet.Contains(ip) {
+				return true
+			}
+		}
+	case ACMEEmailIdentifier:
+		email := strings.ToLower(identifier.Value)
+		for _, e := range r.Emails {
+			e = strings.ToLower(e)
+			if strings.HasPrefix(e, "@") {
+				if strings.HasSuffix(email, e) {
+					return true
+				}
+			} else if email == e {
+				return true
+			}
+		}
+	case ACMEPermanentIdentifier:
+		for _, id := range r.PermanentIdentifiers {
+			if id == identifier.Value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// coversWildcard returns true if the identifier is a wildcard DNS name, and
+// one of the DNS names of the rules is a subdomain of it, or there are DNS
+// regexes. Without this check a wildcard identifier, e.g. "*.example.com",
+// would bypass the denied names of its domain, e.g. "secret.example.com".
+// The names matched by a regex cannot be compared with a domain, so any
+// wildcard could cover one of them.
+func (r *ACMEIdentifierRules) coversWildcard(identifier ACMEIdentifier) bool {
+	if r == nil || identifier.Type != ACMEDNSIdentifier {
+		return false
+	}
+	name := strings.ToLower(strings.TrimSuffix(identifier.Value, "."))
+	if !strings.HasPrefix(name, "*.") {
+		return false
+	}
+	domain := name[1:]
+	for _, n := range r.DNSNames {
+		n = strings.TrimPrefix(strings.ToLower(n), "*")
+		if strings.HasSuffix(n, domain) {
+			return true
+		}
+	}
+	return len(r.regexes) > 0
+}
@@ -0,0 +1,151 @@
+package provisioner
+
+import (
+	"context"
+	"testing"
+
+	"github.com/smallstep/assert"
+)
+
+func TestACMEIdentifierRules_init(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   *ACMEIdentifierRules
+		wantErr bool
+	}{
+		{"ok/nil", nil, false},
+		{"ok", &ACMEIdentifierRules{
+			DNSNames:   []string{"foo.internal", "*.bar.internal"},
+			DNSRegexes: []string{`[a-z]+\.zap\.internal`},
+			IPRanges:   []string{"10.0.0.0/8", "192.168.42.42", "2001:db8::/32", "::1"},
+			Emails:     []string{"jane@example.com", "@smallstep.com"},
+		}, false},
+		{"fail/empty-dns-name", &ACMEIdentifierRules{DNSNames: []string{""}}, true},
+		{"fail/wildcard-dns-name", &ACMEIdentifierRules{DNSNames: []string{"*."}}, true},
+		{"fail/regex", &ACMEIdentifierRules{DNSRegexes: []string{"[a-z"}}, true},
+		{"fail/cidr", &ACMEIdentifierRules{IPRanges: []string{"10.0.0.0/33"}}, true},
+		{"fail/ip", &ACMEIdentifierRules{IPRanges: []string{"10.0.0"}}, true},
+		{"fail/email", &ACMEIdentifierRules{Emails: []string{"example.com"}}, true},
+		{"fail/email-domain", &ACMEIdentifierRules{Emails: []string{"jane@"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.rules.init(); (err != nil) != tt.wantErr {
+				t.Errorf("ACMEIdentifierRules.init() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestACME_AuthorizeOrderIdentifier(t *testing.T) {
+	p := &ACME{
+		Type: "ACME",
+		Name: "acme",
+		Policy: &ACMEPolicy{
+			Allow: &ACMEIdentifierRules{
+				DNSNames:             []string{"foo.internal", "*.bar.internal"},
+				DNSRegexes:           []string{`[a-z]+\.zap\.internal`},
+				IPRanges:             []string{"10.0.0.0/8", "192.168.42.42"},
+				Emails:               []string{"jane@example.com", "@smallstep.com"},
+				PermanentIdentifiers: []string{"C02XK1ABJG5H", "123456"},
+			},
+			Deny: &ACMEIdentifierRules{
+				DNSNames:             []string{"secret.bar.internal"},
+				IPRanges:             []string{"10.10.0.0/16"},
+				Emails:               []string{"root@smallstep.com"},
+				PermanentIdentifiers: []string{"123456"},
+			},
+		},
+	}
+	assert.FatalError(t, p.Init(Config{Claims: globalProvisionerClaims, Audiences: testAudiences}))
+
+	tests := []struct {
+		name       string
+		p          *ACME
+		identifier ACMEIdentifier
+		wantErr    bool
+	}{
+		{"ok/no-policy", &ACME{}, ACMEIdentifier{Type: ACMEDNSIdentifier, Value: "foo.internal"}, false},
+		{"ok/dns-exact", p, ACMEIdentifier{Type: ACMEDNSIdentifier, Value: "FOO.internal"}, false},
+		{"ok/dns-suffix", p, ACMEIdentifier{Type: ACMEDNSIdentifier, Value: "a.b.bar.internal"}, false},
+		{"ok/dns-wildcard", p, ACMEIdentifier{Type: ACMEDNSIdentifier, Value: "*.foo.bar.internal"}, false},
+		{"fail/dns-wildcard-denied", p, ACMEIdentifier{Type: ACMEDNSIdentifier, Value: "*.bar.internal"}, true},
+		{"ok/dns-regex", p, ACMEIdentifier{Type: ACMEDNSIdentifier, Value: "abc.zap.internal"}, false},
+		{"ok/ip-cidr", p, ACMEIdentifier{Type: ACMEIPIdentifier, Value: "10.1.2.3"}, false},
+		{"ok/ip", p, ACMEIdentifier{Type: ACMEIPIdentifier, Value: "192.168.42.42"}, false},
+		{"fail/dns-not-allowed", p, ACMEIdentifier{Type: ACMEDNSIdentifier, Value: "bar.internal"}, true},
+		{"fail/dns-regex-not-full-match", p, ACMEIdentifier{Type: ACMEDNSIdentifier, Value: "a.b.zap.internal"}, true},
+		{"fail/dns-denied", p, ACMEIdentifier{Type: ACMEDNSIdentifier, Value: "secret.bar.internal"}, true},
+		{"fail/ip-not-allowed", p, ACMEIdentifier{Type: ACMEIPIdentifier, Value: "192.168.42.43"}, true},
+		{"fail/ip-denied", p, ACMEIdentifier{Type: ACMEIPIdentifier, Value: "10.10.1.1"}, true},
+		{"fail/ip-invalid", p, ACMEIdentifier{Type: ACMEIPIdentifier, Value: "foo"}, true},
+		{"ok/email", p, ACMEIdentifier{Type: ACMEEmailIdentifier, Value: "Jane@Example.com"}, false},
+		{"ok/email-domain", p, ACMEIdentifier{Type: ACMEEmailIdentifier, Value: "joe@smallstep.com"}, false},
+		{"fail/email-not-allowed", p, ACMEIdentifier{Type: ACMEEmailIdentifier, Value: "joe@example.com"}, true},
+		{"fail/email-subdomain", p, ACMEIdentifier{Type: ACMEEmailIdentifier, Value: "joe@sub.smallstep.com"}, true},
+		{"fail/email-denied", p, ACMEIdentifier{Type: ACMEEmailIdentifier, Value: "root@smallstep.com"}, true},
+		{"ok/permanent-identifier", p, ACMEIdentifier{Type: ACMEPermanentIdentifier, Value: "C02XK1ABJG5H"}, false},
+		{"fail/permanent-identifier-not-allowed", p, ACMEIdentifier{Type: ACMEPermanentIdentifier, Value: "c02xk1abjg5h"}, true},
+		{"fail/permanent-identifier-denied", p, ACMEIdentifier{Type: ACMEPermanentIdentifier, Value: "123456"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.p.AuthorizeOrderIdentifier(context.Background(), tt.identifier); (err != nil) != tt.wantErr {
+				t.Errorf("ACME.AuthorizeOrderIdentifier() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestACME_AuthorizeOrderIdentifier_wildcard(t *testing.T) {
+	p := &ACME{
+		Type: "ACME",
+		Name: "acme",
+		Policy: &ACMEPolicy{
+			Allow: &ACMEIdentifierRules{
+				DNSNames: []string{"*.corp.example.com"},
+			},
+			Deny: &ACMEIdentifierRules{
+				DNSNames: []string{"secret.corp.example.com", "*.internal.corp.example.com"},
+			},
+		},
+	}
+	assert.FatalError(t, p.Init(Config{Claims: globalProvisionerClaims, Audiences: testAudiences}))
+	pr := &ACME{
+		Type: "ACME",
+		Name: "acme-regex",
+		Policy: &ACMEPolicy{
+			Deny: &ACMEIdentifierRules{
+				DNSRegexes: []string{`secret-[0-9]+\.corp\.example\.com`},
+			},
+		},
+	}
+	assert.FatalError(t, pr.Init(Config{Claims: globalProvisionerClaims, Audiences: testAudiences}))
+
+	tests := []struct {
+		name    string
+		p       *ACME
+		value   string
+		wantErr bool
+	}{
+		{"ok", p, "www.corp.example.com", false},
+		{"ok/wildcard", p, "*.www.corp.example.com", false},
+		{"fail/denied", p, "secret.corp.example.com", true},
+		{"fail/wildcard", p, "*.corp.example.com", true},
+		{"fail/wildcard-fqdn", p, "*.Corp.Example.com.", true},
+		{"fail/wildcard-denied-domain", p, "*.internal.corp.example.com", true},
+		{"fail/wildcard-denied-subdomain", p, "*.db.internal.corp.example.com", true},
+		{"ok/regex", pr, "secret.corp.example.com", false},
+		{"fail/regex-denied", pr, "secret-1.corp.example.com", true},
+		{"fail/regex-wildcard", pr, "*.corp.example.com", true},
+		{"fail/regex-any-wildcard", pr, "*.example.org", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.p.AuthorizeOrderIdentifier(context.Background(), ACMEIdentifier{Type: ACMEDNSIdentifier, Value: tt.value})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ACME.AuthorizeOrderIdentifier() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -3,13 +3,16 @@ package provisioner
 import (
 	"context"
 	"crypto/x509"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
 	"github.com/smallstep/certificates/errs"
+	"go.step.sm/crypto/x509util"
 )
 
 func TestACME_Getters(t *testing.T) {
@@ -64,11 +67,134 @@ func TestACME_Init(t *testing.T) {
 				err: errors.New("claims: MinTLSCertDuration must be greater than 0"),
 			}
 		},
+		"fail-bad-challenge-validation-retries": func(t *testing.T) ProvisionerValidateTest {
+			retries := -1
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", ChallengeValidation: &ACMEChallengeValidation{Retries: &retries}},
+				err: errors.New("challengeValidation retries cannot be negative, got -1"),
+			}
+		},
+		"fail-bad-challenge-validation-backoff": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", ChallengeValidation: &ACMEChallengeValidation{Backoff: &Duration{-time.Second}}},
+				err: errors.New("challengeValidation backoff cannot be negative, got -1s"),
+			}
+		},
+		"fail-bad-challenge-validation-email-reply-timeout": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", ChallengeValidation: &ACMEChallengeValidation{EmailReplyTimeout: &Duration{-time.Minute}}},
+				err: errors.New("challengeValidation emailReplyTimeout cannot be negative, got -1m0s"),
+			}
+		},
+		"fail-bad-policy": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", Policy: &ACMEPolicy{Deny: &ACMEIdentifierRules{IPRanges: []string{"foo"}}}},
+				err: errors.New("error initializing policy deny rules: error parsing ip range 'foo'"),
+			}
+		},
+		"fail-bad-rate-limit": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", RateLimits: &ACMERateLimits{CertificatesPerDomain: &ACMERateLimit{Limit: 0}}},
+				err: errors.New("rateLimits certificatesPerDomain limit must be greater than 0, got 0"),
+			}
+		},
+		"fail-negative-rate-limit-window": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", RateLimits: &ACMERateLimits{AccountsPerIP: &ACMERateLimit{Limit: 1, Window: &Duration{Duration: -time.Minute}}}},
+				err: errors.New("rateLimits accountsPerIP window cannot be negative, got -1m0s"),
+			}
+		},
+		"fail-bad-trusted-proxy": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", RateLimits: &ACMERateLimits{TrustedProxies: []string{"10.0.0.0/33"}}},
+				err: errors.New("rateLimits trustedProxies: error parsing ip range '10.0.0.0/33': invalid CIDR address: 10.0.0.0/33"),
+			}
+		},
+		"fail-negative-authorization-lifetime": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", AuthorizationLifetime: &Duration{Duration: -time.Hour}},
+				err: errors.New("authorizationLifetime cannot be negative, got -1h0m0s"),
+			}
+		},
+		"fail-empty-profile-name": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", Profiles: []*ACMEProfile{{Description: "foo"}}},
+				err: errors.New("profile name cannot be empty"),
+			}
+		},
+		"fail-duplicated-profile": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", Profiles: []*ACMEProfile{{Name: "server"}, {Name: "server"}}},
+				err: errors.New("profile server is duplicated"),
+			}
+		},
+		"fail-bad-profile-claims": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p: &ACME{Name: "foo", Type: "bar", Profiles: []*ACMEProfile{
+					{Name: "client", Claims: &Claims{DefaultTLSDur: &Duration{Duration: 72 * time.Hour}, MaxTLSDur: &Duration{Duration: 24 * time.Hour}}},
+				}},
+				err: errors.New("error initializing profile client: claims: MaxCertDuration cannot be less than DefaultCertDuration: MaxCertDuration - 24h0m0s, DefaultCertDuration - 72h0m0s"),
+			}
+		},
+		"fail-empty-caa-issuers": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", CAA: &ACMECAA{}},
+				err: errors.New("caa issuerDomainNames cannot be empty"),
+			}
+		},
+		"fail-bad-attestation-format": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", AttestationFormats: []ACMEAttestationFormat{"android-key"}},
+				err: errors.New("attestation format android-key is not supported"),
+			}
+		},
+		"fail-empty-attestation-roots": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", AttestationFormats: []ACMEAttestationFormat{ACMEAttestationApple}},
+				err: errors.New("attestationRoots cannot be empty if attestationFormats are set"),
+			}
+		},
+		"fail-bad-attestation-roots": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", AttestationFormats: []ACMEAttestationFormat{ACMEAttestationApple}, AttestationRoots: []byte("foo")},
+				err: errors.New("no x509 certificates found in attestationRoots for provisioner 'foo'"),
+			}
+		},
 		"ok": func(t *testing.T) ProvisionerValidateTest {
 			return ProvisionerValidateTest{
 				p: &ACME{Name: "foo", Type: "bar"},
 			}
 		},
+		"ok/attestation": func(t *testing.T) ProvisionerValidateTest {
+			roots, err := ioutil.ReadFile("./testdata/certs/root_ca.crt")
+			assert.FatalError(t, err)
+			return ProvisionerValidateTest{
+				p: &ACME{Name: "foo", Type: "bar", AttestationFormats: []ACMEAttestationFormat{ACMEAttestationStep, ACMEAttestationTPM}, AttestationRoots: roots},
+			}
+		},
+		"ok/caa": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p: &ACME{Name: "foo", Type: "bar", CAA: &ACMECAA{IssuerDomainNames: []string{"ca.example.com"}}},
+			}
+		},
+		"ok/profiles": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p: &ACME{Name: "foo", Type: "bar", Profiles: []*ACMEProfile{
+					{Name: "server", Description: "Short-lived server certificates", Claims: &Claims{DefaultTLSDur: &Duration{Duration: time.Hour}}},
+					{Name: "client", Claims: &Claims{DefaultTLSDur: &Duration{Duration: 72 * time.Hour}, MaxTLSDur: &Duration{Duration: 168 * time.Hour}}},
+				}},
+			}
+		},
+		"ok/challenge-validation": func(t *testing.T) ProvisionerValidateTest {
+			retries := 5
+			return ProvisionerValidateTest{
+				p: &ACME{Name: "foo", Type: "bar", ChallengeValidation: &ACMEChallengeValidation{
+					Retries:               &retries,
+					Backoff:               &Duration{10 * time.Second},
+					DNSPropagationTimeout: &Duration{2 * time.Minute},
+				}},
+			}
+		},
 	}
 
 	config := Config{
@@ -90,6 +216,12 @@ func TestACME_Init(t *testing.T) {
 	}
 }
 
+func TestACME_AuthorizeRevoke(t *testing.T) {
+	p, err := generateACME()
+	assert.FatalError(t, err)
+	assert.Nil(t, p.AuthorizeRevoke(context.Background(), ""))
+}
+
 func TestACME_AuthorizeRenew(t *testing.T) {
 	type test struct {
 		p    *ACME
@@ -141,25 +273,62 @@ func TestACME_AuthorizeRenew(t *testing.T) {
 
 func TestACME_AuthorizeSign(t *testing.T) {
 	type test struct {
-		p     *ACME
-		token string
-		code  int
-		err   error
+		p             *ACME
+		ctx           context.Context
+		token         string
+		def, min, max time.Duration
+		code          int
+		err           error
 	}
 	tests := map[string]func(*testing.T) test{
+		"fail/unknown-profile": func(t *testing.T) test {
+			p, err := generateACME()
+			assert.FatalError(t, err)
+			return test{
+				p:     p,
+				ctx:   NewContextWithACMEProfile(context.Background(), "foo"),
+				token: "foo",
+				code:  http.StatusBadRequest,
+				err:   errors.New("acme.AuthorizeSign: profile foo not found"),
+			}
+		},
 		"ok": func(t *testing.T) test {
 			p, err := generateACME()
 			assert.FatalError(t, err)
 			return test{
 				p:     p,
+				ctx:   context.Background(),
+				token: "foo",
+				def:   p.claimer.DefaultTLSCertDuration(),
+				min:   p.claimer.MinTLSCertDuration(),
+				max:   p.claimer.MaxTLSCertDuration(),
+			}
+		},
+		"ok/profile": func(t *testing.T) test {
+			p, err := generateACME()
+			assert.FatalError(t, err)
+			p.Profiles = []*ACMEProfile{{
+				Name: "client",
+				Claims: &Claims{
+					DefaultTLSDur: &Duration{Duration: 72 * time.Hour},
+					MaxTLSDur:     &Duration{Duration: 168 * time.Hour},
+				},
+			}}
+			assert.FatalError(t, p.Init(Config{Claims: globalProvisionerClaims}))
+			return test{
+				p:     p,
+				ctx:   NewContextWithACMEProfile(context.Background(), "client"),
 				token: "foo",
+				def:   72 * time.Hour,
+				min:   p.claimer.MinTLSCertDuration(),
+				max:   168 * time.Hour,
 			}
 		},
 	}
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
 			tc := tt(t)
-			if opts, err := tc.p.AuthorizeSign(context.Background(), tc.token); err != nil {
+			if opts, err := tc.p.AuthorizeSign(tc.ctx, tc.token); err != nil {
 				if assert.NotNil(t, tc.err) {
 					sc, ok := err.(errs.StatusCoder)
 					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
@@ -168,7 +337,7 @@ func TestACME_AuthorizeSign(t *testing.T) {
 				}
 			} else {
 				if assert.Nil(t, tc.err) && assert.NotNil(t, opts) {
-					assert.Len(t, 5, opts)
+					assert.Len(t, 6, opts)
 					for _, o := range opts {
 						switch v := o.(type) {
 						case *provisionerExtensionOption:
@@ -179,11 +348,13 @@ func TestACME_AuthorizeSign(t *testing.T) {
 						case *forceCNOption:
 							assert.Equals(t, v.ForceCN, tc.p.ForceCN)
 						case profileDefaultDuration:
-							assert.Equals(t, time.Duration(v), tc.p.claimer.DefaultTLSCertDuration())
+							assert.Equals(t, time.Duration(v), tc.def)
 						case defaultPublicKeyValidator:
 						case *validityValidator:
-							assert.Equals(t, v.min, tc.p.claimer.MinTLSCertDuration())
-							assert.Equals(t, v.max, tc.p.claimer.MaxTLSCertDuration())
+							assert.Equals(t, v.min, tc.min)
+							assert.Equals(t, v.max, tc.max)
+						case *x509NamePolicyValidator:
+							assert.Equals(t, v.policy, tc.p.namePolicy)
 						default:
 							assert.FatalError(t, errors.Errorf("unexpected sign option of type %T", v))
 						}
@@ -193,3 +364,162 @@ func TestACME_AuthorizeSign(t *testing.T) {
 		})
 	}
 }
+
+func TestACME_GetProfile(t *testing.T) {
+	provOptions := &Options{X509: &X509Options{Template: "provisioner"}}
+	profileOptions := &Options{X509: &X509Options{Template: "profile"}}
+	p := &ACME{
+		Name: "foo", Type: "ACME", Options: provOptions,
+		Profiles: []*ACMEProfile{
+			{Name: "server", Description: "Server certificates"},
+			{Name: "client", Options: profileOptions, Claims: &Claims{
+				DefaultTLSDur: &Duration{Duration: 72 * time.Hour},
+				MaxTLSDur:     &Duration{Duration: 168 * time.Hour},
+			}},
+		},
+	}
+	assert.FatalError(t, p.Init(Config{Claims: globalProvisionerClaims}))
+
+	profile, err := p.GetProfile("")
+	assert.FatalError(t, err)
+	assert.Equals(t, profile.DefaultTLSCertDuration(), 24*time.Hour)
+	assert.Equals(t, profile.GetOptions(), provOptions)
+
+	profile, err = p.GetProfile("server")
+	assert.FatalError(t, err)
+	assert.Equals(t, profile.DefaultTLSCertDuration(), 24*time.Hour)
+	assert.Equals(t, profile.MaxTLSCertDuration(), 24*time.Hour)
+	assert.Equals(t, profile.GetOptions(), provOptions)
+
+	profile, err = p.GetProfile("client")
+	assert.FatalError(t, err)
+	assert.Equals(t, profile.DefaultTLSCertDuration(), 72*time.Hour)
+	assert.Equals(t, profile.MinTLSCertDuration(), 5*time.Minute)
+	assert.Equals(t, profile.MaxTLSCertDuration(), 168*time.Hour)
+	assert.Equals(t, profile.GetOptions(), profileOptions)
+
+	_, err = p.GetProfile("foo")
+	if assert.Error(t, err) {
+		assert.Equals(t, err.Error(), "profile foo not found")
+	}
+
+	assert.Equals(t, p.GetProfileDescriptions(), map[string]string{
+		"server": "Server certificates",
+		"client": "",
+	})
+	assert.Nil(t, (&ACME{}).GetProfileDescriptions())
+}
+
+func TestACME_WebhookOption(t *testing.T) {
+	provOptions := &Options{Webhooks: []*Webhook{{Name: "cmdb", URL: "https://cmdb.example.com"}}}
+	profileOptions := &Options{Webhooks: []*Webhook{{Name: "inventory", URL: "https://inventory.example.com"}}}
+	p := &ACME{
+		Name: "foo", Type: "ACME", Options: provOptions,
+		Profiles: []*ACMEProfile{
+			{Name: "server"},
+			{Name: "client", Options: profileOptions},
+			{Name: "none", Options: &Options{}},
+		},
+	}
+	assert.FatalError(t, p.Init(Config{Claims: globalProvisionerClaims}))
+
+	webhookNames := func(o SignOption) []string {
+		w, ok := o.(*x509Webhooks)
+		assert.Fatal(t, ok)
+		var names []string
+		if w.controller != nil {
+			for _, wh := range w.controller.webhooks {
+				names = append(names, wh.Name)
+			}
+		}
+		return names
+	}
+	data := x509util.NewTemplateData()
+	assert.Equals(t, webhookNames(p.WebhookOption(data)), []string{"cmdb"})
+	for name, want := range map[string][]string{"": {"cmdb"}, "server": {"cmdb"}, "client": {"inventory"}, "none": nil} {
+		profile, err := p.GetProfile(name)
+		assert.FatalError(t, err)
+		assert.Equals(t, webhookNames(profile.WebhookOption(data)), want)
+	}
+
+	p = &ACME{
+		Name: "foo", Type: "ACME",
+		Profiles: []*ACMEProfile{
+			{Name: "client", Options: &Options{Webhooks: []*Webhook{{Name: "cmdb", URL: "http://cmdb.example.com"}}}},
+		},
+	}
+	err := p.Init(Config{Claims: globalProvisionerClaims})
+	if assert.Error(t, err) {
+		assert.Equals(t, err.Error(), "error initializing profile client: webhook cmdb url must be an absolute https url")
+	}
+}
+
+func TestACME_GetAttestationRoots(t *testing.T) {
+	roots, err := ioutil.ReadFile("./testdata/certs/root_ca.crt")
+	assert.FatalError(t, err)
+	p := &ACME{Name: "foo", Type: "ACME", AttestationFormats: []ACMEAttestationFormat{ACMEAttestationApple}, AttestationRoots: roots}
+	assert.FatalError(t, p.Init(Config{Claims: globalProvisionerClaims}))
+	assert.NotNil(t, p.GetAttestationRoots())
+
+	p = &ACME{Name: "foo", Type: "ACME"}
+	assert.FatalError(t, p.Init(Config{Claims: globalProvisionerClaims}))
+	assert.Nil(t, p.GetAttestationRoots())
+}
+
+func TestACMERenewalInfo_ShouldRenewNow(t *testing.T) {
+	now := time.Now()
+	before := now.Add(-2 * time.Hour)
+	cert := &x509.Certificate{NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Hour)}
+	tests := []struct {
+		name string
+		ri   *ACMERenewalInfo
+		want bool
+	}{
+		{"nil", nil, false},
+		{"empty", &ACMERenewalInfo{}, false},
+		{"issued after", &ACMERenewalInfo{RenewIssuedBefore: &before}, false},
+		{"issued before", &ACMERenewalInfo{RenewIssuedBefore: &now}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.ri.ShouldRenewNow(cert); got != tt.want {
+				t.Errorf("ACMERenewalInfo.ShouldRenewNow() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestACMERateLimits_ClientIP(t *testing.T) {
+	rls := &ACMERateLimits{TrustedProxies: []string{"10.0.0.0/8", "::1"}}
+	assert.FatalError(t, rls.init())
+	tests := []struct {
+		name          string
+		rls           *ACMERateLimits
+		remoteAddr    string
+		xForwardedFor []string
+		want          string
+	}{
+		{"nil", nil, "10.0.0.1:443", []string{"1.1.1.1"}, "10.0.0.1"},
+		{"no port", nil, "10.0.0.1", nil, "10.0.0.1"},
+		{"untrusted", rls, "2.2.2.2:443", []string{"1.1.1.1"}, "2.2.2.2"},
+		{"trusted", rls, "10.0.0.1:443", []string{"1.1.1.1"}, "1.1.1.1"},
+		{"trusted ipv6", rls, "[::1]:443", []string{"1.1.1.1"}, "1.1.1.1"},
+		{"trusted no header", rls, "10.0.0.1:443", nil, "10.0.0.1"},
+		{"trusted chain", rls, "10.0.0.1:443", []string{"1.1.1.1, 2.2.2.2, 10.0.0.2"}, "2.2.2.2"},
+		{"trusted headers", rls, "10.0.0.1:443", []string{"1.1.1.1", "2.2.2.2"}, "2.2.2.2"},
+		{"trusted all", rls, "10.0.0.1:443", []string{"10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"trusted bad hop", rls, "10.0.0.1:443", []string{"1.1.1.1, foo"}, "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/acme/acme/new-account", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.xForwardedFor {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got := tt.rls.ClientIP(r); got != tt.want {
+				t.Errorf("ACMERateLimits.ClientIP() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/certificates/errs"
 	"go.step.sm/crypto/jose"
 	"go.step.sm/crypto/sshutil"
@@ -264,6 +265,8 @@ type AWS struct {
 	Claims                 *Claims  `json:"claims,omitempty"`
 	Options                *Options `json:"options,omitempty"`
 	claimer                *Claimer
+	namePolicy             *policy.Engine
+	webhooks               *webhookController
 	config                 *awsConfig
 	audiences              Audiences
 }
@@ -403,6 +406,12 @@ func (p *AWS) Init(config Config) (err error) {
 	if p.claimer, err = NewClaimer(p.Claims, config.Claims); err != nil {
 		return err
 	}
+	if p.namePolicy, err = newNamePolicyEngine(p.Options); err != nil {
+		return err
+	}
+	if p.webhooks, err = newWebhookController(p.Name, p.Options, config.WebhookClient); err != nil {
+		return err
+	}
 	// Add default config
 	if p.config, err = newAWSConfig(p.IIDRoots); err != nil {
 		return err
@@ -468,6 +477,7 @@ func (p *AWS) AuthorizeSign(ctx context.Context, token string) ([]SignOption, er
 
 	return append(so,
 		templateOptions,
+		p.webhooks.x509Option(data),
 		// modifiers / withOptions
 		newProvisionerExtensionOption(TypeAWS, p.Name, doc.AccountID, "InstanceID", doc.InstanceID),
 		profileDefaultDuration(p.claimer.DefaultTLSCertDuration()),
@@ -475,6 +485,7 @@ func (p *AWS) AuthorizeSign(ctx context.Context, token string) ([]SignOption, er
 		defaultPublicKeyValidator{},
 		commonNameValidator(payload.Claims.Subject),
 		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
+		newX509NamePolicyValidator(p.namePolicy),
 	), nil
 }
 
@@ -743,7 +754,7 @@ func (p *AWS) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "aws.AuthorizeSSHSign")
 	}
-	signOptions = append(signOptions, templateOptions)
+	signOptions = append(signOptions, templateOptions, p.webhooks.sshOption(data))
 
 	return append(signOptions,
 		// Validate user SignSSHOptions.
@@ -756,5 +767,7 @@ func (p *AWS) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 		&sshCertValidityValidator{p.claimer},
 		// Require all the fields in the SSH certificate
 		&sshCertDefaultValidator{},
+		// Validate the principals with the name-constraint policy.
+		newSSHNamePolicyValidator(p.namePolicy),
 	), nil
 }
@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/certificates/errs"
 	"go.step.sm/crypto/jose"
 	"go.step.sm/crypto/sshutil"
@@ -95,6 +96,8 @@ type Azure struct {
 	Claims                 *Claims  `json:"claims,omitempty"`
 	Options                *Options `json:"options,omitempty"`
 	claimer                *Claimer
+	namePolicy             *policy.Engine
+	webhooks               *webhookController
 	config                 *azureConfig
 	oidcConfig             openIDConfiguration
 	keyStore               *keyStore
@@ -208,6 +211,12 @@ func (p *Azure) Init(config Config) (err error) {
 	if p.claimer, err = NewClaimer(p.Claims, config.Claims); err != nil {
 		return err
 	}
+	if p.namePolicy, err = newNamePolicyEngine(p.Options); err != nil {
+		return err
+	}
+	if p.webhooks, err = newWebhookController(p.Name, p.Options, config.WebhookClient); err != nil {
+		return err
+	}
 
 	// Decode and validate openid-configuration endpoint
 	if err := getAndDecode(p.config.oidcDiscoveryURL, &p.oidcConfig); err != nil {
@@ -320,12 +329,14 @@ func (p *Azure) AuthorizeSign(ctx context.Context, token string) ([]SignOption,
 
 	return append(so,
 		templateOptions,
+		p.webhooks.x509Option(data),
 		// modifiers / withOptions
 		newProvisionerExtensionOption(TypeAzure, p.Name, p.TenantID),
 		profileDefaultDuration(p.claimer.DefaultTLSCertDuration()),
 		// validators
 		defaultPublicKeyValidator{},
 		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
+		newX509NamePolicyValidator(p.namePolicy),
 	), nil
 }
 
@@ -381,7 +392,7 @@ func (p *Azure) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOptio
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "azure.AuthorizeSSHSign")
 	}
-	signOptions = append(signOptions, templateOptions)
+	signOptions = append(signOptions, templateOptions, p.webhooks.sshOption(data))
 
 	return append(signOptions,
 		// Validate user SignSSHOptions.
@@ -394,6 +405,8 @@ func (p *Azure) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOptio
 		&sshCertValidityValidator{p.claimer},
 		// Require all the fields in the SSH certificate
 		&sshCertDefaultValidator{},
+		// Validate the principals with the name-constraint policy.
+		newSSHNamePolicyValidator(p.namePolicy),
 	), nil
 }
 
@@ -431,9 +431,9 @@ func TestAzure_AuthorizeSign(t *testing.T) {
 		code    int
 		wantErr bool
 	}{
-		{"ok", p1, args{t1}, 5, http.StatusOK, false},
-		{"ok", p2, args{t2}, 10, http.StatusOK, false},
-		{"ok", p1, args{t11}, 5, http.StatusOK, false},
+		{"ok", p1, args{t1}, 7, http.StatusOK, false},
+		{"ok", p2, args{t2}, 12, http.StatusOK, false},
+		{"ok", p1, args{t11}, 7, http.StatusOK, false},
 		{"fail tenant", p3, args{t3}, 0, http.StatusUnauthorized, true},
 		{"fail resource group", p4, args{t4}, 0, http.StatusUnauthorized, true},
 		{"fail token", p1, args{"token"}, 0, http.StatusUnauthorized, true},
@@ -472,6 +472,10 @@ func TestAzure_AuthorizeSign(t *testing.T) {
 					case *validityValidator:
 						assert.Equals(t, v.min, tt.azure.claimer.MinTLSCertDuration())
 						assert.Equals(t, v.max, tt.azure.claimer.MaxTLSCertDuration())
+					case *x509Webhooks:
+						assert.Equals(t, v.controller, tt.azure.webhooks)
+					case *x509NamePolicyValidator:
+						assert.Equals(t, v.policy, tt.azure.namePolicy)
 					case ipAddressesValidator:
 						assert.Equals(t, v, nil)
 					case emailAddressesValidator:
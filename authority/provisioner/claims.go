@@ -37,6 +37,40 @@ func NewClaimer(claims *Claims, global Claims) (*Claimer, error) {
 	return c, c.Validate()
 }
 
+// mergeClaims returns the claims in c, with the claims in base for the ones
+// that are not set in c.
+func mergeClaims(c, base *Claims) *Claims {
+	switch {
+	case c == nil:
+		return base
+	case base == nil:
+		return c
+	}
+	merged := *c
+	for _, v := range []struct{ dst, src **Duration }{
+		{&merged.MinTLSDur, &base.MinTLSDur},
+		{&merged.MaxTLSDur, &base.MaxTLSDur},
+		{&merged.DefaultTLSDur, &base.DefaultTLSDur},
+		{&merged.MinUserSSHDur, &base.MinUserSSHDur},
+		{&merged.MaxUserSSHDur, &base.MaxUserSSHDur},
+		{&merged.DefaultUserSSHDur, &base.DefaultUserSSHDur},
+		{&merged.MinHostSSHDur, &base.MinHostSSHDur},
+		{&merged.MaxHostSSHDur, &base.MaxHostSSHDur},
+		{&merged.DefaultHostSSHDur, &base.DefaultHostSSHDur},
+	} {
+		if *v.dst == nil {
+			*v.dst = *v.src
+		}
+	}
+	if merged.DisableRenewal == nil {
+		merged.DisableRenewal = base.DisableRenewal
+	}
+	if merged.EnableSSHCA == nil {
+		merged.EnableSSHCA = base.EnableSSHCA
+	}
+	return &merged
+}
+
 // Claims returns the merge of the inner and global claims.
 func (c *Claimer) Claims() Claims {
 	disableRenewal := c.IsDisableRenewal()
@@ -1,6 +1,7 @@
 package provisioner
 
 import (
+	"reflect"
 	"testing"
 	"time"
 
@@ -49,3 +50,26 @@ func TestClaimer_DefaultSSHCertDuration(t *testing.T) {
 		})
 	}
 }
+
+func Test_mergeClaims(t *testing.T) {
+	enabled, disabled := true, false
+	c := &Claims{DefaultTLSDur: &Duration{72 * time.Hour}, EnableSSHCA: &disabled}
+	base := &Claims{DefaultTLSDur: &Duration{24 * time.Hour}, MaxTLSDur: &Duration{168 * time.Hour}, EnableSSHCA: &enabled}
+	if got := mergeClaims(c, base); !reflect.DeepEqual(got, &Claims{
+		DefaultTLSDur: &Duration{72 * time.Hour},
+		MaxTLSDur:     &Duration{168 * time.Hour},
+		EnableSSHCA:   &disabled,
+	}) {
+		t.Errorf("mergeClaims() = %v", got)
+	}
+	if got := mergeClaims(nil, base); got != base {
+		t.Errorf("mergeClaims() = %v, want %v", got, base)
+	}
+	if got := mergeClaims(c, nil); got != c {
+		t.Errorf("mergeClaims() = %v, want %v", got, c)
+	}
+	// The claims are not modified.
+	if c.MaxTLSDur != nil {
+		t.Errorf("mergeClaims() modified the claims: %v", c)
+	}
+}
@@ -94,7 +94,7 @@ func (c *Collection) LoadByToken(token *jose.JSONWebToken, claims *jose.Claims)
 
 	// match with server audiences
 	if matchesAudience(claims.Audience, audiences) {
-		// Use fragment to get provisioner name (GCP, AWS, SSHPOP)
+		// Use fragment to get provisioner name (GCP, AWS, SSHPOP, TPM)
 		if fragment != "" {
 			return c.LoadByTokenID(fragment)
 		}
@@ -124,6 +124,13 @@ func (c *Collection) LoadByToken(token *jose.JSONWebToken, claims *jose.Claims)
 		return nil, false
 	}
 
+	// Try with issuer and audience (Workload, K8sSA)
+	for _, aud := range payload.Audience {
+		if p, ok := c.LoadByTokenID(payload.Issuer + "#" + aud); ok {
+			return p, ok
+		}
+	}
+
 	// Try with azp (OIDC)
 	if len(payload.AuthorizedParty) > 0 {
 		if p, ok := c.LoadByTokenID(payload.AuthorizedParty); ok {
@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/certificates/errs"
 	"go.step.sm/crypto/jose"
 	"go.step.sm/crypto/sshutil"
@@ -89,6 +90,8 @@ type GCP struct {
 	Claims                 *Claims  `json:"claims,omitempty"`
 	Options                *Options `json:"options,omitempty"`
 	claimer                *Claimer
+	namePolicy             *policy.Engine
+	webhooks               *webhookController
 	config                 *gcpConfig
 	keyStore               *keyStore
 	audiences              Audiences
@@ -210,6 +213,12 @@ func (p *GCP) Init(config Config) error {
 	if p.claimer, err = NewClaimer(p.Claims, config.Claims); err != nil {
 		return err
 	}
+	if p.namePolicy, err = newNamePolicyEngine(p.Options); err != nil {
+		return err
+	}
+	if p.webhooks, err = newWebhookController(p.Name, p.Options, config.WebhookClient); err != nil {
+		return err
+	}
 	// Initialize key store
 	p.keyStore, err = newKeyStore(p.config.CertsURL)
 	if err != nil {
@@ -265,12 +274,14 @@ func (p *GCP) AuthorizeSign(ctx context.Context, token string) ([]SignOption, er
 
 	return append(so,
 		templateOptions,
+		p.webhooks.x509Option(data),
 		// modifiers / withOptions
 		newProvisionerExtensionOption(TypeGCP, p.Name, claims.Subject, "InstanceID", ce.InstanceID, "InstanceName", ce.InstanceName),
 		profileDefaultDuration(p.claimer.DefaultTLSCertDuration()),
 		// validators
 		defaultPublicKeyValidator{},
 		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
+		newX509NamePolicyValidator(p.namePolicy),
 	), nil
 }
 
@@ -423,7 +434,7 @@ func (p *GCP) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "gcp.AuthorizeSSHSign")
 	}
-	signOptions = append(signOptions, templateOptions)
+	signOptions = append(signOptions, templateOptions, p.webhooks.sshOption(data))
 
 	return append(signOptions,
 		// Validate user SignSSHOptions.
@@ -436,5 +447,7 @@ func (p *GCP) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 		&sshCertValidityValidator{p.claimer},
 		// Require all the fields in the SSH certificate
 		&sshCertDefaultValidator{},
+		// Validate the principals with the name-constraint policy.
+		newSSHNamePolicyValidator(p.namePolicy),
 	), nil
 }
@@ -515,9 +515,9 @@ func TestGCP_AuthorizeSign(t *testing.T) {
 		code    int
 		wantErr bool
 	}{
-		{"ok", p1, args{t1}, 5, http.StatusOK, false},
-		{"ok", p2, args{t2}, 10, http.StatusOK, false},
-		{"ok", p3, args{t3}, 5, http.StatusOK, false},
+		{"ok", p1, args{t1}, 7, http.StatusOK, false},
+		{"ok", p2, args{t2}, 12, http.StatusOK, false},
+		{"ok", p3, args{t3}, 7, http.StatusOK, false},
 		{"fail token", p1, args{"token"}, 0, http.StatusUnauthorized, true},
 		{"fail key", p1, args{failKey}, 0, http.StatusUnauthorized, true},
 		{"fail iss", p1, args{failIss}, 0, http.StatusUnauthorized, true},
@@ -561,6 +561,10 @@ func TestGCP_AuthorizeSign(t *testing.T) {
 					case *validityValidator:
 						assert.Equals(t, v.min, tt.gcp.claimer.MinTLSCertDuration())
 						assert.Equals(t, v.max, tt.gcp.claimer.MaxTLSCertDuration())
+					case *x509Webhooks:
+						assert.Equals(t, v.controller, tt.gcp.webhooks)
+					case *x509NamePolicyValidator:
+						assert.Equals(t, v.policy, tt.gcp.namePolicy)
 					case ipAddressesValidator:
 						assert.Equals(t, v, nil)
 					case emailAddressesValidator:
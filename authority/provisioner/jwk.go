@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/certificates/errs"
 	"go.step.sm/crypto/jose"
 	"go.step.sm/crypto/sshutil"
@@ -36,6 +37,8 @@ type JWK struct {
 	Claims       *Claims          `json:"claims,omitempty"`
 	Options      *Options         `json:"options,omitempty"`
 	claimer      *Claimer
+	namePolicy   *policy.Engine
+	webhooks     *webhookController
 	audiences    Audiences
 }
 
@@ -102,6 +105,12 @@ func (p *JWK) Init(config Config) (err error) {
 	if p.claimer, err = NewClaimer(p.Claims, config.Claims); err != nil {
 		return err
 	}
+	if p.namePolicy, err = newNamePolicyEngine(p.Options); err != nil {
+		return err
+	}
+	if p.webhooks, err = newWebhookController(p.Name, p.Options, config.WebhookClient); err != nil {
+		return err
+	}
 
 	p.audiences = config.Audiences
 	return err
@@ -177,6 +186,7 @@ func (p *JWK) AuthorizeSign(ctx context.Context, token string) ([]SignOption, er
 
 	return []SignOption{
 		templateOptions,
+		p.webhooks.x509Option(data),
 		// modifiers / withOptions
 		newProvisionerExtensionOption(TypeJWK, p.Name, p.Key.KeyID),
 		profileDefaultDuration(p.claimer.DefaultTLSCertDuration()),
@@ -185,6 +195,7 @@ func (p *JWK) AuthorizeSign(ctx context.Context, token string) ([]SignOption, er
 		defaultPublicKeyValidator{},
 		defaultSANsValidator(claims.SANs),
 		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
+		newX509NamePolicyValidator(p.namePolicy),
 	}, nil
 }
 
@@ -248,7 +259,7 @@ func (p *JWK) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "jwk.AuthorizeSign")
 	}
-	signOptions = append(signOptions, templateOptions)
+	signOptions = append(signOptions, templateOptions, p.webhooks.sshOption(data))
 
 	// Add modifiers from custom claims
 	t := now()
@@ -268,6 +279,8 @@ func (p *JWK) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 		&sshCertValidityValidator{p.claimer},
 		// Require and validate all the default fields in the SSH certificate.
 		&sshCertDefaultValidator{},
+		// Validate the principals with the name-constraint policy.
+		newSSHNamePolicyValidator(p.namePolicy),
 	), nil
 }
 
@@ -295,7 +295,7 @@ func TestJWK_AuthorizeSign(t *testing.T) {
 				}
 			} else {
 				if assert.NotNil(t, got) {
-					assert.Len(t, 7, got)
+					assert.Len(t, 9, got)
 					for _, o := range got {
 						switch v := o.(type) {
 						case certificateOptionsFunc:
@@ -312,6 +312,10 @@ func TestJWK_AuthorizeSign(t *testing.T) {
 						case *validityValidator:
 							assert.Equals(t, v.min, tt.prov.claimer.MinTLSCertDuration())
 							assert.Equals(t, v.max, tt.prov.claimer.MaxTLSCertDuration())
+						case *x509Webhooks:
+							assert.Equals(t, v.controller, tt.prov.webhooks)
+						case *x509NamePolicyValidator:
+							assert.Equals(t, v.policy, tt.prov.namePolicy)
 						case defaultSANsValidator:
 							assert.Equals(t, []string(v), tt.sans)
 						default:
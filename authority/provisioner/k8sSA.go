@@ -8,8 +8,10 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"net/http"
+	"time"
 
 	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/certificates/errs"
 	"go.step.sm/crypto/jose"
 	"go.step.sm/crypto/pemutil"
@@ -17,9 +19,11 @@ import (
 	"go.step.sm/crypto/x509util"
 )
 
-// NOTE: There can be at most one kubernetes service account provisioner configured
-// per instance of step-ca. This is due to a lack of distinguishing information
-// contained in kubernetes service account tokens.
+// NOTE: There can be at most one kubernetes service account provisioner
+// without issuer configured per instance of step-ca. This is due to a lack of
+// distinguishing information contained in legacy kubernetes service account
+// tokens. Provisioners for projected service account tokens are identified by
+// the issuer and audience of the tokens.
 
 const (
 	// K8sSAName is the default name used for kubernetes service account provisioners.
@@ -27,31 +31,92 @@ const (
 	// K8sSAID is the default ID for kubernetes service account provisioners.
 	K8sSAID     = "k8ssa/" + K8sSAName
 	k8sSAIssuer = "kubernetes/serviceaccount"
+	// K8sSATemplateKey is the key used to add the service account data to the
+	// certificate templates.
+	K8sSATemplateKey = "K8sSA"
 )
 
 // jwtPayload extends jwt.Claims with step attributes.
 type k8sSAPayload struct {
 	jose.Claims
-	Namespace          string `json:"kubernetes.io/serviceaccount/namespace,omitempty"`
-	SecretName         string `json:"kubernetes.io/serviceaccount/secret.name,omitempty"`
-	ServiceAccountName string `json:"kubernetes.io/serviceaccount/service-account.name,omitempty"`
-	ServiceAccountUID  string `json:"kubernetes.io/serviceaccount/service-account.uid,omitempty"`
+	Namespace          string                 `json:"kubernetes.io/serviceaccount/namespace,omitempty"`
+	SecretName         string                 `json:"kubernetes.io/serviceaccount/secret.name,omitempty"`
+	ServiceAccountName string                 `json:"kubernetes.io/serviceaccount/service-account.name,omitempty"`
+	ServiceAccountUID  string                 `json:"kubernetes.io/serviceaccount/service-account.uid,omitempty"`
+	Kubernetes         *k8sSAKubernetesClaims `json:"kubernetes.io,omitempty"`
+}
+
+// k8sSAKubernetesClaims are the claims of projected service account tokens.
+type k8sSAKubernetesClaims struct {
+	Namespace      string          `json:"namespace,omitempty"`
+	Pod            *k8sSAObjectRef `json:"pod,omitempty"`
+	Secret         *k8sSAObjectRef `json:"secret,omitempty"`
+	ServiceAccount *k8sSAObjectRef `json:"serviceaccount,omitempty"`
+}
+
+type k8sSAObjectRef struct {
+	Name string `json:"name,omitempty"`
+	UID  string `json:"uid,omitempty"`
+}
+
+// K8sSATemplateData is the data of the service account available in the
+// certificate templates using the K8sSA key, e.g. {{ .K8sSA.Namespace }}.
+type K8sSATemplateData struct {
+	Namespace          string
+	ServiceAccountName string
+	ServiceAccountUID  string
+	PodName            string
+	PodUID             string
+}
+
+// templateData returns the service account data of legacy and projected
+// tokens.
+func (c *k8sSAPayload) templateData() K8sSATemplateData {
+	data := K8sSATemplateData{
+		Namespace:          c.Namespace,
+		ServiceAccountName: c.ServiceAccountName,
+		ServiceAccountUID:  c.ServiceAccountUID,
+	}
+	if k := c.Kubernetes; k != nil {
+		data.Namespace = k.Namespace
+		if k.ServiceAccount != nil {
+			data.ServiceAccountName = k.ServiceAccount.Name
+			data.ServiceAccountUID = k.ServiceAccount.UID
+		}
+		if k.Pod != nil {
+			data.PodName = k.Pod.Name
+			data.PodUID = k.Pod.UID
+		}
+	}
+	return data
 }
 
 // K8sSA represents a Kubernetes ServiceAccount provisioner; an
 // entity trusted to make signature requests.
+//
+// By default the provisioner validates legacy service account tokens. If an
+// issuer and audience are configured, it validates projected service account
+// tokens, and the keys of the cluster can be discovered using the issuer. The
+// tokens can also be validated using the TokenReview API of the cluster.
 type K8sSA struct {
 	*base
-	ID        string   `json:"-"`
-	Type      string   `json:"type"`
-	Name      string   `json:"name"`
-	PubKeys   []byte   `json:"publicKeys,omitempty"`
-	Claims    *Claims  `json:"claims,omitempty"`
-	Options   *Options `json:"options,omitempty"`
-	claimer   *Claimer
-	audiences Audiences
-	//kauthn    kauthn.AuthenticationV1Interface
-	pubKeys []interface{}
+	ID            string            `json:"-"`
+	Type          string            `json:"type"`
+	Name          string            `json:"name"`
+	PubKeys       []byte            `json:"publicKeys,omitempty"`
+	Issuer        string            `json:"issuer,omitempty"`
+	Audience      string            `json:"audience,omitempty"`
+	JWKSetURI     string            `json:"jwksURI,omitempty"`
+	TokenReview   *K8sSATokenReview `json:"tokenReview,omitempty"`
+	Claims        *Claims           `json:"claims,omitempty"`
+	Options       *Options          `json:"options,omitempty"`
+	claimer       *Claimer
+	namePolicy    *policy.Engine
+	webhooks      *webhookController
+	audiences     Audiences
+	keyStore      *keyStore
+	tokenReviewer *k8sSATokenReviewer
+	pubKeys       []interface{}
 }
 
 // GetID returns the provisioner unique identifier. The name and credential id
@@ -64,8 +129,12 @@ func (p *K8sSA) GetID() string {
 }
 
 // GetIDForToken returns an identifier that will be used to load the provisioner
-// from a token.
+// from a token. Provisioners with an issuer are identified by the issuer and
+// the audience separated by a "#".
 func (p *K8sSA) GetIDForToken() string {
+	if p.Issuer != "" {
+		return p.Issuer + "#" + p.Audience
+	}
 	return K8sSAID
 }
 
@@ -97,6 +166,13 @@ func (p *K8sSA) Init(config Config) (err error) {
 		return errors.New("provisioner type cannot be empty")
 	case p.Name == "":
 		return errors.New("provisioner name cannot be empty")
+	case p.Issuer != "" && p.Audience == "":
+		return errors.New("provisioner audience cannot be empty if issuer is set")
+	case p.Issuer != "" && p.Audience == p.Issuer:
+		// The default tokens of the pods are valid for the API server.
+		return errors.New("provisioner audience cannot be the issuer")
+	case p.Issuer == "" && (p.Audience != "" || p.JWKSetURI != ""):
+		return errors.New("provisioner issuer cannot be empty if audience or jwksURI are set")
 	}
 
 	if p.PubKeys != nil {
@@ -120,28 +196,41 @@ func (p *K8sSA) Init(config Config) (err error) {
 			}
 			p.pubKeys = append(p.pubKeys, key)
 		}
-	} else {
-		// TODO: Use the TokenReview API if no pub keys provided. This will need to
-		// be configured with additional attributes in the K8sSA struct for
-		// connecting to the kubernetes API server.
-		return errors.New("K8s Service Account provisioner cannot be initialized without pub keys")
 	}
-	/*
-		// NOTE: Not sure if we should be doing this initialization here ...
-		// If you have a k8sSA provisioner defined in your config, but you're not
-		// in a kubernetes pod then your CA will fail to startup. Maybe we just postpone
-		// creating the authn until token validation time?
-		if err := checkAccess(k8s.AuthorizationV1()); err != nil {
-			return errors.Wrapf(err, "error verifying access to kubernetes authz service for provisioner %s", p.GetID())
-		}
 
-		p.kauthn = k8s.AuthenticationV1()
-	*/
+	switch {
+	case p.TokenReview != nil:
+		if p.tokenReviewer, err = newK8sSATokenReviewer(p.TokenReview); err != nil {
+			return errors.Wrapf(err, "error initializing provisioner '%s'", p.GetName())
+		}
+	case p.JWKSetURI != "":
+		if p.keyStore, err = newKeyStore(p.JWKSetURI); err != nil {
+			return err
+		}
+	case p.PubKeys != nil:
+	case p.Issuer != "":
+		// Discover the JWK set of the cluster.
+		jwksURI, err := discoverJWKSetURI(p.Issuer)
+		if err != nil {
+			return err
+		}
+		if p.keyStore, err = newKeyStore(jwksURI); err != nil {
+			return err
+		}
+	default:
+		return errors.New("K8s Service Account provisioner cannot be initialized without pub keys, issuer or tokenReview")
+	}
 
 	// Update claims with global ones
 	if p.claimer, err = NewClaimer(p.Claims, config.Claims); err != nil {
 		return err
 	}
+	if p.namePolicy, err = newNamePolicyEngine(p.Options); err != nil {
+		return err
+	}
+	if p.webhooks, err = newWebhookController(p.Name, p.Options, config.WebhookClient); err != nil {
+		return err
+	}
 
 	p.audiences = config.Audiences
 	return err
@@ -157,48 +246,66 @@ func (p *K8sSA) authorizeToken(token string, audiences []string) (*k8sSAPayload,
 			"k8ssa.authorizeToken; error parsing k8sSA token")
 	}
 
-	var (
-		valid  bool
-		claims k8sSAPayload
-	)
-	if p.pubKeys == nil {
-		return nil, errs.Unauthorized("k8ssa.authorizeToken; k8sSA TokenReview API integration not implemented")
-		/* NOTE: We plan to support the TokenReview API in a future release.
-		         Below is some code that should be useful when we prioritize
-				 this integration.
-
-			tr := kauthnApi.TokenReview{Spec: kauthnApi.TokenReviewSpec{Token: string(token)}}
-			rvw, err := p.kauthn.TokenReviews().Create(&tr)
-			if err != nil {
-				return nil, errors.Wrap(err, "error using kubernetes TokenReview API")
-			}
-			if rvw.Status.Error != "" {
-				return nil, errors.Errorf("error from kubernetes TokenReviewAPI: %s", rvw.Status.Error)
-			}
-			if !rvw.Status.Authenticated {
-				return nil, errors.New("error from kubernetes TokenReviewAPI: token could not be authenticated")
+	var claims k8sSAPayload
+	if p.tokenReviewer != nil {
+		// The API server validates the token, if the issuer is configured the
+		// token must be valid for the audience of the provisioner.
+		var tokenAudiences []string
+		if p.Issuer != "" {
+			tokenAudiences = []string{p.Audience}
+		}
+		username, err := p.tokenReviewer.Review(token, tokenAudiences)
+		if err != nil {
+			return nil, errs.Wrap(http.StatusUnauthorized, err, "k8ssa.authorizeToken; error validating k8sSA token")
+		}
+		if err := jwt.UnsafeClaimsWithoutVerification(&claims); err != nil {
+			return nil, errs.Wrap(http.StatusUnauthorized, err, "k8ssa.authorizeToken; error parsing k8sSA token claims")
+		}
+		if claims.Subject != username {
+			return nil, errs.Unauthorized("k8ssa.authorizeToken; k8sSA token subject does not match the authenticated user")
+		}
+	} else {
+		keys := p.pubKeys
+		if p.keyStore != nil {
+			for _, k := range p.keyStore.Get(jwt.Headers[0].KeyID) {
+				keys = append(keys, k)
 			}
-			if err = jwt.UnsafeClaimsWithoutVerification(&claims); err != nil {
-				return nil, errors.Wrap(err, "error parsing claims")
+		}
+		if len(keys) == 0 {
+			return nil, errs.Unauthorized("k8ssa.authorizeToken; k8sSA provisioner has no keys to validate the token")
+		}
+		var valid bool
+		for _, pk := range keys {
+			if err = jwt.Claims(pk, &claims); err == nil {
+				valid = true
+				break
 			}
-		*/
-	}
-	for _, pk := range p.pubKeys {
-		if err = jwt.Claims(pk, &claims); err == nil {
-			valid = true
-			break
 		}
-	}
-	if !valid {
-		return nil, errs.Unauthorized("k8ssa.authorizeToken; error validating k8sSA token and extracting claims")
+		if !valid {
+			return nil, errs.Unauthorized("k8ssa.authorizeToken; error validating k8sSA token and extracting claims")
+		}
 	}
 
-	// According to "rfc7519 JSON Web Token" acceptable skew should be no
-	// more than a few minutes.
-	if err = claims.Validate(jose.Expected{
-		Issuer: k8sSAIssuer,
-	}); err != nil {
-		return nil, errs.Wrap(http.StatusUnauthorized, err, "k8ssa.authorizeToken; invalid k8sSA token claims")
+	if p.Issuer == "" {
+		// Legacy tokens do not have an expiration or audience.
+		if err = claims.Validate(jose.Expected{
+			Issuer: k8sSAIssuer,
+		}); err != nil {
+			return nil, errs.Wrap(http.StatusUnauthorized, err, "k8ssa.authorizeToken; invalid k8sSA token claims")
+		}
+	} else {
+		// According to "rfc7519 JSON Web Token" acceptable skew should be no
+		// more than a few minutes.
+		if err = claims.ValidateWithLeeway(jose.Expected{
+			Issuer:   p.Issuer,
+			Audience: jose.Audience{p.Audience},
+			Time:     time.Now().UTC(),
+		}, time.Minute); err != nil {
+			return nil, errs.Wrap(http.StatusUnauthorized, err, "k8ssa.authorizeToken; invalid k8sSA token claims")
+		}
+		if claims.Expiry == nil {
+			return nil, errs.Unauthorized("k8ssa.authorizeToken; k8sSA token must have an expiration")
+		}
 	}
 
 	if claims.Subject == "" {
@@ -223,8 +330,10 @@ func (p *K8sSA) AuthorizeSign(ctx context.Context, token string) ([]SignOption,
 	}
 
 	// Add some values to use in custom templates.
+	sa := claims.templateData()
 	data := x509util.NewTemplateData()
-	data.SetCommonName(claims.ServiceAccountName)
+	data.SetCommonName(sa.ServiceAccountName)
+	data.Set(K8sSATemplateKey, sa)
 	if v, err := unsafeParseSigned(token); err == nil {
 		data.SetToken(v)
 	}
@@ -238,12 +347,14 @@ func (p *K8sSA) AuthorizeSign(ctx context.Context, token string) ([]SignOption,
 
 	return []SignOption{
 		templateOptions,
+		p.webhooks.x509Option(data),
 		// modifiers / withOptions
 		newProvisionerExtensionOption(TypeK8sSA, p.Name, ""),
 		profileDefaultDuration(p.claimer.DefaultTLSCertDuration()),
 		// validators
 		defaultPublicKeyValidator{},
 		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
+		newX509NamePolicyValidator(p.namePolicy),
 	}, nil
 }
 
@@ -267,7 +378,9 @@ func (p *K8sSA) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOptio
 
 	// Certificate templates.
 	// Set some default variables to be used in the templates.
-	data := sshutil.CreateTemplateData(sshutil.HostCert, claims.ServiceAccountName, []string{claims.ServiceAccountName})
+	sa := claims.templateData()
+	data := sshutil.CreateTemplateData(sshutil.HostCert, sa.ServiceAccountName, []string{sa.ServiceAccountName})
+	data.Set(K8sSATemplateKey, sa)
 	if v, err := unsafeParseSigned(token); err == nil {
 		data.SetToken(v)
 	}
@@ -276,7 +389,7 @@ func (p *K8sSA) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOptio
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "k8ssa.AuthorizeSSHSign")
 	}
-	signOptions := []SignOption{templateOptions}
+	signOptions := []SignOption{templateOptions, p.webhooks.sshOption(data)}
 
 	return append(signOptions,
 		// Require type, key-id and principals in the SignSSHOptions.
@@ -289,29 +402,7 @@ func (p *K8sSA) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOptio
 		&sshCertValidityValidator{p.claimer},
 		// Require and validate all the default fields in the SSH certificate.
 		&sshCertDefaultValidator{},
+		// Validate the principals with the name-constraint policy.
+		newSSHNamePolicyValidator(p.namePolicy),
 	), nil
 }
-
-/*
-func checkAccess(authz kauthz.AuthorizationV1Interface) error {
-	r := &kauthzApi.SelfSubjectAccessReview{
-		Spec: kauthzApi.SelfSubjectAccessReviewSpec{
-			ResourceAttributes: &kauthzApi.ResourceAttributes{
-				Group:    "authentication.k8s.io",
-				Version:  "v1",
-				Resource: "tokenreviews",
-				Verb:     "create",
-			},
-		},
-	}
-	rvw, err := authz.SelfSubjectAccessReviews().Create(r)
-	if err != nil {
-		return err
-	}
-	if !rvw.Status.Allowed {
-		return fmt.Errorf("Unable to create kubernetes token reviews: %s", rvw.Status.Reason)
-	}
-
-	return nil
-}
-*/
@@ -3,6 +3,7 @@ package provisioner
 import (
 	"context"
 	"crypto/x509"
+	"io/ioutil"
 	"net/http"
 	"testing"
 	"time"
@@ -11,6 +12,7 @@ import (
 	"github.com/smallstep/assert"
 	"github.com/smallstep/certificates/errs"
 	"go.step.sm/crypto/jose"
+	"go.step.sm/crypto/x509util"
 )
 
 func TestK8sSA_Getters(t *testing.T) {
@@ -51,7 +53,7 @@ func TestK8sSA_authorizeToken(t *testing.T) {
 				err:   errors.New("k8ssa.authorizeToken; error parsing k8sSA token"),
 			}
 		},
-		"fail/not-implemented": func(t *testing.T) test {
+		"fail/no-keys": func(t *testing.T) test {
 			jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
 			assert.FatalError(t, err)
 			p, err := generateK8sSA(nil)
@@ -63,7 +65,7 @@ func TestK8sSA_authorizeToken(t *testing.T) {
 			return test{
 				p:     p,
 				token: tok,
-				err:   errors.New("k8ssa.authorizeToken; k8sSA TokenReview API integration not implemented"),
+				err:   errors.New("k8ssa.authorizeToken; k8sSA provisioner has no keys to validate the token"),
 				code:  http.StatusUnauthorized,
 			}
 		},
@@ -286,12 +288,16 @@ func TestK8sSA_AuthorizeSign(t *testing.T) {
 							case *validityValidator:
 								assert.Equals(t, v.min, tc.p.claimer.MinTLSCertDuration())
 								assert.Equals(t, v.max, tc.p.claimer.MaxTLSCertDuration())
+							case *x509Webhooks:
+								assert.Equals(t, v.controller, tc.p.webhooks)
+							case *x509NamePolicyValidator:
+								assert.Equals(t, v.policy, tc.p.namePolicy)
 							default:
 								assert.FatalError(t, errors.Errorf("unexpected sign option of type %T", v))
 							}
 							tot++
 						}
-						assert.Equals(t, tot, 5)
+						assert.Equals(t, tot, 7)
 					}
 				}
 			}
@@ -370,15 +376,209 @@ func TestK8sSA_AuthorizeSSHSign(t *testing.T) {
 							case *sshCertDefaultValidator:
 							case *sshDefaultDuration:
 								assert.Equals(t, v.Claimer, tc.p.claimer)
+							case *sshWebhooks:
+								assert.Equals(t, v.controller, tc.p.webhooks)
+							case *sshNamePolicyValidator:
+								assert.Equals(t, v.policy, tc.p.namePolicy)
 							default:
 								assert.FatalError(t, errors.Errorf("unexpected sign option of type %T", v))
 							}
 							tot++
 						}
-						assert.Equals(t, tot, 6)
+						assert.Equals(t, tot, 8)
 					}
 				}
 			}
 		})
 	}
 }
+
+func getK8sSAProjectedPayload(iss, aud string) *k8sSAPayload {
+	now := time.Now()
+	return &k8sSAPayload{
+		Claims: jose.Claims{
+			Issuer:    iss,
+			Subject:   "system:serviceaccount:ns-foo:san-foo",
+			Audience:  jose.Audience{aud},
+			IssuedAt:  jose.NewNumericDate(now),
+			NotBefore: jose.NewNumericDate(now),
+			Expiry:    jose.NewNumericDate(now.Add(10 * time.Minute)),
+		},
+		Kubernetes: &k8sSAKubernetesClaims{
+			Namespace:      "ns-foo",
+			Pod:            &k8sSAObjectRef{Name: "pod-foo", UID: "poduid-foo"},
+			ServiceAccount: &k8sSAObjectRef{Name: "san-foo", UID: "sauid-foo"},
+		},
+	}
+}
+
+func TestK8sSA_GetIDForToken(t *testing.T) {
+	p := &K8sSA{Name: "cluster-1", Issuer: "https://cluster-1.example.com", Audience: "step-ca"}
+	assert.Equals(t, "https://cluster-1.example.com#step-ca", p.GetIDForToken())
+	assert.Equals(t, "https://cluster-1.example.com#step-ca", p.GetID())
+	p = &K8sSA{Name: "legacy"}
+	assert.Equals(t, K8sSAID, p.GetIDForToken())
+}
+
+func TestK8sSA_Init(t *testing.T) {
+	srv := generateJWKServer(2)
+	defer srv.Close()
+
+	pubKeys, err := ioutil.ReadFile("./testdata/certs/foo.pub")
+	assert.FatalError(t, err)
+	roots, err := ioutil.ReadFile("./testdata/certs/root_ca.crt")
+	assert.FatalError(t, err)
+
+	config := Config{Claims: globalProvisionerClaims, Audiences: testAudiences}
+	tests := []struct {
+		name         string
+		p            *K8sSA
+		wantKeyStore bool
+		wantErr      bool
+	}{
+		{"ok legacy", &K8sSA{Type: "K8sSA", Name: "legacy", PubKeys: pubKeys}, false, false},
+		{"ok issuer with keys", &K8sSA{Type: "K8sSA", Name: "name", Issuer: "https://cluster.example.com", Audience: "step-ca", PubKeys: pubKeys}, false, false},
+		{"ok jwksURI", &K8sSA{Type: "K8sSA", Name: "name", Issuer: "https://cluster.example.com", Audience: "step-ca", JWKSetURI: srv.URL + "/jwks_uri"}, true, false},
+		{"ok discovery", &K8sSA{Type: "K8sSA", Name: "name", Issuer: srv.URL + "/workload", Audience: "step-ca"}, true, false},
+		{"ok tokenReview", &K8sSA{Type: "K8sSA", Name: "name", TokenReview: &K8sSATokenReview{URL: srv.URL, Roots: roots}}, false, false},
+		{"fail type", &K8sSA{Name: "name", PubKeys: pubKeys}, false, true},
+		{"fail name", &K8sSA{Type: "K8sSA", PubKeys: pubKeys}, false, true},
+		{"fail audience", &K8sSA{Type: "K8sSA", Name: "name", Issuer: "https://cluster.example.com", PubKeys: pubKeys}, false, true},
+		{"fail issuer", &K8sSA{Type: "K8sSA", Name: "name", Audience: "step-ca", PubKeys: pubKeys}, false, true},
+		{"fail audience issuer", &K8sSA{Type: "K8sSA", Name: "name", Issuer: "https://cluster.example.com", Audience: "https://cluster.example.com", PubKeys: pubKeys}, false, true},
+		{"fail jwksURI without issuer", &K8sSA{Type: "K8sSA", Name: "name", JWKSetURI: srv.URL + "/jwks_uri"}, false, true},
+		{"fail no keys", &K8sSA{Type: "K8sSA", Name: "name"}, false, true},
+		{"fail pubKeys", &K8sSA{Type: "K8sSA", Name: "name", PubKeys: []byte("-----BEGIN PUBLIC KEY-----\nZm9v\n-----END PUBLIC KEY-----\n")}, false, true},
+		{"fail jwksURI", &K8sSA{Type: "K8sSA", Name: "name", Issuer: "https://cluster.example.com", Audience: "step-ca", JWKSetURI: srv.URL + "/error"}, false, true},
+		{"fail discovery", &K8sSA{Type: "K8sSA", Name: "name", Issuer: srv.URL, Audience: "step-ca"}, false, true},
+		{"fail tokenReview roots", &K8sSA{Type: "K8sSA", Name: "name", TokenReview: &K8sSATokenReview{URL: srv.URL, Roots: []byte("foo")}}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.p.Init(config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("K8sSA.Init() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil {
+				assert.Equals(t, tt.wantKeyStore, tt.p.keyStore != nil)
+			}
+		})
+	}
+}
+
+func TestK8sSA_authorizeToken_projected(t *testing.T) {
+	srv := generateJWKServer(2)
+	defer srv.Close()
+
+	var keys jose.JSONWebKeySet
+	assert.FatalError(t, getAndDecode(srv.URL+"/private", &keys))
+
+	config := Config{Claims: globalProvisionerClaims, Audiences: testAudiences}
+	p := &K8sSA{Type: "K8sSA", Name: "cluster-1", Issuer: "https://cluster-1.example.com", Audience: "step-ca", JWKSetURI: srv.URL + "/jwks_uri"}
+	assert.FatalError(t, p.Init(config))
+
+	mustToken := func(jwk *jose.JSONWebKey, claims *k8sSAPayload) string {
+		tok, err := generateK8sSAToken(jwk, claims)
+		assert.FatalError(t, err)
+		return tok
+	}
+	otherKey, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+
+	expired := getK8sSAProjectedPayload(p.Issuer, p.Audience)
+	expired.Expiry = jose.NewNumericDate(time.Now().Add(-time.Hour))
+	noExpiry := getK8sSAProjectedPayload(p.Issuer, p.Audience)
+	noExpiry.Expiry = nil
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"ok", mustToken(&keys.Keys[0], getK8sSAProjectedPayload(p.Issuer, p.Audience)), false},
+		{"ok other key", mustToken(&keys.Keys[1], getK8sSAProjectedPayload(p.Issuer, p.Audience)), false},
+		{"fail key", mustToken(otherKey, getK8sSAProjectedPayload(p.Issuer, p.Audience)), true},
+		{"fail issuer", mustToken(&keys.Keys[0], getK8sSAProjectedPayload("https://cluster-2.example.com", p.Audience)), true},
+		{"fail audience", mustToken(&keys.Keys[0], getK8sSAProjectedPayload(p.Issuer, "https://cluster-1.example.com")), true},
+		{"fail expired", mustToken(&keys.Keys[0], expired), true},
+		{"fail no expiry", mustToken(&keys.Keys[0], noExpiry), true},
+		{"fail legacy", mustToken(&keys.Keys[0], getK8sSAPayload()), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := p.authorizeToken(tt.token, testAudiences.Sign)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("K8sSA.authorizeToken() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, sc.StatusCode(), http.StatusUnauthorized)
+				return
+			}
+			assert.Equals(t, K8sSATemplateData{
+				Namespace:          "ns-foo",
+				ServiceAccountName: "san-foo",
+				ServiceAccountUID:  "sauid-foo",
+				PodName:            "pod-foo",
+				PodUID:             "poduid-foo",
+			}, claims.templateData())
+		})
+	}
+}
+
+func TestK8sSA_AuthorizeSign_template(t *testing.T) {
+	jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	assert.FatalError(t, err)
+	csr := parseCertificateRequest(t, "testdata/certs/ecdsa.csr")
+
+	template := `{
+	"subject": {"commonName": {{ toJson .K8sSA.ServiceAccountName }}},
+	"uris": ["spiffe://cluster.local/ns/{{ .K8sSA.Namespace }}/sa/{{ .K8sSA.ServiceAccountName }}"]
+}`
+	legacy, err := generateK8sSA(jwk.Public().Key)
+	assert.FatalError(t, err)
+	legacy.Options = &Options{X509: &X509Options{Template: template}}
+	projected, err := generateK8sSA(jwk.Public().Key)
+	assert.FatalError(t, err)
+	projected.Issuer = "https://cluster-1.example.com"
+	projected.Audience = "step-ca"
+	projected.Options = &Options{X509: &X509Options{Template: template}}
+
+	legacyToken, err := generateK8sSAToken(jwk, nil)
+	assert.FatalError(t, err)
+	projectedToken, err := generateK8sSAToken(jwk, getK8sSAProjectedPayload(projected.Issuer, projected.Audience))
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name     string
+		p        *K8sSA
+		token    string
+		wantURI  string
+		wantName string
+	}{
+		{"legacy", legacy, legacyToken, "spiffe://cluster.local/ns/ns-foo/sa/san-foo", "san-foo"},
+		{"projected", projected, projectedToken, "spiffe://cluster.local/ns/ns-foo/sa/san-foo", "san-foo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := tt.p.AuthorizeSign(context.Background(), tt.token)
+			assert.FatalError(t, err)
+			var certOptions []x509util.Option
+			for _, o := range opts {
+				if v, ok := o.(CertificateOptions); ok {
+					certOptions = append(certOptions, v.Options(SignOptions{})...)
+				}
+			}
+			cert, err := x509util.NewCertificate(csr, certOptions...)
+			assert.FatalError(t, err)
+			crt := cert.GetCertificate()
+			assert.Equals(t, tt.wantName, crt.Subject.CommonName)
+			if assert.Len(t, 1, crt.URIs) {
+				assert.Equals(t, tt.wantURI, crt.URIs[0].String())
+			}
+		})
+	}
+}
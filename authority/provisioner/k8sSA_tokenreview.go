@@ -0,0 +1,187 @@
+package provisioner
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultK8sSATokenReviewURL is the URL of the Kubernetes API server used by
+	// default in the TokenReview requests.
+	DefaultK8sSATokenReviewURL = "https://kubernetes.default.svc"
+	// DefaultK8sSATokenReviewRoots is the file with the root certificates of the
+	// Kubernetes API server used by default in the TokenReview requests.
+	DefaultK8sSATokenReviewRoots = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+	// DefaultK8sSATokenReviewTokenFile is the file with the token used by
+	// default to authenticate the TokenReview requests.
+	DefaultK8sSATokenReviewTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+	k8sSATokenReviewPath    = "/apis/authentication.k8s.io/v1/tokenreviews"
+	k8sSATokenReviewTimeout = 10 * time.Second
+	k8sSAUsernamePrefix     = "system:serviceaccount:"
+	maxTokenReviewSize      = 1 << 20
+)
+
+// K8sSATokenReview is the configuration used to validate the tokens using the
+// TokenReview API of a Kubernetes API server. The default values allow a CA
+// running in a pod of the cluster to use the service account of the pod.
+type K8sSATokenReview struct {
+	// URL is the URL of the Kubernetes API server, defaults to
+	// https://kubernetes.default.svc.
+	URL string `json:"url,omitempty"`
+
+	// Roots are the PEM encoded root certificates used to validate the
+	// certificate of the API server, defaults to the CA of the service
+	// account of the pod.
+	Roots []byte `json:"roots,omitempty"`
+
+	// TokenFile is the file with the token used to authenticate the requests.
+	// The file is read on every request, so it can be rotated. Defaults to the
+	// token of the service account of the pod.
+	TokenFile string `json:"tokenFile,omitempty"`
+}
+
+type k8sSATokenReviewRequest struct {
+	APIVersion string                      `json:"apiVersion"`
+	Kind       string                      `json:"kind"`
+	Spec       k8sSATokenReviewRequestSpec `json:"spec"`
+}
+
+type k8sSATokenReviewRequestSpec struct {
+	Token     string   `json:"token"`
+	Audiences []string `json:"audiences,omitempty"`
+}
+
+type k8sSATokenReviewResponse struct {
+	Status k8sSATokenReviewStatus `json:"status"`
+}
+
+type k8sSATokenReviewStatus struct {
+	Authenticated bool `json:"authenticated"`
+	User          struct {
+		Username string `json:"username"`
+		UID      string `json:"uid"`
+	} `json:"user"`
+	Audiences []string `json:"audiences"`
+	Error     string   `json:"error"`
+}
+
+// k8sSATokenReviewer validates tokens using the TokenReview API.
+type k8sSATokenReviewer struct {
+	url       string
+	tokenFile string
+	client    *http.Client
+}
+
+func newK8sSATokenReviewer(tr *K8sSATokenReview) (*k8sSATokenReviewer, error) {
+	u := tr.URL
+	if u == "" {
+		u = DefaultK8sSATokenReviewURL
+	}
+	tokenFile := tr.TokenFile
+	if tokenFile == "" {
+		tokenFile = DefaultK8sSATokenReviewTokenFile
+	}
+	roots := tr.Roots
+	if len(roots) == 0 {
+		b, err := ioutil.ReadFile(DefaultK8sSATokenReviewRoots)
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading tokenReview roots")
+		}
+		roots = b
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(roots) {
+		return nil, errors.New("error parsing tokenReview roots: no certificates found")
+	}
+
+	return &k8sSATokenReviewer{
+		url:       strings.TrimSuffix(u, "/") + k8sSATokenReviewPath,
+		tokenFile: tokenFile,
+		client: &http.Client{
+			Timeout: k8sSATokenReviewTimeout,
+			Transport: &http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{
+					RootCAs:    pool,
+					MinVersion: tls.VersionTLS12,
+				},
+			},
+		},
+	}, nil
+}
+
+// Review sends the token to the TokenReview API and returns the name of the
+// authenticated user. If audiences are given, the token must be valid for at
+// least one of them.
+func (r *k8sSATokenReviewer) Review(token string, audiences []string) (string, error) {
+	credential, err := ioutil.ReadFile(r.tokenFile)
+	if err != nil {
+		return "", errors.Wrap(err, "error reading tokenReview token")
+	}
+
+	body, err := json.Marshal(k8sSATokenReviewRequest{
+		APIVersion: "authentication.k8s.io/v1",
+		Kind:       "TokenReview",
+		Spec: k8sSATokenReviewRequestSpec{
+			Token:     token,
+			Audiences: audiences,
+		},
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "error marshaling tokenReview request")
+	}
+
+	req, err := http.NewRequest("POST", r.url, bytes.NewReader(body))
+	if err != nil {
+		return "", errors.Wrap(err, "error creating tokenReview request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(credential)))
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "error doing tokenReview request")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return "", errors.Errorf("tokenReview request failed with status code %d", resp.StatusCode)
+	}
+
+	var review k8sSATokenReviewResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxTokenReviewSize)).Decode(&review); err != nil {
+		return "", errors.Wrap(err, "error decoding tokenReview response")
+	}
+
+	status := review.Status
+	switch {
+	case status.Error != "":
+		return "", errors.Errorf("tokenReview failed: %s", status.Error)
+	case !status.Authenticated:
+		return "", errors.New("tokenReview failed: token is not authenticated")
+	case !strings.HasPrefix(status.User.Username, k8sSAUsernamePrefix):
+		return "", errors.Errorf("tokenReview failed: user %s is not a service account", status.User.Username)
+	case len(audiences) > 0 && !containsAnyString(status.Audiences, audiences):
+		return "", errors.New("tokenReview failed: token audiences do not match")
+	}
+	return status.User.Username, nil
+}
+
+func containsAnyString(list, values []string) bool {
+	for _, v := range values {
+		if containsString(list, v) {
+			return true
+		}
+	}
+	return false
+}
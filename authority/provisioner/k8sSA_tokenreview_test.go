@@ -0,0 +1,192 @@
+package provisioner
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/smallstep/assert"
+)
+
+// fakeTokenReviewServer returns a fake Kubernetes API server that authenticates
+// the given tokens with the given service account usernames.
+func fakeTokenReviewServer(t *testing.T, credential string, tokens map[string]string) *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != k8sSATokenReviewPath {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer "+credential {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		var req k8sSATokenReviewRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		assert.Equals(t, "authentication.k8s.io/v1", req.APIVersion)
+		assert.Equals(t, "TokenReview", req.Kind)
+
+		var resp k8sSATokenReviewResponse
+		if username, ok := tokens[req.Spec.Token]; ok {
+			resp.Status.Authenticated = true
+			resp.Status.User.Username = username
+			resp.Status.Audiences = req.Spec.Audiences
+		} else {
+			resp.Status.Error = "invalid bearer token"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(resp)
+	}))
+}
+
+func serverRoots(srv *httptest.Server) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+}
+
+func writeTokenFile(t *testing.T, credential string) string {
+	dir, err := ioutil.TempDir("", "k8ssa")
+	assert.FatalError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	fn := filepath.Join(dir, "token")
+	assert.FatalError(t, ioutil.WriteFile(fn, []byte(credential+"\n"), 0600))
+	return fn
+}
+
+func Test_k8sSATokenReviewer_Review(t *testing.T) {
+	srv := fakeTokenReviewServer(t, "the-credential", map[string]string{
+		"sa-token":   "system:serviceaccount:ns-foo:san-foo",
+		"user-token": "jane",
+	})
+	defer srv.Close()
+
+	tokenFile := writeTokenFile(t, "the-credential")
+	badTokenFile := writeTokenFile(t, "bad-credential")
+
+	type fields struct {
+		url       string
+		tokenFile string
+	}
+	tests := []struct {
+		name      string
+		fields    fields
+		token     string
+		audiences []string
+		want      string
+		wantErr   bool
+	}{
+		{"ok", fields{srv.URL, tokenFile}, "sa-token", nil, "system:serviceaccount:ns-foo:san-foo", false},
+		{"ok audiences", fields{srv.URL + "/", tokenFile}, "sa-token", []string{"step-ca"}, "system:serviceaccount:ns-foo:san-foo", false},
+		{"fail token", fields{srv.URL, tokenFile}, "bad-token", nil, "", true},
+		{"fail user", fields{srv.URL, tokenFile}, "user-token", nil, "", true},
+		{"fail credential", fields{srv.URL, badTokenFile}, "sa-token", nil, "", true},
+		{"fail token file", fields{srv.URL, tokenFile + ".missing"}, "sa-token", nil, "", true},
+		{"fail url", fields{srv.URL + "/not-found", tokenFile}, "sa-token", nil, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := newK8sSATokenReviewer(&K8sSATokenReview{
+				URL:       tt.fields.url,
+				Roots:     serverRoots(srv),
+				TokenFile: tt.fields.tokenFile,
+			})
+			assert.FatalError(t, err)
+			got, err := r.Review(tt.token, tt.audiences)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("k8sSATokenReviewer.Review() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.Equals(t, tt.want, got)
+		})
+	}
+}
+
+func Test_k8sSATokenReviewer_Review_audiences(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var resp k8sSATokenReviewResponse
+		resp.Status.Authenticated = true
+		resp.Status.User.Username = "system:serviceaccount:ns-foo:san-foo"
+		resp.Status.Audiences = []string{"https://kubernetes.default.svc"}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+
+	r, err := newK8sSATokenReviewer(&K8sSATokenReview{
+		URL:       srv.URL,
+		Roots:     serverRoots(srv),
+		TokenFile: writeTokenFile(t, "the-credential"),
+	})
+	assert.FatalError(t, err)
+	_, err = r.Review("sa-token", []string{"step-ca"})
+	assert.Error(t, err)
+}
+
+func TestK8sSA_authorizeToken_tokenReview(t *testing.T) {
+	jwk, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+
+	legacyPayload := getK8sSAPayload()
+	legacyPayload.Subject = "system:serviceaccount:ns-foo:san-foo"
+	legacyToken, err := generateK8sSAToken(jwk, legacyPayload)
+	assert.FatalError(t, err)
+	projectedToken, err := generateK8sSAToken(jwk, getK8sSAProjectedPayload("https://cluster-1.example.com", "step-ca"))
+	assert.FatalError(t, err)
+	otherPayload := getK8sSAProjectedPayload("https://cluster-1.example.com", "step-ca")
+	otherPayload.Kubernetes.Pod.Name = "pod-bar"
+	otherToken, err := generateK8sSAToken(jwk, otherPayload)
+	assert.FatalError(t, err)
+	unknownToken, err := generateK8sSAToken(jwk, getK8sSAProjectedPayload("https://cluster-1.example.com", "step-ca"))
+	assert.FatalError(t, err)
+
+	srv := fakeTokenReviewServer(t, "the-credential", map[string]string{
+		legacyToken:    "system:serviceaccount:ns-foo:san-foo",
+		projectedToken: "system:serviceaccount:ns-foo:san-foo",
+		otherToken:     "system:serviceaccount:ns-foo:other",
+	})
+	defer srv.Close()
+
+	tokenReview := &K8sSATokenReview{
+		URL:       srv.URL,
+		Roots:     serverRoots(srv),
+		TokenFile: writeTokenFile(t, "the-credential"),
+	}
+	config := Config{Claims: globalProvisionerClaims, Audiences: testAudiences}
+	legacy := &K8sSA{Type: "K8sSA", Name: "legacy", TokenReview: tokenReview}
+	assert.FatalError(t, legacy.Init(config))
+	projected := &K8sSA{Type: "K8sSA", Name: "cluster-1", Issuer: "https://cluster-1.example.com", Audience: "step-ca", TokenReview: tokenReview}
+	assert.FatalError(t, projected.Init(config))
+
+	tests := []struct {
+		name    string
+		p       *K8sSA
+		token   string
+		wantErr bool
+	}{
+		{"ok legacy", legacy, legacyToken, false},
+		{"ok projected", projected, projectedToken, false},
+		{"fail legacy issuer", legacy, projectedToken, true},
+		{"fail projected issuer", projected, legacyToken, true},
+		{"fail subject", projected, otherToken, true},
+		{"fail unknown", projected, unknownToken, true},
+		{"fail bad token", projected, "foo", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := tt.p.authorizeToken(tt.token, testAudiences.Sign)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("K8sSA.authorizeToken() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil {
+				assert.Equals(t, "san-foo", claims.templateData().ServiceAccountName)
+			}
+		})
+	}
+}
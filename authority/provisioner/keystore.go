@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"regexp"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -103,6 +104,23 @@ func getKeysFromJWKsURI(uri string) (jose.JSONWebKeySet, time.Duration, error) {
 	return keys, getCacheAge(resp.Header.Get("cache-control")), nil
 }
 
+// discoverJWKSetURI returns the JWK set URL in the OpenID Connect configuration
+// of the given issuer.
+func discoverJWKSetURI(issuer string) (string, error) {
+	var conf openIDConfiguration
+	u := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	if err := getAndDecode(u, &conf); err != nil {
+		return "", err
+	}
+	if err := conf.Validate(); err != nil {
+		return "", errors.Wrapf(err, "error parsing %s", u)
+	}
+	if conf.Issuer != issuer {
+		return "", errors.Errorf("error parsing %s: issuer %s does not match %s", u, conf.Issuer, issuer)
+	}
+	return conf.JWKSetURI, nil
+}
+
 func getCacheAge(cacheControl string) time.Duration {
 	age := defaultCacheAge
 	if len(cacheControl) > 0 {
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/certificates/errs"
 	"go.step.sm/crypto/jose"
 	"go.step.sm/crypto/sshutil"
@@ -52,24 +53,33 @@ type openIDPayload struct {
 // OIDC represents an OAuth 2.0 OpenID Connect provider.
 //
 // ClientSecret is mandatory, but it can be an empty string.
+//
+// GroupsClaim is the claim with the groups of the user, it defaults to
+// "groups", and nested claims can be accessed using dots, e.g.
+// "realm_access.roles". The SSHGroupMappings grant SSH principals, critical
+// options, extensions, and maximum durations to the members of the groups.
 type OIDC struct {
 	*base
-	ID                    string   `json:"-"`
-	Type                  string   `json:"type"`
-	Name                  string   `json:"name"`
-	ClientID              string   `json:"clientID"`
-	ClientSecret          string   `json:"clientSecret"`
-	ConfigurationEndpoint string   `json:"configurationEndpoint"`
-	TenantID              string   `json:"tenantID,omitempty"`
-	Admins                []string `json:"admins,omitempty"`
-	Domains               []string `json:"domains,omitempty"`
-	Groups                []string `json:"groups,omitempty"`
-	ListenAddress         string   `json:"listenAddress,omitempty"`
-	Claims                *Claims  `json:"claims,omitempty"`
-	Options               *Options `json:"options,omitempty"`
+	ID                    string              `json:"-"`
+	Type                  string              `json:"type"`
+	Name                  string              `json:"name"`
+	ClientID              string              `json:"clientID"`
+	ClientSecret          string              `json:"clientSecret"`
+	ConfigurationEndpoint string              `json:"configurationEndpoint"`
+	TenantID              string              `json:"tenantID,omitempty"`
+	Admins                []string            `json:"admins,omitempty"`
+	Domains               []string            `json:"domains,omitempty"`
+	Groups                []string            `json:"groups,omitempty"`
+	GroupsClaim           string              `json:"groupsClaim,omitempty"`
+	SSHGroupMappings      []*OIDCGroupMapping `json:"sshGroupMappings,omitempty"`
+	ListenAddress         string              `json:"listenAddress,omitempty"`
+	Claims                *Claims             `json:"claims,omitempty"`
+	Options               *Options            `json:"options,omitempty"`
 	configuration         openIDConfiguration
 	keyStore              *keyStore
 	claimer               *Claimer
+	namePolicy            *policy.Engine
+	webhooks              *webhookController
 	getIdentityFunc       GetIdentityFunc
 }
 
@@ -178,10 +188,23 @@ func (o *OIDC) Init(config Config) (err error) {
 		}
 	}
 
+	// Validate SSH group mappings
+	for _, m := range o.SSHGroupMappings {
+		if err := m.Validate(); err != nil {
+			return errors.Wrap(err, "error parsing sshGroupMappings")
+		}
+	}
+
 	// Update claims with global ones
 	if o.claimer, err = NewClaimer(o.Claims, config.Claims); err != nil {
 		return err
 	}
+	if o.namePolicy, err = newNamePolicyEngine(o.Options); err != nil {
+		return err
+	}
+	if o.webhooks, err = newWebhookController(o.Name, o.Options, config.WebhookClient); err != nil {
+		return err
+	}
 
 	// Decode and validate openid-configuration endpoint
 	u, err := url.Parse(o.ConfigurationEndpoint)
@@ -296,6 +319,16 @@ func (o *OIDC) authorizeToken(token string) (*openIDPayload, error) {
 		return nil, errs.Unauthorized("oidc.AuthorizeToken; cannot validate oidc token")
 	}
 
+	// Load the groups from a custom claim.
+	if o.GroupsClaim != "" && o.GroupsClaim != "groups" {
+		var m map[string]interface{}
+		if err := jwt.UnsafeClaimsWithoutVerification(&m); err != nil {
+			return nil, errs.Wrap(http.StatusUnauthorized, err,
+				"oidc.AuthorizeToken; error parsing oidc token claims")
+		}
+		claims.Groups = groupsFromClaim(m, o.GroupsClaim)
+	}
+
 	if err := o.ValidatePayload(claims); err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "oidc.AuthorizeToken")
 	}
@@ -362,12 +395,14 @@ func (o *OIDC) AuthorizeSign(ctx context.Context, token string) ([]SignOption, e
 
 	return []SignOption{
 		templateOptions,
+		o.webhooks.x509Option(data),
 		// modifiers / withOptions
 		newProvisionerExtensionOption(TypeOIDC, o.Name, o.ClientID),
 		profileDefaultDuration(o.claimer.DefaultTLSCertDuration()),
 		// validators
 		defaultPublicKeyValidator{},
 		newValidityValidator(o.claimer.MinTLSCertDuration(), o.claimer.MaxTLSCertDuration()),
+		newX509NamePolicyValidator(o.namePolicy),
 	}, nil
 }
 
@@ -404,11 +439,29 @@ func (o *OIDC) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "oidc.AuthorizeSSHSign")
 	}
 
+	// Get the permissions granted to the groups of the user.
+	perms, err := getSSHPermissions(o.SSHGroupMappings, claims.Groups)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "oidc.AuthorizeSSHSign")
+	}
+	claimer, err := perms.claimer(o.claimer)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "oidc.AuthorizeSSHSign")
+	}
+	principals := iden.Usernames
+	for _, p := range perms.Principals {
+		if !containsString(principals, p) {
+			principals = append(principals, p)
+		}
+	}
+
 	// Certificate templates.
-	data := sshutil.CreateTemplateData(sshutil.UserCert, claims.Email, iden.Usernames)
+	data := sshutil.CreateTemplateData(sshutil.UserCert, claims.Email, principals)
 	if v, err := unsafeParseSigned(token); err == nil {
 		data.SetToken(v)
 	}
+	// Add the permissions of the groups.
+	perms.setTemplateData(data)
 	// Add custom extensions added in the identity function.
 	for k, v := range iden.Permissions.Extensions {
 		data.AddExtension(k, v)
@@ -433,7 +486,7 @@ func (o *OIDC) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "jwk.AuthorizeSign")
 	}
-	signOptions := []SignOption{templateOptions}
+	signOptions := []SignOption{templateOptions, o.webhooks.sshOption(data)}
 
 	// Admin users can use any principal, and can sign user and host certificates.
 	// Non-admin users can only use principals returned by the identityFunc, and
@@ -447,19 +500,21 @@ func (o *OIDC) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption
 	} else {
 		signOptions = append(signOptions, sshCertOptionsValidator(SignSSHOptions{
 			CertType:   SSHUserCert,
-			Principals: iden.Usernames,
+			Principals: principals,
 		}))
 	}
 
 	return append(signOptions,
 		// Set the validity bounds if not set.
-		&sshDefaultDuration{o.claimer},
+		&sshDefaultDuration{claimer},
 		// Validate public key
 		&sshDefaultPublicKeyValidator{},
 		// Validate the validity period.
-		&sshCertValidityValidator{o.claimer},
+		&sshCertValidityValidator{claimer},
 		// Require all the fields in the SSH certificate
 		&sshCertDefaultValidator{},
+		// Validate the principals with the name-constraint policy.
+		newSSHNamePolicyValidator(o.namePolicy),
 	), nil
 }
 
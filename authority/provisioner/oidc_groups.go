@@ -0,0 +1,150 @@
+package provisioner
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"go.step.sm/crypto/sshutil"
+)
+
+// OIDCGroupMapping grants SSH permissions to the members of an OIDC group.
+type OIDCGroupMapping struct {
+	// Group is the name of the group in the groups claim of the token.
+	Group string `json:"group"`
+
+	// Principals are the additional principals that the members of the group
+	// can use in their SSH user certificates.
+	Principals []string `json:"principals,omitempty"`
+
+	// CriticalOptions are the critical options added to the SSH certificates,
+	// e.g. force-command or source-address.
+	CriticalOptions map[string]string `json:"criticalOptions,omitempty"`
+
+	// Extensions are the extensions of the SSH certificates. If set, the
+	// default extensions are replaced by the extensions of the mappings of the
+	// groups of the user.
+	Extensions map[string]string `json:"extensions,omitempty"`
+
+	// MaxDuration is the maximum duration of the SSH certificates of the
+	// members of the group. If the user is in multiple groups, the lowest one
+	// will be used.
+	MaxDuration *Duration `json:"maxDuration,omitempty"`
+}
+
+// Validate validates the group mapping.
+func (m *OIDCGroupMapping) Validate() error {
+	switch {
+	case m.Group == "":
+		return errors.New("group cannot be empty")
+	case m.MaxDuration != nil && m.MaxDuration.Duration <= 0:
+		return errors.Errorf("group %s maxDuration must be greater than 0", m.Group)
+	default:
+		return nil
+	}
+}
+
+// oidcSSHPermissions are the SSH permissions granted to a user by the group
+// mappings.
+type oidcSSHPermissions struct {
+	Principals      []string
+	CriticalOptions map[string]string
+	Extensions      map[string]string
+	MaxDuration     time.Duration
+}
+
+// getSSHPermissions returns the SSH permissions granted by the mappings of the
+// given groups.
+func getSSHPermissions(mappings []*OIDCGroupMapping, groups []string) (*oidcSSHPermissions, error) {
+	perms := new(oidcSSHPermissions)
+	criticalOptionGroups := make(map[string]string)
+	for _, m := range mappings {
+		if !containsString(groups, m.Group) {
+			continue
+		}
+		for _, p := range m.Principals {
+			if !containsString(perms.Principals, p) {
+				perms.Principals = append(perms.Principals, p)
+			}
+		}
+		for k, v := range m.CriticalOptions {
+			if perms.CriticalOptions == nil {
+				perms.CriticalOptions = make(map[string]string)
+			}
+			// Critical options restrict the certificate, a user cannot get a
+			// certificate if two groups require different values.
+			if old, ok := perms.CriticalOptions[k]; ok && old != v {
+				return nil, errors.Errorf("groups %s and %s have different values for the critical option %s",
+					criticalOptionGroups[k], m.Group, k)
+			}
+			perms.CriticalOptions[k] = v
+			criticalOptionGroups[k] = m.Group
+		}
+		if m.Extensions != nil {
+			if perms.Extensions == nil {
+				perms.Extensions = make(map[string]string)
+			}
+			for k, v := range m.Extensions {
+				perms.Extensions[k] = v
+			}
+		}
+		if m.MaxDuration != nil && (perms.MaxDuration == 0 || m.MaxDuration.Duration < perms.MaxDuration) {
+			perms.MaxDuration = m.MaxDuration.Duration
+		}
+	}
+	return perms, nil
+}
+
+// setTemplateData adds the permissions to the SSH template data.
+func (p *oidcSSHPermissions) setTemplateData(data sshutil.TemplateData) {
+	if p.Extensions != nil {
+		extensions := make(map[string]interface{}, len(p.Extensions))
+		for k, v := range p.Extensions {
+			extensions[k] = v
+		}
+		data.SetExtensions(extensions)
+	}
+	for k, v := range p.CriticalOptions {
+		data.AddCriticalOption(k, v)
+	}
+}
+
+// claimer returns a claimer that limits the duration of user certificates to
+// the maximum duration of the permissions.
+func (p *oidcSSHPermissions) claimer(c *Claimer) (*Claimer, error) {
+	if p.MaxDuration == 0 || p.MaxDuration >= c.MaxUserSSHCertDuration() {
+		return c, nil
+	}
+	claims := &Claims{
+		MaxUserSSHDur: &Duration{Duration: p.MaxDuration},
+	}
+	if c.DefaultUserSSHCertDuration() > p.MaxDuration {
+		claims.DefaultUserSSHDur = &Duration{Duration: p.MaxDuration}
+	}
+	if c.MinUserSSHCertDuration() > p.MaxDuration {
+		claims.MinUserSSHDur = &Duration{Duration: p.MaxDuration}
+	}
+	return NewClaimer(claims, c.Claims())
+}
+
+// groupsFromClaim returns the groups in the given claim, the claim can be a
+// string or an array of strings.
+func groupsFromClaim(claims map[string]interface{}, name string) []string {
+	v, ok := lookupClaim(claims, name)
+	if !ok {
+		return nil
+	}
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		groups := make([]string, 0, len(v))
+		for _, g := range v {
+			if s, ok := g.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+		return groups
+	default:
+		return nil
+	}
+}
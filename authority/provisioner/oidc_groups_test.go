@@ -0,0 +1,220 @@
+package provisioner
+
+import (
+	"context"
+	"crypto"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/errs"
+	"go.step.sm/crypto/jose"
+)
+
+func TestOIDCGroupMapping_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		m       *OIDCGroupMapping
+		wantErr bool
+	}{
+		{"ok", &OIDCGroupMapping{Group: "dba", Principals: []string{"root@db-*"}}, false},
+		{"ok maxDuration", &OIDCGroupMapping{Group: "dba", MaxDuration: &Duration{Duration: time.Hour}}, false},
+		{"fail group", &OIDCGroupMapping{Principals: []string{"root@db-*"}}, true},
+		{"fail maxDuration", &OIDCGroupMapping{Group: "dba", MaxDuration: &Duration{}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.m.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("OIDCGroupMapping.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_getSSHPermissions(t *testing.T) {
+	mappings := []*OIDCGroupMapping{
+		{Group: "dba", Principals: []string{"root@db-*", "postgres"}, MaxDuration: &Duration{Duration: time.Hour}},
+		{Group: "dev", Principals: []string{"postgres", "deploy"}, Extensions: map[string]string{"permit-pty": ""}},
+		{Group: "ops", Extensions: map[string]string{"permit-port-forwarding": ""}, MaxDuration: &Duration{Duration: 2 * time.Hour}},
+		{Group: "backup", CriticalOptions: map[string]string{"force-command": "/usr/bin/backup", "source-address": "10.0.0.0/8"}},
+		{Group: "restore", CriticalOptions: map[string]string{"force-command": "/usr/bin/restore"}},
+		{Group: "network", CriticalOptions: map[string]string{"source-address": "10.0.0.0/8"}},
+	}
+	tests := []struct {
+		name    string
+		groups  []string
+		want    *oidcSSHPermissions
+		wantErr bool
+	}{
+		{"ok none", []string{"marketing"}, &oidcSSHPermissions{}, false},
+		{"ok dba", []string{"dba"}, &oidcSSHPermissions{
+			Principals:  []string{"root@db-*", "postgres"},
+			MaxDuration: time.Hour,
+		}, false},
+		{"ok dba dev ops", []string{"ops", "dev", "dba"}, &oidcSSHPermissions{
+			Principals:  []string{"root@db-*", "postgres", "deploy"},
+			Extensions:  map[string]string{"permit-pty": "", "permit-port-forwarding": ""},
+			MaxDuration: time.Hour,
+		}, false},
+		{"ok backup network", []string{"backup", "network"}, &oidcSSHPermissions{
+			CriticalOptions: map[string]string{"force-command": "/usr/bin/backup", "source-address": "10.0.0.0/8"},
+		}, false},
+		{"fail backup restore", []string{"backup", "restore"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := getSSHPermissions(mappings, tt.groups)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("getSSHPermissions() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.Equals(t, tt.want, got)
+		})
+	}
+}
+
+func Test_oidcSSHPermissions_claimer(t *testing.T) {
+	c, err := NewClaimer(nil, globalProvisionerClaims)
+	assert.FatalError(t, err)
+
+	got, err := (&oidcSSHPermissions{}).claimer(c)
+	assert.FatalError(t, err)
+	assert.Equals(t, c, got)
+
+	got, err = (&oidcSSHPermissions{MaxDuration: 48 * time.Hour}).claimer(c)
+	assert.FatalError(t, err)
+	assert.Equals(t, c, got)
+
+	got, err = (&oidcSSHPermissions{MaxDuration: time.Hour}).claimer(c)
+	assert.FatalError(t, err)
+	assert.Equals(t, time.Hour, got.MaxUserSSHCertDuration())
+	assert.Equals(t, time.Hour, got.DefaultUserSSHCertDuration())
+	assert.Equals(t, c.MinUserSSHCertDuration(), got.MinUserSSHCertDuration())
+	assert.Equals(t, c.MaxHostSSHCertDuration(), got.MaxHostSSHCertDuration())
+
+	got, err = (&oidcSSHPermissions{MaxDuration: time.Minute}).claimer(c)
+	assert.FatalError(t, err)
+	assert.Equals(t, time.Minute, got.MinUserSSHCertDuration())
+	assert.Equals(t, time.Minute, got.MaxUserSSHCertDuration())
+}
+
+func Test_groupsFromClaim(t *testing.T) {
+	claims := map[string]interface{}{
+		"groups": []interface{}{"dba", "dev", float64(1)},
+		"role":   "admin",
+		"realm_access": map[string]interface{}{
+			"roles": []interface{}{"ops"},
+		},
+		"number": float64(1),
+	}
+	assert.Equals(t, []string{"dba", "dev"}, groupsFromClaim(claims, "groups"))
+	assert.Equals(t, []string{"admin"}, groupsFromClaim(claims, "role"))
+	assert.Equals(t, []string{"ops"}, groupsFromClaim(claims, "realm_access.roles"))
+	assert.Equals(t, []string(nil), groupsFromClaim(claims, "number"))
+	assert.Equals(t, []string(nil), groupsFromClaim(claims, "missing"))
+}
+
+func TestOIDC_AuthorizeSSHSign_groupMappings(t *testing.T) {
+	tm, fn := mockNow()
+	defer fn()
+
+	srv := generateJWKServer(2)
+	defer srv.Close()
+
+	var keys jose.JSONWebKeySet
+	assert.FatalError(t, getAndDecode(srv.URL+"/private", &keys))
+
+	p1, err := generateOIDC()
+	assert.FatalError(t, err)
+	p1.ConfigurationEndpoint = srv.URL + "/.well-known/openid-configuration"
+	p1.GroupsClaim = "realm_access.roles"
+	p1.SSHGroupMappings = []*OIDCGroupMapping{
+		{Group: "dba", Principals: []string{"root@db-*"}, MaxDuration: &Duration{Duration: time.Hour}},
+		{Group: "backup", CriticalOptions: map[string]string{"force-command": "/usr/bin/backup"}, Extensions: map[string]string{"permit-pty": ""}},
+		{Group: "restore", CriticalOptions: map[string]string{"force-command": "/usr/bin/restore"}},
+	}
+	assert.FatalError(t, p1.Init(Config{Claims: globalProvisionerClaims}))
+
+	token := func(groups ...string) string {
+		roles := make([]interface{}, len(groups))
+		for i, g := range groups {
+			roles[i] = g
+		}
+		tok, err := generateWorkloadToken("the-issuer", p1.ClientID, map[string]interface{}{
+			"sub":          "subject",
+			"email":        "name@smallstep.com",
+			"realm_access": map[string]interface{}{"roles": roles},
+		}, time.Now(), &keys.Keys[0])
+		assert.FatalError(t, err)
+		return tok
+	}
+
+	key, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+	signer, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+	pub := key.Public().Key
+
+	defaultExtensions := map[string]string{
+		"permit-X11-forwarding":   "",
+		"permit-agent-forwarding": "",
+		"permit-port-forwarding":  "",
+		"permit-pty":              "",
+		"permit-user-rc":          "",
+	}
+	userDuration := p1.claimer.DefaultUserSSHCertDuration()
+
+	tests := []struct {
+		name                string
+		token               string
+		sshOpts             SignSSHOptions
+		wantPrincipals      []string
+		wantCriticalOptions map[string]string
+		wantExtensions      map[string]string
+		wantValidBefore     time.Time
+		wantErr             bool
+		wantSignErr         bool
+	}{
+		{"ok", token(), SignSSHOptions{}, []string{"name", "name@smallstep.com"}, nil, defaultExtensions, tm.Add(userDuration), false, false},
+		{"ok dba", token("dba"), SignSSHOptions{}, []string{"name", "name@smallstep.com", "root@db-*"}, nil, defaultExtensions, tm.Add(time.Hour), false, false},
+		{"ok dba principal", token("dba"), SignSSHOptions{Principals: []string{"root@db-*"}}, []string{"name", "name@smallstep.com", "root@db-*"}, nil, defaultExtensions, tm.Add(time.Hour), false, false},
+		{"ok backup", token("backup"), SignSSHOptions{}, []string{"name", "name@smallstep.com"}, map[string]string{"force-command": "/usr/bin/backup"}, map[string]string{"permit-pty": ""}, tm.Add(userDuration), false, false},
+		{"fail principal", token("backup"), SignSSHOptions{Principals: []string{"root@db-*"}}, nil, nil, nil, time.Time{}, false, true},
+		{"fail duration", token("dba"), SignSSHOptions{ValidBefore: NewTimeDuration(tm.Add(2 * time.Hour))}, nil, nil, nil, time.Time{}, false, true},
+		{"fail backup restore", token("backup", "restore"), SignSSHOptions{}, nil, nil, nil, time.Time{}, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p1.AuthorizeSSHSign(context.Background(), tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("OIDC.AuthorizeSSHSign() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, sc.StatusCode(), http.StatusUnauthorized)
+				return
+			}
+			cert, err := signSSHCertificate(pub, tt.sshOpts, got, signer.Key.(crypto.Signer))
+			if (err != nil) != tt.wantSignErr {
+				t.Errorf("SignSSH error = %v, wantSignErr %v", err, tt.wantSignErr)
+				return
+			}
+			if err == nil {
+				assert.Equals(t, tt.wantPrincipals, cert.ValidPrincipals)
+				assert.Equals(t, tt.wantCriticalOptions, cert.CriticalOptions)
+				assert.Equals(t, tt.wantExtensions, cert.Extensions)
+				assert.Equals(t, uint64(tt.wantValidBefore.Unix()), cert.ValidBefore)
+			}
+		})
+	}
+
+	// The groups claim is used to validate the allowed groups.
+	p1.Groups = []string{"dba"}
+	_, err = p1.AuthorizeSSHSign(context.Background(), token("backup"))
+	assert.Error(t, err)
+	_, err = p1.AuthorizeSSHSign(context.Background(), token("dba"))
+	assert.FatalError(t, err)
+}
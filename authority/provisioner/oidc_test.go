@@ -324,9 +324,9 @@ func TestOIDC_AuthorizeSign(t *testing.T) {
 			} else {
 				if assert.NotNil(t, got) {
 					if tt.name == "admin" {
-						assert.Len(t, 5, got)
+						assert.Len(t, 7, got)
 					} else {
-						assert.Len(t, 5, got)
+						assert.Len(t, 7, got)
 					}
 					for _, o := range got {
 						switch v := o.(type) {
@@ -342,6 +342,10 @@ func TestOIDC_AuthorizeSign(t *testing.T) {
 						case *validityValidator:
 							assert.Equals(t, v.min, tt.prov.claimer.MinTLSCertDuration())
 							assert.Equals(t, v.max, tt.prov.claimer.MaxTLSCertDuration())
+						case *x509Webhooks:
+							assert.Equals(t, v.controller, tt.prov.webhooks)
+						case *x509NamePolicyValidator:
+							assert.Equals(t, v.policy, tt.prov.namePolicy)
 						case emailOnlyIdentity:
 							assert.Equals(t, string(v), "name@smallstep.com")
 						default:
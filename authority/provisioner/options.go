@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/policy"
 	"go.step.sm/crypto/jose"
 	"go.step.sm/crypto/x509util"
 )
@@ -26,6 +27,14 @@ func (fn certificateOptionsFunc) Options(so SignOptions) []x509util.Option {
 type Options struct {
 	X509 *X509Options `json:"x509,omitempty"`
 	SSH  *SSHOptions  `json:"ssh,omitempty"`
+
+	// Policy contains the name-constraint policy that restricts the names
+	// that can be included in the certificates signed by the provisioner.
+	Policy *policy.Options `json:"policy,omitempty"`
+
+	// Webhooks are the HTTP endpoints called to authorize and enrich the
+	// certificate requests before signing them.
+	Webhooks []*Webhook `json:"webhooks,omitempty"`
 }
 
 // GetX509Options returns the X.509 options.
@@ -44,6 +53,24 @@ func (o *Options) GetSSHOptions() *SSHOptions {
 	return o.SSH
 }
 
+// GetPolicyOptions returns the name-constraint policy options.
+func (o *Options) GetPolicyOptions() *policy.Options {
+	if o == nil {
+		return nil
+	}
+	return o.Policy
+}
+
+// newNamePolicyEngine compiles the name-constraint policy defined in the
+// provisioner options.
+func newNamePolicyEngine(o *Options) (*policy.Engine, error) {
+	engine, err := policy.New(o.GetPolicyOptions())
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing policy")
+	}
+	return engine, nil
+}
+
 // X509Options contains specific options for X.509 certificates.
 type X509Options struct {
 	// Template contains a X.509 certificate template. It can be a JSON template
@@ -7,6 +7,7 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/smallstep/certificates/authority/policy"
 	"go.step.sm/crypto/pemutil"
 	"go.step.sm/crypto/x509util"
 )
@@ -68,6 +69,58 @@ func TestOptions_GetSSHOptions(t *testing.T) {
 	}
 }
 
+func TestOptions_GetPolicyOptions(t *testing.T) {
+	type fields struct {
+		o *Options
+	}
+	tests := []struct {
+		name   string
+		fields fields
+		want   *policy.Options
+	}{
+		{"ok", fields{&Options{Policy: &policy.Options{SSH: &policy.SSHOptions{}}}}, &policy.Options{SSH: &policy.SSHOptions{}}},
+		{"nil", fields{&Options{}}, nil},
+		{"nilOptions", fields{nil}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.fields.o.GetPolicyOptions(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Options.GetPolicyOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_newNamePolicyEngine(t *testing.T) {
+	tests := []struct {
+		name    string
+		o       *Options
+		wantNil bool
+		wantErr bool
+	}{
+		{"ok", &Options{Policy: &policy.Options{X509: &policy.X509Options{
+			Allow: &policy.X509NameOptions{DNSDomains: []string{"*.example.com"}},
+		}}}, false, false},
+		{"ok empty", &Options{Policy: &policy.Options{}}, true, false},
+		{"ok nil", nil, true, false},
+		{"fail", &Options{Policy: &policy.Options{X509: &policy.X509Options{
+			Deny: &policy.X509NameOptions{IPRanges: []string{"10.0.0.0/33"}},
+		}}}, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newNamePolicyEngine(tt.o)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("newNamePolicyEngine() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if (got == nil) != tt.wantNil {
+				t.Errorf("newNamePolicyEngine() = %v, wantNil %v", got, tt.wantNil)
+			}
+		})
+	}
+}
+
 func TestProvisionerX509Options_HasTemplate(t *testing.T) {
 	type fields struct {
 		Template     string
@@ -5,6 +5,7 @@ import (
 	"crypto/x509"
 	"encoding/json"
 	stderrors "errors"
+	"net/http"
 	"net/url"
 	"regexp"
 	"strings"
@@ -156,6 +157,10 @@ const (
 	TypeSSHPOP Type = 9
 	// TypeSCEP is used to indicate the SCEP provisioners
 	TypeSCEP Type = 10
+	// TypeWorkload is used to indicate the Workload provisioners.
+	TypeWorkload Type = 11
+	// TypeTPM is used to indicate the TPM provisioners.
+	TypeTPM Type = 12
 )
 
 // String returns the string representation of the type.
@@ -181,6 +186,10 @@ func (t Type) String() string {
 		return "SSHPOP"
 	case TypeSCEP:
 		return "SCEP"
+	case TypeWorkload:
+		return "Workload"
+	case TypeTPM:
+		return "TPM"
 	default:
 		return ""
 	}
@@ -206,6 +215,8 @@ type Config struct {
 	// GetIdentityFunc is a function that returns an identity that will be
 	// used by the provisioner to populate certificate attributes.
 	GetIdentityFunc GetIdentityFunc
+	// WebhookClient is the HTTP client used to call the provisioner webhooks.
+	WebhookClient *http.Client
 }
 
 type provisioner struct {
@@ -251,6 +262,10 @@ func (l *List) UnmarshalJSON(data []byte) error {
 			p = &SSHPOP{}
 		case "scep":
 			p = &SCEP{}
+		case "workload":
+			p = &Workload{}
+		case "tpm":
+			p = &TPM{}
 		default:
 			// Skip unsupported provisioners. A client using this method may be
 			// compiled with a version of smallstep/certificates that does not
@@ -5,6 +5,8 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/policy"
+	"go.step.sm/crypto/x509util"
 )
 
 // SCEP is the SCEP provisioner type, an entity that can authorize the
@@ -23,6 +25,8 @@ type SCEP struct {
 	Options                *Options `json:"options,omitempty"`
 	Claims                 *Claims  `json:"claims,omitempty"`
 	claimer                *Claimer
+	namePolicy             *policy.Engine
+	webhooks               *webhookController
 
 	secretChallengePassword string
 }
@@ -86,6 +90,12 @@ func (s *SCEP) Init(config Config) (err error) {
 	if s.claimer, err = NewClaimer(s.Claims, config.Claims); err != nil {
 		return err
 	}
+	if s.namePolicy, err = newNamePolicyEngine(s.Options); err != nil {
+		return err
+	}
+	if s.webhooks, err = newWebhookController(s.Name, s.Options, config.WebhookClient); err != nil {
+		return err
+	}
 
 	// Mask the actual challenge value, so it won't be marshaled
 	s.secretChallengePassword = s.ChallengePassword
@@ -117,9 +127,17 @@ func (s *SCEP) AuthorizeSign(ctx context.Context, token string) ([]SignOption, e
 		// validators
 		newPublicKeyMinimumLengthValidator(s.MinimumPublicKeyLength),
 		newValidityValidator(s.claimer.MinTLSCertDuration(), s.claimer.MaxTLSCertDuration()),
+		newX509NamePolicyValidator(s.namePolicy),
 	}, nil
 }
 
+// WebhookOption returns the sign option that calls the webhooks of the
+// provisioner with the certificate request. The responses are stored in the
+// given template data, so it must be the data of the template options.
+func (s *SCEP) WebhookOption(data x509util.TemplateData) SignOption {
+	return s.webhooks.x509Option(data)
+}
+
 // GetChallengePassword returns the challenge password
 func (s *SCEP) GetChallengePassword() string {
 	return s.secretChallengePassword
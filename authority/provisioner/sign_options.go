@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/policy"
 	"go.step.sm/crypto/x509util"
 )
 
@@ -401,6 +402,23 @@ func (v *validityValidator) Valid(cert *x509.Certificate, o SignOptions) error {
 	return nil
 }
 
+// x509NamePolicyValidator validates that the names in the certificate are
+// allowed by the name-constraint policy of the provisioner.
+type x509NamePolicyValidator struct {
+	policy *policy.Engine
+}
+
+// newX509NamePolicyValidator return a new name policy validator.
+func newX509NamePolicyValidator(engine *policy.Engine) *x509NamePolicyValidator {
+	return &x509NamePolicyValidator{policy: engine}
+}
+
+// Valid validates that the names in the certificate, after applying the
+// templates, are allowed by the policy.
+func (v *x509NamePolicyValidator) Valid(cert *x509.Certificate, o SignOptions) error {
+	return v.policy.IsX509CertificateAllowed(cert)
+}
+
 var (
 	stepOIDRoot        = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 37476, 9000, 64}
 	stepOIDProvisioner = append(asn1.ObjectIdentifier(nil), append(stepOIDRoot, 1)...)
@@ -12,6 +12,7 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/policy"
 	"go.step.sm/crypto/pemutil"
 )
 
@@ -453,6 +454,35 @@ func Test_validityValidator_Valid(t *testing.T) {
 	}
 }
 
+func Test_x509NamePolicyValidator_Valid(t *testing.T) {
+	engine, err := policy.New(&policy.Options{
+		X509: &policy.X509Options{
+			Allow: &policy.X509NameOptions{DNSDomains: []string{"*.example.com"}},
+		},
+	})
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name    string
+		policy  *policy.Engine
+		cert    *x509.Certificate
+		wantErr bool
+	}{
+		{"ok", engine, &x509.Certificate{DNSNames: []string{"foo.example.com"}}, false},
+		{"ok nil policy", nil, &x509.Certificate{DNSNames: []string{"foo.example.org"}}, false},
+		{"fail", engine, &x509.Certificate{DNSNames: []string{"foo.example.com", "foo.example.org"}}, true},
+		{"fail common name", engine, &x509.Certificate{Subject: pkix.Name{CommonName: "foo.example.org"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := newX509NamePolicyValidator(tt.policy)
+			if err := v.Valid(tt.cert, SignOptions{}); (err != nil) != tt.wantErr {
+				t.Errorf("x509NamePolicyValidator.Valid() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func Test_forceCN_Option(t *testing.T) {
 	type test struct {
 		so    SignOptions
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/policy"
 	"go.step.sm/crypto/keyutil"
 	"golang.org/x/crypto/ssh"
 )
@@ -406,6 +407,23 @@ func (v *sshCertDefaultValidator) Valid(cert *ssh.Certificate, o SignSSHOptions)
 	}
 }
 
+// sshNamePolicyValidator validates that the principals in the certificate
+// are allowed by the name-constraint policy of the provisioner.
+type sshNamePolicyValidator struct {
+	policy *policy.Engine
+}
+
+// newSSHNamePolicyValidator return a new SSH name policy validator.
+func newSSHNamePolicyValidator(engine *policy.Engine) *sshNamePolicyValidator {
+	return &sshNamePolicyValidator{policy: engine}
+}
+
+// Valid validates that the principals in the certificate, after applying the
+// templates, are allowed by the policy.
+func (v *sshNamePolicyValidator) Valid(cert *ssh.Certificate, o SignSSHOptions) error {
+	return v.policy.IsSSHCertificateAllowed(cert)
+}
+
 // sshDefaultPublicKeyValidator implements a validator for the certificate key.
 type sshDefaultPublicKeyValidator struct{}
 
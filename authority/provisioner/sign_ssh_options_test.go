@@ -7,6 +7,7 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/policy"
 	"go.step.sm/crypto/keyutil"
 	"golang.org/x/crypto/ssh"
 )
@@ -682,6 +683,43 @@ func Test_sshCertDefaultValidator_Valid(t *testing.T) {
 	}
 }
 
+func Test_sshNamePolicyValidator_Valid(t *testing.T) {
+	engine, err := policy.New(&policy.Options{
+		SSH: &policy.SSHOptions{
+			Allow: &policy.SSHNameOptions{
+				DNSDomains: []string{"*.internal"},
+				Principals: []string{"*@example.com"},
+			},
+			Deny: &policy.SSHNameOptions{
+				Principals: []string{"root@example.com"},
+			},
+		},
+	})
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name    string
+		policy  *policy.Engine
+		cert    *ssh.Certificate
+		wantErr bool
+	}{
+		{"ok user", engine, &ssh.Certificate{CertType: ssh.UserCert, ValidPrincipals: []string{"jane@example.com"}}, false},
+		{"ok host", engine, &ssh.Certificate{CertType: ssh.HostCert, ValidPrincipals: []string{"foo.internal"}}, false},
+		{"ok nil policy", nil, &ssh.Certificate{CertType: ssh.UserCert, ValidPrincipals: []string{"root"}}, false},
+		{"fail user", engine, &ssh.Certificate{CertType: ssh.UserCert, ValidPrincipals: []string{"jane@example.com", "jane"}}, true},
+		{"fail user denied", engine, &ssh.Certificate{CertType: ssh.UserCert, ValidPrincipals: []string{"root@example.com"}}, true},
+		{"fail host", engine, &ssh.Certificate{CertType: ssh.HostCert, ValidPrincipals: []string{"foo.example.com"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := newSSHNamePolicyValidator(tt.policy)
+			if err := v.Valid(tt.cert, SignSSHOptions{}); (err != nil) != tt.wantErr {
+				t.Errorf("sshNamePolicyValidator.Valid() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func Test_sshCertValidityValidator(t *testing.T) {
 	p, err := generateX5C(nil)
 	assert.FatalError(t, err)
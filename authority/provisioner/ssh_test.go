@@ -50,6 +50,7 @@ func signSSHCertificate(key crypto.PublicKey, opts SignSSHOptions, signOpts []Si
 	var mods []SSHCertModifier
 	var certOptions []sshutil.Option
 	var validators []SSHCertValidator
+	var webhooks []SSHCertificateWebhook
 
 	for _, op := range signOpts {
 		switch o := op.(type) {
@@ -67,11 +68,20 @@ func signSSHCertificate(key crypto.PublicKey, opts SignSSHOptions, signOpts []Si
 			if err := o.Valid(opts); err != nil {
 				return nil, err
 			}
+		// call webhooks before creating the certificate
+		case SSHCertificateWebhook:
+			webhooks = append(webhooks, o)
 		default:
 			return nil, fmt.Errorf("signSSH: invalid extra option type %T", o)
 		}
 	}
 
+	for _, w := range webhooks {
+		if err := w.CallSSHWebhooks(pub, opts); err != nil {
+			return nil, errs.Wrap(http.StatusForbidden, err, "authority.SignSSH")
+		}
+	}
+
 	// Simulated certificate request with request options.
 	cr := sshutil.CertificateRequest{
 		Type:       opts.CertType,
@@ -0,0 +1,724 @@
+package provisioner
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/policy"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+	"go.step.sm/crypto/jose"
+	"go.step.sm/crypto/sshutil"
+	"go.step.sm/crypto/x509util"
+	"golang.org/x/crypto/ssh"
+)
+
+// tpmChallengeDuration is the time a client has to answer a credential
+// activation challenge.
+const tpmChallengeDuration = 5 * time.Minute
+
+// tpmMaxPCR is the highest PCR index in a PC client TPM.
+const tpmMaxPCR = 23
+
+// tpmChallengeKeySize is the minimum size of the key used to sign the
+// challenges.
+const tpmChallengeKeySize = 32
+
+// TPMTemplateKey is the key used to store the TPM data in the certificate
+// templates.
+const TPMTemplateKey = "TPM"
+
+// TPMTemplateData is the data of the attested TPM available in the
+// certificate templates, e.g. "{{ .TPM.EKHash }}".
+type TPMTemplateData struct {
+	// EKHash is the hex encoded SHA-256 of the PKIX endorsement key.
+	EKHash string
+	// AKName is the hex encoded TPM name of the attestation key.
+	AKName string
+	// PCRs are the hex encoded values of the quoted SHA-256 PCRs.
+	PCRs map[int]string
+}
+
+// TPMChallengeRequest is the request used to create a credential activation
+// challenge. It contains the endorsement key of the TPM, as a certificate
+// chain or as a public key, and the TPMT_PUBLIC of the attestation key.
+type TPMChallengeRequest struct {
+	EKCerts [][]byte `json:"ekCerts,omitempty"`
+	EKPub   []byte   `json:"ekPub,omitempty"`
+	AKPub   []byte   `json:"akPub"`
+}
+
+// TPMChallenge is a credential activation challenge. The credential and the
+// secret are the TPM2B_ID_OBJECT and the TPM2B_ENCRYPTED_SECRET used in
+// TPM2_ActivateCredential to recover the secret that signs the token.
+type TPMChallenge struct {
+	Credential []byte    `json:"credential"`
+	Secret     []byte    `json:"secret"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// tpmAttestation is the attestation in the tpm claim of a token.
+type tpmAttestation struct {
+	EKCerts             [][]byte          `json:"ekCerts,omitempty"`
+	EKPub               []byte            `json:"ekPub,omitempty"`
+	AKPub               []byte            `json:"akPub"`
+	ExpiresAt           int64             `json:"expiresAt"`
+	KeyPub              []byte            `json:"keyPub"`
+	KeyCertify          []byte            `json:"keyCertify"`
+	KeyCertifySignature []byte            `json:"keyCertifySignature"`
+	Quote               []byte            `json:"quote,omitempty"`
+	QuoteSignature      []byte            `json:"quoteSignature,omitempty"`
+	PCRs                map[string][]byte `json:"pcrs,omitempty"`
+}
+
+// tpmPayload extends jwt.Claims with the TPM attestation.
+type tpmPayload struct {
+	jose.Claims
+	SANs   []string        `json:"sans,omitempty"`
+	TPM    *tpmAttestation `json:"tpm"`
+	ekHash string
+	akName []byte
+	key    crypto.PublicKey
+	pcrs   map[int][]byte
+}
+
+// templateData returns the TPM data used in the certificate templates.
+func (p *tpmPayload) templateData() TPMTemplateData {
+	pcrs := make(map[int]string, len(p.pcrs))
+	for i, v := range p.pcrs {
+		pcrs[i] = hex.EncodeToString(v)
+	}
+	return TPMTemplateData{
+		EKHash: p.ekHash,
+		AKName: hex.EncodeToString(p.akName),
+		PCRs:   pcrs,
+	}
+}
+
+// TPM represents a provisioner that grants certificates to machines that
+// prove that the certificate key is resident in a TPM 2.0.
+//
+// The TPM is identified by its endorsement key (EK), trusted if its
+// certificate chains to one of the EK roots or if its hash is in the list of
+// EK keys. The client requests a challenge with the EK and an attestation key
+// (AK), the challenge can only be solved by activating the credential in the
+// same TPM. The secret of the challenge signs the token, which contains a
+// certification of the key of the certificate by the AK, and optionally a
+// quote of the PCRs.
+//
+// The secrets of the challenges are derived from the challenge key, it is the
+// configured one or a random key stored in the database the first time the
+// provisioner is initialized.
+type TPM struct {
+	*base
+	ID                string           `json:"-"`
+	Type              string           `json:"type"`
+	Name              string           `json:"name"`
+	EKRoots           []byte           `json:"ekRoots,omitempty"`
+	EKKeys            []string         `json:"ekKeys,omitempty"`
+	PCRs              map[int][]string `json:"pcrs,omitempty"`
+	ChallengeKey      string           `json:"challengeKey,omitempty"`
+	DisableCustomSANs bool             `json:"disableCustomSANs"`
+	Claims            *Claims          `json:"claims,omitempty"`
+	Options           *Options         `json:"options,omitempty"`
+	claimer           *Claimer
+	namePolicy        *policy.Engine
+	webhooks          *webhookController
+	audiences         Audiences
+	ekRootPool        *x509.CertPool
+	ekKeys            map[string]bool
+	pcrs              map[int][][]byte
+	challengeKey      []byte
+}
+
+// GetID returns the provisioner unique identifier.
+func (p *TPM) GetID() string {
+	if p.ID != "" {
+		return p.ID
+	}
+	return p.GetIDForToken()
+}
+
+// GetIDForToken returns an identifier that will be used to load the provisioner
+// from a token.
+func (p *TPM) GetIDForToken() string {
+	return "tpm/" + p.Name
+}
+
+// GetTokenID returns the identifier of the token.
+func (p *TPM) GetTokenID(ott string) (string, error) {
+	token, err := jose.ParseSigned(ott)
+	if err != nil {
+		return "", errors.Wrap(err, "error parsing token")
+	}
+	var claims jose.Claims
+	if err := token.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		return "", errors.Wrap(err, "error verifying claims")
+	}
+	return claims.ID, nil
+}
+
+// GetName returns the name of the provisioner.
+func (p *TPM) GetName() string {
+	return p.Name
+}
+
+// GetType returns the type of provisioner.
+func (p *TPM) GetType() Type {
+	return TypeTPM
+}
+
+// GetEncryptedKey is not available in a TPM provisioner.
+func (p *TPM) GetEncryptedKey() (kid string, key string, ok bool) {
+	return "", "", false
+}
+
+// Init validates and initializes the TPM provisioner.
+func (p *TPM) Init(config Config) (err error) {
+	switch {
+	case p.Type == "":
+		return errors.New("provisioner type cannot be empty")
+	case p.Name == "":
+		return errors.New("provisioner name cannot be empty")
+	case len(p.EKRoots) == 0 && len(p.EKKeys) == 0:
+		return errors.New("provisioner ekRoots or ekKeys cannot be empty")
+	}
+
+	if len(p.EKRoots) > 0 {
+		p.ekRootPool = x509.NewCertPool()
+		var (
+			block *pem.Block
+			rest  = p.EKRoots
+		)
+		for rest != nil {
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return errors.Wrap(err, "error parsing ekRoots")
+			}
+			p.ekRootPool.AddCert(cert)
+		}
+		if len(p.ekRootPool.Subjects()) == 0 {
+			return errors.Errorf("no x509 certificates found in ekRoots attribute for provisioner '%s'", p.GetName())
+		}
+	}
+
+	p.ekKeys = make(map[string]bool, len(p.EKKeys))
+	for _, s := range p.EKKeys {
+		if b, err := hex.DecodeString(s); err != nil || len(b) != sha256.Size {
+			return errors.Errorf("error parsing ekKeys: %s is not a valid SHA-256 hash", s)
+		}
+		p.ekKeys[strings.ToLower(s)] = true
+	}
+
+	p.pcrs = make(map[int][][]byte, len(p.PCRs))
+	for i, values := range p.PCRs {
+		if i < 0 || i > tpmMaxPCR {
+			return errors.Errorf("error parsing pcrs: %d is not a valid PCR index", i)
+		}
+		if len(values) == 0 {
+			return errors.Errorf("error parsing pcrs: PCR %d values cannot be empty", i)
+		}
+		for _, s := range values {
+			b, err := hex.DecodeString(s)
+			if err != nil || len(b) != sha256.Size {
+				return errors.Errorf("error parsing pcrs: PCR %d value %s is not a valid SHA-256 digest", i, s)
+			}
+			p.pcrs[i] = append(p.pcrs[i], b)
+		}
+	}
+
+	// The challenge key signs the challenges, it must be the same in all the
+	// instances of the CA and after a restart.
+	if p.challengeKey, err = p.initChallengeKey(config.DB); err != nil {
+		return err
+	}
+
+	// Update claims with global ones
+	if p.claimer, err = NewClaimer(p.Claims, config.Claims); err != nil {
+		return err
+	}
+	if p.namePolicy, err = newNamePolicyEngine(p.Options); err != nil {
+		return err
+	}
+	if p.webhooks, err = newWebhookController(p.Name, p.Options, config.WebhookClient); err != nil {
+		return err
+	}
+
+	p.audiences = config.Audiences.WithFragment(p.GetIDForToken())
+	return nil
+}
+
+// initChallengeKey returns the configured challenge key, or the one stored in
+// the database. A new key is stored if the database does not have one.
+func (p *TPM) initChallengeKey(authDB db.AuthDB) ([]byte, error) {
+	if p.ChallengeKey != "" {
+		key, err := base64.StdEncoding.DecodeString(p.ChallengeKey)
+		if err != nil || len(key) < tpmChallengeKeySize {
+			return nil, errors.Errorf("error parsing challengeKey: it must be a base64 encoded key of at least %d bytes", tpmChallengeKeySize)
+		}
+		return key, nil
+	}
+	secretDB, ok := authDB.(db.ProvisionerSecretDB)
+	if !ok {
+		return nil, errors.New("provisioner challengeKey cannot be empty if the database cannot store it")
+	}
+	key := make([]byte, tpmChallengeKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, errors.Wrap(err, "error generating challenge key")
+	}
+	key, err := secretDB.LoadOrStoreProvisionerSecret(p.GetIDForToken()+"/challengeKey", key)
+	if err != nil {
+		return nil, errors.Wrap(err, "error initializing challenge key")
+	}
+	if len(key) < tpmChallengeKeySize {
+		return nil, errors.New("error initializing challenge key: stored key is too short")
+	}
+	return key, nil
+}
+
+// CreateChallenge validates the endorsement and attestation keys and returns
+// a credential activation challenge for them.
+func (p *TPM) CreateChallenge(req *TPMChallengeRequest) (*TPMChallenge, error) {
+	ekPub, ekHash, err := p.verifyEK(req.EKCerts, req.EKPub)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "tpm.CreateChallenge")
+	}
+	ak, err := parseTPMAttestationKey(req.AKPub)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusBadRequest, err, "tpm.CreateChallenge")
+	}
+
+	expiresAt := time.Now().Add(tpmChallengeDuration).Truncate(time.Second)
+	secret := p.challengeSecret(ekHash, ak.Name, expiresAt.Unix())
+	credential, encSecret, err := tpmMakeCredential(ekPub, ak.Name, secret)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusBadRequest, err, "tpm.CreateChallenge")
+	}
+
+	return &TPMChallenge{
+		Credential: credential,
+		Secret:     encSecret,
+		ExpiresAt:  expiresAt,
+	}, nil
+}
+
+// challengeSecret returns the secret of a challenge. It is derived from the
+// challenge key, so it can be recomputed when the token is validated without
+// storing it.
+func (p *TPM) challengeSecret(ekHash string, akName []byte, expiresAt int64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(expiresAt))
+	mac := hmac.New(sha256.New, p.challengeKey)
+	mac.Write([]byte(ekHash))
+	mac.Write(akName)
+	mac.Write(b[:])
+	return mac.Sum(nil)
+}
+
+// verifyEK returns the endorsement key and its hash if the EK certificate
+// chains to one of the EK roots or the EK hash is in the list of EK keys.
+func (p *TPM) verifyEK(ekCerts [][]byte, ekPub []byte) (crypto.PublicKey, string, error) {
+	var (
+		pub     crypto.PublicKey
+		trusted bool
+	)
+	switch {
+	case len(ekCerts) > 0:
+		var certs []*x509.Certificate
+		for _, b := range ekCerts {
+			cert, err := x509.ParseCertificate(b)
+			if err != nil {
+				return nil, "", errors.Wrap(err, "error parsing ekCerts")
+			}
+			certs = append(certs, cert)
+		}
+		pub = certs[0].PublicKey
+		if p.ekRootPool != nil {
+			trusted = verifyEKCertificate(certs, p.ekRootPool) == nil
+		}
+	case len(ekPub) > 0:
+		var err error
+		if pub, err = x509.ParsePKIXPublicKey(ekPub); err != nil {
+			return nil, "", errors.Wrap(err, "error parsing ekPub")
+		}
+	default:
+		return nil, "", errors.New("ekCerts or ekPub are required")
+	}
+
+	ekHash, err := tpmEKHash(pub)
+	if err != nil {
+		return nil, "", err
+	}
+	if !trusted && !p.ekKeys[ekHash] {
+		return nil, "", errors.Errorf("endorsement key %s is not trusted", ekHash)
+	}
+	return pub, ekHash, nil
+}
+
+// tpmEKHash returns the hex encoded SHA-256 of the PKIX endorsement key.
+func tpmEKHash(pub crypto.PublicKey) (string, error) {
+	b, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", errors.Wrap(err, "error marshaling endorsement key")
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// oidSubjectAltName is the OID of the subject alternative name extension.
+var oidSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+
+// verifyEKCertificate verifies the EK certificate chain. EK certificates
+// usually have a critical subject alternative name with only a directory
+// name with the TPM manufacturer, model and version, Go considers it an
+// unhandled critical extension.
+func verifyEKCertificate(certs []*x509.Certificate, roots *x509.CertPool) error {
+	ek := certs[0]
+	var unhandled []asn1.ObjectIdentifier
+	for _, oid := range ek.UnhandledCriticalExtensions {
+		if !oid.Equal(oidSubjectAltName) {
+			unhandled = append(unhandled, oid)
+		}
+	}
+	ek.UnhandledCriticalExtensions = unhandled
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := ek.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
+
+// parseTPMAttestationKey parses the TPMT_PUBLIC of an attestation key, it
+// must be a restricted signing key that cannot leave the TPM.
+func parseTPMAttestationKey(b []byte) (*tpmPublic, error) {
+	ak, err := parseTPMPublic(b)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing akPub")
+	}
+	if !ak.hasAttributes(tpmObjectFixedTPM|tpmObjectFixedParent|tpmObjectSensitiveDataOrigin|tpmObjectRestricted|tpmObjectSign) ||
+		ak.hasAttributes(tpmObjectDecrypt) {
+		return nil, errors.New("akPub is not a restricted signing key generated in the TPM")
+	}
+	return ak, nil
+}
+
+// authorizeToken validates the TPM attestation and the token signed with the
+// secret of the challenge.
+func (p *TPM) authorizeToken(token string, audiences []string) (*tpmPayload, error) {
+	jwt, err := jose.ParseSigned(token)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "tpm.authorizeToken; error parsing tpm token")
+	}
+
+	var payload tpmPayload
+	if err := jwt.UnsafeClaimsWithoutVerification(&payload); err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "tpm.authorizeToken; error parsing tpm token claims")
+	}
+	att := payload.TPM
+	if att == nil {
+		return nil, errs.Unauthorized("tpm.authorizeToken; tpm token does not have a tpm claim")
+	}
+
+	// Validate the TPM keys and the token signature.
+	_, ekHash, err := p.verifyEK(att.EKCerts, att.EKPub)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "tpm.authorizeToken")
+	}
+	ak, err := parseTPMAttestationKey(att.AKPub)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "tpm.authorizeToken")
+	}
+	if time.Now().Unix() > att.ExpiresAt {
+		return nil, errs.Unauthorized("tpm.authorizeToken; tpm challenge has expired")
+	}
+	secret := p.challengeSecret(ekHash, ak.Name, att.ExpiresAt)
+	if err := jwt.Claims(secret, &payload); err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "tpm.authorizeToken; error verifying tpm token")
+	}
+
+	// According to "rfc7519 JSON Web Token" acceptable skew should be no more
+	// than a few minutes.
+	if err := payload.ValidateWithLeeway(jose.Expected{
+		Issuer: p.Name,
+		Time:   time.Now().UTC(),
+	}, time.Minute); err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "tpm.authorizeToken; invalid tpm token claims")
+	}
+	// validate audiences with the defaults
+	if !matchesAudience(payload.Audience, audiences) {
+		return nil, errs.Unauthorized("tpm.authorizeToken; invalid tpm token audience claim (aud); want %s, but got %s",
+			audiences, payload.Audience)
+	}
+	if payload.Subject == "" {
+		return nil, errs.Unauthorized("tpm.authorizeToken; tpm token subject cannot be empty")
+	}
+
+	// The attestations must include a hash of the secret to prove that they
+	// are fresh.
+	nonce := sha256.Sum256(secret)
+
+	// Validate that the key of the certificate is in the TPM.
+	key, err := parseTPMPublic(att.KeyPub)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "tpm.authorizeToken; error parsing keyPub")
+	}
+	if !key.hasAttributes(tpmObjectFixedTPM | tpmObjectFixedParent) {
+		return nil, errs.Unauthorized("tpm.authorizeToken; keyPub is not a key bound to the TPM")
+	}
+	if _, err := verifyTPMSignature(ak.Key, att.KeyCertify, att.KeyCertifySignature); err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "tpm.authorizeToken; invalid keyCertifySignature")
+	}
+	certify, err := parseTPMAttest(att.KeyCertify)
+	switch {
+	case err != nil:
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "tpm.authorizeToken; error parsing keyCertify")
+	case certify.Type != tpmSTAttestCertify:
+		return nil, errs.Unauthorized("tpm.authorizeToken; keyCertify is not a certify attestation")
+	case subtle.ConstantTimeCompare(certify.ExtraData, nonce[:]) != 1:
+		return nil, errs.Unauthorized("tpm.authorizeToken; keyCertify extraData does not match the challenge")
+	case !bytes.Equal(certify.Name, key.Name):
+		return nil, errs.Unauthorized("tpm.authorizeToken; keyCertify name does not match keyPub")
+	}
+
+	// Validate the PCRs, they are required if the provisioner has PCR values.
+	var pcrs map[int][]byte
+	if len(att.Quote) > 0 {
+		if pcrs, err = verifyTPMQuote(ak, att, nonce[:]); err != nil {
+			return nil, errs.Wrap(http.StatusUnauthorized, err, "tpm.authorizeToken")
+		}
+	}
+	for i, values := range p.pcrs {
+		if !containsBytes(values, pcrs[i]) {
+			return nil, errs.Unauthorized("tpm.authorizeToken; PCR %d does not have an allowed value", i)
+		}
+	}
+
+	payload.ekHash = ekHash
+	payload.akName = ak.Name
+	payload.key = key.Key
+	payload.pcrs = pcrs
+	return &payload, nil
+}
+
+// verifyTPMQuote verifies the quote of the SHA-256 PCRs in the attestation and
+// returns the quoted PCRs.
+func verifyTPMQuote(ak *tpmPublic, att *tpmAttestation, nonce []byte) (map[int][]byte, error) {
+	h, err := verifyTPMSignature(ak.Key, att.Quote, att.QuoteSignature)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid quoteSignature")
+	}
+	quote, err := parseTPMAttest(att.Quote)
+	switch {
+	case err != nil:
+		return nil, errors.Wrap(err, "error parsing quote")
+	case quote.Type != tpmSTAttestQuote:
+		return nil, errors.New("quote is not a quote attestation")
+	case subtle.ConstantTimeCompare(quote.ExtraData, nonce) != 1:
+		return nil, errors.New("quote extraData does not match the challenge")
+	case len(quote.PCRSelections) != 1 || quote.PCRSelections[0].Hash != tpmAlgSHA256:
+		return nil, errors.New("quote must only select SHA-256 PCRs")
+	}
+
+	// The digest is the hash of the selected PCRs in ascending order.
+	selected := quote.PCRSelections[0].PCRs
+	if len(selected) != len(att.PCRs) {
+		return nil, errors.New("quote PCRs do not match the pcrs claim")
+	}
+	sort.Ints(selected)
+	pcrs := make(map[int][]byte, len(selected))
+	hh := h.New()
+	for _, i := range selected {
+		v, ok := att.PCRs[strconv.Itoa(i)]
+		if !ok || len(v) != sha256.Size {
+			return nil, errors.New("quote PCRs do not match the pcrs claim")
+		}
+		pcrs[i] = v
+		hh.Write(v)
+	}
+	if !bytes.Equal(hh.Sum(nil), quote.PCRDigest) {
+		return nil, errors.New("quote digest does not match the pcrs claim")
+	}
+	return pcrs, nil
+}
+
+func containsBytes(list [][]byte, b []byte) bool {
+	for _, v := range list {
+		if bytes.Equal(v, b) {
+			return true
+		}
+	}
+	return false
+}
+
+// ekURI returns the URI used to identify the endorsement key.
+func (p *tpmPayload) ekURI() string {
+	return "urn:ek:sha256:" + p.ekHash
+}
+
+// AuthorizeSign validates the given token and returns the sign options that
+// will be used on certificate creation.
+func (p *TPM) AuthorizeSign(ctx context.Context, token string) ([]SignOption, error) {
+	claims, err := p.authorizeToken(token, p.audiences.Sign)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "tpm.AuthorizeSign")
+	}
+
+	// The names of the certificate are the EK identity if custom SANs are
+	// disabled, or the ones in the token otherwise.
+	subject, sans := claims.Subject, claims.SANs
+	if p.DisableCustomSANs {
+		subject, sans = claims.ekHash, []string{claims.ekURI()}
+	} else if len(sans) == 0 {
+		sans = []string{subject}
+	}
+
+	// Certificate templates
+	data := x509util.CreateTemplateData(subject, sans)
+	if v, err := unsafeParseSigned(token); err == nil {
+		data.SetToken(v)
+	}
+	data.Set(TPMTemplateKey, claims.templateData())
+
+	templateOptions, err := CustomTemplateOptions(p.Options, data, x509util.DefaultLeafTemplate)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "tpm.AuthorizeSign")
+	}
+
+	return []SignOption{
+		templateOptions,
+		p.webhooks.x509Option(data),
+		// modifiers / withOptions
+		newProvisionerExtensionOption(TypeTPM, p.Name, claims.ekHash),
+		profileDefaultDuration(p.claimer.DefaultTLSCertDuration()),
+		// validators
+		tpmKeyValidator{claims.key},
+		commonNameValidator(subject),
+		defaultSANsValidator(sans),
+		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
+		newX509NamePolicyValidator(p.namePolicy),
+	}, nil
+}
+
+// AuthorizeRenew returns an error if the renewal is disabled.
+func (p *TPM) AuthorizeRenew(ctx context.Context, cert *x509.Certificate) error {
+	if p.claimer.IsDisableRenewal() {
+		return errs.Unauthorized("tpm.AuthorizeRenew; renew is disabled for tpm provisioner '%s'", p.GetName())
+	}
+	return nil
+}
+
+// AuthorizeSSHSign validates the given token and returns the sign options for
+// an SSH host certificate.
+func (p *TPM) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption, error) {
+	if !p.claimer.IsSSHCAEnabled() {
+		return nil, errs.Unauthorized("tpm.AuthorizeSSHSign; sshCA is disabled for tpm provisioner '%s'", p.GetName())
+	}
+	claims, err := p.authorizeToken(token, p.audiences.SSHSign)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "tpm.AuthorizeSSHSign")
+	}
+
+	keyID, principals := claims.Subject, claims.SANs
+	if p.DisableCustomSANs {
+		keyID, principals = claims.ekHash, []string{claims.ekHash}
+	} else if len(principals) == 0 {
+		principals = []string{keyID}
+	}
+
+	// Certificate templates.
+	data := sshutil.CreateTemplateData(sshutil.HostCert, keyID, principals)
+	if v, err := unsafeParseSigned(token); err == nil {
+		data.SetToken(v)
+	}
+	data.Set(TPMTemplateKey, claims.templateData())
+
+	templateOptions, err := CustomSSHTemplateOptions(p.Options, data, sshutil.DefaultIIDTemplate)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "tpm.AuthorizeSSHSign")
+	}
+	signOptions := []SignOption{templateOptions, p.webhooks.sshOption(data)}
+
+	return append(signOptions,
+		// Only host certificates with the token principals are allowed.
+		sshCertOptionsValidator(SignSSHOptions{
+			CertType:   SSHHostCert,
+			Principals: principals,
+		}),
+		// Set the validity bounds if not set.
+		&sshDefaultDuration{p.claimer},
+		// Validate public key
+		&sshDefaultPublicKeyValidator{},
+		// Validate that the key is the one in the TPM.
+		sshTPMKeyValidator{claims.key},
+		// Validate the validity period.
+		&sshCertValidityValidator{p.claimer},
+		// Require and validate all the default fields in the SSH certificate.
+		&sshCertDefaultValidator{},
+		// Validate the principals with the name-constraint policy.
+		newSSHNamePolicyValidator(p.namePolicy),
+	), nil
+}
+
+// tpmKeyValidator validates that the key of the certificate is the key
+// certified by the TPM.
+type tpmKeyValidator struct {
+	key crypto.PublicKey
+}
+
+// Valid implements the CertificateRequestValidator interface.
+func (v tpmKeyValidator) Valid(req *x509.CertificateRequest) error {
+	want, err := x509.MarshalPKIXPublicKey(v.key)
+	if err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "error marshaling tpm key")
+	}
+	got, err := x509.MarshalPKIXPublicKey(req.PublicKey)
+	if err != nil || !bytes.Equal(want, got) {
+		return errs.Forbidden("certificate request public key does not match the tpm key")
+	}
+	return nil
+}
+
+// sshTPMKeyValidator validates that the key of the SSH certificate is the key
+// certified by the TPM.
+type sshTPMKeyValidator struct {
+	key crypto.PublicKey
+}
+
+// Valid implements the SSHCertValidator interface.
+func (v sshTPMKeyValidator) Valid(cert *ssh.Certificate, o SignSSHOptions) error {
+	want, err := ssh.NewPublicKey(v.key)
+	if err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "error marshaling tpm key")
+	}
+	if cert.Key == nil || !bytes.Equal(want.Marshal(), cert.Key.Marshal()) {
+		return errs.Forbidden("ssh certificate public key does not match the tpm key")
+	}
+	return nil
+}
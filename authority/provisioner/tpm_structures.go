@@ -0,0 +1,449 @@
+package provisioner
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha1" // used by TPM names and PCR banks
+	"crypto/sha256"
+	_ "crypto/sha512" // used by TPM names and PCR banks
+	"encoding/binary"
+	"hash"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+// TPM constants, see the TPM 2.0 specification, part 2.
+const (
+	tpmGeneratedValue  = 0xff544347
+	tpmSTAttestCertify = 0x8017
+	tpmSTAttestQuote   = 0x8018
+	tpmAlgRSA          = 0x0001
+	tpmAlgSHA1         = 0x0004
+	tpmAlgSHA256       = 0x000b
+	tpmAlgSHA384       = 0x000c
+	tpmAlgNull         = 0x0010
+	tpmAlgRSASSA       = 0x0014
+	tpmAlgRSAPSS       = 0x0016
+	tpmAlgECDSA        = 0x0018
+	tpmAlgECC          = 0x0023
+	tpmECCNistP256     = 0x0003
+	tpmECCNistP384     = 0x0004
+	tpmECCNistP521     = 0x0005
+
+	// TPMA_OBJECT attributes.
+	tpmObjectFixedTPM            = 1 << 1
+	tpmObjectFixedParent         = 1 << 4
+	tpmObjectSensitiveDataOrigin = 1 << 5
+	tpmObjectRestricted          = 1 << 16
+	tpmObjectDecrypt             = 1 << 17
+	tpmObjectSign                = 1 << 18
+
+	// tpmCredentialKeySize is the size of the symmetric key used in the
+	// credential activation, the TCG EK templates use AES-128.
+	tpmCredentialKeySize = 16
+)
+
+// tpmReader reads the big-endian TPM structures.
+type tpmReader struct {
+	b   []byte
+	err error
+}
+
+func (r *tpmReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > len(r.b) {
+		r.err = errors.New("tpm structure is too short")
+		return nil
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *tpmReader) uint8() uint8 {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *tpmReader) uint16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *tpmReader) uint32() uint32 {
+	if b := r.bytes(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+// tpm2b reads a sized buffer.
+func (r *tpmReader) tpm2b() []byte {
+	return r.bytes(int(r.uint16()))
+}
+
+// scheme reads an algorithm and its details, all the schemes used in the
+// public area have a hash algorithm or a key size as details.
+func (r *tpmReader) scheme() {
+	if alg := r.uint16(); alg != tpmAlgNull {
+		r.uint16()
+	}
+}
+
+// symmetric reads a TPMT_SYM_DEF_OBJECT, an algorithm with a key size and a
+// mode.
+func (r *tpmReader) symmetric() {
+	if alg := r.uint16(); alg != tpmAlgNull {
+		r.uint16()
+		r.uint16()
+	}
+}
+
+// tpm2bBytes encodes a sized buffer.
+func tpm2bBytes(b []byte) []byte {
+	buf := make([]byte, 2, 2+len(b))
+	binary.BigEndian.PutUint16(buf, uint16(len(b)))
+	return append(buf, b...)
+}
+
+func tpmHashFunc(alg uint16) (crypto.Hash, error) {
+	switch alg {
+	case tpmAlgSHA1:
+		return crypto.SHA1, nil
+	case tpmAlgSHA256:
+		return crypto.SHA256, nil
+	case tpmAlgSHA384:
+		return crypto.SHA384, nil
+	default:
+		return 0, errors.Errorf("tpm hash algorithm %#04x is not supported", alg)
+	}
+}
+
+// tpmPublic is a parsed TPMT_PUBLIC structure.
+type tpmPublic struct {
+	Type       uint16
+	NameAlg    uint16
+	Attributes uint32
+	Key        crypto.PublicKey
+	Name       []byte
+}
+
+// hasAttributes returns true if all the given attributes are set.
+func (p *tpmPublic) hasAttributes(attrs uint32) bool {
+	return p.Attributes&attrs == attrs
+}
+
+// parseTPMPublic parses a TPMT_PUBLIC structure and computes the TPM name of
+// the key.
+func parseTPMPublic(b []byte) (*tpmPublic, error) {
+	r := &tpmReader{b: b}
+	pub := &tpmPublic{
+		Type:       r.uint16(),
+		NameAlg:    r.uint16(),
+		Attributes: r.uint32(),
+	}
+	r.tpm2b() // authPolicy
+
+	switch pub.Type {
+	case tpmAlgRSA:
+		// TPMS_RSA_PARMS: symmetric, scheme, keyBits and exponent.
+		r.symmetric()
+		r.scheme()
+		r.uint16()
+		exponent := r.uint32()
+		if exponent == 0 {
+			exponent = 65537
+		}
+		modulus := r.tpm2b()
+		if r.err == nil {
+			pub.Key = &rsa.PublicKey{N: new(big.Int).SetBytes(modulus), E: int(exponent)}
+		}
+	case tpmAlgECC:
+		// TPMS_ECC_PARMS: symmetric, scheme, curveID and kdf.
+		r.symmetric()
+		r.scheme()
+		curveID := r.uint16()
+		r.scheme()
+		x, y := r.tpm2b(), r.tpm2b()
+		if r.err == nil {
+			var curve elliptic.Curve
+			switch curveID {
+			case tpmECCNistP256:
+				curve = elliptic.P256()
+			case tpmECCNistP384:
+				curve = elliptic.P384()
+			case tpmECCNistP521:
+				curve = elliptic.P521()
+			default:
+				return nil, errors.Errorf("tpm curve %#04x is not supported", curveID)
+			}
+			pub.Key = &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	default:
+		return nil, errors.Errorf("tpm key type %#04x is not supported", pub.Type)
+	}
+	if r.err != nil {
+		return nil, errors.Wrap(r.err, "error parsing tpm public area")
+	}
+
+	h, err := tpmHashFunc(pub.NameAlg)
+	if err != nil {
+		return nil, err
+	}
+	hh := h.New()
+	hh.Write(b)
+	pub.Name = make([]byte, 2, 2+hh.Size())
+	binary.BigEndian.PutUint16(pub.Name, pub.NameAlg)
+	pub.Name = hh.Sum(pub.Name)
+	return pub, nil
+}
+
+// tpmPCRSelection is a selection of PCRs in a bank.
+type tpmPCRSelection struct {
+	Hash uint16
+	PCRs []int
+}
+
+// tpmAttest is a parsed TPMS_ATTEST structure of a certify or a quote
+// operation.
+type tpmAttest struct {
+	Type      uint16
+	ExtraData []byte
+	// Certify attributes.
+	Name []byte
+	// Quote attributes.
+	PCRSelections []tpmPCRSelection
+	PCRDigest     []byte
+}
+
+// parseTPMAttest parses a TPMS_ATTEST structure generated by TPM2_Certify or
+// TPM2_Quote.
+func parseTPMAttest(b []byte) (*tpmAttest, error) {
+	r := &tpmReader{b: b}
+	magic := r.uint32()
+	att := &tpmAttest{
+		Type: r.uint16(),
+	}
+	r.tpm2b() // qualifiedSigner
+	att.ExtraData = r.tpm2b()
+	r.bytes(17) // clockInfo
+	r.bytes(8)  // firmwareVersion
+
+	switch att.Type {
+	case tpmSTAttestCertify:
+		att.Name = r.tpm2b()
+		r.tpm2b() // qualifiedName
+	case tpmSTAttestQuote:
+		n := r.uint32()
+		for i := uint32(0); i < n && r.err == nil; i++ {
+			sel := tpmPCRSelection{Hash: r.uint16()}
+			bitmap := r.bytes(int(r.uint8()))
+			for j, v := range bitmap {
+				for k := 0; k < 8; k++ {
+					if v&(1<<k) != 0 {
+						sel.PCRs = append(sel.PCRs, j*8+k)
+					}
+				}
+			}
+			att.PCRSelections = append(att.PCRSelections, sel)
+		}
+		att.PCRDigest = r.tpm2b()
+	default:
+		return nil, errors.Errorf("tpm attestation type %#04x is not supported", att.Type)
+	}
+
+	switch {
+	case r.err != nil:
+		return nil, errors.Wrap(r.err, "error parsing tpm attestation")
+	case magic != tpmGeneratedValue:
+		return nil, errors.New("tpm attestation magic is not valid")
+	default:
+		return att, nil
+	}
+}
+
+// verifyTPMSignature verifies the TPMT_SIGNATURE of the given data and
+// returns the hash algorithm used.
+func verifyTPMSignature(pub crypto.PublicKey, data, sig []byte) (crypto.Hash, error) {
+	r := &tpmReader{b: sig}
+	alg := r.uint16()
+	h, err := tpmHashFunc(r.uint16())
+	if err != nil {
+		return 0, err
+	}
+	hh := h.New()
+	hh.Write(data)
+	digest := hh.Sum(nil)
+
+	switch alg {
+	case tpmAlgRSASSA, tpmAlgRSAPSS:
+		key, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return 0, errors.New("tpm signature algorithm does not match the key type")
+		}
+		s := r.tpm2b()
+		if r.err != nil {
+			return 0, errors.Wrap(r.err, "error parsing tpm signature")
+		}
+		if alg == tpmAlgRSASSA {
+			err = rsa.VerifyPKCS1v15(key, h, digest, s)
+		} else {
+			err = rsa.VerifyPSS(key, h, digest, s, nil)
+		}
+		if err != nil {
+			return 0, errors.Wrap(err, "error verifying tpm signature")
+		}
+	case tpmAlgECDSA:
+		key, ok := pub.(*ecdsa.PublicKey)
+		if !ok {
+			return 0, errors.New("tpm signature algorithm does not match the key type")
+		}
+		rr, ss := r.tpm2b(), r.tpm2b()
+		if r.err != nil {
+			return 0, errors.Wrap(r.err, "error parsing tpm signature")
+		}
+		if !ecdsa.Verify(key, digest, new(big.Int).SetBytes(rr), new(big.Int).SetBytes(ss)) {
+			return 0, errors.New("error verifying tpm signature")
+		}
+	default:
+		return 0, errors.Errorf("tpm signature algorithm %#04x is not supported", alg)
+	}
+	return h, nil
+}
+
+// tpmMakeCredential implements the TPM2_MakeCredential operation. It protects
+// the secret so only the TPM with the given endorsement key can recover it
+// with TPM2_ActivateCredential, and only if the object with the given name is
+// loaded in it. It returns the TPM2B_ID_OBJECT and the
+// TPM2B_ENCRYPTED_SECRET. The endorsement key must use the default TCG EK
+// templates, with a SHA-256 name algorithm and an AES-128 symmetric key.
+//
+// See the TPM 2.0 specification, part 1, sections 24 and B.10.
+func tpmMakeCredential(ekPub crypto.PublicKey, name, secret []byte) ([]byte, []byte, error) {
+	var seed, encSecret []byte
+	switch pub := ekPub.(type) {
+	case *rsa.PublicKey:
+		seed = make([]byte, tpmCredentialKeySize)
+		if _, err := rand.Read(seed); err != nil {
+			return nil, nil, errors.Wrap(err, "error generating seed")
+		}
+		label := append([]byte("IDENTITY"), 0)
+		b, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, seed, label)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "error encrypting seed")
+		}
+		encSecret = tpm2bBytes(b)
+	case *ecdsa.PublicKey:
+		priv, err := ecdsa.GenerateKey(pub.Curve, rand.Reader)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "error generating ephemeral key")
+		}
+		seed, encSecret = tpmECCSeed(priv, pub)
+	default:
+		return nil, nil, errors.Errorf("endorsement key type %T is not supported", ekPub)
+	}
+
+	credential, err := tpmCredentialBlob(seed, name, secret)
+	if err != nil {
+		return nil, nil, err
+	}
+	return credential, encSecret, nil
+}
+
+// tpmECCSeed returns the seed agreed with ECDH between the ephemeral key and
+// the endorsement key, and the TPM2B_ENCRYPTED_SECRET with the ephemeral
+// point.
+func tpmECCSeed(priv *ecdsa.PrivateKey, ekPub *ecdsa.PublicKey) ([]byte, []byte) {
+	size := (ekPub.Curve.Params().BitSize + 7) / 8
+	z, _ := ekPub.Curve.ScalarMult(ekPub.X, ekPub.Y, priv.D.Bytes())
+	ephX, ephY := padBytes(priv.X.Bytes(), size), padBytes(priv.Y.Bytes(), size)
+	seed := tpmKDFe(crypto.SHA256, padBytes(z.Bytes(), size), "IDENTITY", ephX, padBytes(ekPub.X.Bytes(), size), sha256.Size*8)
+	return seed, tpm2bBytes(append(tpm2bBytes(ephX), tpm2bBytes(ephY)...))
+}
+
+// tpmCredentialBlob returns the TPM2B_ID_OBJECT with the secret protected
+// with the keys derived from the seed and the name of the object.
+func tpmCredentialBlob(seed, name, secret []byte) ([]byte, error) {
+	// Encrypt the credential, a TPM2B_DIGEST with the secret, using a key
+	// derived from the seed and the name of the object.
+	block, err := aes.NewCipher(tpmKDFa(crypto.SHA256, seed, "STORAGE", name, nil, tpmCredentialKeySize*8))
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating cipher")
+	}
+	credential := tpm2bBytes(secret)
+	encIdentity := make([]byte, len(credential))
+	cipher.NewCFBEncrypter(block, make([]byte, aes.BlockSize)).XORKeyStream(encIdentity, credential)
+
+	// Protect the integrity of the credential and bind it to the name.
+	mac := hmac.New(sha256.New, tpmKDFa(crypto.SHA256, seed, "INTEGRITY", nil, nil, sha256.Size*8))
+	mac.Write(encIdentity)
+	mac.Write(name)
+	idObject := append(tpm2bBytes(mac.Sum(nil)), encIdentity...)
+
+	return tpm2bBytes(idObject), nil
+}
+
+// tpmKDFa implements the KDFa key derivation function, see the TPM 2.0
+// specification, part 1, section 11.4.10.2.
+func tpmKDFa(h crypto.Hash, key []byte, label string, contextU, contextV []byte, bits int) []byte {
+	mac := hmac.New(h.New, key)
+	return tpmKDF(mac, bits, func() {
+		mac.Write([]byte(label))
+		mac.Write([]byte{0})
+		mac.Write(contextU)
+		mac.Write(contextV)
+		binary.Write(mac, binary.BigEndian, uint32(bits))
+	})
+}
+
+// tpmKDFe implements the KDFe key derivation function used with ECDH, see the
+// TPM 2.0 specification, part 1, section 11.4.10.3.
+func tpmKDFe(h crypto.Hash, z []byte, use string, partyUInfo, partyVInfo []byte, bits int) []byte {
+	hh := h.New()
+	return tpmKDF(hh, bits, func() {
+		hh.Write(z)
+		hh.Write([]byte(use))
+		hh.Write([]byte{0})
+		hh.Write(partyUInfo)
+		hh.Write(partyVInfo)
+	})
+}
+
+func tpmKDF(h hash.Hash, bits int, update func()) []byte {
+	n := (bits + 7) / 8
+	var out []byte
+	for counter := uint32(1); len(out) < n; counter++ {
+		h.Reset()
+		binary.Write(h, binary.BigEndian, counter)
+		update()
+		out = h.Sum(out)
+	}
+	out = out[:n]
+	if mask := uint8(bits % 8); mask > 0 {
+		out[0] &= (1 << mask) - 1
+	}
+	return out
+}
+
+// padBytes left pads the given bytes with zeros up to the given size.
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	out := make([]byte, size)
+	copy(out[size-len(b):], b)
+	return out
+}
@@ -0,0 +1,945 @@
+package provisioner
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+	"go.step.sm/crypto/jose"
+	"go.step.sm/crypto/x509util"
+	"golang.org/x/crypto/ssh"
+)
+
+// softTPM is a software implementation of the TPM 2.0 operations used by the
+// TPM provisioner: credential activation, certify and quote.
+type softTPM struct {
+	ek     crypto.Signer
+	ekCert *x509.Certificate
+	ak     *ecdsa.PrivateKey
+	akPub  []byte
+	key    *ecdsa.PrivateKey
+	keyPub []byte
+	pcrs   map[int][]byte
+}
+
+func newSoftTPM(t *testing.T, ek crypto.Signer) *softTPM {
+	t.Helper()
+	ak, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	pcrs := make(map[int][]byte)
+	for i := 0; i <= tpmMaxPCR; i++ {
+		sum := sha256.Sum256([]byte{byte(i)})
+		pcrs[i] = sum[:]
+	}
+	return &softTPM{
+		ek:     ek,
+		ak:     ak,
+		akPub:  encodeTPMPublicECC(&ak.PublicKey, tpmObjectFixedTPM|tpmObjectFixedParent|tpmObjectSensitiveDataOrigin|tpmObjectRestricted|tpmObjectSign),
+		key:    key,
+		keyPub: encodeTPMPublicECC(&key.PublicKey, tpmObjectFixedTPM|tpmObjectFixedParent|tpmObjectSensitiveDataOrigin|tpmObjectSign),
+		pcrs:   pcrs,
+	}
+}
+
+func newSoftTPMRSA(t *testing.T) *softTPM {
+	t.Helper()
+	ek, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.FatalError(t, err)
+	return newSoftTPM(t, ek)
+}
+
+func newSoftTPMECC(t *testing.T) *softTPM {
+	t.Helper()
+	ek, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	return newSoftTPM(t, ek)
+}
+
+func (s *softTPM) ekPub(t *testing.T) []byte {
+	t.Helper()
+	b, err := x509.MarshalPKIXPublicKey(s.ek.Public())
+	assert.FatalError(t, err)
+	return b
+}
+
+func (s *softTPM) ekHash(t *testing.T) string {
+	t.Helper()
+	sum := sha256.Sum256(s.ekPub(t))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *softTPM) akName(t *testing.T) []byte {
+	t.Helper()
+	pub, err := parseTPMPublic(s.akPub)
+	assert.FatalError(t, err)
+	return pub.Name
+}
+
+// encodeTPMPublicECC encodes a TPMT_PUBLIC of a P-256 ECDSA key.
+func encodeTPMPublicECC(pub *ecdsa.PublicKey, attrs uint32) []byte {
+	b := []byte{0x00, 0x23, 0x00, 0x0b}
+	b = append(b, uint32Bytes(attrs)...)
+	b = append(b, tpm2bBytes(nil)...)
+	b = append(b, 0x00, 0x10, 0x00, 0x18, 0x00, 0x0b, 0x00, 0x03, 0x00, 0x10)
+	b = append(b, tpm2bBytes(padBytes(pub.X.Bytes(), 32))...)
+	return append(b, tpm2bBytes(padBytes(pub.Y.Bytes(), 32))...)
+}
+
+// encodeTPMPublicRSA encodes a TPMT_PUBLIC of an RSASSA key.
+func encodeTPMPublicRSA(pub *rsa.PublicKey, attrs uint32) []byte {
+	b := []byte{0x00, 0x01, 0x00, 0x0b}
+	b = append(b, uint32Bytes(attrs)...)
+	b = append(b, tpm2bBytes(nil)...)
+	b = append(b, 0x00, 0x10, 0x00, 0x14, 0x00, 0x0b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00)
+	return append(b, tpm2bBytes(pub.N.Bytes())...)
+}
+
+func uint32Bytes(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+// activateCredential implements TPM2_ActivateCredential.
+func (s *softTPM) activateCredential(credential, encSecret, name []byte) ([]byte, error) {
+	var seed []byte
+	r := &tpmReader{b: encSecret}
+	switch ek := s.ek.(type) {
+	case *rsa.PrivateKey:
+		b := r.tpm2b()
+		if r.err != nil {
+			return nil, r.err
+		}
+		var err error
+		if seed, err = rsa.DecryptOAEP(sha256.New(), rand.Reader, ek, b, append([]byte("IDENTITY"), 0)); err != nil {
+			return nil, err
+		}
+	case *ecdsa.PrivateKey:
+		point := &tpmReader{b: r.tpm2b()}
+		x, y := point.tpm2b(), point.tpm2b()
+		if point.err != nil || r.err != nil {
+			return nil, errors.New("error parsing secret")
+		}
+		z, _ := ek.Curve.ScalarMult(new(big.Int).SetBytes(x), new(big.Int).SetBytes(y), ek.D.Bytes())
+		seed = tpmKDFe(crypto.SHA256, padBytes(z.Bytes(), 32), "IDENTITY", x, padBytes(ek.X.Bytes(), 32), 256)
+	}
+
+	r = &tpmReader{b: credential}
+	r = &tpmReader{b: r.tpm2b()}
+	integrity := r.tpm2b()
+	encIdentity := r.b
+	if r.err != nil {
+		return nil, r.err
+	}
+	mac := hmac.New(sha256.New, tpmKDFa(crypto.SHA256, seed, "INTEGRITY", nil, nil, 256))
+	mac.Write(encIdentity)
+	mac.Write(name)
+	if !hmac.Equal(mac.Sum(nil), integrity) {
+		return nil, errors.New("integrity check failed")
+	}
+	block, err := aes.NewCipher(tpmKDFa(crypto.SHA256, seed, "STORAGE", name, nil, 128))
+	if err != nil {
+		return nil, err
+	}
+	plain := make([]byte, len(encIdentity))
+	cipher.NewCFBDecrypter(block, make([]byte, aes.BlockSize)).XORKeyStream(plain, encIdentity)
+	r = &tpmReader{b: plain}
+	secret := r.tpm2b()
+	return secret, r.err
+}
+
+// attest returns a TPMS_ATTEST and its TPMT_SIGNATURE using the AK.
+func (s *softTPM) attest(typ uint16, extraData, attested []byte) ([]byte, []byte) {
+	b := uint32Bytes(tpmGeneratedValue)
+	b = append(b, byte(typ>>8), byte(typ))
+	b = append(b, tpm2bBytes([]byte("qualified-signer"))...)
+	b = append(b, tpm2bBytes(extraData)...)
+	b = append(b, make([]byte, 17+8)...)
+	b = append(b, attested...)
+
+	sum := sha256.Sum256(b)
+	r, ss, err := ecdsa.Sign(rand.Reader, s.ak, sum[:])
+	if err != nil {
+		panic(err)
+	}
+	sig := []byte{0x00, 0x18, 0x00, 0x0b}
+	sig = append(sig, tpm2bBytes(r.Bytes())...)
+	sig = append(sig, tpm2bBytes(ss.Bytes())...)
+	return b, sig
+}
+
+// certify implements TPM2_Certify of the key with the AK.
+func (s *softTPM) certify(t *testing.T, nonce []byte) ([]byte, []byte) {
+	t.Helper()
+	pub, err := parseTPMPublic(s.keyPub)
+	assert.FatalError(t, err)
+	return s.attest(tpmSTAttestCertify, nonce, append(tpm2bBytes(pub.Name), tpm2bBytes(nil)...))
+}
+
+// quote implements TPM2_Quote of the given SHA-256 PCRs with the AK.
+func (s *softTPM) quote(nonce []byte, pcrs []int) ([]byte, []byte) {
+	bitmap := make([]byte, 3)
+	for _, i := range pcrs {
+		bitmap[i/8] |= 1 << (i % 8)
+	}
+	sorted := append([]int{}, pcrs...)
+	sort.Ints(sorted)
+	h := sha256.New()
+	for _, i := range sorted {
+		h.Write(s.pcrs[i])
+	}
+	attested := append(uint32Bytes(1), 0x00, 0x0b, 0x03)
+	attested = append(attested, bitmap...)
+	attested = append(attested, tpm2bBytes(h.Sum(nil))...)
+	return s.attest(tpmSTAttestQuote, nonce, attested)
+}
+
+// tpmTokenOptions are the options used to generate a TPM token.
+type tpmTokenOptions struct {
+	aud      string
+	sub      string
+	sans     []string
+	ekCerts  bool
+	pcrs     []int
+	modify   func(att *tpmAttestation)
+	issuedAt time.Time
+}
+
+// generateTPMToken solves a challenge of the provisioner and returns a token.
+func generateTPMToken(t *testing.T, p *TPM, s *softTPM, o tpmTokenOptions) string {
+	t.Helper()
+	req := &TPMChallengeRequest{AKPub: s.akPub}
+	if o.ekCerts {
+		req.EKCerts = [][]byte{s.ekCert.Raw}
+	} else {
+		req.EKPub = s.ekPub(t)
+	}
+	ch, err := p.CreateChallenge(req)
+	assert.FatalError(t, err)
+	secret, err := s.activateCredential(ch.Credential, ch.Secret, s.akName(t))
+	assert.FatalError(t, err)
+
+	nonce := sha256.Sum256(secret)
+	certify, certifySig := s.certify(t, nonce[:])
+	att := &tpmAttestation{
+		EKCerts:             req.EKCerts,
+		EKPub:               req.EKPub,
+		AKPub:               s.akPub,
+		ExpiresAt:           ch.ExpiresAt.Unix(),
+		KeyPub:              s.keyPub,
+		KeyCertify:          certify,
+		KeyCertifySignature: certifySig,
+	}
+	if len(o.pcrs) > 0 {
+		att.Quote, att.QuoteSignature = s.quote(nonce[:], o.pcrs)
+		att.PCRs = make(map[string][]byte)
+		for _, i := range o.pcrs {
+			att.PCRs[strconv.Itoa(i)] = s.pcrs[i]
+		}
+	}
+	if o.modify != nil {
+		o.modify(att)
+	}
+
+	if o.aud == "" {
+		o.aud = testAudiences.Sign[0] + "#tpm/" + p.Name
+	}
+	if o.sub == "" {
+		o.sub = "host.example.com"
+	}
+	if o.issuedAt.IsZero() {
+		o.issuedAt = time.Now()
+	}
+	sig, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.HS256, Key: secret},
+		new(jose.SignerOptions).WithType("JWT"),
+	)
+	assert.FatalError(t, err)
+	tok, err := jose.Signed(sig).Claims(tpmPayload{
+		Claims: jose.Claims{
+			ID:        "the-jti",
+			Subject:   o.sub,
+			Issuer:    p.Name,
+			IssuedAt:  jose.NewNumericDate(o.issuedAt),
+			NotBefore: jose.NewNumericDate(o.issuedAt),
+			Expiry:    jose.NewNumericDate(o.issuedAt.Add(5 * time.Minute)),
+			Audience:  []string{o.aud},
+		},
+		SANs: o.sans,
+		TPM:  att,
+	}).CompactSerialize()
+	assert.FatalError(t, err)
+	return tok
+}
+
+// generateEKCertificate creates an EK root and an EK certificate for the TPM
+// with a critical SAN extension with a directory name, like the ones issued
+// by TPM manufacturers.
+func generateEKCertificate(t *testing.T, s *softTPM) []byte {
+	t.Helper()
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	rootTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "EK Root CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	rootDER, err := x509.CreateCertificate(rand.Reader, rootTmpl, rootTmpl, &rootKey.PublicKey, rootKey)
+	assert.FatalError(t, err)
+	root, err := x509.ParseCertificate(rootDER)
+	assert.FatalError(t, err)
+
+	// SAN with a directoryName.
+	san := []byte{0x30, 0x0e, 0xa4, 0x0c, 0x30, 0x0a, 0x31, 0x08, 0x30, 0x06, 0x06, 0x01, 0x00, 0x0c, 0x01, 0x41}
+	ekTmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageKeyEncipherment,
+		ExtraExtensions: []pkix.Extension{
+			{Id: oidSubjectAltName, Critical: true, Value: san},
+		},
+	}
+	ekDER, err := x509.CreateCertificate(rand.Reader, ekTmpl, root, s.ek.Public(), rootKey)
+	assert.FatalError(t, err)
+	s.ekCert, err = x509.ParseCertificate(ekDER)
+	assert.FatalError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: rootDER})
+}
+
+func newTPMCSR(t *testing.T, key crypto.Signer, cn string, dnsNames ...string) *x509.CertificateRequest {
+	t.Helper()
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: cn},
+		DNSNames: dnsNames,
+	}, key)
+	assert.FatalError(t, err)
+	csr, err := x509.ParseCertificateRequest(der)
+	assert.FatalError(t, err)
+	return csr
+}
+
+// newTPMConfig returns a configuration with a database that stores the
+// challenge keys in memory.
+func newTPMConfig() Config {
+	secrets := make(map[string][]byte)
+	return Config{Claims: globalProvisionerClaims, Audiences: testAudiences, DB: &db.MockAuthDB{
+		MLoadOrStoreProvisionerSecret: func(id string, secret []byte) ([]byte, error) {
+			if v, ok := secrets[id]; ok {
+				return v, nil
+			}
+			secrets[id] = secret
+			return secret, nil
+		},
+	}}
+}
+
+func generateTPM(t *testing.T, s *softTPM) *TPM {
+	t.Helper()
+	p := &TPM{
+		Type:   "TPM",
+		Name:   "bare-metal",
+		EKKeys: []string{s.ekHash(t)},
+	}
+	assert.FatalError(t, p.Init(newTPMConfig()))
+	return p
+}
+
+func TestTPM_Getters(t *testing.T) {
+	p := &TPM{Type: "TPM", Name: "bare-metal"}
+	assert.Equals(t, "tpm/bare-metal", p.GetID())
+	assert.Equals(t, "tpm/bare-metal", p.GetIDForToken())
+	assert.Equals(t, "bare-metal", p.GetName())
+	assert.Equals(t, TypeTPM, p.GetType())
+	kid, key, ok := p.GetEncryptedKey()
+	assert.Equals(t, "", kid)
+	assert.Equals(t, "", key)
+	assert.False(t, ok)
+
+	p.ID = "the-id"
+	assert.Equals(t, "the-id", p.GetID())
+}
+
+func TestTPM_Init(t *testing.T) {
+	s := newSoftTPMECC(t)
+	root := generateEKCertificate(t, s)
+	hash := s.ekHash(t)
+	config := newTPMConfig()
+
+	tests := []struct {
+		name    string
+		p       *TPM
+		wantErr bool
+	}{
+		{"ok ekKeys", &TPM{Type: "TPM", Name: "tpm", EKKeys: []string{hash}}, false},
+		{"ok ekRoots", &TPM{Type: "TPM", Name: "tpm", EKRoots: root}, false},
+		{"ok pcrs", &TPM{Type: "TPM", Name: "tpm", EKRoots: root, PCRs: map[int][]string{
+			0: {hex.EncodeToString(s.pcrs[0])},
+			7: {hex.EncodeToString(s.pcrs[7]), hex.EncodeToString(s.pcrs[6])},
+		}}, false},
+		{"fail type", &TPM{Name: "tpm", EKKeys: []string{hash}}, true},
+		{"fail name", &TPM{Type: "TPM", EKKeys: []string{hash}}, true},
+		{"fail no ek", &TPM{Type: "TPM", Name: "tpm"}, true},
+		{"fail ekRoots", &TPM{Type: "TPM", Name: "tpm", EKRoots: []byte("foo")}, true},
+		{"fail ekRoots pem", &TPM{Type: "TPM", Name: "tpm", EKRoots: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("foo")})}, true},
+		{"fail ekKeys", &TPM{Type: "TPM", Name: "tpm", EKKeys: []string{"foo"}}, true},
+		{"fail ekKeys size", &TPM{Type: "TPM", Name: "tpm", EKKeys: []string{hash[:32]}}, true},
+		{"fail pcr index", &TPM{Type: "TPM", Name: "tpm", EKKeys: []string{hash}, PCRs: map[int][]string{24: {hash}}}, true},
+		{"fail pcr empty", &TPM{Type: "TPM", Name: "tpm", EKKeys: []string{hash}, PCRs: map[int][]string{7: {}}}, true},
+		{"fail pcr value", &TPM{Type: "TPM", Name: "tpm", EKKeys: []string{hash}, PCRs: map[int][]string{7: {"foo"}}}, true},
+		{"fail claims", &TPM{Type: "TPM", Name: "tpm", EKKeys: []string{hash}, Claims: &Claims{DefaultTLSDur: &Duration{0}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.p.Init(config); (err != nil) != tt.wantErr {
+				t.Errorf("TPM.Init() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTPM_Init_challengeKey(t *testing.T) {
+	hash := newSoftTPMECC(t).ekHash(t)
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	newTPM := func(challengeKey string) *TPM {
+		return &TPM{Type: "TPM", Name: "tpm", EKKeys: []string{hash}, ChallengeKey: challengeKey}
+	}
+
+	// The configured key is used.
+	p := newTPM(base64.StdEncoding.EncodeToString(key))
+	assert.FatalError(t, p.Init(Config{Claims: globalProvisionerClaims, Audiences: testAudiences}))
+	assert.Equals(t, key, p.challengeKey)
+
+	// The stored key is kept when the provisioner is initialized again.
+	config := newTPMConfig()
+	p1, p2 := newTPM(""), newTPM("")
+	assert.FatalError(t, p1.Init(config))
+	assert.FatalError(t, p2.Init(config))
+	assert.Equals(t, 32, len(p1.challengeKey))
+	assert.Equals(t, p1.challengeKey, p2.challengeKey)
+
+	tests := []struct {
+		name   string
+		p      *TPM
+		config Config
+		err    string
+	}{
+		{"fail challengeKey", newTPM("foo"), config, "error parsing challengeKey"},
+		{"fail challengeKey size", newTPM(base64.StdEncoding.EncodeToString(key[:16])), config, "error parsing challengeKey"},
+		{"fail no db", newTPM(""), Config{Claims: globalProvisionerClaims, Audiences: testAudiences}, "provisioner challengeKey cannot be empty"},
+		{"fail db", newTPM(""), Config{Claims: globalProvisionerClaims, Audiences: testAudiences, DB: &db.MockAuthDB{
+			Err: errors.New("force"),
+		}}, "error initializing challenge key: force"},
+		{"fail db key size", newTPM(""), Config{Claims: globalProvisionerClaims, Audiences: testAudiences, DB: &db.MockAuthDB{
+			Ret1: []byte("foo"),
+		}}, "error initializing challenge key: stored key is too short"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.p.Init(tt.config)
+			if assert.Error(t, err) {
+				assert.HasPrefix(t, err.Error(), tt.err)
+			}
+		})
+	}
+}
+
+func TestTPM_CreateChallenge(t *testing.T) {
+	s := newSoftTPMRSA(t)
+	p := generateTPM(t, s)
+	root := generateEKCertificate(t, s)
+	pRoots := &TPM{Type: "TPM", Name: "bare-metal", EKRoots: root}
+	assert.FatalError(t, pRoots.Init(newTPMConfig()))
+
+	other := newSoftTPMECC(t)
+	generateEKCertificate(t, other)
+
+	ak, err := parseTPMPublic(s.akPub)
+	assert.FatalError(t, err)
+	encryptionKey := encodeTPMPublicECC(ak.Key.(*ecdsa.PublicKey), tpmObjectFixedTPM|tpmObjectFixedParent|tpmObjectSensitiveDataOrigin|tpmObjectDecrypt)
+
+	tests := []struct {
+		name     string
+		p        *TPM
+		req      *TPMChallengeRequest
+		wantCode int
+	}{
+		{"ok ekPub", p, &TPMChallengeRequest{EKPub: s.ekPub(t), AKPub: s.akPub}, 0},
+		{"ok ekCerts", pRoots, &TPMChallengeRequest{EKCerts: [][]byte{s.ekCert.Raw}, AKPub: s.akPub}, 0},
+		{"ok ekCerts allow-list", p, &TPMChallengeRequest{EKCerts: [][]byte{s.ekCert.Raw}, AKPub: s.akPub}, 0},
+		{"fail no ek", p, &TPMChallengeRequest{AKPub: s.akPub}, http.StatusUnauthorized},
+		{"fail ekPub", p, &TPMChallengeRequest{EKPub: []byte("foo"), AKPub: s.akPub}, http.StatusUnauthorized},
+		{"fail ekCerts", p, &TPMChallengeRequest{EKCerts: [][]byte{[]byte("foo")}, AKPub: s.akPub}, http.StatusUnauthorized},
+		{"fail untrusted ekPub", p, &TPMChallengeRequest{EKPub: other.ekPub(t), AKPub: s.akPub}, http.StatusUnauthorized},
+		{"fail untrusted ekCerts", pRoots, &TPMChallengeRequest{EKCerts: [][]byte{other.ekCert.Raw}, AKPub: s.akPub}, http.StatusUnauthorized},
+		{"fail akPub", p, &TPMChallengeRequest{EKPub: s.ekPub(t), AKPub: []byte("foo")}, http.StatusBadRequest},
+		{"fail akPub attributes", p, &TPMChallengeRequest{EKPub: s.ekPub(t), AKPub: encryptionKey}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.p.CreateChallenge(tt.req)
+			if tt.wantCode != 0 {
+				if assert.Error(t, err) {
+					sc, ok := err.(errs.StatusCoder)
+					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+					assert.Equals(t, tt.wantCode, sc.StatusCode())
+				}
+				return
+			}
+			assert.FatalError(t, err)
+			assert.True(t, got.ExpiresAt.After(time.Now()))
+			secret, err := s.activateCredential(got.Credential, got.Secret, s.akName(t))
+			assert.FatalError(t, err)
+			assert.Equals(t, tt.p.challengeSecret(s.ekHash(t), s.akName(t), got.ExpiresAt.Unix()), secret)
+
+			// The credential can only be activated with the same AK.
+			_, err = s.activateCredential(got.Credential, got.Secret, other.akName(t))
+			assert.Error(t, err)
+		})
+	}
+}
+
+// Test_tpmMakeCredential_vectors checks the credentials with the values created
+// by the credactivation package of github.com/google/go-tpm, the one used by
+// go-attestation with hardware TPMs.
+func Test_tpmMakeCredential_vectors(t *testing.T) {
+	mustHex := func(s string) []byte {
+		b, err := hex.DecodeString(s)
+		assert.FatalError(t, err)
+		return b
+	}
+	name := mustHex("000b7f093592aebbb47767b5655699cdab8c86c4b24f5c93e3b22a3c2538856e9bf1")
+	secret := mustHex("2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b")
+
+	// The seed is the one encrypted with an RSA endorsement key.
+	credential, err := tpmCredentialBlob(mustHex("64baacb45d0e090a534b69d67fe2467f"), name, secret)
+	assert.FatalError(t, err)
+	assert.Equals(t, mustHex("00440020ec97a3bef64ea620182f502db448732b90d502b7b2ffac40263d6dd7c48e4732f7765381d1334ae0edf35f6d16c1d7fc12f7d24a96807ac48de5eaf600a596048744"), credential)
+
+	newKey := func(d []byte) *ecdsa.PrivateKey {
+		key := &ecdsa.PrivateKey{D: new(big.Int).SetBytes(d)}
+		key.Curve = elliptic.P256()
+		key.X, key.Y = key.Curve.ScalarBaseMult(d)
+		return key
+	}
+	ephemeral := newKey(mustHex("8341425cafede9d24b0599aefdfdeff1c1526ed75b07217eb99bf8c0b7498b81"))
+	ek := newKey(mustHex("729841c48e5ae7999d99facd04906aeac620e130bd1d78dbf9d8884d69601e6e"))
+	seed, encSecret := tpmECCSeed(ephemeral, &ek.PublicKey)
+	assert.Equals(t, mustHex("10713fefc07231430466e4e4fd63874d9417d1033c8dfd44851c571cf7d4c245"), seed)
+	assert.Equals(t, mustHex("004400209a781ca6d055a7f30d0c9ff87936c739f6816ef5f5e72b4b946404b0a1a83b2a0020c19f842945ea2bf65aa1649b2b02ff79854c8d5ecfcd403862e8a97ea66c71c1"), encSecret)
+}
+
+func TestTPM_authorizeToken(t *testing.T) {
+	s := newSoftTPMECC(t)
+	root := generateEKCertificate(t, s)
+	p := generateTPM(t, s)
+	pRoots := &TPM{Type: "TPM", Name: "bare-metal", EKRoots: root}
+	assert.FatalError(t, pRoots.Init(newTPMConfig()))
+	pPCRs := &TPM{Type: "TPM", Name: "bare-metal", EKKeys: p.EKKeys, PCRs: map[int][]string{
+		0: {hex.EncodeToString(s.pcrs[0])},
+		7: {"0000000000000000000000000000000000000000000000000000000000000000", hex.EncodeToString(s.pcrs[7])},
+	}}
+	assert.FatalError(t, pPCRs.Init(newTPMConfig()))
+
+	other := newSoftTPMECC(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.FatalError(t, err)
+
+	type test struct {
+		p        *TPM
+		token    string
+		wantPCRs map[int][]byte
+		err      error
+	}
+	tests := map[string]func(t *testing.T) test{
+		"ok": func(t *testing.T) test {
+			return test{p: p, token: generateTPMToken(t, p, s, tpmTokenOptions{})}
+		},
+		"ok ekCerts": func(t *testing.T) test {
+			return test{p: pRoots, token: generateTPMToken(t, pRoots, s, tpmTokenOptions{ekCerts: true})}
+		},
+		"ok pcrs": func(t *testing.T) test {
+			return test{
+				p:        pPCRs,
+				token:    generateTPMToken(t, pPCRs, s, tpmTokenOptions{pcrs: []int{7, 0}}),
+				wantPCRs: map[int][]byte{0: s.pcrs[0], 7: s.pcrs[7]},
+			}
+		},
+		"fail token": func(t *testing.T) test {
+			return test{p: p, token: "foo", err: errors.New("tpm.authorizeToken; error parsing tpm token")}
+		},
+		"fail no tpm claim": func(t *testing.T) test {
+			sig, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.HS256, Key: []byte("secret")}, nil)
+			assert.FatalError(t, err)
+			tok, err := jose.Signed(sig).Claims(jose.Claims{Issuer: p.Name}).CompactSerialize()
+			assert.FatalError(t, err)
+			return test{p: p, token: tok, err: errors.New("tpm.authorizeToken; tpm token does not have a tpm claim")}
+		},
+		"fail untrusted ek": func(t *testing.T) test {
+			tok := generateTPMToken(t, p, s, tpmTokenOptions{})
+			return test{p: pRoots, token: tok, err: errors.New("tpm.authorizeToken: endorsement key " + s.ekHash(t) + " is not trusted")}
+		},
+		"fail akPub": func(t *testing.T) test {
+			return test{p: p, token: generateTPMToken(t, p, s, tpmTokenOptions{modify: func(att *tpmAttestation) {
+				att.AKPub = []byte("foo")
+			}}), err: errors.New("tpm.authorizeToken: error parsing akPub")}
+		},
+		"fail other ak": func(t *testing.T) test {
+			return test{p: p, token: generateTPMToken(t, p, s, tpmTokenOptions{modify: func(att *tpmAttestation) {
+				att.AKPub = other.akPub
+			}}), err: errors.New("tpm.authorizeToken; error verifying tpm token")}
+		},
+		"fail expired challenge": func(t *testing.T) test {
+			return test{p: p, token: generateTPMToken(t, p, s, tpmTokenOptions{modify: func(att *tpmAttestation) {
+				att.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+			}}), err: errors.New("tpm.authorizeToken; tpm challenge has expired")}
+		},
+		"fail modified challenge": func(t *testing.T) test {
+			return test{p: p, token: generateTPMToken(t, p, s, tpmTokenOptions{modify: func(att *tpmAttestation) {
+				att.ExpiresAt += 3600
+			}}), err: errors.New("tpm.authorizeToken; error verifying tpm token")}
+		},
+		"fail reinitialized provisioner": func(t *testing.T) test {
+			p := generateTPM(t, s)
+			tok := generateTPMToken(t, p, s, tpmTokenOptions{})
+			assert.FatalError(t, p.Init(newTPMConfig()))
+			return test{p: p, token: tok, err: errors.New("tpm.authorizeToken; error verifying tpm token")}
+		},
+		"fail audience": func(t *testing.T) test {
+			return test{p: p, token: generateTPMToken(t, p, s, tpmTokenOptions{aud: "https://ca.smallstep.com/1.0/sign#tpm/foo"}),
+				err: errors.New("tpm.authorizeToken; invalid tpm token audience claim (aud)")}
+		},
+		"fail expired token": func(t *testing.T) test {
+			return test{p: p, token: generateTPMToken(t, p, s, tpmTokenOptions{issuedAt: time.Now().Add(-10 * time.Minute)}),
+				err: errors.New("tpm.authorizeToken; invalid tpm token claims")}
+		},
+		"fail keyPub": func(t *testing.T) test {
+			return test{p: p, token: generateTPMToken(t, p, s, tpmTokenOptions{modify: func(att *tpmAttestation) {
+				att.KeyPub = []byte("foo")
+			}}), err: errors.New("tpm.authorizeToken; error parsing keyPub")}
+		},
+		"fail keyPub attributes": func(t *testing.T) test {
+			return test{p: p, token: generateTPMToken(t, p, s, tpmTokenOptions{modify: func(att *tpmAttestation) {
+				att.KeyPub = encodeTPMPublicECC(&s.key.PublicKey, tpmObjectSign)
+			}}), err: errors.New("tpm.authorizeToken; keyPub is not a key bound to the TPM")}
+		},
+		"fail keyPub name": func(t *testing.T) test {
+			return test{p: p, token: generateTPMToken(t, p, s, tpmTokenOptions{modify: func(att *tpmAttestation) {
+				att.KeyPub = encodeTPMPublicRSA(&rsaKey.PublicKey, tpmObjectFixedTPM|tpmObjectFixedParent)
+			}}), err: errors.New("tpm.authorizeToken; keyCertify name does not match keyPub")}
+		},
+		"fail keyCertifySignature": func(t *testing.T) test {
+			return test{p: p, token: generateTPMToken(t, p, s, tpmTokenOptions{modify: func(att *tpmAttestation) {
+				att.KeyCertify = append(att.KeyCertify, 0)
+			}}), err: errors.New("tpm.authorizeToken; invalid keyCertifySignature")}
+		},
+		"fail keyCertify nonce": func(t *testing.T) test {
+			return test{p: p, token: generateTPMToken(t, p, s, tpmTokenOptions{modify: func(att *tpmAttestation) {
+				att.KeyCertify, att.KeyCertifySignature = s.certify(t, []byte("nonce"))
+			}}), err: errors.New("tpm.authorizeToken; keyCertify extraData does not match the challenge")}
+		},
+		"fail keyCertify quote": func(t *testing.T) test {
+			return test{p: p, token: generateTPMToken(t, p, s, tpmTokenOptions{pcrs: []int{0}, modify: func(att *tpmAttestation) {
+				att.KeyCertify, att.KeyCertifySignature = att.Quote, att.QuoteSignature
+			}}), err: errors.New("tpm.authorizeToken; keyCertify is not a certify attestation")}
+		},
+		"fail missing quote": func(t *testing.T) test {
+			return test{p: pPCRs, token: generateTPMToken(t, pPCRs, s, tpmTokenOptions{}),
+				err: errors.New("tpm.authorizeToken; PCR")}
+		},
+		"fail pcr value": func(t *testing.T) test {
+			s := newSoftTPMECC(t)
+			s.pcrs[7] = make([]byte, 32)
+			s.pcrs[7][0] = 1
+			pPCRs := &TPM{Type: "TPM", Name: "bare-metal", EKKeys: []string{s.ekHash(t)}, PCRs: pPCRs.PCRs}
+			assert.FatalError(t, pPCRs.Init(newTPMConfig()))
+			return test{p: pPCRs, token: generateTPMToken(t, pPCRs, s, tpmTokenOptions{pcrs: []int{0, 7}}),
+				err: errors.New("tpm.authorizeToken; PCR 7 does not have an allowed value")}
+		},
+		"fail quote pcrs claim": func(t *testing.T) test {
+			return test{p: pPCRs, token: generateTPMToken(t, pPCRs, s, tpmTokenOptions{pcrs: []int{0, 7}, modify: func(att *tpmAttestation) {
+				att.PCRs["7"] = make([]byte, 32)
+			}}), err: errors.New("tpm.authorizeToken: quote digest does not match the pcrs claim")}
+		},
+		"fail quote missing pcr": func(t *testing.T) test {
+			return test{p: pPCRs, token: generateTPMToken(t, pPCRs, s, tpmTokenOptions{pcrs: []int{0, 7}, modify: func(att *tpmAttestation) {
+				delete(att.PCRs, "7")
+			}}), err: errors.New("tpm.authorizeToken: quote PCRs do not match the pcrs claim")}
+		},
+		"fail quote nonce": func(t *testing.T) test {
+			return test{p: pPCRs, token: generateTPMToken(t, pPCRs, s, tpmTokenOptions{pcrs: []int{0, 7}, modify: func(att *tpmAttestation) {
+				att.Quote, att.QuoteSignature = s.quote([]byte("nonce"), []int{0, 7})
+			}}), err: errors.New("tpm.authorizeToken: quote extraData does not match the challenge")}
+		},
+		"fail quote signature": func(t *testing.T) test {
+			return test{p: pPCRs, token: generateTPMToken(t, pPCRs, s, tpmTokenOptions{pcrs: []int{0, 7}, modify: func(att *tpmAttestation) {
+				att.QuoteSignature = att.KeyCertifySignature
+			}}), err: errors.New("tpm.authorizeToken: invalid quoteSignature")}
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := tt(t)
+			got, err := tc.p.authorizeToken(tc.token, tc.p.audiences.Sign)
+			if tc.err != nil {
+				if assert.Error(t, err) {
+					sc, ok := err.(errs.StatusCoder)
+					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+					assert.Equals(t, http.StatusUnauthorized, sc.StatusCode())
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+				return
+			}
+			assert.FatalError(t, err)
+			assert.Equals(t, s.ekHash(t), got.ekHash)
+			assert.Equals(t, s.akName(t), got.akName)
+			assert.Equals(t, &s.key.PublicKey, got.key)
+			assert.Equals(t, tc.wantPCRs, got.pcrs)
+		})
+	}
+}
+
+func TestTPM_AuthorizeSign(t *testing.T) {
+	s := newSoftTPMRSA(t)
+	p := generateTPM(t, s)
+	pDisable := &TPM{Type: "TPM", Name: "bare-metal", EKKeys: p.EKKeys, DisableCustomSANs: true}
+	assert.FatalError(t, pDisable.Init(newTPMConfig()))
+	pTemplate := &TPM{Type: "TPM", Name: "bare-metal", EKKeys: p.EKKeys, Options: &Options{
+		X509: &X509Options{Template: `{"subject": {"commonName": {{ toJson .Subject.CommonName }}}, "uris": ["spiffe://example.com/tpm/{{ .TPM.EKHash }}"]}`},
+	}}
+	assert.FatalError(t, pTemplate.Init(newTPMConfig()))
+
+	ekHash := s.ekHash(t)
+	tests := []struct {
+		name       string
+		p          *TPM
+		token      string
+		csr        *x509.CertificateRequest
+		wantCN     string
+		wantSANs   []string
+		wantURIs   []string
+		wantErr    bool
+		wantVerify bool
+	}{
+		{"ok", p, generateTPMToken(t, p, s, tpmTokenOptions{sans: []string{"host.example.com", "10.0.0.1"}}),
+			newTPMCSR(t, s.key, "host.example.com", "host.example.com"),
+			"host.example.com", []string{"host.example.com"}, nil, false, false},
+		{"ok default sans", p, generateTPMToken(t, p, s, tpmTokenOptions{}),
+			newTPMCSR(t, s.key, "host.example.com", "host.example.com"),
+			"host.example.com", []string{"host.example.com"}, nil, false, false},
+		{"ok disableCustomSANs", pDisable, generateTPMToken(t, pDisable, s, tpmTokenOptions{}),
+			newTPMCSR(t, s.key, ekHash),
+			ekHash, nil, []string{"urn:ek:sha256:" + ekHash}, false, false},
+		{"ok template", pTemplate, generateTPMToken(t, pTemplate, s, tpmTokenOptions{}),
+			newTPMCSR(t, s.key, "host.example.com", "host.example.com"),
+			"host.example.com", nil, []string{"spiffe://example.com/tpm/" + ekHash}, false, false},
+		{"fail token", p, "foo", nil, "", nil, nil, true, false},
+		{"fail disableCustomSANs", pDisable, generateTPMToken(t, pDisable, s, tpmTokenOptions{}),
+			newTPMCSR(t, s.key, "host.example.com", "host.example.com"),
+			"", nil, nil, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.p.AuthorizeSign(context.Background(), tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("TPM.AuthorizeSign() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, http.StatusUnauthorized, sc.StatusCode())
+				return
+			}
+			assert.Len(t, 9, got)
+
+			// Validate the CSR.
+			var verifyErr error
+			for _, o := range got {
+				if v, ok := o.(CertificateRequestValidator); ok {
+					if err := v.Valid(tt.csr); err != nil {
+						verifyErr = err
+						break
+					}
+				}
+			}
+			if tt.wantVerify {
+				assert.Error(t, verifyErr)
+				return
+			}
+			assert.FatalError(t, verifyErr)
+
+			// Create the certificate with the template.
+			var templateOpt CertificateOptions
+			for _, o := range got {
+				if v, ok := o.(CertificateOptions); ok {
+					templateOpt = v
+				}
+			}
+			assert.NotNil(t, templateOpt)
+			cert, err := x509util.NewCertificate(tt.csr, templateOpt.Options(SignOptions{})...)
+			assert.FatalError(t, err)
+			crt := cert.GetCertificate()
+			assert.Equals(t, tt.wantCN, crt.Subject.CommonName)
+			assert.Equals(t, tt.wantSANs, crt.DNSNames)
+			var uris []string
+			for _, u := range crt.URIs {
+				uris = append(uris, u.String())
+			}
+			assert.Equals(t, tt.wantURIs, uris)
+		})
+	}
+
+	// The key of the CSR must be the TPM key.
+	got, err := p.AuthorizeSign(context.Background(), generateTPMToken(t, p, s, tpmTokenOptions{}))
+	assert.FatalError(t, err)
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	for _, o := range got {
+		if v, ok := o.(tpmKeyValidator); ok {
+			assert.FatalError(t, v.Valid(&x509.CertificateRequest{PublicKey: &s.key.PublicKey}))
+			assert.Error(t, v.Valid(&x509.CertificateRequest{PublicKey: &other.PublicKey}))
+		}
+	}
+}
+
+func TestTPM_AuthorizeRenew(t *testing.T) {
+	s := newSoftTPMECC(t)
+	p1 := generateTPM(t, s)
+	disable := true
+	p2 := &TPM{Type: "TPM", Name: "bare-metal", EKKeys: p1.EKKeys, Claims: &Claims{DisableRenewal: &disable}}
+	assert.FatalError(t, p2.Init(newTPMConfig()))
+
+	assert.FatalError(t, p1.AuthorizeRenew(context.Background(), &x509.Certificate{}))
+	err := p2.AuthorizeRenew(context.Background(), &x509.Certificate{})
+	if assert.Error(t, err) {
+		sc, ok := err.(errs.StatusCoder)
+		assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+		assert.Equals(t, http.StatusUnauthorized, sc.StatusCode())
+	}
+}
+
+func TestTPM_AuthorizeSSHSign(t *testing.T) {
+	tm, fn := mockNow()
+	defer fn()
+
+	s := newSoftTPMECC(t)
+	p := generateTPM(t, s)
+	pDisable := &TPM{Type: "TPM", Name: "bare-metal", EKKeys: p.EKKeys, DisableCustomSANs: true}
+	assert.FatalError(t, pDisable.Init(newTPMConfig()))
+	pNoSSH := &TPM{Type: "TPM", Name: "bare-metal", EKKeys: p.EKKeys, Claims: &Claims{EnableSSHCA: new(bool)}}
+	assert.FatalError(t, pNoSSH.Init(newTPMConfig()))
+
+	sshAud := testAudiences.SSHSign[0] + "#tpm/bare-metal"
+	signer, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	ekHash := s.ekHash(t)
+	hostDuration := p.claimer.DefaultHostSSHCertDuration()
+
+	tests := []struct {
+		name           string
+		p              *TPM
+		token          string
+		key            crypto.PublicKey
+		sshOpts        SignSSHOptions
+		wantPrincipals []string
+		wantErr        bool
+		wantSignErr    bool
+	}{
+		{"ok", p, generateTPMToken(t, p, s, tpmTokenOptions{aud: sshAud, sans: []string{"host.example.com", "10.0.0.1"}}),
+			&s.key.PublicKey, SignSSHOptions{}, []string{"host.example.com", "10.0.0.1"}, false, false},
+		{"ok principals", p, generateTPMToken(t, p, s, tpmTokenOptions{aud: sshAud, sans: []string{"host.example.com", "10.0.0.1"}}),
+			&s.key.PublicKey, SignSSHOptions{CertType: "host", Principals: []string{"10.0.0.1"}}, []string{"10.0.0.1"}, false, false},
+		{"ok default principals", p, generateTPMToken(t, p, s, tpmTokenOptions{aud: sshAud}),
+			&s.key.PublicKey, SignSSHOptions{}, []string{"host.example.com"}, false, false},
+		{"ok disableCustomSANs", pDisable, generateTPMToken(t, pDisable, s, tpmTokenOptions{aud: sshAud, sans: []string{"host.example.com"}}),
+			&s.key.PublicKey, SignSSHOptions{}, []string{ekHash}, false, false},
+		{"fail sshCA disabled", pNoSSH, generateTPMToken(t, pNoSSH, s, tpmTokenOptions{aud: sshAud}),
+			&s.key.PublicKey, SignSSHOptions{}, nil, true, false},
+		{"fail audience", p, generateTPMToken(t, p, s, tpmTokenOptions{}),
+			&s.key.PublicKey, SignSSHOptions{}, nil, true, false},
+		{"fail user cert", p, generateTPMToken(t, p, s, tpmTokenOptions{aud: sshAud}),
+			&s.key.PublicKey, SignSSHOptions{CertType: "user"}, nil, false, true},
+		{"fail principals", p, generateTPMToken(t, p, s, tpmTokenOptions{aud: sshAud}),
+			&s.key.PublicKey, SignSSHOptions{Principals: []string{"other.example.com"}}, nil, false, true},
+		{"fail key", p, generateTPMToken(t, p, s, tpmTokenOptions{aud: sshAud}),
+			&other.PublicKey, SignSSHOptions{}, nil, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.p.AuthorizeSSHSign(context.Background(), tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("TPM.AuthorizeSSHSign() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, http.StatusUnauthorized, sc.StatusCode())
+				return
+			}
+			cert, err := signSSHCertificate(tt.key, tt.sshOpts, got, signer.Key.(crypto.Signer))
+			if (err != nil) != tt.wantSignErr {
+				t.Errorf("SignSSH error = %v, wantSignErr %v", err, tt.wantSignErr)
+				return
+			}
+			if err == nil {
+				assert.Equals(t, uint32(ssh.HostCert), cert.CertType)
+				assert.Equals(t, tt.wantPrincipals, cert.ValidPrincipals)
+				assert.Equals(t, uint64(tm.Add(hostDuration).Unix()), cert.ValidBefore)
+			}
+		})
+	}
+}
+
+func TestCollection_LoadByToken_tpm(t *testing.T) {
+	s := newSoftTPMECC(t)
+	p := generateTPM(t, s)
+	c := NewCollection(testAudiences)
+	assert.FatalError(t, c.Store(p))
+
+	tok := generateTPMToken(t, p, s, tpmTokenOptions{})
+	jwt, err := jose.ParseSigned(tok)
+	assert.FatalError(t, err)
+	var claims jose.Claims
+	assert.FatalError(t, jwt.UnsafeClaimsWithoutVerification(&claims))
+
+	got, ok := c.LoadByToken(jwt, &claims)
+	assert.True(t, ok)
+	assert.Equals(t, p, got)
+}
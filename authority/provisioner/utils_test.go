@@ -1101,6 +1101,8 @@ func generateJWKServer(n int) *httptest.Server {
 			writeJSON(w, hits)
 		case "/.well-known/openid-configuration":
 			writeJSON(w, openIDConfiguration{Issuer: "the-issuer", JWKSetURI: srv.URL + "/jwks_uri"})
+		case "/workload/.well-known/openid-configuration":
+			writeJSON(w, openIDConfiguration{Issuer: srv.URL + "/workload", JWKSetURI: srv.URL + "/jwks_uri"})
 		case "/common/.well-known/openid-configuration":
 			writeJSON(w, openIDConfiguration{Issuer: "https://login.microsoftonline.com/{tenantid}/v2.0", JWKSetURI: srv.URL + "/jwks_uri"})
 		case "/random":
@@ -0,0 +1,372 @@
+package provisioner
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.step.sm/crypto/sshutil"
+	"go.step.sm/crypto/x509util"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// WebhookSignatureHeader is the header with the hex encoded HMAC-SHA256 of
+	// the body of a webhook request.
+	WebhookSignatureHeader = "X-Smallstep-Signature"
+
+	// WebhookNameHeader is the header with the name of the webhook.
+	WebhookNameHeader = "X-Smallstep-Webhook-Name"
+
+	// WebhooksTemplateKey is the key in the template data where the data
+	// returned by the webhooks is stored, indexed by the webhook name.
+	WebhooksTemplateKey = "Webhooks"
+
+	// DefaultWebhookTimeout is the time a webhook has to respond if no timeout
+	// is configured.
+	DefaultWebhookTimeout = 10 * time.Second
+
+	// maxWebhookResponseSize is the maximum number of bytes read from a
+	// webhook response.
+	maxWebhookResponseSize = 1 << 20
+)
+
+// Webhook certificate types.
+const (
+	X509WebhookCertType = "x509"
+	SSHWebhookCertType  = "ssh"
+)
+
+// Webhook is the configuration of an HTTP endpoint called before signing a
+// certificate. The webhook can deny the request, and the data it returns is
+// available in the certificate templates as {{ .Webhooks.<name> }}.
+type Webhook struct {
+	// Name identifies the webhook, it must be unique in the provisioner.
+	Name string `json:"name"`
+
+	// URL is the https endpoint called with a POST request.
+	URL string `json:"url"`
+
+	// CertType limits the webhook to "x509" or "ssh" certificates. The webhook
+	// is called for all the certificates if it is empty.
+	CertType string `json:"certType,omitempty"`
+
+	// Secret is the base64 encoded key used to sign the requests with
+	// HMAC-SHA256. The signature is sent in the X-Smallstep-Signature header.
+	Secret string `json:"secret,omitempty"`
+
+	// DisableTLSClientAuth disables the use of a certificate issued by the
+	// CA as a TLS client certificate.
+	DisableTLSClientAuth bool `json:"disableTLSClientAuth,omitempty"`
+
+	// Timeout is the time the webhook has to respond, it defaults to 10s.
+	Timeout *Duration `json:"timeout,omitempty"`
+
+	secret []byte
+}
+
+// GetWebhooks returns the webhooks.
+func (o *Options) GetWebhooks() []*Webhook {
+	if o == nil {
+		return nil
+	}
+	return o.Webhooks
+}
+
+// Validate validates and initializes the webhook.
+func (w *Webhook) Validate() error {
+	if w.Name == "" {
+		return errors.New("webhook name cannot be empty")
+	}
+	u, err := url.Parse(w.URL)
+	if err != nil {
+		return errors.Wrapf(err, "webhook %s url is not valid", w.Name)
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return errors.Errorf("webhook %s url must be an absolute https url", w.Name)
+	}
+	switch w.CertType {
+	case "", X509WebhookCertType, SSHWebhookCertType:
+	default:
+		return errors.Errorf("webhook %s certType %s is not valid", w.Name, w.CertType)
+	}
+	if w.Secret != "" {
+		if w.secret, err = base64.StdEncoding.DecodeString(w.Secret); err != nil {
+			return errors.Wrapf(err, "webhook %s secret is not valid", w.Name)
+		}
+	}
+	if w.Timeout != nil && w.Timeout.Duration < 0 {
+		return errors.Errorf("webhook %s timeout cannot be negative", w.Name)
+	}
+	return nil
+}
+
+func (w *Webhook) timeout() time.Duration {
+	if w.Timeout == nil || w.Timeout.Duration == 0 {
+		return DefaultWebhookTimeout
+	}
+	return w.Timeout.Duration
+}
+
+// WebhookRequestBody is the body of the requests sent to the webhooks.
+type WebhookRequestBody struct {
+	Timestamp              time.Time                      `json:"timestamp"`
+	ProvisionerName        string                         `json:"provisionerName"`
+	Token                  interface{}                    `json:"token,omitempty"`
+	X509CertificateRequest *WebhookX509CertificateRequest `json:"x509CertificateRequest,omitempty"`
+	SSHCertificateRequest  *WebhookSSHCertificateRequest  `json:"sshCertificateRequest,omitempty"`
+}
+
+// WebhookX509CertificateRequest contains the details of the CSR sent to the
+// webhooks.
+type WebhookX509CertificateRequest struct {
+	Raw                []byte   `json:"raw"`
+	CommonName         string   `json:"commonName"`
+	DNSNames           []string `json:"dnsNames,omitempty"`
+	EmailAddresses     []string `json:"emailAddresses,omitempty"`
+	IPAddresses        []net.IP `json:"ipAddresses,omitempty"`
+	URIs               []string `json:"uris,omitempty"`
+	PublicKeyAlgorithm string   `json:"publicKeyAlgorithm"`
+}
+
+// WebhookSSHCertificateRequest contains the details of the SSH certificate
+// request sent to the webhooks.
+type WebhookSSHCertificateRequest struct {
+	PublicKey  []byte   `json:"publicKey"`
+	Type       string   `json:"type"`
+	KeyID      string   `json:"keyID"`
+	Principals []string `json:"principals"`
+}
+
+// WebhookResponseBody is the body the webhooks must respond with. If Allow is
+// false the certificate will not be signed.
+type WebhookResponseBody struct {
+	Allow bool        `json:"allow"`
+	Data  interface{} `json:"data,omitempty"`
+}
+
+// webhookController calls the webhooks configured in a provisioner.
+type webhookController struct {
+	provisionerName string
+	webhooks        []*Webhook
+	client          *http.Client
+	noTLSAuthClient *http.Client
+}
+
+// newWebhookController validates the webhooks in the provisioner options and
+// returns a controller that calls them using the given client. If the client
+// is nil the default http client will be used. It returns nil if there are no
+// webhooks.
+func newWebhookController(provisionerName string, o *Options, client *http.Client) (*webhookController, error) {
+	webhooks := o.GetWebhooks()
+	if len(webhooks) == 0 {
+		return nil, nil
+	}
+	names := make(map[string]bool, len(webhooks))
+	for _, w := range webhooks {
+		if err := w.Validate(); err != nil {
+			return nil, err
+		}
+		if names[w.Name] {
+			return nil, errors.Errorf("webhook %s is duplicated", w.Name)
+		}
+		names[w.Name] = true
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &webhookController{
+		provisionerName: provisionerName,
+		webhooks:        webhooks,
+		client:          client,
+		noTLSAuthClient: withoutTLSClientAuth(client),
+	}, nil
+}
+
+// withoutTLSClientAuth returns a copy of the client that does not send a TLS
+// client certificate.
+func withoutTLSClientAuth(client *http.Client) *http.Client {
+	tr, ok := client.Transport.(*http.Transport)
+	if !ok || tr.TLSClientConfig == nil {
+		return client
+	}
+	tr = tr.Clone()
+	tr.TLSClientConfig.Certificates = nil
+	tr.TLSClientConfig.GetClientCertificate = nil
+	c := *client
+	c.Transport = tr
+	return &c
+}
+
+// x509Option returns the sign option used to call the webhooks before signing an
+// X.509 certificate.
+func (c *webhookController) x509Option(data x509util.TemplateData) *x509Webhooks {
+	return &x509Webhooks{controller: c, data: data}
+}
+
+// sshOption returns the sign option used to call the webhooks before signing an
+// SSH certificate.
+func (c *webhookController) sshOption(data sshutil.TemplateData) *sshWebhooks {
+	return &sshWebhooks{controller: c, data: data}
+}
+
+// call calls all the webhooks for the given certificate type. The data
+// returned by the webhooks is added to the template data.
+func (c *webhookController) call(certType string, data map[string]interface{}, body *WebhookRequestBody) error {
+	if c == nil {
+		return nil
+	}
+	body.ProvisionerName = c.provisionerName
+	results := make(map[string]interface{})
+	for _, w := range c.webhooks {
+		if w.CertType != "" && w.CertType != certType {
+			continue
+		}
+		resp, err := c.do(w, body)
+		if err != nil {
+			return err
+		}
+		if !resp.Allow {
+			return errors.Errorf("webhook %s denied the request", w.Name)
+		}
+		results[w.Name] = resp.Data
+	}
+	if len(results) > 0 {
+		data[WebhooksTemplateKey] = results
+	}
+	return nil
+}
+
+// do sends the request to a webhook and returns its response.
+func (c *webhookController) do(w *Webhook, body *WebhookRequestBody) (*WebhookResponseBody, error) {
+	body.Timestamp = now().UTC()
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error marshaling webhook %s request", w.Name)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), w.timeout())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(b))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating webhook %s request", w.Name)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookNameHeader, w.Name)
+	if len(w.secret) > 0 {
+		mac := hmac.New(sha256.New, w.secret)
+		mac.Write(b)
+		req.Header.Set(WebhookSignatureHeader, hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	client := c.client
+	if w.DisableTLSClientAuth {
+		client = c.noTLSAuthClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error calling webhook %s", w.Name)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return nil, errors.Errorf("webhook %s responded with status code %d", w.Name, resp.StatusCode)
+	}
+	rb, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxWebhookResponseSize))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading webhook %s response", w.Name)
+	}
+	var wr WebhookResponseBody
+	if err := json.Unmarshal(rb, &wr); err != nil {
+		return nil, errors.Wrapf(err, "error unmarshaling webhook %s response", w.Name)
+	}
+	return &wr, nil
+}
+
+// CertificateWebhook is the interface used to call the webhooks of a
+// provisioner before creating an X.509 certificate.
+type CertificateWebhook interface {
+	SignOption
+	CallWebhooks(cr *x509.CertificateRequest) error
+}
+
+// SSHCertificateWebhook is the interface used to call the webhooks of a
+// provisioner before creating an SSH certificate.
+type SSHCertificateWebhook interface {
+	SignOption
+	CallSSHWebhooks(key ssh.PublicKey, opts SignSSHOptions) error
+}
+
+// x509Webhooks is a SignOption that calls the webhooks with the certificate
+// request and stores the responses in the template data.
+type x509Webhooks struct {
+	controller *webhookController
+	data       x509util.TemplateData
+}
+
+// CallWebhooks implements CertificateWebhook.
+func (o *x509Webhooks) CallWebhooks(cr *x509.CertificateRequest) error {
+	uris := make([]string, len(cr.URIs))
+	for i, u := range cr.URIs {
+		uris[i] = u.String()
+	}
+	return o.controller.call(X509WebhookCertType, o.data, &WebhookRequestBody{
+		Token: o.data[x509util.TokenKey],
+		X509CertificateRequest: &WebhookX509CertificateRequest{
+			Raw:                cr.Raw,
+			CommonName:         cr.Subject.CommonName,
+			DNSNames:           cr.DNSNames,
+			EmailAddresses:     cr.EmailAddresses,
+			IPAddresses:        cr.IPAddresses,
+			URIs:               uris,
+			PublicKeyAlgorithm: cr.PublicKeyAlgorithm.String(),
+		},
+	})
+}
+
+// sshWebhooks is a SignOption that calls the webhooks with the SSH
+// certificate request and stores the responses in the template data.
+type sshWebhooks struct {
+	controller *webhookController
+	data       sshutil.TemplateData
+}
+
+// CallSSHWebhooks implements SSHCertificateWebhook. The certificate type, key
+// id and principals default to the ones in the template data if they are not
+// in the request.
+func (o *sshWebhooks) CallSSHWebhooks(key ssh.PublicKey, opts SignSSHOptions) error {
+	cr := &WebhookSSHCertificateRequest{
+		Type:       opts.CertType,
+		KeyID:      opts.KeyID,
+		Principals: opts.Principals,
+	}
+	if key != nil {
+		cr.PublicKey = key.Marshal()
+	}
+	if v, ok := o.data[sshutil.TypeKey].(string); ok && cr.Type == "" {
+		cr.Type = v
+	}
+	if v, ok := o.data[sshutil.KeyIDKey].(string); ok && cr.KeyID == "" {
+		cr.KeyID = v
+	}
+	if v, ok := o.data[sshutil.PrincipalsKey].([]string); ok && len(cr.Principals) == 0 {
+		cr.Principals = v
+	}
+	return o.controller.call(SSHWebhookCertType, o.data, &WebhookRequestBody{
+		Token:                 o.data[sshutil.TokenKey],
+		SSHCertificateRequest: cr,
+	})
+}
@@ -0,0 +1,326 @@
+package provisioner
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"go.step.sm/crypto/keyutil"
+	"go.step.sm/crypto/sshutil"
+	"go.step.sm/crypto/x509util"
+	"golang.org/x/crypto/ssh"
+)
+
+var testWebhookSecret = []byte("super-secret-webhook-key")
+
+// newWebhookTestServer returns a TLS server that validates the signature of
+// the requests and responds depending on the name of the webhook.
+func newWebhookTestServer(t *testing.T) (*httptest.Server, chan *WebhookRequestBody) {
+	requests := make(chan *WebhookRequestBody, 10)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		assert.FatalError(t, err)
+
+		name := r.Header.Get(WebhookNameHeader)
+		if sig := r.Header.Get(WebhookSignatureHeader); sig != "" {
+			mac := hmac.New(sha256.New, testWebhookSecret)
+			mac.Write(b)
+			if sig != hex.EncodeToString(mac.Sum(nil)) {
+				http.Error(w, "bad signature", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		var body WebhookRequestBody
+		assert.FatalError(t, json.Unmarshal(b, &body))
+		requests <- &body
+
+		switch name {
+		case "deny":
+			w.Write([]byte(`{"allow":false}`))
+		case "error":
+			http.Error(w, "error", http.StatusInternalServerError)
+		case "bad-json":
+			w.Write([]byte(`{"allow":`))
+		case "slow":
+			time.Sleep(200 * time.Millisecond)
+			w.Write([]byte(`{"allow":true}`))
+		default:
+			w.Write([]byte(`{"allow":true,"data":{"owner":"` + name + `@example.com"}}`))
+		}
+	}))
+	return srv, requests
+}
+
+func TestWebhook_Validate(t *testing.T) {
+	secret := base64.StdEncoding.EncodeToString(testWebhookSecret)
+	tests := []struct {
+		name    string
+		webhook *Webhook
+		wantErr bool
+	}{
+		{"ok", &Webhook{Name: "cmdb", URL: "https://cmdb.example.com/webhook"}, false},
+		{"ok secret", &Webhook{Name: "cmdb", URL: "https://cmdb.example.com/webhook", Secret: secret}, false},
+		{"ok x509", &Webhook{Name: "cmdb", URL: "https://cmdb.example.com", CertType: "x509"}, false},
+		{"ok ssh", &Webhook{Name: "cmdb", URL: "https://cmdb.example.com", CertType: "ssh"}, false},
+		{"ok timeout", &Webhook{Name: "cmdb", URL: "https://cmdb.example.com", Timeout: &Duration{time.Second}}, false},
+		{"fail name", &Webhook{URL: "https://cmdb.example.com/webhook"}, true},
+		{"fail url", &Webhook{Name: "cmdb", URL: "%"}, true},
+		{"fail http", &Webhook{Name: "cmdb", URL: "http://cmdb.example.com/webhook"}, true},
+		{"fail relative", &Webhook{Name: "cmdb", URL: "/webhook"}, true},
+		{"fail certType", &Webhook{Name: "cmdb", URL: "https://cmdb.example.com", CertType: "foo"}, true},
+		{"fail secret", &Webhook{Name: "cmdb", URL: "https://cmdb.example.com", Secret: "%%%"}, true},
+		{"fail timeout", &Webhook{Name: "cmdb", URL: "https://cmdb.example.com", Timeout: &Duration{-time.Second}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.webhook.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Webhook.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWebhook_timeout(t *testing.T) {
+	assert.Equals(t, DefaultWebhookTimeout, (&Webhook{}).timeout())
+	assert.Equals(t, DefaultWebhookTimeout, (&Webhook{Timeout: &Duration{}}).timeout())
+	assert.Equals(t, time.Second, (&Webhook{Timeout: &Duration{time.Second}}).timeout())
+}
+
+func Test_newWebhookController(t *testing.T) {
+	client := &http.Client{}
+	w1 := &Webhook{Name: "w1", URL: "https://cmdb.example.com/w1"}
+	w2 := &Webhook{Name: "w2", URL: "https://cmdb.example.com/w2"}
+	type args struct {
+		o      *Options
+		client *http.Client
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    *webhookController
+		wantErr bool
+	}{
+		{"ok nil", args{nil, client}, nil, false},
+		{"ok empty", args{&Options{}, client}, nil, false},
+		{"ok", args{&Options{Webhooks: []*Webhook{w1, w2}}, client}, &webhookController{
+			provisionerName: "prov", webhooks: []*Webhook{w1, w2}, client: client, noTLSAuthClient: client,
+		}, false},
+		{"ok default client", args{&Options{Webhooks: []*Webhook{w1}}, nil}, &webhookController{
+			provisionerName: "prov", webhooks: []*Webhook{w1}, client: http.DefaultClient, noTLSAuthClient: http.DefaultClient,
+		}, false},
+		{"fail validate", args{&Options{Webhooks: []*Webhook{{Name: "w3"}}}, client}, nil, true},
+		{"fail duplicated", args{&Options{Webhooks: []*Webhook{w1, w1}}, client}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newWebhookController("prov", tt.args.o, tt.args.client)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("newWebhookController() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newWebhookController() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_withoutTLSClientAuth(t *testing.T) {
+	getClientCertificate := func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		return &tls.Certificate{}, nil
+	}
+	client := &http.Client{
+		Timeout: time.Minute,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs:              x509.NewCertPool(),
+				Certificates:         []tls.Certificate{{}},
+				GetClientCertificate: getClientCertificate,
+			},
+		},
+	}
+
+	got := withoutTLSClientAuth(client)
+	assert.Equals(t, time.Minute, got.Timeout)
+	tr := got.Transport.(*http.Transport)
+	assert.Equals(t, client.Transport.(*http.Transport).TLSClientConfig.RootCAs, tr.TLSClientConfig.RootCAs)
+	assert.Nil(t, tr.TLSClientConfig.Certificates)
+	assert.Nil(t, tr.TLSClientConfig.GetClientCertificate)
+
+	// The original client is not modified.
+	assert.Len(t, 1, client.Transport.(*http.Transport).TLSClientConfig.Certificates)
+	assert.NotNil(t, client.Transport.(*http.Transport).TLSClientConfig.GetClientCertificate)
+
+	// Clients without TLS configuration are returned as they are.
+	assert.Equals(t, http.DefaultClient, withoutTLSClientAuth(http.DefaultClient))
+}
+
+func Test_x509Webhooks_CallWebhooks(t *testing.T) {
+	srv, requests := newWebhookTestServer(t)
+	defer srv.Close()
+
+	secret := base64.StdEncoding.EncodeToString(testWebhookSecret)
+	csr := &x509.CertificateRequest{
+		Raw:                []byte("raw"),
+		Subject:            pkix.Name{CommonName: "foo.example.com"},
+		DNSNames:           []string{"foo.example.com"},
+		IPAddresses:        []net.IP{net.ParseIP("10.0.0.1")},
+		EmailAddresses:     []string{"foo@example.com"},
+		URIs:               []*url.URL{{Scheme: "spiffe", Host: "example.com", Path: "/foo"}},
+		PublicKeyAlgorithm: x509.ECDSA,
+	}
+	newWebhook := func(name, certType string) *Webhook {
+		return &Webhook{Name: name, URL: srv.URL, CertType: certType, Secret: secret, Timeout: &Duration{100 * time.Millisecond}}
+	}
+
+	tests := []struct {
+		name     string
+		webhooks []*Webhook
+		want     map[string]interface{}
+		calls    int
+		wantErr  bool
+	}{
+		{"ok", []*Webhook{newWebhook("cmdb", "")}, map[string]interface{}{
+			"cmdb": map[string]interface{}{"owner": "cmdb@example.com"},
+		}, 1, false},
+		{"ok multiple", []*Webhook{newWebhook("cmdb", ""), newWebhook("inventory", "x509")}, map[string]interface{}{
+			"cmdb":      map[string]interface{}{"owner": "cmdb@example.com"},
+			"inventory": map[string]interface{}{"owner": "inventory@example.com"},
+		}, 2, false},
+		{"ok skip ssh", []*Webhook{newWebhook("cmdb", ""), newWebhook("ssh", "ssh")}, map[string]interface{}{
+			"cmdb": map[string]interface{}{"owner": "cmdb@example.com"},
+		}, 1, false},
+		{"ok without data", []*Webhook{{Name: "slow", URL: srv.URL}}, map[string]interface{}{
+			"slow": nil,
+		}, 1, false},
+		{"fail deny", []*Webhook{newWebhook("cmdb", ""), newWebhook("deny", "")}, nil, 2, true},
+		{"fail status", []*Webhook{newWebhook("error", "")}, nil, 1, true},
+		{"fail json", []*Webhook{newWebhook("bad-json", "")}, nil, 1, true},
+		{"fail signature", []*Webhook{{Name: "cmdb", URL: srv.URL, Secret: base64.StdEncoding.EncodeToString([]byte("foo"))}}, nil, 0, true},
+		{"fail timeout", []*Webhook{newWebhook("slow", "")}, nil, 1, true},
+		{"fail tls", []*Webhook{newWebhook("cmdb", "")}, nil, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := srv.Client()
+			if tt.name == "fail tls" {
+				client = http.DefaultClient
+			}
+			c, err := newWebhookController("prov", &Options{Webhooks: tt.webhooks}, client)
+			assert.FatalError(t, err)
+
+			data := x509util.CreateTemplateData("foo.example.com", []string{"foo.example.com"})
+			data.SetToken(map[string]interface{}{"sub": "foo.example.com"})
+			err = c.x509Option(data).CallWebhooks(csr)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("x509Webhooks.CallWebhooks() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			for i := 0; i < tt.calls; i++ {
+				body := <-requests
+				assert.Equals(t, "prov", body.ProvisionerName)
+				assert.Equals(t, map[string]interface{}{"sub": "foo.example.com"}, body.Token)
+				assert.Nil(t, body.SSHCertificateRequest)
+				assert.Equals(t, &WebhookX509CertificateRequest{
+					Raw:                []byte("raw"),
+					CommonName:         "foo.example.com",
+					DNSNames:           []string{"foo.example.com"},
+					EmailAddresses:     []string{"foo@example.com"},
+					IPAddresses:        []net.IP{net.ParseIP("10.0.0.1")},
+					URIs:               []string{"spiffe://example.com/foo"},
+					PublicKeyAlgorithm: "ECDSA",
+				}, body.X509CertificateRequest)
+				assert.False(t, body.Timestamp.IsZero())
+			}
+			assert.Len(t, 0, requests)
+
+			if tt.wantErr {
+				return
+			}
+			if tt.want == nil {
+				_, ok := data[WebhooksTemplateKey]
+				assert.False(t, ok)
+			} else {
+				assert.Equals(t, tt.want, data[WebhooksTemplateKey])
+			}
+		})
+	}
+}
+
+func Test_sshWebhooks_CallSSHWebhooks(t *testing.T) {
+	srv, requests := newWebhookTestServer(t)
+	defer srv.Close()
+
+	pub, _, err := keyutil.GenerateDefaultKeyPair()
+	assert.FatalError(t, err)
+	key, err := ssh.NewPublicKey(pub)
+	assert.FatalError(t, err)
+
+	c, err := newWebhookController("prov", &Options{Webhooks: []*Webhook{
+		{Name: "cmdb", URL: srv.URL, CertType: "ssh"},
+		{Name: "x509", URL: srv.URL, CertType: "x509"},
+	}}, srv.Client())
+	assert.FatalError(t, err)
+
+	type args struct {
+		opts SignSSHOptions
+	}
+	tests := []struct {
+		name string
+		args args
+		want *WebhookSSHCertificateRequest
+	}{
+		{"ok", args{SignSSHOptions{CertType: "user", KeyID: "foo@example.com", Principals: []string{"foo"}}}, &WebhookSSHCertificateRequest{
+			PublicKey: key.Marshal(), Type: "user", KeyID: "foo@example.com", Principals: []string{"foo"},
+		}},
+		{"ok template data", args{SignSSHOptions{}}, &WebhookSSHCertificateRequest{
+			PublicKey: key.Marshal(), Type: "host", KeyID: "foo.internal", Principals: []string{"foo.internal", "10.0.0.1"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := sshutil.CreateTemplateData(sshutil.HostCert, "foo.internal", []string{"foo.internal", "10.0.0.1"})
+			data.SetToken("token")
+			if err := c.sshOption(data).CallSSHWebhooks(key, tt.args.opts); err != nil {
+				t.Errorf("sshWebhooks.CallSSHWebhooks() error = %v", err)
+				return
+			}
+			body := <-requests
+			assert.Len(t, 0, requests)
+			assert.Equals(t, "prov", body.ProvisionerName)
+			assert.Equals(t, "token", body.Token)
+			assert.Nil(t, body.X509CertificateRequest)
+			assert.Equals(t, tt.want, body.SSHCertificateRequest)
+			assert.Equals(t, map[string]interface{}{
+				"cmdb": map[string]interface{}{"owner": "cmdb@example.com"},
+			}, data[WebhooksTemplateKey])
+		})
+	}
+}
+
+func Test_webhookController_nil(t *testing.T) {
+	var c *webhookController
+	x509Data := x509util.NewTemplateData()
+	assert.FatalError(t, c.x509Option(x509Data).CallWebhooks(&x509.CertificateRequest{}))
+	assert.Equals(t, x509util.NewTemplateData(), x509Data)
+
+	sshData := sshutil.CreateTemplateData(sshutil.UserCert, "foo", []string{"foo"})
+	assert.FatalError(t, c.sshOption(sshData).CallSSHWebhooks(nil, SignSSHOptions{}))
+	assert.Equals(t, sshutil.CreateTemplateData(sshutil.UserCert, "foo", []string{"foo"}), sshData)
+}
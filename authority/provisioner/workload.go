@@ -0,0 +1,467 @@
+package provisioner
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/Masterminds/sprig/v3"
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/policy"
+	"github.com/smallstep/certificates/errs"
+	"go.step.sm/crypto/jose"
+	"go.step.sm/crypto/sshutil"
+	"go.step.sm/crypto/x509util"
+)
+
+// defaultWorkloadSubject is the template used for the subject if none is
+// configured.
+const defaultWorkloadSubject = "{{ .sub }}"
+
+// ClaimMatcher is a condition that a claim of a token must satisfy. A matcher
+// with multiple conditions requires all of them. If the claim is an array, at
+// least one of its elements must satisfy the conditions.
+type ClaimMatcher struct {
+	// Claim is the name of the claim, nested claims can be accessed using
+	// dots, e.g. "github.ref".
+	Claim string `json:"claim"`
+
+	// Equals is a list of values, the claim must be equal to one of them.
+	Equals []string `json:"equals,omitempty"`
+
+	// Prefixes is a list of prefixes, the claim must start with one of them.
+	Prefixes []string `json:"prefixes,omitempty"`
+
+	// Regex is a regular expression that must match the whole claim.
+	Regex string `json:"regex,omitempty"`
+
+	regex *regexp.Regexp
+}
+
+// Validate validates and initializes the claim matcher.
+func (m *ClaimMatcher) Validate() (err error) {
+	if m.Claim == "" {
+		return errors.New("claim cannot be empty")
+	}
+	if len(m.Equals) == 0 && len(m.Prefixes) == 0 && m.Regex == "" {
+		return errors.Errorf("claim %s must have equals, prefixes or regex", m.Claim)
+	}
+	if m.Regex != "" {
+		if m.regex, err = regexp.Compile("^(?:" + m.Regex + ")$"); err != nil {
+			return errors.Wrapf(err, "claim %s regex is not valid", m.Claim)
+		}
+	}
+	return nil
+}
+
+// Match returns an error if the claims do not satisfy the matcher.
+func (m *ClaimMatcher) Match(claims map[string]interface{}) error {
+	v, ok := lookupClaim(claims, m.Claim)
+	if !ok {
+		return errors.Errorf("claim %s not found", m.Claim)
+	}
+	values, ok := v.([]interface{})
+	if !ok {
+		values = []interface{}{v}
+	}
+	for _, v := range values {
+		if s, ok := claimToString(v); ok && m.matchString(s) {
+			return nil
+		}
+	}
+	return errors.Errorf("claim %s does not match the required values", m.Claim)
+}
+
+func (m *ClaimMatcher) matchString(s string) bool {
+	if len(m.Equals) > 0 && !containsString(m.Equals, s) {
+		return false
+	}
+	if len(m.Prefixes) > 0 {
+		var found bool
+		for _, p := range m.Prefixes {
+			if strings.HasPrefix(s, p) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if m.regex != nil && !m.regex.MatchString(s) {
+		return false
+	}
+	return true
+}
+
+// lookupClaim returns the value of the claim with the given name. If the claim
+// does not exist, it will try to find it as a nested claim.
+func lookupClaim(claims map[string]interface{}, name string) (interface{}, bool) {
+	if v, ok := claims[name]; ok {
+		return v, true
+	}
+	parts := strings.SplitN(name, ".", 2)
+	if len(parts) != 2 {
+		return nil, false
+	}
+	if m, ok := claims[parts[0]].(map[string]interface{}); ok {
+		return lookupClaim(m, parts[1])
+	}
+	return nil, false
+}
+
+// claimToString returns the string representation of strings, numbers and
+// booleans.
+func claimToString(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	default:
+		return "", false
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Workload represents a provisioner that trusts the JWTs of a workload
+// identity issuer, like GitHub Actions, GitLab CI, SPIRE or Vault. The keys of
+// the issuer are discovered using the OpenID Connect configuration endpoint of
+// the issuer, or they can be set using a JWK set URL.
+//
+// The subject, SANs and SSH principals of the certificates are generated from
+// the claims of the token using Go templates, e.g. "{{ .repository }}".
+type Workload struct {
+	*base
+	ID            string          `json:"-"`
+	Type          string          `json:"type"`
+	Name          string          `json:"name"`
+	Issuer        string          `json:"issuer"`
+	Audience      string          `json:"audience"`
+	JWKSetURI     string          `json:"jwksURI,omitempty"`
+	RequireClaims []*ClaimMatcher `json:"requireClaims,omitempty"`
+	Subject       string          `json:"subject,omitempty"`
+	SANs          []string        `json:"sans,omitempty"`
+	Principals    []string        `json:"principals,omitempty"`
+	Claims        *Claims         `json:"claims,omitempty"`
+	Options       *Options        `json:"options,omitempty"`
+	keyStore      *keyStore
+	subject       *template.Template
+	sans          []*template.Template
+	principals    []*template.Template
+	claimer       *Claimer
+	namePolicy    *policy.Engine
+	webhooks      *webhookController
+}
+
+// GetID returns the provisioner unique identifier.
+func (p *Workload) GetID() string {
+	if p.ID != "" {
+		return p.ID
+	}
+	return p.GetIDForToken()
+}
+
+// GetIDForToken returns an identifier that will be used to load the provisioner
+// from a token. The identifier is the issuer and the audience separated by a
+// "#".
+func (p *Workload) GetIDForToken() string {
+	return p.Issuer + "#" + p.Audience
+}
+
+// GetTokenID returns the identifier of the token.
+func (p *Workload) GetTokenID(ott string) (string, error) {
+	token, err := jose.ParseSigned(ott)
+	if err != nil {
+		return "", errors.Wrap(err, "error parsing token")
+	}
+	var claims jose.Claims
+	if err := token.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		return "", errors.Wrap(err, "error verifying claims")
+	}
+	return claims.ID, nil
+}
+
+// GetName returns the name of the provisioner.
+func (p *Workload) GetName() string {
+	return p.Name
+}
+
+// GetType returns the type of provisioner.
+func (p *Workload) GetType() Type {
+	return TypeWorkload
+}
+
+// GetEncryptedKey is not available in a Workload provisioner.
+func (p *Workload) GetEncryptedKey() (kid string, key string, ok bool) {
+	return "", "", false
+}
+
+// Init validates and initializes the Workload provisioner.
+func (p *Workload) Init(config Config) (err error) {
+	switch {
+	case p.Type == "":
+		return errors.New("provisioner type cannot be empty")
+	case p.Name == "":
+		return errors.New("provisioner name cannot be empty")
+	case p.Issuer == "":
+		return errors.New("provisioner issuer cannot be empty")
+	case p.Audience == "":
+		return errors.New("provisioner audience cannot be empty")
+	}
+
+	for _, m := range p.RequireClaims {
+		if err := m.Validate(); err != nil {
+			return errors.Wrap(err, "error parsing requireClaims")
+		}
+	}
+
+	// Parse the templates used to map the claims.
+	subject := p.Subject
+	if subject == "" {
+		subject = defaultWorkloadSubject
+	}
+	if p.subject, err = parseWorkloadTemplate("subject", subject); err != nil {
+		return err
+	}
+	p.sans = make([]*template.Template, len(p.SANs))
+	for i, s := range p.SANs {
+		if p.sans[i], err = parseWorkloadTemplate("sans", s); err != nil {
+			return err
+		}
+	}
+	p.principals = make([]*template.Template, len(p.Principals))
+	for i, s := range p.Principals {
+		if p.principals[i], err = parseWorkloadTemplate("principals", s); err != nil {
+			return err
+		}
+	}
+
+	// Update claims with global ones
+	if p.claimer, err = NewClaimer(p.Claims, config.Claims); err != nil {
+		return err
+	}
+	if p.namePolicy, err = newNamePolicyEngine(p.Options); err != nil {
+		return err
+	}
+	if p.webhooks, err = newWebhookController(p.Name, p.Options, config.WebhookClient); err != nil {
+		return err
+	}
+
+	// Discover the JWK set of the issuer if it is not configured.
+	jwksURI := p.JWKSetURI
+	if jwksURI == "" {
+		if jwksURI, err = discoverJWKSetURI(p.Issuer); err != nil {
+			return err
+		}
+	}
+	p.keyStore, err = newKeyStore(jwksURI)
+	return err
+}
+
+func parseWorkloadTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(sprig.TxtFuncMap()).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing %s template", name)
+	}
+	return tmpl, nil
+}
+
+func executeWorkloadTemplate(tmpl *template.Template, claims map[string]interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, claims); err != nil {
+		return "", errors.Wrapf(err, "error executing %s template", tmpl.Name())
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// workloadPayload contains the claims of a workload token.
+type workloadPayload struct {
+	jose.Claims
+	claims map[string]interface{}
+}
+
+// authorizeToken validates the token and the required claims and returns the
+// claims in it.
+func (p *Workload) authorizeToken(token string) (*workloadPayload, error) {
+	jwt, err := jose.ParseSigned(token)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err,
+			"workload.authorizeToken; error parsing workload token")
+	}
+
+	var (
+		found   bool
+		payload workloadPayload
+	)
+	kid := jwt.Headers[0].KeyID
+	for _, key := range p.keyStore.Get(kid) {
+		if err := jwt.Claims(key, &payload.Claims, &payload.claims); err == nil {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, errs.Unauthorized("workload.authorizeToken; cannot validate workload token")
+	}
+
+	// According to "rfc7519 JSON Web Token" acceptable skew should be no more
+	// than a few minutes.
+	if err := payload.ValidateWithLeeway(jose.Expected{
+		Issuer:   p.Issuer,
+		Audience: jose.Audience{p.Audience},
+		Time:     time.Now().UTC(),
+	}, time.Minute); err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "workload.authorizeToken; invalid workload token claims")
+	}
+	if payload.Expiry == nil {
+		return nil, errs.Unauthorized("workload.authorizeToken; workload token must have an expiration")
+	}
+
+	for _, m := range p.RequireClaims {
+		if err := m.Match(payload.claims); err != nil {
+			return nil, errs.Wrap(http.StatusUnauthorized, err, "workload.authorizeToken; invalid workload token claims")
+		}
+	}
+
+	return &payload, nil
+}
+
+// mapClaims returns the subject and the SANs or principals generated with the
+// given templates.
+func (p *Workload) mapClaims(claims map[string]interface{}, templates []*template.Template) (string, []string, error) {
+	subject, err := executeWorkloadTemplate(p.subject, claims)
+	if err != nil {
+		return "", nil, err
+	}
+	if subject == "" {
+		return "", nil, errors.New("subject template generated an empty subject")
+	}
+	var names []string
+	for _, tmpl := range templates {
+		name, err := executeWorkloadTemplate(tmpl, claims)
+		if err != nil {
+			return "", nil, err
+		}
+		// Empty values are skipped, this allows to use conditionals.
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return subject, names, nil
+}
+
+// AuthorizeSign validates the given token and returns the sign options with
+// the subject and SANs generated from the token claims.
+func (p *Workload) AuthorizeSign(ctx context.Context, token string) ([]SignOption, error) {
+	claims, err := p.authorizeToken(token)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "workload.AuthorizeSign")
+	}
+
+	subject, sans, err := p.mapClaims(claims.claims, p.sans)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "workload.AuthorizeSign")
+	}
+
+	// Certificate templates
+	data := x509util.CreateTemplateData(subject, sans)
+	if v, err := unsafeParseSigned(token); err == nil {
+		data.SetToken(v)
+	}
+
+	templateOptions, err := CustomTemplateOptions(p.Options, data, x509util.DefaultLeafTemplate)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "workload.AuthorizeSign")
+	}
+
+	return []SignOption{
+		templateOptions,
+		p.webhooks.x509Option(data),
+		// modifiers / withOptions
+		newProvisionerExtensionOption(TypeWorkload, p.Name, p.Issuer),
+		profileDefaultDuration(p.claimer.DefaultTLSCertDuration()),
+		// validators
+		defaultPublicKeyValidator{},
+		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
+		newX509NamePolicyValidator(p.namePolicy),
+	}, nil
+}
+
+// AuthorizeRenew returns an error if the renewal is disabled.
+func (p *Workload) AuthorizeRenew(ctx context.Context, cert *x509.Certificate) error {
+	if p.claimer.IsDisableRenewal() {
+		return errs.Unauthorized("workload.AuthorizeRenew; renew is disabled for workload provisioner '%s'", p.GetName())
+	}
+	return nil
+}
+
+// AuthorizeSSHSign validates the given token and returns the sign options for
+// an SSH user certificate with the key id and principals generated from the
+// token claims.
+func (p *Workload) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption, error) {
+	if !p.claimer.IsSSHCAEnabled() {
+		return nil, errs.Unauthorized("workload.AuthorizeSSHSign; sshCA is disabled for workload provisioner '%s'", p.GetName())
+	}
+	claims, err := p.authorizeToken(token)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "workload.AuthorizeSSHSign")
+	}
+
+	keyID, principals, err := p.mapClaims(claims.claims, p.principals)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "workload.AuthorizeSSHSign")
+	}
+	if len(p.principals) == 0 {
+		principals = []string{keyID}
+	} else if len(principals) == 0 {
+		return nil, errs.Unauthorized("workload.AuthorizeSSHSign; principals templates generated no principals")
+	}
+
+	// Certificate templates.
+	data := sshutil.CreateTemplateData(sshutil.UserCert, keyID, principals)
+	if v, err := unsafeParseSigned(token); err == nil {
+		data.SetToken(v)
+	}
+
+	templateOptions, err := CustomSSHTemplateOptions(p.Options, data, sshutil.DefaultTemplate)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "workload.AuthorizeSSHSign")
+	}
+	signOptions := []SignOption{templateOptions, p.webhooks.sshOption(data)}
+
+	return append(signOptions,
+		// Only user certificates with the mapped principals are allowed.
+		sshCertOptionsValidator(SignSSHOptions{
+			CertType:   SSHUserCert,
+			KeyID:      keyID,
+			Principals: principals,
+		}),
+		// Set the validity bounds if not set.
+		&sshDefaultDuration{p.claimer},
+		// Validate public key
+		&sshDefaultPublicKeyValidator{},
+		// Validate the validity period.
+		&sshCertValidityValidator{p.claimer},
+		// Require and validate all the default fields in the SSH certificate.
+		&sshCertDefaultValidator{},
+		// Validate the principals with the name-constraint policy.
+		newSSHNamePolicyValidator(p.namePolicy),
+	), nil
+}
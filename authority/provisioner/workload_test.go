@@ -0,0 +1,426 @@
+package provisioner
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"net/http"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/errs"
+	"go.step.sm/crypto/jose"
+	"golang.org/x/crypto/ssh"
+)
+
+func generateWorkloadToken(iss, aud string, claims map[string]interface{}, iat time.Time, jwk *jose.JSONWebKey) (string, error) {
+	so := new(jose.SignerOptions)
+	so.WithType("JWT")
+	so.WithHeader("kid", jwk.KeyID)
+	sig, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: jwk.Key}, so)
+	if err != nil {
+		return "", err
+	}
+	std := jose.Claims{
+		ID:        "the-jti",
+		Issuer:    iss,
+		Audience:  []string{aud},
+		IssuedAt:  jose.NewNumericDate(iat),
+		NotBefore: jose.NewNumericDate(iat),
+		Expiry:    jose.NewNumericDate(iat.Add(5 * time.Minute)),
+	}
+	return jose.Signed(sig).Claims(std).Claims(claims).CompactSerialize()
+}
+
+func generateWorkload(srv string) *Workload {
+	return &Workload{
+		Type:      "Workload",
+		Name:      "github",
+		Issuer:    "https://token.actions.githubusercontent.com",
+		Audience:  "https://ca.example.com/workload",
+		JWKSetURI: srv + "/jwks_uri",
+		RequireClaims: []*ClaimMatcher{
+			{Claim: "repository", Equals: []string{"smallstep/certificates"}},
+			{Claim: "ref", Prefixes: []string{"refs/heads/", "refs/tags/"}},
+		},
+		Subject:    "{{ .repository }}",
+		SANs:       []string{"spiffe://example.com/{{ .repository }}", `{{ if hasKey . "environment" }}{{ .environment }}.example.com{{ end }}`},
+		Principals: []string{"{{ .repository_owner }}", "{{ .actor }}"},
+	}
+}
+
+func TestWorkload_Getters(t *testing.T) {
+	p := &Workload{Name: "name", Issuer: "https://issuer.example.com", Audience: "the-audience"}
+	assert.Equals(t, "https://issuer.example.com#the-audience", p.GetID())
+	assert.Equals(t, "https://issuer.example.com#the-audience", p.GetIDForToken())
+	assert.Equals(t, "name", p.GetName())
+	assert.Equals(t, TypeWorkload, p.GetType())
+	kid, key, ok := p.GetEncryptedKey()
+	assert.Equals(t, "", kid)
+	assert.Equals(t, "", key)
+	assert.False(t, ok)
+	p.ID = "the-id"
+	assert.Equals(t, "the-id", p.GetID())
+}
+
+func TestWorkload_Init(t *testing.T) {
+	srv := generateJWKServer(2)
+	defer srv.Close()
+
+	config := Config{Claims: globalProvisionerClaims}
+	tests := []struct {
+		name    string
+		p       *Workload
+		wantErr bool
+	}{
+		{"ok", generateWorkload(srv.URL), false},
+		{"ok discovery", &Workload{Type: "Workload", Name: "name", Issuer: srv.URL + "/workload", Audience: "aud"}, false},
+		{"fail type", &Workload{Name: "name", Issuer: srv.URL + "/workload", Audience: "aud"}, true},
+		{"fail name", &Workload{Type: "Workload", Issuer: srv.URL + "/workload", Audience: "aud"}, true},
+		{"fail issuer", &Workload{Type: "Workload", Name: "name", Audience: "aud"}, true},
+		{"fail audience", &Workload{Type: "Workload", Name: "name", Issuer: srv.URL + "/workload"}, true},
+		{"fail discovery issuer", &Workload{Type: "Workload", Name: "name", Issuer: srv.URL, Audience: "aud"}, true},
+		{"fail discovery", &Workload{Type: "Workload", Name: "name", Issuer: srv.URL + "/error", Audience: "aud"}, true},
+		{"fail jwksURI", &Workload{Type: "Workload", Name: "name", Issuer: srv.URL, Audience: "aud", JWKSetURI: srv.URL + "/error"}, true},
+		{"fail requireClaims", &Workload{Type: "Workload", Name: "name", Issuer: srv.URL, Audience: "aud", JWKSetURI: srv.URL + "/jwks_uri", RequireClaims: []*ClaimMatcher{{Claim: "sub"}}}, true},
+		{"fail subject", &Workload{Type: "Workload", Name: "name", Issuer: srv.URL, Audience: "aud", JWKSetURI: srv.URL + "/jwks_uri", Subject: "{{ .sub"}, true},
+		{"fail sans", &Workload{Type: "Workload", Name: "name", Issuer: srv.URL, Audience: "aud", JWKSetURI: srv.URL + "/jwks_uri", SANs: []string{"{{ .sub"}}, true},
+		{"fail principals", &Workload{Type: "Workload", Name: "name", Issuer: srv.URL, Audience: "aud", JWKSetURI: srv.URL + "/jwks_uri", Principals: []string{"{{ .sub"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.p.Init(config); (err != nil) != tt.wantErr {
+				t.Errorf("Workload.Init() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestClaimMatcher_Match(t *testing.T) {
+	claims := map[string]interface{}{
+		"repository": "smallstep/certificates",
+		"ref":        "refs/heads/master",
+		"run_number": float64(42),
+		"groups":     []interface{}{"admin", "dev"},
+		"github": map[string]interface{}{
+			"event_name": "push",
+		},
+	}
+	tests := []struct {
+		name    string
+		m       *ClaimMatcher
+		wantErr bool
+	}{
+		{"ok equals", &ClaimMatcher{Claim: "repository", Equals: []string{"foo/bar", "smallstep/certificates"}}, false},
+		{"ok prefixes", &ClaimMatcher{Claim: "ref", Prefixes: []string{"refs/heads/"}}, false},
+		{"ok regex", &ClaimMatcher{Claim: "ref", Regex: "refs/(heads|tags)/.+"}, false},
+		{"ok number", &ClaimMatcher{Claim: "run_number", Equals: []string{"42"}}, false},
+		{"ok array", &ClaimMatcher{Claim: "groups", Equals: []string{"dev"}}, false},
+		{"ok nested", &ClaimMatcher{Claim: "github.event_name", Equals: []string{"push"}}, false},
+		{"ok all", &ClaimMatcher{Claim: "ref", Equals: []string{"refs/heads/master"}, Prefixes: []string{"refs/"}, Regex: ".*master"}, false},
+		{"fail equals", &ClaimMatcher{Claim: "repository", Equals: []string{"smallstep/cli"}}, true},
+		{"fail prefixes", &ClaimMatcher{Claim: "ref", Prefixes: []string{"refs/tags/"}}, true},
+		{"fail regex", &ClaimMatcher{Claim: "ref", Regex: "heads"}, true},
+		{"fail all", &ClaimMatcher{Claim: "ref", Equals: []string{"refs/heads/master"}, Prefixes: []string{"refs/tags/"}}, true},
+		{"fail array", &ClaimMatcher{Claim: "groups", Equals: []string{"ops"}}, true},
+		{"fail missing", &ClaimMatcher{Claim: "environment", Equals: []string{"prod"}}, true},
+		{"fail type", &ClaimMatcher{Claim: "github", Equals: []string{"push"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.FatalError(t, tt.m.Validate())
+			if err := tt.m.Match(claims); (err != nil) != tt.wantErr {
+				t.Errorf("ClaimMatcher.Match() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestClaimMatcher_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		m       *ClaimMatcher
+		wantErr bool
+	}{
+		{"ok", &ClaimMatcher{Claim: "sub", Equals: []string{"foo"}}, false},
+		{"fail claim", &ClaimMatcher{Equals: []string{"foo"}}, true},
+		{"fail conditions", &ClaimMatcher{Claim: "sub"}, true},
+		{"fail regex", &ClaimMatcher{Claim: "sub", Regex: "("}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.m.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("ClaimMatcher.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWorkload_AuthorizeSign(t *testing.T) {
+	srv := generateJWKServer(2)
+	defer srv.Close()
+
+	var keys jose.JSONWebKeySet
+	assert.FatalError(t, getAndDecode(srv.URL+"/private", &keys))
+
+	p := generateWorkload(srv.URL)
+	assert.FatalError(t, p.Init(Config{Claims: globalProvisionerClaims}))
+
+	claims := map[string]interface{}{
+		"sub":              "repo:smallstep/certificates:ref:refs/heads/master",
+		"repository":       "smallstep/certificates",
+		"repository_owner": "smallstep",
+		"actor":            "maraino",
+		"ref":              "refs/heads/master",
+	}
+	ok, err := generateWorkloadToken(p.Issuer, p.Audience, claims, time.Now(), &keys.Keys[0])
+	assert.FatalError(t, err)
+	failAudience, err := generateWorkloadToken(p.Issuer, "https://ca.example.com/1.0/sign", claims, time.Now(), &keys.Keys[0])
+	assert.FatalError(t, err)
+	failIssuer, err := generateWorkloadToken("https://gitlab.com", p.Audience, claims, time.Now(), &keys.Keys[0])
+	assert.FatalError(t, err)
+	failExpired, err := generateWorkloadToken(p.Issuer, p.Audience, claims, time.Now().Add(-time.Hour), &keys.Keys[0])
+	assert.FatalError(t, err)
+	badKey, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+	failKey, err := generateWorkloadToken(p.Issuer, p.Audience, claims, time.Now(), badKey)
+	assert.FatalError(t, err)
+	failRepository, err := generateWorkloadToken(p.Issuer, p.Audience, map[string]interface{}{
+		"repository": "smallstep/cli", "ref": "refs/heads/master",
+	}, time.Now(), &keys.Keys[1])
+	assert.FatalError(t, err)
+	failRef, err := generateWorkloadToken(p.Issuer, p.Audience, map[string]interface{}{
+		"repository": "smallstep/certificates", "ref": "refs/pull/1/merge",
+	}, time.Now(), &keys.Keys[1])
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name    string
+		token   string
+		code    int
+		wantErr bool
+	}{
+		{"ok", ok, http.StatusOK, false},
+		{"fail audience", failAudience, http.StatusUnauthorized, true},
+		{"fail issuer", failIssuer, http.StatusUnauthorized, true},
+		{"fail expired", failExpired, http.StatusUnauthorized, true},
+		{"fail key", failKey, http.StatusUnauthorized, true},
+		{"fail repository", failRepository, http.StatusUnauthorized, true},
+		{"fail ref", failRef, http.StatusUnauthorized, true},
+		{"fail token", "foobarzar", http.StatusUnauthorized, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p.AuthorizeSign(context.Background(), tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Workload.AuthorizeSign() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, sc.StatusCode(), tt.code)
+				assert.Nil(t, got)
+				return
+			}
+			assert.Len(t, 7, got)
+			for _, o := range got {
+				switch v := o.(type) {
+				case certificateOptionsFunc:
+				case *x509Webhooks:
+					assert.Equals(t, v.controller, p.webhooks)
+				case *provisionerExtensionOption:
+					assert.Equals(t, v.Type, int(TypeWorkload))
+					assert.Equals(t, v.Name, p.Name)
+					assert.Equals(t, v.CredentialID, p.Issuer)
+					assert.Len(t, 0, v.KeyValuePairs)
+				case profileDefaultDuration:
+					assert.Equals(t, time.Duration(v), p.claimer.DefaultTLSCertDuration())
+				case defaultPublicKeyValidator:
+				case *validityValidator:
+					assert.Equals(t, v.min, p.claimer.MinTLSCertDuration())
+					assert.Equals(t, v.max, p.claimer.MaxTLSCertDuration())
+				case *x509NamePolicyValidator:
+					assert.Equals(t, v.policy, p.namePolicy)
+				default:
+					assert.FatalError(t, errors.Errorf("unexpected sign option of type %T", v))
+				}
+			}
+		})
+	}
+}
+
+func TestWorkload_mapClaims(t *testing.T) {
+	mustParse := func(text string) *template.Template {
+		tmpl, err := parseWorkloadTemplate("test", text)
+		assert.FatalError(t, err)
+		return tmpl
+	}
+	claims := map[string]interface{}{
+		"sub":        "the-subject",
+		"repository": "smallstep/certificates",
+		"empty":      "",
+	}
+	type fields struct {
+		subject   string
+		templates []string
+	}
+	tests := []struct {
+		name        string
+		fields      fields
+		wantSubject string
+		wantNames   []string
+		wantErr     bool
+	}{
+		{"ok", fields{"{{ .sub }}", nil}, "the-subject", nil, false},
+		{"ok names", fields{"{{ .repository }}", []string{"{{ .sub }}.example.com", " {{ .repository | upper }} "}}, "smallstep/certificates", []string{"the-subject.example.com", "SMALLSTEP/CERTIFICATES"}, false},
+		{"ok skip empty", fields{"{{ .sub }}", []string{"{{ .empty }}", "{{ if .empty }}foo{{ end }}", "bar"}}, "the-subject", []string{"bar"}, false},
+		{"fail empty subject", fields{"{{ .empty }}", nil}, "", nil, true},
+		{"fail missing subject", fields{"{{ .missing }}", nil}, "", nil, true},
+		{"ok optional", fields{"{{ .sub }}", []string{`{{ if hasKey . "missing" }}{{ .missing }}{{ end }}`, `{{ get . "missing" }}`}}, "the-subject", nil, false},
+		{"fail missing name", fields{"{{ .sub }}", []string{"{{ .missing }}"}}, "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Workload{subject: mustParse(tt.fields.subject)}
+			var templates []*template.Template
+			for _, s := range tt.fields.templates {
+				templates = append(templates, mustParse(s))
+			}
+			subject, names, err := p.mapClaims(claims, templates)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Workload.mapClaims() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.Equals(t, tt.wantSubject, subject)
+			assert.Equals(t, tt.wantNames, names)
+		})
+	}
+}
+
+func TestWorkload_AuthorizeSSHSign(t *testing.T) {
+	srv := generateJWKServer(2)
+	defer srv.Close()
+
+	var keys jose.JSONWebKeySet
+	assert.FatalError(t, getAndDecode(srv.URL+"/private", &keys))
+
+	p1 := generateWorkload(srv.URL)
+	assert.FatalError(t, p1.Init(Config{Claims: globalProvisionerClaims}))
+	p2 := generateWorkload(srv.URL)
+	p2.Principals = nil
+	assert.FatalError(t, p2.Init(Config{Claims: globalProvisionerClaims}))
+	p3 := generateWorkload(srv.URL)
+	disable := false
+	p3.Claims = &Claims{EnableSSHCA: &disable}
+	assert.FatalError(t, p3.Init(Config{Claims: globalProvisionerClaims}))
+
+	claims := map[string]interface{}{
+		"repository":       "smallstep/certificates",
+		"repository_owner": "smallstep",
+		"actor":            "maraino",
+		"ref":              "refs/tags/v0.17.3",
+	}
+	t1, err := generateWorkloadToken(p1.Issuer, p1.Audience, claims, time.Now(), &keys.Keys[0])
+	assert.FatalError(t, err)
+
+	key, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+	signer, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+	pub := key.Public().Key
+	tests := []struct {
+		name           string
+		prov           *Workload
+		token          string
+		sshOpts        SignSSHOptions
+		wantPrincipals []string
+		wantErr        bool
+		wantSignErr    bool
+	}{
+		{"ok", p1, t1, SignSSHOptions{}, []string{"smallstep", "maraino"}, false, false},
+		{"ok principals", p1, t1, SignSSHOptions{Principals: []string{"maraino"}}, []string{"smallstep", "maraino"}, false, false},
+		{"ok default principals", p2, t1, SignSSHOptions{}, []string{"smallstep/certificates"}, false, false},
+		{"fail host", p1, t1, SignSSHOptions{CertType: SSHHostCert}, nil, false, true},
+		{"fail principals", p1, t1, SignSSHOptions{Principals: []string{"root"}}, nil, false, true},
+		{"fail sshCA disabled", p3, t1, SignSSHOptions{}, nil, true, false},
+		{"fail token", p1, "foobarzar", SignSSHOptions{}, nil, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.prov.AuthorizeSSHSign(context.Background(), tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Workload.AuthorizeSSHSign() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, sc.StatusCode(), http.StatusUnauthorized)
+				return
+			}
+			cert, err := signSSHCertificate(pub, tt.sshOpts, got, signer.Key.(crypto.Signer))
+			if (err != nil) != tt.wantSignErr {
+				t.Errorf("SignSSH error = %v, wantSignErr %v", err, tt.wantSignErr)
+				return
+			}
+			if err == nil {
+				assert.Equals(t, tt.wantPrincipals, cert.ValidPrincipals)
+				assert.Equals(t, uint32(ssh.UserCert), cert.CertType)
+			}
+		})
+	}
+}
+
+func TestWorkload_AuthorizeRenew(t *testing.T) {
+	p1 := &Workload{claimer: &Claimer{global: globalProvisionerClaims}}
+	disable := true
+	p2 := &Workload{Name: "name", claimer: &Claimer{global: globalProvisionerClaims, claims: &Claims{DisableRenewal: &disable}}}
+	assert.FatalError(t, p1.AuthorizeRenew(context.Background(), &x509.Certificate{}))
+	err := p2.AuthorizeRenew(context.Background(), &x509.Certificate{})
+	if assert.Error(t, err) {
+		sc, ok := err.(errs.StatusCoder)
+		assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+		assert.Equals(t, sc.StatusCode(), http.StatusUnauthorized)
+	}
+}
+
+func TestCollection_LoadByToken_workload(t *testing.T) {
+	key, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+
+	p1 := &Workload{Name: "github", Issuer: "https://token.actions.githubusercontent.com", Audience: "https://ca.example.com/workload"}
+	p2 := &Workload{Name: "gitlab", Issuer: "https://gitlab.com", Audience: "https://ca.example.com/workload"}
+	c := NewCollection(testAudiences)
+	assert.FatalError(t, c.Store(p1))
+	assert.FatalError(t, c.Store(p2))
+
+	tests := []struct {
+		name   string
+		iss    string
+		aud    string
+		want   Interface
+		wantOK bool
+	}{
+		{"ok github", p1.Issuer, p1.Audience, p1, true},
+		{"ok gitlab", p2.Issuer, p2.Audience, p2, true},
+		{"fail audience", p1.Issuer, "https://ca.example.com", nil, false},
+		{"fail issuer", "https://vault.example.com", p1.Audience, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := generateWorkloadToken(tt.iss, tt.aud, nil, time.Now(), key)
+			assert.FatalError(t, err)
+			jwt, claims, err := parseToken(token)
+			assert.FatalError(t, err)
+			got, ok := c.LoadByToken(jwt, claims)
+			assert.Equals(t, tt.wantOK, ok)
+			if tt.wantOK {
+				assert.Equals(t, tt.want, got)
+			}
+		})
+	}
+}
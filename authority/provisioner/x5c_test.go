@@ -463,7 +463,7 @@ func TestX5C_AuthorizeSign(t *testing.T) {
 			} else {
 				if assert.Nil(t, tc.err) {
 					if assert.NotNil(t, opts) {
-						assert.Equals(t, len(opts), 7)
+						assert.Equals(t, len(opts), 9)
 						for _, o := range opts {
 							switch v := o.(type) {
 							case certificateOptionsFunc:
@@ -486,6 +486,10 @@ func TestX5C_AuthorizeSign(t *testing.T) {
 							case *validityValidator:
 								assert.Equals(t, v.min, tc.p.claimer.MinTLSCertDuration())
 								assert.Equals(t, v.max, tc.p.claimer.MaxTLSCertDuration())
+							case *x509Webhooks:
+								assert.Equals(t, v.controller, tc.p.webhooks)
+							case *x509NamePolicyValidator:
+								assert.Equals(t, v.policy, tc.p.namePolicy)
 							default:
 								assert.FatalError(t, errors.Errorf("unexpected sign option of type %T", v))
 							}
@@ -779,15 +783,19 @@ func TestX5C_AuthorizeSSHSign(t *testing.T) {
 							case *sshCertValidityValidator:
 								assert.Equals(t, v.Claimer, tc.p.claimer)
 							case *sshDefaultPublicKeyValidator, *sshCertDefaultValidator, sshCertificateOptionsFunc:
+							case *sshWebhooks:
+								assert.Equals(t, v.controller, tc.p.webhooks)
+							case *sshNamePolicyValidator:
+								assert.Equals(t, v.policy, tc.p.namePolicy)
 							default:
 								assert.FatalError(t, errors.Errorf("unexpected sign option of type %T", v))
 							}
 							tot++
 						}
 						if len(tc.claims.Step.SSH.CertType) > 0 {
-							assert.Equals(t, tot, 9)
+							assert.Equals(t, tot, 11)
 						} else {
-							assert.Equals(t, tot, 7)
+							assert.Equals(t, tot, 9)
 						}
 					}
 				}
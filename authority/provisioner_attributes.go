@@ -0,0 +1,167 @@
+package authority
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/admin"
+	"github.com/smallstep/certificates/authority/provisioner"
+)
+
+// provisionerAttributes are the attributes of the provisioners, by type, that
+// are not part of the linkedca provisioner types. When the admin API is
+// enabled, they are stored in the admin database next to the provisioner as a
+// JSON object with the same format used in the ca.json.
+var provisionerAttributes = map[provisioner.Type][]string{
+	provisioner.TypeACME: {
+		"requireEAB", "renewalInfo", "challengeValidation", "caa", "policy",
+		"rateLimits", "authorizationLifetime", "profiles",
+		"attestationFormats", "attestationRoots",
+	},
+	provisioner.TypeK8sSA: {
+		"issuer", "audience", "jwksURI", "tokenReview",
+	},
+}
+
+// isProvisionerAttribute returns true if the given attribute is one of the
+// attributes stored in the admin database for the given type of provisioner.
+func isProvisionerAttribute(typ provisioner.Type, name string) bool {
+	for _, s := range provisionerAttributes[typ] {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// applyProvisionerAttributes sets the attributes stored in the admin database
+// in the given provisioner. It fails if the attributes contain an attribute
+// that is part of the linkedca provisioner type or that is not supported by
+// the provisioner.
+func applyProvisionerAttributes(p provisioner.Interface, attrs json.RawMessage) error {
+	if len(attrs) == 0 {
+		return nil
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(attrs, &m); err != nil {
+		return admin.WrapError(admin.ErrorBadRequestType, err, "error unmarshaling attributes of provisioner %s", p.GetName())
+	}
+	for name := range m {
+		if !isProvisionerAttribute(p.GetType(), name) {
+			return admin.NewError(admin.ErrorBadRequestType,
+				"attribute %s is not supported by %s provisioners", name, p.GetType())
+		}
+	}
+	if err := json.Unmarshal(attrs, p); err != nil {
+		return admin.WrapError(admin.ErrorBadRequestType, err, "error setting attributes of provisioner %s", p.GetName())
+	}
+	return nil
+}
+
+// marshalProvisionerAttributes returns the attributes of the given
+// provisioner that are not part of the linkedca provisioner type, or nil if
+// the provisioner does not have any.
+func marshalProvisionerAttributes(p provisioner.Interface) (json.RawMessage, error) {
+	if len(provisionerAttributes[p.GetType()]) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error marshaling provisioner %s", p.GetName())
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, errors.Wrapf(err, "error unmarshaling provisioner %s", p.GetName())
+	}
+	for name := range m {
+		if !isProvisionerAttribute(p.GetType(), name) {
+			delete(m, name)
+		}
+	}
+	if len(m) == 0 {
+		return nil, nil
+	}
+	b, err = json.Marshal(m)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error marshaling attributes of provisioner %s", p.GetName())
+	}
+	return b, nil
+}
+
+// GetProvisionerAttributes returns the attributes of the provisioner with the
+// given id that are not part of the linkedca provisioner type. It returns nil
+// if the provisioner does not have any.
+func (a *Authority) GetProvisionerAttributes(ctx context.Context, id string) (json.RawMessage, error) {
+	a.adminMutex.RLock()
+	defer a.adminMutex.RUnlock()
+	return a.getProvisionerAttributes(ctx, id)
+}
+
+// UpdateProvisionerAttributes validates and stores the attributes of the
+// provisioner with the given id and reloads the provisioner.
+func (a *Authority) UpdateProvisionerAttributes(ctx context.Context, id string, attrs json.RawMessage) error {
+	a.adminMutex.Lock()
+	defer a.adminMutex.Unlock()
+
+	prov, err := a.adminDB.GetProvisioner(ctx, id)
+	if err != nil {
+		return admin.WrapErrorISE(err, "error getting provisioner %s", id)
+	}
+	pol, err := a.getPolicy(ctx, id)
+	if err != nil {
+		return err
+	}
+	certProv, err := provisionerToCertificates(prov, pol)
+	if err != nil {
+		return admin.WrapErrorISE(err,
+			"error converting to certificates provisioner from linkedca provisioner")
+	}
+	if err := applyProvisionerAttributes(certProv, attrs); err != nil {
+		return err
+	}
+
+	provisionerConfig, err := a.generateProvisionerConfig(ctx)
+	if err != nil {
+		return admin.WrapErrorISE(err, "error generating provisioner config")
+	}
+	if err := certProv.Init(*provisionerConfig); err != nil {
+		return admin.WrapError(admin.ErrorBadRequestType, err, "error initializing provisioner %s", prov.Name)
+	}
+
+	if err := a.adminDB.UpdateProvisionerAttributes(ctx, id, attrs); err != nil {
+		return admin.WrapErrorISE(err, "error updating attributes of provisioner %s", prov.Name)
+	}
+	if err := a.reloadAdminResources(ctx); err != nil {
+		return admin.WrapErrorISE(err, "error reloading admin resources on provisioner attributes update")
+	}
+	return nil
+}
+
+// RemoveProvisionerAttributes removes the attributes of the provisioner with
+// the given id and reloads the provisioner.
+func (a *Authority) RemoveProvisionerAttributes(ctx context.Context, id string) error {
+	a.adminMutex.Lock()
+	defer a.adminMutex.Unlock()
+
+	if err := a.adminDB.DeleteProvisionerAttributes(ctx, id); err != nil {
+		return admin.WrapErrorISE(err, "error deleting attributes of provisioner %s", id)
+	}
+	if err := a.reloadAdminResources(ctx); err != nil {
+		return admin.WrapErrorISE(err, "error reloading admin resources on provisioner attributes removal")
+	}
+	return nil
+}
+
+// getProvisionerAttributes returns the attributes of the provisioner with the
+// given id from the admin database, or nil if they do not exist.
+func (a *Authority) getProvisionerAttributes(ctx context.Context, id string) (json.RawMessage, error) {
+	attrs, err := a.adminDB.GetProvisionerAttributes(ctx, id)
+	if err != nil {
+		if k, ok := err.(*admin.Error); ok && k.IsType(admin.ErrorNotFoundType) {
+			return nil, nil
+		}
+		return nil, admin.WrapErrorISE(err, "error getting attributes of provisioner %s", id)
+	}
+	return attrs, nil
+}
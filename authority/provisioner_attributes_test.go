@@ -0,0 +1,98 @@
+package authority
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+)
+
+func Test_applyProvisionerAttributes(t *testing.T) {
+	newACME := func() *provisioner.ACME {
+		return &provisioner.ACME{
+			Type: "ACME",
+			Name: "acme",
+			Options: &provisioner.Options{
+				X509: &provisioner.X509Options{Template: "{}"},
+			},
+		}
+	}
+	tests := []struct {
+		name  string
+		prov  provisioner.Interface
+		attrs string
+		want  provisioner.Interface
+		err   string
+	}{
+		{"ok", newACME(), `{"requireEAB":true,"caa":{"issuerDomainNames":["ca.example.com"]}}`, &provisioner.ACME{
+			Type:       "ACME",
+			Name:       "acme",
+			RequireEAB: true,
+			CAA:        &provisioner.ACMECAA{IssuerDomainNames: []string{"ca.example.com"}},
+			Options: &provisioner.Options{
+				X509: &provisioner.X509Options{Template: "{}"},
+			},
+		}, ""},
+		{"ok/empty", newACME(), "", newACME(), ""},
+		{"fail/linkedca", newACME(), `{"forceCN":true}`, nil, "attribute forceCN is not supported by ACME provisioners"},
+		{"fail/name", newACME(), `{"name":"foo"}`, nil, "attribute name is not supported by ACME provisioners"},
+		{"ok/k8sSA", &provisioner.K8sSA{Type: "K8sSA", Name: "k8s"}, `{"issuer":"https://k8s.example.com","jwksURI":"https://k8s.example.com/keys","tokenReview":{"url":"https://k8s.example.com"}}`, &provisioner.K8sSA{
+			Type:        "K8sSA",
+			Name:        "k8s",
+			Issuer:      "https://k8s.example.com",
+			JWKSetURI:   "https://k8s.example.com/keys",
+			TokenReview: &provisioner.K8sSATokenReview{URL: "https://k8s.example.com"},
+		}, ""},
+		{"fail/type", &provisioner.JWK{Type: "JWK", Name: "jwk"}, `{"requireEAB":true}`, nil, "attribute requireEAB is not supported by JWK provisioners"},
+		{"fail/object", newACME(), `[]`, nil, "error unmarshaling attributes of provisioner acme"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := applyProvisionerAttributes(tt.prov, json.RawMessage(tt.attrs))
+			if tt.err != "" {
+				if assert.Error(t, err) {
+					assert.HasPrefix(t, err.Error(), tt.err)
+				}
+				return
+			}
+			assert.FatalError(t, err)
+			assert.Equals(t, tt.want, tt.prov)
+		})
+	}
+}
+
+func Test_marshalProvisionerAttributes(t *testing.T) {
+	d, err := provisioner.NewDuration("24h")
+	assert.FatalError(t, err)
+	tests := []struct {
+		name string
+		prov provisioner.Interface
+		want string
+	}{
+		{"ok", &provisioner.ACME{
+			Type:                  "ACME",
+			Name:                  "acme",
+			ForceCN:               true,
+			RequireEAB:            true,
+			AuthorizationLifetime: d,
+		}, `{"authorizationLifetime":"24h0m0s","requireEAB":true}`},
+		{"ok/empty", &provisioner.ACME{Type: "ACME", Name: "acme", ForceCN: true}, ""},
+		{"ok/k8sSA", &provisioner.K8sSA{
+			Type:      "K8sSA",
+			Name:      "k8s",
+			PubKeys:   []byte("foo"),
+			Issuer:    "https://k8s.example.com",
+			Audience:  "step-ca",
+			JWKSetURI: "https://k8s.example.com/keys",
+		}, `{"audience":"step-ca","issuer":"https://k8s.example.com","jwksURI":"https://k8s.example.com/keys"}`},
+		{"ok/type", &provisioner.JWK{Type: "JWK", Name: "jwk"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := marshalProvisionerAttributes(tt.prov)
+			assert.FatalError(t, err)
+			assert.Equals(t, tt.want, string(got))
+		})
+	}
+}
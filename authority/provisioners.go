@@ -11,6 +11,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/smallstep/certificates/authority/admin"
 	"github.com/smallstep/certificates/authority/config"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/certificates/authority/provisioner"
 	"github.com/smallstep/certificates/errs"
 	step "go.step.sm/cli-utils/config"
@@ -108,6 +109,7 @@ func (a *Authority) generateProvisionerConfig(ctx context.Context) (*provisioner
 			HostKeys: sshKeys.HostKeys,
 		},
 		GetIdentityFunc: a.getIdentityFunc,
+		WebhookClient:   a.webhookClient,
 	}, nil
 
 }
@@ -167,11 +169,24 @@ func (a *Authority) UpdateProvisioner(ctx context.Context, nu *linkedca.Provisio
 	a.adminMutex.Lock()
 	defer a.adminMutex.Unlock()
 
-	certProv, err := ProvisionerToCertificates(nu)
+	// Keep the policy and the attributes of the provisioner.
+	pol, err := a.getPolicy(ctx, nu.Id)
+	if err != nil {
+		return err
+	}
+	attrs, err := a.getProvisionerAttributes(ctx, nu.Id)
+	if err != nil {
+		return err
+	}
+
+	certProv, err := provisionerToCertificates(nu, pol)
 	if err != nil {
 		return admin.WrapErrorISE(err,
 			"error converting to certificates provisioner from linkedca provisioner")
 	}
+	if err := applyProvisionerAttributes(certProv, attrs); err != nil {
+		return err
+	}
 
 	provisionerConfig, err := a.generateProvisionerConfig(ctx)
 	if err != nil {
@@ -235,6 +250,22 @@ func (a *Authority) RemoveProvisioner(ctx context.Context, id string) error {
 		}
 		return admin.WrapErrorISE(err, "error deleting provisioner %s", provName)
 	}
+	// Remove the policy of the provisioner, if any.
+	if pol, err := a.getPolicy(ctx, provID); err != nil {
+		return err
+	} else if pol != nil {
+		if err := a.adminDB.DeletePolicy(ctx, provID); err != nil {
+			return admin.WrapErrorISE(err, "error deleting policy of provisioner %s", provName)
+		}
+	}
+	// Remove the attributes of the provisioner, if any.
+	if attrs, err := a.getProvisionerAttributes(ctx, provID); err != nil {
+		return err
+	} else if attrs != nil {
+		if err := a.adminDB.DeleteProvisionerAttributes(ctx, provID); err != nil {
+			return admin.WrapErrorISE(err, "error deleting attributes of provisioner %s", provName)
+		}
+	}
 	return nil
 }
 
@@ -361,22 +392,26 @@ func ValidateDurations(d *linkedca.Durations) error {
 	return nil
 }
 
-func provisionerListToCertificates(l []*linkedca.Provisioner) (provisioner.List, error) {
+func provisionerListToCertificates(l []*linkedca.Provisioner, policies map[string]*policy.Options, attributes map[string]json.RawMessage) (provisioner.List, error) {
 	var nu provisioner.List
 	for _, p := range l {
-		certProv, err := ProvisionerToCertificates(p)
+		certProv, err := provisionerToCertificates(p, policies[p.Id])
 		if err != nil {
 			return nil, err
 		}
+		if err := applyProvisionerAttributes(certProv, attributes[p.Id]); err != nil {
+			return nil, err
+		}
 		nu = append(nu, certProv)
 	}
 	return nu, nil
 }
 
-func optionsToCertificates(p *linkedca.Provisioner) *provisioner.Options {
+func optionsToCertificates(p *linkedca.Provisioner, pol *policy.Options) *provisioner.Options {
 	ops := &provisioner.Options{
-		X509: &provisioner.X509Options{},
-		SSH:  &provisioner.SSHOptions{},
+		X509:   &provisioner.X509Options{},
+		SSH:    &provisioner.SSHOptions{},
+		Policy: pol,
 	}
 	if p.X509Template != nil {
 		ops.X509.Template = string(p.X509Template.Template)
@@ -389,6 +424,37 @@ func optionsToCertificates(p *linkedca.Provisioner) *provisioner.Options {
 	return ops
 }
 
+// provisionerPolicy returns the name-constraint policy in the options of the
+// given provisioner.
+func provisionerPolicy(p provisioner.Interface) *policy.Options {
+	var ops *provisioner.Options
+	switch p := p.(type) {
+	case *provisioner.JWK:
+		ops = p.Options
+	case *provisioner.OIDC:
+		ops = p.Options
+	case *provisioner.GCP:
+		ops = p.Options
+	case *provisioner.AWS:
+		ops = p.Options
+	case *provisioner.Azure:
+		ops = p.Options
+	case *provisioner.ACME:
+		ops = p.Options
+	case *provisioner.X5C:
+		ops = p.Options
+	case *provisioner.K8sSA:
+		ops = p.Options
+	case *provisioner.SCEP:
+		ops = p.Options
+	case *provisioner.Workload:
+		ops = p.Options
+	case *provisioner.TPM:
+		ops = p.Options
+	}
+	return ops.GetPolicyOptions()
+}
+
 func durationsToCertificates(d *linkedca.Durations) (min, max, def *provisioner.Duration, err error) {
 	if len(d.Min) > 0 {
 		min, err = provisioner.NewDuration(d.Min)
@@ -564,6 +630,14 @@ func provisionerPEMToLinkedca(b []byte) [][]byte {
 // ProvisionerToCertificates converts the linkedca provisioner type to the certificates provisioner
 // interface.
 func ProvisionerToCertificates(p *linkedca.Provisioner) (provisioner.Interface, error) {
+	return provisionerToCertificates(p, nil)
+}
+
+// provisionerToCertificates converts the linkedca provisioner type to the
+// certificates provisioner interface with the given name-constraint policy.
+// The policies are not part of the linkedca type and they are stored
+// separately in the admin database.
+func provisionerToCertificates(p *linkedca.Provisioner, pol *policy.Options) (provisioner.Interface, error) {
 	claims, err := claimsToCertificates(p.Claims)
 	if err != nil {
 		return nil, err
@@ -574,7 +648,7 @@ func ProvisionerToCertificates(p *linkedca.Provisioner) (provisioner.Interface,
 		return nil, errors.New("provisioner does not have any details")
 	}
 
-	options := optionsToCertificates(p)
+	options := optionsToCertificates(p, pol)
 
 	switch d := details.(type) {
 	case *linkedca.ProvisionerDetails_JWK:
@@ -714,6 +788,9 @@ func ProvisionerToCertificates(p *linkedca.Provisioner) (provisioner.Interface,
 // ProvisionerToLinkedca converts a provisioner.Interface to a
 // linkedca.Provisioner type.
 func ProvisionerToLinkedca(p provisioner.Interface) (*linkedca.Provisioner, error) {
+	if err := checkLinkedcaSupport(p); err != nil {
+		return nil, err
+	}
 	switch p := p.(type) {
 	case *provisioner.JWK:
 		x509Template, sshTemplate, err := provisionerOptionsToLinkedca(p.Options)
@@ -938,6 +1015,18 @@ func ProvisionerToLinkedca(p provisioner.Interface) (*linkedca.Provisioner, erro
 	}
 }
 
+// checkLinkedcaSupport returns an error if the provisioner type does not have
+// a linkedca representation, these provisioners cannot be stored in the admin
+// database and they can only be configured in the ca.json.
+func checkLinkedcaSupport(p provisioner.Interface) error {
+	switch p.(type) {
+	case *provisioner.Workload, *provisioner.TPM:
+		return errors.Errorf("%s provisioner %s is not supported by the admin database; it can only be configured in the ca.json without enableAdmin",
+			p.GetType(), p.GetName())
+	}
+	return nil
+}
+
 func parseInstanceAge(age string) (provisioner.Duration, error) {
 	var instanceAge provisioner.Duration
 	if age != "" {
@@ -104,3 +104,22 @@ func TestGetProvisioners(t *testing.T) {
 		})
 	}
 }
+
+func TestProvisionerToLinkedca_unsupported(t *testing.T) {
+	tests := []struct {
+		name string
+		prov provisioner.Interface
+		err  string
+	}{
+		{"workload", &provisioner.Workload{Type: "Workload", Name: "workload"}, "Workload provisioner workload is not supported by the admin database"},
+		{"tpm", &provisioner.TPM{Type: "TPM", Name: "tpm"}, "TPM provisioner tpm is not supported by the admin database"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ProvisionerToLinkedca(tt.prov)
+			if assert.Error(t, err) {
+				assert.HasPrefix(t, err.Error(), tt.err)
+			}
+		})
+	}
+}
@@ -138,6 +138,7 @@ func (a *Authority) SignSSH(ctx context.Context, key ssh.PublicKey, opts provisi
 		certOptions []sshutil.Option
 		mods        []provisioner.SSHCertModifier
 		validators  []provisioner.SSHCertValidator
+		webhooks    []provisioner.SSHCertificateWebhook
 	)
 
 	// Validate given options.
@@ -168,11 +169,23 @@ func (a *Authority) SignSSH(ctx context.Context, key ssh.PublicKey, opts provisi
 				return nil, errs.Wrap(http.StatusForbidden, err, "authority.SignSSH")
 			}
 
+		// call the webhooks before creating the certificate
+		case provisioner.SSHCertificateWebhook:
+			webhooks = append(webhooks, o)
+
 		default:
 			return nil, errs.InternalServer("authority.SignSSH: invalid extra option type %T", o)
 		}
 	}
 
+	// The webhooks can deny the request or add data to the template, so they
+	// must run before rendering it.
+	for _, w := range webhooks {
+		if err := w.CallSSHWebhooks(key, opts); err != nil {
+			return nil, errs.Wrap(http.StatusForbidden, err, "authority.SignSSH")
+		}
+	}
+
 	// Simulated certificate request with request options.
 	cr := sshutil.CertificateRequest{
 		Type:       opts.CertType,
@@ -209,6 +222,11 @@ func (a *Authority) SignSSH(ctx context.Context, key ssh.PublicKey, opts provisi
 		}
 	}
 
+	// Validate the final principals with the policy of the authority.
+	if err := a.getNamePolicy().IsSSHCertificateAllowed(certTpl); err != nil {
+		return nil, errs.Wrap(http.StatusForbidden, err, "authority.SignSSH")
+	}
+
 	// Get signer from authority keys
 	var signer ssh.Signer
 	switch certTpl.CertType {
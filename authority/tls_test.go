@@ -21,6 +21,7 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/certificates/authority/provisioner"
 	"github.com/smallstep/certificates/db"
 	"github.com/smallstep/certificates/errs"
@@ -55,6 +56,15 @@ func (m *certificateDurationEnforcer) Enforce(cert *x509.Certificate) error {
 	return nil
 }
 
+type certificateWebhook string
+
+func (w certificateWebhook) CallWebhooks(cr *x509.CertificateRequest) error {
+	if w == "" {
+		return nil
+	}
+	return errors.New(string(w))
+}
+
 func getDefaultIssuer(a *Authority) *x509.Certificate {
 	return a.x509CAService.(*softcas.SoftCAS).CertificateChain[len(a.x509CAService.(*softcas.SoftCAS).CertificateChain)-1]
 }
@@ -313,6 +323,37 @@ func TestAuthority_Sign(t *testing.T) {
 				code:      http.StatusUnauthorized,
 			}
 		},
+		"fail authority policy": func(t *testing.T) *signTest {
+			_a := testAuthority(t)
+			_a.config.AuthorityConfig.Template = a.config.AuthorityConfig.Template
+			engine, err := policy.New(&policy.Options{
+				X509: &policy.X509Options{
+					Deny: &policy.X509NameOptions{DNSDomains: []string{"*.smallstep.com"}},
+				},
+			})
+			assert.FatalError(t, err)
+			_a.namePolicy = engine
+			csr := getCSR(t, priv)
+			return &signTest{
+				auth:      _a,
+				csr:       csr,
+				extraOpts: extraOpts,
+				signOpts:  signOpts,
+				err:       errors.New(`authority.Sign: dns name "test.smallstep.com" is denied by the policy`),
+				code:      http.StatusUnauthorized,
+			}
+		},
+		"fail webhook": func(t *testing.T) *signTest {
+			csr := getCSR(t, priv)
+			return &signTest{
+				auth:      a,
+				csr:       csr,
+				extraOpts: append(extraOpts, certificateWebhook("webhook cmdb denied the request")),
+				signOpts:  signOpts,
+				err:       errors.New("authority.Sign: webhook cmdb denied the request"),
+				code:      http.StatusForbidden,
+			}
+		},
 		"fail validate sans when adding common name not in claims": func(t *testing.T) *signTest {
 			csr := getCSR(t, priv, func(csr *x509.CertificateRequest) {
 				csr.DNSNames = append(csr.DNSNames, csr.Subject.CommonName)
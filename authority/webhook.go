@@ -0,0 +1,53 @@
+package authority
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"time"
+)
+
+// newWebhookClient returns the HTTP client used by the provisioners to call
+// their webhooks. The client trusts the system roots and the roots of the CA,
+// and it authenticates itself with a certificate issued by the CA.
+func (a *Authority) newWebhookClient() *http.Client {
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	for _, crt := range a.rootX509Certs {
+		pool.AddCert(crt)
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{
+				RootCAs:              pool,
+				MinVersion:           tls.VersionTLS12,
+				GetClientCertificate: a.getWebhookClientCertificate,
+			},
+		},
+	}
+}
+
+// getWebhookClientCertificate returns the TLS client certificate used to call
+// the webhooks. The certificate is the same one used by the CA server, and it
+// is renewed once two thirds of its lifetime have passed.
+func (a *Authority) getWebhookClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	a.webhookMutex.Lock()
+	defer a.webhookMutex.Unlock()
+
+	if crt := a.webhookCertificate; crt != nil && crt.Leaf != nil {
+		lifetime := crt.Leaf.NotAfter.Sub(crt.Leaf.NotBefore)
+		if time.Now().Before(crt.Leaf.NotAfter.Add(-lifetime / 3)) {
+			return crt, nil
+		}
+	}
+
+	crt, err := a.GetTLSCertificate()
+	if err != nil {
+		return nil, err
+	}
+	a.webhookCertificate = crt
+	return crt, nil
+}
@@ -1,6 +1,7 @@
 package ca
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
@@ -15,6 +16,7 @@ import (
 	"github.com/smallstep/certificates/acme"
 	acmeAPI "github.com/smallstep/certificates/acme/api"
 	acmeNoSQL "github.com/smallstep/certificates/acme/db/nosql"
+	acmeEmail "github.com/smallstep/certificates/acme/email"
 	"github.com/smallstep/certificates/api"
 	"github.com/smallstep/certificates/authority"
 	adminAPI "github.com/smallstep/certificates/authority/admin/api"
@@ -92,6 +94,9 @@ type CA struct {
 	insecureSrv *server.Server
 	opts        *options
 	renewer     *TLSRenewer
+	acmeEmail   *acmeEmail.Transport
+	acmeHandler api.RouterHandler
+	acmeCancel  context.CancelFunc
 }
 
 // New creates and initializes the CA with the given configuration and options.
@@ -174,13 +179,34 @@ func (ca *CA) Init(config *config.Config) (*CA, error) {
 			return nil, errors.Wrap(err, "error configuring ACME DB interface")
 		}
 	}
+	if gc, ok := acmeDB.(authority.ACMEGarbageCollector); ok {
+		if err := auth.StartACMEGarbageCollector(gc); err != nil {
+			return nil, errors.Wrap(err, "error starting ACME garbage collector")
+		}
+	}
+	// Email transport used by email-reply-00 challenges
+	var emailTransport acme.EmailTransport
+	if config.ACMEEmail != nil {
+		ca.acmeEmail, err = acmeEmail.New(config.ACMEEmail)
+		if err != nil {
+			return nil, errors.Wrap(err, "error configuring ACME email transport")
+		}
+		emailTransport = ca.acmeEmail
+	}
+	// The background validations of challenges are interrupted when the CA
+	// is stopped or reloaded.
+	var acmeCtx context.Context
+	acmeCtx, ca.acmeCancel = context.WithCancel(context.Background())
 	acmeHandler := acmeAPI.NewHandler(acmeAPI.HandlerOptions{
 		Backdate: *config.AuthorityConfig.Backdate,
 		DB:       acmeDB,
 		DNS:      dns,
 		Prefix:   prefix,
 		CA:       auth,
+		Email:    emailTransport,
+		Context:  acmeCtx,
 	})
+	ca.acmeHandler = acmeHandler
 	mux.Route("/"+prefix, func(r chi.Router) {
 		acmeHandler.Route(r)
 	})
@@ -194,7 +220,7 @@ func (ca *CA) Init(config *config.Config) (*CA, error) {
 	if config.AuthorityConfig.EnableAdmin {
 		adminDB := auth.GetAdminDatabase()
 		if adminDB != nil {
-			adminHandler := adminAPI.NewHandler(auth)
+			adminHandler := adminAPI.NewHandler(auth, acmeDB)
 			mux.Route("/admin", func(r chi.Router) {
 				adminHandler.Route(r)
 			})
@@ -231,6 +257,23 @@ func (ca *CA) Init(config *config.Config) (*CA, error) {
 		})
 	}
 
+	// The CRL is also served over HTTP so clients can download it from the
+	// CRL distribution points without TLS.
+	if config.CRL.IsEnabled() {
+		crlHandler := api.NewCRLHandler(auth)
+		insecureMux.Get("/crl", crlHandler)
+		insecureMux.Get("/1.0/crl", crlHandler)
+	}
+
+	// OCSP is usually served over HTTP, see RFC 6960, appendix A.
+	if config.OCSP.IsEnabled() {
+		ocspHandler := api.NewOCSPHandler(auth)
+		insecureMux.Get("/ocsp/*", ocspHandler)
+		insecureMux.Post("/ocsp", ocspHandler)
+		insecureMux.Get("/1.0/ocsp/*", ocspHandler)
+		insecureMux.Post("/1.0/ocsp", ocspHandler)
+	}
+
 	// helpful routine for logging all routes
 	//dumpRoutes(mux)
 
@@ -257,8 +300,9 @@ func (ca *CA) Init(config *config.Config) (*CA, error) {
 	ca.srv = server.New(config.Address, handler, tlsConfig)
 
 	// only start the insecure server if the insecure address is configured
-	// and, currently, also only when it should serve SCEP endpoints.
-	if ca.shouldServeSCEPEndpoints() && config.InsecureAddress != "" {
+	// and, currently, also only when it should serve SCEP, CRL or OCSP
+	// endpoints.
+	if (ca.shouldServeSCEPEndpoints() || config.CRL.IsEnabled() || config.OCSP.IsEnabled()) && config.InsecureAddress != "" {
 		// TODO: instead opt for having a single server.Server but two
 		// http.Servers handling the HTTP and HTTPS handler? The latter
 		// will probably introduce more complexity in terms of graceful
@@ -299,9 +343,15 @@ func (ca *CA) Run() error {
 // Stop stops the CA calling to the server Shutdown method.
 func (ca *CA) Stop() error {
 	ca.renewer.Stop()
+	ca.stopACMEValidations()
 	if err := ca.auth.Shutdown(); err != nil {
 		log.Printf("error stopping ca.Authority: %+v\n", err)
 	}
+	if ca.acmeEmail != nil {
+		if err := ca.acmeEmail.Close(); err != nil {
+			log.Printf("error stopping ACME email transport: %+v\n", err)
+		}
+	}
 	var insecureShutdownErr error
 	if ca.insecureSrv != nil {
 		insecureShutdownErr = ca.insecureSrv.Shutdown()
@@ -364,14 +414,35 @@ func (ca *CA) Reload() error {
 	// 3. Replace ca properties
 	// Do not replace ca.srv
 	ca.renewer.Stop()
+	ca.stopACMEValidations()
 	ca.auth.CloseForReload()
+	if ca.acmeEmail != nil {
+		if err := ca.acmeEmail.Close(); err != nil {
+			log.Printf("error stopping ACME email transport: %+v\n", err)
+		}
+	}
 	ca.auth = newCA.auth
 	ca.config = newCA.config
 	ca.opts = newCA.opts
 	ca.renewer = newCA.renewer
+	ca.acmeEmail = newCA.acmeEmail
+	ca.acmeHandler = newCA.acmeHandler
+	ca.acmeCancel = newCA.acmeCancel
 	return nil
 }
 
+// stopACMEValidations interrupts the background validations of ACME
+// challenges and waits for them to return. The challenges stay in the
+// processing status and their validation is resumed on the next request.
+func (ca *CA) stopACMEValidations() {
+	if ca.acmeCancel != nil {
+		ca.acmeCancel()
+	}
+	if h, ok := ca.acmeHandler.(interface{ Wait() }); ok {
+		h.Wait()
+	}
+}
+
 // getTLSConfig returns a TLSConfig for the CA server with a self-renewing
 // server certificate.
 func (ca *CA) getTLSConfig(auth *authority.Authority) (*tls.Config, error) {
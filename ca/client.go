@@ -34,6 +34,7 @@ import (
 	"go.step.sm/crypto/keyutil"
 	"go.step.sm/crypto/pemutil"
 	"go.step.sm/crypto/x509util"
+	"golang.org/x/crypto/ocsp"
 	"golang.org/x/net/http2"
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
@@ -778,6 +779,49 @@ retry:
 	return &revoke, nil
 }
 
+// OCSPResponse is the type returned by the OCSP method. It contains the DER
+// representation of the OCSP response and the parsed response.
+type OCSPResponse struct {
+	Raw []byte
+	*ocsp.Response
+}
+
+// OCSP performs an OCSP request to the CA for the given certificate and
+// returns the OCSP response. The response is verified using the given issuer.
+func (c *Client) OCSP(cert, issuer *x509.Certificate) (*OCSPResponse, error) {
+	var retried bool
+	body, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating ocsp request")
+	}
+retry:
+	u := c.endpoint.ResolveReference(&url.URL{Path: "/ocsp"})
+	resp, err := c.client.Post(u.String(), "application/ocsp-request", bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrapf(err, "client POST %s failed", u)
+	}
+	if resp.StatusCode >= 400 {
+		if !retried && c.retryOnError(resp) {
+			retried = true
+			goto retry
+		}
+		return nil, readError(resp.Body)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading %s", u)
+	}
+	res, err := ocsp.ParseResponseForCert(b, cert, issuer)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing %s response", u)
+	}
+	return &OCSPResponse{
+		Raw:      b,
+		Response: res,
+	}, nil
+}
+
 // Provisioners performs the provisioners request to the CA and returns the
 // api.ProvisionersResponse struct with a map of provisioners.
 //
@@ -837,6 +881,38 @@ retry:
 	return &key, nil
 }
 
+// TPMChallenge performs the POST /tpm/{name}/challenge request to the CA and
+// returns the api.TPMChallengeResponse struct with the credential activation
+// challenge for the given TPM provisioner.
+func (c *Client) TPMChallenge(name string, req *api.TPMChallengeRequest) (*api.TPMChallengeResponse, error) {
+	var retried bool
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling request")
+	}
+	u := c.endpoint.ResolveReference(&url.URL{
+		Path:    "/tpm/" + name + "/challenge",
+		RawPath: "/tpm/" + url.PathEscape(name) + "/challenge",
+	})
+retry:
+	resp, err := c.client.Post(u.String(), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrapf(err, "client POST %s failed", u)
+	}
+	if resp.StatusCode >= 400 {
+		if !retried && c.retryOnError(resp) {
+			retried = true
+			goto retry
+		}
+		return nil, readError(resp.Body)
+	}
+	var challenge api.TPMChallengeResponse
+	if err := readJSON(resp.Body, &challenge); err != nil {
+		return nil, errors.Wrapf(err, "error reading %s", u)
+	}
+	return &challenge, nil
+}
+
 // Roots performs the get roots request to the CA and returns the
 // api.RootsResponse struct.
 func (c *Client) Roots() (*api.RootsResponse, error) {
@@ -719,6 +719,83 @@ func TestClient_ProvisionerKey(t *testing.T) {
 	}
 }
 
+func TestClient_TPMChallenge(t *testing.T) {
+	ok := &api.TPMChallengeResponse{
+		Credential: []byte("credential"),
+		Secret:     []byte("secret"),
+		ExpiresAt:  time.Unix(1600000000, 0).UTC(),
+	}
+	request := &api.TPMChallengeRequest{
+		EKPub: []byte("ek"),
+		AKPub: []byte("ak"),
+	}
+
+	tests := []struct {
+		name         string
+		provisioner  string
+		request      *api.TPMChallengeRequest
+		response     interface{}
+		responseCode int
+		wantErr      bool
+		err          error
+	}{
+		{"ok", "tpm", request, ok, 200, false, nil},
+		{"ok escaped", "tpm/bare metal", request, ok, 200, false, nil},
+		{"fail", "tpm", request, errs.Unauthorized("force"), 401, true, errors.New(errs.UnauthorizedDefaultMsg)},
+		{"fail not found", "invalid", request, errs.NotFound("force"), 404, true, errors.New(errs.NotFoundDefaultMsg)},
+	}
+
+	srv := httptest.NewServer(nil)
+	defer srv.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewClient(srv.URL, WithTransport(http.DefaultTransport))
+			if err != nil {
+				t.Errorf("NewClient() error = %v", err)
+				return
+			}
+
+			srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				expected := "/tpm/" + url.PathEscape(tt.provisioner) + "/challenge"
+				if req.RequestURI != expected {
+					t.Errorf("RequestURI = %s, want %s", req.RequestURI, expected)
+				}
+				body := new(api.TPMChallengeRequest)
+				if err := api.ReadJSON(req.Body, body); err != nil {
+					e, ok := tt.response.(error)
+					assert.Fatal(t, ok, "response expected to be error type")
+					api.WriteError(w, e)
+					return
+				}
+				api.JSONStatus(w, tt.response, tt.responseCode)
+			})
+
+			got, err := c.TPMChallenge(tt.provisioner, tt.request)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Client.TPMChallenge() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			switch {
+			case err != nil:
+				if got != nil {
+					t.Errorf("Client.TPMChallenge() = %v, want nil", got)
+				}
+
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, sc.StatusCode(), tt.responseCode)
+				assert.HasPrefix(t, tt.err.Error(), err.Error())
+			default:
+				if !reflect.DeepEqual(got, tt.response) {
+					t.Errorf("Client.TPMChallenge() = %v, want %v", got, tt.response)
+				}
+			}
+		})
+	}
+}
+
 func TestClient_Roots(t *testing.T) {
 	ok := &api.RootsResponse{
 		Certificates: []api.Certificate{
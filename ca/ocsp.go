@@ -0,0 +1,111 @@
+package ca
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ocsp"
+)
+
+// getOCSPStapleFunc returns an OCSPStapleFunc that gets the OCSP response of a
+// tls certificate from the CA. The issuer must be the second certificate in
+// the chain.
+func getOCSPStapleFunc(client *Client) OCSPStapleFunc {
+	return func(cert *tls.Certificate) ([]byte, time.Time, error) {
+		if cert.Leaf == nil || len(cert.Certificate) < 2 {
+			return nil, time.Time{}, errors.New("ca: certificate chain does not contain the issuer")
+		}
+		issuer, err := x509.ParseCertificate(cert.Certificate[1])
+		if err != nil {
+			return nil, time.Time{}, errors.Wrap(err, "ca: error parsing issuer certificate")
+		}
+		resp, err := client.OCSP(cert.Leaf, issuer)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		if resp.Status == ocsp.Unknown {
+			return nil, time.Time{}, errors.Errorf("ca: certificate with serial number %s is unknown to the CA", cert.Leaf.SerialNumber)
+		}
+		return resp.Raw, resp.NextUpdate, nil
+	}
+}
+
+// ocspChecker verifies the revocation status of the peer certificates using
+// the CA OCSP responder. Responses are cached until their next update.
+type ocspChecker struct {
+	client *Client
+	cache  sync.Map
+}
+
+// newOCSPChecker creates a new ocspChecker. To avoid checking the revocation
+// status recursively, the checker uses its own copy of the given client with
+// the current transport.
+func newOCSPChecker(c *Client) *ocspChecker {
+	return &ocspChecker{
+		client: &Client{
+			client:    newClient(c.client.GetTransport()),
+			endpoint:  c.endpoint,
+			retryFunc: c.retryFunc,
+			opts:      c.opts,
+		},
+	}
+}
+
+// VerifyConnection implements the tls.Config VerifyConnection callback. It
+// rejects the connection if the peer certificate has been revoked, or if its
+// status cannot be retrieved. Peers not verified by the tls.Config, or
+// certificates unknown to the CA, are ignored.
+func (o *ocspChecker) VerifyConnection(cs tls.ConnectionState) error {
+	if len(cs.VerifiedChains) == 0 || len(cs.VerifiedChains[0]) < 2 {
+		return nil
+	}
+	leaf, issuer := cs.VerifiedChains[0][0], cs.VerifiedChains[0][1]
+	resp, err := o.getResponse(leaf, issuer, cs.OCSPResponse)
+	if err != nil {
+		return errors.Wrap(err, "ca: error checking certificate revocation status")
+	}
+	if resp.Status == ocsp.Revoked {
+		return errors.Errorf("ca: certificate with serial number %s has been revoked", leaf.SerialNumber)
+	}
+	return nil
+}
+
+// getResponse returns a valid OCSP response for the given certificate. It will
+// use the stapled response if present, a cached response, or a new response
+// from the CA.
+func (o *ocspChecker) getResponse(cert, issuer *x509.Certificate, staple []byte) (*ocsp.Response, error) {
+	now := time.Now()
+	if len(staple) > 0 {
+		if resp, err := ocsp.ParseResponseForCert(staple, cert, issuer); err == nil && isOCSPResponseValid(resp, now) {
+			return resp, nil
+		}
+	}
+
+	key := issuer.SerialNumber.String() + ":" + cert.SerialNumber.String()
+	if v, ok := o.cache.Load(key); ok {
+		if resp := v.(*ocsp.Response); isOCSPResponseValid(resp, now) {
+			return resp, nil
+		}
+		o.cache.Delete(key)
+	}
+
+	resp, err := o.client.OCSP(cert, issuer)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.NextUpdate.IsZero() {
+		o.cache.Store(key, resp.Response)
+	}
+	return resp.Response, nil
+}
+
+// isOCSPResponseValid returns true if the OCSP response is current.
+func isOCSPResponseValid(resp *ocsp.Response, now time.Time) bool {
+	if now.Before(resp.ThisUpdate) {
+		return false
+	}
+	return resp.NextUpdate.IsZero() || now.Before(resp.NextUpdate)
+}
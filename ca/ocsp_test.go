@@ -0,0 +1,256 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ocsp"
+)
+
+func mustOCSPCertificates(t *testing.T) (leaf, issuer *x509.Certificate, signer crypto.Signer) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Intermediate"},
+		NotBefore:             now,
+		NotAfter:              now.Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issuer, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	der, err = x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(1234),
+		Subject:      pkix.Name{CommonName: "test.smallstep.com"},
+		NotBefore:    now,
+		NotAfter:     now.Add(time.Hour),
+	}, issuer, mustKey().Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	return leaf, issuer, key
+}
+
+func startOCSPTestServer(t *testing.T, issuer *x509.Certificate, signer crypto.Signer, status *int) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/ocsp" {
+			http.NotFound(w, r)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		req, err := ocsp.ParseRequest(body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if *status < 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"status":500,"message":"The certificate authority encountered an Internal Server Error. Please see the certificate authority logs for more info."}`))
+			return
+		}
+		now := time.Now().Truncate(time.Minute)
+		tmpl := ocsp.Response{
+			Status:       *status,
+			SerialNumber: req.SerialNumber,
+			ThisUpdate:   now,
+			NextUpdate:   now.Add(time.Hour),
+		}
+		if *status == ocsp.Revoked {
+			tmpl.RevokedAt = now
+		}
+		b, err := ocsp.CreateResponse(issuer, issuer, tmpl, signer)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		w.Write(b)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestClient_OCSP(t *testing.T) {
+	leaf, issuer, signer := mustOCSPCertificates(t)
+	status := ocsp.Good
+	srv := startOCSPTestServer(t, issuer, signer, &status)
+	client, err := NewClient(srv.URL, WithTransport(http.DefaultTransport))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{"good", ocsp.Good, false},
+		{"revoked", ocsp.Revoked, false},
+		{"unknown", ocsp.Unknown, false},
+		{"fail", -1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status = tt.status
+			got, err := client.OCSP(leaf, issuer)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Client.OCSP() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Status != tt.status {
+				t.Errorf("Client.OCSP() Status = %v, want %v", got.Status, tt.status)
+			}
+			if got.SerialNumber.Cmp(leaf.SerialNumber) != 0 {
+				t.Errorf("Client.OCSP() SerialNumber = %v, want %v", got.SerialNumber, leaf.SerialNumber)
+			}
+			if _, err := ocsp.ParseResponseForCert(got.Raw, leaf, issuer); err != nil {
+				t.Errorf("Client.OCSP() Raw is not a valid response: %v", err)
+			}
+		})
+	}
+}
+
+func Test_ocspChecker_VerifyConnection(t *testing.T) {
+	leaf, issuer, signer := mustOCSPCertificates(t)
+	status := ocsp.Good
+	srv := startOCSPTestServer(t, issuer, signer, &status)
+	client, err := NewClient(srv.URL, WithTransport(http.DefaultTransport))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	revokedStaple, err := ocsp.CreateResponse(issuer, issuer, ocsp.Response{
+		Status:       ocsp.Revoked,
+		SerialNumber: leaf.SerialNumber,
+		ThisUpdate:   time.Now().Add(-time.Minute),
+		NextUpdate:   time.Now().Add(time.Hour),
+		RevokedAt:    time.Now().Add(-time.Minute),
+	}, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chains := [][]*x509.Certificate{{leaf, issuer}}
+	tests := []struct {
+		name    string
+		status  int
+		cs      tls.ConnectionState
+		wantErr bool
+	}{
+		{"ok no chains", -1, tls.ConnectionState{}, false},
+		{"ok good", ocsp.Good, tls.ConnectionState{VerifiedChains: chains}, false},
+		{"ok unknown", ocsp.Unknown, tls.ConnectionState{VerifiedChains: chains}, false},
+		{"fail revoked", ocsp.Revoked, tls.ConnectionState{VerifiedChains: chains}, true},
+		{"fail revoked staple", ocsp.Good, tls.ConnectionState{VerifiedChains: chains, OCSPResponse: revokedStaple}, true},
+		{"fail error", -1, tls.ConnectionState{VerifiedChains: chains}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status = tt.status
+			// Use a new checker to avoid cached responses.
+			o := newOCSPChecker(client)
+			if err := o.VerifyConnection(tt.cs); (err != nil) != tt.wantErr {
+				t.Errorf("ocspChecker.VerifyConnection() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_ocspChecker_cache(t *testing.T) {
+	leaf, issuer, signer := mustOCSPCertificates(t)
+	status := ocsp.Good
+	srv := startOCSPTestServer(t, issuer, signer, &status)
+	client, err := NewClient(srv.URL, WithTransport(http.DefaultTransport))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	o := newOCSPChecker(client)
+	cs := tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{leaf, issuer}}}
+	if err := o.VerifyConnection(cs); err != nil {
+		t.Fatalf("ocspChecker.VerifyConnection() error = %v", err)
+	}
+	// The cached response is used until the next update.
+	status = -1
+	if err := o.VerifyConnection(cs); err != nil {
+		t.Errorf("ocspChecker.VerifyConnection() error = %v", err)
+	}
+}
+
+func TestTLSRenewer_stapleCertificate(t *testing.T) {
+	leaf, issuer, _ := mustOCSPCertificates(t)
+	cert := &tls.Certificate{
+		Certificate: [][]byte{leaf.Raw, issuer.Raw},
+		Leaf:        leaf,
+	}
+	nextUpdate := time.Now().Add(time.Hour)
+	okStaple := func(*tls.Certificate) ([]byte, time.Time, error) {
+		return []byte("staple"), nextUpdate, nil
+	}
+	failStaple := func(*tls.Certificate) ([]byte, time.Time, error) {
+		return nil, time.Time{}, errors.New("an error")
+	}
+
+	tests := []struct {
+		name       string
+		fn         OCSPStapleFunc
+		renewed    bool
+		wantStaple []byte
+		wantNext   func(time.Duration) bool
+	}{
+		{"ok", okStaple, false, []byte("staple"), func(d time.Duration) bool { return d > 29*time.Minute && d <= 30*time.Minute }},
+		{"ok renewed", okStaple, true, nil, func(d time.Duration) bool { return d == 0 }},
+		{"fail", failStaple, false, nil, func(d time.Duration) bool { return d == minOCSPRefresh }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewTLSRenewer(cert, nil, WithOCSPStapleFunc(tt.fn))
+			if err != nil {
+				t.Fatalf("NewTLSRenewer() error = %v", err)
+			}
+			if tt.renewed {
+				r.setCertificate(&tls.Certificate{Certificate: cert.Certificate, Leaf: leaf})
+			}
+			got := r.stapleCertificate(cert)
+			if !tt.wantNext(got) {
+				t.Errorf("TLSRenewer.stapleCertificate() = %v", got)
+			}
+			if staple := r.getCertificate().OCSPStaple; string(staple) != string(tt.wantStaple) {
+				t.Errorf("TLSRenewer.stapleCertificate() OCSPStaple = %s, want %s", staple, tt.wantStaple)
+			}
+		})
+	}
+}
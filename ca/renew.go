@@ -14,14 +14,25 @@ import (
 // certificate.
 type RenewFunc func() (*tls.Certificate, error)
 
+// OCSPStapleFunc defines the type of the functions used to get the OCSP
+// response to staple to a tls certificate. It returns the DER representation
+// of the response and the time of the next update.
+type OCSPStapleFunc func(cert *tls.Certificate) ([]byte, time.Time, error)
+
 var minCertDuration = time.Minute
 
+// minOCSPRefresh is the minimum time between two OCSP staple refreshes, it's
+// also used as the retry interval if the OCSP response cannot be retrieved.
+var minOCSPRefresh = time.Minute
+
 // TLSRenewer automatically renews a tls certificate using a RenewFunc.
 type TLSRenewer struct {
 	sync.RWMutex
 	RenewCertificate RenewFunc
+	StapleOCSP       OCSPStapleFunc
 	cert             *tls.Certificate
 	timer            *time.Timer
+	ocspTimer        *time.Timer
 	renewBefore      time.Duration
 	renewJitter      time.Duration
 	certNotAfter     time.Time
@@ -45,6 +56,15 @@ func WithRenewJitter(j time.Duration) func(r *TLSRenewer) error {
 	}
 }
 
+// WithOCSPStapleFunc modifies a tlsRenewer by setting the function used to get
+// the OCSP response stapled to the certificate.
+func WithOCSPStapleFunc(fn OCSPStapleFunc) func(r *TLSRenewer) error {
+	return func(r *TLSRenewer) error {
+		r.StapleOCSP = fn
+		return nil
+	}
+}
+
 // NewTLSRenewer creates a TLSRenewer for the given cert. It will use the given
 // RenewFunc to get a new certificate when required.
 func NewTLSRenewer(cert *tls.Certificate, fn RenewFunc, opts ...tlsRenewerOptions) (*TLSRenewer, error) {
@@ -77,12 +97,17 @@ func NewTLSRenewer(cert *tls.Certificate, fn RenewFunc, opts ...tlsRenewerOption
 	return r, nil
 }
 
-// Run starts the certificate renewer for the given certificate.
+// Run starts the certificate renewer for the given certificate. If an
+// OCSPStapleFunc is set, it will also start stapling OCSP responses to the
+// certificate.
 func (r *TLSRenewer) Run() {
 	cert := r.getCertificate()
 	next := r.nextRenewDuration(cert.Leaf.NotAfter)
 	r.Lock()
 	r.timer = time.AfterFunc(next, r.renewCertificate)
+	if r.StapleOCSP != nil {
+		r.ocspTimer = time.AfterFunc(0, r.refreshOCSPStaple)
+	}
 	r.Unlock()
 }
 
@@ -97,6 +122,9 @@ func (r *TLSRenewer) RunContext(ctx context.Context) {
 
 // Stop prevents the renew timer from firing.
 func (r *TLSRenewer) Stop() bool {
+	if r.ocspTimer != nil {
+		r.ocspTimer.Stop()
+	}
 	if r.timer != nil {
 		return r.timer.Stop()
 	}
@@ -177,9 +205,49 @@ func (r *TLSRenewer) renewCertificate() {
 	}
 	r.Lock()
 	r.timer.Reset(next)
+	// Staple an OCSP response to the new certificate as soon as possible.
+	if err == nil && r.ocspTimer != nil {
+		r.ocspTimer.Reset(0)
+	}
 	r.Unlock()
 }
 
+// refreshOCSPStaple staples a new OCSP response to the current certificate and
+// schedules the next refresh.
+func (r *TLSRenewer) refreshOCSPStaple() {
+	next := r.stapleCertificate(r.getCertificate())
+	r.Lock()
+	r.ocspTimer.Reset(next)
+	r.Unlock()
+}
+
+// stapleCertificate gets a new OCSP response for the given certificate and
+// staples it. It returns the time to wait until the next refresh, half of the
+// remaining validity of the response.
+func (r *TLSRenewer) stapleCertificate(cert *tls.Certificate) time.Duration {
+	staple, nextUpdate, err := r.StapleOCSP(cert)
+	if err != nil {
+		return minOCSPRefresh
+	}
+
+	r.Lock()
+	// Refresh immediately if the certificate has been renewed in the meantime.
+	if r.cert != cert {
+		r.Unlock()
+		return 0
+	}
+	stapled := *cert
+	stapled.OCSPStaple = staple
+	r.cert = &stapled
+	r.Unlock()
+
+	next := time.Until(nextUpdate) / 2
+	if next < minOCSPRefresh {
+		next = minOCSPRefresh
+	}
+	return next
+}
+
 func (r *TLSRenewer) nextRenewDuration(notAfter time.Time) time.Duration {
 	d := time.Until(notAfter) - r.renewBefore
 	n := rand.Int63n(int64(r.renewJitter))
@@ -151,6 +151,11 @@ func (c *Client) GetServerTLSConfig(ctx context.Context, sign *api.SignResponse,
 	// tls.Config instead of the default one.
 	tlsConfig.GetConfigForClient = c.buildGetConfigForClient(tlsCtx)
 
+	// Staple OCSP responses if requested
+	if tlsCtx.stapleOCSP {
+		renewer.StapleOCSP = getOCSPStapleFunc(c)
+	}
+
 	// Update renew function with transport
 	tr := getDefaultTransport(tlsConfig)
 	// Use mutable tls.Config on renew
@@ -19,6 +19,7 @@ type TLSOptionCtx struct {
 	mutableConfig *mutableTLSConfig
 	hasRootCA     bool
 	hasClientCA   bool
+	stapleOCSP    bool
 }
 
 // newTLSOptionCtx creates the TLSOption context.
@@ -107,6 +108,29 @@ func VerifyClientCertIfGiven() TLSOption {
 	}
 }
 
+// StapleOCSPResponse is a tls.Config option used on servers to staple the OCSP
+// response of the server certificate. The response is retrieved from the CA
+// and it's refreshed periodically and on every renewal. The CA must have the
+// OCSP responder enabled. This option has no effect on clients.
+func StapleOCSPResponse() TLSOption {
+	return func(ctx *TLSOptionCtx) error {
+		ctx.stapleOCSP = true
+		return nil
+	}
+}
+
+// VerifyPeerRevocation is a tls.Config option used on clients and servers to
+// reject peers with a revoked certificate. The revocation status of the peer
+// certificate is checked using the stapled OCSP response, if valid, or the
+// OCSP responder of the CA. The connection will fail if the status cannot be
+// retrieved, so the CA must have the OCSP responder enabled.
+func VerifyPeerRevocation() TLSOption {
+	return func(ctx *TLSOptionCtx) error {
+		ctx.Config.VerifyConnection = newOCSPChecker(ctx.Client).VerifyConnection
+		return nil
+	}
+}
+
 // AddRootCA adds to the tls.Config RootCAs the given certificate. RootCAs
 // defines the set of root certificate authorities that clients use when
 // verifying server certificates.
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/smallstep/certificates/kms/apiv1"
+	"golang.org/x/crypto/ocsp"
 )
 
 // CertificateAuthorityType indicates the type of Certificate Authority to
@@ -140,3 +141,27 @@ type CreateCertificateAuthorityResponse struct {
 	PrivateKey       crypto.PrivateKey
 	Signer           crypto.Signer
 }
+
+// CreateCRLRequest is the request used to create a certificate revocation
+// list signed by the certificate authority.
+type CreateCRLRequest struct {
+	RevocationList *x509.RevocationList
+}
+
+// CreateCRLResponse is the response to a create CRL request and contains the
+// DER encoded certificate revocation list.
+type CreateCRLResponse struct {
+	CRL []byte
+}
+
+// CreateOCSPResponseRequest is the request used to sign an OCSP response
+// using the key of the certificate authority.
+type CreateOCSPResponseRequest struct {
+	Template ocsp.Response
+}
+
+// CreateOCSPResponseResponse is the response to a create OCSP response request
+// and contains the DER encoded OCSP response.
+type CreateOCSPResponseResponse struct {
+	Response []byte
+}
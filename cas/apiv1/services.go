@@ -27,6 +27,20 @@ type CertificateAuthorityCreator interface {
 	CreateCertificateAuthority(req *CreateCertificateAuthorityRequest) (*CreateCertificateAuthorityResponse, error)
 }
 
+// CertificateAuthorityCRLGenerator is an optional interface implemented by a
+// CertificateAuthorityService that has a method to create a certificate
+// revocation list.
+type CertificateAuthorityCRLGenerator interface {
+	CreateCRL(req *CreateCRLRequest) (*CreateCRLResponse, error)
+}
+
+// CertificateAuthorityOCSPSigner is an optional interface implemented by a
+// CertificateAuthorityService that can sign OCSP responses using the key of the
+// issuing certificate authority.
+type CertificateAuthorityOCSPSigner interface {
+	CreateOCSPResponse(req *CreateOCSPResponseRequest) (*CreateOCSPResponseResponse, error)
+}
+
 // SignatureAlgorithmGetter is an optional implementation in a crypto.Signer
 // that returns the SignatureAlgorithm to use.
 type SignatureAlgorithmGetter interface {
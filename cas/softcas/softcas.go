@@ -3,6 +3,7 @@ package softcas
 import (
 	"context"
 	"crypto"
+	"crypto/rand"
 	"crypto/x509"
 	"time"
 
@@ -11,6 +12,7 @@ import (
 	"github.com/smallstep/certificates/kms"
 	kmsapi "github.com/smallstep/certificates/kms/apiv1"
 	"go.step.sm/crypto/x509util"
+	"golang.org/x/crypto/ocsp"
 )
 
 func init() {
@@ -114,6 +116,44 @@ func (c *SoftCAS) RevokeCertificate(req *apiv1.RevokeCertificateRequest) (*apiv1
 	}, nil
 }
 
+// CreateCRL signs a new certificate revocation list using Golang or KMS
+// crypto. The issuer of the CRL will be the first certificate in the chain.
+func (c *SoftCAS) CreateCRL(req *apiv1.CreateCRLRequest) (*apiv1.CreateCRLResponse, error) {
+	if req.RevocationList == nil {
+		return nil, errors.New("createCRLRequest `revocationList` cannot be nil")
+	}
+
+	// Signers can specify the signature algorithm.
+	if req.RevocationList.SignatureAlgorithm == 0 {
+		if sa, ok := c.Signer.(apiv1.SignatureAlgorithmGetter); ok {
+			req.RevocationList.SignatureAlgorithm = sa.SignatureAlgorithm()
+		}
+	}
+
+	der, err := x509.CreateRevocationList(rand.Reader, req.RevocationList, c.CertificateChain[0], c.Signer)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating certificate revocation list")
+	}
+
+	return &apiv1.CreateCRLResponse{
+		CRL: der,
+	}, nil
+}
+
+// CreateOCSPResponse signs an OCSP response using Golang or KMS crypto. The
+// response is signed directly by the first certificate in the chain.
+func (c *SoftCAS) CreateOCSPResponse(req *apiv1.CreateOCSPResponseRequest) (*apiv1.CreateOCSPResponseResponse, error) {
+	issuer := c.CertificateChain[0]
+	resp, err := ocsp.CreateResponse(issuer, issuer, req.Template, c.Signer)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating ocsp response")
+	}
+
+	return &apiv1.CreateOCSPResponseResponse{
+		Response: resp,
+	}, nil
+}
+
 // CreateCertificateAuthority creates a root or an intermediate certificate.
 func (c *SoftCAS) CreateCertificateAuthority(req *apiv1.CreateCertificateAuthorityRequest) (*apiv1.CreateCertificateAuthorityResponse, error) {
 	switch {
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"context"
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/x509"
 	"crypto/x509/pkix"
@@ -20,6 +22,7 @@ import (
 	kmsapi "github.com/smallstep/certificates/kms/apiv1"
 	"go.step.sm/crypto/pemutil"
 	"go.step.sm/crypto/x509util"
+	"golang.org/x/crypto/ocsp"
 )
 
 var (
@@ -447,6 +450,133 @@ func TestSoftCAS_RevokeCertificate(t *testing.T) {
 	}
 }
 
+func TestSoftCAS_CreateCRL(t *testing.T) {
+	revocationList := &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: testNow,
+		NextUpdate: testNow.Add(24 * time.Hour),
+		RevokedCertificates: []pkix.RevokedCertificate{
+			{SerialNumber: big.NewInt(1234), RevocationTime: testNow},
+		},
+	}
+	type fields struct {
+		Issuer *x509.Certificate
+		Signer crypto.Signer
+	}
+	type args struct {
+		req *apiv1.CreateCRLRequest
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		wantErr bool
+	}{
+		{"ok", fields{testIssuer, testSigner}, args{&apiv1.CreateCRLRequest{
+			RevocationList: revocationList,
+		}}, false},
+		{"fail revocationList", fields{testIssuer, testSigner}, args{&apiv1.CreateCRLRequest{}}, true},
+		{"fail signer", fields{testIssuer, &badSigner{}}, args{&apiv1.CreateCRLRequest{
+			RevocationList: revocationList,
+		}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &SoftCAS{
+				CertificateChain: []*x509.Certificate{tt.fields.Issuer},
+				Signer:           tt.fields.Signer,
+			}
+			got, err := c.CreateCRL(tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SoftCAS.CreateCRL() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			crl, err := x509.ParseCRL(got.CRL)
+			if err != nil {
+				t.Errorf("x509.ParseCRL() error = %v", err)
+				return
+			}
+			if err := tt.fields.Issuer.CheckCRLSignature(crl); err != nil {
+				t.Errorf("x509.CheckCRLSignature() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestSoftCAS_CreateOCSPResponse(t *testing.T) {
+	// Ed25519 keys are not supported by the ocsp package.
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := *testIntermediateTemplate
+	tmpl.PublicKey = key.Public()
+	tmpl.NotBefore = testNow
+	tmpl.NotAfter = testNow.Add(24 * time.Hour)
+	issuer, err := x509util.CreateCertificate(&tmpl, &tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type fields struct {
+		Issuer *x509.Certificate
+		Signer crypto.Signer
+	}
+	type args struct {
+		req *apiv1.CreateOCSPResponseRequest
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		wantErr bool
+	}{
+		{"ok", fields{issuer, key}, args{&apiv1.CreateOCSPResponseRequest{
+			Template: ocsp.Response{
+				Status:       ocsp.Good,
+				SerialNumber: big.NewInt(1234),
+				ThisUpdate:   testNow,
+				NextUpdate:   testNow.Add(time.Hour),
+			},
+		}}, false},
+		{"fail signer", fields{issuer, &badSigner{}}, args{&apiv1.CreateOCSPResponseRequest{
+			Template: ocsp.Response{
+				Status:       ocsp.Good,
+				SerialNumber: big.NewInt(1234),
+				ThisUpdate:   testNow,
+				NextUpdate:   testNow.Add(time.Hour),
+			},
+		}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &SoftCAS{
+				CertificateChain: []*x509.Certificate{tt.fields.Issuer},
+				Signer:           tt.fields.Signer,
+			}
+			got, err := c.CreateOCSPResponse(tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SoftCAS.CreateOCSPResponse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			resp, err := ocsp.ParseResponse(got.Response, tt.fields.Issuer)
+			if err != nil {
+				t.Errorf("ocsp.ParseResponse() error = %v", err)
+				return
+			}
+			if resp.Status != ocsp.Good || resp.SerialNumber.Cmp(big.NewInt(1234)) != 0 {
+				t.Errorf("SoftCAS.CreateOCSPResponse() = %v, want good response for serial 1234", resp)
+			}
+		})
+	}
+}
+
 func Test_now(t *testing.T) {
 	t0 := time.Now()
 	t1 := now()
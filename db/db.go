@@ -14,20 +14,28 @@ import (
 )
 
 var (
-	certsTable             = []byte("x509_certs")
-	revokedCertsTable      = []byte("revoked_x509_certs")
-	revokedSSHCertsTable   = []byte("revoked_ssh_certs")
-	usedOTTTable           = []byte("used_ott")
-	sshCertsTable          = []byte("ssh_certs")
-	sshHostsTable          = []byte("ssh_hosts")
-	sshUsersTable          = []byte("ssh_users")
-	sshHostPrincipalsTable = []byte("ssh_host_principals")
+	certsTable              = []byte("x509_certs")
+	revokedCertsTable       = []byte("revoked_x509_certs")
+	revokedSSHCertsTable    = []byte("revoked_ssh_certs")
+	usedOTTTable            = []byte("used_ott")
+	sshCertsTable           = []byte("ssh_certs")
+	sshHostsTable           = []byte("ssh_hosts")
+	sshUsersTable           = []byte("ssh_users")
+	sshHostPrincipalsTable  = []byte("ssh_host_principals")
+	crlTable                = []byte("x509_crl")
+	provisionerSecretsTable = []byte("provisioner_secrets")
 )
 
+// crlKey is the key used to store the current CRL in the crlTable.
+var crlKey = []byte("crl")
+
 // ErrAlreadyExists can be returned if the DB attempts to set a key that has
 // been previously set.
 var ErrAlreadyExists = errors.New("already exists")
 
+// ErrNotFound can be returned if the DB does not contain the requested key.
+var ErrNotFound = errors.New("not found")
+
 // Config represents the JSON attributes used for configuring a step-ca DB.
 type Config struct {
 	Type       string `json:"type"`
@@ -56,6 +64,22 @@ type AuthDB interface {
 	Shutdown() error
 }
 
+// CertificateRevocationListDB is an optional interface implemented by an
+// AuthDB that can list the revoked certificates and store the generated
+// certificate revocation list.
+type CertificateRevocationListDB interface {
+	GetRevokedCertificates() ([]RevokedCertificateInfo, error)
+	GetCRL() (*CertificateRevocationListInfo, error)
+	StoreCRL(*CertificateRevocationListInfo) error
+}
+
+// ProvisionerSecretDB is an optional interface implemented by an AuthDB that
+// can store the secrets generated by the provisioners, so they are kept across
+// restarts and shared by all the instances of the CA.
+type ProvisionerSecretDB interface {
+	LoadOrStoreProvisionerSecret(id string, secret []byte) ([]byte, error)
+}
+
 // DB is a wrapper over the nosql.DB interface.
 type DB struct {
 	nosql.DB
@@ -82,7 +106,7 @@ func New(c *Config) (AuthDB, error) {
 	tables := [][]byte{
 		revokedCertsTable, certsTable, usedOTTTable,
 		sshCertsTable, sshHostsTable, sshHostPrincipalsTable, sshUsersTable,
-		revokedSSHCertsTable,
+		revokedSSHCertsTable, crlTable, provisionerSecretsTable,
 	}
 	for _, b := range tables {
 		if err := db.CreateTable(b); err != nil {
@@ -104,6 +128,16 @@ type RevokedCertificateInfo struct {
 	RevokedAt     time.Time
 	TokenID       string
 	MTLS          bool
+	ACME          bool
+	ExpiresAt     time.Time
+}
+
+// CertificateRevocationListInfo contains the last generated certificate
+// revocation list and the information required to generate the next one.
+type CertificateRevocationListInfo struct {
+	Number    int64
+	ExpiresAt time.Time
+	DER       []byte
 }
 
 // IsRevoked returns whether or not a certificate with the given identifier
@@ -209,6 +243,83 @@ func (db *DB) StoreCertificate(crt *x509.Certificate) error {
 	return nil
 }
 
+// GetRevokedCertificate returns the revocation information of the certificate
+// with the given serial number. It returns ErrNotFound if the certificate has
+// not been revoked.
+func (db *DB) GetRevokedCertificate(serialNumber string) (*RevokedCertificateInfo, error) {
+	b, err := db.Get(revokedCertsTable, []byte(serialNumber))
+	if err != nil {
+		if nosql.IsErrNotFound(err) {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "database Get error")
+	}
+	var rci RevokedCertificateInfo
+	if err := json.Unmarshal(b, &rci); err != nil {
+		return nil, errors.Wrapf(err, "error unmarshaling revoked certificate %s", serialNumber)
+	}
+	return &rci, nil
+}
+
+// GetRevokedCertificates returns a list of all the revoked certificates.
+func (db *DB) GetRevokedCertificates() ([]RevokedCertificateInfo, error) {
+	entries, err := db.List(revokedCertsTable)
+	if err != nil {
+		return nil, errors.Wrap(err, "database List error")
+	}
+	revokedCerts := make([]RevokedCertificateInfo, 0, len(entries))
+	for _, e := range entries {
+		var rci RevokedCertificateInfo
+		if err := json.Unmarshal(e.Value, &rci); err != nil {
+			return nil, errors.Wrapf(err, "error unmarshaling revoked certificate %s", string(e.Key))
+		}
+		revokedCerts = append(revokedCerts, rci)
+	}
+	return revokedCerts, nil
+}
+
+// GetCRL returns the last certificate revocation list stored. It returns
+// ErrNotFound if no CRL has been stored yet.
+func (db *DB) GetCRL() (*CertificateRevocationListInfo, error) {
+	b, err := db.Get(crlTable, crlKey)
+	if err != nil {
+		if nosql.IsErrNotFound(err) {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "database Get error")
+	}
+	var crlInfo CertificateRevocationListInfo
+	if err := json.Unmarshal(b, &crlInfo); err != nil {
+		return nil, errors.Wrap(err, "error unmarshaling certificate revocation list")
+	}
+	return &crlInfo, nil
+}
+
+// StoreCRL stores the given certificate revocation list.
+func (db *DB) StoreCRL(crlInfo *CertificateRevocationListInfo) error {
+	b, err := json.Marshal(crlInfo)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling certificate revocation list")
+	}
+	if err := db.Set(crlTable, crlKey, b); err != nil {
+		return errors.Wrap(err, "database Set error")
+	}
+	return nil
+}
+
+// LoadOrStoreProvisionerSecret returns the secret stored with the given id. If
+// there is no secret it stores and returns the given one.
+func (db *DB) LoadOrStoreProvisionerSecret(id string, secret []byte) ([]byte, error) {
+	current, swapped, err := db.CmpAndSwap(provisionerSecretsTable, []byte(id), nil, secret)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error storing provisioner secret %s", id)
+	}
+	if swapped {
+		return secret, nil
+	}
+	return current, nil
+}
+
 // UseToken returns true if we were able to successfully store the token for
 // for the first time, false otherwise.
 func (db *DB) UseToken(id, tok string) (bool, error) {
@@ -296,19 +407,24 @@ func (db *DB) Shutdown() error {
 
 // MockAuthDB mocks the AuthDB interface. //
 type MockAuthDB struct {
-	Err                   error
-	Ret1                  interface{}
-	MIsRevoked            func(string) (bool, error)
-	MIsSSHRevoked         func(string) (bool, error)
-	MRevoke               func(rci *RevokedCertificateInfo) error
-	MRevokeSSH            func(rci *RevokedCertificateInfo) error
-	MGetCertificate       func(serialNumber string) (*x509.Certificate, error)
-	MStoreCertificate     func(crt *x509.Certificate) error
-	MUseToken             func(id, tok string) (bool, error)
-	MIsSSHHost            func(principal string) (bool, error)
-	MStoreSSHCertificate  func(crt *ssh.Certificate) error
-	MGetSSHHostPrincipals func() ([]string, error)
-	MShutdown             func() error
+	Err                           error
+	Ret1                          interface{}
+	MIsRevoked                    func(string) (bool, error)
+	MIsSSHRevoked                 func(string) (bool, error)
+	MRevoke                       func(rci *RevokedCertificateInfo) error
+	MRevokeSSH                    func(rci *RevokedCertificateInfo) error
+	MGetCertificate               func(serialNumber string) (*x509.Certificate, error)
+	MStoreCertificate             func(crt *x509.Certificate) error
+	MUseToken                     func(id, tok string) (bool, error)
+	MIsSSHHost                    func(principal string) (bool, error)
+	MStoreSSHCertificate          func(crt *ssh.Certificate) error
+	MGetSSHHostPrincipals         func() ([]string, error)
+	MShutdown                     func() error
+	MGetRevokedCert               func(serialNumber string) (*RevokedCertificateInfo, error)
+	MGetRevokedCerts              func() ([]RevokedCertificateInfo, error)
+	MGetCRL                       func() (*CertificateRevocationListInfo, error)
+	MStoreCRL                     func(*CertificateRevocationListInfo) error
+	MLoadOrStoreProvisionerSecret func(id string, secret []byte) ([]byte, error)
 }
 
 // IsRevoked mock.
@@ -394,6 +510,49 @@ func (m *MockAuthDB) GetSSHHostPrincipals() ([]string, error) {
 	return m.Ret1.([]string), m.Err
 }
 
+// GetRevokedCertificate mock.
+func (m *MockAuthDB) GetRevokedCertificate(serialNumber string) (*RevokedCertificateInfo, error) {
+	if m.MGetRevokedCert != nil {
+		return m.MGetRevokedCert(serialNumber)
+	}
+	return m.Ret1.(*RevokedCertificateInfo), m.Err
+}
+
+// GetRevokedCertificates mock.
+func (m *MockAuthDB) GetRevokedCertificates() ([]RevokedCertificateInfo, error) {
+	if m.MGetRevokedCerts != nil {
+		return m.MGetRevokedCerts()
+	}
+	return m.Ret1.([]RevokedCertificateInfo), m.Err
+}
+
+// GetCRL mock.
+func (m *MockAuthDB) GetCRL() (*CertificateRevocationListInfo, error) {
+	if m.MGetCRL != nil {
+		return m.MGetCRL()
+	}
+	return m.Ret1.(*CertificateRevocationListInfo), m.Err
+}
+
+// StoreCRL mock.
+func (m *MockAuthDB) StoreCRL(crlInfo *CertificateRevocationListInfo) error {
+	if m.MStoreCRL != nil {
+		return m.MStoreCRL(crlInfo)
+	}
+	return m.Err
+}
+
+// LoadOrStoreProvisionerSecret mock.
+func (m *MockAuthDB) LoadOrStoreProvisionerSecret(id string, secret []byte) ([]byte, error) {
+	if m.MLoadOrStoreProvisionerSecret != nil {
+		return m.MLoadOrStoreProvisionerSecret(id, secret)
+	}
+	if m.Ret1 == nil {
+		return secret, m.Err
+	}
+	return m.Ret1.([]byte), m.Err
+}
+
 // Shutdown mock.
 func (m *MockAuthDB) Shutdown() error {
 	if m.MShutdown != nil {
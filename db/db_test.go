@@ -158,3 +158,52 @@ func TestUseToken(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadOrStoreProvisionerSecret(t *testing.T) {
+	tests := map[string]struct {
+		db   *DB
+		want []byte
+		err  error
+	}{
+		"fail/force-CmpAndSwap-error": {
+			db: &DB{&MockNoSQLDB{
+				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					return nil, false, errors.New("force")
+				},
+			}, true},
+			err: errors.New("error storing provisioner secret tpm/tpm: force"),
+		},
+		"ok/stored": {
+			db: &DB{&MockNoSQLDB{
+				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					assert.Equals(t, provisionerSecretsTable, bucket)
+					assert.Equals(t, []byte("tpm/tpm"), key)
+					assert.Nil(t, old)
+					return newval, true, nil
+				},
+			}, true},
+			want: []byte("secret"),
+		},
+		"ok/loaded": {
+			db: &DB{&MockNoSQLDB{
+				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					return []byte("current"), false, nil
+				},
+			}, true},
+			want: []byte("current"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.LoadOrStoreProvisionerSecret("tpm/tpm", []byte("secret"))
+			if tc.err != nil {
+				if assert.Error(t, err) {
+					assert.Equals(t, tc.err.Error(), err.Error())
+				}
+				return
+			}
+			assert.FatalError(t, err)
+			assert.Equals(t, tc.want, got)
+		})
+	}
+}
@@ -276,7 +276,7 @@ func (a *Authority) SignCSR(ctx context.Context, csr *x509.CertificateRequest, m
 	if err != nil {
 		return nil, errors.Wrap(err, "error creating template options from SCEP provisioner")
 	}
-	signOps = append(signOps, templateOptions)
+	signOps = append(signOps, templateOptions, p.WebhookOption(data))
 
 	certChain, err := a.signAuth.Sign(csr, opts, signOps...)
 	if err != nil {
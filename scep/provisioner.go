@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/smallstep/certificates/authority/provisioner"
+	"go.step.sm/crypto/x509util"
 )
 
 // Provisioner is an interface that implements a subset of the provisioner.Interface --
@@ -16,4 +17,5 @@ type Provisioner interface {
 	GetOptions() *provisioner.Options
 	GetChallengePassword() string
 	GetCapabilities() []string
+	WebhookOption(data x509util.TemplateData) provisioner.SignOption
 }